@@ -0,0 +1,331 @@
+package admin
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+const (
+	ScopedApiKeyLength = 16
+
+	BearerPrefix = "Bearer "
+)
+
+// principal is the caller of an authenticated request, it is either an admin user or the owner of some pairs
+type principal struct {
+	name string
+	// role of the admin user, empty for the pair owners
+	role Role
+	// id of the admin user in db, zero for the bootstrap api key and the pair owners
+	userID uint
+	// set if the request is authenticated by a token, such requests get no new tokens
+	token bool
+	// erc20 addresses of the pairs owned by the caller
+	pairs map[string]bool
+}
+
+func (p *principal) isPairOwner() bool {
+	return p.role == ""
+}
+
+func (p *principal) can(permission Permission) bool {
+	if p.isPairOwner() {
+		return permission == PermissionManagePairs
+	}
+	return rolePermissions[p.role][permission]
+}
+
+func (p *principal) canManagePair(erc20Addr string) bool {
+	return (!p.isPairOwner() && p.can(PermissionManagePairs)) || p.pairs[common.HexToAddress(erc20Addr).String()]
+}
+
+// bootstrapEnabled returns whether the api key of the key manager is accepted, it only bootstraps the first admin
+// user and is rejected once there is an enabled admin user
+func (admin *Admin) bootstrapEnabled() bool {
+	var count int
+	if err := admin.DB.Model(model.AdminUser{}).Where("role = ? and disabled = ?", RoleAdmin, false).Count(&count).Error; err != nil {
+		util.Logger.Errorf("query admin users error, err=%s", err.Error())
+		return false
+	}
+	return count == 0
+}
+
+func userPrincipal(user *model.AdminUser) (*principal, error) {
+	if user.Disabled {
+		return nil, fmt.Errorf("user %s is disabled", user.Name)
+	}
+	return &principal{name: user.Name, role: Role(user.Role), userID: user.ID}, nil
+}
+
+// authenticateToken verifies the token issued to an admin user, the user is read again so that the tokens of the
+// disabled users are rejected and the role changes take effect at once
+func (admin *Admin) authenticateToken(token string) (*principal, error) {
+	claims, err := admin.jwtSigner.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	user := model.AdminUser{}
+	if err := admin.DB.Where("name = ?", claims.Subject).First(&user).Error; err != nil {
+		return nil, fmt.Errorf("user %s is not found", claims.Subject)
+	}
+	p, err := userPrincipal(&user)
+	if err != nil {
+		return nil, err
+	}
+	p.token = true
+	return p, nil
+}
+
+// authenticate verifies either the bearer token in the Authorization header and the hmac of the request with the
+// secret of the token, or the hmac of the request with the secret of the api key. The api key is the key of an admin
+// user, the bootstrap api key of the key manager or a scoped api key of the pair owners. Both require the timestamp
+// and the nonce, a nonce is accepted once.
+func (admin *Admin) authenticate(r *http.Request) (*principal, []byte, error) {
+	apiKey := r.Header.Get("ApiKey")
+	hash := r.Header.Get("Authorization")
+
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if strings.HasPrefix(hash, BearerPrefix) {
+		// the token replaces the api key, the request is signed with the secret of the token so that the timestamp and
+		// the nonce checked against the replays can't be replaced
+		signed, err := admin.signedPayload(r, payload)
+		if err != nil {
+			return nil, nil, err
+		}
+		token := strings.TrimPrefix(hash, BearerPrefix)
+		p, err := admin.authenticateToken(token)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !util.NewHmacSigner("", admin.jwtSigner.TokenSecret(token)).Verify(signed, r.Header.Get(util.TokenSignatureHeader)) {
+			return nil, nil, fmt.Errorf("invalid %s of the token request", util.TokenSignatureHeader)
+		}
+		if err := admin.useNonce(r, tokenNonceKey(p.name)); err != nil {
+			return nil, nil, err
+		}
+		return p, payload, nil
+	}
+	if apiKey == "" {
+		return nil, nil, fmt.Errorf("api key mismatch")
+	}
+	signed, err := admin.signedPayload(r, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var p *principal
+	user := model.AdminUser{}
+	if err := admin.DB.Where("api_key = ?", apiKey).First(&user).Error; err == nil {
+		if !util.NewHmacSigner(apiKey, user.ApiSecret).Verify(signed, hash) {
+			return nil, nil, fmt.Errorf("invalud auth")
+		}
+		if p, err = userPrincipal(&user); err != nil {
+			return nil, nil, err
+		}
+	} else if admin.hmacSigner.ApiKey == apiKey {
+		if !admin.hmacSigner.Verify(signed, hash) {
+			return nil, nil, fmt.Errorf("invalud auth")
+		}
+		if !admin.bootstrapEnabled() {
+			return nil, nil, fmt.Errorf("bootstrap api key is disabled since there are admin users")
+		}
+		p = &principal{name: "admin", role: RoleAdmin}
+	} else {
+		owners := make([]model.PairOwner, 0)
+		admin.DB.Where("api_key = ?", apiKey).Find(&owners)
+		if len(owners) == 0 {
+			return nil, nil, fmt.Errorf("api key mismatch")
+		}
+		if !util.NewHmacSigner(apiKey, owners[0].ApiSecret).Verify(signed, hash) {
+			return nil, nil, fmt.Errorf("invalud auth")
+		}
+		p = &principal{name: owners[0].Name, pairs: make(map[string]bool)}
+		for _, owner := range owners {
+			p.pairs[owner.ERC20Addr] = true
+		}
+	}
+
+	if err := admin.useNonce(r, apiKey); err != nil {
+		return nil, nil, err
+	}
+	return p, payload, nil
+}
+
+// tokenNonceKey scopes the nonces of the requests authenticated by a token to the admin user the token is issued to
+func tokenNonceKey(subject string) string {
+	return "token:" + subject
+}
+
+// signedPayload returns the payload signed by the hmac of the request. The requests sign their method, uri,
+// timestamp and nonce along with the body, see util.SignRequest, and the timestamp must be within the replay window.
+func (admin *Admin) signedPayload(r *http.Request, body []byte) ([]byte, error) {
+	header := r.Header.Get(util.TimestampHeader)
+	nonce := r.Header.Get(util.NonceHeader)
+	if header == "" || nonce == "" {
+		return nil, fmt.Errorf("%s and %s headers are required by the signed requests", util.TimestampHeader, util.NonceHeader)
+	}
+	timestamp, err := strconv.ParseInt(header, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s header: %s", util.TimestampHeader, header)
+	}
+	window := admin.cfg.AdminConfig.GetReplayWindow()
+	if skew := time.Since(time.Unix(timestamp, 0)); skew > window || skew < -window {
+		return nil, fmt.Errorf("request is signed at %d, out of the replay window of %s", timestamp, window)
+	}
+	return util.SignedRequestPayload(r.Method, r.URL.RequestURI(), timestamp, nonce, body), nil
+}
+
+// useNonce records the nonce of the signed request, the request is rejected if the api key has used the nonce
+// within the replay window. The nonces out of the window are pruned, their requests are rejected by the timestamp.
+func (admin *Admin) useNonce(r *http.Request, apiKey string) error {
+	nonce := r.Header.Get(util.NonceHeader)
+	timestamp, _ := strconv.ParseInt(r.Header.Get(util.TimestampHeader), 10, 64)
+	window := admin.cfg.AdminConfig.GetReplayWindow()
+	if err := admin.DB.Where("signed_at < ?", time.Now().Add(-window).Unix()).Delete(model.AdminRequestNonce{}).Error; err != nil {
+		util.Logger.Errorf("prune admin request nonces error, err=%s", err.Error())
+	}
+
+	used := 0
+	if err := admin.DB.Model(model.AdminRequestNonce{}).Where("api_key = ? and nonce = ?", apiKey, nonce).Count(&used).Error; err != nil {
+		return err
+	}
+	if used == 0 {
+		// the unique index rejects the replay racing the first request on another replica
+		err := admin.DB.Create(&model.AdminRequestNonce{ApiKey: apiKey, Nonce: nonce, SignedAt: timestamp}).Error
+		if err == nil {
+			return nil
+		}
+		admin.DB.Model(model.AdminRequestNonce{}).Where("api_key = ? and nonce = ?", apiKey, nonce).Count(&used)
+		if used == 0 {
+			return err
+		}
+	}
+	util.Logger.Errorf("replayed %s %s is rejected, nonce %s of api key %s is used", r.Method, r.URL.Path, nonce, apiKey)
+	return fmt.Errorf("nonce %s is used, the request is replayed", nonce)
+}
+
+// checkAuth returns the body of the request authorized by withPermission with the permission of the route
+func (admin *Admin) checkAuth(r *http.Request) ([]byte, error) {
+	authorized, err := getAuthorizedRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return authorized.payload, nil
+}
+
+func newScopedApiKey() (string, error) {
+	bz := make([]byte, ScopedApiKeyLength)
+	if _, err := rand.Read(bz); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bz), nil
+}
+
+// AddPairOwnerHandler grants the pair of the erc20 address to a scoped api key, a new api key and secret are
+// generated if the api key is not provided
+func (admin *Admin) AddPairOwnerHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := admin.checkAuth(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var addPairOwner pairOwnerRequest
+	err = json.Unmarshal(reqBody, &addPairOwner)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !common.IsHexAddress(addPairOwner.ERC20Addr) {
+		http.Error(w, fmt.Sprintf("invalid erc20 address: %s", addPairOwner.ERC20Addr), http.StatusBadRequest)
+		return
+	}
+	erc20Addr := common.HexToAddress(addPairOwner.ERC20Addr).String()
+	if err := admin.DB.Where("erc20_addr = ?", erc20Addr).First(&model.SwapPair{}).Error; err != nil {
+		http.Error(w, fmt.Sprintf("swapPair %s is not found", erc20Addr), http.StatusBadRequest)
+		return
+	}
+
+	owner := model.PairOwner{
+		Name:      addPairOwner.Name,
+		ApiKey:    addPairOwner.ApiKey,
+		ERC20Addr: erc20Addr,
+	}
+	var resp pairOwnerResponse
+	if owner.ApiKey == "" {
+		if owner.Name == "" {
+			http.Error(w, "name should not be empty", http.StatusBadRequest)
+			return
+		}
+		if owner.ApiKey, err = newScopedApiKey(); err == nil {
+			owner.ApiSecret, err = newScopedApiKey()
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// the secret is only returned once
+		resp.ApiSecret = owner.ApiSecret
+	} else {
+		existing := model.PairOwner{}
+		if err := admin.DB.Where("api_key = ?", owner.ApiKey).First(&existing).Error; err != nil {
+			http.Error(w, fmt.Sprintf("api key %s is not found", owner.ApiKey), http.StatusBadRequest)
+			return
+		}
+		owner.Name, owner.ApiSecret = existing.Name, existing.ApiSecret
+	}
+
+	if err := admin.DB.Create(&owner).Error; err != nil {
+		http.Error(w, fmt.Sprintf("add pair owner error, err=%s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	util.Logger.Infof("pair %s is granted to %s, api key %s", erc20Addr, owner.Name, owner.ApiKey)
+
+	resp.Name, resp.ApiKey, resp.ERC20Addr = owner.Name, owner.ApiKey, owner.ERC20Addr
+	writeJson(w, http.StatusOK, resp)
+}
+
+// RemovePairOwnerHandler revokes the right of a scoped api key to manage the pair of the erc20 address
+func (admin *Admin) RemovePairOwnerHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := admin.checkAuth(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var removePairOwner pairOwnerRequest
+	err = json.Unmarshal(reqBody, &removePairOwner)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	erc20Addr := common.HexToAddress(removePairOwner.ERC20Addr).String()
+	res := admin.DB.Unscoped().Where("api_key = ? and erc20_addr = ?", removePairOwner.ApiKey, erc20Addr).Delete(model.PairOwner{})
+	if res.Error != nil {
+		http.Error(w, fmt.Sprintf("remove pair owner error, err=%s", res.Error.Error()), http.StatusInternalServerError)
+		return
+	}
+	if res.RowsAffected == 0 {
+		http.Error(w, "pair owner is not found", http.StatusBadRequest)
+		return
+	}
+	util.Logger.Infof("pair %s is revoked from api key %s", erc20Addr, removePairOwner.ApiKey)
+	writeJson(w, http.StatusOK, removePairOwner)
+}
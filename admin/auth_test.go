@@ -0,0 +1,214 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"occ-swap-server/common"
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+// newTestAdmin returns an admin on a sqlite db in memory with an enabled admin user
+func newTestAdmin(t *testing.T) (*Admin, *model.AdminUser) {
+	db, err := model.OpenDB(common.DBDialectSqlite3, model.SqliteInMemoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	user := &model.AdminUser{Name: "alice", Role: string(RoleAdmin), ApiKey: "alice-key", ApiSecret: "alice-secret"}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return NewAdmin(&util.Config{}, db, util.NewHmacSigner("bootstrap-key", "bootstrap-secret"), nil, nil), user
+}
+
+func TestReplayedBearerRequest(t *testing.T) {
+	admin, user := newTestAdmin(t)
+	token, err := admin.jwtSigner.Sign(util.JWTClaims{Subject: user.Name, Role: user.Role, IssuedAt: time.Now().Unix(),
+		ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := admin.withPermission(apiRoute{Method: http.MethodPost, Path: "/withdraw_token", Permission: PermissionManage},
+		func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/withdraw_token", strings.NewReader(`{"amount":"1"}`))
+		req.Header.Set("Authorization", BearerPrefix+token)
+		return req
+	}
+	send := func(req *http.Request) int {
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code
+	}
+
+	// the token alone is not enough
+	if code := send(newRequest()); code != http.StatusUnauthorized {
+		t.Errorf("request without a nonce got %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	// nor the token with a nonce but without the signature of the token secret
+	unsigned := newRequest()
+	if _, _, err := util.SetRequestNonce(unsigned); err != nil {
+		t.Fatal(err)
+	}
+	if code := send(unsigned); code != http.StatusUnauthorized {
+		t.Errorf("request without the token signature got %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	req := newRequest()
+	if err := util.SignTokenRequest(req, token, admin.jwtSigner.TokenSecret(token), []byte(`{"amount":"1"}`)); err != nil {
+		t.Fatal(err)
+	}
+	replayed := newRequest()
+	replayed.Header = req.Header.Clone()
+	renonced := newRequest()
+	renonced.Header = req.Header.Clone()
+	if _, _, err := util.SetRequestNonce(renonced); err != nil {
+		t.Fatal(err)
+	}
+	if code := send(req); code != http.StatusOK {
+		t.Fatalf("request got %d, want %d", code, http.StatusOK)
+	}
+	if code := send(replayed); code != http.StatusUnauthorized {
+		t.Errorf("replayed request got %d, want %d", code, http.StatusUnauthorized)
+	}
+	// the captured request with a fresh nonce doesn't match its signature
+	if code := send(renonced); code != http.StatusUnauthorized {
+		t.Errorf("replayed request with a fresh nonce got %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	stale := newRequest()
+	util.SignTokenRequest(stale, token, admin.jwtSigner.TokenSecret(token), []byte(`{"amount":"1"}`))
+	stale.Header.Set(util.TimestampHeader, "1")
+	if code := send(stale); code != http.StatusUnauthorized {
+		t.Errorf("request out of the replay window got %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestPermissionRoutesOnSecureListenerOnly(t *testing.T) {
+	admin, _ := newTestAdmin(t)
+	serve := func(router http.Handler) int {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/withdraw_token", strings.NewReader(`{"amount":"1"}`)))
+		return rec.Code
+	}
+
+	router, secureRouter, err := admin.newRouters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secureRouter != nil {
+		t.Fatal("secure router without secure_listen_addr")
+	}
+	if code := serve(router); code != http.StatusNotFound {
+		t.Errorf("endpoint with a permission on the public listener got %d, want %d", code, http.StatusNotFound)
+	}
+
+	admin.cfg.AdminConfig = util.AdminConfig{ListenAddr: ":8001", SecureListenAddr: ":8443", AllowedIPs: []string{"10.0.0.0/8"}}
+	router, secureRouter, err = admin.newRouters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := serve(router); code != http.StatusNotFound {
+		t.Errorf("endpoint with a permission on the public listener got %d, want %d", code, http.StatusNotFound)
+	}
+	// the remote ip of the test requests is 192.0.2.1
+	if code := serve(secureRouter); code != http.StatusForbidden {
+		t.Errorf("request from an ip out of the allowlist got %d, want %d", code, http.StatusForbidden)
+	}
+}
+
+func TestEmptyAllowlist(t *testing.T) {
+	handler := withAllowedIPs(nil, func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/pending_swaps", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("request with an empty allowlist got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	cfg := util.AdminConfig{ListenAddr: ":8001", SecureListenAddr: ":8443", TLSCertFile: "server.crt", TLSKeyFile: "server.key",
+		ClientCAFile: "client_ca.crt"}
+	if errs := cfg.Check(); len(errs) != 1 || !strings.Contains(errs[0], "allowed_ips") {
+		t.Errorf("secure listener without allowed_ips got errors %v", errs)
+	}
+}
+
+func TestSponsorChallenge(t *testing.T) {
+	admin, _ := newTestAdmin(t)
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sponsor := crypto.PubkeyToAddress(key.PublicKey)
+	const path, body = "/api/v1/sponsor/webhook", `{"url":"https://example.com/hook"}`
+
+	issue := func() challengeResponse {
+		query := url.Values{"sponsor": {sponsor.String()}, "action": {http.MethodPost + " " + path}, "params": {body}}
+		rec := httptest.NewRecorder()
+		admin.ChallengeHandler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/auth/challenge?"+query.Encode(), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("issue challenge got %d: %s", rec.Code, rec.Body.String())
+		}
+		var issued challengeResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &issued); err != nil {
+			t.Fatal(err)
+		}
+		return issued
+	}
+	newRequest := func(issued challengeResponse, path, body string) *http.Request {
+		sig, err := crypto.Sign(accounts.TextHash([]byte(issued.Message)), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Sponsor", sponsor.String())
+		req.Header.Set("Challenge", issued.Challenge)
+		req.Header.Set("Signature", hexutil.Encode(sig))
+		return req
+	}
+
+	issued := issue()
+	if _, _, err := admin.checkSponsorAuth(newRequest(issued, path, body)); err != nil {
+		t.Fatalf("signed request is rejected: %s", err.Error())
+	}
+	if _, _, err := admin.checkSponsorAuth(newRequest(issued, path, body)); err == nil {
+		t.Error("challenge is used twice")
+	}
+	if _, _, err := admin.checkSponsorAuth(newRequest(issue(), path, `{"url":"https://attacker.example.com/hook"}`)); err == nil {
+		t.Error("challenge signed for other params is accepted")
+	}
+	if _, _, err := admin.checkSponsorAuth(newRequest(issue(), "/api/v1/sponsor/swaps/0x01/cancel", body)); err == nil {
+		t.Error("challenge signed for another action is accepted")
+	}
+}
+
+func TestExportFormats(t *testing.T) {
+	admin, _ := newTestAdmin(t)
+	var export apiRoute
+	for _, route := range admin.routes() {
+		if route.Path == "/export" {
+			export = route
+		}
+	}
+	// only the validation of the params is tested
+	export.Handler = func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	for format, want := range map[string]int{ExportFormatCSV: http.StatusOK, "parquet": http.StatusBadRequest} {
+		rec := httptest.NewRecorder()
+		withValidation(export)(rec, httptest.NewRequest(http.MethodGet, "/export?from=2021-06-01&to=2021-06-30&format="+format, nil))
+		if rec.Code != want {
+			t.Errorf("export as %s got %d, want %d", format, rec.Code, want)
+		}
+	}
+}
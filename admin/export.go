@@ -0,0 +1,234 @@
+package admin
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+const (
+	ExportTypeSwaps   = "swaps"
+	ExportTypeFillTxs = "fill_txs"
+
+	// ExportFormatCSV is the only export format, parquet is out of scope, the csv is converted by the analytics
+	// tools of the finance team
+	ExportFormatCSV = "csv"
+
+	ExportDateLayout = "2006-01-02"
+	ExportBatchSize  = 1000
+)
+
+var (
+	exportTypeParam   = apiParam{Name: "type", In: "query", Type: "string", Pattern: "^(swaps|fill_txs)$", Description: "swaps or fill_txs, swaps by default"}
+	exportFormatParam = apiParam{Name: "format", In: "query", Type: "string", Pattern: "^csv$", Description: "csv, the only format"}
+	exportFromParam   = apiParam{Name: "from", In: "query", Type: "string", Pattern: "^\\d{4}-\\d{2}-\\d{2}$", Required: true, Description: "first day of the range, utc, yyyy-mm-dd"}
+	exportToParam     = apiParam{Name: "to", In: "query", Type: "string", Pattern: "^\\d{4}-\\d{2}-\\d{2}$", Required: true, Description: "last day of the range, utc, yyyy-mm-dd"}
+)
+
+var (
+	exportSwapsHeader = []string{"id", "created_at", "updated_at", "status", "direction", "sponsor", "to_chain_id", "symbol",
+		"amount", "fee_amount", "start_tx_hash", "fill_tx_hash", "memo", "hmac_valid"}
+	exportFillTxsHeader = []string{"id", "created_at", "direction", "start_swap_tx_hash", "fill_swap_tx_hash", "status", "height",
+		"gas_price", "consumed_fee_amount", "revert_reason", "swap_hmac_valid"}
+)
+
+func parseExportRange(r *http.Request) (time.Time, time.Time, error) {
+	from, err := time.Parse(ExportDateLayout, r.URL.Query().Get("from"))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid from: %s", err.Error())
+	}
+	to, err := time.Parse(ExportDateLayout, r.URL.Query().Get("to"))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid to: %s", err.Error())
+	}
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("to should not be before from")
+	}
+	// the last day is included
+	return from, to.AddDate(0, 0, 1), nil
+}
+
+// ExportHandler dumps the swaps or the fill txs created in the date range as csv for accounting. The hmac of every
+// swap is verified again and reported in the export, the tampered swaps are alerted.
+func (admin *Admin) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := admin.checkAuth(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	exportType := r.URL.Query().Get("type")
+	if exportType == "" {
+		exportType = ExportTypeSwaps
+	}
+	from, to, err := parseExportRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fromDay, toDay := from.Format(ExportDateLayout), to.AddDate(0, 0, -1).Format(ExportDateLayout)
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s_%s_%s.csv", exportType, fromDay, toDay))
+	writer := csv.NewWriter(w)
+
+	var invalid int
+	if exportType == ExportTypeFillTxs {
+		invalid, err = admin.exportFillTxs(writer, from, to)
+	} else {
+		invalid, err = admin.exportSwaps(writer, from, to)
+	}
+	writer.Flush()
+	if err == nil {
+		err = writer.Error()
+	}
+	if err != nil {
+		// the header is sent already, the export is truncated
+		util.Logger.Errorf("export %s error, err=%s", exportType, err.Error())
+		return
+	}
+	if invalid > 0 {
+		util.Logger.Errorf("export %s from %s to %s, %d rows failed the hmac verification", exportType, fromDay, toDay, invalid)
+		util.SendTelegramMessage(fmt.Sprintf("Urgent alert: export %s from %s to %s, %d rows failed the hmac verification",
+			exportType, fromDay, toDay, invalid))
+	}
+}
+
+// exportSwaps writes the swaps in batches, it returns the number of the swaps whose hmac is invalid
+func (admin *Admin) exportSwaps(writer *csv.Writer, from, to time.Time) (int, error) {
+	if err := writer.Write(exportSwapsHeader); err != nil {
+		return 0, err
+	}
+	invalid := 0
+	var lastID uint
+	for {
+		swaps := make([]model.Swap, 0)
+		err := admin.DB.Where("created_at >= ? and created_at < ? and id > ?", from, to, lastID).
+			Order("id asc").Limit(ExportBatchSize).Find(&swaps).Error
+		if err != nil {
+			return invalid, err
+		}
+		if len(swaps) == 0 {
+			return invalid, nil
+		}
+
+		startTxHashes := make([]string, 0, len(swaps))
+		for _, swap := range swaps {
+			startTxHashes = append(startTxHashes, swap.StartTxHash)
+		}
+		txEventLogs := make([]model.SwapStartTxLog, 0)
+		if err := admin.DB.Where("tx_hash in (?)", startTxHashes).Find(&txEventLogs).Error; err != nil {
+			return invalid, err
+		}
+		fees := make(map[string]string, len(txEventLogs))
+		for _, txEventLog := range txEventLogs {
+			fees[txEventLog.TxHash] = txEventLog.FeeAmount
+		}
+
+		for _, swap := range swaps {
+			valid := admin.swapEngine.VerifySwap(&swap)
+			if !valid {
+				invalid++
+			}
+			err := writer.Write([]string{
+				strconv.FormatUint(uint64(swap.ID), 10),
+				swap.CreatedAt.UTC().Format(time.RFC3339),
+				swap.UpdatedAt.UTC().Format(time.RFC3339),
+				string(swap.Status),
+				string(swap.Direction),
+				swap.Sponsor,
+				swap.ToChainId,
+				swap.Symbol,
+				swap.Amount,
+				fees[swap.StartTxHash],
+				swap.StartTxHash,
+				swap.FillTxHash,
+				swap.Memo,
+				strconv.FormatBool(valid),
+			})
+			if err != nil {
+				return invalid, err
+			}
+			lastID = swap.ID
+		}
+	}
+}
+
+// exportFillTxs writes the fill txs in batches along with the hmac verification of their swaps, it returns the
+// number of the fill txs whose swap hmac is invalid
+func (admin *Admin) exportFillTxs(writer *csv.Writer, from, to time.Time) (int, error) {
+	if err := writer.Write(exportFillTxsHeader); err != nil {
+		return 0, err
+	}
+	invalid := 0
+	var lastID uint
+	for {
+		swapTxs := make([]model.SwapFillTx, 0)
+		err := admin.DB.Where("created_at >= ? and created_at < ? and id > ?", from, to, lastID).
+			Order("id asc").Limit(ExportBatchSize).Find(&swapTxs).Error
+		if err != nil {
+			return invalid, err
+		}
+		if len(swapTxs) == 0 {
+			return invalid, nil
+		}
+
+		swapIDs := make([]uint, 0, len(swapTxs))
+		for _, swapTx := range swapTxs {
+			swapIDs = append(swapIDs, swapTx.SwapID)
+		}
+		swaps := make([]model.Swap, 0)
+		if err := admin.DB.Where("id in (?)", swapIDs).Find(&swaps).Error; err != nil {
+			return invalid, err
+		}
+		swapValid := make(map[uint]bool, len(swaps))
+		for _, swap := range swaps {
+			swapValid[swap.ID] = admin.swapEngine.VerifySwap(&swap)
+		}
+
+		for _, swapTx := range swapTxs {
+			valid := swapValid[swapTx.SwapID]
+			if !valid {
+				invalid++
+			}
+			err := writer.Write([]string{
+				strconv.FormatUint(uint64(swapTx.ID), 10),
+				swapTx.CreatedAt.UTC().Format(time.RFC3339),
+				string(swapTx.Direction),
+				swapTx.StartSwapTxHash,
+				swapTx.FillSwapTxHash,
+				fillTxStatusName(swapTx.Status),
+				strconv.FormatInt(swapTx.Height, 10),
+				swapTx.GasPrice,
+				swapTx.ConsumedFeeAmount,
+				swapTx.RevertReason,
+				strconv.FormatBool(valid),
+			})
+			if err != nil {
+				return invalid, err
+			}
+			lastID = swapTx.ID
+		}
+	}
+}
+
+func fillTxStatusName(status model.FillTxStatus) string {
+	switch status {
+	case model.FillTxCreated:
+		return "created"
+	case model.FillTxSent:
+		return "sent"
+	case model.FillTxSuccess:
+		return "success"
+	case model.FillTxFailed:
+		return "failed"
+	case model.FillTxMissing:
+		return "missing"
+	default:
+		return strconv.Itoa(int(status))
+	}
+}
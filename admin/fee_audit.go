@@ -0,0 +1,73 @@
+package admin
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"occ-swap-server/swap"
+	"occ-swap-server/util"
+)
+
+var feeAuditHeader = []string{"id", "created_at", "chain", "direction", "sponsor", "start_tx_hash", "charged_fee", "expected_fee",
+	"charged_rebate", "expected_rebate", "tier", "net_fee_diff"}
+
+// FeeAuditHandler re-prices the swaps created in the date range under the current swap fees and sponsor tiers, and
+// dumps the swaps charged otherwise as csv, e.g. to refund the swaps after a mistake in the fee config
+func (admin *Admin) FeeAuditHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := admin.checkAuth(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, to, err := parseExportRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	schedule, err := admin.swapEngine.GetFeeSchedule()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fromDay, toDay := from.Format(ExportDateLayout), to.AddDate(0, 0, -1).Format(ExportDateLayout)
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=fee_audit_%s_%s.csv", fromDay, toDay))
+	writer := csv.NewWriter(w)
+	if err := writer.Write(feeAuditHeader); err != nil {
+		util.Logger.Errorf("fee audit error, err=%s", err.Error())
+		return
+	}
+
+	discrepancies := 0
+	audited, err := admin.swapEngine.AuditSwapFees(schedule, from, to, func(repricing *swap.FeeRepricing) error {
+		discrepancies++
+		return writer.Write([]string{
+			strconv.FormatUint(uint64(repricing.SwapID), 10),
+			repricing.CreatedAt.UTC().Format(time.RFC3339),
+			repricing.Chain,
+			repricing.Direction,
+			repricing.Sponsor,
+			repricing.StartTxHash,
+			repricing.ChargedFee.String(),
+			repricing.ExpectedFee.String(),
+			repricing.ChargedRebate.String(),
+			repricing.ExpectedRebate.String(),
+			repricing.Tier,
+			repricing.NetFeeDiff.String(),
+		})
+	})
+	writer.Flush()
+	if err == nil {
+		err = writer.Error()
+	}
+	if err != nil {
+		// the header is sent already, the report is truncated
+		util.Logger.Errorf("fee audit error, err=%s", err.Error())
+		return
+	}
+	util.Logger.Infof("fee audit from %s to %s, %d of %d swaps were charged other fees than the current fee schedule",
+		fromDay, toDay, discrepancies, audited)
+}
@@ -0,0 +1,80 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+
+	"occ-swap-server/model"
+)
+
+const (
+	DefaultListKeyUsagesLimit = 100
+	MaxListKeyUsagesLimit     = 1000
+)
+
+var keyUsageParams = []apiParam{
+	{Name: "chain", In: "query", Type: "string", Description: "chain of the signed txs"},
+	{Name: "purpose", In: "query", Type: "string", Pattern: "^(fill|retry_fill|approve|rebalance|relay|deploy|nonce_plug|withdraw)$",
+		Description: "what the txs are signed for"},
+	{Name: "account", In: "query", Type: "string", Pattern: "^0x[0-9a-fA-F]{40}$", Description: "relayer account signing the txs"},
+	{Name: "tx_hash", In: "query", Type: "string", Pattern: "^0x[0-9a-fA-F]{64}$", Description: "hash of the signed tx"},
+	{Name: "from", In: "query", Type: "string", Pattern: "^\\d{4}-\\d{2}-\\d{2}$", Description: "first day of the range, utc, yyyy-mm-dd"},
+	{Name: "to", In: "query", Type: "string", Pattern: "^\\d{4}-\\d{2}-\\d{2}$", Description: "last day of the range, utc, yyyy-mm-dd"},
+	{Name: "limit", In: "query", Type: "integer", Description: fmt.Sprintf("max number of signatures, at most %d", MaxListKeyUsagesLimit)},
+}
+
+// KeyUsagesHandler lists the txs signed by the relayer keys, the latest first, for the security audits of the key
+// handling
+func (admin *Admin) KeyUsagesHandler(w http.ResponseWriter, r *http.Request) {
+	limit := DefaultListKeyUsagesLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l <= 0 || l > MaxListKeyUsagesLimit {
+			http.Error(w, fmt.Sprintf("limit should be between 1 and %d", MaxListKeyUsagesLimit), http.StatusBadRequest)
+			return
+		}
+		limit = l
+	}
+
+	query := admin.DB.Order("id desc").Limit(limit)
+	if chain := r.URL.Query().Get("chain"); chain != "" {
+		query = query.Where("chain = ?", chain)
+	}
+	if purpose := r.URL.Query().Get("purpose"); purpose != "" {
+		query = query.Where("purpose = ?", purpose)
+	}
+	if account := r.URL.Query().Get("account"); account != "" {
+		query = query.Where("account = ?", ethcom.HexToAddress(account).String())
+	}
+	if txHash := r.URL.Query().Get("tx_hash"); txHash != "" {
+		query = query.Where("tx_hash = ?", ethcom.HexToHash(txHash).String())
+	}
+	if fromStr := r.URL.Query().Get("from"); fromStr != "" {
+		from, err := time.Parse(ExportDateLayout, fromStr)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid from: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+		query = query.Where("created_at >= ?", from)
+	}
+	if toStr := r.URL.Query().Get("to"); toStr != "" {
+		to, err := time.Parse(ExportDateLayout, toStr)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid to: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+		// the last day is included
+		query = query.Where("created_at < ?", to.AddDate(0, 0, 1))
+	}
+
+	keyUsages := make([]model.KeyUsage, 0)
+	if err := query.Find(&keyUsages).Error; err != nil {
+		http.Error(w, fmt.Sprintf("query key usages error, err=%s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, http.StatusOK, keyUsages)
+}
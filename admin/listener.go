@@ -0,0 +1,76 @@
+package admin
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	"occ-swap-server/util"
+)
+
+// remoteIP returns the ip of the peer of the connection
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// withAllowedIPs rejects the requests whose remote ips are not in the networks, all are rejected if there is no
+// network. The remote ip is the peer of the connection, so the allowlist needs the clients to connect directly.
+func withAllowedIPs(nets []*net.IPNet, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := net.ParseIP(remoteIP(r))
+		for _, ipNet := range nets {
+			if ip != nil && ipNet.Contains(ip) {
+				next(w, r)
+				return
+			}
+		}
+		util.Logger.Infof("%s %s from %s is rejected by the ip allowlist", r.Method, r.URL.Path, r.RemoteAddr)
+		http.Error(w, "ip is not allowed", http.StatusForbidden)
+	}
+}
+
+// newClientTLSConfig returns the tls config which requires the client certificates signed by the client ca
+func newClientTLSConfig(cfg util.AdminConfig) (*tls.Config, error) {
+	caPEM, err := ioutil.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("read client ca file error, err=%s", err.Error())
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificate in client ca file %s", cfg.ClientCAFile)
+	}
+	return &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
+
+// listenAndServeTLS serves the endpoints with a permission on the secure listener with mutual tls
+func listenAndServeTLS(cfg util.AdminConfig, handler http.Handler) {
+	tlsConfig, err := newClientTLSConfig(cfg)
+	if err != nil {
+		panic(fmt.Sprintf("start secure admin server error, err=%s", err.Error()))
+	}
+	srv := &http.Server{
+		Handler:      handler,
+		Addr:         cfg.SecureListenAddr,
+		TLSConfig:    tlsConfig,
+		WriteTimeout: cfg.GetStreamTimeout(),
+		ReadTimeout:  ReadTimeout,
+	}
+
+	util.Logger.Infof("start secure admin server at %s", srv.Addr)
+
+	err = srv.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		panic(fmt.Sprintf("start secure admin server error, err=%s", err.Error()))
+	}
+}
@@ -0,0 +1,466 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"occ-swap-server/util"
+)
+
+const OpenAPIVersion = "3.0.3"
+
+// apiParam is a path or query parameter of an api
+type apiParam struct {
+	Name        string
+	In          string
+	Type        string
+	Pattern     string
+	Required    bool
+	Description string
+}
+
+// apiRoute annotates a handler with the information used to generate the OpenAPI spec and validate the requests
+type apiRoute struct {
+	Method  string
+	Path    string
+	Summary string
+	// Permission is required to call the route, the request is signed with the api secret or a token is sent, see
+	// withPermission. The routes without a permission are public.
+	Permission Permission
+	// SponsorAuth means a challenge is signed by the sponsor, see checkSponsorAuth
+	SponsorAuth bool
+	Params      []apiParam
+	// Body is the request type decoded from the body, the fields tagged with required:"true" are required
+	Body interface{}
+	// Stream means the response is written while it is read from the db, it may take up to the stream timeout
+	// instead of the write timeout, see withWriteTimeout
+	Stream  bool
+	Handler http.HandlerFunc
+}
+
+var (
+	addressParam     = apiParam{Name: "addr", In: "path", Type: "string", Pattern: "^0x[0-9a-fA-F]{40}$", Required: true, Description: "sponsor address"}
+	startTxHashParam = apiParam{Name: "start_tx_hash", In: "path", Type: "string", Pattern: "^0x[0-9a-fA-F]{64}$", Required: true, Description: "hash of the swap start tx"}
+)
+
+func (admin *Admin) routes() []apiRoute {
+	return []apiRoute{
+		{Method: http.MethodGet, Path: "/", Summary: "List the endpoints", Handler: admin.Endpoints},
+		{Method: http.MethodGet, Path: "/healthz", Summary: "Health check", Handler: admin.Healthz},
+		{Method: http.MethodGet, Path: "/swagger.json", Summary: "OpenAPI specification of the admin server", Handler: admin.SwaggerHandler},
+		{Method: http.MethodGet, Path: "/metrics", Summary: "Prometheus metrics", Handler: promhttp.Handler().ServeHTTP},
+		{Method: http.MethodPut, Path: "/update_swap_pair", Summary: "Update the bounds and availability of a swap pair", Permission: PermissionManagePairs,
+			Body: updateSwapPairRequest{}, Handler: admin.UpdateSwapPairHandler},
+		{Method: http.MethodPost, Path: "/register_swap_pair", Summary: "Add a swap pair, deploying its missing pegged token", Permission: PermissionManagePairs,
+			Body: registerSwapPairRequest{}, Handler: admin.RegisterSwapPairHandler},
+		{Method: http.MethodDelete, Path: "/swap_pairs", Summary: "Delete a swap pair no swap references, the others can only be disabled",
+			Permission: PermissionManagePairs, Body: deleteSwapPairRequest{}, Handler: admin.DeleteSwapPairHandler},
+		{Method: http.MethodPost, Path: "/migrate_swap_pair", Summary: "Move a paused swap pair to new token contracts", Permission: PermissionManagePairs,
+			Body: migrateSwapPairRequest{}, Handler: admin.MigrateSwapPairHandler},
+		{Method: http.MethodGet, Path: "/pair_migrations", Summary: "Audit records of the migrated swap pairs", Permission: PermissionAudit,
+			Handler: admin.PairMigrationsHandler},
+		{Method: http.MethodGet, Path: "/pegged_token_deployments/{id}", Summary: "Progress of a pegged token deployment", Permission: PermissionRead,
+			Params: []apiParam{peggedTokenDeploymentParam}, Handler: admin.PeggedTokenDeploymentHandler},
+		{Method: http.MethodPost, Path: "/withdraw_token", Summary: "Withdraw token from the relayer", Permission: PermissionManage,
+			Body: withdrawTokenRequest{}, Handler: admin.WithdrawToken},
+		{Method: http.MethodPost, Path: "/retry_failed_swaps", Summary: "Retry the failed swaps", Permission: PermissionOperate,
+			Body: retryFailedSwapsRequest{}, Handler: admin.RetryFailedSwaps},
+		{Method: http.MethodGet, Path: "/api/v1/address/{addr}/summary", Summary: "Swaps of a sponsor with aggregate statistics",
+			Params: addressSummaryParams, Handler: admin.AddressSummaryHandler},
+		{Method: http.MethodGet, Path: "/api/v1/quote", Summary: "Estimated fees, bounds, pause status and eta of a swap",
+			Params: quoteParams, Handler: admin.QuoteHandler},
+		{Method: http.MethodPost, Path: "/api/v1/build-swap-tx", Summary: "Calldata of the approve and the swap txs the owner sends to start a swap",
+			Body: buildSwapTxRequest{}, Handler: admin.BuildSwapTxHandler},
+		{Method: http.MethodGet, Path: "/api/v1/status", Summary: "Pause status and latency percentiles of the swap directions",
+			Handler: admin.StatusHandler},
+		{Method: http.MethodGet, Path: "/api/v1/status_page", Summary: "Public status of the swap directions and the active incident",
+			Handler: admin.StatusPageHandler},
+		{Method: http.MethodPost, Path: "/incident", Summary: "Set or resolve the incident note of the status page", Permission: PermissionOperate,
+			Body: incidentRequest{}, Handler: admin.IncidentHandler},
+		{Method: http.MethodGet, Path: "/api/v1/auth/challenge", Summary: "Issue a challenge signed by the sponsor to authenticate a request",
+			Params: []apiParam{sponsorParam, actionParam, paramsParam}, Handler: admin.ChallengeHandler},
+		{Method: http.MethodGet, Path: "/api/v1/sponsor/swaps/{start_tx_hash}", Summary: "Private details of a swap of the sponsor",
+			SponsorAuth: true, Params: []apiParam{startTxHashParam}, Handler: admin.SponsorSwapHandler},
+		{Method: http.MethodPost, Path: "/api/v1/sponsor/swaps/{start_tx_hash}/cancel", Summary: "Cancel a delayed swap of the sponsor",
+			SponsorAuth: true, Params: []apiParam{startTxHashParam}, Handler: admin.SponsorCancelSwapHandler},
+		{Method: http.MethodPost, Path: "/api/v1/sponsor/webhook", Summary: "Set or remove the webhook of the sponsor",
+			SponsorAuth: true, Body: sponsorWebhookRequest{}, Handler: admin.SponsorWebhookHandler},
+		{Method: http.MethodPost, Path: "/api/v1/permit2/params", Summary: "Fields of the permit2 transfer the owner signs to start a swap in one tx",
+			Body: permit2ParamsRequest{}, Handler: admin.Permit2ParamsHandler},
+		{Method: http.MethodPost, Path: "/api/v1/permit2/build-swap-tx", Summary: "Calldata of the swap tx starting a swap with the permit2 transfer signed by the owner",
+			Body: permit2SwapTxRequest{}, Handler: admin.BuildPermit2SwapTxHandler},
+		{Method: http.MethodGet, Path: "/api/v1/relay/params", Summary: "Fields of the permit the owner signs for a relayed swap",
+			Params: []apiParam{relayChainParam, ownerParam}, Handler: admin.RelayParamsHandler},
+		{Method: http.MethodPost, Path: "/api/v1/relay/swaps", Summary: "Start a swap by the relayer with the permit signed by the owner",
+			Body: relaySwapRequest{}, Handler: admin.RelaySwapHandler},
+		{Method: http.MethodGet, Path: "/api/v1/relay/swaps/{id}", Summary: "Progress of a relayed swap",
+			Params: []apiParam{relayedSwapParam}, Handler: admin.RelayedSwapHandler},
+		{Method: http.MethodGet, Path: "/swaps", Summary: "List the swaps of a status, the pending swaps by default", Permission: PermissionRead,
+			Params: []apiParam{
+				{Name: "status", In: "query", Type: "string", Description: "swap status or pending"},
+				{Name: "limit", In: "query", Type: "integer", Description: fmt.Sprintf("max number of swaps, at most %d", MaxListSwapsLimit)},
+			}, Handler: admin.ListSwapsHandler},
+		{Method: http.MethodGet, Path: "/swaps/{start_tx_hash}/timeline", Summary: "All the records related to a swap", Permission: PermissionRead,
+			Params: []apiParam{startTxHashParam}, Handler: admin.SwapTimelineHandler},
+		{Method: http.MethodGet, Path: "/swaps/{start_tx_hash}/proof", Summary: "Receipt proof of the SwapStarted event of a filled swap", Permission: PermissionRead,
+			Params: []apiParam{startTxHashParam}, Handler: admin.SwapProofHandler},
+		{Method: http.MethodGet, Path: "/archived_swaps/{start_tx_hash}", Summary: "A swap moved to the archive tables by the retention policy", Permission: PermissionRead,
+			Params: []apiParam{startTxHashParam}, Handler: admin.ArchivedSwapHandler},
+		{Method: http.MethodPost, Path: "/pause_direction", Summary: "Pause or resume a swap direction", Permission: PermissionOperate,
+			Body: pauseDirectionRequest{}, Handler: admin.PauseDirectionHandler},
+		{Method: http.MethodPost, Path: "/pause_pair", Summary: "Pause or resume a swap pair", Permission: PermissionOperate,
+			Body: pausePairRequest{}, Handler: admin.PausePairHandler},
+		{Method: http.MethodGet, Path: "/paused_pairs", Summary: "Swap pairs paused by the admin or the anomaly detector", Permission: PermissionRead, Handler: admin.PausedPairsHandler},
+		{Method: http.MethodPost, Path: "/rebroadcast_fill_tx", Summary: "Re-send the stored signed bytes of a pending fill tx", Permission: PermissionOperate,
+			Body: rebroadcastFillTxRequest{}, Handler: admin.RebroadcastFillTxHandler},
+		{Method: http.MethodPost, Path: "/backfill", Summary: "Re-scan a block range for swap events", Permission: PermissionOperate,
+			Body: backfillRequest{}, Handler: admin.BackfillHandler},
+		{Method: http.MethodPost, Path: "/delayed_swap", Summary: "Expedite or cancel a delayed swap", Permission: PermissionOperate,
+			Body: delayedSwapRequest{}, Handler: admin.DelayedSwapHandler},
+		{Method: http.MethodPost, Path: "/uneconomic_swap", Summary: "Fill or reject a swap whose bridge fee doesn't cover the fill gas", Permission: PermissionOperate,
+			Body: uneconomicSwapRequest{}, Handler: admin.UneconomicSwapHandler},
+		{Method: http.MethodPost, Path: "/integrity_sweep", Summary: "Verify the record hashes of all the swaps", Permission: PermissionAudit,
+			Body: integritySweepRequest{}, Handler: admin.IntegritySweepHandler},
+		{Method: http.MethodGet, Path: "/integrity_sweep", Summary: "Report of the latest integrity sweep", Permission: PermissionAudit, Handler: admin.IntegrityReportHandler},
+		{Method: http.MethodGet, Path: "/quarantined_swaps", Summary: "Swaps quarantined by the integrity sweep", Permission: PermissionAudit,
+			Params: []apiParam{
+				{Name: "reviewed", In: "query", Type: "boolean", Description: "include the reviewed swaps"},
+				{Name: "limit", In: "query", Type: "integer", Description: fmt.Sprintf("max number of swaps, at most %d", MaxListQuarantinedSwapsLimit)},
+			}, Handler: admin.QuarantinedSwapsHandler},
+		{Method: http.MethodPost, Path: "/import_swaps", Summary: "Import the swaps of the dump of a previous bridge deployment", Permission: PermissionManage,
+			Body: importSwapsRequest{}, Handler: admin.ImportSwapsHandler},
+		{Method: http.MethodGet, Path: "/admin/overview", Summary: "Swap counts, failure rate, relayer balances and paused flags", Permission: PermissionRead,
+			Handler: admin.OverviewHandler},
+		{Method: http.MethodGet, Path: "/liquidity", Summary: "Liquidity of the swap agents on the destination chains", Permission: PermissionRead, Handler: admin.LiquidityHandler},
+		{Method: http.MethodGet, Path: "/export", Summary: "Export the swaps or the fill txs of a date range as csv", Permission: PermissionAudit,
+			Params: []apiParam{exportTypeParam, exportFormatParam, exportFromParam, exportToParam}, Stream: true, Handler: admin.ExportHandler},
+		{Method: http.MethodGet, Path: "/fee_audit", Summary: "Swaps of a date range charged other fees than the current fee schedule, as csv", Permission: PermissionAudit,
+			Params: []apiParam{exportFromParam, exportToParam}, Stream: true, Handler: admin.FeeAuditHandler},
+		{Method: http.MethodGet, Path: "/key_usages", Summary: "Txs signed by the relayer keys, for the security audits of the key handling",
+			Permission: PermissionAudit, Params: keyUsageParams, Handler: admin.KeyUsagesHandler},
+		{Method: http.MethodGet, Path: "/stats/hourly", Summary: "Hourly counts, amounts and fill latency of the swaps by pair and direction",
+			Permission: PermissionRead, Params: rollupParams, Handler: admin.HourlyStatsHandler},
+		{Method: http.MethodGet, Path: "/stats/daily", Summary: "Daily counts, amounts and fill latency of the swaps by pair and direction",
+			Permission: PermissionRead, Params: rollupParams, Handler: admin.DailyStatsHandler},
+		{Method: http.MethodGet, Path: "/relayers", Summary: "Balances of the relayer accounts on the destination chains", Permission: PermissionRead, Handler: admin.RelayersHandler},
+		{Method: http.MethodGet, Path: "/drain_brake", Summary: "Chains halted by the drain brake and the hourly spend of the relayer accounts",
+			Permission: PermissionRead, Handler: admin.DrainBrakeHandler},
+		{Method: http.MethodPost, Path: "/drain_brake", Summary: "Resume the fills to a chain halted by the drain brake", Permission: PermissionOperate,
+			Body: releaseDrainBrakeRequest{}, Handler: admin.ReleaseDrainBrakeHandler},
+		{Method: http.MethodGet, Path: "/rebalance_plans", Summary: "Inventory of the swap agents against their targets and the latest rebalance plans",
+			Permission: PermissionRead, Params: []apiParam{{Name: "limit", In: "query", Type: "integer",
+				Description: fmt.Sprintf("max number of plans, at most %d", MaxListRebalancePlansLimit)}},
+			Handler: admin.RebalancePlansHandler},
+		{Method: http.MethodPost, Path: "/rebalance_plans", Summary: "Propose a rebalance plan from the current inventory", Permission: PermissionManage,
+			Handler: admin.ProposeRebalancePlanHandler},
+		{Method: http.MethodPost, Path: "/rebalance_plan", Summary: "Approve or reject a rebalance plan, or mark it executed by hand", Permission: PermissionManage,
+			Body: rebalancePlanRequest{}, Handler: admin.RebalancePlanHandler},
+		{Method: http.MethodGet, Path: "/nonce_reconciliations", Summary: "Nonce gaps of the relayer accounts rebroadcast or plugged on startup", Permission: PermissionRead,
+			Handler: admin.NonceReconciliationsHandler},
+		{Method: http.MethodGet, Path: "/daemons", Summary: "Last runs, errors and backoff of the daemons of the swap engine", Permission: PermissionRead,
+			Handler: admin.DaemonsHandler},
+		{Method: http.MethodPost, Path: "/mark_swap_filled", Summary: "Mark a swap as filled by an external tx", Permission: PermissionOperate,
+			Body: markSwapFilledRequest{}, Handler: admin.MarkSwapFilledHandler},
+		{Method: http.MethodPost, Path: "/pair_owners", Summary: "Grant a swap pair to a scoped api key", Permission: PermissionManage,
+			Body: pairOwnerRequest{}, Handler: admin.AddPairOwnerHandler},
+		{Method: http.MethodDelete, Path: "/pair_owners", Summary: "Revoke a swap pair from a scoped api key", Permission: PermissionManage,
+			Body: pairOwnerRequest{}, Handler: admin.RemovePairOwnerHandler},
+		{Method: http.MethodGet, Path: "/sponsor_tiers", Summary: "Tiers of the partner sponsors, with their fee rebates and fill priorities",
+			Permission: PermissionRead, Handler: admin.SponsorTiersHandler},
+		{Method: http.MethodPost, Path: "/sponsor_tiers", Summary: "Set the tier of a sponsor", Permission: PermissionManage,
+			Body: sponsorTierRequest{}, Handler: admin.SetSponsorTierHandler},
+		{Method: http.MethodDelete, Path: "/sponsor_tiers", Summary: "Remove the tier of a sponsor", Permission: PermissionManage,
+			Body: sponsorTierRequest{}, Handler: admin.RemoveSponsorTierHandler},
+		{Method: http.MethodGet, Path: "/admin_users", Summary: "List the admin users and their roles", Permission: PermissionManageUsers,
+			Handler: admin.ListAdminUsersHandler},
+		{Method: http.MethodPost, Path: "/admin_users", Summary: "Add an admin user with a role", Permission: PermissionManageUsers,
+			Body: addAdminUserRequest{}, Handler: admin.AddAdminUserHandler},
+		{Method: http.MethodPut, Path: "/admin_users", Summary: "Change the role of an admin user, disable it or rotate its api secret",
+			Permission: PermissionManageUsers, Body: updateAdminUserRequest{}, Handler: admin.UpdateAdminUserHandler},
+		{Method: http.MethodPost, Path: "/auth/token", Summary: "Issue a bearer token to the admin user of the signed request",
+			Permission: PermissionRead, Handler: admin.TokenHandler},
+		{Method: http.MethodPost, Path: "/webhooks", Summary: "Add a webhook called when the swaps succeed or fail", Permission: PermissionManagePairs,
+			Body: addWebhookRequest{}, Handler: admin.AddWebhookHandler},
+		{Method: http.MethodDelete, Path: "/webhooks", Summary: "Remove a webhook", Permission: PermissionManagePairs,
+			Body: removeWebhookRequest{}, Handler: admin.RemoveWebhookHandler},
+		{Method: http.MethodGet, Path: "/webhook_deliveries", Summary: "Latest deliveries of a webhook", Permission: PermissionManagePairs,
+			Params: []apiParam{webhookIDParam, {Name: "limit", In: "query", Type: "integer",
+				Description: fmt.Sprintf("max number of deliveries, at most %d", MaxListWebhookDeliveriesLimit)}},
+			Handler: admin.WebhookDeliveriesHandler},
+	}
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" {
+		name = field.Name
+	}
+	return name
+}
+
+func schemaType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	default:
+		return "string"
+	}
+}
+
+func schemaOf(t reflect.Type) map[string]interface{} {
+	schema := map[string]interface{}{"type": schemaType(t)}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		schema["items"] = schemaOf(t.Elem())
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		required := make([]string, 0)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Tag.Get("json") == "-" {
+				continue
+			}
+			name := jsonFieldName(field)
+			properties[name] = schemaOf(field.Type)
+			if field.Tag.Get("required") == "true" {
+				required = append(required, name)
+			}
+		}
+		schema["properties"] = properties
+		schema["additionalProperties"] = false
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+	}
+	return schema
+}
+
+func buildOpenAPISpec(routes []apiRoute) map[string]interface{} {
+	paths := make(map[string]map[string]interface{})
+	for _, route := range routes {
+		operation := map[string]interface{}{
+			"summary": route.Summary,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{"description": "OK"},
+				"400": map[string]interface{}{"description": "invalid request"},
+			},
+		}
+		if len(route.Params) > 0 {
+			params := make([]interface{}, 0, len(route.Params))
+			for _, p := range route.Params {
+				schema := map[string]interface{}{"type": p.Type}
+				if p.Pattern != "" {
+					schema["pattern"] = p.Pattern
+				}
+				params = append(params, map[string]interface{}{
+					"name":        p.Name,
+					"in":          p.In,
+					"required":    p.Required,
+					"description": p.Description,
+					"schema":      schema,
+				})
+			}
+			operation["parameters"] = params
+		}
+		if route.Body != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": schemaOf(reflect.TypeOf(route.Body))},
+				},
+			}
+		}
+		if route.Permission != "" {
+			operation["security"] = []interface{}{
+				map[string]interface{}{"ApiKey": []string{}, "Signature": []string{}, "Timestamp": []string{}, "Nonce": []string{}},
+				map[string]interface{}{"Bearer": []string{}, "TokenSignature": []string{}, "Timestamp": []string{}, "Nonce": []string{}},
+			}
+			operation["x-permission"] = route.Permission
+			operation["responses"].(map[string]interface{})["401"] = map[string]interface{}{"description": "not authenticated"}
+			operation["responses"].(map[string]interface{})["403"] = map[string]interface{}{"description": "permission denied"}
+		}
+		if route.SponsorAuth {
+			operation["security"] = []interface{}{map[string]interface{}{
+				"Sponsor": []string{}, "Challenge": []string{}, "SponsorSignature": []string{}, "SignatureType": []string{},
+			}}
+		}
+		if paths[route.Path] == nil {
+			paths[route.Path] = make(map[string]interface{})
+		}
+		paths[route.Path][strings.ToLower(route.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": OpenAPIVersion,
+		"info": map[string]interface{}{
+			"title":   "occ swap server admin api",
+			"version": "v1",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"ApiKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": "ApiKey"},
+				"Signature": map[string]interface{}{"type": "apiKey", "in": "header", "name": "Authorization",
+					"description": "hex encoded HMAC-SHA256 of the method, uri, timestamp and nonce, each followed by a newline, and the body"},
+				"Timestamp": map[string]interface{}{"type": "apiKey", "in": "header", "name": util.TimestampHeader,
+					"description": "unix time the request is signed at, within replay_window_seconds of admin_config"},
+				"Nonce": map[string]interface{}{"type": "apiKey", "in": "header", "name": util.NonceHeader,
+					"description": "random nonce of the request, a nonce of the api key or of the token user is accepted once"},
+				"Bearer": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT", "description": "token issued by /auth/token"},
+				"TokenSignature": map[string]interface{}{"type": "apiKey", "in": "header", "name": util.TokenSignatureHeader,
+					"description": "hex encoded HMAC-SHA256 of the method, uri, timestamp and nonce, each followed by a newline, and the body, with the token_secret issued along with the token"},
+				"Sponsor":   map[string]interface{}{"type": "apiKey", "in": "header", "name": "Sponsor", "description": "address of the sponsor"},
+				"Challenge": map[string]interface{}{"type": "apiKey", "in": "header", "name": "Challenge", "description": "challenge issued to the sponsor"},
+				"SponsorSignature": map[string]interface{}{"type": "apiKey", "in": "header", "name": "Signature",
+					"description": "hex encoded signature of the challenge by the sponsor"},
+				"SignatureType": map[string]interface{}{"type": "apiKey", "in": "header", "name": "SignatureType",
+					"description": "eip191 for the message signed by personal_sign, or eip712 for the typed data"},
+			},
+		},
+	}
+}
+
+// SwaggerHandler serves the OpenAPI spec generated from the route annotations
+func (admin *Admin) SwaggerHandler(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, buildOpenAPISpec(admin.routes()))
+}
+
+func checkParamType(p apiParam, value string) error {
+	switch p.Type {
+	case "integer":
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("%s should be an integer", p.Name)
+		}
+	case "boolean":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%s should be a boolean", p.Name)
+		}
+	}
+	if p.Pattern != "" && !regexp.MustCompile(p.Pattern).MatchString(value) {
+		return fmt.Errorf("%s doesn't match %s", p.Name, p.Pattern)
+	}
+	return nil
+}
+
+func checkValueType(name string, schema map[string]interface{}, value interface{}) error {
+	expected := schema["type"].(string)
+	ok := false
+	switch v := value.(type) {
+	case nil:
+		ok = true
+	case bool:
+		ok = expected == "boolean"
+	case string:
+		ok = expected == "string"
+	case float64:
+		ok = expected == "number" || (expected == "integer" && v == math.Trunc(v))
+	case []interface{}:
+		ok = expected == "array"
+		if ok {
+			for i, item := range v {
+				if err := checkValueType(fmt.Sprintf("%s[%d]", name, i), schema["items"].(map[string]interface{}), item); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]interface{}:
+		ok = expected == "object"
+	}
+	if !ok {
+		return fmt.Errorf("%s should be of type %s", name, expected)
+	}
+	return nil
+}
+
+func checkBody(schema map[string]interface{}, body []byte) error {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return fmt.Errorf("body should be a json object")
+	}
+	properties := schema["properties"].(map[string]interface{})
+	if required, ok := schema["required"].([]string); ok {
+		for _, name := range required {
+			if _, ok := fields[name]; !ok {
+				return fmt.Errorf("%s is required", name)
+			}
+		}
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		property, ok := properties[name]
+		if !ok {
+			return fmt.Errorf("unknown field %s", name)
+		}
+		if err := checkValueType(name, property.(map[string]interface{}), fields[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateRequest checks the params and the body of the request against the route annotation, the body is
+// restored so that the handler can read it again
+func validateRequest(route apiRoute, r *http.Request) error {
+	for _, p := range route.Params {
+		var value string
+		if p.In == "path" {
+			value = mux.Vars(r)[p.Name]
+		} else {
+			value = r.URL.Query().Get(p.Name)
+		}
+		if value == "" {
+			if p.Required {
+				return fmt.Errorf("%s is required", p.Name)
+			}
+			continue
+		}
+		if err := checkParamType(p, value); err != nil {
+			return err
+		}
+	}
+
+	if route.Body == nil {
+		return nil
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return checkBody(schemaOf(reflect.TypeOf(route.Body)), body)
+}
+
+func withValidation(route apiRoute) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := validateRequest(route, r); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request, %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+		route.Handler(w, r)
+	}
+}
@@ -0,0 +1,521 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+
+	cmm "occ-swap-server/common"
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+const (
+	DefaultListSwapsLimit = 100
+	MaxListSwapsLimit     = 1000
+
+	MaxBackfillBlocks = 5000
+
+	DelayedSwapExpedite = "expedite"
+	DelayedSwapCancel   = "cancel"
+
+	UneconomicSwapFill   = "fill"
+	UneconomicSwapReject = "reject"
+
+	DefaultListQuarantinedSwapsLimit = 100
+	MaxListQuarantinedSwapsLimit     = 1000
+
+	MaxImportSwaps = 1000
+)
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	jsonBytes, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(jsonBytes)
+	if err != nil {
+		util.Logger.Errorf("write response error, err=%s", err.Error())
+	}
+}
+
+// ListSwapsHandler lists the swaps of the given status, the pending swaps are listed by default
+func (admin *Admin) ListSwapsHandler(w http.ResponseWriter, r *http.Request) {
+	limit := DefaultListSwapsLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l <= 0 || l > MaxListSwapsLimit {
+			http.Error(w, fmt.Sprintf("limit should be between 1 and %d", MaxListSwapsLimit), http.StatusBadRequest)
+			return
+		}
+		limit = l
+	}
+
+	statuses := pendingSwapStatuses
+	if status := r.URL.Query().Get("status"); status != "" && status != "pending" {
+		statuses = []cmm.SwapStatus{cmm.SwapStatus(status)}
+	}
+
+	swaps := make([]model.Swap, 0)
+	err := admin.DB.Where("status in (?)", statuses).Order("id asc").Limit(limit).Find(&swaps).Error
+	if err != nil {
+		http.Error(w, fmt.Sprintf("query swaps error, err=%s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, http.StatusOK, swaps)
+}
+
+// SwapTimelineHandler returns all the records related to the swap of the given start tx hash
+func (admin *Admin) SwapTimelineHandler(w http.ResponseWriter, r *http.Request) {
+	startTxHash := mux.Vars(r)["start_tx_hash"]
+
+	var timeline swapTimelineResponse
+	err := admin.DB.Where("start_tx_hash = ?", startTxHash).First(&timeline.Swap).Error
+	if err == gorm.ErrRecordNotFound {
+		archived := 0
+		admin.DB.Model(model.ArchivedSwap{}).Where("start_tx_hash = ?", startTxHash).Count(&archived)
+		if archived != 0 {
+			http.Error(w, fmt.Sprintf("swap %s is archived, see /archived_swaps/%s", startTxHash, startTxHash), http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("swap %s is not found", startTxHash), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("query swap error, err=%s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	txEventLog := model.SwapStartTxLog{}
+	if err := admin.DB.Where("tx_hash = ?", startTxHash).First(&txEventLog).Error; err == nil {
+		timeline.SwapStartTxLog = &txEventLog
+	}
+	timeline.SwapFillTxs = make([]model.SwapFillTx, 0)
+	admin.DB.Where("swap_id = ?", timeline.Swap.ID).Order("id asc").Find(&timeline.SwapFillTxs)
+	timeline.RetrySwaps = make([]model.RetrySwap, 0)
+	admin.DB.Where("start_tx_hash = ?", startTxHash).Order("id asc").Find(&timeline.RetrySwaps)
+	timeline.RetrySwapTxs = make([]model.RetrySwapTx, 0)
+	admin.DB.Where("start_tx_hash = ?", startTxHash).Order("id asc").Find(&timeline.RetrySwapTxs)
+	swapProof := model.SwapProof{}
+	if err := admin.DB.Where("start_tx_hash = ?", startTxHash).First(&swapProof).Error; err == nil {
+		timeline.SwapProof = &swapProof
+	}
+	timeline.StartTxUrl, timeline.FillTxUrl = admin.swapEngine.SwapExplorerLinks(&timeline.Swap)
+
+	writeJson(w, http.StatusOK, timeline)
+}
+
+// ArchivedSwapHandler returns the archived swap of the given start tx hash with its start tx log and fill txs
+func (admin *Admin) ArchivedSwapHandler(w http.ResponseWriter, r *http.Request) {
+	startTxHash := mux.Vars(r)["start_tx_hash"]
+
+	var archivedSwap archivedSwapResponse
+	err := admin.DB.Where("start_tx_hash = ?", startTxHash).First(&archivedSwap.Swap).Error
+	if err == gorm.ErrRecordNotFound {
+		http.Error(w, fmt.Sprintf("archived swap %s is not found", startTxHash), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("query archived swap error, err=%s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	txEventLog := model.ArchivedSwapStartTxLog{}
+	if err := admin.DB.Where("tx_hash = ?", startTxHash).First(&txEventLog).Error; err == nil {
+		archivedSwap.SwapStartTxLog = &txEventLog
+	}
+	archivedSwap.SwapFillTxs = make([]model.ArchivedSwapFillTx, 0)
+	admin.DB.Where("start_swap_tx_hash = ?", startTxHash).Order("id asc").Find(&archivedSwap.SwapFillTxs)
+
+	writeJson(w, http.StatusOK, archivedSwap)
+}
+
+// SwapProofHandler returns the receipt proof of the SwapStarted event of a filled swap, it is stored once the
+// swap succeeds
+func (admin *Admin) SwapProofHandler(w http.ResponseWriter, r *http.Request) {
+	startTxHash := mux.Vars(r)["start_tx_hash"]
+
+	var proof swapProofResponse
+	err := admin.DB.Where("start_tx_hash = ?", startTxHash).First(&proof.SwapProof).Error
+	if err == gorm.ErrRecordNotFound {
+		http.Error(w, fmt.Sprintf("proof of swap %s is not found", startTxHash), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("query proof error, err=%s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	proof.ProofNodes = make([]string, 0)
+	if proof.Proof != "" {
+		if err := json.Unmarshal([]byte(proof.Proof), &proof.ProofNodes); err != nil {
+			http.Error(w, fmt.Sprintf("decode proof error, err=%s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	writeJson(w, http.StatusOK, proof)
+}
+
+func (admin *Admin) PauseDirectionHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := admin.checkAuth(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var pauseDirection pauseDirectionRequest
+	err = json.Unmarshal(reqBody, &pauseDirection)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = admin.swapEngine.PauseDirection(cmm.SwapDirection(pauseDirection.Direction), pauseDirection.Paused)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, http.StatusOK, pauseDirection)
+}
+
+// PausePairHandler pauses or resumes filling the swaps of a pair, the pairs paused by the anomaly detector are only
+// resumed here
+func (admin *Admin) PausePairHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := admin.checkAuth(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var pausePair pausePairRequest
+	err = json.Unmarshal(reqBody, &pausePair)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !ethcom.IsHexAddress(pausePair.ERC20Addr) {
+		http.Error(w, fmt.Sprintf("invalid erc20 address: %s", pausePair.ERC20Addr), http.StatusBadRequest)
+		return
+	}
+	if pausePair.Operator == "" {
+		pausePair.Operator = callerName(r)
+	}
+
+	erc20Addr := ethcom.HexToAddress(pausePair.ERC20Addr)
+	if pausePair.Paused {
+		err = admin.swapEngine.PausePair(erc20Addr, pausePair.Reason, pausePair.Operator)
+	} else {
+		err = admin.swapEngine.ResumePair(erc20Addr, pausePair.Operator)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, http.StatusOK, pausePair)
+}
+
+func (admin *Admin) PausedPairsHandler(w http.ResponseWriter, r *http.Request) {
+	pausedPairs := make([]model.PausedPair, 0)
+	if err := admin.DB.Order("id asc").Find(&pausedPairs).Error; err != nil {
+		http.Error(w, fmt.Sprintf("query paused pairs error, err=%s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, http.StatusOK, pausedPairs)
+}
+
+// RebroadcastFillTxHandler re-sends the stored signed bytes of a pending fill tx which is lost by the nodes
+func (admin *Admin) RebroadcastFillTxHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := admin.checkAuth(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var rebroadcast rebroadcastFillTxRequest
+	err = json.Unmarshal(reqBody, &rebroadcast)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rebroadcast.Operator == "" {
+		rebroadcast.Operator = callerName(r)
+	}
+
+	if err := admin.swapEngine.RebroadcastFillTx(rebroadcast.FillTxHash, rebroadcast.Operator); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, http.StatusOK, rebroadcast)
+}
+
+func (admin *Admin) BackfillHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := admin.checkAuth(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var backfill backfillRequest
+	err = json.Unmarshal(reqBody, &backfill)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ob, ok := admin.observers[strings.ToUpper(backfill.Chain)]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown chain: %s", backfill.Chain), http.StatusBadRequest)
+		return
+	}
+	if backfill.FromHeight <= 0 || backfill.ToHeight < backfill.FromHeight {
+		http.Error(w, "invalid block range", http.StatusBadRequest)
+		return
+	}
+	if backfill.ToHeight-backfill.FromHeight >= MaxBackfillBlocks {
+		http.Error(w, fmt.Sprintf("block range should be less than %d", MaxBackfillBlocks), http.StatusBadRequest)
+		return
+	}
+
+	var backfillResp backfillResponse
+	backfillResp.SavedEvents, err = ob.Backfill(backfill.FromHeight, backfill.ToHeight)
+	if err != nil {
+		backfillResp.ErrMsg = err.Error()
+		writeJson(w, http.StatusInternalServerError, backfillResp)
+		return
+	}
+	writeJson(w, http.StatusOK, backfillResp)
+}
+
+// DelayedSwapHandler expedites or cancels a swap which is held by the time-lock delay
+func (admin *Admin) DelayedSwapHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := admin.checkAuth(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var delayedSwap delayedSwapRequest
+	err = json.Unmarshal(reqBody, &delayedSwap)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch delayedSwap.Action {
+	case DelayedSwapExpedite:
+		err = admin.swapEngine.ExpediteDelayedSwap(delayedSwap.StartTxHash)
+	case DelayedSwapCancel:
+		err = admin.swapEngine.CancelDelayedSwap(delayedSwap.StartTxHash, delayedSwap.Reason, callerName(r))
+	default:
+		http.Error(w, fmt.Sprintf("unknown action: %s", delayedSwap.Action), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, http.StatusOK, delayedSwap)
+}
+
+// UneconomicSwapHandler fills or rejects a swap which is held since its bridge fee doesn't cover the fill gas
+func (admin *Admin) UneconomicSwapHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := admin.checkAuth(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var uneconomicSwap uneconomicSwapRequest
+	err = json.Unmarshal(reqBody, &uneconomicSwap)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch uneconomicSwap.Action {
+	case UneconomicSwapFill:
+		err = admin.swapEngine.FillUneconomicSwap(uneconomicSwap.StartTxHash)
+	case UneconomicSwapReject:
+		err = admin.swapEngine.RejectUneconomicSwap(uneconomicSwap.StartTxHash, uneconomicSwap.Reason)
+	default:
+		http.Error(w, fmt.Sprintf("unknown action: %s", uneconomicSwap.Action), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, http.StatusOK, uneconomicSwap)
+}
+
+// IntegritySweepHandler verifies the record hashes of all the swaps, the failed swaps are quarantined if it is set
+func (admin *Admin) IntegritySweepHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := admin.checkAuth(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var integritySweep integritySweepRequest
+	err = json.Unmarshal(reqBody, &integritySweep)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, http.StatusOK, admin.swapEngine.SweepIntegrity(integritySweep.Quarantine))
+}
+
+// IntegrityReportHandler returns the report of the latest integrity sweep
+func (admin *Admin) IntegrityReportHandler(w http.ResponseWriter, r *http.Request) {
+	report := admin.swapEngine.GetIntegrityReport()
+	if report == nil {
+		http.Error(w, "no integrity sweep has run", http.StatusNotFound)
+		return
+	}
+	writeJson(w, http.StatusOK, report)
+}
+
+// QuarantinedSwapsHandler lists the swaps quarantined by the integrity sweep, the latest first
+func (admin *Admin) QuarantinedSwapsHandler(w http.ResponseWriter, r *http.Request) {
+	limit := DefaultListQuarantinedSwapsLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l <= 0 || l > MaxListQuarantinedSwapsLimit {
+			http.Error(w, fmt.Sprintf("limit should be between 1 and %d", MaxListQuarantinedSwapsLimit), http.StatusBadRequest)
+			return
+		}
+		limit = l
+	}
+
+	query := admin.DB.Order("id desc").Limit(limit)
+	if r.URL.Query().Get("reviewed") != "true" {
+		query = query.Where("reviewed = ?", false)
+	}
+	swaps := make([]model.QuarantinedSwap, 0)
+	if err := query.Find(&swaps).Error; err != nil {
+		http.Error(w, fmt.Sprintf("query quarantined swaps error, err=%s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, http.StatusOK, swaps)
+}
+
+// ImportSwapsHandler imports a batch of the swaps of the dump of a previous bridge deployment
+func (admin *Admin) ImportSwapsHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := admin.checkAuth(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var importSwaps importSwapsRequest
+	err = json.Unmarshal(reqBody, &importSwaps)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if importSwaps.Source == "" {
+		http.Error(w, "source is required", http.StatusBadRequest)
+		return
+	}
+	if len(importSwaps.Swaps) == 0 || len(importSwaps.Swaps) > MaxImportSwaps {
+		http.Error(w, fmt.Sprintf("swaps should be between 1 and %d", MaxImportSwaps), http.StatusBadRequest)
+		return
+	}
+
+	report, err := admin.swapEngine.ImportSwaps(importSwaps.Source, importSwaps.Swaps)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("import swaps error, imported %d, err=%s", report.Imported, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, http.StatusOK, report)
+}
+
+// LiquidityHandler returns the liquidity of the swap agents on the destination chains
+func (admin *Admin) LiquidityHandler(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, admin.swapEngine.GetLiquidity())
+}
+
+// RelayersHandler returns the balances of the relayer accounts of the destination chains
+func (admin *Admin) RelayersHandler(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, admin.swapEngine.GetRelayerAccounts())
+}
+
+// DrainBrakeHandler returns the chains halted by the drain brake and the hourly spend of the relayer accounts
+func (admin *Admin) DrainBrakeHandler(w http.ResponseWriter, r *http.Request) {
+	status, err := admin.swapEngine.GetDrainBrakeStatus()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("query drain brake error, err=%s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, http.StatusOK, status)
+}
+
+// ReleaseDrainBrakeHandler resumes the fills to a chain halted by the drain brake, once the spend of its relayer
+// accounts is explained
+func (admin *Admin) ReleaseDrainBrakeHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := admin.checkAuth(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var release releaseDrainBrakeRequest
+	err = json.Unmarshal(reqBody, &release)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if release.Operator == "" {
+		release.Operator = callerName(r)
+	}
+
+	if err := admin.swapEngine.ReleaseDrainBrake(release.Chain, release.Operator); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, http.StatusOK, release)
+}
+
+// DaemonsHandler returns the last runs and errors of the daemons of the swap engine
+func (admin *Admin) DaemonsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, admin.swapEngine.GetDaemonStatus())
+}
+
+// NonceReconciliationsHandler returns how the nonce gaps of the relayer accounts are filled on startup
+func (admin *Admin) NonceReconciliationsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, admin.swapEngine.GetNonceReconciliations())
+}
+
+// MarkSwapFilledHandler attaches a fill tx sent from another wallet to the swap and marks the swap as success
+func (admin *Admin) MarkSwapFilledHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := admin.checkAuth(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var markSwapFilled markSwapFilledRequest
+	err = json.Unmarshal(reqBody, &markSwapFilled)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if markSwapFilled.Operator == "" {
+		http.Error(w, "operator should not be empty", http.StatusBadRequest)
+		return
+	}
+
+	err = admin.swapEngine.MarkSwapFilled(markSwapFilled.StartTxHash, markSwapFilled.FillTxHash, markSwapFilled.Operator)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, http.StatusOK, markSwapFilled)
+}
@@ -0,0 +1,69 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	cmm "occ-swap-server/common"
+	"occ-swap-server/model"
+	"occ-swap-server/swap"
+)
+
+// OverviewHandler summarizes the state of the bridge in one call for the ops dashboards and the status pages
+func (admin *Admin) OverviewHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	overview := overviewResponse{
+		PendingCounts:    make(map[cmm.SwapStatus]int, len(pendingSwapStatuses)),
+		Relayers:         admin.swapEngine.GetRelayerAccounts(),
+		PausedDirections: admin.swapEngine.GetPausedDirections(),
+		PausedPairs:      make([]model.PausedPair, 0),
+	}
+
+	err := admin.DB.Model(model.Swap{}).Where("created_at >= ?", today).Count(&overview.TodayCount).Error
+	if err == nil {
+		err = admin.DB.Model(model.Swap{}).Where("created_at >= ? and status = ?", today, swap.SwapSuccess).
+			Count(&overview.TodaySuccessCount).Error
+	}
+	if err == nil {
+		err = admin.DB.Model(model.Swap{}).Where("created_at >= ? and status in (?)", today, failedSwapStatuses).
+			Count(&overview.TodayFailedCount).Error
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("query swaps error, err=%s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if finished := overview.TodaySuccessCount + overview.TodayFailedCount; finished > 0 {
+		overview.FailureRate = float64(overview.TodayFailedCount) / float64(finished)
+	}
+
+	var statusCounts []struct {
+		Status cmm.SwapStatus
+		Count  int
+	}
+	err = admin.DB.Model(model.Swap{}).Select("status, count(*) as count").Where("status in (?)", pendingSwapStatuses).
+		Group("status").Scan(&statusCounts).Error
+	if err != nil {
+		http.Error(w, fmt.Sprintf("query swaps error, err=%s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	for _, status := range pendingSwapStatuses {
+		overview.PendingCounts[status] = 0
+	}
+	for _, statusCount := range statusCounts {
+		overview.PendingCounts[statusCount.Status] = statusCount.Count
+	}
+
+	var oldest model.Swap
+	if err := admin.DB.Where("status in (?)", pendingSwapStatuses).Order("id asc").First(&oldest).Error; err == nil {
+		overview.OldestPendingAge = int64(now.Sub(oldest.CreatedAt) / time.Second)
+		overview.OldestPendingStartTxHash = oldest.StartTxHash
+	}
+
+	if err := admin.DB.Order("id asc").Find(&overview.PausedPairs).Error; err != nil {
+		http.Error(w, fmt.Sprintf("query paused pairs error, err=%s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, http.StatusOK, overview)
+}
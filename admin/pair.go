@@ -0,0 +1,216 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/mux"
+
+	"occ-swap-server/model"
+	"occ-swap-server/swap"
+	"occ-swap-server/util"
+)
+
+var peggedTokenDeploymentParam = apiParam{Name: "id", In: "path", Type: "integer", Required: true, Description: "id of the pegged token deployment"}
+
+type migrateSwapPairResponse struct {
+	SwapPair      *model.SwapPair      `json:"swap_pair"`
+	PairMigration *model.PairMigration `json:"pair_migration"`
+}
+
+type registerSwapPairResponse struct {
+	SwapPair              *model.SwapPair              `json:"swap_pair,omitempty"`
+	PeggedTokenDeployment *model.PeggedTokenDeployment `json:"pegged_token_deployment,omitempty"`
+}
+
+func registerCheck(register *registerSwapPairRequest) error {
+	for name, addr := range map[string]string{"sponsor": register.Sponsor, "bep20_addr": register.BEP20Addr, "erc20_addr": register.ERC20Addr} {
+		if addr != "" && !common.IsHexAddress(addr) {
+			return fmt.Errorf("invalid %s: %s", name, addr)
+		}
+	}
+	if register.Symbol == "" || register.Name == "" {
+		return fmt.Errorf("symbol and name can't be empty")
+	}
+	lowerBound, ok := big.NewInt(0).SetString(register.LowerBound, 10)
+	if !ok || lowerBound.Sign() < 0 {
+		return fmt.Errorf("invalid lowerBound amount: %s", register.LowerBound)
+	}
+	upperBound, ok := big.NewInt(0).SetString(register.UpperBound, 10)
+	if !ok || upperBound.Cmp(lowerBound) < 0 {
+		return fmt.Errorf("invalid upperBound amount: %s", register.UpperBound)
+	}
+	if len(register.IconUrl) > MaxIconUrlLength {
+		return fmt.Errorf("icon length exceed limit")
+	}
+	return nil
+}
+
+// RegisterSwapPairHandler adds a swap pair. If the token of the pair on pegged_token_chain doesn't exist yet, it is
+// deployed by the relayer with the pegged token factory, the deployment is returned and the swap pair is saved once
+// the deploy tx is confirmed. The fill methods of the pair are checked against the code of the swap agents. The pair
+// owners can't add the pairs.
+func (admin *Admin) RegisterSwapPairHandler(w http.ResponseWriter, r *http.Request) {
+	authorized, err := getAuthorizedRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	caller, reqBody := authorized.principal, authorized.payload
+	if caller.isPairOwner() {
+		http.Error(w, fmt.Sprintf("permission denied, %s can't register swap pairs", caller.name), http.StatusForbidden)
+		return
+	}
+
+	var register registerSwapPairRequest
+	if err := json.Unmarshal(reqBody, &register); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := registerCheck(&register); err != nil {
+		http.Error(w, fmt.Sprintf("parameters is invalid, %v", err), http.StatusBadRequest)
+		return
+	}
+	sponsor := ""
+	if register.Sponsor != "" {
+		sponsor = common.HexToAddress(register.Sponsor).String()
+	}
+
+	fillMethods := make([]swap.FillMethodRequest, 0, len(register.FillMethods))
+	for _, fillMethod := range register.FillMethods {
+		fillMethods = append(fillMethods, swap.FillMethodRequest{
+			Chain:  fillMethod.Chain,
+			Method: fillMethod.Method,
+			Abi:    fillMethod.Abi,
+			Args:   fillMethod.Args,
+		})
+	}
+	swapPair, deployment, err := admin.swapEngine.RegisterSwapPair(swap.RegisterSwapPairRequest{
+		Sponsor:          sponsor,
+		BEP20Addr:        register.BEP20Addr,
+		ERC20Addr:        register.ERC20Addr,
+		Symbol:           register.Symbol,
+		Name:             register.Name,
+		Decimals:         register.Decimals,
+		LowBound:         register.LowerBound,
+		UpperBound:       register.UpperBound,
+		IconUrl:          register.IconUrl,
+		Available:        register.Available,
+		PeggedTokenChain: strings.ToUpper(register.PeggedTokenChain),
+		FillMethods:      fillMethods,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if deployment != nil {
+		util.Logger.Infof("swap pair %s is registered by %s, its pegged token on %s is being deployed", register.Symbol, caller.name, deployment.Chain)
+		writeJson(w, http.StatusAccepted, registerSwapPairResponse{PeggedTokenDeployment: deployment})
+		return
+	}
+	util.Logger.Infof("swap pair %s is registered by %s, bep20 address %s, erc20 address %s", swapPair.Symbol, caller.name,
+		swapPair.BEP20Addr, swapPair.ERC20Addr)
+	writeJson(w, http.StatusOK, registerSwapPairResponse{SwapPair: swapPair})
+}
+
+// PeggedTokenDeploymentHandler returns the progress of a pegged token deployment, the token is set once it is
+// deployed and the swap pair is saved
+func (admin *Admin) PeggedTokenDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	deployment := model.PeggedTokenDeployment{}
+	if admin.DB.Where("id = ?", mux.Vars(r)["id"]).First(&deployment).RecordNotFound() {
+		http.Error(w, "pegged token deployment not found", http.StatusNotFound)
+		return
+	}
+	writeJson(w, http.StatusOK, deployment)
+}
+
+// DeleteSwapPairHandler deletes a swap pair which no swap references, the pairs with swaps are disabled by
+// update_swap_pair instead so their swaps keep their metadata. The pair owners can't delete the pairs.
+func (admin *Admin) DeleteSwapPairHandler(w http.ResponseWriter, r *http.Request) {
+	authorized, err := getAuthorizedRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	caller, reqBody := authorized.principal, authorized.payload
+	if caller.isPairOwner() {
+		http.Error(w, fmt.Sprintf("permission denied, %s can't delete swap pairs", caller.name), http.StatusForbidden)
+		return
+	}
+
+	var deleteSwapPair deleteSwapPairRequest
+	if err := json.Unmarshal(reqBody, &deleteSwapPair); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	erc20Addr, err := model.NormalizeAddress("erc20_addr", deleteSwapPair.ERC20Addr)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("parameters is invalid, %v", err), http.StatusBadRequest)
+		return
+	}
+
+	swapPair, err := admin.swapEngine.DeleteSwapPair(common.HexToAddress(erc20Addr))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	util.Logger.Infof("swap pair %s is deleted by %s", swapPair.Symbol, caller.name)
+	writeJson(w, http.StatusOK, swapPair)
+}
+
+// MigrateSwapPairHandler moves a paused swap pair to new token contracts when a token migrates, the unfilled and
+// the failed swaps of the pair fill the new tokens. The pair owners can't migrate the pairs.
+func (admin *Admin) MigrateSwapPairHandler(w http.ResponseWriter, r *http.Request) {
+	authorized, err := getAuthorizedRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	caller, reqBody := authorized.principal, authorized.payload
+	if caller.isPairOwner() {
+		http.Error(w, fmt.Sprintf("permission denied, %s can't migrate swap pairs", caller.name), http.StatusForbidden)
+		return
+	}
+
+	var migrate migrateSwapPairRequest
+	if err := json.Unmarshal(reqBody, &migrate); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for name, addr := range map[string]string{"erc20_addr": migrate.ERC20Addr, "new_bep20_addr": migrate.NewBEP20Addr, "new_erc20_addr": migrate.NewERC20Addr} {
+		if !common.IsHexAddress(addr) {
+			http.Error(w, fmt.Sprintf("parameters is invalid, invalid %s: %s", name, addr), http.StatusBadRequest)
+			return
+		}
+	}
+	if migrate.Operator == "" {
+		migrate.Operator = caller.name
+	}
+
+	migration, swapPair, err := admin.swapEngine.MigrateSwapPair(swap.MigrateSwapPairRequest{
+		OldERC20Addr: migrate.ERC20Addr,
+		BEP20Addr:    migrate.NewBEP20Addr,
+		ERC20Addr:    migrate.NewERC20Addr,
+		Reason:       migrate.Reason,
+		Operator:     migrate.Operator,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, http.StatusOK, migrateSwapPairResponse{SwapPair: swapPair, PairMigration: migration})
+}
+
+// PairMigrationsHandler returns the migrations of the swap pairs, the latest first
+func (admin *Admin) PairMigrationsHandler(w http.ResponseWriter, r *http.Request) {
+	migrations := make([]model.PairMigration, 0)
+	if err := admin.DB.Order("id desc").Find(&migrations).Error; err != nil {
+		http.Error(w, fmt.Sprintf("query pair migrations error, err=%s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, http.StatusOK, migrations)
+}
@@ -0,0 +1,113 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"occ-swap-server/swap"
+)
+
+// Permit2ParamsHandler returns the fields of the permit2 transfer the owner signs to start a swap in one tx, the
+// approve tx of permit2 is included if the allowance is not enough
+func (admin *Admin) Permit2ParamsHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var paramsReq permit2ParamsRequest
+	if err := json.Unmarshal(reqBody, &paramsReq); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !common.IsHexAddress(paramsReq.Pair) {
+		http.Error(w, fmt.Sprintf("invalid pair: %s", paramsReq.Pair), http.StatusBadRequest)
+		return
+	}
+	if !common.IsHexAddress(paramsReq.Owner) {
+		http.Error(w, fmt.Sprintf("invalid owner: %s", paramsReq.Owner), http.StatusBadRequest)
+		return
+	}
+	amount, ok := big.NewInt(0).SetString(paramsReq.Amount, 10)
+	if !ok || amount.Sign() <= 0 {
+		http.Error(w, fmt.Sprintf("invalid amount: %s", paramsReq.Amount), http.StatusBadRequest)
+		return
+	}
+	if paramsReq.ToChainId == "" {
+		http.Error(w, "to_chain_id should not be empty", http.StatusBadRequest)
+		return
+	}
+
+	params, err := admin.swapEngine.GetPermit2Params(strings.ToUpper(paramsReq.Chain), common.HexToAddress(paramsReq.Pair),
+		amount, paramsReq.ToChainId, common.HexToAddress(paramsReq.Owner))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, http.StatusOK, params)
+}
+
+// BuildPermit2SwapTxHandler returns the swapWithPermit2 tx the wallet of the owner sends to start a swap with the
+// signed permit2 transfer, the swap is observed once the tx is mined
+func (admin *Admin) BuildPermit2SwapTxHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var build permit2SwapTxRequest
+	if err := json.Unmarshal(reqBody, &build); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !common.IsHexAddress(build.Pair) {
+		http.Error(w, fmt.Sprintf("invalid pair: %s", build.Pair), http.StatusBadRequest)
+		return
+	}
+	if !common.IsHexAddress(build.Owner) {
+		http.Error(w, fmt.Sprintf("invalid owner: %s", build.Owner), http.StatusBadRequest)
+		return
+	}
+	amount, ok := big.NewInt(0).SetString(build.Amount, 10)
+	if !ok || amount.Sign() <= 0 {
+		http.Error(w, fmt.Sprintf("invalid amount: %s", build.Amount), http.StatusBadRequest)
+		return
+	}
+	nonce, ok := big.NewInt(0).SetString(build.Nonce, 10)
+	if !ok {
+		http.Error(w, fmt.Sprintf("invalid nonce: %s", build.Nonce), http.StatusBadRequest)
+		return
+	}
+	if build.ToChainId == "" {
+		http.Error(w, "to_chain_id should not be empty", http.StatusBadRequest)
+		return
+	}
+	signature, err := hexutil.Decode(build.Signature)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid signature: %s", build.Signature), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := admin.swapEngine.BuildPermit2SwapTx(swap.Permit2SwapRequest{
+		Chain:     strings.ToUpper(build.Chain),
+		Pair:      common.HexToAddress(build.Pair),
+		Owner:     common.HexToAddress(build.Owner),
+		ToChainId: build.ToChainId,
+		Amount:    amount,
+		Nonce:     nonce,
+		Deadline:  build.Deadline,
+		Signature: signature,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, http.StatusOK, tx)
+}
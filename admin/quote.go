@@ -0,0 +1,200 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jinzhu/gorm"
+
+	"occ-swap-server/model"
+	"occ-swap-server/swap"
+	"occ-swap-server/util"
+)
+
+var quoteParams = []apiParam{
+	{Name: "pair", In: "query", Type: "string", Pattern: "^0x[0-9a-fA-F]{40}$", Required: true, Description: "erc20 address of the swap pair"},
+	{Name: "amount", In: "query", Type: "string", Pattern: "^\\d+$", Required: true, Description: "amount to swap in the smallest unit of the token"},
+	{Name: "from", In: "query", Type: "string", Required: true, Description: "chain the swap is started on, BSC, ETH or CRO"},
+	{Name: "to", In: "query", Type: "string", Pattern: "^\\d+$", Required: true, Description: "chain id of the destination chain"},
+	{Name: "sponsor", In: "query", Type: "string", Pattern: "^0x[0-9a-fA-F]{40}$", Description: "sponsor address, the fee rebate of its tier is quoted"},
+}
+
+// QuoteHandler estimates the bridge fee, the destination gas and the eta of a swap for the frontends to show
+// before the users start it
+func (admin *Admin) QuoteHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	pair := query.Get("pair")
+	if !common.IsHexAddress(pair) {
+		http.Error(w, fmt.Sprintf("invalid pair: %s", pair), http.StatusBadRequest)
+		return
+	}
+	amount, ok := big.NewInt(0).SetString(query.Get("amount"), 10)
+	if !ok || amount.Sign() <= 0 {
+		http.Error(w, fmt.Sprintf("invalid amount: %s", query.Get("amount")), http.StatusBadRequest)
+		return
+	}
+	toChainId := query.Get("to")
+	if toChainId == "" {
+		http.Error(w, "to should not be empty", http.StatusBadRequest)
+		return
+	}
+
+	sponsor := query.Get("sponsor")
+	if sponsor != "" && !common.IsHexAddress(sponsor) {
+		http.Error(w, fmt.Sprintf("invalid sponsor: %s", sponsor), http.StatusBadRequest)
+		return
+	}
+
+	quote, err := admin.swapEngine.QuoteSwap(strings.ToUpper(query.Get("from")), common.HexToAddress(pair), amount, toChainId, sponsor)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, http.StatusOK, quote)
+}
+
+// BuildSwapTxHandler returns the txs the wallet of the owner sends to start a swap, the approve tx of the swap agent
+// is included if the allowance is not enough
+func (admin *Admin) BuildSwapTxHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var build buildSwapTxRequest
+	if err := json.Unmarshal(reqBody, &build); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !common.IsHexAddress(build.Pair) {
+		http.Error(w, fmt.Sprintf("invalid pair: %s", build.Pair), http.StatusBadRequest)
+		return
+	}
+	if !common.IsHexAddress(build.Owner) {
+		http.Error(w, fmt.Sprintf("invalid owner: %s", build.Owner), http.StatusBadRequest)
+		return
+	}
+	amount, ok := big.NewInt(0).SetString(build.Amount, 10)
+	if !ok || amount.Sign() <= 0 {
+		http.Error(w, fmt.Sprintf("invalid amount: %s", build.Amount), http.StatusBadRequest)
+		return
+	}
+	if build.ToChainId == "" {
+		http.Error(w, "to_chain_id should not be empty", http.StatusBadRequest)
+		return
+	}
+
+	txs, err := admin.swapEngine.BuildSwapTxs(strings.ToUpper(build.Chain), common.HexToAddress(build.Pair), amount,
+		build.ToChainId, common.HexToAddress(build.Owner), build.Memo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, http.StatusOK, txs)
+}
+
+// StatusHandler returns the pause status and the latency percentiles of the enabled swap directions
+func (admin *Admin) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	statuses, err := admin.swapEngine.GetDirectionStatuses()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, http.StatusOK, statuses)
+}
+
+// StatusPageHandler returns the sanitized status of the bridge for the website, the balances and the accounts
+// are never included
+func (admin *Admin) StatusPageHandler(w http.ResponseWriter, r *http.Request) {
+	statuses, err := admin.swapEngine.GetDirectionStatuses()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page := statusPageResponse{
+		Status:     swap.DirectionOperational,
+		Directions: make([]statusPageDirection, 0, len(statuses)),
+		UpdateTime: time.Now().Unix(),
+	}
+	for _, status := range statuses {
+		page.Directions = append(page.Directions, statusPageDirection{
+			Direction:  status.Direction,
+			Status:     status.State,
+			Reason:     status.Reason,
+			EtaSeconds: status.EtaSeconds,
+		})
+		if statusSeverity(status.State) > statusSeverity(page.Status) {
+			page.Status = status.State
+		}
+	}
+
+	var incident model.Incident
+	if err := admin.DB.Order("id desc").First(&incident).Error; err == nil {
+		page.Incident = &statusPageIncident{Note: incident.Note, Since: incident.CreatedAt.Unix()}
+	} else if err != gorm.ErrRecordNotFound {
+		http.Error(w, fmt.Sprintf("query incident error, err=%s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, http.StatusOK, page)
+}
+
+func statusSeverity(status string) int {
+	switch status {
+	case swap.DirectionPaused:
+		return 2
+	case swap.DirectionDegraded:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// IncidentHandler sets the incident note of the status page, an empty note resolves the active incident
+func (admin *Admin) IncidentHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := admin.checkAuth(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var incident incidentRequest
+	err = json.Unmarshal(reqBody, &incident)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if incident.Operator == "" {
+		incident.Operator = callerName(r)
+	}
+
+	err = func() error {
+		tx := admin.DB.Begin()
+		if err := tx.Error; err != nil {
+			return err
+		}
+		if err := tx.Where("deleted_at is null").Delete(model.Incident{}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		if incident.Note != "" {
+			if err := tx.Create(&model.Incident{Note: incident.Note, Operator: incident.Operator}).Error; err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		return tx.Commit().Error
+	}()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("save incident error, err=%s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	util.Logger.Infof("incident note of the status page set by %s: %s", incident.Operator, incident.Note)
+	writeJson(w, http.StatusOK, incident)
+}
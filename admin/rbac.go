@@ -0,0 +1,111 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"occ-swap-server/util"
+)
+
+// Role of an admin user, the role grants the permissions of the endpoints
+type Role string
+
+const (
+	// RoleViewer reads the swaps and the state of the engine
+	RoleViewer Role = "viewer"
+	// RoleOperator intervenes in the swaps, e.g. pauses, retries and backfills
+	RoleOperator Role = "operator"
+	// RoleSecurity audits the swaps and manages the users
+	RoleSecurity Role = "security"
+	// RoleAdmin has all the permissions
+	RoleAdmin Role = "admin"
+)
+
+// Permission is required by an endpoint, the endpoints without a permission are public
+type Permission string
+
+const (
+	PermissionRead        Permission = "read"
+	PermissionOperate     Permission = "operate"
+	PermissionAudit       Permission = "audit"
+	PermissionManage      Permission = "manage"
+	PermissionManageUsers Permission = "manage_users"
+	// PermissionManagePairs is also granted to the pair owners for their own pairs and webhooks, see canManagePair
+	PermissionManagePairs Permission = "manage_pairs"
+)
+
+var rolePermissions = map[Role]map[Permission]bool{
+	RoleViewer:   {PermissionRead: true},
+	RoleOperator: {PermissionRead: true, PermissionOperate: true},
+	RoleSecurity: {PermissionRead: true, PermissionAudit: true, PermissionManageUsers: true},
+	RoleAdmin: {
+		PermissionRead: true, PermissionOperate: true, PermissionAudit: true, PermissionManage: true,
+		PermissionManageUsers: true, PermissionManagePairs: true,
+	},
+}
+
+func isRole(role Role) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// canGrant returns whether the role has all the permissions of the granted role, so no user can grant more than it has
+func canGrant(role, granted Role) bool {
+	for permission := range rolePermissions[granted] {
+		if !rolePermissions[role][permission] {
+			return false
+		}
+	}
+	return true
+}
+
+type authKey struct{}
+
+// authorizedRequest is the caller and the body of a request authorized by withPermission
+type authorizedRequest struct {
+	principal *principal
+	payload   []byte
+}
+
+// withPermission authenticates the request and checks that the caller has the permission of the route, the caller
+// and the body are kept in the context for the handler, see checkAuth
+func (admin *Admin) withPermission(route apiRoute, next http.HandlerFunc) http.HandlerFunc {
+	if route.Permission == "" {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, payload, err := admin.authenticate(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if !p.can(route.Permission) {
+			http.Error(w, fmt.Sprintf("permission denied, %s has no %s permission", p.name, route.Permission), http.StatusForbidden)
+			return
+		}
+		if route.Method != http.MethodGet {
+			util.Logger.Infof("%s %s is called by %s", route.Method, route.Path, p.name)
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(payload))
+		next(w, r.WithContext(context.WithValue(r.Context(), authKey{}, &authorizedRequest{principal: p, payload: payload})))
+	}
+}
+
+func getAuthorizedRequest(r *http.Request) (*authorizedRequest, error) {
+	authorized, ok := r.Context().Value(authKey{}).(*authorizedRequest)
+	if !ok {
+		return nil, fmt.Errorf("request is not authenticated")
+	}
+	return authorized, nil
+}
+
+// callerName is the name of the caller of the authorized request, it is recorded as the operator of the actions
+func callerName(r *http.Request) string {
+	if authorized, err := getAuthorizedRequest(r); err == nil {
+		return authorized.principal.name
+	}
+	return "admin"
+}
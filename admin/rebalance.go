@@ -0,0 +1,99 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"occ-swap-server/swap"
+)
+
+const (
+	DefaultListRebalancePlansLimit = 20
+	MaxListRebalancePlansLimit     = 200
+
+	RebalancePlanApprove = "approve"
+	RebalancePlanReject  = "reject"
+	// the approved plan is executed by hand, without auto_execute
+	RebalancePlanExecuted = "executed"
+)
+
+// RebalancePlansHandler returns the inventory of the chains against their targets and the latest rebalance plans
+func (admin *Admin) RebalancePlansHandler(w http.ResponseWriter, r *http.Request) {
+	limit := DefaultListRebalancePlansLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l <= 0 || l > MaxListRebalancePlansLimit {
+			http.Error(w, fmt.Sprintf("limit should be between 1 and %d", MaxListRebalancePlansLimit), http.StatusBadRequest)
+			return
+		}
+		limit = l
+	}
+
+	plans, err := admin.swapEngine.GetRebalancePlans(limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("query rebalance plans error, err=%s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	resp := rebalancePlansResponse{Plans: plans}
+	// the plans are kept when the inventory is unknown, e.g. before the first run of the liquidity daemon
+	if resp.Inventory, err = admin.swapEngine.GetInventory(); err != nil {
+		resp.ErrMsg = err.Error()
+	}
+	writeJson(w, http.StatusOK, resp)
+}
+
+// ProposeRebalancePlanHandler plans the transfers from the current inventory at once, the plan is pending until
+// another operator approves it
+func (admin *Admin) ProposeRebalancePlanHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := admin.checkAuth(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	plan, err := admin.swapEngine.ProposeRebalancePlan(callerName(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if plan == nil {
+		http.Error(w, "the inventory is within the threshold of the targets, no transfer is needed", http.StatusBadRequest)
+		return
+	}
+	writeJson(w, http.StatusOK, plan)
+}
+
+// RebalancePlanHandler approves or rejects a pending rebalance plan, or marks an approved plan executed by hand
+func (admin *Admin) RebalancePlanHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := admin.checkAuth(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var update rebalancePlanRequest
+	err = json.Unmarshal(reqBody, &update)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var plan *swap.RebalancePlanDetail
+	switch update.Action {
+	case RebalancePlanApprove:
+		plan, err = admin.swapEngine.ApproveRebalancePlan(update.ID, callerName(r))
+	case RebalancePlanReject:
+		plan, err = admin.swapEngine.RejectRebalancePlan(update.ID, callerName(r), update.Reason)
+	case RebalancePlanExecuted:
+		plan, err = admin.swapEngine.MarkRebalancePlanExecuted(update.ID, callerName(r))
+	default:
+		http.Error(w, fmt.Sprintf("unknown action: %s", update.Action), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, http.StatusOK, plan)
+}
@@ -0,0 +1,96 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/gorilla/mux"
+
+	"occ-swap-server/model"
+	"occ-swap-server/swap"
+)
+
+var (
+	relayChainParam  = apiParam{Name: "chain", In: "query", Type: "string", Required: true, Description: "chain the swap is started on, BSC, ETH or CRO"}
+	ownerParam       = apiParam{Name: "owner", In: "query", Type: "string", Pattern: "^0x[0-9a-fA-F]{40}$", Required: true, Description: "owner of the tokens"}
+	relayedSwapParam = apiParam{Name: "id", In: "path", Type: "integer", Required: true, Description: "id of the relayed swap"}
+)
+
+// RelayParamsHandler returns the fields of the permit the owner signs for a relayed swap
+func (admin *Admin) RelayParamsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	owner := query.Get("owner")
+	if !common.IsHexAddress(owner) {
+		http.Error(w, fmt.Sprintf("invalid owner: %s", owner), http.StatusBadRequest)
+		return
+	}
+	params, err := admin.swapEngine.GetRelayParams(strings.ToUpper(query.Get("chain")), common.HexToAddress(owner))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, http.StatusOK, params)
+}
+
+// RelaySwapHandler saves a swap started by the relayer with the permit signed by the owner, the owner pays no gas
+func (admin *Admin) RelaySwapHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var relaySwap relaySwapRequest
+	if err := json.Unmarshal(reqBody, &relaySwap); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !common.IsHexAddress(relaySwap.Owner) {
+		http.Error(w, fmt.Sprintf("invalid owner: %s", relaySwap.Owner), http.StatusBadRequest)
+		return
+	}
+	amount, ok := big.NewInt(0).SetString(relaySwap.Amount, 10)
+	if !ok {
+		http.Error(w, fmt.Sprintf("invalid amount: %s", relaySwap.Amount), http.StatusBadRequest)
+		return
+	}
+	signature, err := hexutil.Decode(relaySwap.Signature)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid signature: %s", relaySwap.Signature), http.StatusBadRequest)
+		return
+	}
+
+	relayedSwap, err := admin.swapEngine.RelaySwap(swap.RelaySwapRequest{
+		Chain:     strings.ToUpper(relaySwap.Chain),
+		Owner:     common.HexToAddress(relaySwap.Owner),
+		ToChainId: relaySwap.ToChainId,
+		Amount:    amount,
+		Deadline:  relaySwap.Deadline,
+		Signature: signature,
+		RemoteIP:  remoteIP(r),
+	})
+	if err == swap.ErrTooManyRelayedSwaps {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, http.StatusOK, newRelayedSwapResponse(relayedSwap))
+}
+
+// RelayedSwapHandler returns the progress of a relayed swap, the swap is found by the start tx hash once it is started
+func (admin *Admin) RelayedSwapHandler(w http.ResponseWriter, r *http.Request) {
+	relayedSwap := model.RelayedSwap{}
+	if admin.DB.Where("id = ?", mux.Vars(r)["id"]).First(&relayedSwap).RecordNotFound() {
+		http.Error(w, "relayed swap not found", http.StatusNotFound)
+		return
+	}
+	writeJson(w, http.StatusOK, newRelayedSwapResponse(&relayedSwap))
+}
@@ -3,7 +3,6 @@ package admin
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"math/big"
 	"net/http"
 	"strings"
@@ -12,9 +11,11 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/gorilla/mux"
 	"github.com/jinzhu/gorm"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	cmm "occ-swap-server/common"
 	"occ-swap-server/model"
+	"occ-swap-server/observer"
 	"occ-swap-server/swap"
 	"occ-swap-server/util"
 )
@@ -22,6 +23,10 @@ import (
 const (
 	DefaultListenAddr = "0.0.0.0:8080"
 
+	// ReadTimeout bounds the reads of the requests of the admin api, WriteTimeout the responses other than the streamed ones
+	ReadTimeout  = 3 * time.Second
+	WriteTimeout = 3 * time.Second
+
 	MaxIconUrlLength = 400
 )
 
@@ -31,22 +36,30 @@ type Admin struct {
 	cfg *util.Config
 
 	hmacSigner *util.HmacSigner
+	// signs the tokens issued to the admin users, the key is the admin secret of the key manager
+	jwtSigner  *util.JWTSigner
 	swapEngine *swap.SwapEngine
+	// key is the chain name
+	observers map[string]*observer.Observer
 }
 
-func NewAdmin(config *util.Config, db *gorm.DB, signer *util.HmacSigner, swapEngine *swap.SwapEngine) *Admin {
+func NewAdmin(config *util.Config, db *gorm.DB, signer *util.HmacSigner, swapEngine *swap.SwapEngine, observers map[string]*observer.Observer) *Admin {
 	return &Admin{
 		DB:         db,
 		cfg:        config,
 		hmacSigner: signer,
+		jwtSigner:  util.NewJWTSigner(signer.SecretKey),
 		swapEngine: swapEngine,
+		observers:  observers,
 	}
 }
 
 func updateCheck(update *updateSwapPairRequest) error {
-	if update.ERC20Addr == "" {
-		return fmt.Errorf("bsc_token_contract_addr can't be empty")
+	erc20Addr, err := model.NormalizeAddress("erc20_addr", update.ERC20Addr)
+	if err != nil {
+		return err
 	}
+	update.ERC20Addr = erc20Addr
 	if update.UpperBound != "" {
 		if _, ok := big.NewInt(0).SetString(update.UpperBound, 10); !ok {
 			return fmt.Errorf("invalid upperBound amount: %s", update.UpperBound)
@@ -60,15 +73,19 @@ func updateCheck(update *updateSwapPairRequest) error {
 	if len(update.IconUrl) > MaxIconUrlLength {
 		return fmt.Errorf("icon length exceed limit")
 	}
+	if update.AssetType != "" && update.AssetType != cmm.AssetTypeFungible && update.AssetType != cmm.AssetTypeERC721 {
+		return fmt.Errorf("invalid asset type: %s", update.AssetType)
+	}
 	return nil
 }
 
 func (admin *Admin) UpdateSwapPairHandler(w http.ResponseWriter, r *http.Request) {
-	reqBody, err := admin.checkAuth(r)
+	authorized, err := getAuthorizedRequest(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	caller, reqBody := authorized.principal, authorized.payload
 
 	var updateSwapPair updateSwapPairRequest
 	err = json.Unmarshal(reqBody, &updateSwapPair)
@@ -77,6 +94,11 @@ func (admin *Admin) UpdateSwapPairHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if !caller.canManagePair(updateSwapPair.ERC20Addr) {
+		http.Error(w, fmt.Sprintf("permission denied, %s is not the owner of swapPair %s", caller.name, updateSwapPair.ERC20Addr), http.StatusForbidden)
+		return
+	}
+
 	if err := updateCheck(&updateSwapPair); err != nil {
 		http.Error(w, fmt.Sprintf("parameters is invalid, %v", err), http.StatusBadRequest)
 		return
@@ -90,7 +112,8 @@ func (admin *Admin) UpdateSwapPairHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	toUpdate := map[string]interface{}{
-		"available": updateSwapPair.Available,
+		"available":   updateSwapPair.Available,
+		"nft_enabled": updateSwapPair.NFTEnabled,
 	}
 
 	if updateSwapPair.LowerBound != "" {
@@ -102,6 +125,9 @@ func (admin *Admin) UpdateSwapPairHandler(w http.ResponseWriter, r *http.Request
 	if updateSwapPair.IconUrl != "" {
 		toUpdate["icon_url"] = updateSwapPair.IconUrl
 	}
+	if updateSwapPair.AssetType != "" {
+		toUpdate["asset_type"] = updateSwapPair.AssetType
+	}
 
 	err = admin.DB.Model(model.SwapPair{}).Where("erc20_addr = ?", updateSwapPair.ERC20Addr).Updates(toUpdate).Error
 	if err != nil {
@@ -118,9 +144,8 @@ func (admin *Admin) UpdateSwapPairHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	swapPairIns, err := admin.swapEngine.GetSwapPairInstance(common.HexToAddress(updateSwapPair.ERC20Addr))
-	// disable is only for frontend, do not affect backend
-	// if we want to disable it in backend, set the low_bound and upper_bound to be zero
-	if err != nil && updateSwapPair.Available {
+	// an unavailable pair is only disabled, its new swaps are rejected while its in-flight swaps are still filled
+	if err != nil {
 		// add swapPair in swapper
 		err = admin.swapEngine.AddSwapPairInstance(&swapPair)
 		if err != nil {
@@ -152,7 +177,63 @@ func (admin *Admin) Endpoints(w http.ResponseWriter, r *http.Request) {
 	}{
 		Endpoints: []string{
 			"/update_swap_pair",
+			"/register_swap_pair",
+			"/swap_pairs",
+			"/migrate_swap_pair",
+			"/pair_migrations",
+			"/pegged_token_deployments/{id}",
 			"/healthz",
+			"/api/v1/address/{addr}/summary",
+			"/api/v1/quote",
+			"/api/v1/build-swap-tx",
+			"/api/v1/status",
+			"/api/v1/status_page",
+			"/incident",
+			"/swaps",
+			"/swaps/{start_tx_hash}/timeline",
+			"/swaps/{start_tx_hash}/proof",
+			"/archived_swaps/{start_tx_hash}",
+			"/retry_failed_swaps",
+			"/pause_direction",
+			"/pause_pair",
+			"/paused_pairs",
+			"/rebroadcast_fill_tx",
+			"/backfill",
+			"/delayed_swap",
+			"/uneconomic_swap",
+			"/integrity_sweep",
+			"/quarantined_swaps",
+			"/import_swaps",
+			"/admin/overview",
+			"/liquidity",
+			"/relayers",
+			"/drain_brake",
+			"/rebalance_plans",
+			"/rebalance_plan",
+			"/api/v1/auth/challenge",
+			"/api/v1/sponsor/swaps/{start_tx_hash}",
+			"/api/v1/sponsor/swaps/{start_tx_hash}/cancel",
+			"/api/v1/sponsor/webhook",
+			"/api/v1/relay/params",
+			"/api/v1/relay/swaps",
+			"/api/v1/relay/swaps/{id}",
+			"/api/v1/permit2/params",
+			"/api/v1/permit2/build-swap-tx",
+			"/nonce_reconciliations",
+			"/daemons",
+			"/export",
+			"/fee_audit",
+			"/key_usages",
+			"/stats/hourly",
+			"/stats/daily",
+			"/mark_swap_filled",
+			"/pair_owners",
+			"/sponsor_tiers",
+			"/admin_users",
+			"/auth/token",
+			"/webhooks",
+			"/webhook_deliveries",
+			"/metrics",
 		},
 	}
 
@@ -198,6 +279,8 @@ func (admin *Admin) WithdrawToken(w http.ResponseWriter, r *http.Request) {
 		common.HexToAddress(withdrawToken.Recipient), amount)
 	if err != nil {
 		withdrawResp.ErrMsg = err.Error()
+	} else {
+		withdrawResp.TxUrl = admin.cfg.ExplorerLink(withdrawToken.Chain, util.ExplorerTx, withdrawResp.TxHash)
 	}
 
 	jsonBytes, err := json.MarshalIndent(withdrawResp, "", "    ")
@@ -269,47 +352,107 @@ func (admin *Admin) RetryFailedSwaps(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+)
+
+type healthResponse struct {
+	Status string             `json:"status"`
+	Chains []swap.ChainHealth `json:"chains"`
+}
+
+// Healthz reports the circuit breakers of the chain rpcs, the status is 200 even if a chain is degraded since the
+// process itself is alive
 func (admin *Admin) Healthz(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
+	resp := healthResponse{Status: HealthOK, Chains: make([]swap.ChainHealth, 0)}
+	if admin.swapEngine != nil {
+		resp.Chains = admin.swapEngine.GetChainHealth()
+	}
+	for _, chain := range resp.Chains {
+		if chain.State == swap.CircuitOpen {
+			resp.Status = HealthDegraded
+		}
+	}
+	writeJson(w, http.StatusOK, resp)
 }
 
-func (admin *Admin) checkAuth(r *http.Request) ([]byte, error) {
-	apiKey := r.Header.Get("ApiKey")
-	hash := r.Header.Get("Authorization")
+// Serve serves the admin api, the endpoints with a permission are only served on the secure listener and only to
+// the allowed ips, they are not served at all without the secure listener
+func (admin *Admin) Serve() {
+	cfg := admin.cfg.AdminConfig
+	router, secureRouter, err := admin.newRouters()
+	if err != nil {
+		panic(fmt.Sprintf("start admin server error, err=%s", err.Error()))
+	}
+
+	if secureRouter == nil {
+		util.Logger.Warningf("secure_listen_addr of admin_config is not set, the admin endpoints with a permission are not served")
+	} else {
+		go listenAndServeTLS(cfg, secureRouter)
+	}
+	listenAndServe(admin.cfg, router, cfg.GetStreamTimeout())
+}
 
-	payload, err := ioutil.ReadAll(r.Body)
+// newRouters returns the router of the public endpoints and the router of the endpoints with a permission, the
+// latter is nil without the secure listener
+func (admin *Admin) newRouters() (*mux.Router, *mux.Router, error) {
+	cfg := admin.cfg.AdminConfig
+	nets, err := cfg.GetAllowedNets()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	if admin.hmacSigner.ApiKey != apiKey {
-		return nil, fmt.Errorf("api key mismatch")
+	router := mux.NewRouter()
+	var secureRouter *mux.Router
+	if cfg.SecureListenAddr != "" {
+		secureRouter = mux.NewRouter()
+		secureRouter.Handle("/healthz", withWriteTimeout(http.HandlerFunc(admin.Healthz))).Methods(http.MethodGet)
 	}
 
-	if !admin.hmacSigner.Verify(payload, hash) {
-		return nil, fmt.Errorf("invalud auth")
+	for _, route := range admin.routes() {
+		handler := admin.withPermission(route, withValidation(route))
+		if !route.Stream {
+			handler = withWriteTimeout(handler).ServeHTTP
+		}
+		if route.Permission == "" {
+			router.HandleFunc(route.Path, handler).Methods(route.Method)
+		} else if secureRouter != nil {
+			secureRouter.HandleFunc(route.Path, withAllowedIPs(nets, handler)).Methods(route.Method)
+		}
 	}
-	return payload, nil
+	return router, secureRouter, nil
 }
 
-func (admin *Admin) Serve() {
+// ServeObserver serves the health check and the metrics of the observer processes, they have no admin api since
+// they have no swap engine
+func ServeObserver(cfg *util.Config) {
 	router := mux.NewRouter()
+	router.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}).Methods(http.MethodGet)
+	router.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
+
+	listenAndServe(cfg, router, WriteTimeout)
+}
 
-	router.HandleFunc("/", admin.Endpoints).Methods("GET")
-	router.HandleFunc("/healthz", admin.Healthz).Methods("GET")
-	router.HandleFunc("/update_swap_pair", admin.UpdateSwapPairHandler).Methods("PUT")
-	router.HandleFunc("/withdraw_token", admin.WithdrawToken).Methods("POST")
-	router.HandleFunc("/retry_failed_swaps", admin.RetryFailedSwaps).Methods("POST")
+// withWriteTimeout cuts the responses other than the streamed ones after the write timeout, the servers of the admin
+// api only time out the writes after the stream timeout so the exports are not cut
+func withWriteTimeout(handler http.Handler) http.Handler {
+	return http.TimeoutHandler(handler, WriteTimeout, "write timeout")
+}
 
+// listenAndServe serves the handler, the writes time out after writeTimeout
+func listenAndServe(cfg *util.Config, handler http.Handler, writeTimeout time.Duration) {
 	listenAddr := DefaultListenAddr
-	if admin.cfg.AdminConfig.ListenAddr != "" {
-		listenAddr = admin.cfg.AdminConfig.ListenAddr
+	if cfg.AdminConfig.ListenAddr != "" {
+		listenAddr = cfg.AdminConfig.ListenAddr
 	}
 	srv := &http.Server{
-		Handler:      router,
+		Handler:      handler,
 		Addr:         listenAddr,
-		WriteTimeout: 3 * time.Second,
-		ReadTimeout:  3 * time.Second,
+		WriteTimeout: writeTimeout,
+		ReadTimeout:  ReadTimeout,
 	}
 
 	util.Logger.Infof("start admin server at %s", srv.Addr)
@@ -0,0 +1,397 @@
+package admin
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+const (
+	SignatureTypeEIP191 = "eip191"
+	SignatureTypeEIP712 = "eip712"
+
+	// ChallengeExpiry is how long a challenge can be signed and used, it can only be used once
+	ChallengeExpiry = 5 * time.Minute
+	ChallengeLength = 16
+	// MaxChallenges is the number of the outstanding challenges, the oldest are evicted past it, and
+	// MaxChallengesPerIP the ones of a single ip, more are refused until some are used or expired
+	MaxChallenges      = 10000
+	MaxChallengesPerIP = 20
+
+	eip712DomainName    = "occ swap server"
+	eip712DomainVersion = "1"
+)
+
+var (
+	eip712DomainType         = "EIP712Domain(string name,string version)"
+	eip712AuthenticationType = "Authentication(address sponsor,string challenge,string action,bytes32 params)"
+
+	sponsorParam = apiParam{Name: "sponsor", In: "query", Type: "string", Pattern: "^0x[0-9a-fA-F]{40}$", Required: true, Description: "sponsor address"}
+	actionParam  = apiParam{Name: "action", In: "query", Type: "string", Pattern: "^(GET|POST) /api/v1/sponsor/[^ ]+$", Required: true,
+		Description: "method and path of the sponsor request the challenge is signed for, e.g. POST /api/v1/sponsor/webhook"}
+	paramsParam = apiParam{Name: "params", In: "query", Type: "string", Description: "body of the sponsor request, empty if it has none"}
+)
+
+// sponsorAuthMessage is what the sponsor signs to authenticate a request, the challenge, and the request it is
+// issued for: the action is the method and the path of the request and the params are the keccak256 of its body
+type sponsorAuthMessage struct {
+	sponsor   common.Address
+	challenge string
+	action    string
+	params    common.Hash
+}
+
+// text is the text signed with EIP-191 personal_sign
+func (m sponsorAuthMessage) text() string {
+	return fmt.Sprintf("Sign in to %s\nSponsor: %s\nChallenge: %s\nAction: %s\nParams: %s", eip712DomainName,
+		m.sponsor.String(), m.challenge, m.action, m.params.Hex())
+}
+
+// eip712Hash is the EIP-712 hash of the typed data Authentication{sponsor, challenge, action, params}
+func (m sponsorAuthMessage) eip712Hash() []byte {
+	domainSeparator := crypto.Keccak256(
+		crypto.Keccak256([]byte(eip712DomainType)),
+		crypto.Keccak256([]byte(eip712DomainName)),
+		crypto.Keccak256([]byte(eip712DomainVersion)),
+	)
+	structHash := crypto.Keccak256(
+		crypto.Keccak256([]byte(eip712AuthenticationType)),
+		common.LeftPadBytes(m.sponsor.Bytes(), 32),
+		crypto.Keccak256([]byte(m.challenge)),
+		crypto.Keccak256([]byte(m.action)),
+		m.params.Bytes(),
+	)
+	return crypto.Keccak256([]byte{0x19, 0x01}, domainSeparator, structHash)
+}
+
+// typedData is the typed data of the message for eth_signTypedData_v4
+func (m sponsorAuthMessage) typedData() map[string]interface{} {
+	return map[string]interface{}{
+		"types": map[string]interface{}{
+			"EIP712Domain": []map[string]string{
+				{"name": "name", "type": "string"},
+				{"name": "version", "type": "string"},
+			},
+			"Authentication": []map[string]string{
+				{"name": "sponsor", "type": "address"},
+				{"name": "challenge", "type": "string"},
+				{"name": "action", "type": "string"},
+				{"name": "params", "type": "bytes32"},
+			},
+		},
+		"primaryType": "Authentication",
+		"domain":      map[string]string{"name": eip712DomainName, "version": eip712DomainVersion},
+		"message": map[string]string{"sponsor": m.sponsor.String(), "challenge": m.challenge, "action": m.action,
+			"params": m.params.Hex()},
+	}
+}
+
+// recoverSigner returns the address that signed the hash, the recovery id of the signature is either 0/1 or 27/28
+func recoverSigner(hash []byte, signature string) (common.Address, error) {
+	sig, err := hexutil.Decode(signature)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("invalid signature: %s", err.Error())
+	}
+	if len(sig) != crypto.SignatureLength {
+		return common.Address{}, fmt.Errorf("invalid signature length: %d", len(sig))
+	}
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+	pubKey, err := crypto.SigToPub(hash, sig)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*pubKey), nil
+}
+
+// ChallengeHandler issues a challenge to the sponsor for a self service request, the action and the params of the
+// request are signed with the challenge by the sponsor, with either the message by personal_sign or the typed data
+// by eth_signTypedData_v4. The challenges are kept in the db until they are used or expired.
+func (admin *Admin) ChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	bz := make([]byte, ChallengeLength)
+	if _, err := rand.Read(bz); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	message := sponsorAuthMessage{
+		sponsor:   common.HexToAddress(query.Get("sponsor")),
+		challenge: hex.EncodeToString(bz),
+		action:    query.Get("action"),
+		params:    crypto.Keccak256Hash([]byte(query.Get("params"))),
+	}
+	now := time.Now()
+	expireTime := now.Add(ChallengeExpiry)
+	ip := remoteIP(r)
+
+	issued := model.SponsorChallenge{
+		Challenge:  message.challenge,
+		Sponsor:    message.sponsor.String(),
+		Action:     message.action,
+		ParamsHash: message.params.Hex(),
+		IP:         ip,
+		ExpireAt:   expireTime.Unix(),
+	}
+	code, err := func() (int, error) {
+		if err := admin.DB.Where("expire_at < ?", now.Unix()).Delete(model.SponsorChallenge{}).Error; err != nil {
+			return http.StatusInternalServerError, err
+		}
+		outstanding := 0
+		if err := admin.DB.Model(model.SponsorChallenge{}).Where("ip = ?", ip).Count(&outstanding).Error; err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if outstanding >= MaxChallengesPerIP {
+			return http.StatusTooManyRequests, fmt.Errorf("too many outstanding challenges of %s, sign or let some expire first", ip)
+		}
+		// the oldest challenges are evicted past MaxChallenges
+		if err := admin.DB.Model(model.SponsorChallenge{}).Count(&outstanding).Error; err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if evicted := outstanding - MaxChallenges + 1; evicted > 0 {
+			ids := make([]uint, 0, evicted)
+			if err := admin.DB.Model(model.SponsorChallenge{}).Order("id asc").Limit(evicted).Pluck("id", &ids).Error; err != nil {
+				return http.StatusInternalServerError, err
+			}
+			if err := admin.DB.Where("id in (?)", ids).Delete(model.SponsorChallenge{}).Error; err != nil {
+				return http.StatusInternalServerError, err
+			}
+		}
+		return http.StatusInternalServerError, admin.DB.Create(&issued).Error
+	}()
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	writeJson(w, http.StatusOK, challengeResponse{
+		Sponsor:    message.sponsor.String(),
+		Challenge:  message.challenge,
+		Action:     message.action,
+		Params:     message.params.Hex(),
+		Message:    message.text(),
+		TypedData:  message.typedData(),
+		ExpireTime: expireTime.Unix(),
+	})
+}
+
+// useChallenge removes the challenge and returns it, only one of the concurrent requests with the same challenge
+// gets it, on any replica. The expired challenges are not returned.
+func (admin *Admin) useChallenge(challenge string) (*model.SponsorChallenge, error) {
+	issued := model.SponsorChallenge{}
+	err := admin.DB.Where("challenge = ?", challenge).First(&issued).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	deleted := admin.DB.Where("id = ?", issued.ID).Delete(model.SponsorChallenge{})
+	if deleted.Error != nil {
+		return nil, deleted.Error
+	}
+	if deleted.RowsAffected != 1 || time.Now().Unix() > issued.ExpireAt {
+		return nil, nil
+	}
+	return &issued, nil
+}
+
+// checkSponsorAuth verifies that the challenge in the headers is issued to the sponsor for the request and signed
+// by it, the challenge is used up even if the signature is wrong. The action and the params of the request must be
+// the ones the challenge is issued for.
+func (admin *Admin) checkSponsorAuth(r *http.Request) (common.Address, []byte, error) {
+	if !common.IsHexAddress(r.Header.Get("Sponsor")) {
+		return common.Address{}, nil, fmt.Errorf("invalid sponsor address: %s", r.Header.Get("Sponsor"))
+	}
+	sponsor := common.HexToAddress(r.Header.Get("Sponsor"))
+	challenge := r.Header.Get("Challenge")
+
+	issued, err := admin.useChallenge(challenge)
+	if err != nil {
+		return common.Address{}, nil, fmt.Errorf("query challenge error, err=%s", err.Error())
+	}
+	if issued == nil || issued.Sponsor != sponsor.String() {
+		return common.Address{}, nil, fmt.Errorf("challenge is not issued to the sponsor or expired")
+	}
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return common.Address{}, nil, err
+	}
+	message := sponsorAuthMessage{
+		sponsor:   sponsor,
+		challenge: challenge,
+		action:    r.Method + " " + r.URL.Path,
+		params:    crypto.Keccak256Hash(payload),
+	}
+	if message.action != issued.Action || message.params.Hex() != issued.ParamsHash {
+		return common.Address{}, nil, fmt.Errorf("challenge is issued for %s with params %s, not for this request",
+			issued.Action, issued.ParamsHash)
+	}
+
+	var hash []byte
+	switch signatureType := r.Header.Get("SignatureType"); signatureType {
+	case "", SignatureTypeEIP191:
+		hash = accounts.TextHash([]byte(message.text()))
+	case SignatureTypeEIP712:
+		hash = message.eip712Hash()
+	default:
+		return common.Address{}, nil, fmt.Errorf("unknown signature type: %s", signatureType)
+	}
+	signer, err := recoverSigner(hash, r.Header.Get("Signature"))
+	if err != nil {
+		return common.Address{}, nil, err
+	}
+	if signer != sponsor {
+		return common.Address{}, nil, fmt.Errorf("challenge is not signed by the sponsor")
+	}
+	return sponsor, payload, nil
+}
+
+// getSponsorSwap returns the swap if it is started by the sponsor
+func (admin *Admin) getSponsorSwap(sponsor common.Address, startTxHash string) (*model.Swap, int, error) {
+	swap := model.Swap{}
+	err := admin.DB.Where("start_tx_hash = ?", startTxHash).First(&swap).Error
+	if err == gorm.ErrRecordNotFound || (err == nil && !strings.EqualFold(swap.Sponsor, sponsor.String())) {
+		return nil, http.StatusNotFound, fmt.Errorf("swap %s of sponsor %s is not found", startTxHash, sponsor.String())
+	} else if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("query swap error, err=%s", err.Error())
+	}
+	return &swap, http.StatusOK, nil
+}
+
+// SponsorSwapHandler returns the private details of a swap to its sponsor, including the fill txs and the
+// deliveries of its webhooks
+func (admin *Admin) SponsorSwapHandler(w http.ResponseWriter, r *http.Request) {
+	sponsor, _, err := admin.checkSponsorAuth(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	swap, code, err := admin.getSponsorSwap(sponsor, mux.Vars(r)["start_tx_hash"])
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	details := sponsorSwapResponse{
+		Swap:              *swap,
+		SwapFillTxs:       make([]model.SwapFillTx, 0),
+		WebhookDeliveries: make([]model.WebhookDelivery, 0),
+	}
+	admin.DB.Where("swap_id = ?", swap.ID).Order("id asc").Find(&details.SwapFillTxs)
+	webhookIDs := make([]uint, 0)
+	admin.DB.Model(model.Webhook{}).Where("sponsor = ? and self_service = ?", sponsor.String(), true).Pluck("id", &webhookIDs)
+	if len(webhookIDs) != 0 {
+		admin.DB.Where("start_tx_hash = ? and webhook_id in (?)", swap.StartTxHash, webhookIDs).Order("id asc").
+			Find(&details.WebhookDeliveries)
+	}
+	writeJson(w, http.StatusOK, details)
+}
+
+// SponsorCancelSwapHandler cancels a delayed swap for its sponsor
+func (admin *Admin) SponsorCancelSwapHandler(w http.ResponseWriter, r *http.Request) {
+	sponsor, reqBody, err := admin.checkSponsorAuth(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var cancelSwap sponsorCancelSwapRequest
+	if len(reqBody) != 0 {
+		if err := json.Unmarshal(reqBody, &cancelSwap); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	swap, code, err := admin.getSponsorSwap(sponsor, mux.Vars(r)["start_tx_hash"])
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	if err := admin.swapEngine.CancelDelayedSwap(swap.StartTxHash, cancelSwap.Reason, "sponsor"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	util.Logger.Infof("delayed swap %s is cancelled by sponsor %s", swap.StartTxHash, sponsor.String())
+	writeJson(w, http.StatusOK, cancelSwap)
+}
+
+// SponsorWebhookHandler sets the webhook called when the swaps of the sponsor succeed or fail, it replaces the
+// previous webhook set by the sponsor, an empty url removes it
+func (admin *Admin) SponsorWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	sponsor, reqBody, err := admin.checkSponsorAuth(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var setWebhook sponsorWebhookRequest
+	if err := json.Unmarshal(reqBody, &setWebhook); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if setWebhook.Url != "" {
+		if err := admin.cfg.WebhookConfig.CheckUrl(setWebhook.Url); err != nil {
+			http.Error(w, fmt.Sprintf("invalid webhook url: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+	}
+	secret, err := newScopedApiKey()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	webhook := model.Webhook{
+		Url:         setWebhook.Url,
+		Secret:      secret,
+		Sponsor:     sponsor.String(),
+		SelfService: true,
+	}
+	err = func() error {
+		tx := admin.DB.Begin()
+		if err := tx.Error; err != nil {
+			return err
+		}
+		if err := tx.Where("sponsor = ? and self_service = ?", sponsor.String(), true).Delete(model.Webhook{}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		if webhook.Url != "" {
+			if err := tx.Create(&webhook).Error; err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		return tx.Commit().Error
+	}()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("set webhook error, err=%s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if webhook.Url == "" {
+		util.Logger.Infof("webhook of sponsor %s is removed", sponsor.String())
+		writeJson(w, http.StatusOK, webhookResponse{Sponsor: webhook.Sponsor})
+		return
+	}
+	util.Logger.Infof("webhook %d is set by sponsor %s, url %s", webhook.ID, webhook.Sponsor, webhook.Url)
+	writeJson(w, http.StatusOK, webhookResponse{
+		ID:      webhook.ID,
+		Url:     webhook.Url,
+		Sponsor: webhook.Sponsor,
+		Secret:  webhook.Secret,
+	})
+}
@@ -0,0 +1,82 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func (admin *Admin) SponsorTiersHandler(w http.ResponseWriter, r *http.Request) {
+	tiers, err := admin.swapEngine.GetSponsorTiers()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("query sponsor tiers error, err=%s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, http.StatusOK, tiers)
+}
+
+// SetSponsorTierHandler sets the tier of a sponsor, the unfilled swaps of the sponsor are reordered by its priority
+// at once, the fee rebate only applies to the new swaps
+func (admin *Admin) SetSponsorTierHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := admin.checkAuth(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var setTier sponsorTierRequest
+	err = json.Unmarshal(reqBody, &setTier)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !common.IsHexAddress(setTier.Sponsor) {
+		http.Error(w, fmt.Sprintf("invalid sponsor address: %s", setTier.Sponsor), http.StatusBadRequest)
+		return
+	}
+	if setTier.Tier == "" {
+		http.Error(w, "tier should not be empty", http.StatusBadRequest)
+		return
+	}
+	if setTier.Operator == "" {
+		setTier.Operator = callerName(r)
+	}
+
+	tier, err := admin.swapEngine.SetSponsorTier(common.HexToAddress(setTier.Sponsor), setTier.Tier,
+		setTier.FeeRebatePercent, setTier.Priority, setTier.Operator)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, http.StatusOK, tier)
+}
+
+func (admin *Admin) RemoveSponsorTierHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := admin.checkAuth(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var removeTier sponsorTierRequest
+	err = json.Unmarshal(reqBody, &removeTier)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !common.IsHexAddress(removeTier.Sponsor) {
+		http.Error(w, fmt.Sprintf("invalid sponsor address: %s", removeTier.Sponsor), http.StatusBadRequest)
+		return
+	}
+	if removeTier.Operator == "" {
+		removeTier.Operator = callerName(r)
+	}
+
+	if err := admin.swapEngine.RemoveSponsorTier(common.HexToAddress(removeTier.Sponsor), removeTier.Operator); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, http.StatusOK, removeTier)
+}
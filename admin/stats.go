@@ -0,0 +1,68 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+
+	cmm "occ-swap-server/common"
+	"occ-swap-server/model"
+)
+
+const (
+	DefaultListRollupsLimit = 1000
+	MaxListRollupsLimit     = 10000
+)
+
+var rollupParams = []apiParam{
+	exportFromParam,
+	exportToParam,
+	{Name: "pair", In: "query", Type: "string", Description: "erc20 address of the swap pair"},
+	{Name: "direction", In: "query", Type: "string", Description: "swap direction"},
+	{Name: "limit", In: "query", Type: "integer", Description: fmt.Sprintf("max number of rollups, at most %d", MaxListRollupsLimit)},
+}
+
+// HourlyStatsHandler returns the hourly rollups of the swaps created in the date range
+func (admin *Admin) HourlyStatsHandler(w http.ResponseWriter, r *http.Request) {
+	admin.writeRollups(w, r, model.RollupHour)
+}
+
+// DailyStatsHandler returns the daily rollups of the swaps created in the date range
+func (admin *Admin) DailyStatsHandler(w http.ResponseWriter, r *http.Request) {
+	admin.writeRollups(w, r, model.RollupDay)
+}
+
+func (admin *Admin) writeRollups(w http.ResponseWriter, r *http.Request, period model.RollupPeriod) {
+	from, to, err := parseExportRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := DefaultListRollupsLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l <= 0 || l > MaxListRollupsLimit {
+			http.Error(w, fmt.Sprintf("limit should be between 1 and %d", MaxListRollupsLimit), http.StatusBadRequest)
+			return
+		}
+		limit = l
+	}
+	pair := r.URL.Query().Get("pair")
+	if pair != "" {
+		if !ethcom.IsHexAddress(pair) {
+			http.Error(w, fmt.Sprintf("invalid pair address: %s", pair), http.StatusBadRequest)
+			return
+		}
+		pair = ethcom.HexToAddress(pair).String()
+	}
+
+	rollups, err := admin.swapEngine.GetRollups(period, from.Unix(), to.Unix(), pair,
+		cmm.SwapDirection(r.URL.Query().Get("direction")), limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("query %s rollups error, err=%s", period, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, http.StatusOK, rollups)
+}
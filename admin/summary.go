@@ -0,0 +1,175 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/mux"
+
+	cmm "occ-swap-server/common"
+	"occ-swap-server/model"
+	"occ-swap-server/swap"
+	"occ-swap-server/util"
+)
+
+var pendingSwapStatuses = []cmm.SwapStatus{swap.SwapTokenReceived, swap.SwapDelayed, swap.SwapAwaitingLiquidity, swap.SwapAwaitingWindow, swap.SwapAwaitingGas, swap.SwapUneconomic, swap.SwapConfirmed, swap.SwapSending, swap.SwapSent}
+
+var failedSwapStatuses = []cmm.SwapStatus{swap.SwapSendFailed, swap.SwapAbandoned, swap.SwapExpired, swap.SwapRejected}
+
+const (
+	DefaultAddressSummaryLimit = 50
+	MaxAddressSummaryLimit     = 500
+	// the successful swaps are read in batches of their amounts to sum them up
+	addressSummaryBatchSize = 1000
+)
+
+var addressSummaryParams = []apiParam{
+	addressParam,
+	{Name: "limit", In: "query", Type: "integer", Description: fmt.Sprintf("max number of swaps, at most %d", MaxAddressSummaryLimit)},
+	{Name: "offset", In: "query", Type: "integer", Description: "number of the latest swaps to skip"},
+}
+
+func newAddressSwap(s *model.Swap) addressSwap {
+	return addressSwap{
+		Status:        s.Status,
+		Direction:     s.Direction,
+		ToChainId:     s.ToChainId,
+		Symbol:        s.Symbol,
+		BEP20Addr:     s.BEP20Addr,
+		ERC20Addr:     s.ERC20Addr,
+		Amount:        s.Amount,
+		AmountDecimal: s.AmountDecimal,
+		Decimals:      s.Decimals,
+		AssetType:     s.AssetType,
+		TokenId:       s.TokenId,
+		StartTxHash:   s.StartTxHash,
+		FillTxHash:    s.FillTxHash,
+		CreatedAt:     s.CreatedAt.Unix(),
+		UpdatedAt:     s.UpdatedAt.Unix(),
+	}
+}
+
+// AddressSummaryHandler returns the latest swaps started by the given sponsor with aggregate statistics of all its
+// swaps. It is public, so the swaps leave out the recipient, the memo, the logs and the other internal columns, the
+// sponsor reads them on /api/v1/sponsor/swaps.
+func (admin *Admin) AddressSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	addr := mux.Vars(r)["addr"]
+	if !common.IsHexAddress(addr) {
+		http.Error(w, fmt.Sprintf("invalid address: %s", addr), http.StatusBadRequest)
+		return
+	}
+	sponsor := common.HexToAddress(addr).String()
+
+	limit := DefaultAddressSummaryLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l <= 0 || l > MaxAddressSummaryLimit {
+			http.Error(w, fmt.Sprintf("limit should be between 1 and %d", MaxAddressSummaryLimit), http.StatusBadRequest)
+			return
+		}
+		limit = l
+	}
+	offset := 0
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		o, err := strconv.Atoi(offsetStr)
+		if err != nil || o < 0 {
+			http.Error(w, "offset should not be negative", http.StatusBadRequest)
+			return
+		}
+		offset = o
+	}
+
+	swaps := make([]model.Swap, 0)
+	err := admin.DB.Where("sponsor = ?", sponsor).Order("id desc").Offset(offset).Limit(limit).Find(&swaps).Error
+	if err != nil {
+		http.Error(w, fmt.Sprintf("query swaps error, err=%s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	var totalCount, pendingCount, failedCount, successCount int
+	counts := []struct {
+		count    *int
+		statuses []cmm.SwapStatus
+	}{
+		{&pendingCount, pendingSwapStatuses},
+		{&failedCount, failedSwapStatuses},
+		{&successCount, []cmm.SwapStatus{swap.SwapSuccess}},
+	}
+	err = admin.DB.Model(model.Swap{}).Where("sponsor = ?", sponsor).Count(&totalCount).Error
+	for i := 0; err == nil && i < len(counts); i++ {
+		err = admin.DB.Model(model.Swap{}).Where("sponsor = ? and status in (?)", sponsor, counts[i].statuses).Count(counts[i].count).Error
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("query swaps error, err=%s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	// amounts are stored as decimal strings, so they are summed up here rather than in the db
+	bridged := make(map[string]*tokenBridgedAmount)
+	totals := make(map[string]*big.Int)
+	tokenKeys := make([]string, 0)
+	var lastID uint
+	for {
+		successSwaps := make([]model.Swap, 0)
+		err := admin.DB.Select("id, symbol, bep20_addr, erc20_addr, decimals, amount").
+			Where("sponsor = ? and status = ? and id > ?", sponsor, swap.SwapSuccess, lastID).
+			Order("id asc").Limit(addressSummaryBatchSize).Find(&successSwaps).Error
+		if err != nil {
+			http.Error(w, fmt.Sprintf("query swaps error, err=%s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		if len(successSwaps) == 0 {
+			break
+		}
+		for _, s := range successSwaps {
+			lastID = s.ID
+			amount, ok := big.NewInt(0).SetString(s.Amount, 10)
+			if !ok {
+				util.Logger.Errorf("invalid swap amount, swap id %d, amount %s", s.ID, s.Amount)
+				continue
+			}
+			key := fmt.Sprintf("%s#%s#%s#%d", s.Symbol, s.BEP20Addr, s.ERC20Addr, s.Decimals)
+			if _, ok := bridged[key]; !ok {
+				bridged[key] = &tokenBridgedAmount{Symbol: s.Symbol, BEP20Addr: s.BEP20Addr, ERC20Addr: s.ERC20Addr, Decimals: s.Decimals}
+				totals[key] = big.NewInt(0)
+				tokenKeys = append(tokenKeys, key)
+			}
+			totals[key].Add(totals[key], amount)
+		}
+	}
+
+	summary := addressSummaryResponse{
+		Address:      sponsor,
+		TotalCount:   totalCount,
+		PendingCount: pendingCount,
+		FailedCount:  failedCount,
+		SuccessCount: successCount,
+		TotalBridged: make([]tokenBridgedAmount, 0, len(tokenKeys)),
+		Swaps:        make([]addressSwap, 0, len(swaps)),
+	}
+	for _, key := range tokenKeys {
+		bridged[key].Amount = totals[key].String()
+		bridged[key].AmountDecimal = model.FormatAmount(totals[key], bridged[key].Decimals)
+		summary.TotalBridged = append(summary.TotalBridged, *bridged[key])
+	}
+	for i := range swaps {
+		summary.Swaps = append(summary.Swaps, newAddressSwap(&swaps[i]))
+	}
+
+	jsonBytes, err := json.MarshalIndent(summary, "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(jsonBytes)
+	if err != nil {
+		util.Logger.Errorf("write response error, err=%s", err.Error())
+	}
+}
@@ -1,27 +1,79 @@
 package admin
 
+import (
+	cmm "occ-swap-server/common"
+	"occ-swap-server/model"
+	"occ-swap-server/swap"
+)
+
 type updateSwapPairRequest struct {
-	ERC20Addr  string `json:"erc20_addr"`
+	ERC20Addr  string `json:"erc20_addr" required:"true"`
 	Available  bool   `json:"available"`
 	LowerBound string `json:"lower_bound"`
 	UpperBound string `json:"upper_bound"`
 	IconUrl    string `json:"icon_url"`
+	// fungible or erc721, the swaps of an erc721 pair are only filled while nft_enabled is set
+	AssetType  cmm.AssetType `json:"asset_type"`
+	NFTEnabled bool          `json:"nft_enabled"`
+}
+
+// registerSwapPairRequest adds a fungible swap pair, the token on pegged_token_chain is deployed by the pegged token
+// factory of the chain if it is set, and its address is left empty
+type registerSwapPairRequest struct {
+	Sponsor          string `json:"sponsor"`
+	BEP20Addr        string `json:"bep20_addr"`
+	ERC20Addr        string `json:"erc20_addr"`
+	Symbol           string `json:"symbol" required:"true"`
+	Name             string `json:"name" required:"true"`
+	Decimals         int    `json:"decimals" required:"true"`
+	LowerBound       string `json:"lower_bound" required:"true"`
+	UpperBound       string `json:"upper_bound" required:"true"`
+	IconUrl          string `json:"icon_url"`
+	Available        bool   `json:"available"`
+	PeggedTokenChain string `json:"pegged_token_chain"`
+	// the methods filling the swaps of the pair instead of fillSwap, at most one a destination chain
+	FillMethods []fillMethodRequest `json:"fill_methods"`
+}
+
+// fillMethodRequest is the method of the swap agent of the chain filling the swaps of the pair, abi is the json abi
+// holding the method, args are the values passed as its arguments in order: zero, from_chain_id, to_chain_id,
+// recipient, amount, token or start_tx_hash
+type fillMethodRequest struct {
+	Chain  string   `json:"chain" required:"true"`
+	Method string   `json:"method" required:"true"`
+	Abi    string   `json:"abi" required:"true"`
+	Args   []string `json:"args"`
+}
+
+type deleteSwapPairRequest struct {
+	ERC20Addr string `json:"erc20_addr" required:"true"`
+}
+
+// migrateSwapPairRequest moves the pair of erc20_addr to the new tokens, the token which doesn't migrate is repeated
+type migrateSwapPairRequest struct {
+	ERC20Addr    string `json:"erc20_addr" required:"true"`
+	NewBEP20Addr string `json:"new_bep20_addr" required:"true"`
+	NewERC20Addr string `json:"new_erc20_addr" required:"true"`
+	Reason       string `json:"reason"`
+	Operator     string `json:"operator"`
 }
 
 type withdrawTokenRequest struct {
-	Chain     string `json:"chain"`
-	TokenAddr string `json:"token_addr"`
-	Recipient string `json:"recipient"`
-	Amount    string `json:"amount"`
+	Chain     string `json:"chain" required:"true"`
+	TokenAddr string `json:"token_addr" required:"true"`
+	Recipient string `json:"recipient" required:"true"`
+	Amount    string `json:"amount" required:"true"`
 }
 
 type withdrawTokenResponse struct {
 	TxHash string `json:"tx_hash"`
+	// explorer link of the tx, empty if the explorer is unknown
+	TxUrl  string `json:"tx_url"`
 	ErrMsg string `json:"err_msg"`
 }
 
 type retryFailedSwapsRequest struct {
-	SwapIDList []uint `json:"swap_id_list"`
+	SwapIDList []uint `json:"swap_id_list" required:"true"`
 }
 
 type retryFailedSwapsResponse struct {
@@ -29,3 +81,385 @@ type retryFailedSwapsResponse struct {
 	RejectedSwapIDList []uint `json:"rejected_swap_id_list"`
 	ErrMsg             string `json:"err_msg"`
 }
+
+type tokenBridgedAmount struct {
+	Symbol    string `json:"symbol"`
+	BEP20Addr string `json:"bep20_addr"`
+	ERC20Addr string `json:"erc20_addr"`
+	Amount    string `json:"amount"`
+	// the amount in the unit of the token
+	AmountDecimal string `json:"amount_decimal"`
+	Decimals      int    `json:"decimals"`
+}
+
+// addressSwap is the public view of a swap
+type addressSwap struct {
+	Status        cmm.SwapStatus    `json:"status"`
+	Direction     cmm.SwapDirection `json:"direction"`
+	ToChainId     string            `json:"to_chain_id"`
+	Symbol        string            `json:"symbol"`
+	BEP20Addr     string            `json:"bep20_addr"`
+	ERC20Addr     string            `json:"erc20_addr"`
+	Amount        string            `json:"amount"`
+	AmountDecimal string            `json:"amount_decimal"`
+	Decimals      int               `json:"decimals"`
+	AssetType     cmm.AssetType     `json:"asset_type"`
+	TokenId       string            `json:"token_id"`
+	StartTxHash   string            `json:"start_tx_hash"`
+	FillTxHash    string            `json:"fill_tx_hash"`
+	CreatedAt     int64             `json:"created_at"`
+	UpdatedAt     int64             `json:"updated_at"`
+}
+
+type addressSummaryResponse struct {
+	Address string `json:"address"`
+	// the counts and the bridged amounts are of all the swaps of the address, the swaps are the page of limit and offset
+	TotalCount   int                  `json:"total_count"`
+	PendingCount int                  `json:"pending_count"`
+	FailedCount  int                  `json:"failed_count"`
+	SuccessCount int                  `json:"success_count"`
+	TotalBridged []tokenBridgedAmount `json:"total_bridged"`
+	Swaps        []addressSwap        `json:"swaps"`
+}
+
+type swapTimelineResponse struct {
+	Swap           model.Swap            `json:"swap"`
+	SwapStartTxLog *model.SwapStartTxLog `json:"swap_start_tx_log"`
+	SwapFillTxs    []model.SwapFillTx    `json:"swap_fill_txs"`
+	RetrySwaps     []model.RetrySwap     `json:"retry_swaps"`
+	RetrySwapTxs   []model.RetrySwapTx   `json:"retry_swap_txs"`
+	SwapProof      *model.SwapProof      `json:"swap_proof"`
+	// explorer links of the start tx and the fill tx, empty if the explorer is unknown
+	StartTxUrl string `json:"start_tx_url"`
+	FillTxUrl  string `json:"fill_tx_url"`
+}
+
+// archivedSwapResponse is an archived swap with its start tx log and fill txs
+type archivedSwapResponse struct {
+	Swap           model.ArchivedSwap            `json:"swap"`
+	SwapStartTxLog *model.ArchivedSwapStartTxLog `json:"swap_start_tx_log"`
+	SwapFillTxs    []model.ArchivedSwapFillTx    `json:"swap_fill_txs"`
+}
+
+// swapProofResponse is the receipt proof of a swap start tx, the trie nodes are decoded from the record
+type overviewResponse struct {
+	// counts of the swaps created since 00:00 utc today
+	TodayCount        int     `json:"today_count"`
+	TodaySuccessCount int     `json:"today_success_count"`
+	TodayFailedCount  int     `json:"today_failed_count"`
+	FailureRate       float64 `json:"failure_rate"`
+
+	PendingCounts map[cmm.SwapStatus]int `json:"pending_counts"`
+	// age in seconds of the oldest pending swap, 0 if no swap is pending
+	OldestPendingAge         int64  `json:"oldest_pending_age"`
+	OldestPendingStartTxHash string `json:"oldest_pending_start_tx_hash"`
+
+	Relayers         []swap.RelayerAccount `json:"relayers"`
+	PausedDirections []cmm.SwapDirection   `json:"paused_directions"`
+	PausedPairs      []model.PausedPair    `json:"paused_pairs"`
+}
+
+type rebroadcastFillTxRequest struct {
+	FillTxHash string `json:"fill_tx_hash" required:"true"`
+	Operator   string `json:"operator"`
+}
+
+type incidentRequest struct {
+	// an empty note resolves the active incident
+	Note     string `json:"note"`
+	Operator string `json:"operator"`
+}
+
+type statusPageIncident struct {
+	Note  string `json:"note"`
+	Since int64  `json:"since"`
+}
+
+type statusPageDirection struct {
+	Direction  cmm.SwapDirection `json:"direction"`
+	Status     string            `json:"status"`
+	Reason     string            `json:"reason"`
+	EtaSeconds int64             `json:"eta_seconds"`
+}
+
+// statusPageResponse is the public status of the bridge, the worst status of the directions is the overall status
+type statusPageResponse struct {
+	Status     string                `json:"status"`
+	Incident   *statusPageIncident   `json:"incident"`
+	Directions []statusPageDirection `json:"directions"`
+	UpdateTime int64                 `json:"update_time"`
+}
+
+type swapProofResponse struct {
+	model.SwapProof
+	ProofNodes []string `json:"proof_nodes"`
+}
+
+type pauseDirectionRequest struct {
+	Direction string `json:"direction" required:"true"`
+	Paused    bool   `json:"paused"`
+}
+
+type pausePairRequest struct {
+	ERC20Addr string `json:"erc20_addr" required:"true"`
+	Paused    bool   `json:"paused"`
+	Reason    string `json:"reason"`
+	Operator  string `json:"operator"`
+}
+
+type releaseDrainBrakeRequest struct {
+	Chain    string `json:"chain" required:"true"`
+	Operator string `json:"operator"`
+}
+
+type backfillRequest struct {
+	Chain      string `json:"chain" required:"true"`
+	FromHeight int64  `json:"from_height" required:"true"`
+	ToHeight   int64  `json:"to_height" required:"true"`
+}
+
+type backfillResponse struct {
+	SavedEvents int    `json:"saved_events"`
+	ErrMsg      string `json:"err_msg"`
+}
+
+type delayedSwapRequest struct {
+	StartTxHash string `json:"start_tx_hash" required:"true"`
+	// expedite or cancel
+	Action string `json:"action" required:"true"`
+	Reason string `json:"reason"`
+}
+
+type uneconomicSwapRequest struct {
+	StartTxHash string `json:"start_tx_hash" required:"true"`
+	// fill or reject
+	Action string `json:"action" required:"true"`
+	Reason string `json:"reason"`
+}
+
+type rebalancePlanRequest struct {
+	ID uint `json:"id" required:"true"`
+	// approve, reject or executed
+	Action string `json:"action" required:"true"`
+	Reason string `json:"reason"`
+}
+
+type rebalancePlansResponse struct {
+	Inventory []swap.ChainInventory      `json:"inventory"`
+	Plans     []swap.RebalancePlanDetail `json:"plans"`
+	ErrMsg    string                     `json:"err_msg,omitempty"`
+}
+
+type integritySweepRequest struct {
+	// move the tampered swaps and the swaps without a record hash to the quarantined swaps
+	Quarantine bool `json:"quarantine"`
+}
+
+// importSwapsRequest is a batch of the dump of the previous deployment source
+type importSwapsRequest struct {
+	Source string              `json:"source" required:"true"`
+	Swaps  []swap.ImportedSwap `json:"swaps" required:"true"`
+}
+
+type markSwapFilledRequest struct {
+	StartTxHash string `json:"start_tx_hash" required:"true"`
+	FillTxHash  string `json:"fill_tx_hash" required:"true"`
+	// the operator who sent the fill tx, recorded in the swap log
+	Operator string `json:"operator" required:"true"`
+}
+
+// sponsorTierRequest sets the tier of the sponsor, only the sponsor is read to remove it
+type sponsorTierRequest struct {
+	Sponsor          string `json:"sponsor" required:"true"`
+	Tier             string `json:"tier"`
+	FeeRebatePercent int64  `json:"fee_rebate_percent"`
+	Priority         int64  `json:"priority"`
+	Operator         string `json:"operator"`
+}
+
+type pairOwnerRequest struct {
+	Name      string `json:"name"`
+	ApiKey    string `json:"api_key"`
+	ERC20Addr string `json:"erc20_addr" required:"true"`
+}
+
+type pairOwnerResponse struct {
+	Name      string `json:"name"`
+	ApiKey    string `json:"api_key"`
+	ApiSecret string `json:"api_secret,omitempty"`
+	ERC20Addr string `json:"erc20_addr"`
+}
+
+type addAdminUserRequest struct {
+	Name string `json:"name" required:"true"`
+	// viewer, operator, security or admin
+	Role string `json:"role" required:"true"`
+}
+
+type updateAdminUserRequest struct {
+	Name string `json:"name" required:"true"`
+	// unchanged if empty
+	Role     string `json:"role"`
+	Disabled bool   `json:"disabled"`
+	// generate a new api secret, the former one is rejected at once
+	RotateSecret bool `json:"rotate_secret"`
+}
+
+type adminUserResponse struct {
+	Name   string `json:"name"`
+	Role   string `json:"role"`
+	ApiKey string `json:"api_key"`
+	// only returned when the user is added or the secret is rotated
+	ApiSecret string `json:"api_secret,omitempty"`
+	Disabled  bool   `json:"disabled"`
+}
+
+type tokenResponse struct {
+	Token string `json:"token"`
+	// the secret signing the requests sent with the token, it is only returned once
+	TokenSecret string `json:"token_secret"`
+	Role        string `json:"role"`
+	ExpireTime  int64  `json:"expire_time"`
+}
+
+type addWebhookRequest struct {
+	Url string `json:"url" required:"true"`
+	// optional, the webhook is called for the swaps of all the sponsors if empty
+	Sponsor string `json:"sponsor"`
+}
+
+type webhookResponse struct {
+	ID      uint   `json:"id"`
+	Url     string `json:"url"`
+	Sponsor string `json:"sponsor"`
+	ApiKey  string `json:"api_key"`
+	// hmac key of the payload signature, only returned when the webhook is added
+	Secret string `json:"secret,omitempty"`
+}
+
+type removeWebhookRequest struct {
+	ID uint `json:"id" required:"true"`
+}
+
+type challengeResponse struct {
+	Sponsor   string `json:"sponsor"`
+	Challenge string `json:"challenge"`
+	// the request the challenge is issued for, its method and path, and the keccak256 of its body
+	Action string `json:"action"`
+	Params string `json:"params"`
+	// text to sign with personal_sign
+	Message string `json:"message"`
+	// typed data to sign with eth_signTypedData_v4
+	TypedData  map[string]interface{} `json:"typed_data"`
+	ExpireTime int64                  `json:"expire_time"`
+}
+
+type sponsorSwapResponse struct {
+	Swap              model.Swap              `json:"swap"`
+	SwapFillTxs       []model.SwapFillTx      `json:"swap_fill_txs"`
+	WebhookDeliveries []model.WebhookDelivery `json:"webhook_deliveries"`
+}
+
+type sponsorCancelSwapRequest struct {
+	Reason string `json:"reason"`
+}
+
+type sponsorWebhookRequest struct {
+	// empty url removes the webhook
+	Url string `json:"url"`
+}
+
+type buildSwapTxRequest struct {
+	// erc20 address of the swap pair
+	Pair string `json:"pair" required:"true"`
+	// amount to swap in the smallest unit of the token
+	Amount string `json:"amount" required:"true"`
+	// chain the swap is started on, BSC, ETH or CRO
+	Chain string `json:"chain" required:"true"`
+	// chain id of the destination chain
+	ToChainId string `json:"to_chain_id" required:"true"`
+	// owner of the tokens sending the txs
+	Owner string `json:"owner" required:"true"`
+	// optional reference of the swap appended to the call data of the swap tx, up to 64 printable ascii characters
+	Memo string `json:"memo"`
+}
+
+type permit2ParamsRequest struct {
+	// erc20 address of the swap pair
+	Pair string `json:"pair" required:"true"`
+	// amount to swap in the smallest unit of the token
+	Amount string `json:"amount" required:"true"`
+	// chain the swap is started on, BSC, ETH or CRO
+	Chain string `json:"chain" required:"true"`
+	// chain id of the destination chain
+	ToChainId string `json:"to_chain_id" required:"true"`
+	// owner of the tokens signing the permit and sending the swap tx
+	Owner string `json:"owner" required:"true"`
+}
+
+type permit2SwapTxRequest struct {
+	Pair      string `json:"pair" required:"true"`
+	Amount    string `json:"amount" required:"true"`
+	Chain     string `json:"chain" required:"true"`
+	ToChainId string `json:"to_chain_id" required:"true"`
+	Owner     string `json:"owner" required:"true"`
+	// nonce and deadline of the permit in unix seconds, as returned by the permit2 params
+	Nonce    string `json:"nonce" required:"true"`
+	Deadline int64  `json:"deadline" required:"true"`
+	// hex encoded 65 bytes signature of the permit, r, s and v
+	Signature string `json:"signature" required:"true"`
+}
+
+type relaySwapRequest struct {
+	Chain     string `json:"chain" required:"true"`
+	Owner     string `json:"owner" required:"true"`
+	ToChainId string `json:"to_chain_id" required:"true"`
+	Amount    string `json:"amount" required:"true"`
+	// deadline of the permit in unix seconds
+	Deadline int64 `json:"deadline" required:"true"`
+	// hex encoded 65 bytes signature of the permit, r, s and v
+	Signature string `json:"signature" required:"true"`
+}
+
+// relayedSwapResponse is a relayed swap without the signature of the permit
+type relayedSwapResponse struct {
+	ID            uint                    `json:"id"`
+	Chain         string                  `json:"chain"`
+	Owner         string                  `json:"owner"`
+	Spender       string                  `json:"spender"`
+	Token         string                  `json:"token"`
+	ToChainId     string                  `json:"to_chain_id"`
+	Amount        string                  `json:"amount"`
+	Fee           string                  `json:"fee"`
+	Deadline      int64                   `json:"deadline"`
+	Status        model.RelayedSwapStatus `json:"status"`
+	PermitTxHash  string                  `json:"permit_tx_hash"`
+	PullTxHash    string                  `json:"pull_tx_hash"`
+	ApproveTxHash string                  `json:"approve_tx_hash"`
+	StartTxHash   string                  `json:"start_tx_hash"`
+	ErrorMsg      string                  `json:"error_msg"`
+	CreatedAt     int64                   `json:"created_at"`
+	UpdatedAt     int64                   `json:"updated_at"`
+}
+
+func newRelayedSwapResponse(relayedSwap *model.RelayedSwap) relayedSwapResponse {
+	return relayedSwapResponse{
+		ID:            relayedSwap.ID,
+		Chain:         relayedSwap.Chain,
+		Owner:         relayedSwap.Owner,
+		Spender:       relayedSwap.Spender,
+		Token:         relayedSwap.Token,
+		ToChainId:     relayedSwap.ToChainId,
+		Amount:        relayedSwap.Amount,
+		Fee:           relayedSwap.Fee,
+		Deadline:      relayedSwap.Deadline,
+		Status:        relayedSwap.Status,
+		PermitTxHash:  relayedSwap.PermitTxHash,
+		PullTxHash:    relayedSwap.PullTxHash,
+		ApproveTxHash: relayedSwap.ApproveTxHash,
+		StartTxHash:   relayedSwap.StartTxHash,
+		ErrorMsg:      relayedSwap.ErrorMsg,
+		CreatedAt:     relayedSwap.CreatedAt.Unix(),
+		UpdatedAt:     relayedSwap.UpdatedAt.Unix(),
+	}
+}
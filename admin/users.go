@@ -0,0 +1,166 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+func newAdminUserResponse(user *model.AdminUser) adminUserResponse {
+	return adminUserResponse{Name: user.Name, Role: user.Role, ApiKey: user.ApiKey, Disabled: user.Disabled}
+}
+
+// ListAdminUsersHandler lists the admin users, the api secrets are not returned
+func (admin *Admin) ListAdminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users := make([]model.AdminUser, 0)
+	if err := admin.DB.Order("id asc").Find(&users).Error; err != nil {
+		http.Error(w, fmt.Sprintf("query admin users error, err=%s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	resp := make([]adminUserResponse, 0, len(users))
+	for i := range users {
+		resp = append(resp, newAdminUserResponse(&users[i]))
+	}
+	writeJson(w, http.StatusOK, resp)
+}
+
+// AddAdminUserHandler adds an admin user with a new api key and secret, the caller can only grant the roles whose
+// permissions it has
+func (admin *Admin) AddAdminUserHandler(w http.ResponseWriter, r *http.Request) {
+	authorized, err := getAuthorizedRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var addUser addAdminUserRequest
+	err = json.Unmarshal(authorized.payload, &addUser)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !isRole(Role(addUser.Role)) {
+		http.Error(w, fmt.Sprintf("unknown role: %s", addUser.Role), http.StatusBadRequest)
+		return
+	}
+	if !canGrant(authorized.principal.role, Role(addUser.Role)) {
+		http.Error(w, fmt.Sprintf("permission denied, %s can't grant role %s", authorized.principal.name, addUser.Role), http.StatusForbidden)
+		return
+	}
+
+	user := model.AdminUser{Name: addUser.Name, Role: addUser.Role}
+	if user.ApiKey, err = newScopedApiKey(); err == nil {
+		user.ApiSecret, err = newScopedApiKey()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := admin.DB.Create(&user).Error; err != nil {
+		http.Error(w, fmt.Sprintf("add admin user error, err=%s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	util.Logger.Infof("admin user %s is added with role %s by %s", user.Name, user.Role, authorized.principal.name)
+
+	resp := newAdminUserResponse(&user)
+	// the secret is only returned once
+	resp.ApiSecret = user.ApiSecret
+	writeJson(w, http.StatusOK, resp)
+}
+
+// UpdateAdminUserHandler changes the role of an admin user, disables or enables it, or rotates its api secret. The
+// caller can only update the users whose roles it can grant, and can't disable itself or change its own role.
+func (admin *Admin) UpdateAdminUserHandler(w http.ResponseWriter, r *http.Request) {
+	authorized, err := getAuthorizedRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	caller := authorized.principal
+
+	var updateUser updateAdminUserRequest
+	err = json.Unmarshal(authorized.payload, &updateUser)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user := model.AdminUser{}
+	if err := admin.DB.Where("name = ?", updateUser.Name).First(&user).Error; err != nil {
+		http.Error(w, fmt.Sprintf("admin user %s is not found", updateUser.Name), http.StatusBadRequest)
+		return
+	}
+	if updateUser.Role == "" {
+		updateUser.Role = user.Role
+	}
+	if !isRole(Role(updateUser.Role)) {
+		http.Error(w, fmt.Sprintf("unknown role: %s", updateUser.Role), http.StatusBadRequest)
+		return
+	}
+	if !canGrant(caller.role, Role(user.Role)) || !canGrant(caller.role, Role(updateUser.Role)) {
+		http.Error(w, fmt.Sprintf("permission denied, %s can't grant role %s", caller.name, updateUser.Role), http.StatusForbidden)
+		return
+	}
+	if user.ID == caller.userID && (updateUser.Disabled || updateUser.Role != user.Role) {
+		http.Error(w, "user can't disable itself or change its own role", http.StatusBadRequest)
+		return
+	}
+
+	toUpdate := map[string]interface{}{
+		"role":     updateUser.Role,
+		"disabled": updateUser.Disabled,
+	}
+	var apiSecret string
+	if updateUser.RotateSecret {
+		if apiSecret, err = newScopedApiKey(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		toUpdate["api_secret"] = apiSecret
+	}
+	if err := admin.DB.Model(&user).Updates(toUpdate).Error; err != nil {
+		http.Error(w, fmt.Sprintf("update admin user error, err=%s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	util.Logger.Infof("admin user %s is updated by %s, role %s, disabled %v, secret rotated %v",
+		user.Name, caller.name, updateUser.Role, updateUser.Disabled, updateUser.RotateSecret)
+
+	user.Role, user.Disabled = updateUser.Role, updateUser.Disabled
+	resp := newAdminUserResponse(&user)
+	resp.ApiSecret = apiSecret
+	writeJson(w, http.StatusOK, resp)
+}
+
+// TokenHandler issues a token to the admin user of the signed request, the token is sent as a bearer token instead
+// of signing every request
+func (admin *Admin) TokenHandler(w http.ResponseWriter, r *http.Request) {
+	authorized, err := getAuthorizedRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	caller := authorized.principal
+	if caller.userID == 0 || caller.token {
+		http.Error(w, "tokens are only issued to the admin users signing the request with their api secrets", http.StatusForbidden)
+		return
+	}
+
+	now := time.Now()
+	expireTime := now.Add(admin.cfg.AdminConfig.GetTokenTTL())
+	token, err := admin.jwtSigner.Sign(util.JWTClaims{
+		Subject:   caller.name,
+		Role:      string(caller.role),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expireTime.Unix(),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, http.StatusOK, tokenResponse{Token: token, TokenSecret: admin.jwtSigner.TokenSecret(token), Role: string(caller.role),
+		ExpireTime: expireTime.Unix()})
+}
@@ -0,0 +1,152 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+const MaxListWebhookDeliveriesLimit = 1000
+
+var webhookIDParam = apiParam{Name: "webhook_id", In: "query", Type: "integer", Required: true, Description: "id of the webhook"}
+
+// webhookApiKey is the api key the webhooks of the caller are registered with, it is empty for the admin users
+func webhookApiKey(p *principal, r *http.Request) string {
+	if !p.isPairOwner() {
+		return ""
+	}
+	return r.Header.Get("ApiKey")
+}
+
+// AddWebhookHandler registers a webhook called when the swaps of the sponsor succeed or fail. The webhooks of the
+// scoped api keys are only called for the swaps of the owned pairs.
+func (admin *Admin) AddWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	authorized, err := getAuthorizedRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, reqBody := authorized.principal, authorized.payload
+
+	var addWebhook addWebhookRequest
+	err = json.Unmarshal(reqBody, &addWebhook)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := admin.cfg.WebhookConfig.CheckUrl(addWebhook.Url); err != nil {
+		http.Error(w, fmt.Sprintf("invalid webhook url: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	sponsor := ""
+	if addWebhook.Sponsor != "" {
+		if !common.IsHexAddress(addWebhook.Sponsor) {
+			http.Error(w, fmt.Sprintf("invalid sponsor address: %s", addWebhook.Sponsor), http.StatusBadRequest)
+			return
+		}
+		sponsor = common.HexToAddress(addWebhook.Sponsor).String()
+	}
+
+	secret, err := newScopedApiKey()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	webhook := model.Webhook{
+		Url:     addWebhook.Url,
+		Secret:  secret,
+		Sponsor: sponsor,
+		ApiKey:  webhookApiKey(p, r),
+	}
+	if err := admin.DB.Create(&webhook).Error; err != nil {
+		http.Error(w, fmt.Sprintf("add webhook error, err=%s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	util.Logger.Infof("webhook %d is added by %s, url %s, sponsor %s", webhook.ID, p.name, webhook.Url, webhook.Sponsor)
+
+	writeJson(w, http.StatusOK, webhookResponse{
+		ID:      webhook.ID,
+		Url:     webhook.Url,
+		Sponsor: webhook.Sponsor,
+		ApiKey:  webhook.ApiKey,
+		Secret:  webhook.Secret,
+	})
+}
+
+// RemoveWebhookHandler removes a webhook of the caller, the pending deliveries of it are given up
+func (admin *Admin) RemoveWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	authorized, err := getAuthorizedRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, reqBody := authorized.principal, authorized.payload
+
+	var removeWebhook removeWebhookRequest
+	err = json.Unmarshal(reqBody, &removeWebhook)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := admin.DB.Where("id = ?", removeWebhook.ID)
+	if p.isPairOwner() {
+		query = query.Where("api_key = ?", webhookApiKey(p, r))
+	}
+	res := query.Delete(model.Webhook{})
+	if res.Error != nil {
+		http.Error(w, fmt.Sprintf("remove webhook error, err=%s", res.Error.Error()), http.StatusInternalServerError)
+		return
+	}
+	if res.RowsAffected == 0 {
+		http.Error(w, "webhook is not found", http.StatusBadRequest)
+		return
+	}
+	util.Logger.Infof("webhook %d is removed by %s", removeWebhook.ID, p.name)
+	writeJson(w, http.StatusOK, removeWebhook)
+}
+
+// WebhookDeliveriesHandler returns the latest deliveries of a webhook of the caller
+func (admin *Admin) WebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	authorized, err := getAuthorizedRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p := authorized.principal
+
+	webhookID, err := strconv.ParseUint(r.URL.Query().Get("webhook_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid webhook_id", http.StatusBadRequest)
+		return
+	}
+	limit := DefaultListSwapsLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l <= 0 || l > MaxListWebhookDeliveriesLimit {
+			http.Error(w, fmt.Sprintf("limit should be between 1 and %d", MaxListWebhookDeliveriesLimit), http.StatusBadRequest)
+			return
+		}
+		limit = l
+	}
+
+	webhook := model.Webhook{}
+	if err := admin.DB.Where("id = ?", webhookID).First(&webhook).Error; err != nil || (p.isPairOwner() && webhook.ApiKey != webhookApiKey(p, r)) {
+		http.Error(w, "webhook is not found", http.StatusBadRequest)
+		return
+	}
+
+	deliveries := make([]model.WebhookDelivery, 0)
+	err = admin.DB.Where("webhook_id = ?", webhook.ID).Order("id desc").Limit(limit).Find(&deliveries).Error
+	if err != nil {
+		http.Error(w, fmt.Sprintf("query webhook deliveries error, err=%s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, http.StatusOK, deliveries)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"occ-swap-server/swap"
+	"occ-swap-server/util"
+)
+
+const (
+	dashboardFile  = "grafana/bridge-dashboard.json"
+	alertRulesFile = "prometheus/bridge-alerts.json"
+)
+
+// dashboards writes the grafana dashboard and the prometheus alert rules of the metrics the server exposes, run it
+// with make dashboards after adding or renaming a metric
+func main() {
+	output := flag.String("output", "ops", "directory the dashboard and the alert rules are written to")
+	configPath := flag.String("config-path", "", "config of the relayer balance alert thresholds and the queue max ages, the thresholds are 0 and the max ages are the defaults without it")
+	flag.Parse()
+
+	cfg := &util.Config{}
+	if *configPath != "" {
+		cfg = util.ParseConfigFromFile(*configPath)
+	}
+	dashboard, rules, err := swap.MarshalDashboards(cfg.ChainConfig, cfg.QueueAgeConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "generate dashboards error: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	for file, content := range map[string][]byte{dashboardFile: dashboard, alertRulesFile: rules} {
+		path := filepath.Join(*output, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "create directory of %s error: %s\n", path, err.Error())
+			os.Exit(1)
+		}
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "write %s error: %s\n", path, err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("wrote %s\n", path)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"occ-swap-server/swap/swaptest"
+	"occ-swap-server/util"
+)
+
+// loadgen runs the swap engine against the in-memory chains of swaptest, injects swaps at the rate and prints the
+// throughput and the latency percentiles of the engine. Compare the reports of two builds with the same flags to
+// measure a regression of the daemons.
+func main() {
+	chain := flag.String("chain", "BSC", "chain the swaps are started on, BSC, ETH or CRO")
+	toChainId := flag.Int64("to-chain-id", 4, "chain id of the destination chain in the testnet environment")
+	rate := flag.Float64("rate", 5, "swaps injected a second")
+	count := flag.Int("count", 100, "number of the swaps to inject")
+	amount := flag.String("amount", "1000", "amount of every swap in the smallest unit of the mock token")
+	timeout := flag.Duration("timeout", 2*time.Minute, "time to wait for the swaps after the last one is injected")
+	blockInterval := flag.Duration("block-interval", 100*time.Millisecond, "interval the chains are mined")
+	logLevel := flag.String("log-level", "ERROR", "level of the engine logs")
+	jsonOutput := flag.Bool("json", false, "print the report as json")
+	flag.Parse()
+
+	swapAmount, ok := big.NewInt(0).SetString(*amount, 10)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "invalid --amount: %s\n", *amount)
+		os.Exit(2)
+	}
+	util.InitLogger(util.LogConfig{Level: strings.ToUpper(*logLevel), UseConsoleLogger: true})
+
+	h, err := swaptest.NewHarness()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "set up harness error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	// the daemons still poll the db once it is closed
+	h.DB.LogMode(false)
+	defer h.Close()
+
+	engine, err := h.NewSwapEngine()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "create swap engine error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	engine.Start()
+	stop := h.AutoCommit(*blockInterval)
+	defer stop()
+
+	report, err := h.RunLoad(swaptest.LoadOptions{
+		Chain:     strings.ToUpper(*chain),
+		ToChainId: *toChainId,
+		Amount:    swapAmount,
+		Rate:      *rate,
+		Count:     *count,
+		Timeout:   *timeout,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "run load error: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if *jsonOutput {
+		jsonBytes, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(jsonBytes))
+	} else {
+		printReport(report)
+	}
+	if report.Succeeded != report.Injected {
+		os.Exit(1)
+	}
+}
+
+func printReport(report *swaptest.LoadReport) {
+	fmt.Printf("injected %d swaps at %.2f/s, %d succeeded, %d failed, %d pending after %s\n",
+		report.Injected, report.InjectionRate, report.Succeeded, report.Failed, report.Pending, report.Elapsed)
+	for status, count := range report.FailedStatuses {
+		fmt.Printf("  %d swaps %s\n", count, status)
+	}
+	fmt.Printf("throughput %.2f swaps/s\n", report.Throughput)
+	fmt.Printf("%-18s %8s %8s %8s %8s %8s\n", "stage", "samples", "p50 ms", "p90 ms", "p99 ms", "max ms")
+	for _, stage := range []string{swaptest.LoadStageConfirm, swaptest.LoadStageFill, swaptest.LoadStageTotal} {
+		latency := report.Latency[stage]
+		fmt.Printf("%-18s %8d %8d %8d %8d %8d\n", stage, latency.Samples, latency.P50, latency.P90, latency.P99, latency.Max)
+	}
+}
@@ -87,16 +87,15 @@ func main() {
 		return
 	}
 
-	signer := util.NewHmacSigner(req.ApiKey, req.ApiSecret)
-	hash := signer.Sign(body)
-
 	httpReq, err := http.NewRequest(req.Method, req.Endpoint, bytes.NewReader(body))
 	if err != nil {
 		println("new request error")
 		return
 	}
-	httpReq.Header.Set("ApiKey", req.ApiKey)
-	httpReq.Header.Set("Authorization", hash)
+	if err := util.NewHmacSigner(req.ApiKey, req.ApiSecret).SignRequest(httpReq, body); err != nil {
+		println(fmt.Sprintf("sign request error, err=%s", err.Error()))
+		return
+	}
 
 	resp, err := http.DefaultClient.Do(httpReq)
 	if err != nil {
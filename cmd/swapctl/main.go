@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"occ-swap-server/util"
+)
+
+const (
+	flagEndpoint    = "endpoint"
+	flagApiKey      = "api-key"
+	flagApiSecret   = "api-secret"
+	flagToken       = "token"
+	flagTokenSecret = "token-secret"
+	flagTLSCert     = "tls-cert"
+	flagTLSKey      = "tls-key"
+	flagTLSCA       = "tls-ca"
+
+	flagStatus = "status"
+	flagLimit  = "limit"
+	flagChain  = "chain"
+	flagFrom   = "from"
+	flagTo     = "to"
+	flagType   = "type"
+	flagOutput = "output"
+
+	flagQuarantine = "quarantine"
+
+	flagFile   = "file"
+	flagSource = "source"
+	flagBatch  = "batch"
+)
+
+// newHttpClient returns the client of the admin api, it presents the client certificate if there is one, for the
+// secure listener with mutual tls
+func newHttpClient() (*http.Client, error) {
+	certFile, keyFile, caFile := viper.GetString(flagTLSCert), viper.GetString(flagTLSKey), viper.GetString(flagTLSCA)
+	if certFile == "" && caFile == "" {
+		return http.DefaultClient, nil
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate error, err=%s", err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		caPEM, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file error, err=%s", err.Error())
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificate in ca file %s", caFile)
+		}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}, nil
+}
+
+// doRequest sends a request signed with the api key and secret of the admin user, or with its token, to the admin
+// api and returns the response body
+func doRequest(method, path string, body interface{}) ([]byte, error) {
+	endpoint := strings.TrimRight(viper.GetString(flagEndpoint), "/")
+	apiKey := viper.GetString(flagApiKey)
+	apiSecret := viper.GetString(flagApiSecret)
+	token := viper.GetString(flagToken)
+	tokenSecret := viper.GetString(flagTokenSecret)
+
+	payload := make([]byte, 0)
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("marshal request body error, err=%s", err.Error())
+		}
+	}
+
+	httpReq, err := http.NewRequest(method, endpoint+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("new request error, err=%s", err.Error())
+	}
+	if token != "" {
+		if err := util.SignTokenRequest(httpReq, token, tokenSecret, payload); err != nil {
+			return nil, fmt.Errorf("sign request error, err=%s", err.Error())
+		}
+	} else if apiKey != "" && apiSecret != "" {
+		if err := util.NewHmacSigner(apiKey, apiSecret).SignRequest(httpReq, payload); err != nil {
+			return nil, fmt.Errorf("sign request error, err=%s", err.Error())
+		}
+	}
+
+	client, err := newHttpClient()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("send request error, err=%s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	resBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("get response body error, err=%s", err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resBody, fmt.Errorf("request failed, status code %d", resp.StatusCode)
+	}
+	return resBody, nil
+}
+
+// sendRequest sends a signed request to the admin api and prints the response
+func sendRequest(method, path string, body interface{}) error {
+	resBody, err := doRequest(method, path, body)
+	if resBody != nil {
+		fmt.Println(string(resBody))
+	}
+	return err
+}
+
+func pendingCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pending",
+		Short: "List pending swaps, or swaps of the given status",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			status, _ := cmd.Flags().GetString(flagStatus)
+			limit, _ := cmd.Flags().GetInt(flagLimit)
+			return sendRequest(http.MethodGet, fmt.Sprintf("/swaps?status=%s&limit=%d", status, limit), nil)
+		},
+	}
+	cmd.Flags().String(flagStatus, "pending", "swap status")
+	cmd.Flags().Int(flagLimit, 100, "max number of swaps to list")
+	return cmd
+}
+
+func timelineCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "timeline [start_tx_hash]",
+		Short: "Show the full timeline of a swap",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return sendRequest(http.MethodGet, fmt.Sprintf("/swaps/%s/timeline", args[0]), nil)
+		},
+	}
+}
+
+func requeueCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "requeue [swap_id]...",
+		Short: "Requeue failed swaps",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			swapIDList := make([]uint, 0, len(args))
+			for _, arg := range args {
+				id, err := strconv.ParseUint(arg, 10, 64)
+				if err != nil {
+					return fmt.Errorf("invalid swap id: %s", arg)
+				}
+				swapIDList = append(swapIDList, uint(id))
+			}
+			return sendRequest(http.MethodPost, "/retry_failed_swaps", map[string]interface{}{
+				"swap_id_list": swapIDList,
+			})
+		},
+	}
+}
+
+func pauseCmd(paused bool) *cobra.Command {
+	use, short := "pause [direction]", "Pause sending fill txs of a swap direction"
+	if !paused {
+		use, short = "resume [direction]", "Resume sending fill txs of a swap direction"
+	}
+	return &cobra.Command{
+		Use:   use,
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return sendRequest(http.MethodPost, "/pause_direction", map[string]interface{}{
+				"direction": args[0],
+				"paused":    paused,
+			})
+		},
+	}
+}
+
+func backfillCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "backfill",
+		Short: "Re-scan a block range of a chain for missing swap start events",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			chain, _ := cmd.Flags().GetString(flagChain)
+			from, _ := cmd.Flags().GetInt64(flagFrom)
+			to, _ := cmd.Flags().GetInt64(flagTo)
+			return sendRequest(http.MethodPost, "/backfill", map[string]interface{}{
+				"chain":       chain,
+				"from_height": from,
+				"to_height":   to,
+			})
+		},
+	}
+	cmd.Flags().String(flagChain, "", "chain name, BSC, ETH or CRO")
+	cmd.Flags().Int64(flagFrom, 0, "start height")
+	cmd.Flags().Int64(flagTo, 0, "end height")
+	return cmd
+}
+
+func exportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export the swaps or the fill txs of a date range as csv",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			exportType, _ := cmd.Flags().GetString(flagType)
+			from, _ := cmd.Flags().GetString(flagFrom)
+			to, _ := cmd.Flags().GetString(flagTo)
+			output, _ := cmd.Flags().GetString(flagOutput)
+			resBody, err := doRequest(http.MethodGet, fmt.Sprintf("/export?type=%s&from=%s&to=%s", exportType, from, to), nil)
+			if err != nil {
+				if resBody != nil {
+					fmt.Println(string(resBody))
+				}
+				return err
+			}
+			if output == "" {
+				fmt.Print(string(resBody))
+				return nil
+			}
+			return ioutil.WriteFile(output, resBody, 0644)
+		},
+	}
+	cmd.Flags().String(flagType, "swaps", "swaps or fill_txs")
+	cmd.Flags().String(flagFrom, "", "first day, yyyy-mm-dd")
+	cmd.Flags().String(flagTo, "", "last day, yyyy-mm-dd")
+	cmd.Flags().String(flagOutput, "", "output file, stdout if empty")
+	return cmd
+}
+
+func feeAuditCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "fee-audit",
+		Short: "Re-price the swaps of a date range under the current fee schedule and export the discrepancies as csv",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			from, _ := cmd.Flags().GetString(flagFrom)
+			to, _ := cmd.Flags().GetString(flagTo)
+			output, _ := cmd.Flags().GetString(flagOutput)
+			resBody, err := doRequest(http.MethodGet, fmt.Sprintf("/fee_audit?from=%s&to=%s", from, to), nil)
+			if err != nil {
+				if resBody != nil {
+					fmt.Println(string(resBody))
+				}
+				return err
+			}
+			if output == "" {
+				fmt.Print(string(resBody))
+				return nil
+			}
+			return ioutil.WriteFile(output, resBody, 0644)
+		},
+	}
+	cmd.Flags().String(flagFrom, "", "first day, yyyy-mm-dd")
+	cmd.Flags().String(flagTo, "", "last day, yyyy-mm-dd")
+	cmd.Flags().String(flagOutput, "", "output file, stdout if empty")
+	return cmd
+}
+
+func integritySweepCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "integrity-sweep",
+		Short: "Verify the record hashes of all the swaps and report the tampered ones",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			quarantine, _ := cmd.Flags().GetBool(flagQuarantine)
+			return sendRequest(http.MethodPost, "/integrity_sweep", map[string]interface{}{
+				"quarantine": quarantine,
+			})
+		},
+	}
+	cmd.Flags().Bool(flagQuarantine, false, "move the tampered swaps and the swaps without a record hash to the quarantined swaps")
+	return cmd
+}
+
+// importIntColumns are the columns of the csv dumps of swaps which are numbers in the import request
+var importIntColumns = map[string]bool{"decimals": true, "create_time": true}
+
+// readImportFile reads the swaps of a json dump, an array of swaps, or of a csv dump whose header names the fields of
+// the swaps
+func readImportFile(file string) ([]map[string]interface{}, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read dump error, err=%s", err.Error())
+	}
+	swaps := make([]map[string]interface{}, 0)
+	if strings.ToLower(filepath.Ext(file)) == ".json" {
+		if err := json.Unmarshal(content, &swaps); err != nil {
+			return nil, fmt.Errorf("unmarshal dump error, err=%s", err.Error())
+		}
+		return swaps, nil
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("read csv dump error, err=%s", err.Error())
+	}
+	if len(records) == 0 {
+		return swaps, nil
+	}
+	header := records[0]
+	for i, record := range records[1:] {
+		swap := make(map[string]interface{}, len(header))
+		for j, column := range header {
+			column = strings.TrimSpace(column)
+			if !importIntColumns[column] {
+				swap[column] = record[j]
+				continue
+			}
+			value, err := strconv.ParseInt(strings.TrimSpace(record[j]), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s of row %d: %s", column, i+2, record[j])
+			}
+			swap[column] = value
+		}
+		swaps = append(swaps, swap)
+	}
+	return swaps, nil
+}
+
+func importCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Import the swaps of a csv or json dump of a previous bridge deployment",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file, _ := cmd.Flags().GetString(flagFile)
+			source, _ := cmd.Flags().GetString(flagSource)
+			batch, _ := cmd.Flags().GetInt(flagBatch)
+			if file == "" || source == "" {
+				return fmt.Errorf("file and source are required")
+			}
+			if batch <= 0 {
+				return fmt.Errorf("invalid batch size: %d", batch)
+			}
+			swaps, err := readImportFile(file)
+			if err != nil {
+				return err
+			}
+			// the swaps already imported are skipped, so the import is run again after a failed batch
+			for start := 0; start < len(swaps); start += batch {
+				end := start + batch
+				if end > len(swaps) {
+					end = len(swaps)
+				}
+				fmt.Printf("swaps %d to %d of %d\n", start, end-1, len(swaps))
+				if err := sendRequest(http.MethodPost, "/import_swaps", map[string]interface{}{
+					"source": source,
+					"swaps":  swaps[start:end],
+				}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().String(flagFile, "", "dump file, json if its extension is .json, csv otherwise")
+	cmd.Flags().String(flagSource, "", "name of the previous deployment, recorded on the imported swaps")
+	cmd.Flags().Int(flagBatch, 500, "swaps per request, at most 1000")
+	return cmd
+}
+
+func main() {
+	rootCmd := &cobra.Command{
+		Use:          "swapctl",
+		Short:        "Operator tool to inspect and intervene in the swap backend through the admin api",
+		SilenceUsage: true,
+	}
+	rootCmd.PersistentFlags().String(flagEndpoint, "http://127.0.0.1:8080", "admin api endpoint")
+	rootCmd.PersistentFlags().String(flagApiKey, "", "admin api key")
+	rootCmd.PersistentFlags().String(flagApiSecret, "", "admin api secret")
+	rootCmd.PersistentFlags().String(flagTLSCert, "", "client certificate file of the secure listener")
+	rootCmd.PersistentFlags().String(flagTLSKey, "", "client key file of the secure listener")
+	rootCmd.PersistentFlags().String(flagTLSCA, "", "ca file of the server certificate, the system cas if empty")
+	rootCmd.PersistentFlags().String(flagToken, "", "token issued by /auth/token, used instead of the api secret")
+	rootCmd.PersistentFlags().String(flagTokenSecret, "", "token_secret issued along with the token, it signs the requests sent with the token")
+
+	viper.SetEnvPrefix("swapctl")
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	viper.AutomaticEnv()
+	if err := viper.BindPFlags(rootCmd.PersistentFlags()); err != nil {
+		panic(fmt.Sprintf("bind flags error, err=%s", err))
+	}
+
+	rootCmd.AddCommand(pendingCmd(), timelineCmd(), requeueCmd(), pauseCmd(true), pauseCmd(false), backfillCmd(), exportCmd(),
+		feeAuditCmd(), integritySweepCmd(), importCmd())
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,105 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+// the swap statuses are declared with their type so the exhaustive linter checks the switches on them, the swap
+// package names them after the stages of the lifecycle
+const (
+	SwapStatusReceived          SwapStatus = "received"
+	SwapStatusRejected          SwapStatus = "rejected"
+	SwapStatusConfirmed         SwapStatus = "confirmed"
+	SwapStatusAwaitingLiquidity SwapStatus = "awaiting_liquidity"
+	SwapStatusDelayed           SwapStatus = "delayed"
+	SwapStatusSending           SwapStatus = "sending"
+	SwapStatusSent              SwapStatus = "sent"
+	SwapStatusSendFailed        SwapStatus = "sent_fail"
+	SwapStatusSuccess           SwapStatus = "sent_success"
+	SwapStatusMismatch          SwapStatus = "mismatch"
+	SwapStatusAbandoned         SwapStatus = "abandoned"
+	SwapStatusUneconomic        SwapStatus = "uneconomic"
+	SwapStatusExpired           SwapStatus = "expired"
+	SwapStatusAwaitingWindow    SwapStatus = "awaiting_window"
+	SwapStatusAwaitingGas       SwapStatus = "awaiting_gas"
+	SwapStatusVerifyRejected    SwapStatus = "verify_rejected"
+)
+
+const (
+	SwapDirectionETH2BSC   SwapDirection = "eth_bsc"
+	SwapDirectionETH2MATIC SwapDirection = "eth_matic"
+	SwapDirectionBSC2ETH   SwapDirection = "bsc_eth"
+	SwapDirectionBSC2MATIC SwapDirection = "bsc_matic"
+	SwapDirectionMATIC2BSC SwapDirection = "matic_bsc"
+	SwapDirectionMATIC2ETH SwapDirection = "matic_eth"
+)
+
+// SwapStatuses is the registry of the swap statuses, a status is added here with its constant, the db check
+// constraints and the status validation are derived from it
+var SwapStatuses = []SwapStatus{
+	SwapStatusReceived,
+	SwapStatusRejected,
+	SwapStatusConfirmed,
+	SwapStatusAwaitingLiquidity,
+	SwapStatusDelayed,
+	SwapStatusSending,
+	SwapStatusSent,
+	SwapStatusSendFailed,
+	SwapStatusSuccess,
+	SwapStatusMismatch,
+	SwapStatusAbandoned,
+	SwapStatusUneconomic,
+	SwapStatusExpired,
+	SwapStatusAwaitingWindow,
+	SwapStatusAwaitingGas,
+	SwapStatusVerifyRejected,
+}
+
+// SwapDirections is the registry of the swap directions, like SwapStatuses
+var SwapDirections = []SwapDirection{
+	SwapDirectionETH2BSC,
+	SwapDirectionETH2MATIC,
+	SwapDirectionBSC2ETH,
+	SwapDirectionBSC2MATIC,
+	SwapDirectionMATIC2BSC,
+	SwapDirectionMATIC2ETH,
+}
+
+// Valid returns whether the status is in the registry
+func (status SwapStatus) Valid() bool {
+	for _, s := range SwapStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Valid returns whether the direction is in the registry
+func (direction SwapDirection) Valid() bool {
+	for _, d := range SwapDirections {
+		if d == direction {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseSwapStatus returns the registered status of the value, the case and the surrounding spaces are ignored
+func ParseSwapStatus(value string) (SwapStatus, error) {
+	status := SwapStatus(strings.ToLower(strings.TrimSpace(value)))
+	if !status.Valid() {
+		return "", fmt.Errorf("unknown swap status: %q", value)
+	}
+	return status, nil
+}
+
+// ParseSwapDirection returns the registered direction of the value, like ParseSwapStatus
+func ParseSwapDirection(value string) (SwapDirection, error) {
+	direction := SwapDirection(strings.ToLower(strings.TrimSpace(value)))
+	if !direction.Valid() {
+		return "", fmt.Errorf("unknown swap direction: %q", value)
+	}
+	return direction, nil
+}
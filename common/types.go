@@ -7,18 +7,20 @@ const (
 	ObserverPruneInterval  = 10 * time.Second
 	ObserverAlertInterval  = 5 * time.Second
 
-	ChainBSC   = "BSC"   // binance smart chain
-	ChainETH   = "ETH"   // ethereum
+	ChainBSC = "BSC" // binance smart chain
+	ChainETH = "ETH" // ethereum
 	// ChainMATIC = "MATIC" //polygon
 	ChainMATIC = "CRO" //cronos
 
 	VaultName = "BSC_ETH_SWAP"
 
-	DBDialectMysql   = "mysql"
-	DBDialectSqlite3 = "sqlite3"
+	DBDialectMysql    = "mysql"
+	DBDialectSqlite3  = "sqlite3"
+	DBDialectPostgres = "postgres"
 
-	LocalPrivateKey = "local_private_key"
-	AWSPrivateKey   = "aws_private_key"
+	LocalPrivateKey    = "local_private_key"
+	AWSPrivateKey      = "aws_private_key"
+	KeystorePrivateKey = "keystore_private_key"
 )
 
 type SwapStatus string
@@ -26,6 +28,47 @@ type SwapPairStatus string
 type RetrySwapStatus string
 type SwapDirection string
 
+// AssetType is the kind of the tokens of a swap pair
+type AssetType string
+
+const (
+	AssetTypeFungible AssetType = "fungible"
+	AssetTypeERC721   AssetType = "erc721"
+)
+
+// FailureClass is the kind of failure of a fill tx, it selects the auto-retry policy of the failed swap
+type FailureClass string
+
+const (
+	FailureRPC         FailureClass = "rpc"         // the fill tx can't be sent to the node
+	FailureRevert      FailureClass = "revert"      // the fill tx is reverted on chain
+	FailureUnderpriced FailureClass = "underpriced" // the fill tx is rejected as replacement underpriced
+	FailureConnection  FailureClass = "connection"  // the node refuses the connection
+	FailureTimeout     FailureClass = "timeout"     // the node doesn't answer in time
+	FailureNonce       FailureClass = "nonce"       // the nonce of the fill tx is already used
+	// the relayer can't pay the gas of the fill tx
+	FailureInsufficientFunds FailureClass = "insufficient_funds"
+	// the recipient of the swap is blocked by the screening
+	FailureBlocked FailureClass = "blocked"
+)
+
+// FailureCategory tells whether the swaps of a failure class can be retried automatically
+type FailureCategory string
+
+const (
+	FailureTransient FailureCategory = "transient"
+	FailurePermanent FailureCategory = "permanent"
+)
+
+// Category returns the category of the failure class, the reverted fill txs and the blocked recipients are
+// permanent, a retry fails again
+func (class FailureClass) Category() FailureCategory {
+	if class == FailureRevert || class == FailureBlocked {
+		return FailurePermanent
+	}
+	return FailureTransient
+}
+
 type BlockAndEventLogs struct {
 	Height          int64
 	Chain           string
@@ -34,3 +77,10 @@ type BlockAndEventLogs struct {
 	BlockTime       int64
 	Events          []interface{}
 }
+
+// RangeEventLogs are the events of the blocks from FromHeight to Height, ParentBlockHash is the parent of the first
+// block and BlockHash and BlockTime are of the last block
+type RangeEventLogs struct {
+	FromHeight int64
+	BlockAndEventLogs
+}
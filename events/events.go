@@ -0,0 +1,392 @@
+// Package events decodes the events of the swap agent contracts. It is shared by the observer, which saves the
+// SwapStarted events, and the swap engine, which verifies the start txs and the fill txs against the saved
+// records, so both sides decode the logs the same way. Decoding doesn't modify the log, decoding the same log
+// again returns the same event.
+package events
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	agent "occ-swap-server/abi"
+	"occ-swap-server/common"
+	"occ-swap-server/util"
+)
+
+const (
+	SwapStartedEventName    = "SwapStarted"
+	SwapFilledEventName     = "SwapFilled"
+	SwapNFTStartedEventName = "SwapNFTStarted"
+	SwapNFTFilledEventName  = "SwapNFTFilled"
+	// SwapPermit2StartedEventName is the SwapStarted variant of the swaps started with a permit2 signature
+	SwapPermit2StartedEventName = "SwapPermit2Started"
+
+	// Permit2AbiVersion is the version of the SwapStarted events decoded from the SwapPermit2Started events
+	Permit2AbiVersion = "permit2"
+)
+
+var (
+	// SwapStartedTopic and SwapFilledTopic are the event ids of the compiled-in swap agent abi
+	SwapStartedTopic ethcom.Hash
+	SwapFilledTopic  ethcom.Hash
+
+	// DefaultDecoder decodes the events of the compiled-in swap agent abi only, of no chain in particular
+	DefaultDecoder *Decoder
+)
+
+// swapStartedTokenArgs are the names of the token argument of the SwapStarted event in the abi versions
+var swapStartedTokenArgs = []string{"token", "tokenAddress"}
+
+// legacyTokenArg returns the name of the token of the source chain in the legacy abis, which emit both the bep20 and
+// the erc20 addresses of the pair
+func legacyTokenArg(chain string) string {
+	if chain == common.ChainBSC {
+		return "bep20Addr"
+	}
+	return "erc20Addr"
+}
+
+// swapStartedRecipientArgs are the names of the recipient argument of the SwapStarted event in the abi versions
+var swapStartedRecipientArgs = []string{"recipient", "toAddress"}
+
+// swapFilledStartTxHashArgs are the names of the start tx hash argument of the SwapFilled event in the abi versions
+var swapFilledStartTxHashArgs = []string{"startTxHash", "swapTxHash"}
+
+// ErrUnknownEvent is returned if the log is not the event of any abi version of the decoder
+var ErrUnknownEvent = errors.New("unknown event")
+
+func init() {
+	decoder, err := NewDecoder("", nil)
+	if err != nil {
+		panic(fmt.Sprintf("parse swap agent abi error, err=%s", err.Error()))
+	}
+	DefaultDecoder = decoder
+	SwapStartedTopic = decoder.versions[0].Abi.Events[SwapStartedEventName].ID()
+	SwapFilledTopic = decoder.versions[0].Abi.Events[SwapFilledEventName].ID()
+}
+
+// SwapStarted is emitted by the swap agent of the source chain when a user starts a swap
+type SwapStarted struct {
+	FromChainId *big.Int
+	ToChainId   *big.Int
+	FromAddress ethcom.Address
+	Amount      *big.Int
+	// nil if the abi version has no feeAmount
+	FeeAmount *big.Int
+	// the token of the source chain, zero if the abi version doesn't emit it, the swap agent then has one token a
+	// chain, see executor.ResolveSwapToken
+	Token ethcom.Address
+	// the account paid on the destination chain, zero if the abi version doesn't emit it, the sponsor is paid then
+	Recipient ethcom.Address
+	// version of the abi the event is decoded with
+	Version string
+}
+
+// SwapFilled is emitted by the swap agent of the destination chain when a swap is filled
+type SwapFilled struct {
+	FromChainId *big.Int
+	ToChainId   *big.Int
+	ToAddress   ethcom.Address
+	Amount      *big.Int
+	// the swap start tx the swap agent is told the fill is of, zero if the abi version doesn't emit it
+	StartTxHash ethcom.Hash
+	Version     string
+}
+
+// SwapNFTStarted is emitted by the swap agent of the source chain when a user starts a swap of an erc721 token
+type SwapNFTStarted struct {
+	FromChainId *big.Int
+	ToChainId   *big.Int
+	FromAddress ethcom.Address
+	Collection  ethcom.Address
+	TokenId     *big.Int
+}
+
+// SwapNFTFilled is emitted by the swap agent of the destination chain when a swap of an erc721 token is filled
+type SwapNFTFilled struct {
+	FromChainId *big.Int
+	ToChainId   *big.Int
+	ToAddress   ethcom.Address
+	Collection  ethcom.Address
+	TokenId     *big.Int
+}
+
+// AbiVersion is a version of the swap agent abi
+type AbiVersion struct {
+	Version string
+	Abi     abi.ABI
+}
+
+// Decoder decodes the logs with the abi version whose event id matches the first topic of the log, the versions
+// may differ in which arguments are indexed but keep their names
+type Decoder struct {
+	// the chain of the swap agent, the source chain of the SwapStarted events
+	chain    string
+	versions []*AbiVersion
+	// the erc721 events have a single version
+	nftAbi abi.ABI
+	// and so does the permit2 variant of SwapStarted
+	permit2Abi abi.ABI
+}
+
+// NewDecoder returns a decoder of the compiled-in abi followed by the historical abis of the swap agent of the chain
+func NewDecoder(chain string, historicalAbis []util.SwapAgentAbiConfig) (*Decoder, error) {
+	currentAbi, err := abi.JSON(strings.NewReader(agent.SwapAgentABI))
+	if err != nil {
+		return nil, err
+	}
+	versions := []*AbiVersion{{Version: util.CurrentSwapAgentAbiVersion, Abi: currentAbi}}
+	for _, abiCfg := range historicalAbis {
+		historicalAbi, err := abiCfg.Load()
+		if err != nil {
+			return nil, fmt.Errorf("load swap agent abi %s error: %s", abiCfg.Version, err.Error())
+		}
+		versions = append(versions, &AbiVersion{Version: abiCfg.Version, Abi: historicalAbi})
+	}
+	nftAbi, err := abi.JSON(strings.NewReader(agent.NFTSwapAgentABI))
+	if err != nil {
+		return nil, err
+	}
+	permit2Abi, err := abi.JSON(strings.NewReader(agent.Permit2SwapAgentABI))
+	if err != nil {
+		return nil, err
+	}
+	return &Decoder{chain: chain, versions: versions, nftAbi: nftAbi, permit2Abi: permit2Abi}, nil
+}
+
+// SwapStartedTopics returns the distinct SwapStarted event ids of the abi versions to filter the logs with, and the
+// id of the permit2 variant
+func (d *Decoder) SwapStartedTopics() []ethcom.Hash {
+	return append(d.topics(SwapStartedEventName), d.permit2Abi.Events[SwapPermit2StartedEventName].ID())
+}
+
+// SwapFilledTopics returns the distinct SwapFilled event ids of the abi versions to filter the logs with
+func (d *Decoder) SwapFilledTopics() []ethcom.Hash {
+	return d.topics(SwapFilledEventName)
+}
+
+// SwapNFTStartedTopics returns the SwapNFTStarted event id to filter the logs with
+func (d *Decoder) SwapNFTStartedTopics() []ethcom.Hash {
+	return []ethcom.Hash{d.nftAbi.Events[SwapNFTStartedEventName].ID()}
+}
+
+func (d *Decoder) topics(name string) []ethcom.Hash {
+	topics := make([]ethcom.Hash, 0, len(d.versions))
+	seen := make(map[ethcom.Hash]bool)
+	for _, version := range d.versions {
+		event, ok := version.Abi.Events[name]
+		if !ok || seen[event.ID()] {
+			continue
+		}
+		topics = append(topics, event.ID())
+		seen[event.ID()] = true
+	}
+	return topics
+}
+
+// findEvent returns the first abi version which has the event of the name whose id is the first topic of the log,
+// a SwapPermit2Started log is found as a SwapStarted event of the permit2 version
+func (d *Decoder) findEvent(name string, log *types.Log) (*AbiVersion, abi.Event, bool) {
+	if len(log.Topics) == 0 {
+		return nil, abi.Event{}, false
+	}
+	for _, version := range d.versions {
+		if event, ok := version.Abi.Events[name]; ok && event.ID() == log.Topics[0] {
+			return version, event, true
+		}
+	}
+	if event := d.permit2Abi.Events[SwapPermit2StartedEventName]; name == SwapStartedEventName && event.ID() == log.Topics[0] {
+		return &AbiVersion{Version: Permit2AbiVersion, Abi: d.permit2Abi}, event, true
+	}
+	return nil, abi.Event{}, false
+}
+
+// DecodeSwapStarted decodes the SwapStarted event, or its SwapPermit2Started variant, it returns ErrUnknownEvent if
+// the log is not a SwapStarted event of any abi version
+func (d *Decoder) DecodeSwapStarted(log *types.Log) (*SwapStarted, error) {
+	version, event, ok := d.findEvent(SwapStartedEventName, log)
+	if !ok {
+		return nil, ErrUnknownEvent
+	}
+	args, err := unpackEventArgs(event, log)
+	if err != nil {
+		return nil, err
+	}
+
+	ev := SwapStarted{Version: version.Version}
+	if ev.ToChainId, ok = bigArg(args, "toChainId"); !ok {
+		return nil, fmt.Errorf("no toChainId in %s event of abi %s", SwapStartedEventName, version.Version)
+	}
+	if ev.FromAddress, ok = args["fromAddress"].(ethcom.Address); !ok {
+		return nil, fmt.Errorf("no fromAddress in %s event of abi %s", SwapStartedEventName, version.Version)
+	}
+	if ev.Amount, ok = bigArg(args, "amount"); !ok {
+		return nil, fmt.Errorf("no amount in %s event of abi %s", SwapStartedEventName, version.Version)
+	}
+	ev.FromChainId, _ = bigArg(args, "fromChainId")
+	ev.FeeAmount, _ = bigArg(args, "feeAmount")
+	// the legacy abis emit both the bep20 and the erc20 addresses, the token of the source chain is the one of the
+	// chain of the decoder
+	if d.chain != "" {
+		if token, ok := args[legacyTokenArg(d.chain)].(ethcom.Address); ok {
+			ev.Token = token
+		}
+	}
+	for _, name := range swapStartedTokenArgs {
+		if token, ok := args[name].(ethcom.Address); ok {
+			ev.Token = token
+			break
+		}
+	}
+	for _, name := range swapStartedRecipientArgs {
+		if recipient, ok := args[name].(ethcom.Address); ok {
+			ev.Recipient = recipient
+			break
+		}
+	}
+	return &ev, nil
+}
+
+// DecodeSwapFilled decodes the SwapFilled event, it returns ErrUnknownEvent if the log is not a SwapFilled event
+// of any abi version. The recipient is the fromAddress argument of the event.
+func (d *Decoder) DecodeSwapFilled(log *types.Log) (*SwapFilled, error) {
+	version, event, ok := d.findEvent(SwapFilledEventName, log)
+	if !ok {
+		return nil, ErrUnknownEvent
+	}
+	args, err := unpackEventArgs(event, log)
+	if err != nil {
+		return nil, err
+	}
+
+	ev := SwapFilled{Version: version.Version}
+	if ev.ToChainId, ok = bigArg(args, "toChainId"); !ok {
+		return nil, fmt.Errorf("no toChainId in %s event of abi %s", SwapFilledEventName, version.Version)
+	}
+	if ev.ToAddress, ok = args["fromAddress"].(ethcom.Address); !ok {
+		return nil, fmt.Errorf("no fromAddress in %s event of abi %s", SwapFilledEventName, version.Version)
+	}
+	if ev.Amount, ok = bigArg(args, "amount"); !ok {
+		return nil, fmt.Errorf("no amount in %s event of abi %s", SwapFilledEventName, version.Version)
+	}
+	ev.FromChainId, _ = bigArg(args, "fromChainId")
+	for _, name := range swapFilledStartTxHashArgs {
+		if startTxHash, ok := hashArg(args, name); ok {
+			ev.StartTxHash = startTxHash
+			break
+		}
+	}
+	return &ev, nil
+}
+
+// DecodeSwapNFTStarted decodes the SwapNFTStarted event, it returns ErrUnknownEvent if the log is not a
+// SwapNFTStarted event
+func (d *Decoder) DecodeSwapNFTStarted(log *types.Log) (*SwapNFTStarted, error) {
+	args, err := d.unpackNFTEvent(SwapNFTStartedEventName, log)
+	if err != nil {
+		return nil, err
+	}
+
+	var ok bool
+	ev := SwapNFTStarted{}
+	if ev.ToChainId, ok = bigArg(args, "toChainId"); !ok {
+		return nil, fmt.Errorf("no toChainId in %s event", SwapNFTStartedEventName)
+	}
+	if ev.FromAddress, ok = args["fromAddress"].(ethcom.Address); !ok {
+		return nil, fmt.Errorf("no fromAddress in %s event", SwapNFTStartedEventName)
+	}
+	if ev.Collection, ok = args["collection"].(ethcom.Address); !ok {
+		return nil, fmt.Errorf("no collection in %s event", SwapNFTStartedEventName)
+	}
+	if ev.TokenId, ok = bigArg(args, "tokenId"); !ok {
+		return nil, fmt.Errorf("no tokenId in %s event", SwapNFTStartedEventName)
+	}
+	ev.FromChainId, _ = bigArg(args, "fromChainId")
+	return &ev, nil
+}
+
+// DecodeSwapNFTFilled decodes the SwapNFTFilled event, it returns ErrUnknownEvent if the log is not a
+// SwapNFTFilled event. The recipient is the fromAddress argument of the event.
+func (d *Decoder) DecodeSwapNFTFilled(log *types.Log) (*SwapNFTFilled, error) {
+	args, err := d.unpackNFTEvent(SwapNFTFilledEventName, log)
+	if err != nil {
+		return nil, err
+	}
+
+	var ok bool
+	ev := SwapNFTFilled{}
+	if ev.ToChainId, ok = bigArg(args, "toChainId"); !ok {
+		return nil, fmt.Errorf("no toChainId in %s event", SwapNFTFilledEventName)
+	}
+	if ev.ToAddress, ok = args["fromAddress"].(ethcom.Address); !ok {
+		return nil, fmt.Errorf("no fromAddress in %s event", SwapNFTFilledEventName)
+	}
+	if ev.Collection, ok = args["collection"].(ethcom.Address); !ok {
+		return nil, fmt.Errorf("no collection in %s event", SwapNFTFilledEventName)
+	}
+	if ev.TokenId, ok = bigArg(args, "tokenId"); !ok {
+		return nil, fmt.Errorf("no tokenId in %s event", SwapNFTFilledEventName)
+	}
+	ev.FromChainId, _ = bigArg(args, "fromChainId")
+	return &ev, nil
+}
+
+func (d *Decoder) unpackNFTEvent(name string, log *types.Log) (map[string]interface{}, error) {
+	event := d.nftAbi.Events[name]
+	if len(log.Topics) == 0 || log.Topics[0] != event.ID() {
+		return nil, ErrUnknownEvent
+	}
+	return unpackEventArgs(event, log)
+}
+
+// unpackEventArgs decodes the indexed arguments of the event from the topics and the others from the data
+func unpackEventArgs(event abi.Event, log *types.Log) (map[string]interface{}, error) {
+	args := make(map[string]interface{})
+	if err := event.Inputs.NonIndexed().UnpackIntoMap(args, log.Data); err != nil {
+		return nil, err
+	}
+	topicIdx := 1
+	for _, input := range event.Inputs {
+		if !input.Indexed {
+			continue
+		}
+		if topicIdx >= len(log.Topics) {
+			return nil, fmt.Errorf("missing topic of %s", input.Name)
+		}
+		topic := log.Topics[topicIdx]
+		topicIdx++
+		switch input.Type.T {
+		case abi.UintTy, abi.IntTy:
+			args[input.Name] = topic.Big()
+		case abi.AddressTy:
+			args[input.Name] = ethcom.BytesToAddress(topic.Bytes())
+		default:
+			// dynamic types are indexed by their hashes
+			args[input.Name] = topic
+		}
+	}
+	return args, nil
+}
+
+func bigArg(args map[string]interface{}, name string) (*big.Int, bool) {
+	value, ok := args[name].(*big.Int)
+	return value, ok
+}
+
+// hashArg returns the bytes32 arg, the indexed ones are unpacked from the topics as hashes
+func hashArg(args map[string]interface{}, name string) (ethcom.Hash, bool) {
+	switch value := args[name].(type) {
+	case ethcom.Hash:
+		return value, true
+	case [32]byte:
+		return ethcom.Hash(value), true
+	default:
+		return ethcom.Hash{}, false
+	}
+}
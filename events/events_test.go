@@ -0,0 +1,281 @@
+package events
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"occ-swap-server/common"
+	"occ-swap-server/util"
+)
+
+// v2Abi moves amount to the data and adds the fee, the token and the recipient, its SwapFilled event carries the
+// start tx hash
+const v2Abi = `[{"anonymous":false,"inputs":[
+{"indexed":false,"name":"fromChainId","type":"uint256"},
+{"indexed":true,"name":"toChainId","type":"uint256"},
+{"indexed":true,"name":"fromAddress","type":"address"},
+{"indexed":true,"name":"startTxHash","type":"bytes32"},
+{"indexed":false,"name":"amount","type":"uint256"}],"name":"SwapFilled","type":"event"},
+{"anonymous":false,"inputs":[
+{"indexed":false,"name":"fromChainId","type":"uint256"},
+{"indexed":true,"name":"toChainId","type":"uint256"},
+{"indexed":true,"name":"fromAddress","type":"address"},
+{"indexed":false,"name":"tokenAddress","type":"address"},
+{"indexed":false,"name":"toAddress","type":"address"},
+{"indexed":false,"name":"amount","type":"uint256"},
+{"indexed":false,"name":"feeAmount","type":"uint256"}],"name":"SwapStarted","type":"event"}]`
+
+// legacyAbi emits both the bep20 and the erc20 addresses of the pair
+const legacyAbi = `[{"anonymous":false,"inputs":[
+{"indexed":true,"name":"bep20Addr","type":"address"},
+{"indexed":true,"name":"erc20Addr","type":"address"},
+{"indexed":true,"name":"fromAddress","type":"address"},
+{"indexed":false,"name":"toChainId","type":"uint256"},
+{"indexed":false,"name":"amount","type":"uint256"},
+{"indexed":false,"name":"feeAmount","type":"uint256"}],"name":"SwapStarted","type":"event"}]`
+
+var (
+	testFrom      = ethcom.HexToAddress("0x1000000000000000000000000000000000000001")
+	testRecipient = ethcom.HexToAddress("0x2000000000000000000000000000000000000002")
+	testBEP20     = ethcom.HexToAddress("0x3000000000000000000000000000000000000003")
+	testERC20     = ethcom.HexToAddress("0x4000000000000000000000000000000000000004")
+)
+
+func newTestDecoder(t *testing.T, chain string) *Decoder {
+	dir, err := ioutil.TempDir("", "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the abi files are read by NewDecoder
+	defer os.RemoveAll(dir)
+
+	var historicalAbis []util.SwapAgentAbiConfig
+	for version, content := range map[string]string{"v2": v2Abi, "legacy": legacyAbi} {
+		file := filepath.Join(dir, version+".json")
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		historicalAbis = append(historicalAbis, util.SwapAgentAbiConfig{Version: version, AbiFile: file})
+	}
+	decoder, err := NewDecoder(chain, historicalAbis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoder
+}
+
+// testLog builds the log of the event with the args, the indexed args go to the topics and the others to the data
+func testLog(t *testing.T, event abi.Event, args map[string]interface{}) *types.Log {
+	log := &types.Log{Topics: []ethcom.Hash{event.ID()}}
+	var values []interface{}
+	for _, input := range event.Inputs {
+		value, ok := args[input.Name]
+		if !ok {
+			t.Fatalf("no %s arg of %s", input.Name, event.Name)
+		}
+		if !input.Indexed {
+			values = append(values, value)
+			continue
+		}
+		switch v := value.(type) {
+		case *big.Int:
+			log.Topics = append(log.Topics, ethcom.BigToHash(v))
+		case ethcom.Address:
+			log.Topics = append(log.Topics, v.Hash())
+		case ethcom.Hash:
+			log.Topics = append(log.Topics, v)
+		default:
+			t.Fatalf("unsupported indexed arg %s", input.Name)
+		}
+	}
+	data, err := event.Inputs.NonIndexed().Pack(values...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Data = data
+	return log
+}
+
+func (d *Decoder) testEvent(t *testing.T, version, name string) abi.Event {
+	if version == Permit2AbiVersion {
+		return d.permit2Abi.Events[name]
+	}
+	for _, v := range d.versions {
+		if v.Version == version {
+			return v.Abi.Events[name]
+		}
+	}
+	t.Fatalf("no abi version %s", version)
+	return abi.Event{}
+}
+
+func TestDecodeSwapStarted(t *testing.T) {
+	args := map[string]interface{}{
+		"fromChainId":  big.NewInt(56),
+		"toChainId":    big.NewInt(25),
+		"fromAddress":  testFrom,
+		"amount":       big.NewInt(1000),
+		"feeAmount":    big.NewInt(10),
+		"token":        testBEP20,
+		"tokenAddress": testBEP20,
+		"toAddress":    testRecipient,
+		"nonce":        big.NewInt(7),
+		"bep20Addr":    testBEP20,
+		"erc20Addr":    testERC20,
+	}
+
+	cases := []struct {
+		chain   string
+		version string
+		event   string
+		want    SwapStarted
+	}{{
+		chain:   common.ChainBSC,
+		version: util.CurrentSwapAgentAbiVersion,
+		event:   SwapStartedEventName,
+		want: SwapStarted{FromChainId: big.NewInt(56), ToChainId: big.NewInt(25), FromAddress: testFrom,
+			Amount: big.NewInt(1000)},
+	}, {
+		chain:   common.ChainBSC,
+		version: "v2",
+		event:   SwapStartedEventName,
+		want: SwapStarted{FromChainId: big.NewInt(56), ToChainId: big.NewInt(25), FromAddress: testFrom,
+			Amount: big.NewInt(1000), FeeAmount: big.NewInt(10), Token: testBEP20, Recipient: testRecipient},
+	}, {
+		chain:   common.ChainBSC,
+		version: "legacy",
+		event:   SwapStartedEventName,
+		want: SwapStarted{ToChainId: big.NewInt(25), FromAddress: testFrom, Amount: big.NewInt(1000),
+			FeeAmount: big.NewInt(10), Token: testBEP20},
+	}, {
+		chain:   common.ChainETH,
+		version: "legacy",
+		event:   SwapStartedEventName,
+		want: SwapStarted{ToChainId: big.NewInt(25), FromAddress: testFrom, Amount: big.NewInt(1000),
+			FeeAmount: big.NewInt(10), Token: testERC20},
+	}, {
+		chain:   common.ChainBSC,
+		version: Permit2AbiVersion,
+		event:   SwapPermit2StartedEventName,
+		want: SwapStarted{FromChainId: big.NewInt(56), ToChainId: big.NewInt(25), FromAddress: testFrom,
+			Amount: big.NewInt(1000), Token: testBEP20},
+	}}
+	for _, c := range cases {
+		decoder := newTestDecoder(t, c.chain)
+		log := testLog(t, decoder.testEvent(t, c.version, c.event), args)
+		ev, err := decoder.DecodeSwapStarted(log)
+		if err != nil {
+			t.Fatalf("%s %s: %s", c.chain, c.version, err.Error())
+		}
+		c.want.Version = c.version
+		if !reflect.DeepEqual(*ev, c.want) {
+			t.Errorf("%s %s: got %+v, want %+v", c.chain, c.version, *ev, c.want)
+		}
+	}
+}
+
+func TestDecodeSwapFilled(t *testing.T) {
+	decoder := newTestDecoder(t, common.ChainBSC)
+	log := testLog(t, decoder.testEvent(t, util.CurrentSwapAgentAbiVersion, SwapFilledEventName),
+		map[string]interface{}{
+			"fromChainId": big.NewInt(25),
+			"toChainId":   big.NewInt(56),
+			"fromAddress": testRecipient,
+			"amount":      big.NewInt(990),
+		})
+	ev, err := decoder.DecodeSwapFilled(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := SwapFilled{FromChainId: big.NewInt(25), ToChainId: big.NewInt(56), ToAddress: testRecipient,
+		Amount: big.NewInt(990), Version: util.CurrentSwapAgentAbiVersion}
+	if !reflect.DeepEqual(*ev, want) {
+		t.Errorf("got %+v, want %+v", *ev, want)
+	}
+}
+
+func TestDecodeSwapFilledStartTxHash(t *testing.T) {
+	decoder := newTestDecoder(t, common.ChainBSC)
+	startTxHash := ethcom.HexToHash("0x5000000000000000000000000000000000000000000000000000000000000005")
+	log := testLog(t, decoder.testEvent(t, "v2", SwapFilledEventName),
+		map[string]interface{}{
+			"fromChainId": big.NewInt(25),
+			"toChainId":   big.NewInt(56),
+			"fromAddress": testRecipient,
+			"startTxHash": startTxHash,
+			"amount":      big.NewInt(990),
+		})
+	ev, err := decoder.DecodeSwapFilled(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := SwapFilled{FromChainId: big.NewInt(25), ToChainId: big.NewInt(56), ToAddress: testRecipient,
+		Amount: big.NewInt(990), StartTxHash: startTxHash, Version: "v2"}
+	if !reflect.DeepEqual(*ev, want) {
+		t.Errorf("got %+v, want %+v", *ev, want)
+	}
+}
+
+func TestDecodeUnknownEvent(t *testing.T) {
+	decoder := newTestDecoder(t, common.ChainBSC)
+	filled := testLog(t, decoder.testEvent(t, util.CurrentSwapAgentAbiVersion, SwapFilledEventName),
+		map[string]interface{}{
+			"fromChainId": big.NewInt(25),
+			"toChainId":   big.NewInt(56),
+			"fromAddress": testRecipient,
+			"amount":      big.NewInt(990),
+		})
+	logs := map[string]*types.Log{
+		"no topics":   {},
+		"other topic": {Topics: []ethcom.Hash{ethcom.HexToHash("0x01")}},
+		"filled":      filled,
+	}
+	for name, log := range logs {
+		if _, err := decoder.DecodeSwapStarted(log); err != ErrUnknownEvent {
+			t.Errorf("%s: DecodeSwapStarted got %v, want %v", name, err, ErrUnknownEvent)
+		}
+		if _, err := decoder.DecodeSwapNFTStarted(log); err != ErrUnknownEvent {
+			t.Errorf("%s: DecodeSwapNFTStarted got %v, want %v", name, err, ErrUnknownEvent)
+		}
+	}
+	if _, err := decoder.DecodeSwapFilled(logs["other topic"]); err != ErrUnknownEvent {
+		t.Errorf("DecodeSwapFilled got %v, want %v", err, ErrUnknownEvent)
+	}
+}
+
+func TestDecodeSameLogTwice(t *testing.T) {
+	decoder := newTestDecoder(t, common.ChainETH)
+	log := testLog(t, decoder.testEvent(t, "legacy", SwapStartedEventName), map[string]interface{}{
+		"bep20Addr":   testBEP20,
+		"erc20Addr":   testERC20,
+		"fromAddress": testFrom,
+		"toChainId":   big.NewInt(25),
+		"amount":      big.NewInt(1000),
+		"feeAmount":   big.NewInt(10),
+	})
+	topics := append([]ethcom.Hash(nil), log.Topics...)
+	data := append([]byte(nil), log.Data...)
+
+	first, err := decoder.DecodeSwapStarted(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := decoder.DecodeSwapStarted(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("got %+v then %+v", *first, *second)
+	}
+	if !reflect.DeepEqual(log.Topics, topics) || !reflect.DeepEqual(log.Data, data) {
+		t.Errorf("decoding modified the log")
+	}
+}
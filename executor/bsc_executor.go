@@ -15,9 +15,14 @@ import (
 	agent "occ-swap-server/abi"
 	contractabi "occ-swap-server/abi"
 	"occ-swap-server/common"
+	"occ-swap-server/events"
+	"occ-swap-server/model"
 	"occ-swap-server/util"
 )
 
+// filterLogsTimeout is the timeout of an eth_getLogs, the ranges of blocks take longer than a block
+const filterLogsTimeout = 30 * time.Second
+
 type BscExecutor struct {
 	Chain  string
 	Config *util.Config
@@ -25,7 +30,9 @@ type BscExecutor struct {
 	SwapAgentAddr    ethcmm.Address
 	BSCSwapAgentInst *contractabi.ETHSwapAgent
 	SwapAgentAbi     abi.ABI
-	Client           *ethclient.Client
+	// decodes the events of the current and the historical abis of the swap agent
+	EventDecoder *events.Decoder
+	Client       *ethclient.Client
 }
 
 func NewBSCExecutor(ethClient *ethclient.Client, swapAddr string, config *util.Config, chainId int64) *BscExecutor {
@@ -52,12 +59,17 @@ func NewBSCExecutor(ethClient *ethclient.Client, swapAddr string, config *util.C
 	// if chainId == 137 || chainId == 80001 {
 	// 	chainName = common.ChainMATIC
 	// }
+	eventDecoder, err := events.NewDecoder(chainName, config.ChainConfig.GetSwapAgentHistoricalAbis(chainName))
+	if err != nil {
+		panic(err.Error())
+	}
 	return &BscExecutor{
 		Chain:            chainName,
 		Config:           config,
 		SwapAgentAddr:    ethcmm.HexToAddress(swapAddr),
 		BSCSwapAgentInst: bscSwapAgentInst,
 		SwapAgentAbi:     agentAbi,
+		EventDecoder:     eventDecoder,
 		Client:           ethClient,
 	}
 }
@@ -89,45 +101,157 @@ func (e *BscExecutor) GetBlockAndTxEvents(height int64) (*common.BlockAndEventLo
 		Events:          packageLogs,
 	}, nil
 }
-func (e *BscExecutor) GetLogs(header *types.Header) ([]interface{}, error) {
-	return e.GetSwapStartLogs(header)
-}
 
-func (e *BscExecutor) GetSwapStartLogs(header *types.Header) ([]interface{}, error) {
-	topics := [][]ethcmm.Hash{{BSC2ETHSwapStartedEventHash}}
+// GetRangeEvents returns the events of the blocks from fromHeight to toHeight, the headers of the first and the last
+// blocks are fetched to chain the range to the block logs
+func (e *BscExecutor) GetRangeEvents(fromHeight, toHeight int64) (*common.RangeEventLogs, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	blockNumber := header.Number
+	fromHeader, err := e.Client.HeaderByNumber(ctxWithTimeout, big.NewInt(fromHeight))
+	if err != nil {
+		return nil, err
+	}
+	toHeader, err := e.Client.HeaderByNumber(ctxWithTimeout, big.NewInt(toHeight))
+	if err != nil {
+		return nil, err
+	}
 
+	packageLogs, err := e.getSwapStartLogs(fromHeight, toHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	return &common.RangeEventLogs{
+		FromHeight: fromHeight,
+		BlockAndEventLogs: common.BlockAndEventLogs{
+			Height:          toHeight,
+			Chain:           e.Chain,
+			BlockHash:       toHeader.Hash().String(),
+			ParentBlockHash: fromHeader.ParentHash.String(),
+			BlockTime:       int64(toHeader.Time),
+			Events:          packageLogs,
+		},
+	}, nil
+}
+
+func (e *BscExecutor) GetLatestHeight() (int64, error) {
 	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	logs, err := e.Client.FilterLogs(ctxWithTimeout, ethereum.FilterQuery{
-		FromBlock: blockNumber,
-		ToBlock:   blockNumber,
-		Topics:    topics,
-		Addresses: []ethcmm.Address{e.SwapAgentAddr},
-	})
+	header, err := e.Client.HeaderByNumber(ctxWithTimeout, nil)
+	if err != nil {
+		return 0, err
+	}
+	return header.Number.Int64(), nil
+}
+
+func (e *BscExecutor) GetLogs(header *types.Header) ([]interface{}, error) {
+	return e.GetSwapStartLogs(header)
+}
+
+func (e *BscExecutor) GetSwapStartLogs(header *types.Header) ([]interface{}, error) {
+	return e.getSwapStartLogs(header.Number.Int64(), header.Number.Int64())
+}
 
+func (e *BscExecutor) getSwapStartLogs(fromHeight, toHeight int64) ([]interface{}, error) {
+	logs, err := e.filterLogs(fromHeight, toHeight)
 	if err != nil {
 		return nil, err
 	}
 
 	eventModels := make([]interface{}, 0, len(logs))
 	for _, log := range logs {
-
-		event, err := ParseBSC2ETHSwapStartEvent(&e.SwapAgentAbi, &log)
-		if err != nil {
-			util.Logger.Errorf("parse event log error, er=%s", err.Error())
+		// the logs of a reorged block are not events
+		if log.Removed {
 			continue
 		}
-		if event == nil {
+		event, err := e.EventDecoder.DecodeSwapStarted(&log)
+		if err == events.ErrUnknownEvent {
+			if eventModel := e.toSwapNFTStartTxLog(&log); eventModel != nil {
+				eventModels = append(eventModels, eventModel)
+			}
+			continue
+		}
+		if err != nil {
+			util.Logger.Errorf("parse event log error, txHash: %s, er=%s", log.TxHash.String(), err.Error())
 			continue
 		}
-		eventModel := event.ToSwapStartTxLog(&log)
+		eventModel := ToSwapStartTxLog(event, &log)
 		eventModel.Chain = e.Chain
-		util.Logger.Debugf("Found bridge swap: Chain: %s, txHash: %s, toChainId: %s, fromAddress: %s, amount: %s",
-			eventModel.Chain, eventModel.TxHash, eventModel.ToChainId, eventModel.FromAddress, eventModel.Amount)
+		if eventModel.TokenAddr == "" {
+			token, err := e.resolveSwapToken(event, &log)
+			if err != nil {
+				return nil, err
+			}
+			eventModel.TokenAddr = model.OptionalAddress(token)
+		}
+		if eventModel.Memo, err = e.resolveSwapMemo(&log); err != nil {
+			return nil, err
+		}
+		util.Logger.Debugf("Found bridge swap: Chain: %s, txHash: %s, toChainId: %s, fromAddress: %s, amount: %s, abi: %s",
+			eventModel.Chain, eventModel.TxHash, eventModel.ToChainId, eventModel.FromAddress, eventModel.Amount, event.Version)
 		eventModels = append(eventModels, eventModel)
 	}
 	return eventModels, nil
 }
+
+// filterLogs queries the swap events of the swap agent in the range, the range is halved until the provider
+// accepts it if the provider limits the results or the range of eth_getLogs
+func (e *BscExecutor) filterLogs(fromHeight, toHeight int64) ([]types.Log, error) {
+	topics := [][]ethcmm.Hash{append(e.EventDecoder.SwapStartedTopics(), e.EventDecoder.SwapNFTStartedTopics()...)}
+
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), filterLogsTimeout)
+	defer cancel()
+
+	logs, err := e.Client.FilterLogs(ctxWithTimeout, ethereum.FilterQuery{
+		FromBlock: big.NewInt(fromHeight),
+		ToBlock:   big.NewInt(toHeight),
+		Topics:    topics,
+		Addresses: []ethcmm.Address{e.SwapAgentAddr},
+	})
+	if err == nil || fromHeight >= toHeight || !IsLogRangeTooLarge(err) {
+		return logs, err
+	}
+
+	midHeight := fromHeight + (toHeight-fromHeight)/2
+	util.Logger.Debugf("%s, split log range %d-%d at %d: %s", e.Chain, fromHeight, toHeight, midHeight, err.Error())
+	logs, err = e.filterLogs(fromHeight, midHeight)
+	if err != nil {
+		return nil, err
+	}
+	upperLogs, err := e.filterLogs(midHeight+1, toHeight)
+	if err != nil {
+		return nil, err
+	}
+	return append(logs, upperLogs...), nil
+}
+
+func (e *BscExecutor) resolveSwapToken(event *events.SwapStarted, log *types.Log) (ethcmm.Address, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return ResolveSwapToken(ctxWithTimeout, e.Client, e.SwapAgentAddr, event, log)
+}
+
+func (e *BscExecutor) resolveSwapMemo(log *types.Log) (string, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return ResolveSwapMemo(ctxWithTimeout, e.Client, e.SwapAgentAddr, log)
+}
+
+// toSwapNFTStartTxLog returns the event log of the SwapNFTStarted event, it is nil if the log is not the event
+func (e *BscExecutor) toSwapNFTStartTxLog(log *types.Log) *model.SwapStartTxLog {
+	event, err := e.EventDecoder.DecodeSwapNFTStarted(log)
+	if err == events.ErrUnknownEvent {
+		return nil
+	}
+	if err != nil {
+		util.Logger.Errorf("parse nft event log error, txHash: %s, er=%s", log.TxHash.String(), err.Error())
+		return nil
+	}
+	eventModel := ToSwapNFTStartTxLog(event, log)
+	eventModel.Chain = e.Chain
+	util.Logger.Debugf("Found bridge nft swap: Chain: %s, txHash: %s, toChainId: %s, fromAddress: %s, collection: %s, tokenId: %s",
+		eventModel.Chain, eventModel.TxHash, eventModel.ToChainId, eventModel.FromAddress, eventModel.TokenAddr, eventModel.TokenId)
+	return eventModel
+}
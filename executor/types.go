@@ -1,28 +1,60 @@
 package executor
 
 import (
+	"context"
 	"fmt"
 	"math/big"
+	"strings"
 
 	common "occ-swap-server/common"
 
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	ethcmm "github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 
+	agent "occ-swap-server/abi"
+	"occ-swap-server/events"
 	"occ-swap-server/model"
+	"occ-swap-server/util"
 )
 
 type Executor interface {
 	GetBlockAndTxEvents(height int64) (*common.BlockAndEventLogs, error)
+	// GetRangeEvents returns the events of a range of blocks with one eth_getLogs, the range is split if the
+	// provider returns too many results
+	GetRangeEvents(fromHeight, toHeight int64) (*common.RangeEventLogs, error)
+	GetLatestHeight() (int64, error)
 	GetChainName() string
 }
 
+// logRangeTooLargeErrors are the errors of the providers whose eth_getLogs returns too many results or is given too
+// many blocks, e.g. "query returned more than 10000 results"
+var logRangeTooLargeErrors = []string{
+	"query returned more than",
+	"query exceeds max results",
+	"log response size exceeded",
+	"block range is too wide",
+	"exceed maximum block range",
+	"block range too large",
+}
+
+// IsLogRangeTooLarge is true if the eth_getLogs failed because of the range, it is to be split
+func IsLogRangeTooLarge(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, tooLarge := range logRangeTooLargeErrors {
+		if strings.Contains(msg, tooLarge) {
+			return true
+		}
+	}
+	return false
+}
+
 // ===================  SwapStarted =============
 var (
 	SwapStartedEventName        = "SwapStarted"
 	ETH2BSCSwapStartedEventHash = ethcmm.HexToHash("0x7b2b39fe8cb99baf3c533665217a130daefeee1af6329eca59c5bf06a53999ac")
-	BSC2ETHSwapStartedEventHash = ethcmm.HexToHash("0x7b2b39fe8cb99baf3c533665217a130daefeee1af6329eca59c5bf06a53999ac")
+	BSC2ETHSwapStartedEventHash = events.SwapStartedTopic
 )
 
 type ETH2BSCSwapStartedEvent struct {
@@ -34,7 +66,7 @@ type ETH2BSCSwapStartedEvent struct {
 
 func (ev *ETH2BSCSwapStartedEvent) ToSwapStartTxLog(log *types.Log) *model.SwapStartTxLog {
 	pack := &model.SwapStartTxLog{
-		TokenAddr:   ev.ERC20Addr.String(),
+		TokenAddr:   model.OptionalAddress(ev.ERC20Addr),
 		FromAddress: ev.FromAddr.String(),
 		Amount:      ev.Amount.String(),
 
@@ -62,18 +94,15 @@ func ParseETH2BSCSwapStartEvent(abi *abi.ABI, log *types.Log) (*ETH2BSCSwapStart
 	return &ev, nil
 }
 
-type BSC2ETHSwapStartedEvent struct {
-	FeeAmount   *big.Int
-	toChainId   *big.Int
-	fromAddress ethcmm.Address
-	amount      *big.Int
-}
-
-func (ev *BSC2ETHSwapStartedEvent) ToSwapStartTxLog(log *types.Log) *model.SwapStartTxLog {
+// ToSwapStartTxLog converts the SwapStarted event of the log to the event log saved by the observer, the token is
+// empty if the event doesn't emit it, see ResolveSwapToken, and so is the recipient
+func ToSwapStartTxLog(ev *events.SwapStarted, log *types.Log) *model.SwapStartTxLog {
 	pack := &model.SwapStartTxLog{
-		FromAddress: ev.fromAddress.String(),
-		Amount:      ev.amount.String(),
-		ToChainId:   ev.toChainId.String(),
+		TokenAddr:   model.OptionalAddress(ev.Token),
+		FromAddress: ev.FromAddress.String(),
+		Amount:      ev.Amount.String(),
+		ToChainId:   ev.ToChainId.String(),
+		Recipient:   model.OptionalAddress(ev.Recipient),
 
 		FeeAmount: ev.FeeAmount.String(),
 		BlockHash: log.BlockHash.Hex(),
@@ -83,14 +112,22 @@ func (ev *BSC2ETHSwapStartedEvent) ToSwapStartTxLog(log *types.Log) *model.SwapS
 	return pack
 }
 
-func ParseBSC2ETHSwapStartEvent(abi *abi.ABI, log *types.Log) (*BSC2ETHSwapStartedEvent, error) {
-	var ev BSC2ETHSwapStartedEvent
-
-	ev.toChainId = log.Topics[1].Big()
-	ev.fromAddress = ethcmm.BytesToAddress(log.Topics[2].Bytes())
-	ev.amount = log.Topics[3].Big()
+// ToSwapNFTStartTxLog converts the SwapNFTStarted event of the log to the event log saved by the observer, the
+// amount of an erc721 swap is 1
+func ToSwapNFTStartTxLog(ev *events.SwapNFTStarted, log *types.Log) *model.SwapStartTxLog {
+	pack := &model.SwapStartTxLog{
+		TokenAddr:   ev.Collection.String(),
+		TokenId:     ev.TokenId.String(),
+		FromAddress: ev.FromAddress.String(),
+		Amount:      "1",
+		FeeAmount:   "0",
+		ToChainId:   ev.ToChainId.String(),
 
-	return &ev, nil
+		BlockHash: log.BlockHash.Hex(),
+		TxHash:    log.TxHash.String(),
+		Height:    int64(log.BlockNumber),
+	}
+	return pack
 }
 
 // =================  SphynxSwapPairRegister ===================
@@ -136,3 +173,90 @@ func ParseSwapPairRegisterEvent(abi *abi.ABI, log *types.Log) (*SwapPairRegister
 	ev.BEP20ContractAddr = ethcmm.BytesToAddress(log.Topics[3].Bytes())
 	return &ev, nil
 }
+
+var swapAgentABI = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(agent.SwapAgentABI))
+	if err != nil {
+		panic(fmt.Sprintf("parse swap agent abi error, err=%s", err.Error()))
+	}
+	return parsed
+}()
+
+// ResolveSwapToken returns the token of the swap on the source chain, the token emitted by the event, or else the
+// token registered in the swap agent under the source chain id at the block of the event. It is zero if the event
+// has neither.
+func ResolveSwapToken(ctx context.Context, caller ethereum.ContractCaller, swapAgent ethcmm.Address, ev *events.SwapStarted,
+	log *types.Log) (ethcmm.Address, error) {
+	if ev.Token != (ethcmm.Address{}) || ev.FromChainId == nil {
+		return ev.Token, nil
+	}
+	data, err := swapAgentABI.Pack("tokenAddresses", ev.FromChainId)
+	if err != nil {
+		return ethcmm.Address{}, err
+	}
+	result, err := caller.CallContract(ctx, ethereum.CallMsg{To: &swapAgent, Data: data}, new(big.Int).SetUint64(log.BlockNumber))
+	if err != nil {
+		return ethcmm.Address{}, fmt.Errorf("query token of swap agent %s error: %s", swapAgent.String(), err.Error())
+	}
+	var token ethcmm.Address
+	if err := swapAgentABI.Unpack(&token, "tokenAddresses", result); err != nil {
+		return ethcmm.Address{}, fmt.Errorf("unpack token of swap agent %s error: %s", swapAgent.String(), err.Error())
+	}
+	return token, nil
+}
+
+// MaxSwapMemoLength is the max length of the memo appended to the call data of the swap tx
+const MaxSwapMemoLength = 64
+
+// CheckSwapMemo checks the memo is at most MaxSwapMemoLength bytes of printable ascii, the other memos are dropped
+func CheckSwapMemo(memo string) error {
+	if len(memo) > MaxSwapMemoLength {
+		return fmt.Errorf("memo should not be longer than %d bytes", MaxSwapMemoLength)
+	}
+	for _, c := range []byte(memo) {
+		if c < 0x20 || c > 0x7e {
+			return fmt.Errorf("memo should only contain printable ascii characters")
+		}
+	}
+	return nil
+}
+
+// ParseSwapMemo returns the memo the sender appended to the arguments of the swap call of the swap agent, the
+// swap agent ignores the extra call data. It is empty if the input is not a swap call or carries no memo.
+func ParseSwapMemo(input []byte) (string, error) {
+	if len(input) < 4 {
+		return "", nil
+	}
+	method, err := swapAgentABI.MethodById(input[:4])
+	if err != nil || method.Name != "swap" {
+		return "", nil
+	}
+	// the arguments of swap are static, 32 bytes each
+	argsLength := 4 + 32*len(method.Inputs)
+	if len(input) <= argsLength {
+		return "", nil
+	}
+	memo := string(input[argsLength:])
+	if err := CheckSwapMemo(memo); err != nil {
+		return "", err
+	}
+	return memo, nil
+}
+
+// ResolveSwapMemo returns the memo of the swap tx of the SwapStarted event, empty if the tx doesn't call the swap
+// agent directly, e.g. the relayed swaps, or its memo is invalid
+func ResolveSwapMemo(ctx context.Context, reader ethereum.TransactionReader, swapAgent ethcmm.Address, log *types.Log) (string, error) {
+	tx, _, err := reader.TransactionByHash(ctx, log.TxHash)
+	if err != nil {
+		return "", fmt.Errorf("query swap tx %s error: %s", log.TxHash.String(), err.Error())
+	}
+	if tx.To() == nil || *tx.To() != swapAgent {
+		return "", nil
+	}
+	memo, err := ParseSwapMemo(tx.Data())
+	if err != nil {
+		util.Logger.Infof("drop memo of swap tx %s: %s", log.TxHash.String(), err.Error())
+		return "", nil
+	}
+	return memo, nil
+}
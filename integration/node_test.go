@@ -0,0 +1,96 @@
+//go:build integration
+// +build integration
+
+// Package integration runs the observers and the swap engine against dev nodes in docker. Every chain is an anvil
+// node with the mock swap agent and the mock token of swaptest deployed, so the whole pipeline runs over real rpc.
+// The tests are run by go test -tags=integration ./integration/.
+package integration
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"occ-swap-server/swap"
+)
+
+const (
+	DefaultNodeImage = "ghcr.io/foundry-rs/foundry:latest"
+	DefaultBasePort  = 18545
+
+	// private keys of the first two accounts funded by anvil
+	UserPrivateKey    = "ac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcae784d7bf4f2ff80"
+	RelayerPrivateKey = "59c6995e998f97a5a0044966f0945389dc9e86dae88c7a8412f4603b6b78690d"
+
+	nodeStartTimeout = 30 * time.Second
+)
+
+// Node is a dev node in a docker container. Anvil is used instead of geth --dev because the chain id of the geth
+// dev chain can't be set, and the routes of the engine need the chain ids of the testnet profile. The hardfork is
+// istanbul, the headers of the later hardforks can't be decoded by the go-ethereum version of the engine.
+type Node struct {
+	Chain   string
+	ChainID int64
+	URL     string
+	Client  *swap.BatchClient
+
+	container string
+}
+
+// StartNode runs the node of the chain in docker and waits until its rpc is up, blocks are mined every second
+func StartNode(image, chain string, chainID int64, port int) (*Node, error) {
+	name := fmt.Sprintf("occ-swap-integration-%s", strings.ToLower(chain))
+	out, err := exec.Command("docker", "run", "-d", "--rm", "--name", name, "-p", fmt.Sprintf("127.0.0.1:%d:8545", port),
+		"--entrypoint", "anvil", image,
+		"--host", "0.0.0.0", "--chain-id", fmt.Sprint(chainID), "--hardfork", "istanbul", "--block-time", "1").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("start %s node error: %s, %s", chain, err.Error(), strings.TrimSpace(string(out)))
+	}
+	node := &Node{
+		Chain:     chain,
+		ChainID:   chainID,
+		URL:       fmt.Sprintf("http://127.0.0.1:%d", port),
+		container: strings.TrimSpace(string(out)),
+	}
+
+	deadline := time.Now().Add(nodeStartTimeout)
+	for {
+		err = node.dial()
+		if err == nil {
+			return node, nil
+		}
+		if time.Now().After(deadline) {
+			node.Stop()
+			return nil, fmt.Errorf("%s node is not up after %s: %s", chain, nodeStartTimeout, err.Error())
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+func (node *Node) dial() error {
+	client, err := swap.DialBatchClient(node.URL)
+	if err != nil {
+		return err
+	}
+	chainID, err := client.ChainID(context.Background())
+	if err != nil {
+		client.Close()
+		return err
+	}
+	if chainID.Int64() != node.ChainID {
+		client.Close()
+		return fmt.Errorf("chain id of %s node is %d, not %d", node.Chain, chainID.Int64(), node.ChainID)
+	}
+	node.Client = client
+	return nil
+}
+
+// Stop removes the container of the node
+func (node *Node) Stop() {
+	if node.Client != nil {
+		node.Client.Close()
+	}
+	exec.Command("docker", "rm", "-f", node.container).Run()
+}
@@ -0,0 +1,329 @@
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/jinzhu/gorm"
+
+	sabi "occ-swap-server/abi"
+	"occ-swap-server/common"
+	"occ-swap-server/executor"
+	"occ-swap-server/model"
+	"occ-swap-server/observer"
+	"occ-swap-server/swap"
+	"occ-swap-server/swap/swaptest"
+	"occ-swap-server/util"
+)
+
+const receiptTimeout = 30 * time.Second
+
+// ChainIDs are the chain ids of the testnet profile, the executors name their chains by them
+var ChainIDs = map[string]int64{
+	common.ChainBSC:   97,
+	common.ChainETH:   4,
+	common.ChainMATIC: 338,
+}
+
+// Options of the suite, the zero values are the defaults
+type Options struct {
+	Image    string
+	BasePort int
+	// the sqlite db of the observers and the engine, in memory by default
+	DBPath string
+}
+
+// Suite holds the nodes, the db, the observers and the swap engine of a run
+type Suite struct {
+	DB     *gorm.DB
+	Config *util.Config
+
+	User    *ecdsa.PrivateKey
+	Relayer *ecdsa.PrivateKey
+	// key is the chain name
+	Nodes      map[string]*Node
+	SwapAgents map[string]ethcom.Address
+	Tokens     map[string]ethcom.Address
+
+	Engine    *swap.SwapEngine
+	Observers map[string]*observer.Observer
+
+	swapAgentABI *abi.ABI
+}
+
+// NewSuite starts a node for every chain of the testnet profile and deploys the mock contracts, the observers and
+// the engine are started by Start
+func NewSuite(opts Options) (*Suite, error) {
+	if opts.Image == "" {
+		opts.Image = DefaultNodeImage
+	}
+	if opts.BasePort == 0 {
+		opts.BasePort = DefaultBasePort
+	}
+	if opts.DBPath == "" {
+		opts.DBPath = model.SqliteInMemoryPath
+	}
+	user, err := crypto.HexToECDSA(UserPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	relayer, err := crypto.HexToECDSA(RelayerPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	swapAgentABI, err := abi.JSON(strings.NewReader(sabi.SwapAgentABI))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Suite{
+		User:         user,
+		Relayer:      relayer,
+		Nodes:        make(map[string]*Node),
+		SwapAgents:   make(map[string]ethcom.Address),
+		Tokens:       make(map[string]ethcom.Address),
+		Observers:    make(map[string]*observer.Observer),
+		swapAgentABI: &swapAgentABI,
+	}
+	for i, chain := range []string{common.ChainBSC, common.ChainETH, common.ChainMATIC} {
+		node, err := StartNode(opts.Image, chain, ChainIDs[chain], opts.BasePort+i)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.Nodes[chain] = node
+		if err := s.deployContracts(chain); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("deploy contracts on %s error: %s", chain, err.Error())
+		}
+	}
+
+	s.Config = s.newConfig()
+	if s.DB, err = model.OpenDB(common.DBDialectSqlite3, opts.DBPath); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := s.registerTokenPairs(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// registerTokenPairs saves the swap pairs of the token on BSC and the tokens on the other chains, the nodes deploy
+// the tokens at the same address so the chains may share a pair
+func (s *Suite) registerTokenPairs() error {
+	registered := make(map[ethcom.Address]bool)
+	for _, chain := range []string{common.ChainETH, common.ChainMATIC} {
+		if registered[s.Tokens[chain]] {
+			continue
+		}
+		if err := s.DB.Create(swaptest.TokenPair(s.Tokens[common.ChainBSC], s.Tokens[chain])).Error; err != nil {
+			return fmt.Errorf("register swap pair of %s error: %s", chain, err.Error())
+		}
+		registered[s.Tokens[chain]] = true
+	}
+	return nil
+}
+
+// deployContracts deploys the mock swap agent and token of the chain, the token is registered in the swap agent
+// under the chain id and the swap agent has the default liquidity of swaptest
+func (s *Suite) deployContracts(chain string) error {
+	receipt, err := s.SendTx(chain, s.User, nil, swaptest.MockSwapAgentDeployCode())
+	if err != nil {
+		return err
+	}
+	swapAgent := receipt.ContractAddress
+	if receipt, err = s.SendTx(chain, s.User, nil, swaptest.MockTokenDeployCode()); err != nil {
+		return err
+	}
+	token := receipt.ContractAddress
+	if _, err := s.SendTx(chain, s.User, &swapAgent, swaptest.SetTokenData(big.NewInt(s.Nodes[chain].ChainID), token)); err != nil {
+		return err
+	}
+	if _, err := s.SendTx(chain, s.User, &token, swaptest.SetBalanceData(swapAgent, swaptest.DefaultLiquidity)); err != nil {
+		return err
+	}
+	s.SwapAgents[chain], s.Tokens[chain] = swapAgent, token
+	return nil
+}
+
+// newConfig returns a config with short intervals on the nodes, the relayer signs the fill txs of all the chains
+func (s *Suite) newConfig() *util.Config {
+	return &util.Config{
+		KeyManagerConfig: util.KeyManagerConfig{
+			KeyType:              common.LocalPrivateKey,
+			LocalHMACKey:         "integration",
+			LocalBSCTxHash:       RelayerPrivateKey,
+			LocalETHPrivateKey:   RelayerPrivateKey,
+			LocalMATICPrivateKey: RelayerPrivateKey,
+		},
+		DBConfig: util.DBConfig{Dialect: common.DBDialectSqlite3, DBPath: model.SqliteInMemoryPath},
+		ChainConfig: util.ChainConfig{
+			BalanceMonitorInterval:     1,
+			MonitorSwapRequestInterval: 1,
+			ConfirmSwapRequestInterval: 1,
+			RetryFailedSwapInterval:    1,
+			TrackRetrySwapTxInterval:   1,
+
+			BSCProvider:              s.Nodes[common.ChainBSC].URL,
+			BSCChainID:               s.Nodes[common.ChainBSC].ChainID,
+			BSCConfirmNum:            1,
+			BSCSwapAgentAddr:         s.SwapAgents[common.ChainBSC].String(),
+			BSCMaxTrackRetry:         30,
+			BSCSwapDaemonInterval:    1,
+			BSCTrackTxInterval:       1,
+			BSCObserverFetchInterval: 1,
+
+			ETHProvider:              s.Nodes[common.ChainETH].URL,
+			ETHChainID:               s.Nodes[common.ChainETH].ChainID,
+			ETHConfirmNum:            1,
+			ETHSwapAgentAddr:         s.SwapAgents[common.ChainETH].String(),
+			ETHMaxTrackRetry:         30,
+			ETHSwapDaemonInterval:    1,
+			ETHTrackTxInterval:       1,
+			ETHObserverFetchInterval: 1,
+
+			MATICProvider:              s.Nodes[common.ChainMATIC].URL,
+			MATICChainID:               s.Nodes[common.ChainMATIC].ChainID,
+			MATICConfirmNum:            1,
+			MATICSwapAgentAddr:         s.SwapAgents[common.ChainMATIC].String(),
+			MATICMaxTrackRetry:         30,
+			MATICSwapDaemonInterval:    1,
+			MATICTrackTxInterval:       1,
+			MATICObserverFetchInterval: 1,
+		},
+		AlertConfig:       util.AlertConfig{BlockUpdateTimeout: 60},
+		EnvironmentConfig: util.EnvironmentConfig{Profile: util.EnvironmentTestnet},
+	}
+}
+
+// Start starts the observers and the swap engine on the nodes, the same as main does
+func (s *Suite) Start() error {
+	for chain, node := range s.Nodes {
+		head, err := node.Client.HeaderByNumber(context.Background(), nil)
+		if err != nil {
+			return err
+		}
+		// the blocks of the deployment have no swap
+		chainExecutor := executor.NewBSCExecutor(node.Client.Client, s.SwapAgents[chain].String(), s.Config, node.ChainID)
+		s.Observers[chain] = observer.NewObserver(s.DB, head.Number.Int64()+1, 1, s.Config, chainExecutor)
+		s.Observers[chain].Start()
+	}
+
+	engine, err := swap.NewSwapEngine(s.DB, s.Config,
+		s.Nodes[common.ChainBSC].Client, s.Nodes[common.ChainETH].Client, s.Nodes[common.ChainMATIC].Client)
+	if err != nil {
+		return err
+	}
+	s.Engine = engine
+	s.Engine.Start()
+	return nil
+}
+
+// Close stops the nodes and closes the db, the daemons of the engine and the observers are left to the exit of
+// the process
+func (s *Suite) Close() {
+	for _, node := range s.Nodes {
+		node.Stop()
+	}
+	if s.DB != nil {
+		s.DB.Close()
+	}
+}
+
+// SendTx signs the tx with the key and waits for its receipt, the contract is created if to is nil
+func (s *Suite) SendTx(chain string, key *ecdsa.PrivateKey, to *ethcom.Address, data []byte) (*types.Receipt, error) {
+	node, ok := s.Nodes[chain]
+	if !ok {
+		return nil, fmt.Errorf("unknown chain %s", chain)
+	}
+	ctx := context.Background()
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	nonce, err := node.Client.PendingNonceAt(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+	gasPrice, err := node.Client.SuggestGasPrice(ctx)
+	if err != nil {
+		return nil, err
+	}
+	gasLimit, err := node.Client.EstimateGas(ctx, ethereum.CallMsg{From: from, To: to, Data: data})
+	if err != nil {
+		return nil, err
+	}
+	var rawTx *types.Transaction
+	if to == nil {
+		rawTx = types.NewContractCreation(nonce, big.NewInt(0), gasLimit, gasPrice, data)
+	} else {
+		rawTx = types.NewTransaction(nonce, *to, big.NewInt(0), gasLimit, gasPrice, data)
+	}
+	signedTx, err := bind.NewKeyedTransactor(key).Signer(types.NewEIP155Signer(big.NewInt(node.ChainID)), from, rawTx)
+	if err != nil {
+		return nil, err
+	}
+	if err := node.Client.SendTransaction(ctx, signedTx); err != nil {
+		return nil, err
+	}
+	return s.WaitForReceipt(chain, signedTx.Hash())
+}
+
+// WaitForReceipt polls the receipt of the tx until it is mined, it is an error if the tx is failed
+func (s *Suite) WaitForReceipt(chain string, txHash ethcom.Hash) (*types.Receipt, error) {
+	deadline := time.Now().Add(receiptTimeout)
+	for {
+		receipt, err := s.Nodes[chain].Client.TransactionReceipt(context.Background(), txHash)
+		if err == nil {
+			if receipt.Status == types.ReceiptStatusFailed {
+				return receipt, fmt.Errorf("tx %s on %s is failed", txHash.String(), chain)
+			}
+			return receipt, nil
+		}
+		if err != ethereum.NotFound || time.Now().After(deadline) {
+			return nil, fmt.Errorf("get receipt of tx %s on %s error: %s", txHash.String(), chain, err.Error())
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// StartSwap sends a swap tx of the user to the swap agent of the source chain, the observer picks it up
+func (s *Suite) StartSwap(fromChain, toChain string, amount *big.Int) (*types.Receipt, error) {
+	data, err := s.swapAgentABI.Pack("swap", big.NewInt(s.Nodes[fromChain].ChainID), big.NewInt(s.Nodes[toChain].ChainID), amount)
+	if err != nil {
+		return nil, err
+	}
+	swapAgent := s.SwapAgents[fromChain]
+	return s.SendTx(fromChain, s.User, &swapAgent, data)
+}
+
+// WaitForSwap polls the swap of the start tx until it has the status
+func (s *Suite) WaitForSwap(startTxHash string, status common.SwapStatus, timeout time.Duration) (*model.Swap, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		var swap model.Swap
+		err := s.DB.Where("start_tx_hash = ?", startTxHash).First(&swap).Error
+		if err == nil && swap.Status == status {
+			return &swap, nil
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return nil, fmt.Errorf("swap of %s is not found: %s", startTxHash, err.Error())
+			}
+			return &swap, fmt.Errorf("swap of %s is %s, not %s after %s, log: %s", startTxHash, swap.Status, status, timeout, swap.Log)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
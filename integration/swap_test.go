@@ -0,0 +1,141 @@
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"occ-swap-server/common"
+	"occ-swap-server/events"
+	"occ-swap-server/model"
+	"occ-swap-server/swap"
+)
+
+const swapTimeout = 2 * time.Minute
+
+var (
+	image    = flag.String("image", DefaultNodeImage, "docker image of the anvil nodes")
+	basePort = flag.Int("base-port", DefaultBasePort, "rpc port of the first node, the other nodes use the next ports")
+	dbPath   = flag.String("db-path", "", "sqlite db of the observers and the engine, in memory by default")
+
+	// the suite shared by the tests, it is started by TestMain
+	suite *Suite
+)
+
+var chainPairs = [][2]string{
+	{common.ChainBSC, common.ChainETH},
+	{common.ChainBSC, common.ChainMATIC},
+	{common.ChainETH, common.ChainBSC},
+	{common.ChainETH, common.ChainMATIC},
+	{common.ChainMATIC, common.ChainBSC},
+	{common.ChainMATIC, common.ChainETH},
+}
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	s, err := NewSuite(Options{Image: *image, BasePort: *basePort, DBPath: *dbPath})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "set up suite error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	if err := s.Start(); err != nil {
+		s.Close()
+		fmt.Fprintf(os.Stderr, "start suite error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	suite = s
+	code := m.Run()
+	s.Close()
+	os.Exit(code)
+}
+
+// TestSwap runs a swap of every direction
+func TestSwap(t *testing.T) {
+	for i, pair := range chainPairs {
+		fromChain, toChain, amount := pair[0], pair[1], big.NewInt(int64(1000+i))
+		t.Run(fmt.Sprintf("%s to %s", fromChain, toChain), func(t *testing.T) {
+			runSwaps(t, suite, fromChain, toChain, []*big.Int{amount})
+		})
+	}
+}
+
+// TestSwapBatch runs a batch of swaps of the same direction started at once
+func TestSwapBatch(t *testing.T) {
+	amounts := make([]*big.Int, 0, 5)
+	for i := 0; i < 5; i++ {
+		amounts = append(amounts, big.NewInt(int64(2000+i)))
+	}
+	runSwaps(t, suite, common.ChainBSC, common.ChainETH, amounts)
+}
+
+// runSwaps starts a swap of every amount and checks that all of them are filled
+func runSwaps(t *testing.T, s *Suite, fromChain, toChain string, amounts []*big.Int) {
+	startTxHashes := make([]string, 0, len(amounts))
+	for _, amount := range amounts {
+		receipt, err := s.StartSwap(fromChain, toChain, amount)
+		if err != nil {
+			t.Fatalf("start swap error: %s", err.Error())
+		}
+		startTxHashes = append(startTxHashes, receipt.TxHash.String())
+	}
+	fillTxHashes := make(map[string]bool)
+	for i, startTxHash := range startTxHashes {
+		filled, err := s.WaitForSwap(startTxHash, swap.SwapSuccess, swapTimeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fillTxHashes[filled.FillTxHash] {
+			t.Fatalf("fill tx %s is recorded for several swaps", filled.FillTxHash)
+		}
+		fillTxHashes[filled.FillTxHash] = true
+		if err := s.checkFill(filled, toChain, amounts[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkFill checks the fill tx of the swap is successful in db, and has the SwapFilled event of the user and the
+// amount on the destination chain
+func (s *Suite) checkFill(filled *model.Swap, toChain string, amount *big.Int) error {
+	swapTx := model.SwapFillTx{}
+	if err := s.DB.Where("fill_swap_tx_hash = ?", filled.FillTxHash).First(&swapTx).Error; err != nil {
+		return fmt.Errorf("fill tx %s of swap %s is not found: %s", filled.FillTxHash, filled.StartTxHash, err.Error())
+	}
+	if swapTx.Status != model.FillTxSuccess {
+		return fmt.Errorf("fill tx %s of swap %s has status %d", filled.FillTxHash, filled.StartTxHash, swapTx.Status)
+	}
+	if swapTx.SwapID != filled.ID {
+		return fmt.Errorf("fill tx %s of swap %s is linked to swap %d", filled.FillTxHash, filled.StartTxHash, swapTx.SwapID)
+	}
+
+	receipt, err := s.Nodes[toChain].Client.TransactionReceipt(context.Background(), ethcom.HexToHash(filled.FillTxHash))
+	if err != nil {
+		return fmt.Errorf("get receipt of fill tx %s error: %s", filled.FillTxHash, err.Error())
+	}
+	user := crypto.PubkeyToAddress(s.User.PublicKey)
+	for _, log := range receipt.Logs {
+		if log.Address != s.SwapAgents[toChain] {
+			continue
+		}
+		event, err := events.DefaultDecoder.DecodeSwapFilled(log)
+		if err != nil {
+			continue
+		}
+		if event.ToAddress != user || event.Amount.Cmp(amount) != 0 {
+			return fmt.Errorf("SwapFilled event of fill tx %s is to %s of %s, expected %s of %s",
+				filled.FillTxHash, event.ToAddress.String(), event.Amount.String(), user.String(), amount.String())
+		}
+		return nil
+	}
+	return fmt.Errorf("no SwapFilled event is found in fill tx %s", filled.FillTxHash)
+}
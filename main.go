@@ -1,18 +1,21 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"math/big"
+	"os"
+	"strings"
 
 	"occ-swap-server/admin"
 
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/jinzhu/gorm"
-	_ "github.com/jinzhu/gorm/dialects/mysql"
-	_ "github.com/jinzhu/gorm/dialects/sqlite"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 
+	"occ-swap-server/common"
 	"occ-swap-server/executor"
 	"occ-swap-server/model"
 	"occ-swap-server/observer"
@@ -25,6 +28,8 @@ const (
 	flagConfigAwsRegion    = "aws-region"
 	flagConfigAwsSecretKey = "aws-secret-key"
 	flagConfigPath         = "config-path"
+	flagRole               = "role"
+	flagRecover            = "recover"
 )
 
 const (
@@ -37,6 +42,8 @@ func initFlags() {
 	flag.String(flagConfigType, "", "config type, local or aws")
 	flag.String(flagConfigAwsRegion, "", "aws s3 region")
 	flag.String(flagConfigAwsSecretKey, "", "aws s3 secret key")
+	flag.String(flagRole, "", "role of the process, all, observer or executor, overrides the role in config")
+	flag.String(flagRecover, "", "recover the swaps from the chain events of the block ranges and exit, e.g. BSC:1000-2000,ETH:500-900")
 
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
 	pflag.Parse()
@@ -47,7 +54,55 @@ func initFlags() {
 }
 
 func printUsage() {
-	fmt.Print("usage: ./swap --config-type [local or aws] --config-path config_file_path\n")
+	fmt.Print("usage: ./swap --config-type [local or aws] --config-path config_file_path [--role all, observer or executor] [--recover chain:from-to,...]\n")
+}
+
+func exitWithConfigErrors(errs []string) {
+	fmt.Fprintf(os.Stderr, "invalid config, %d problems found:\n", len(errs))
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "  - %s\n", err)
+	}
+	os.Exit(1)
+}
+
+// checkSwapPairs checks the bounds of the swap pairs in db parse as big integers
+func checkSwapPairs(db *gorm.DB) []string {
+	errs := make([]string, 0)
+	pairs := make([]model.SwapPair, 0)
+	db.Find(&pairs)
+	for _, pair := range pairs {
+		lowBound, ok := big.NewInt(0).SetString(pair.LowBound, 10)
+		if !ok {
+			errs = append(errs, fmt.Sprintf("low_bound of swap pair %s should be a big integer: %s", pair.Symbol, pair.LowBound))
+		}
+		upperBound, okk := big.NewInt(0).SetString(pair.UpperBound, 10)
+		if !okk {
+			errs = append(errs, fmt.Sprintf("upper_bound of swap pair %s should be a big integer: %s", pair.Symbol, pair.UpperBound))
+		}
+		if ok && okk && lowBound.Cmp(upperBound) > 0 {
+			errs = append(errs, fmt.Sprintf("low_bound of swap pair %s is larger than upper_bound", pair.Symbol))
+		}
+	}
+	return errs
+}
+
+// recoverSwaps rebuilds the swaps from the chain events of the ranges and prints the report, the engine and the
+// observers are not started
+func recoverSwaps(db *gorm.DB, config *util.Config, recoverRanges string, bscClient, ethClient, maticClient *swap.BatchClient) {
+	ranges, err := swap.ParseRecoveryRanges(recoverRanges)
+	if err != nil {
+		exitWithConfigErrors([]string{err.Error()})
+	}
+	swapEngine, err := swap.NewSwapEngine(db, config, bscClient, ethClient, maticClient)
+	if err != nil {
+		panic(fmt.Sprintf("create swap engine error, err=%s", err.Error()))
+	}
+	report := swapEngine.Recover(ranges)
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+	if report.Error != "" {
+		os.Exit(1)
+	}
 }
 
 func main() {
@@ -92,45 +147,80 @@ func main() {
 		}
 		config = util.ParseConfigFromFile(configFilePath)
 	}
-	config.Validate()
+	if role := viper.GetString(flagRole); role != "" {
+		config.Role = role
+	}
+	if errs := config.Check(); len(errs) > 0 {
+		exitWithConfigErrors(errs)
+	}
 
 	// init logger
 	util.InitLogger(config.LogConfig)
 	util.InitTgAlerter(config.AlertConfig)
 
-	db, err := gorm.Open(config.DBConfig.Dialect, config.DBConfig.DBPath)
+	db, err := model.OpenDB(config.DBConfig.Dialect, config.DBConfig.DBPath)
 	if err != nil {
 		panic(fmt.Sprintf("open db error, err=%s", err.Error()))
 	}
 	defer db.Close()
-	model.InitTables(db)
+	// the daemons poll these tables all the time, without the indexes they scan them
+	for _, index := range model.MissingDaemonIndexes(db) {
+		util.Logger.Warningf("index %s on %s(%s) is missing, the daemon queries on %s will scan the table",
+			index.Name, index.Table, strings.Join(index.Columns, ", "), index.Table)
+	}
 
-	bscClient, err := ethclient.Dial(config.ChainConfig.BSCProvider)
+	bscClient, err := swap.DialBatchClient(config.ChainConfig.BSCProvider)
 	if err != nil {
 		panic("new bsc client error")
 	}
 
-	ethClient, err := ethclient.Dial(config.ChainConfig.ETHProvider)
+	ethClient, err := swap.DialBatchClient(config.ChainConfig.ETHProvider)
 	if err != nil {
 		panic("new eth client error")
 	}
 
-	maticClient, err := ethclient.Dial(config.ChainConfig.MATICProvider)
+	maticClient, err := swap.DialBatchClient(config.ChainConfig.MATICProvider)
 	if err != nil {
 		panic("new matic client error")
 	}
 
-	bscExecutor := executor.NewBSCExecutor(bscClient, config.ChainConfig.BSCSwapAgentAddr, config, 97)
-	bscObserver := observer.NewObserver(db, config.ChainConfig.BSCStartHeight, config.ChainConfig.BSCConfirmNum, config, bscExecutor)
-	bscObserver.Start()
+	errs := util.CheckChains(config, map[string]*ethclient.Client{
+		common.ChainBSC:   bscClient.Client,
+		common.ChainETH:   ethClient.Client,
+		common.ChainMATIC: maticClient.Client,
+	})
+	errs = append(errs, checkSwapPairs(db)...)
+	if len(errs) > 0 {
+		exitWithConfigErrors(errs)
+	}
 
-	ethExecutor := executor.NewBSCExecutor(ethClient, config.ChainConfig.ETHSwapAgentAddr, config, 4)
-	ethObserver := observer.NewObserver(db, config.ChainConfig.ETHStartHeight, config.ChainConfig.ETHConfirmNum, config, ethExecutor)
-	ethObserver.Start()
+	if recoverRanges := viper.GetString(flagRecover); recoverRanges != "" {
+		recoverSwaps(db, config, recoverRanges, bscClient, ethClient, maticClient)
+		return
+	}
 
-	maticExecutor := executor.NewBSCExecutor(maticClient, config.ChainConfig.MATICSwapAgentAddr, config, 338)
-	maticObserver := observer.NewObserver(db, config.ChainConfig.MATICStartHeight, config.ChainConfig.MATICConfirmNum, config, maticExecutor)
-	maticObserver.Start()
+	// the observers and the executor share the db, so they can run as separate processes
+	observers := make(map[string]*observer.Observer)
+	if config.HasRole(util.RoleObserver) {
+		bscExecutor := executor.NewBSCExecutor(bscClient.Client, config.ChainConfig.BSCSwapAgentAddr, config, 97)
+		observers[common.ChainBSC] = observer.NewObserver(db, config.ChainConfig.BSCStartHeight, config.ChainConfig.BSCConfirmNum, config, bscExecutor)
+
+		ethExecutor := executor.NewBSCExecutor(ethClient.Client, config.ChainConfig.ETHSwapAgentAddr, config, 4)
+		observers[common.ChainETH] = observer.NewObserver(db, config.ChainConfig.ETHStartHeight, config.ChainConfig.ETHConfirmNum, config, ethExecutor)
+
+		maticExecutor := executor.NewBSCExecutor(maticClient.Client, config.ChainConfig.MATICSwapAgentAddr, config, 338)
+		observers[common.ChainMATIC] = observer.NewObserver(db, config.ChainConfig.MATICStartHeight, config.ChainConfig.MATICConfirmNum, config, maticExecutor)
+
+		for _, ob := range observers {
+			ob.Start()
+		}
+	}
+	util.Logger.Infof("start as %s", config.GetRole())
+
+	if !config.HasRole(util.RoleExecutor) {
+		go admin.ServeObserver(config)
+		select {}
+	}
 
 	swapEngine, err := swap.NewSwapEngine(db, config, bscClient, ethClient, maticClient)
 	if err != nil {
@@ -143,7 +233,7 @@ func main() {
 	if err != nil {
 		panic(fmt.Sprintf("new hmac singer error, err=%s", err.Error()))
 	}
-	admin := admin.NewAdmin(config, db, signer, swapEngine)
+	admin := admin.NewAdmin(config, db, signer, swapEngine, observers)
 	go admin.Serve()
 
 	select {}
@@ -0,0 +1,52 @@
+package model
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// AdminUser is a user of the admin api, the role of the user decides the endpoints it can call. The user signs the
+// requests with its api secret, or sends a token issued to it.
+type AdminUser struct {
+	gorm.Model
+	Name      string `gorm:"not null;unique_index:admin_user_name"`
+	Role      string `gorm:"not null"`
+	ApiKey    string `gorm:"not null;unique_index:admin_user_api_key"`
+	ApiSecret string `gorm:"not null"`
+	// disabled users are rejected, the tokens issued to them included
+	Disabled bool `gorm:"not null;default:false"`
+}
+
+func (AdminUser) TableName() string {
+	return "admin_users"
+}
+
+// AdminRequestNonce is a nonce of a signed admin request, the nonces are kept for the replay window so that the
+// replicas of the admin api reject a replayed request alike
+type AdminRequestNonce struct {
+	ID       uint   `gorm:"primary_key"`
+	ApiKey   string `gorm:"not null;unique_index:admin_request_nonce_key"`
+	Nonce    string `gorm:"not null;unique_index:admin_request_nonce_key"`
+	SignedAt int64  `gorm:"not null;index:admin_request_nonce_signed_at"`
+}
+
+func (AdminRequestNonce) TableName() string {
+	return "admin_request_nonces"
+}
+
+// SponsorChallenge is a challenge issued to a sponsor for a self service request, the challenges are kept in the db
+// so that a challenge issued by a replica of the admin api is accepted by the others, once
+type SponsorChallenge struct {
+	ID        uint   `gorm:"primary_key"`
+	Challenge string `gorm:"not null;unique_index:sponsor_challenge_challenge"`
+	Sponsor   string `gorm:"not null"`
+	// method and path of the request the challenge is issued for, and the keccak256 of its body
+	Action     string `gorm:"not null"`
+	ParamsHash string `gorm:"not null"`
+	// ip the challenge is issued to
+	IP       string `gorm:"not null;index:sponsor_challenge_ip"`
+	ExpireAt int64  `gorm:"not null;index:sponsor_challenge_expire_at"`
+}
+
+func (SponsorChallenge) TableName() string {
+	return "sponsor_challenges"
+}
@@ -0,0 +1,56 @@
+package model
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// FormatAmount returns the amount in the smallest unit of a token of the decimals as a decimal string in the unit of
+// the token, without trailing zeros, e.g. 1500000000000000000 of 18 decimals is 1.5. It is exact, unlike the floats.
+func FormatAmount(amount *big.Int, decimals int) string {
+	sign := ""
+	if amount.Sign() < 0 {
+		sign = "-"
+	}
+	digits := new(big.Int).Abs(amount).String()
+	if decimals <= 0 {
+		return sign + digits
+	}
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+	whole, fraction := digits[:len(digits)-decimals], strings.TrimRight(digits[len(digits)-decimals:], "0")
+	if fraction == "" {
+		return sign + whole
+	}
+	return sign + whole + "." + fraction
+}
+
+// FormatRawAmount is FormatAmount of an amount column, it fails if the column is not an integer
+func FormatRawAmount(raw string, decimals int) (string, error) {
+	amount, ok := big.NewInt(0).SetString(raw, 10)
+	if !ok {
+		return "", fmt.Errorf("invalid amount: %q", raw)
+	}
+	return FormatAmount(amount, decimals), nil
+}
+
+// ParseAmount returns the decimal string in the unit of a token of the decimals in its smallest unit, it is the
+// inverse of FormatAmount. It fails if the string has more fractional digits than the decimals.
+func ParseAmount(value string, decimals int) (*big.Int, error) {
+	value = strings.TrimSpace(value)
+	whole, fraction := value, ""
+	if i := strings.IndexByte(value, '.'); i >= 0 {
+		whole, fraction = value[:i], value[i+1:]
+	}
+	if decimals < 0 || len(fraction) > decimals {
+		return nil, fmt.Errorf("amount %q has more than %d decimals", value, decimals)
+	}
+	digits := whole + fraction + strings.Repeat("0", decimals-len(fraction))
+	amount, ok := big.NewInt(0).SetString(digits, 10)
+	if !ok || whole == "" && fraction == "" || strings.ContainsAny(whole+fraction, "+-") {
+		return nil, fmt.Errorf("invalid amount: %q", value)
+	}
+	return amount, nil
+}
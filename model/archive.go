@@ -0,0 +1,183 @@
+package model
+
+import (
+	"time"
+
+	"occ-swap-server/common"
+)
+
+// ArchivedSwap is a swap moved out of the swaps table by the retention policy, it keeps the id and the record
+// hash of the swap. The archive tables are only read by the operators, so only the hashes and the sponsor are
+// indexed.
+type ArchivedSwap struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	Status          common.SwapStatus `gorm:"not null"`
+	Sponsor         string            `gorm:"not null;index:archived_swap_sponsor"`
+	Recipient       string            `gorm:"not null;default:''"`
+	Memo            string            `gorm:"not null;default:''"`
+	ToChainId       string            `gorm:"not null"`
+	BEP20Addr       string            `gorm:"not null"`
+	ERC20Addr       string            `gorm:"not null"`
+	Symbol          string
+	Amount          string               `gorm:"not null"`
+	Decimals        int                  `gorm:"not null"`
+	AmountDecimal   string               `gorm:"not null;default:''"`
+	Direction       common.SwapDirection `gorm:"not null"`
+	AssetType       common.AssetType     `gorm:"not null;default:'fungible'"`
+	TokenId         string               `gorm:"not null;default:''"`
+	StartTxHash     string               `gorm:"not null;index:archived_swap_start_tx_hash"`
+	FillTxHash      string               `gorm:"not null;index:archived_swap_fill_tx_hash"`
+	Log             string
+	RevertReason    string
+	DelayedUntil    int64
+	FailureClass    common.FailureClass
+	FailureCategory common.FailureCategory `gorm:"not null;default:''"`
+	RetryAttempts   int64
+	FeeRebate       string `gorm:"not null;default:''"`
+	ImportedFrom    string `gorm:"not null;default:''"`
+	RecordHash      string `gorm:"not null"`
+
+	// unix time the swap is archived
+	ArchivedAt int64 `gorm:"not null"`
+}
+
+func (ArchivedSwap) TableName() string {
+	return "archived_swaps"
+}
+
+func NewArchivedSwap(swap *Swap, archivedAt int64) *ArchivedSwap {
+	return &ArchivedSwap{
+		ID:              swap.ID,
+		CreatedAt:       swap.CreatedAt,
+		UpdatedAt:       swap.UpdatedAt,
+		Status:          swap.Status,
+		Sponsor:         swap.Sponsor,
+		Recipient:       swap.Recipient,
+		Memo:            swap.Memo,
+		ToChainId:       swap.ToChainId,
+		BEP20Addr:       swap.BEP20Addr,
+		ERC20Addr:       swap.ERC20Addr,
+		Symbol:          swap.Symbol,
+		Amount:          swap.Amount,
+		Decimals:        swap.Decimals,
+		AmountDecimal:   swap.AmountDecimal,
+		Direction:       swap.Direction,
+		AssetType:       swap.AssetType,
+		TokenId:         swap.TokenId,
+		StartTxHash:     swap.StartTxHash,
+		FillTxHash:      swap.FillTxHash,
+		Log:             swap.Log,
+		RevertReason:    swap.RevertReason,
+		DelayedUntil:    swap.DelayedUntil,
+		FailureClass:    swap.FailureClass,
+		FailureCategory: swap.FailureCategory,
+		RetryAttempts:   swap.RetryAttempts,
+		FeeRebate:       swap.FeeRebate,
+		ImportedFrom:    swap.ImportedFrom,
+		RecordHash:      swap.RecordHash,
+		ArchivedAt:      archivedAt,
+	}
+}
+
+// ArchivedSwapStartTxLog is the start tx log of an archived swap, the observers still check it so an archived
+// swap is never saved again
+type ArchivedSwapStartTxLog struct {
+	Id    int64  `gorm:"primary_key"`
+	Chain string `gorm:"not null"`
+
+	TokenAddr   string `gorm:"not null"`
+	FromAddress string `gorm:"not null"`
+	Amount      string `gorm:"not null"`
+	FeeAmount   string `gorm:"not null"`
+	ToChainId   string `gorm:"not null"`
+	TokenId     string `gorm:"not null;default:''"`
+	Recipient   string `gorm:"not null;default:''"`
+	Memo        string `gorm:"not null;default:''"`
+
+	Status       TxStatus `gorm:"not null"`
+	TxHash       string   `gorm:"not null;unique_index:archived_swap_start_tx_log_tx_hash"`
+	BlockHash    string   `gorm:"not null"`
+	Height       int64    `gorm:"not null"`
+	ConfirmedNum int64    `gorm:"not null"`
+	Phase        TxPhase  `gorm:"not null"`
+
+	UpdateTime int64
+	CreateTime int64
+	ArchivedAt int64 `gorm:"not null"`
+}
+
+func (ArchivedSwapStartTxLog) TableName() string {
+	return "archived_swap_start_txs"
+}
+
+func NewArchivedSwapStartTxLog(txEventLog *SwapStartTxLog, archivedAt int64) *ArchivedSwapStartTxLog {
+	return &ArchivedSwapStartTxLog{
+		Id:           txEventLog.Id,
+		Chain:        txEventLog.Chain,
+		TokenAddr:    txEventLog.TokenAddr,
+		FromAddress:  txEventLog.FromAddress,
+		Amount:       txEventLog.Amount,
+		FeeAmount:    txEventLog.FeeAmount,
+		ToChainId:    txEventLog.ToChainId,
+		TokenId:      txEventLog.TokenId,
+		Recipient:    txEventLog.Recipient,
+		Memo:         txEventLog.Memo,
+		Status:       txEventLog.Status,
+		TxHash:       txEventLog.TxHash,
+		BlockHash:    txEventLog.BlockHash,
+		Height:       txEventLog.Height,
+		ConfirmedNum: txEventLog.ConfirmedNum,
+		Phase:        txEventLog.Phase,
+		UpdateTime:   txEventLog.UpdateTime,
+		CreateTime:   txEventLog.CreateTime,
+		ArchivedAt:   archivedAt,
+	}
+}
+
+// ArchivedSwapFillTx is a fill tx of an archived swap, the raw tx is dropped as it is never rebroadcast again
+type ArchivedSwapFillTx struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	SwapID            uint                 `gorm:"not null;default:0"`
+	Direction         common.SwapDirection `gorm:"not null"`
+	StartSwapTxHash   string               `gorm:"not null;index:archived_swap_fill_tx_start_swap_tx_hash"`
+	FillSwapTxHash    string               `gorm:"not null"`
+	GasPrice          string               `gorm:"not null"`
+	ConsumedFeeAmount string
+	Height            int64
+	Status            FillTxStatus `gorm:"not null"`
+	RevertReason      string
+	GasLimit          int64 `gorm:"not null;default:0"`
+	GasEstimate       int64 `gorm:"not null;default:0"`
+
+	ArchivedAt int64 `gorm:"not null"`
+}
+
+func (ArchivedSwapFillTx) TableName() string {
+	return "archived_swap_fill_txs"
+}
+
+func NewArchivedSwapFillTx(swapTx *SwapFillTx, archivedAt int64) *ArchivedSwapFillTx {
+	return &ArchivedSwapFillTx{
+		ID:                swapTx.ID,
+		CreatedAt:         swapTx.CreatedAt,
+		UpdatedAt:         swapTx.UpdatedAt,
+		SwapID:            swapTx.SwapID,
+		Direction:         swapTx.Direction,
+		StartSwapTxHash:   swapTx.StartSwapTxHash,
+		FillSwapTxHash:    swapTx.FillSwapTxHash,
+		GasPrice:          swapTx.GasPrice,
+		ConsumedFeeAmount: swapTx.ConsumedFeeAmount,
+		Height:            swapTx.Height,
+		Status:            swapTx.Status,
+		RevertReason:      swapTx.RevertReason,
+		GasLimit:          swapTx.GasLimit,
+		GasEstimate:       swapTx.GasEstimate,
+		ArchivedAt:        archivedAt,
+	}
+}
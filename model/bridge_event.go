@@ -0,0 +1,20 @@
+package model
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// BridgeEvent is a swap lifecycle event waiting to be published to the event sink, it is written in the tx changing
+// the swap so no event is lost. The id is the sequence of the events, the sink gets them in its order.
+type BridgeEvent struct {
+	gorm.Model
+	Type        string `gorm:"not null"`
+	SwapID      uint   `gorm:"not null;index:bridge_event_swap_id"`
+	Payload     string `gorm:"type:text;not null"`
+	Published   bool   `gorm:"not null;default:false;index:bridge_event_published"`
+	PublishedAt int64  `gorm:"not null;default:0"`
+}
+
+func (BridgeEvent) TableName() string {
+	return "bridge_events"
+}
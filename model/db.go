@@ -0,0 +1,50 @@
+package model
+
+import (
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/mysql"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+
+	"occ-swap-server/common"
+)
+
+const (
+	// SqliteInMemoryPath opens a sqlite database which only lives in memory, for local development and tests
+	SqliteInMemoryPath = ":memory:"
+
+	// SqliteBusyTimeout is how long in milliseconds a sqlite write waits for the lock held by another writer
+	SqliteBusyTimeout = 5000
+)
+
+// OpenDB opens the db of the dialect and creates the tables. sqlite only allows one writer at a time and every
+// connection to ":memory:" opens a new empty database, so sqlite dbs are limited to one connection, all the queries
+// of a transaction must be sent through the transaction itself.
+func OpenDB(dialect, path string) (*gorm.DB, error) {
+	db, err := gorm.Open(dialect, path)
+	if err != nil {
+		return nil, err
+	}
+	if dialect == common.DBDialectSqlite3 {
+		db.DB().SetMaxOpenConns(1)
+		if err := db.Exec(fmt.Sprintf("PRAGMA busy_timeout = %d", SqliteBusyTimeout)).Error; err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	InitTables(db)
+	return db, nil
+}
+
+// LockForUpdate locks the rows selected by the query until the end of the tx, the rows locked by another tx are
+// skipped instead of waited for, so the executor replicas never pick the same rows. Only postgres supports it,
+// sqlite serializes the writers anyway and mysql can't skip the locked rows before 8.0, the claims of the rows are
+// conditional so the replicas still pick a row once there.
+func LockForUpdate(db *gorm.DB) *gorm.DB {
+	if db.Dialect().GetName() == common.DBDialectPostgres {
+		return db.Set("gorm:query_option", "FOR UPDATE SKIP LOCKED")
+	}
+	return db
+}
@@ -0,0 +1,35 @@
+package model
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// DrainBrakeHalt is a chain whose fills are halted by the drain brake, it is only deleted when the admin releases the
+// chain, so the halt outlives a restart and holds for every replica
+type DrainBrakeHalt struct {
+	gorm.Model
+	Chain string `gorm:"not null;unique_index:drain_brake_halt_chain"`
+	// the relayer account whose spend of the token, or of the native coin, went above the ceiling
+	Account string `gorm:"not null"`
+	Token   string `gorm:"not null"`
+	Spent   string `gorm:"not null"`
+	Ceiling string `gorm:"not null"`
+}
+
+func (DrainBrakeHalt) TableName() string {
+	return "drain_brake_halts"
+}
+
+// RelayerSpend is an amount of a token, or of the native coin, sent by a relayer account, the drain brake sums those
+// of all the replicas within the last hour
+type RelayerSpend struct {
+	gorm.Model
+	Chain   string `gorm:"not null;index:relayer_spend_chain"`
+	Account string `gorm:"not null"`
+	Token   string `gorm:"not null"`
+	Amount  string `gorm:"not null"`
+}
+
+func (RelayerSpend) TableName() string {
+	return "relayer_spends"
+}
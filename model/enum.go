@@ -0,0 +1,114 @@
+package model
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+
+	"occ-swap-server/common"
+)
+
+// CheckEnums rejects the swaps whose status or direction is not in the registries of the common package, a swap of
+// a mistyped status is never picked up by a daemon. The swaps rejected for their to chain id have no direction.
+func (swap *Swap) CheckEnums() error {
+	if !swap.Status.Valid() {
+		return fmt.Errorf("unknown swap status: %q", swap.Status)
+	}
+	if swap.Direction == "" && swap.Status == common.SwapStatusRejected {
+		return nil
+	}
+	return checkDirection(swap.Direction)
+}
+
+func checkDirection(direction common.SwapDirection) error {
+	if !direction.Valid() {
+		return fmt.Errorf("unknown swap direction: %q", direction)
+	}
+	return nil
+}
+
+// EnumConstraint is a check constraint limiting a column to the values of a registry
+type EnumConstraint struct {
+	Table  string
+	Name   string
+	Column string
+	Values []string
+}
+
+// EnumConstraints are the check constraints of the swap statuses and directions
+func EnumConstraints() []EnumConstraint {
+	statuses := make([]string, 0, len(common.SwapStatuses))
+	for _, status := range common.SwapStatuses {
+		statuses = append(statuses, string(status))
+	}
+	directions := make([]string, 0, len(common.SwapDirections))
+	for _, direction := range common.SwapDirections {
+		directions = append(directions, string(direction))
+	}
+	sort.Strings(statuses)
+	sort.Strings(directions)
+	return []EnumConstraint{
+		{Table: "swaps", Name: "swap_status_enum", Column: "status", Values: statuses},
+		{Table: "swaps", Name: "swap_direction_enum", Column: "direction", Values: append([]string{""}, directions...)},
+		{Table: "retry_swaps", Name: "retry_swap_direction_enum", Column: "direction", Values: directions},
+		{Table: "swap_fill_txs", Name: "swap_fill_tx_direction_enum", Column: "direction", Values: directions},
+	}
+}
+
+// CaseSensitiveColumn returns the column compared case sensitively, as the registries are. The default collations
+// of mysql ignore the case, so "SENT_SUCCESS" would pass a check against "sent_success", the comparison is binary
+// there.
+func CaseSensitiveColumn(dialect, column string) string {
+	if dialect == common.DBDialectMysql {
+		return "BINARY " + column
+	}
+	return column
+}
+
+// Check returns the condition of the constraint on the dialect
+func (constraint EnumConstraint) Check(dialect string) string {
+	return fmt.Sprintf("%s in ('%s')", CaseSensitiveColumn(dialect, constraint.Column), strings.Join(constraint.Values, "', '"))
+}
+
+// Version returns the data migration recording the constraint with its values on the dialect, it changes once a
+// value is added to the registry
+func (constraint EnumConstraint) Version(dialect string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(constraint.Check(dialect)))
+	return fmt.Sprintf("enum_constraint_%s_%08x", constraint.Name, hash.Sum32())
+}
+
+// ReplaceEnumConstraint drops the constraint, if any, and adds it with the values of the registry. sqlite can't
+// alter the constraints of a table, the inserts are still validated by the models. Like the address constraints the
+// postgres one is not validated against the existing rows, and it is replaced in a tx so the column is never left
+// unchecked.
+func ReplaceEnumConstraint(db *gorm.DB, constraint EnumConstraint) error {
+	dialect := db.Dialect().GetName()
+	if dialect == common.DBDialectSqlite3 {
+		return nil
+	}
+	if dialect == common.DBDialectPostgres {
+		tx := db.Begin()
+		if err := tx.Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s", constraint.Table, constraint.Name)).Error; err != nil {
+			tx.Rollback()
+			return fmt.Errorf("drop constraint %s error: %s", constraint.Name, err.Error())
+		}
+		if err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s CHECK (%s) NOT VALID", constraint.Table, constraint.Name, constraint.Check(dialect))).Error; err != nil {
+			tx.Rollback()
+			return fmt.Errorf("add constraint %s error: %s", constraint.Name, err.Error())
+		}
+		return tx.Commit().Error
+	}
+	// mysql has no ddl tx, the missing constraint fails the drop
+	db.Exec(fmt.Sprintf("ALTER TABLE %s DROP CHECK %s", constraint.Table, constraint.Name))
+	if err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s CHECK (%s)", constraint.Table, constraint.Name, constraint.Check(dialect))).Error; err != nil {
+		return fmt.Errorf("add constraint %s error: %s", constraint.Name, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package model
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// Incident is the note shown on the public status page, only the latest one is active. A new note soft deletes the
+// former ones, so the history is kept.
+type Incident struct {
+	gorm.Model
+	Note     string `gorm:"type:text"`
+	Operator string
+}
+
+func (Incident) TableName() string {
+	return "incidents"
+}
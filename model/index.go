@@ -0,0 +1,69 @@
+package model
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// Index is a composite index of the daemon queries, the equality columns come first and the range or order column
+// last, the same way the queries filter
+type Index struct {
+	Table   string
+	Name    string
+	Columns []string
+}
+
+// DaemonIndexes are the indexes of the hot daemon queries, the daemons poll them all the time so they must never
+// scan the tables
+var DaemonIndexes = []Index{
+	// the observers, confirming the swap start txs
+	{Table: "swap_start_txs", Name: "swap_start_tx_log_chain_status_confirmed_num", Columns: []string{"chain", "status", "confirmed_num"}},
+	// monitorSwapRequestDaemon
+	{Table: "swap_start_txs", Name: "swap_start_tx_log_phase_height", Columns: []string{"phase", "height"}},
+	// confirmSwapRequestDaemon
+	{Table: "swap_start_txs", Name: "swap_start_tx_log_status_phase_height", Columns: []string{"status", "phase", "height"}},
+	// the watchdog, finding the unacked swap start txs
+	{Table: "swap_start_txs", Name: "swap_start_tx_log_phase_update_time", Columns: []string{"phase", "update_time"}},
+	// the swap and liquidity daemons, filling and holding the swaps of the destination chain
+	{Table: "swaps", Name: "swap_status_direction", Columns: []string{"status", "direction"}},
+	// releaseDelayedSwapsDaemon
+	{Table: "swaps", Name: "swap_status_delayed_until", Columns: []string{"status", "delayed_until"}},
+	// autoRetryFailedSwapsDaemon
+	{Table: "swaps", Name: "swap_status_next_retry_at", Columns: []string{"status", "next_retry_at"}},
+	// the watchdog and the archive daemon, finding the old swaps of a status
+	{Table: "swaps", Name: "swap_status_updated_at", Columns: []string{"status", "updated_at"}},
+	// trackSwapTxDaemon
+	{Table: "swap_fill_txs", Name: "swap_fill_tx_status_direction_track_retry_counter", Columns: []string{"status", "direction", "track_retry_counter"}},
+	// trackDroppedSwapTxDaemon
+	{Table: "swap_fill_txs", Name: "swap_fill_tx_status_direction_broadcast_time", Columns: []string{"status", "direction", "broadcast_time"}},
+	// the watchdog, finding the fill txs which are never sent
+	{Table: "swap_fill_txs", Name: "swap_fill_tx_status_created_at", Columns: []string{"status", "created_at"}},
+	// retryFailedSwapsDaemon
+	{Table: "retry_swaps", Name: "retry_swap_status", Columns: []string{"status"}},
+	// trackRetrySwapTxDaemon
+	{Table: "retry_swap_txs", Name: "retry_swap_tx_status_track_retry_counter", Columns: []string{"status", "track_retry_counter"}},
+	// webhookDeliveryDaemon
+	{Table: "webhook_deliveries", Name: "webhook_delivery_status_next_attempt_at", Columns: []string{"status", "next_attempt_at"}},
+	// the drain brake, summing the spend of the relayer accounts within the last hour
+	{Table: "relayer_spends", Name: "relayer_spend_created_at", Columns: []string{"created_at"}},
+	// the observers, finding the latest and the old blocks of a chain
+	{Table: "block_log", Name: "block_log_chain_height", Columns: []string{"chain", "height"}},
+}
+
+// CreateDaemonIndexes creates the daemon indexes which are missing, the existing ones are kept
+func CreateDaemonIndexes(db *gorm.DB) {
+	for _, index := range DaemonIndexes {
+		db.Table(index.Table).AddIndex(index.Name, index.Columns...)
+	}
+}
+
+// MissingDaemonIndexes returns the daemon indexes which are not in the db, e.g. the db user may not be allowed to
+// create them
+func MissingDaemonIndexes(db *gorm.DB) []Index {
+	missing := make([]Index, 0)
+	for _, index := range DaemonIndexes {
+		if !db.Dialect().HasIndex(index.Table, index.Name) {
+			missing = append(missing, index)
+		}
+	}
+	return missing
+}
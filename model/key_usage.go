@@ -0,0 +1,52 @@
+package model
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// the purposes a relayer key signs a tx for
+const (
+	KeyUsageFill      = "fill"
+	KeyUsageRetryFill = "retry_fill"
+	KeyUsageApprove   = "approve"
+	KeyUsageRebalance = "rebalance"
+	KeyUsageRelay     = "relay"
+	KeyUsageDeploy    = "deploy"
+	KeyUsageNoncePlug = "nonce_plug"
+	KeyUsageWithdraw  = "withdraw"
+)
+
+// KeyUsage is the audit record of a tx signed by a relayer key. It is saved before the tx is sent, a tx which is
+// signed but not sent, e.g. replaced by a repriced one, is recorded as well.
+type KeyUsage struct {
+	gorm.Model
+	Purpose string `gorm:"not null;index:key_usage_purpose"`
+	Chain   string `gorm:"not null;index:key_usage_chain"`
+	Account string `gorm:"not null;index:key_usage_account"`
+	TxHash  string `gorm:"not null;index:key_usage_tx_hash"`
+	Nonce   uint64 `gorm:"not null"`
+	// the token and the amount moved by the tx, empty if it moves none
+	Token  string
+	Amount string
+	// the daemon signing the tx and the server instance running it
+	Daemon   string `gorm:"not null"`
+	Instance string `gorm:"not null"`
+}
+
+func (KeyUsage) TableName() string {
+	return "key_usages"
+}
+
+// RelayerNonce is a nonce of a relayer account reserved by a server instance before it signs a tx with the nonce, the
+// replicas share the relayer keys, so a nonce reserved by another instance is never signed or replaced
+type RelayerNonce struct {
+	gorm.Model
+	Chain    string `gorm:"not null;unique_index:relayer_nonce_chain_account_nonce"`
+	Account  string `gorm:"not null;unique_index:relayer_nonce_chain_account_nonce"`
+	Nonce    uint64 `gorm:"not null;unique_index:relayer_nonce_chain_account_nonce"`
+	Instance string `gorm:"not null"`
+}
+
+func (RelayerNonce) TableName() string {
+	return "relayer_nonces"
+}
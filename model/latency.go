@@ -0,0 +1,23 @@
+package model
+
+import (
+	"github.com/jinzhu/gorm"
+
+	"occ-swap-server/common"
+)
+
+// SwapLatencyStat is the rolling percentiles in seconds of a stage of the recent filled swaps of a direction,
+// it is recomputed periodically
+type SwapLatencyStat struct {
+	gorm.Model
+	Direction common.SwapDirection `gorm:"not null;unique_index:swap_latency_stat_direction_stage"`
+	Stage     string               `gorm:"not null;unique_index:swap_latency_stat_direction_stage"`
+	Samples   int                  `gorm:"not null"`
+	P50       int64                `gorm:"not null"`
+	P90       int64                `gorm:"not null"`
+	P99       int64                `gorm:"not null"`
+}
+
+func (SwapLatencyStat) TableName() string {
+	return "swap_latency_stats"
+}
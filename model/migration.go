@@ -0,0 +1,122 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"occ-swap-server/common"
+)
+
+// DataMigration is a data migration applied to the db, every migration runs once
+type DataMigration struct {
+	Name      string `gorm:"primary_key"`
+	AppliedAt int64  `gorm:"not null"`
+}
+
+func (DataMigration) TableName() string {
+	return "data_migrations"
+}
+
+func IsMigrationApplied(db *gorm.DB, name string) bool {
+	return !db.Where("name = ?", name).First(&DataMigration{}).RecordNotFound()
+}
+
+// MarkMigrationApplied records the migration, it is a no-op if another replica has recorded it first
+func MarkMigrationApplied(db *gorm.DB, name string) error {
+	if IsMigrationApplied(db, name) {
+		return nil
+	}
+	return db.Create(&DataMigration{Name: name, AppliedAt: time.Now().Unix()}).Error
+}
+
+// Constraint is a check constraint of a table
+type Constraint struct {
+	Table string
+	Name  string
+	Check string
+}
+
+var notZeroAddress = fmt.Sprintf("<> '%s'", ZeroAddress)
+
+// AddressConstraints reject the empty and zero addresses and tx hashes of the required columns, and the zero
+// addresses of the optional ones
+var AddressConstraints = []Constraint{
+	{Table: "swaps", Name: "swap_sponsor_valid", Check: "sponsor <> '' and sponsor " + notZeroAddress},
+	{Table: "swaps", Name: "swap_bep20_addr_valid", Check: "bep20_addr " + notZeroAddress},
+	{Table: "swaps", Name: "swap_erc20_addr_valid", Check: "erc20_addr " + notZeroAddress},
+	{Table: "swaps", Name: "swap_start_tx_hash_valid", Check: "start_tx_hash <> ''"},
+	{Table: "retry_swaps", Name: "retry_swap_sponsor_valid", Check: "sponsor <> '' and sponsor " + notZeroAddress},
+	{Table: "retry_swaps", Name: "retry_swap_bep20_addr_valid", Check: "bep20_addr " + notZeroAddress},
+	{Table: "retry_swaps", Name: "retry_swap_erc20_addr_valid", Check: "erc20_addr " + notZeroAddress},
+	{Table: "retry_swaps", Name: "retry_swap_start_tx_hash_valid", Check: "start_tx_hash <> ''"},
+	{Table: "swap_start_txs", Name: "swap_start_tx_from_address_valid", Check: "from_address <> '' and from_address " + notZeroAddress},
+	{Table: "swap_start_txs", Name: "swap_start_tx_token_addr_valid", Check: "token_addr " + notZeroAddress},
+	{Table: "swap_start_txs", Name: "swap_start_tx_tx_hash_valid", Check: "tx_hash <> ''"},
+	{Table: "swap_fill_txs", Name: "swap_fill_tx_fill_swap_tx_hash_valid", Check: "fill_swap_tx_hash <> ''"},
+	{Table: "retry_swap_txs", Name: "retry_swap_tx_retry_fill_swap_tx_hash_valid", Check: "retry_fill_swap_tx_hash <> ''"},
+	{Table: "swap_pairs", Name: "swap_pair_bep20_addr_valid", Check: "bep20_addr <> '' and bep20_addr " + notZeroAddress},
+	{Table: "swap_pairs", Name: "swap_pair_erc20_addr_valid", Check: "erc20_addr <> '' and erc20_addr " + notZeroAddress},
+}
+
+// CreateAddressConstraints adds the address constraints, it returns the errors of the constraints which can't be
+// added. sqlite can't add a constraint to an existing table, the inserts are still validated by the models. The
+// postgres constraints are not validated against the existing rows, so the rows the repair leaves don't block them.
+func CreateAddressConstraints(db *gorm.DB) []error {
+	dialect := db.Dialect().GetName()
+	if dialect == common.DBDialectSqlite3 {
+		return nil
+	}
+	errs := make([]error, 0)
+	for _, constraint := range AddressConstraints {
+		stmt := fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s CHECK (%s)", constraint.Table, constraint.Name, constraint.Check)
+		if dialect == common.DBDialectPostgres {
+			stmt += " NOT VALID"
+		}
+		if err := db.Exec(stmt).Error; err != nil {
+			errs = append(errs, fmt.Errorf("add constraint %s error: %s", constraint.Name, err.Error()))
+		}
+	}
+	return errs
+}
+
+// ForeignKey is a foreign key of a table, the rows of the referenced table can't be deleted while they are referenced
+type ForeignKey struct {
+	Table    string
+	Name     string
+	Column   string
+	Ref      string
+	OnDelete string
+}
+
+// ForeignKeys link the fill txs to their swaps by id, the archive moves the fill txs of a swap before the swap
+var ForeignKeys = []ForeignKey{
+	{Table: "swap_fill_txs", Name: "swap_fill_tx_swap_id_fk", Column: "swap_id", Ref: "swaps(id)", OnDelete: "RESTRICT"},
+}
+
+// CreateForeignKeys adds the foreign keys, it returns the errors of the foreign keys which can't be added. Like the
+// address constraints, sqlite can't add them to an existing table and the postgres ones are not validated against
+// the existing rows, e.g. the fill txs of the swaps deleted before. The foreign keys already there are skipped.
+func CreateForeignKeys(db *gorm.DB) []error {
+	dialect := db.Dialect().GetName()
+	if dialect == common.DBDialectSqlite3 {
+		return nil
+	}
+	errs := make([]error, 0)
+	for _, fk := range ForeignKeys {
+		// the foreign keys added by an earlier run whose migration is not marked applied
+		if db.Dialect().HasForeignKey(fk.Table, fk.Name) {
+			continue
+		}
+		stmt := fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s ON DELETE %s ON UPDATE RESTRICT",
+			fk.Table, fk.Name, fk.Column, fk.Ref, fk.OnDelete)
+		if dialect == common.DBDialectPostgres {
+			stmt += " NOT VALID"
+		}
+		if err := db.Exec(stmt).Error; err != nil {
+			errs = append(errs, fmt.Errorf("add foreign key %s error: %s", fk.Name, err.Error()))
+		}
+	}
+	return errs
+}
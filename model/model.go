@@ -62,4 +62,34 @@ func InitTables(db *gorm.DB) {
 	db.AutoMigrate(&SwapPairStateMachine{})
 	db.AutoMigrate(&RetrySwap{})
 	db.AutoMigrate(&RetrySwapTx{})
+	db.AutoMigrate(&PairOwner{})
+	db.AutoMigrate(&PausedPair{})
+	db.AutoMigrate(&PairFillMethod{})
+	db.AutoMigrate(&Webhook{})
+	db.AutoMigrate(&WebhookDelivery{})
+	db.AutoMigrate(&SwapProof{})
+	db.AutoMigrate(&SwapLatencyStat{})
+	db.AutoMigrate(&Incident{})
+	db.AutoMigrate(&ArchivedSwap{})
+	db.AutoMigrate(&ArchivedSwapStartTxLog{})
+	db.AutoMigrate(&ArchivedSwapFillTx{})
+	db.AutoMigrate(&RelayedSwap{})
+	db.AutoMigrate(&QuarantinedSwap{})
+	db.AutoMigrate(&AdminUser{})
+	db.AutoMigrate(&AdminRequestNonce{})
+	db.AutoMigrate(&SponsorChallenge{})
+	db.AutoMigrate(&PeggedTokenDeployment{})
+	db.AutoMigrate(&DataMigration{})
+	db.AutoMigrate(&SponsorTier{})
+	db.AutoMigrate(&PairMigration{})
+	db.AutoMigrate(&RebalancePlan{})
+	db.AutoMigrate(&SwapRollup{})
+	db.AutoMigrate(&BridgeEvent{})
+	db.AutoMigrate(&KeyUsage{})
+	db.AutoMigrate(&RelayerNonce{})
+	db.AutoMigrate(&DrainBrakeHalt{})
+	db.AutoMigrate(&RelayerSpend{})
+	db.AutoMigrate(&WithdrawalLock{})
+	CreateDaemonIndexes(db)
+	CreateWithdrawalLocks(db)
 }
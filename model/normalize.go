@@ -0,0 +1,195 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	ZeroAddress = "0x0000000000000000000000000000000000000000"
+	ZeroTxHash  = "0x0000000000000000000000000000000000000000000000000000000000000000"
+)
+
+// NormalizeAddress returns the checksummed address, it fails if the address is invalid or zero
+func NormalizeAddress(name, addr string) (string, error) {
+	addr = strings.TrimSpace(addr)
+	if !ethcom.IsHexAddress(addr) {
+		return "", fmt.Errorf("invalid %s: %q", name, addr)
+	}
+	address := ethcom.HexToAddress(addr)
+	if address == (ethcom.Address{}) {
+		return "", fmt.Errorf("%s should not be the zero address", name)
+	}
+	return address.String(), nil
+}
+
+// NormalizeOptionalAddress is NormalizeAddress, except the empty address is kept
+func NormalizeOptionalAddress(name, addr string) (string, error) {
+	if strings.TrimSpace(addr) == "" {
+		return "", nil
+	}
+	return NormalizeAddress(name, addr)
+}
+
+// OptionalAddress is the address saved in an optional address column, the zero address is left empty
+func OptionalAddress(addr ethcom.Address) string {
+	if addr == (ethcom.Address{}) {
+		return ""
+	}
+	return addr.String()
+}
+
+// NormalizeTxHash returns the lowercase tx hash, it fails if the hash is not 32 bytes of hex or zero
+func NormalizeTxHash(name, hash string) (string, error) {
+	hash = strings.TrimSpace(hash)
+	hex := strings.TrimPrefix(strings.TrimPrefix(hash, "0x"), "0X")
+	if len(hex) != 2*ethcom.HashLength || len(hex) == len(hash) || !isHex(hex) {
+		return "", fmt.Errorf("invalid %s: %q", name, hash)
+	}
+	normalized := "0x" + strings.ToLower(hex)
+	if normalized == ZeroTxHash {
+		return "", fmt.Errorf("%s should not be the zero hash", name)
+	}
+	return normalized, nil
+}
+
+// NormalizeOptionalTxHash is NormalizeTxHash, except the empty hash is kept
+func NormalizeOptionalTxHash(name, hash string) (string, error) {
+	if strings.TrimSpace(hash) == "" {
+		return "", nil
+	}
+	return NormalizeTxHash(name, hash)
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizer normalizes the fields of a row one by one and keeps the first error
+type normalizer struct {
+	err error
+}
+
+func (n *normalizer) address(name string, field *string, optional bool) {
+	if n.err != nil {
+		return
+	}
+	normalize := NormalizeAddress
+	if optional {
+		normalize = NormalizeOptionalAddress
+	}
+	*field, n.err = normalizeField(normalize, name, *field)
+}
+
+func (n *normalizer) txHash(name string, field *string, optional bool) {
+	if n.err != nil {
+		return
+	}
+	normalize := NormalizeTxHash
+	if optional {
+		normalize = NormalizeOptionalTxHash
+	}
+	*field, n.err = normalizeField(normalize, name, *field)
+}
+
+// normalizeField keeps the field as it is if it is invalid
+func normalizeField(normalize func(name, value string) (string, error), name, value string) (string, error) {
+	normalized, err := normalize(name, value)
+	if err != nil {
+		return value, err
+	}
+	return normalized, nil
+}
+
+// Normalize checksums the addresses and lowercases the tx hashes of the swap, the token addresses of the fungible
+// swaps are empty. The engine normalizes the swap before computing its record hash.
+func (swap *Swap) Normalize() error {
+	n := &normalizer{}
+	n.address("sponsor", &swap.Sponsor, false)
+	n.address("recipient", &swap.Recipient, true)
+	n.address("bep20 address", &swap.BEP20Addr, true)
+	n.address("erc20 address", &swap.ERC20Addr, true)
+	n.txHash("start tx hash", &swap.StartTxHash, false)
+	n.txHash("fill tx hash", &swap.FillTxHash, true)
+	return n.err
+}
+
+// BeforeCreate rejects the swaps with an invalid address or tx hash, or an unknown status or direction
+func (swap *Swap) BeforeCreate() error {
+	if err := swap.Normalize(); err != nil {
+		return err
+	}
+	return swap.CheckEnums()
+}
+
+func (retrySwap *RetrySwap) Normalize() error {
+	n := &normalizer{}
+	n.address("sponsor", &retrySwap.Sponsor, false)
+	n.address("recipient", &retrySwap.Recipient, true)
+	n.address("bep20 address", &retrySwap.BEP20Addr, true)
+	n.address("erc20 address", &retrySwap.ERC20Addr, true)
+	n.txHash("start tx hash", &retrySwap.StartTxHash, false)
+	n.txHash("fill tx hash", &retrySwap.FillTxHash, true)
+	return n.err
+}
+
+func (retrySwap *RetrySwap) BeforeCreate() error {
+	if err := retrySwap.Normalize(); err != nil {
+		return err
+	}
+	return checkDirection(retrySwap.Direction)
+}
+
+func (l *SwapStartTxLog) Normalize() error {
+	n := &normalizer{}
+	n.address("from address", &l.FromAddress, false)
+	n.address("token address", &l.TokenAddr, true)
+	n.address("recipient", &l.Recipient, true)
+	n.txHash("tx hash", &l.TxHash, false)
+	n.txHash("block hash", &l.BlockHash, false)
+	return n.err
+}
+
+func (swapTx *SwapFillTx) Normalize() error {
+	n := &normalizer{}
+	n.txHash("start tx hash", &swapTx.StartSwapTxHash, false)
+	n.txHash("fill tx hash", &swapTx.FillSwapTxHash, false)
+	return n.err
+}
+
+func (swapTx *SwapFillTx) BeforeCreate() error {
+	if err := swapTx.Normalize(); err != nil {
+		return err
+	}
+	return checkDirection(swapTx.Direction)
+}
+
+func (retrySwapTx *RetrySwapTx) Normalize() error {
+	n := &normalizer{}
+	n.txHash("start tx hash", &retrySwapTx.StartTxHash, false)
+	n.txHash("retry fill tx hash", &retrySwapTx.RetryFillSwapTxHash, false)
+	return n.err
+}
+
+func (retrySwapTx *RetrySwapTx) BeforeCreate() error {
+	return retrySwapTx.Normalize()
+}
+
+func (pair *SwapPair) Normalize() error {
+	n := &normalizer{}
+	n.address("sponsor", &pair.Sponsor, true)
+	n.address("bep20 address", &pair.BEP20Addr, false)
+	n.address("erc20 address", &pair.ERC20Addr, false)
+	return n.err
+}
+
+func (pair *SwapPair) BeforeCreate() error {
+	return pair.Normalize()
+}
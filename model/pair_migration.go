@@ -0,0 +1,27 @@
+package model
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// PairMigration is the audit record of a swap pair moved to new token contracts. The old pair is soft deleted so its
+// tokens can be kept by the new pair, and the unfilled and the failed swaps of the old pair now fill the new tokens.
+type PairMigration struct {
+	gorm.Model
+	OldPairID    uint   `gorm:"not null;index:pair_migration_old_pair_id"`
+	NewPairID    uint   `gorm:"not null;index:pair_migration_new_pair_id"`
+	Symbol       string `gorm:"not null"`
+	OldBEP20Addr string `gorm:"not null"`
+	OldERC20Addr string `gorm:"not null;index:pair_migration_old_erc20_addr"`
+	NewBEP20Addr string `gorm:"not null"`
+	NewERC20Addr string `gorm:"not null;index:pair_migration_new_erc20_addr"`
+	// the swaps mapped to the new tokens, their start tx hashes are saved as a json array
+	MigratedSwaps int64  `gorm:"not null;default:0"`
+	StartTxHashes string `gorm:"type:text"`
+	Reason        string
+	Operator      string `gorm:"not null"`
+}
+
+func (PairMigration) TableName() string {
+	return "pair_migrations"
+}
@@ -0,0 +1,43 @@
+package model
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+type PeggedTokenStatus string
+
+const (
+	PeggedTokenPending   PeggedTokenStatus = "pending"
+	PeggedTokenDeploying PeggedTokenStatus = "deploying"
+	PeggedTokenDeployed  PeggedTokenStatus = "deployed"
+	PeggedTokenFailed    PeggedTokenStatus = "failed"
+)
+
+// PeggedTokenDeployment is a swap pair registered before its token on Chain exists. The deployer, a relayer of the
+// chain, deploys the pegged token of SourceToken with the factory, and the swap pair is saved with Token once the
+// deploy tx is confirmed.
+type PeggedTokenDeployment struct {
+	gorm.Model
+	Chain       string `gorm:"not null"`
+	SourceToken string `gorm:"not null;index:pegged_token_source_token"`
+	Deployer    string `gorm:"not null"`
+
+	// fields of the swap pair
+	Sponsor    string `gorm:"not null"`
+	Symbol     string `gorm:"not null"`
+	Name       string `gorm:"not null"`
+	Decimals   int    `gorm:"not null"`
+	LowBound   string `gorm:"not null"`
+	UpperBound string `gorm:"not null"`
+	IconUrl    string
+	Available  bool `gorm:"not null"`
+
+	Status   PeggedTokenStatus `gorm:"not null;index:pegged_token_status"`
+	TxHash   string
+	Token    string
+	ErrorMsg string
+}
+
+func (PeggedTokenDeployment) TableName() string {
+	return "pegged_token_deployments"
+}
@@ -0,0 +1,32 @@
+package model
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// SwapProof is the receipt proof of the SwapStarted event of a filled swap. The receipt at TxIndex of the block
+// is proven against the receipts root of the header by the trie nodes of Proof, so a dispute can be resolved
+// from the record alone. Verified is false if the receipts of the block returned by the node don't match the
+// receipts root, the header and the receipt are kept anyway.
+type SwapProof struct {
+	gorm.Model
+	StartTxHash  string `gorm:"not null;unique_index:swap_proof_start_tx_hash"`
+	Chain        string `gorm:"not null"`
+	BlockHash    string `gorm:"not null"`
+	Height       int64  `gorm:"not null"`
+	ReceiptsRoot string `gorm:"not null"`
+	// rlp encoded header and receipt in hex
+	Header  string `gorm:"type:text"`
+	Receipt string `gorm:"type:text"`
+	TxIndex uint
+	// index of the SwapStarted event in the logs of the receipt
+	LogIndex uint
+	// json array of the hex encoded trie nodes from the receipts root to the receipt
+	Proof    string `gorm:"type:text"`
+	Verified bool
+	Error    string
+}
+
+func (SwapProof) TableName() string {
+	return "swap_proofs"
+}
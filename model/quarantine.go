@@ -0,0 +1,37 @@
+package model
+
+import (
+	"github.com/jinzhu/gorm"
+
+	"occ-swap-server/common"
+)
+
+type IntegrityIssue string
+
+const (
+	// IntegrityTampered swaps have a record hash which doesn't match their fields
+	IntegrityTampered IntegrityIssue = "tampered"
+	// IntegrityLegacy swaps have no record hash, they are written before the record hashes
+	IntegrityLegacy IntegrityIssue = "legacy"
+)
+
+// QuarantinedSwap is a swap which fails the integrity sweep, it is kept for the operators to review. The swap is
+// soft deleted from the swaps table, so no daemon touches it, and its fields at the time of the sweep are kept in
+// Snapshot.
+type QuarantinedSwap struct {
+	gorm.Model
+
+	SwapID      uint              `gorm:"not null;index:quarantined_swap_swap_id"`
+	StartTxHash string            `gorm:"not null;index:quarantined_swap_start_tx_hash"`
+	Status      common.SwapStatus `gorm:"not null"`
+	Issue       IntegrityIssue    `gorm:"not null"`
+	// json of the swap row
+	Snapshot string `gorm:"type:text"`
+
+	Reviewed   bool `gorm:"not null;default:false"`
+	ReviewNote string
+}
+
+func (QuarantinedSwap) TableName() string {
+	return "quarantined_swaps"
+}
@@ -0,0 +1,33 @@
+package model
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+type RebalancePlanStatus string
+
+const (
+	RebalancePlanPending   RebalancePlanStatus = "pending"
+	RebalancePlanApproved  RebalancePlanStatus = "approved"
+	RebalancePlanExecuting RebalancePlanStatus = "executing"
+	RebalancePlanExecuted  RebalancePlanStatus = "executed"
+	RebalancePlanRejected  RebalancePlanStatus = "rejected"
+	RebalancePlanFailed    RebalancePlanStatus = "failed"
+)
+
+// RebalancePlan is the transfers of the bridged token between the swap agents planned from the inventory of the
+// chains, it is executed once it is approved by another operator than the proposer. The transfers and the inventory
+// the plan is computed from are saved as json.
+type RebalancePlan struct {
+	gorm.Model
+	Status    RebalancePlanStatus `gorm:"not null;index:rebalance_plan_status"`
+	Transfers string              `gorm:"type:text;not null"`
+	Inventory string              `gorm:"type:text;not null"`
+	Proposer  string              `gorm:"not null"`
+	Approver  string
+	ErrorMsg  string
+}
+
+func (RebalancePlan) TableName() string {
+	return "rebalance_plans"
+}
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+type RelayedSwapStatus string
+
+const (
+	RelayedSwapPending    RelayedSwapStatus = "pending"
+	RelayedSwapPermitting RelayedSwapStatus = "permitting"
+	RelayedSwapPulling    RelayedSwapStatus = "pulling"
+	RelayedSwapApproving  RelayedSwapStatus = "approving"
+	RelayedSwapStarting   RelayedSwapStatus = "starting"
+	RelayedSwapStarted    RelayedSwapStatus = "started"
+	RelayedSwapFailed     RelayedSwapStatus = "failed"
+)
+
+// RelayedSwap is a swap started by a relayer on behalf of the owner of the tokens, with the EIP-2612 permit signed
+// by the owner for the relayer. The relayer sends the permit, pulls the tokens with transferFrom, approves the swap
+// agent and starts the swap, each tx is sent once the former one is mined. The swap of StartTxHash is filled to
+// the owner.
+type RelayedSwap struct {
+	gorm.Model
+	Chain     string `gorm:"not null"`
+	Owner     string `gorm:"not null;index:relayed_swap_owner"`
+	Spender   string `gorm:"not null"`
+	Token     string `gorm:"not null"`
+	ToChainId string `gorm:"not null"`
+	Amount    string `gorm:"not null"`
+	// the part of the amount kept by the relayer, the swap is started with the rest
+	Fee      string `gorm:"not null;default:'0'"`
+	Deadline int64  `gorm:"not null"`
+	// hex encoded 65 bytes signature of the permit, r, s and v
+	Signature string `gorm:"not null"`
+
+	Status        RelayedSwapStatus `gorm:"not null;index:relayed_swap_status"`
+	PermitTxHash  string
+	PullTxHash    string
+	ApproveTxHash string
+	StartTxHash   string `gorm:"index:relayed_swap_start_tx_hash"`
+	ErrorMsg      string
+	// the ip the permit is submitted from, the relayed swaps of an ip are rate limited
+	RemoteIP string `gorm:"not null;default:'';index:relayed_swap_remote_ip"`
+}
+
+func (RelayedSwap) TableName() string {
+	return "relayed_swaps"
+}
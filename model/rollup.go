@@ -0,0 +1,38 @@
+package model
+
+import (
+	"github.com/jinzhu/gorm"
+
+	"occ-swap-server/common"
+)
+
+type RollupPeriod string
+
+const (
+	RollupHour RollupPeriod = "hour"
+	RollupDay  RollupPeriod = "day"
+)
+
+// SwapRollup is the aggregate of the swaps of a pair and a direction created within the hour or the day starting at
+// BucketStart, the amounts are in the smallest unit of the pair
+type SwapRollup struct {
+	gorm.Model
+	Period      RollupPeriod         `gorm:"not null;unique_index:swap_rollup_bucket"`
+	BucketStart int64                `gorm:"not null;unique_index:swap_rollup_bucket"`
+	ERC20Addr   string               `gorm:"not null;unique_index:swap_rollup_bucket"`
+	Direction   common.SwapDirection `gorm:"not null;unique_index:swap_rollup_bucket"`
+	Symbol      string
+
+	SwapCount    int64  `gorm:"not null"`
+	SuccessCount int64  `gorm:"not null"`
+	FailedCount  int64  `gorm:"not null"`
+	TotalAmount  string `gorm:"not null"`
+	FilledAmount string `gorm:"not null"`
+	// seconds from the swaps created to their fill succeeding, summed over the successful swaps
+	LatencySum int64 `gorm:"not null"`
+	AvgLatency int64 `gorm:"not null"`
+}
+
+func (SwapRollup) TableName() string {
+	return "swap_rollups"
+}
@@ -0,0 +1,22 @@
+package model
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// SponsorTier is the deal of a partner sponsor, its swaps are filled before the other swaps by priority and a part
+// of their bridge fee is rebated, the fee is charged by the swap agent so the rebate is owed to the sponsor
+type SponsorTier struct {
+	gorm.Model
+	Sponsor string `gorm:"not null;unique_index:sponsor_tier_sponsor"`
+	Tier    string `gorm:"not null"`
+	// percent of the bridge fee rebated, 100 is zero fee
+	FeeRebatePercent int64 `gorm:"not null;default:0"`
+	// the swaps of a higher priority are filled first
+	Priority int64  `gorm:"not null;default:0"`
+	Operator string `gorm:"not null"`
+}
+
+func (SponsorTier) TableName() string {
+	return "sponsor_tiers"
+}
@@ -17,6 +17,12 @@ type SwapStartTxLog struct {
 	Amount      string `gorm:"not null"`
 	FeeAmount   string `gorm:"not null"`
 	ToChainId   string `gorm:"not null"`
+	// token id of the SwapNFTStarted event, TokenAddr is the collection of the source chain
+	TokenId string `gorm:"not null;default:''"`
+	// the account paid on the destination chain, empty if the event doesn't emit one
+	Recipient string `gorm:"not null;default:''"`
+	// the memo appended to the call data of the swap tx, e.g. the reference of the deposit of an exchange
+	Memo string `gorm:"not null;default:''"`
 
 	Status       TxStatus `gorm:"not null;index:swap_start_tx_log_status"`
 	TxHash       string   `gorm:"not null;index:swap_start_tx_log_tx_hash"`
@@ -37,12 +43,14 @@ func (SwapStartTxLog) TableName() string {
 func (l *SwapStartTxLog) BeforeCreate() (err error) {
 	l.CreateTime = time.Now().Unix()
 	l.UpdateTime = time.Now().Unix()
-	return nil
+	return l.Normalize()
 }
 
 type SwapFillTx struct {
 	gorm.Model
 
+	// swap filled by the tx, a foreign key to swaps
+	SwapID            uint                 `gorm:"not null;default:0;index:swap_fill_tx_swap_id"`
 	Direction         common.SwapDirection `gorm:"not null"`
 	StartSwapTxHash   string               `gorm:"not null;index:swap_fill_tx_start_swap_tx_hash"`
 	FillSwapTxHash    string               `gorm:"not null;index:swap_fill_tx_fill_swap_tx_hash"`
@@ -50,7 +58,17 @@ type SwapFillTx struct {
 	ConsumedFeeAmount string
 	Height            int64
 	Status            FillTxStatus `gorm:"not null"`
+	// gas limit of the fill tx, and the gas estimate it is derived from, the estimate is 0 if the fallback gas limit is used
+	GasLimit          int64 `gorm:"not null;default:0"`
+	GasEstimate       int64 `gorm:"not null;default:0"`
 	TrackRetryCounter int64
+	// revert reason of the failed fill tx
+	RevertReason string
+
+	// hex encoded signed tx, used to rebroadcast the tx if it is dropped by the node
+	RawTx              string `gorm:"type:text"`
+	BroadcastTime      int64
+	RebroadcastCounter int64
 }
 
 func (SwapFillTx) TableName() string {
@@ -71,17 +89,32 @@ type RetrySwap struct {
 	Symbol      string                 `gorm:"not null"`
 	Amount      string                 `gorm:"not null"`
 	Decimals    int                    `gorm:"not null"`
+	AssetType   common.AssetType       `gorm:"not null;default:'fungible'"`
+	TokenId     string                 `gorm:"not null;default:''"`
+	Recipient   string                 `gorm:"not null;default:''"`
 
 	ToChainId string `gorm:"not null;index:retry_swap_tochainid"`
 
 	RecordHash string `gorm:"not null"`
 	ErrorMsg   string
+
+	// the executor replica filling the retry swap, and the unix time its claim expires, other replicas skip it until then
+	ClaimedBy    string `gorm:"not null;default:''"`
+	ClaimedUntil int64  `gorm:"not null;default:0"`
 }
 
 func (RetrySwap) TableName() string {
 	return "retry_swaps"
 }
 
+// GetRecipient returns the account the retry fill pays, see Swap.GetRecipient
+func (retrySwap *RetrySwap) GetRecipient() string {
+	if retrySwap.Recipient != "" {
+		return retrySwap.Recipient
+	}
+	return retrySwap.Sponsor
+}
+
 type RetrySwapTx struct {
 	gorm.Model
 
@@ -95,6 +128,10 @@ type RetrySwapTx struct {
 	GasPrice            string
 	ConsumedFeeAmount   string
 	Height              int64
+	RevertReason        string
+	// gas limit of the retry fill tx, and its gas estimate, the estimate is 0 if the fallback gas limit is used
+	GasLimit    int64 `gorm:"not null;default:0"`
+	GasEstimate int64 `gorm:"not null;default:0"`
 }
 
 func (RetrySwapTx) TableName() string {
@@ -108,6 +145,10 @@ type Swap struct {
 	// the user addreess who start this swap
 	Sponsor   string `gorm:"not null;index:swap_sponsor"`
 	ToChainId string `gorm:"not null;index:swap_tochainid"`
+	// the account the fill pays if the start event names one other than the sponsor, empty otherwise
+	Recipient string `gorm:"not null;default:''"`
+	// the memo of the swap tx, the sponsors reconcile their deposits by it
+	Memo string `gorm:"not null;default:''"`
 
 	BEP20Addr string `gorm:"not null;index:swap_bep20_addr"`
 	ERC20Addr string `gorm:"not null;index:swap_erc20_addr"`
@@ -115,6 +156,12 @@ type Swap struct {
 	Amount    string               `gorm:"not null;index:swap_amount"`
 	Decimals  int                  `gorm:"not null"`
 	Direction common.SwapDirection `gorm:"not null;index:swap_direction"`
+	// the amount in the unit of the token, see FormatAmount, it is derived from Amount and Decimals so it is not in
+	// the record hash. It is kept as text, a numeric column rounds the amounts of 18 decimals on sqlite.
+	AmountDecimal string `gorm:"not null;default:''"`
+	// the erc721 swaps fill the token of TokenId, their amount is 1
+	AssetType common.AssetType `gorm:"not null;default:'fungible'"`
+	TokenId   string           `gorm:"not null;default:''"`
 
 	// The tx hash confirmed deposit
 	StartTxHash string `gorm:"not null;index:swap_start_tx_hash"`
@@ -123,6 +170,27 @@ type Swap struct {
 
 	// used to log more message about how this swap failed or invalid
 	Log string
+	// revert reason of the failed fill tx
+	RevertReason string
+	// unix time after which a delayed swap is eligible for fill
+	DelayedUntil int64
+	// the failure class of the last failed fill tx and its category, and the auto retries of the failed swap
+	FailureClass    common.FailureClass
+	FailureCategory common.FailureCategory `gorm:"not null;default:''"`
+	RetryAttempts   int64
+	// unix time of the next auto retry, 0 if the swap is not retried automatically
+	NextRetryAt int64 `gorm:"index:swap_next_retry_at"`
+	// set by the operators to fill the swap whose bridge fee doesn't cover the fill gas
+	FillOverride bool `gorm:"not null;default:false"`
+	// the executor replica filling the swap, and the unix time its claim expires, other replicas skip the swap until then
+	ClaimedBy    string `gorm:"not null;default:''"`
+	ClaimedUntil int64  `gorm:"not null;default:0"`
+	// the priority of the sponsor tier, and the bridge fee owed back to the sponsor in wei of the source chain,
+	// they follow the tier so they are not in the record hash
+	Priority  int64  `gorm:"not null;default:0"`
+	FeeRebate string `gorm:"not null;default:''"`
+	// the previous bridge deployment the swap is imported from, empty if the swap is started on this one
+	ImportedFrom string `gorm:"not null;default:''"`
 
 	RecordHash string `gorm:"not null"`
 }
@@ -130,3 +198,11 @@ type Swap struct {
 func (Swap) TableName() string {
 	return "swaps"
 }
+
+// GetRecipient returns the account the fill of the swap pays, the sponsor unless the start event names a recipient
+func (swap *Swap) GetRecipient() string {
+	if swap.Recipient != "" {
+		return swap.Recipient
+	}
+	return swap.Sponsor
+}
@@ -20,6 +20,10 @@ type SwapPair struct {
 	LowBound   string `gorm:"not null"`
 	UpperBound string `gorm:"not null"`
 	IconUrl    string
+	// the collections of the erc721 pairs are BEP20Addr on BSC and ERC20Addr on the other chains, their swaps are
+	// only filled while NFTEnabled is set
+	AssetType  common.AssetType `gorm:"not null;default:'fungible'"`
+	NFTEnabled bool             `gorm:"not null;default:false"`
 
 	RecordHash string `gorm:"not null"`
 }
@@ -28,6 +32,49 @@ func (SwapPair) TableName() string {
 	return "swap_pairs"
 }
 
+// PairOwner grants the holder of the scoped api key the right to manage the swap pair of the erc20 address,
+// all the rows of the same api key share the same secret
+type PairOwner struct {
+	gorm.Model
+	Name      string `gorm:"not null"`
+	ApiKey    string `gorm:"not null;unique_index:pair_owner_api_key_erc20_addr"`
+	ApiSecret string `gorm:"not null"`
+	ERC20Addr string `gorm:"not null;unique_index:pair_owner_api_key_erc20_addr"`
+}
+
+func (PairOwner) TableName() string {
+	return "pair_owners"
+}
+
+// PausedPair stops filling the swaps of the erc20 address, the pairs paused by the anomaly detector are only
+// resumed by the admin
+type PausedPair struct {
+	gorm.Model
+	ERC20Addr string `gorm:"not null;unique_index:paused_pair_erc20_addr"`
+	Reason    string
+	Operator  string `gorm:"not null"`
+}
+
+func (PausedPair) TableName() string {
+	return "paused_pairs"
+}
+
+// PairFillMethod replaces the fillSwap call of the swap agent of the chain for the swaps of the pair of the erc20
+// address, e.g. an agent minting or unlocking the token. Abi is the json abi of Method, Args names the value passed
+// as each argument of the method in order, comma separated.
+type PairFillMethod struct {
+	gorm.Model
+	ERC20Addr string `gorm:"not null;unique_index:pair_fill_method_erc20_addr_chain"`
+	Chain     string `gorm:"not null;unique_index:pair_fill_method_erc20_addr_chain"`
+	Method    string `gorm:"not null"`
+	Abi       string `gorm:"not null"`
+	Args      string `gorm:"not null"`
+}
+
+func (PairFillMethod) TableName() string {
+	return "pair_fill_methods"
+}
+
 type SwapPairRegisterTxLog struct {
 	Id    int64
 	Chain string `gorm:"not null;index:swappair_register_tx_log_chain"`
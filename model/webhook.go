@@ -0,0 +1,49 @@
+package model
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending WebhookDeliveryStatus = "pending"
+	WebhookDeliverySuccess WebhookDeliveryStatus = "success"
+	WebhookDeliveryFailed  WebhookDeliveryStatus = "failed"
+)
+
+// Webhook is a callback url of an integrator, it is called when a swap of the sponsor, or of the pairs owned by
+// the api key, succeeds or fails. Empty Sponsor matches all the sponsors, empty ApiKey is registered by the admin
+// or by the sponsor itself.
+type Webhook struct {
+	gorm.Model
+	Url     string `gorm:"not null"`
+	Secret  string `gorm:"not null"`
+	Sponsor string `gorm:"not null;index:webhook_sponsor"`
+	ApiKey  string `gorm:"not null;index:webhook_api_key"`
+	// set by the sponsor with a signed challenge, a sponsor has at most one
+	SelfService bool `gorm:"not null;default:false"`
+}
+
+func (Webhook) TableName() string {
+	return "webhooks"
+}
+
+// WebhookDelivery is a call of a webhook, the payload is the snapshot of the swap when the call is queued
+type WebhookDelivery struct {
+	gorm.Model
+	WebhookID     uint                  `gorm:"not null;index:webhook_delivery_webhook_id"`
+	SwapID        uint                  `gorm:"not null"`
+	StartTxHash   string                `gorm:"not null;index:webhook_delivery_start_tx_hash"`
+	Event         string                `gorm:"not null"`
+	Payload       string                `gorm:"type:text"`
+	Status        WebhookDeliveryStatus `gorm:"not null;index:webhook_delivery_status"`
+	Attempts      int64
+	NextAttemptAt int64 `gorm:"index:webhook_delivery_next_attempt_at"`
+	ResponseCode  int
+	LastError     string
+}
+
+func (WebhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
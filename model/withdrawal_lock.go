@@ -0,0 +1,29 @@
+package model
+
+import (
+	"github.com/jinzhu/gorm"
+
+	"occ-swap-server/common"
+)
+
+// WithdrawalLock is the row of a chain locked by the replicas while they check the daily withdrawal limit of the
+// chain against the fills in db, so two replicas never both take the last of the allowance
+type WithdrawalLock struct {
+	gorm.Model
+	Chain string `gorm:"not null;unique_index:withdrawal_lock_chain"`
+	// unix nanoseconds of the last lock, the update of the row takes the lock
+	LockedAt int64 `gorm:"not null;default:0"`
+}
+
+func (WithdrawalLock) TableName() string {
+	return "withdrawal_locks"
+}
+
+// CreateWithdrawalLocks creates the lock rows of the chains, the rows must exist before the txs lock them, an insert
+// failing in the tx would abort it on postgres
+func CreateWithdrawalLocks(db *gorm.DB) {
+	for _, chain := range []string{common.ChainBSC, common.ChainETH, common.ChainMATIC} {
+		// another replica may create the row at the same time, the unique index keeps one
+		db.Where(WithdrawalLock{Chain: chain}).FirstOrCreate(&WithdrawalLock{})
+	}
+}
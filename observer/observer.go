@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"time"
 
+	ethcom "github.com/ethereum/go-ethereum/common"
 	"github.com/jinzhu/gorm"
 
 	"occ-swap-server/common"
@@ -20,6 +21,15 @@ type Observer struct {
 
 	Config   *util.Config
 	Executor executor.Executor
+	// queue the confirmed swap start events are pushed to, nil if it is disabled
+	Queue util.Queue
+	// runs the routines of the observer
+	Scheduler *util.Scheduler
+
+	// latest height of the chain last queried, the blocks confirmed by then are fetched by ranges
+	latestHeight int64
+	// whether the last block fetched alone was not produced yet, the latest height isn't queried again meanwhile
+	atHead bool
 }
 
 // NewObserver returns the observer instance
@@ -32,49 +42,83 @@ func NewObserver(db *gorm.DB, startHeight, confirmNum int64, cfg *util.Config, e
 
 		Config:   cfg,
 		Executor: executor,
+		Queue:    util.NewQueue(cfg.QueueConfig),
+
+		Scheduler: util.NewScheduler(cfg.SchedulerConfig),
 	}
 }
 
 // Start starts the routines of observer
 func (ob *Observer) Start() {
-	go ob.Fetch(ob.StartHeight)
-	go ob.Prune()
-	go ob.Alert()
+	chain := ob.Executor.GetChainName()
+	ob.Scheduler.Go(util.Daemon{Name: "fetch_" + chain, Interval: ob.fetchInterval(), Run: ob.Fetch})
+	ob.Scheduler.Go(util.Daemon{Name: "prune_" + chain, Interval: common.ObserverPruneInterval, Run: ob.Prune})
+	ob.Scheduler.Go(util.Daemon{Name: "alert_" + chain, Interval: common.ObserverAlertInterval, Run: ob.Alert})
 }
 
-func (ob *Observer) fetchSleep() {
+func (ob *Observer) fetchInterval() time.Duration {
 	if ob.Executor.GetChainName() == common.ChainBSC {
-		time.Sleep(time.Duration(ob.Config.ChainConfig.BSCObserverFetchInterval) * time.Second)
+		return time.Duration(ob.Config.ChainConfig.BSCObserverFetchInterval) * time.Second
 	} else if ob.Executor.GetChainName() == common.ChainETH {
-		time.Sleep(time.Duration(ob.Config.ChainConfig.ETHObserverFetchInterval) * time.Second)
+		return time.Duration(ob.Config.ChainConfig.ETHObserverFetchInterval) * time.Second
 	} else if ob.Executor.GetChainName() == common.ChainMATIC {
-		time.Sleep(time.Duration(ob.Config.ChainConfig.MATICObserverFetchInterval) * time.Second)
+		return time.Duration(ob.Config.ChainConfig.MATICObserverFetchInterval) * time.Second
 	}
-
+	return 0
 }
 
-// Fetch starts the main routine for fetching blocks of BSC
-func (ob *Observer) Fetch(startHeight int64) {
-	for {
-		curBlockLog, err := ob.GetCurrentBlockLog()
-		if err != nil {
-			util.Logger.Errorf("get current block log from db error: %s", err.Error())
-			ob.fetchSleep()
-			continue
-		}
+// Fetch fetches the next block, the blocks are fetched one after another until the head is reached
+func (ob *Observer) Fetch() error {
+	curBlockLog, err := ob.GetCurrentBlockLog()
+	if err != nil {
+		return fmt.Errorf("get current block log from db error: %s", err.Error())
+	}
 
-		nextHeight := curBlockLog.Height + 1
-		if curBlockLog.Height == 0 && startHeight != 0 {
-			nextHeight = startHeight
+	nextHeight := curBlockLog.Height + 1
+	if curBlockLog.Height == 0 && ob.StartHeight != 0 {
+		nextHeight = ob.StartHeight
+	}
+
+	if toHeight := ob.rangeEnd(nextHeight); toHeight > nextHeight {
+		util.Logger.Debugf("fetch %s blocks, height=%d-%d", ob.Executor.GetChainName(), nextHeight, toHeight)
+		if err := ob.fetchRange(curBlockLog.Height, nextHeight, toHeight, curBlockLog.BlockHash); err != nil {
+			return fmt.Errorf("fetch %s blocks error, height=%d-%d, err=%s", ob.Executor.GetChainName(), nextHeight, toHeight, err.Error())
 		}
+		return util.RunAgain
+	}
+
+	util.Logger.Debugf("fetch %s block, height=%d", ob.Executor.GetChainName(), nextHeight)
+	err = ob.fetchBlock(curBlockLog.Height, nextHeight, curBlockLog.BlockHash)
+	if err != nil {
+		// the next block is mostly not produced yet, so the error is not backed off
+		util.Logger.Debugf("fetch %s block error, err=%s", ob.Executor.GetChainName(), err.Error())
+		ob.atHead = true
+		return nil
+	}
+	ob.atHead = false
+	return util.RunAgain
+}
 
-		util.Logger.Debugf("fetch %s block, height=%d", ob.Executor.GetChainName(), nextHeight)
-		err = ob.fetchBlock(curBlockLog.Height, nextHeight, curBlockLog.BlockHash)
+// rangeEnd returns the last block of the range fetched from the next height, the blocks within the confirm number
+// of the head are fetched one by one, so a fork among them is detected at its block
+func (ob *Observer) rangeEnd(nextHeight int64) int64 {
+	logRange := ob.Config.ChainConfig.GetObserverLogRange(ob.Executor.GetChainName())
+	if logRange <= 1 {
+		return nextHeight
+	}
+	if !ob.atHead && nextHeight+ob.ConfirmNum >= ob.latestHeight {
+		latestHeight, err := ob.Executor.GetLatestHeight()
 		if err != nil {
-			util.Logger.Debugf("fetch %s block error, err=%s", ob.Executor.GetChainName(), err.Error())
-			ob.fetchSleep()
+			util.Logger.Debugf("get %s latest height error, err=%s", ob.Executor.GetChainName(), err.Error())
+			return nextHeight
 		}
+		ob.latestHeight = latestHeight
+	}
+	toHeight := ob.latestHeight - ob.ConfirmNum
+	if toHeight > nextHeight+logRange-1 {
+		toHeight = nextHeight + logRange - 1
 	}
+	return toHeight
 }
 
 // fetchBlock fetches the next block of BSC and saves it to database. if the next block hash
@@ -86,32 +130,46 @@ func (ob *Observer) fetchBlock(curHeight, nextHeight int64, curBlockHash string)
 	}
 
 	parentHash := blockAndEventLogs.ParentBlockHash
-	if curHeight != 0 && ((parentHash == curBlockHash && blockAndEventLogs.Chain == "ETH") || (parentHash != curBlockHash && blockAndEventLogs.Chain == "BSC")) {
+	if curHeight != 0 && parentHash != curBlockHash {
 		return ob.DeleteBlockAndTxEvents(curHeight)
-	} else {
-		nextBlockLog := model.BlockLog{
-			BlockHash:  blockAndEventLogs.BlockHash,
-			ParentHash: parentHash,
-			Height:     blockAndEventLogs.Height,
-			BlockTime:  blockAndEventLogs.BlockTime,
-			Chain:      blockAndEventLogs.Chain,
-		}
+	}
+	return ob.saveBlock(blockAndEventLogs)
+}
 
-		err := ob.SaveBlockAndTxEvents(&nextBlockLog, blockAndEventLogs.Events)
-		if err != nil {
-			return err
-		}
+// fetchRange fetches the events of the blocks from the next height to the given height with one eth_getLogs, only
+// the last block of the range is saved as a block log. The range is chained to the current block by the parent
+// hash of its first block, the current block is deleted otherwise, the same as fetchBlock.
+func (ob *Observer) fetchRange(curHeight, nextHeight, toHeight int64, curBlockHash string) error {
+	rangeEventLogs, err := ob.Executor.GetRangeEvents(nextHeight, toHeight)
+	if err != nil {
+		return err
+	}
 
-		err = ob.UpdateSwapStartConfirmedNum(nextBlockLog.Height)
-		if err != nil {
-			return err
-		}
-		err = ob.UpdateSwapPairRegisterConfirmedNum(nextBlockLog.Height)
-		if err != nil {
-			return err
-		}
+	if curHeight != 0 && rangeEventLogs.ParentBlockHash != curBlockHash {
+		return ob.DeleteBlockAndTxEvents(curHeight)
 	}
-	return nil
+	return ob.saveBlock(&rangeEventLogs.BlockAndEventLogs)
+}
+
+func (ob *Observer) saveBlock(blockAndEventLogs *common.BlockAndEventLogs) error {
+	nextBlockLog := model.BlockLog{
+		BlockHash:  blockAndEventLogs.BlockHash,
+		ParentHash: blockAndEventLogs.ParentBlockHash,
+		Height:     blockAndEventLogs.Height,
+		BlockTime:  blockAndEventLogs.BlockTime,
+		Chain:      blockAndEventLogs.Chain,
+	}
+
+	err := ob.SaveBlockAndTxEvents(&nextBlockLog, blockAndEventLogs.Events)
+	if err != nil {
+		return err
+	}
+
+	err = ob.UpdateSwapStartConfirmedNum(nextBlockLog.Height)
+	if err != nil {
+		return err
+	}
+	return ob.UpdateSwapPairRegisterConfirmedNum(nextBlockLog.Height)
 }
 
 // DeleteBlockAndTxEvents deletes the block and txs of the given height
@@ -127,7 +185,7 @@ func (ob *Observer) DeleteBlockAndTxEvents(height int64) error {
 	}
 
 	txEventLogList := make([]model.SwapStartTxLog, 0)
-	ob.DB.Where("chain = ? and height = ? and status = ?", ob.Executor.GetChainName(), height, model.TxStatusInit).Find(&txEventLogList)
+	tx.Where("chain = ? and height = ? and status = ?", ob.Executor.GetChainName(), height, model.TxStatusInit).Find(&txEventLogList)
 	for _, txEventLog := range txEventLogList {
 		if err := tx.Where("start_tx_hash = ?", txEventLog.TxHash).Delete(model.Swap{}).Error; err != nil {
 			tx.Rollback()
@@ -141,7 +199,7 @@ func (ob *Observer) DeleteBlockAndTxEvents(height int64) error {
 	}
 
 	registerLogList := make([]model.SwapPairRegisterTxLog, 0)
-	ob.DB.Where("chain = ? and height = ? and status = ?", ob.Executor.GetChainName(), height, model.TxStatusInit).Find(&registerLogList)
+	tx.Where("chain = ? and height = ? and status = ?", ob.Executor.GetChainName(), height, model.TxStatusInit).Find(&registerLogList)
 	for _, registerLog := range registerLogList {
 		if err := tx.Where("swap_pair_register_tx_hash = ?", registerLog.TxHash).Delete(model.SwapPairStateMachine{}).Error; err != nil {
 			tx.Rollback()
@@ -166,18 +224,97 @@ func (ob *Observer) UpdateSwapStartConfirmedNum(height int64) error {
 		return err
 	}
 
-	err = ob.DB.Model(model.SwapStartTxLog{}).Where("chain = ? and status = ? and confirmed_num >= ?",
-		ob.Executor.GetChainName(), model.TxStatusInit, ob.ConfirmNum).Updates(
-		map[string]interface{}{
-			"status": model.TxStatusConfirmed,
-		}).Error
+	riskyTokens, err := ob.getRiskyTokens()
 	if err != nil {
 		return err
 	}
+	// the swap start txs of the tokens of the risk classes wait for the confirm numbers of their classes
+	tokensByConfirmNum := make(map[int64][]string)
+	riskyTokenAddrs := make([]string, 0, len(riskyTokens))
+	for token, confirmNum := range riskyTokens {
+		tokensByConfirmNum[confirmNum] = append(tokensByConfirmNum[confirmNum], token)
+		riskyTokenAddrs = append(riskyTokenAddrs, token)
+	}
+	scopes := []func(*gorm.DB) *gorm.DB{func(db *gorm.DB) *gorm.DB {
+		db = db.Where("confirmed_num >= ?", ob.ConfirmNum)
+		if len(riskyTokenAddrs) != 0 {
+			db = db.Where("token_addr not in (?)", riskyTokenAddrs)
+		}
+		return db
+	}}
+	for confirmNum, tokens := range tokensByConfirmNum {
+		confirmNum, tokens := confirmNum, tokens
+		scopes = append(scopes, func(db *gorm.DB) *gorm.DB {
+			return db.Where("token_addr in (?) and confirmed_num >= ?", tokens, confirmNum)
+		})
+	}
+
+	for _, scope := range scopes {
+		confirmedTxHashes := make([]string, 0)
+		if ob.Queue != nil {
+			err = ob.DB.Model(model.SwapStartTxLog{}).Where("chain = ? and status = ?", ob.Executor.GetChainName(), model.TxStatusInit).
+				Scopes(scope).Pluck("tx_hash", &confirmedTxHashes).Error
+			if err != nil {
+				return err
+			}
+		}
 
+		err = ob.DB.Model(model.SwapStartTxLog{}).Where("chain = ? and status = ?", ob.Executor.GetChainName(), model.TxStatusInit).
+			Scopes(scope).Updates(
+			map[string]interface{}{
+				"status": model.TxStatusConfirmed,
+			}).Error
+		if err != nil {
+			return err
+		}
+
+		ob.pushConfirmedEvents(confirmedTxHashes)
+	}
 	return nil
 }
 
+// getRiskyTokens returns the confirm numbers of the tokens of the chain whose risk classes wait for more
+// confirmations than the chain, key is the token address on the chain
+func (ob *Observer) getRiskyTokens() (map[string]int64, error) {
+	chain := ob.Executor.GetChainName()
+	riskCfg := ob.Config.RiskClassConfig
+	riskyTokens := make(map[string]int64)
+	for erc20Addr := range riskCfg.Pairs {
+		if confirmNum := riskCfg.GetConfirmNum(erc20Addr, chain, ob.ConfirmNum); confirmNum > ob.ConfirmNum {
+			riskyTokens[ethcom.HexToAddress(erc20Addr).String()] = confirmNum
+		}
+	}
+	if chain != common.ChainBSC || len(riskyTokens) == 0 {
+		return riskyTokens, nil
+	}
+
+	// the tokens of the pairs on bsc are their bep20 addresses
+	erc20Addrs := make([]string, 0, len(riskyTokens))
+	for erc20Addr := range riskyTokens {
+		erc20Addrs = append(erc20Addrs, erc20Addr)
+	}
+	pairs := make([]model.SwapPair, 0)
+	if err := ob.DB.Where("erc20_addr in (?)", erc20Addrs).Find(&pairs).Error; err != nil {
+		return nil, err
+	}
+	bep20Tokens := make(map[string]int64, len(pairs))
+	for _, pair := range pairs {
+		bep20Tokens[ethcom.HexToAddress(pair.BEP20Addr).String()] = riskyTokens[ethcom.HexToAddress(pair.ERC20Addr).String()]
+	}
+	return bep20Tokens, nil
+}
+
+// pushConfirmedEvents pushes the confirmed swap start txs to the queue, the swap engine still finds them in db
+// if the push fails
+func (ob *Observer) pushConfirmedEvents(txHashes []string) {
+	for _, txHash := range txHashes {
+		if err := ob.Queue.Push(fmt.Sprintf("%s:%s", ob.Executor.GetChainName(), txHash)); err != nil {
+			util.Logger.Errorf("push swap start event error, chain %s, tx hash %s, err: %s", ob.Executor.GetChainName(), txHash, err.Error())
+			return
+		}
+	}
+}
+
 func (ob *Observer) UpdateSwapPairRegisterConfirmedNum(height int64) error {
 	err := ob.DB.Model(model.SwapPairRegisterTxLog{}).Where("chain = ? and status = ?", ob.Executor.GetChainName(), model.TxStatusInit).Updates(
 		map[string]interface{}{
@@ -200,21 +337,16 @@ func (ob *Observer) UpdateSwapPairRegisterConfirmedNum(height int64) error {
 }
 
 // Prune prunes the outdated blocks
-func (ob *Observer) Prune() {
-	for {
-		curBlockLog, err := ob.GetCurrentBlockLog()
-		if err != nil {
-			util.Logger.Errorf("get current block log error, err=%s", err.Error())
-			time.Sleep(common.ObserverPruneInterval)
-
-			continue
-		}
-		err = ob.DB.Where("chain = ? and height < ?", ob.Executor.GetChainName(), curBlockLog.Height-common.ObserverMaxBlockNumber).Delete(model.BlockLog{}).Error
-		if err != nil {
-			util.Logger.Infof("prune block logs error, err=%s", err.Error())
-		}
-		time.Sleep(common.ObserverPruneInterval)
+func (ob *Observer) Prune() error {
+	curBlockLog, err := ob.GetCurrentBlockLog()
+	if err != nil {
+		return fmt.Errorf("get current block log error, err=%s", err.Error())
 	}
+	err = ob.DB.Where("chain = ? and height < ?", ob.Executor.GetChainName(), curBlockLog.Height-common.ObserverMaxBlockNumber).Delete(model.BlockLog{}).Error
+	if err != nil {
+		util.Logger.Infof("prune block logs error, err=%s", err.Error())
+	}
+	return nil
 }
 
 func (ob *Observer) SaveBlockAndTxEvents(blockLog *model.BlockLog, packages []interface{}) error {
@@ -248,23 +380,80 @@ func (ob *Observer) GetCurrentBlockLog() (*model.BlockLog, error) {
 }
 
 // Alert sends alerts to tg group if there is no new block fetched in a specific time
-func (ob *Observer) Alert() {
-	for {
-		curOtherChainBlockLog, err := ob.GetCurrentBlockLog()
-		if err != nil {
-			util.Logger.Errorf("get current block log error, err=%s", err.Error())
-			time.Sleep(common.ObserverAlertInterval)
+func (ob *Observer) Alert() error {
+	curOtherChainBlockLog, err := ob.GetCurrentBlockLog()
+	if err != nil {
+		return fmt.Errorf("get current block log error, err=%s", err.Error())
+	}
+	if curOtherChainBlockLog.Height > 0 {
+		if time.Now().Unix()-curOtherChainBlockLog.CreateTime > ob.Config.AlertConfig.BlockUpdateTimeout {
+			msg := fmt.Sprintf("last block fetched at %s, chain=%s, height=%d",
+				time.Unix(curOtherChainBlockLog.CreateTime, 0).String(), ob.Executor.GetChainName(), curOtherChainBlockLog.Height)
+			util.SendTelegramMessage(msg)
+		}
+	}
+	return nil
+}
+
+// Backfill re-scans the given range of already fetched blocks and saves the swap start events
+// which are missing in the db, it returns the number of saved events
+func (ob *Observer) Backfill(fromHeight, toHeight int64) (int, error) {
+	curBlockLog, err := ob.GetCurrentBlockLog()
+	if err != nil {
+		return 0, err
+	}
+	if toHeight > curBlockLog.Height {
+		toHeight = curBlockLog.Height
+	}
 
-			continue
+	riskyTokens, err := ob.getRiskyTokens()
+	if err != nil {
+		return 0, err
+	}
+	saved := 0
+	logRange := ob.Config.ChainConfig.GetObserverLogRange(ob.Executor.GetChainName())
+	for rangeFrom := fromHeight; rangeFrom <= toHeight; rangeFrom += logRange {
+		rangeTo := rangeFrom + logRange - 1
+		if rangeTo > toHeight {
+			rangeTo = toHeight
 		}
-		if curOtherChainBlockLog.Height > 0 {
-			if time.Now().Unix()-curOtherChainBlockLog.CreateTime > ob.Config.AlertConfig.BlockUpdateTimeout {
-				msg := fmt.Sprintf("last block fetched at %s, chain=%s, height=%d",
-					time.Unix(curOtherChainBlockLog.CreateTime, 0).String(), ob.Executor.GetChainName(), curOtherChainBlockLog.Height)
-				util.SendTelegramMessage(msg)
+		rangeEventLogs, err := ob.Executor.GetRangeEvents(rangeFrom, rangeTo)
+		if err != nil {
+			return saved, fmt.Errorf("get range events error, height=%d-%d, err=%s", rangeFrom, rangeTo, err.Error())
+		}
+		for _, event := range rangeEventLogs.Events {
+			txEventLog, ok := event.(*model.SwapStartTxLog)
+			if !ok {
+				continue
+			}
+			count := 0
+			if err := ob.DB.Model(model.SwapStartTxLog{}).Where("tx_hash = ?", txEventLog.TxHash).Count(&count).Error; err != nil {
+				return saved, err
+			}
+			if count > 0 {
+				continue
 			}
+			// the start tx logs of the archived swaps are moved out of the table
+			if err := ob.DB.Model(model.ArchivedSwapStartTxLog{}).Where("tx_hash = ?", txEventLog.TxHash).Count(&count).Error; err != nil {
+				return saved, err
+			}
+			if count > 0 {
+				continue
+			}
+			txEventLog.ConfirmedNum = curBlockLog.Height + 1 - txEventLog.Height
+			confirmNum, ok := riskyTokens[ethcom.HexToAddress(txEventLog.TokenAddr).String()]
+			if !ok {
+				confirmNum = ob.ConfirmNum
+			}
+			if txEventLog.ConfirmedNum >= confirmNum {
+				txEventLog.Status = model.TxStatusConfirmed
+			}
+			if err := ob.DB.Create(txEventLog).Error; err != nil {
+				return saved, err
+			}
+			util.Logger.Infof("backfill swap start event, chain %s, height %d, tx hash %s", ob.Executor.GetChainName(), txEventLog.Height, txEventLog.TxHash)
+			saved++
 		}
-
-		time.Sleep(common.ObserverAlertInterval)
 	}
+	return saved, nil
 }
@@ -0,0 +1,205 @@
+// Package price values the tokens in usd for the limits and the thresholds configured in usd. The prices are read
+// from the chainlink feeds on the chains, or from a rest oracle for the symbols without a chainlink feed, and cached
+// for the cache seconds of the config.
+package price
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethcom "github.com/ethereum/go-ethereum/common"
+
+	sabi "occ-swap-server/abi"
+	"occ-swap-server/util"
+)
+
+var aggregatorABI abi.ABI
+
+func init() {
+	parsed, err := abi.JSON(strings.NewReader(sabi.AggregatorV3ABI))
+	if err != nil {
+		panic(fmt.Sprintf("parse aggregator abi error, err=%s", err.Error()))
+	}
+	aggregatorABI = parsed
+}
+
+// CallerProvider returns the rpc of the chain the chainlink feeds are read from
+type CallerProvider func(chain string) ethereum.ContractCaller
+
+type cachedPrice struct {
+	price     float64
+	fetchedAt time.Time
+}
+
+// Oracle returns the usd prices of the symbols, it is safe for concurrent use
+type Oracle struct {
+	mutex   sync.Mutex
+	cfg     util.PriceConfig
+	callers CallerProvider
+	client  *http.Client
+	cache   map[string]cachedPrice
+}
+
+func NewOracle(cfg util.PriceConfig, callers CallerProvider) *Oracle {
+	return &Oracle{
+		cfg:     cfg,
+		callers: callers,
+		client:  &http.Client{Timeout: cfg.GetTimeout()},
+		cache:   make(map[string]cachedPrice),
+	}
+}
+
+// Price returns the usd price of the symbol, it is read from the chainlink feed of the symbol if there is one
+func (oracle *Oracle) Price(symbol string) (float64, error) {
+	oracle.mutex.Lock()
+	cached, ok := oracle.cache[symbol]
+	oracle.mutex.Unlock()
+	if ok && time.Since(cached.fetchedAt) < oracle.cfg.GetCache() {
+		return cached.price, nil
+	}
+
+	var price float64
+	var err error
+	if feed, ok := oracle.getChainlinkFeed(symbol); ok {
+		price, err = oracle.queryChainlink(feed)
+	} else {
+		price, err = oracle.queryRest(symbol)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if price <= 0 || math.IsInf(price, 0) || math.IsNaN(price) {
+		return 0, fmt.Errorf("invalid price of %s: %f", symbol, price)
+	}
+
+	oracle.mutex.Lock()
+	oracle.cache[symbol] = cachedPrice{price: price, fetchedAt: time.Now()}
+	oracle.mutex.Unlock()
+	return price, nil
+}
+
+// NativePrice returns the usd price of the native token of the chain
+func (oracle *Oracle) NativePrice(chain string) (float64, error) {
+	return oracle.Price(oracle.cfg.GetNativeSymbol(chain))
+}
+
+// TokenValue returns the usd value of the amount of the token, it is error if the token is not in the config
+func (oracle *Oracle) TokenValue(erc20Addr string, amount *big.Int) (float64, error) {
+	token, ok := oracle.cfg.GetToken(erc20Addr)
+	if !ok {
+		return 0, fmt.Errorf("token %s is not valued", erc20Addr)
+	}
+	price, err := oracle.Price(token.Symbol)
+	if err != nil {
+		return 0, err
+	}
+	return ToFloat(amount, token.Decimals) * price, nil
+}
+
+func (oracle *Oracle) getChainlinkFeed(symbol string) (util.ChainlinkFeedConfig, bool) {
+	for _, feed := range oracle.cfg.ChainlinkFeeds {
+		if feed.Symbol == symbol {
+			return feed, true
+		}
+	}
+	return util.ChainlinkFeedConfig{}, false
+}
+
+// queryChainlink reads the latest round of the aggregator, the round is rejected if it is older than the max age
+func (oracle *Oracle) queryChainlink(feed util.ChainlinkFeedConfig) (float64, error) {
+	caller := oracle.callers(feed.Chain)
+	aggregator := ethcom.HexToAddress(feed.Aggregator)
+
+	var decimals uint8
+	if err := callAggregator(caller, aggregator, &decimals, "decimals"); err != nil {
+		return 0, fmt.Errorf("query decimals of chainlink feed %s error: %s", feed.Symbol, err.Error())
+	}
+	var round struct {
+		RoundId         *big.Int
+		Answer          *big.Int
+		StartedAt       *big.Int
+		UpdatedAt       *big.Int
+		AnsweredInRound *big.Int
+	}
+	if err := callAggregator(caller, aggregator, &round, "latestRoundData"); err != nil {
+		return 0, fmt.Errorf("query latest round of chainlink feed %s error: %s", feed.Symbol, err.Error())
+	}
+	if round.Answer.Sign() <= 0 {
+		return 0, fmt.Errorf("invalid answer of chainlink feed %s: %s", feed.Symbol, round.Answer.String())
+	}
+	updatedAt := time.Unix(round.UpdatedAt.Int64(), 0)
+	if time.Since(updatedAt) > oracle.cfg.GetMaxAge() {
+		return 0, fmt.Errorf("chainlink feed %s is stale, updated at %s", feed.Symbol, updatedAt.UTC().Format(time.RFC3339))
+	}
+	return ToFloat(round.Answer, int(decimals)), nil
+}
+
+func callAggregator(caller ethereum.ContractCaller, aggregator ethcom.Address, out interface{}, method string) error {
+	data, err := aggregatorABI.Pack(method)
+	if err != nil {
+		return err
+	}
+	output, err := caller.CallContract(context.Background(), ethereum.CallMsg{To: &aggregator, Data: data}, nil)
+	if err != nil {
+		return err
+	}
+	return aggregatorABI.Unpack(out, method, output)
+}
+
+// queryRest reads the price of the symbol from the rest oracle, the prices of all the symbols are cached
+func (oracle *Oracle) queryRest(symbol string) (float64, error) {
+	if oracle.cfg.RestUrl == "" {
+		return 0, fmt.Errorf("no price feed of %s", symbol)
+	}
+	resp, err := oracle.client.Get(oracle.cfg.RestUrl)
+	if err != nil {
+		return 0, fmt.Errorf("query price oracle error: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("query price oracle error: response status %d", resp.StatusCode)
+	}
+	prices := make(map[string]float64)
+	if err := json.NewDecoder(resp.Body).Decode(&prices); err != nil {
+		return 0, fmt.Errorf("decode price oracle error: %s", err.Error())
+	}
+
+	now := time.Now()
+	oracle.mutex.Lock()
+	for s, p := range prices {
+		if _, ok := oracle.getChainlinkFeed(s); !ok && p > 0 {
+			oracle.cache[s] = cachedPrice{price: p, fetchedAt: now}
+		}
+	}
+	oracle.mutex.Unlock()
+
+	price, ok := prices[symbol]
+	if !ok {
+		return 0, fmt.Errorf("no price of %s in price oracle", symbol)
+	}
+	return price, nil
+}
+
+// ToFloat returns the amount in the units of the token of the decimals
+func ToFloat(amount *big.Int, decimals int) float64 {
+	value := new(big.Float).SetInt(amount)
+	value.Quo(value, new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)))
+	result, _ := value.Float64()
+	return result
+}
+
+// FromFloat returns the amount of the token of the decimals, it is the inverse of ToFloat
+func FromFloat(value float64, decimals int) *big.Int {
+	amount := new(big.Float).Mul(big.NewFloat(value), new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)))
+	result, _ := amount.Int(nil)
+	return result
+}
@@ -0,0 +1,516 @@
+package swap
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"occ-swap-server/util"
+)
+
+type BroadcastPriority int
+
+const (
+	BroadcastPriorityNormal BroadcastPriority = iota
+	BroadcastPriorityHigh
+)
+
+const (
+	// MaxRebroadcastTimes is the max times a tx which is not seen by the node is rebroadcast, after that the
+	// tx is not tracked by the broadcaster any more and left to the tx tracking daemons
+	MaxRebroadcastTimes = 3
+	// MaxReplaceTimes is the max times an underpriced tx is signed again with a bumped gas price
+	MaxReplaceTimes = 3
+	// ReplacementPriceBump is the percentage the gas price of a replacement tx is bumped by, the same as the
+	// default price bump of the geth tx pool
+	ReplacementPriceBump = 10
+	// SelfTransferGasLimit is the gas of a plain transfer
+	SelfTransferGasLimit = 21000
+)
+
+type broadcastResult struct {
+	tx  *types.Transaction
+	err error
+}
+
+type broadcastRequest struct {
+	priority BroadcastPriority
+	seq      int64
+	use      KeyUse
+	contract ethcom.Address
+	// value sent with the call, nil for none
+	value *big.Int
+	data  []byte
+	// onSigned is called with the signed tx and the gas estimate of the call before the tx is broadcast, the tx is
+	// not broadcast if it returns error. It is called again with the replacement if the tx is underpriced and
+	// replaced. The gas estimate is 0 if the gas can't be estimated and the fallback gas limit is used.
+	onSigned func(signedTx *types.Transaction, gasEstimate uint64) error
+	result   chan broadcastResult
+	// gas estimate of the call, set when it is sent
+	gasEstimate uint64
+}
+
+// broadcastQueue is a priority queue of broadcast requests, requests of the same priority are served in order
+type broadcastQueue []*broadcastRequest
+
+func (q broadcastQueue) Len() int { return len(q) }
+
+func (q broadcastQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q broadcastQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *broadcastQueue) Push(x interface{}) { *q = append(*q, x.(*broadcastRequest)) }
+
+func (q *broadcastQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	req := old[n-1]
+	*q = old[:n-1]
+	return req
+}
+
+type inFlightTx struct {
+	tx          *types.Transaction
+	sentAt      time.Time
+	rebroadcast int
+}
+
+// Broadcaster sends the txs of one chain signed by the same key. Nonces are assigned serially, the number of
+// txs which are sent but not mined is bounded, and txs which are not seen by the node after a timeout are
+// rebroadcast. The replicas share the key, so every nonce is reserved before a tx is signed with it, and the nonces
+// reserved by the other replicas are skipped.
+type Broadcaster struct {
+	chain      string
+	client     ChainClient
+	privateKey *ecdsa.PrivateKey
+	account    ethcom.Address
+	chainId    *big.Int
+	explorer   util.ExplorerTemplates
+
+	maxInFlight        int
+	rebroadcastTimeout time.Duration
+	gasPolicy          util.GasLimitPolicy
+	// onSent is called with every tx sent by the account, set before the broadcaster starts
+	onSent func(account ethcom.Address, tx *types.Transaction)
+	// onSign is called with every tx signed by the key before it is sent, the tx is not sent if it returns error
+	onSign func(use KeyUse, account ethcom.Address, tx *types.Transaction) error
+	// reserveNonce reserves the nonce of the account for the replica, it is false if another replica reserved it
+	reserveNonce func(account ethcom.Address, nonce uint64) (bool, error)
+
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	queue    broadcastQueue
+	seq      int64
+	nonce    uint64
+	inFlight map[ethcom.Hash]*inFlightTx
+}
+
+func NewBroadcaster(chain string, client ChainClient, privateKey *ecdsa.PrivateKey, chainId *big.Int,
+	explorer util.ExplorerTemplates, maxInFlight int, rebroadcastTimeout time.Duration, gasPolicy util.GasLimitPolicy) *Broadcaster {
+	b := &Broadcaster{
+		chain:              chain,
+		client:             client,
+		privateKey:         privateKey,
+		account:            crypto.PubkeyToAddress(privateKey.PublicKey),
+		chainId:            chainId,
+		explorer:           explorer,
+		maxInFlight:        maxInFlight,
+		rebroadcastTimeout: rebroadcastTimeout,
+		gasPolicy:          gasPolicy,
+		inFlight:           make(map[ethcom.Hash]*inFlightTx),
+	}
+	b.cond = sync.NewCond(&b.mutex)
+	return b
+}
+
+func (b *Broadcaster) Start(scheduler *util.Scheduler) {
+	name := fmt.Sprintf("%s_%s", b.chain, b.account.String())
+	scheduler.Go(util.Daemon{Name: "broadcast_" + name, Run: b.sendDaemon})
+	scheduler.Go(util.Daemon{Name: "rebroadcast_" + name, Interval: b.rebroadcastTimeout, WaitFirst: true, Run: b.rebroadcastDaemon})
+}
+
+// Broadcast queues a contract call and blocks until the signed tx is sent to the node
+func (b *Broadcaster) Broadcast(priority BroadcastPriority, use KeyUse, contract ethcom.Address, data []byte,
+	onSigned func(signedTx *types.Transaction, gasEstimate uint64) error) (*types.Transaction, error) {
+	return b.BroadcastValue(priority, use, contract, nil, data, onSigned)
+}
+
+// BroadcastValue is Broadcast of a payable contract call, the value is sent with the call
+func (b *Broadcaster) BroadcastValue(priority BroadcastPriority, use KeyUse, contract ethcom.Address, value *big.Int, data []byte,
+	onSigned func(signedTx *types.Transaction, gasEstimate uint64) error) (*types.Transaction, error) {
+	req := &broadcastRequest{
+		priority: priority,
+		use:      use,
+		contract: contract,
+		value:    value,
+		data:     data,
+		onSigned: onSigned,
+		result:   make(chan broadcastResult, 1),
+	}
+
+	b.mutex.Lock()
+	b.seq++
+	req.seq = b.seq
+	heap.Push(&b.queue, req)
+	b.cond.Broadcast()
+	b.mutex.Unlock()
+
+	res := <-req.result
+	return res.tx, res.err
+}
+
+// OnSent sets the hook called with every tx sent by the account, it must be set before the broadcaster starts
+func (b *Broadcaster) OnSent(onSent func(account ethcom.Address, tx *types.Transaction)) {
+	b.onSent = onSent
+}
+
+// OnSign sets the hook called with every tx signed by the key, it must be set before the broadcaster starts
+func (b *Broadcaster) OnSign(onSign func(use KeyUse, account ethcom.Address, tx *types.Transaction) error) {
+	b.onSign = onSign
+}
+
+// OnReserveNonce sets the hook reserving the nonces of the account before the txs are signed, it must be set before
+// the broadcaster starts
+func (b *Broadcaster) OnReserveNonce(reserveNonce func(account ethcom.Address, nonce uint64) (bool, error)) {
+	b.reserveNonce = reserveNonce
+}
+
+// Account returns the address of the key signing the txs
+func (b *Broadcaster) Account() ethcom.Address {
+	return b.account
+}
+
+// InFlightCount returns the number of txs which are sent but not mined yet
+func (b *Broadcaster) InFlightCount() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.inFlight)
+}
+
+// sendDaemon sends the next request once there is room for it
+func (b *Broadcaster) sendDaemon() error {
+	b.mutex.Lock()
+	for b.queue.Len() == 0 || len(b.inFlight) >= b.maxInFlight {
+		b.cond.Wait()
+	}
+	req := heap.Pop(&b.queue).(*broadcastRequest)
+	b.mutex.Unlock()
+
+	// the caller of the request is released if the send panics
+	defer func() {
+		if r := recover(); r != nil {
+			req.result <- broadcastResult{err: fmt.Errorf("send tx panicked: %v", r)}
+			panic(r)
+		}
+	}()
+	tx, err := b.send(req)
+	req.result <- broadcastResult{tx: tx, err: err}
+	return util.RunAgain
+}
+
+func (b *Broadcaster) send(req *broadcastRequest) (*types.Transaction, error) {
+	txOpts := bind.NewKeyedTransactor(b.privateKey)
+
+	// the key may be used by the other components, so never go below the pending nonce of the node
+	pendingNonce, err := b.client.PendingNonceAt(context.Background(), txOpts.From)
+	if err != nil {
+		return nil, err
+	}
+	b.mutex.Lock()
+	if pendingNonce > b.nonce {
+		b.nonce = pendingNonce
+	}
+	nonce := b.nonce
+	b.mutex.Unlock()
+	if nonce, err = b.reserveFrom(nonce); err != nil {
+		return nil, err
+	}
+
+	gasPrice, err := b.client.SuggestGasPrice(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	value := big.NewInt(0)
+	if req.value != nil {
+		value = req.value
+	}
+	msg := ethereum.CallMsg{From: txOpts.From, To: &req.contract, GasPrice: gasPrice, Value: value, Data: req.data}
+	gasLimit, err := b.estimateGasLimit(req, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	rawTx := types.NewTransaction(nonce, req.contract, value, gasLimit, gasPrice, req.data)
+	signedTx, err := b.sign(txOpts, req.use, rawTx)
+	if err != nil {
+		return nil, err
+	}
+	if req.onSigned != nil {
+		if err := req.onSigned(signedTx, req.gasEstimate); err != nil {
+			return nil, err
+		}
+	}
+
+	err = b.client.SendTransaction(context.Background(), signedTx)
+	for replaced := 0; isReplaceUnderpriced(err) && replaced < MaxReplaceTimes; replaced++ {
+		replacement, replaceErr := b.replaceUnderpriced(req, signedTx)
+		if replacement == nil {
+			if replaceErr != nil {
+				util.Logger.Errorf("replace underpriced tx %s to %s error: %s", signedTx.Hash().String(), b.chain, replaceErr.Error())
+			}
+			break
+		}
+		signedTx, err = replacement, replaceErr
+	}
+	if err != nil {
+		util.Logger.Errorf("broadcast tx to %s error: %s", b.chain, err.Error())
+		return signedTx, err
+	}
+	util.Logger.Infof("Send transaction to %s, %s %s", b.chain, signedTx.Hash().String(), b.explorer.Link(util.ExplorerTx, signedTx.Hash().String()))
+
+	b.mutex.Lock()
+	b.nonce = nonce + 1
+	// a replaced tx can never be mined
+	for txHash, tx := range b.inFlight {
+		if tx.tx.Nonce() == nonce {
+			delete(b.inFlight, txHash)
+		}
+	}
+	b.inFlight[signedTx.Hash()] = &inFlightTx{tx: signedTx, sentAt: time.Now()}
+	b.mutex.Unlock()
+	if b.onSent != nil {
+		b.onSent(b.account, signedTx)
+	}
+	return signedTx, nil
+}
+
+// reserveFrom reserves the first nonce from the nonce which is not reserved by another replica, a nonce the replica
+// reserved before is reserved again
+func (b *Broadcaster) reserveFrom(nonce uint64) (uint64, error) {
+	for {
+		reserved, err := b.reserve(nonce)
+		if err != nil {
+			return 0, err
+		}
+		if reserved {
+			return nonce, nil
+		}
+		util.Logger.Debugf("nonce %d of %s on %s is reserved by another replica, skip it", nonce, b.account.String(), b.chain)
+		nonce++
+	}
+}
+
+// reserve reserves the nonce for the replica, it is false if another replica reserved it
+func (b *Broadcaster) reserve(nonce uint64) (bool, error) {
+	if b.reserveNonce == nil {
+		return true, nil
+	}
+	reserved, err := b.reserveNonce(b.account, nonce)
+	if err != nil {
+		return false, fmt.Errorf("reserve nonce %d of %s on %s error: %s", nonce, b.account.String(), b.chain, err.Error())
+	}
+	return reserved, nil
+}
+
+// estimateGasLimit returns the gas limit of the call by the gas policy of the chain, the fallback gas limit is used
+// if the gas can't be estimated
+func (b *Broadcaster) estimateGasLimit(req *broadcastRequest, msg ethereum.CallMsg) (uint64, error) {
+	estimate, err := b.client.EstimateGas(context.Background(), msg)
+	if err != nil {
+		util.Logger.Errorf("estimate gas of call to %s on %s error, use the fallback gas limit %d: %s",
+			req.contract.String(), b.chain, b.gasPolicy.Fallback, err.Error())
+		req.gasEstimate = 0
+		return b.gasPolicy.Fallback, nil
+	}
+	req.gasEstimate = estimate
+	return b.gasPolicy.GetGasLimit(estimate)
+}
+
+func isReplaceUnderpriced(err error) bool {
+	return err != nil && err.Error() == core.ErrReplaceUnderpriced.Error()
+}
+
+// replaceUnderpriced signs the call again with the nonce of the underpriced tx and a bumped gas price, and sends it
+// to replace the tx pending with the nonce. Sending the call with a new nonce instead could get both of them mined.
+// It returns nil if the nonce is mined already, or the pending tx is another call of the broadcaster or a tx of another
+// replica, which are never replaced.
+func (b *Broadcaster) replaceUnderpriced(req *broadcastRequest, underpricedTx *types.Transaction) (*types.Transaction, error) {
+	nonce := underpricedTx.Nonce()
+	minedNonce, err := b.client.NonceAt(context.Background(), b.account, nil)
+	if err != nil {
+		return nil, err
+	}
+	if nonce < minedNonce {
+		util.Logger.Infof("nonce %d of %s on %s is mined, tx %s is not replaced", nonce, b.account.String(), b.chain, underpricedTx.Hash().String())
+		return nil, nil
+	}
+
+	// the replicas share the key, the pending tx is of another replica unless the replica reserved the nonce
+	if reserved, err := b.reserve(nonce); err != nil || !reserved {
+		if err == nil {
+			b.mutex.Lock()
+			if b.nonce <= nonce {
+				b.nonce = nonce + 1
+			}
+			b.mutex.Unlock()
+			util.Logger.Errorf("nonce %d of %s on %s is reserved by another replica, tx %s is not replaced", nonce,
+				b.account.String(), b.chain, underpricedTx.Hash().String())
+		}
+		return nil, err
+	}
+
+	gasPrice := underpricedTx.GasPrice()
+	b.mutex.Lock()
+	for _, tx := range b.inFlight {
+		if tx.tx.Nonce() != nonce {
+			continue
+		}
+		if tx.tx.To() == nil || *tx.tx.To() != req.contract || !bytes.Equal(tx.tx.Data(), req.data) {
+			// the pending nonce of the node is behind, skip the nonce of the other call
+			if b.nonce <= nonce {
+				b.nonce = nonce + 1
+			}
+			b.mutex.Unlock()
+			util.Logger.Errorf("nonce %d of %s on %s is pending with another tx %s, tx %s is not replaced", nonce,
+				b.account.String(), b.chain, tx.tx.Hash().String(), underpricedTx.Hash().String())
+			return nil, nil
+		}
+		if tx.tx.GasPrice().Cmp(gasPrice) > 0 {
+			gasPrice = tx.tx.GasPrice()
+		}
+	}
+	b.mutex.Unlock()
+	gasPrice = bumpGasPrice(gasPrice)
+
+	txOpts := bind.NewKeyedTransactor(b.privateKey)
+	rawTx := types.NewTransaction(nonce, req.contract, underpricedTx.Value(), underpricedTx.Gas(), gasPrice, req.data)
+	signedTx, err := b.sign(txOpts, req.use, rawTx)
+	if err != nil {
+		return nil, err
+	}
+	if req.onSigned != nil {
+		if err := req.onSigned(signedTx, req.gasEstimate); err != nil {
+			return nil, err
+		}
+	}
+	util.Logger.Infof("tx %s to %s is underpriced, replace the pending tx of nonce %d with tx %s, gas price %s",
+		underpricedTx.Hash().String(), b.chain, nonce, signedTx.Hash().String(), gasPrice.String())
+	return signedTx, b.client.SendTransaction(context.Background(), signedTx)
+}
+
+// sign signs the tx with the key and passes it to the onSign hook
+func (b *Broadcaster) sign(txOpts *bind.TransactOpts, use KeyUse, rawTx *types.Transaction) (*types.Transaction, error) {
+	signedTx, err := txOpts.Signer(types.NewEIP155Signer(b.chainId), txOpts.From, rawTx)
+	if err != nil {
+		return nil, err
+	}
+	if b.onSign != nil {
+		if err := b.onSign(use, b.account, signedTx); err != nil {
+			return nil, fmt.Errorf("record %s signature of tx %s error: %s", use.Purpose, signedTx.Hash().String(), err.Error())
+		}
+	}
+	return signedTx, nil
+}
+
+// bumpGasPrice returns the gas price bumped by ReplacementPriceBump percent, rounded up
+func bumpGasPrice(gasPrice *big.Int) *big.Int {
+	bumped := big.NewInt(0).Mul(gasPrice, big.NewInt(100+ReplacementPriceBump))
+	bumped.Add(bumped, big.NewInt(99))
+	return bumped.Div(bumped, big.NewInt(100))
+}
+
+// sendSelfTransfer sends nothing to the account itself with the nonce, it fills a nonce gap so the txs with the
+// larger nonces can be mined
+func (b *Broadcaster) sendSelfTransfer(use KeyUse, nonce uint64) (*types.Transaction, error) {
+	gasPrice, err := b.client.SuggestGasPrice(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	txOpts := bind.NewKeyedTransactor(b.privateKey)
+	rawTx := types.NewTransaction(nonce, b.account, big.NewInt(0), SelfTransferGasLimit, gasPrice, nil)
+	signedTx, err := b.sign(txOpts, use, rawTx)
+	if err != nil {
+		return nil, err
+	}
+	return signedTx, b.client.SendTransaction(context.Background(), signedTx)
+}
+
+func (b *Broadcaster) rebroadcastDaemon() error {
+	b.mutex.Lock()
+	txs := make([]*inFlightTx, 0, len(b.inFlight))
+	for _, tx := range b.inFlight {
+		txs = append(txs, tx)
+	}
+	b.mutex.Unlock()
+
+	for _, tx := range txs {
+		b.checkInFlightTx(tx)
+	}
+	return nil
+}
+
+func (b *Broadcaster) checkInFlightTx(tx *inFlightTx) {
+	txHash := tx.tx.Hash()
+	if _, err := b.client.TransactionReceipt(context.Background(), txHash); err == nil {
+		b.release(txHash)
+		return
+	}
+	if time.Since(tx.sentAt) < b.rebroadcastTimeout {
+		return
+	}
+
+	_, _, err := b.client.TransactionByHash(context.Background(), txHash)
+	if err == nil {
+		// still known by the node, wait for it to be mined
+		return
+	}
+	if err != ethereum.NotFound {
+		util.Logger.Debugf("%s, query tx %s failed: %s", b.chain, txHash.String(), err.Error())
+		return
+	}
+
+	if tx.rebroadcast >= MaxRebroadcastTimes {
+		util.Logger.Errorf("tx %s is not seen by %s node after rebroadcast %d times, stop tracking it", txHash.String(), b.chain, tx.rebroadcast)
+		util.SendTelegramMessage(fmt.Sprintf("tx %s is not seen by %s node after rebroadcast %d times, stop tracking it %s", txHash.String(), b.chain, tx.rebroadcast,
+			b.explorer.Link(util.ExplorerTx, txHash.String())))
+		b.mutex.Lock()
+		// the nonce of the dropped tx is free again, follow the pending nonce of the node
+		b.nonce = 0
+		b.mutex.Unlock()
+		b.release(txHash)
+		return
+	}
+	util.Logger.Infof("tx %s is not seen by %s node after %s, rebroadcast it", txHash.String(), b.chain, b.rebroadcastTimeout.String())
+	if err := b.client.SendTransaction(context.Background(), tx.tx); err != nil {
+		util.Logger.Errorf("rebroadcast tx %s to %s error: %s", txHash.String(), b.chain, err.Error())
+	}
+
+	b.mutex.Lock()
+	tx.sentAt = time.Now()
+	tx.rebroadcast++
+	b.mutex.Unlock()
+}
+
+func (b *Broadcaster) release(txHash ethcom.Hash) {
+	b.mutex.Lock()
+	delete(b.inFlight, txHash)
+	b.cond.Broadcast()
+	b.mutex.Unlock()
+}
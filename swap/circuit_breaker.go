@@ -0,0 +1,365 @@
+package swap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"occ-swap-server/common"
+	"occ-swap-server/util"
+)
+
+const (
+	CircuitClosed = "closed"
+	CircuitOpen   = "open"
+
+	probeTimeout = 10 * time.Second
+)
+
+// ErrCircuitOpen is returned by the rpc calls of a chain whose circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker of the chain rpc is open")
+
+// ChainHealth is the state of the circuit breaker of a chain rpc
+type ChainHealth struct {
+	Chain     string    `json:"chain"`
+	State     string    `json:"state"`
+	Failures  int64     `json:"failures"`
+	OpenedAt  time.Time `json:"opened_at"`
+	LastError string    `json:"last_error"`
+	ProbeTime time.Time `json:"probe_time"`
+}
+
+// circuitBreaker counts the consecutive rpc failures of a chain. It is opened after threshold failures, the calls
+// then fail with ErrCircuitOpen without reaching the rpc, and the probe daemon queries the latest header every
+// probeInterval until the rpc is back. Opening and closing are alerted once.
+type circuitBreaker struct {
+	chain         string
+	client        ChainClient
+	threshold     int64
+	probeInterval time.Duration
+
+	mutex     sync.RWMutex
+	failures  int64
+	open      bool
+	openedAt  time.Time
+	lastErr   error
+	probeTime time.Time
+}
+
+func newCircuitBreaker(chain string, client ChainClient, cfg util.CircuitBreakerConfig) *circuitBreaker {
+	circuitOpenGauge.WithLabelValues(chain).Set(0)
+	return &circuitBreaker{
+		chain:         chain,
+		client:        client,
+		threshold:     cfg.GetFailureThreshold(),
+		probeInterval: cfg.GetProbeInterval(),
+	}
+}
+
+// Client returns the chain client whose calls go through the breaker
+func (b *circuitBreaker) Client() ChainClient {
+	return &breakerClient{breaker: b}
+}
+
+func (b *circuitBreaker) IsOpen() bool {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.open
+}
+
+func (b *circuitBreaker) allow() error {
+	if b.IsOpen() {
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// record counts the result of a call, only the failures of the rpc itself are counted, the errors returned by the
+// node like a revert or a missing tx mean the rpc is up
+func (b *circuitBreaker) record(err error) {
+	if err != nil && !isRPCFailure(err) {
+		err = nil
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if err == nil {
+		if !b.open {
+			b.failures = 0
+		}
+		return
+	}
+	b.failures++
+	b.lastErr = err
+	if b.open || b.failures < b.threshold {
+		return
+	}
+	b.open = true
+	b.openedAt = time.Now()
+	circuitOpenGauge.WithLabelValues(b.chain).Set(1)
+	util.Logger.Errorf("%s rpc failed %d times in a row, circuit breaker is open, last err: %s", b.chain, b.failures, err.Error())
+	util.SendTelegramMessage(fmt.Sprintf("Urgent alert: %s rpc failed %d times in a row, the chain is degraded until the rpc is back, last err: %s",
+		b.chain, b.failures, err.Error()))
+}
+
+// Start starts the probe daemon, it only probes the rpc while the breaker is open
+func (b *circuitBreaker) Start(scheduler *util.Scheduler) {
+	scheduler.Go(util.Daemon{Name: "probe_" + b.chain, Interval: b.probeInterval, WaitFirst: true, Run: b.probeDaemon})
+}
+
+// probeDaemon queries the latest header and closes the breaker once it succeeds
+func (b *circuitBreaker) probeDaemon() error {
+	if !b.IsOpen() {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	_, err := b.client.HeaderByNumber(ctx, nil)
+	cancel()
+
+	b.mutex.Lock()
+	b.probeTime = time.Now()
+	if err != nil {
+		b.lastErr = err
+		b.mutex.Unlock()
+		util.Logger.Debugf("%s rpc probe failed: %s", b.chain, err.Error())
+		return nil
+	}
+	downtime := time.Since(b.openedAt)
+	b.open = false
+	b.failures = 0
+	b.mutex.Unlock()
+
+	circuitOpenGauge.WithLabelValues(b.chain).Set(0)
+	util.Logger.Infof("%s rpc is back after %s, circuit breaker is closed", b.chain, downtime.Round(time.Second))
+	util.SendTelegramMessage(fmt.Sprintf("%s rpc is back after %s, the chain is no longer degraded", b.chain, downtime.Round(time.Second)))
+	return nil
+}
+
+func (b *circuitBreaker) Health() ChainHealth {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	health := ChainHealth{
+		Chain:     b.chain,
+		State:     CircuitClosed,
+		Failures:  b.failures,
+		ProbeTime: b.probeTime,
+	}
+	if b.open {
+		health.State = CircuitOpen
+		health.OpenedAt = b.openedAt
+	}
+	if b.lastErr != nil {
+		health.LastError = b.lastErr.Error()
+	}
+	return health
+}
+
+// isRPCFailure tells whether the rpc failed to answer, the json-rpc errors of the node implement rpc.Error
+func isRPCFailure(err error) bool {
+	if err == nil || err == ethereum.NotFound || err == ErrCircuitOpen {
+		return false
+	}
+	var rpcErr rpc.Error
+	return !errors.As(err, &rpcErr)
+}
+
+// breakerClient is the chain client of the engine, every call is recorded by the breaker of the chain
+type breakerClient struct {
+	breaker *circuitBreaker
+}
+
+var _ ChainClient = (*breakerClient)(nil)
+var _ ReceiptBatcher = (*breakerClient)(nil)
+
+func (c *breakerClient) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if err := c.breaker.allow(); err != nil {
+		return nil, err
+	}
+	result, err := c.breaker.client.CallContract(ctx, call, blockNumber)
+	c.breaker.record(err)
+	return result, err
+}
+
+func (c *breakerClient) EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+	if err := c.breaker.allow(); err != nil {
+		return 0, err
+	}
+	gas, err := c.breaker.client.EstimateGas(ctx, call)
+	c.breaker.record(err)
+	return gas, err
+}
+
+func (c *breakerClient) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	if err := c.breaker.allow(); err != nil {
+		return nil, err
+	}
+	gasPrice, err := c.breaker.client.SuggestGasPrice(ctx)
+	c.breaker.record(err)
+	return gasPrice, err
+}
+
+func (c *breakerClient) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	if err := c.breaker.allow(); err != nil {
+		return nil, err
+	}
+	logs, err := c.breaker.client.FilterLogs(ctx, query)
+	c.breaker.record(err)
+	return logs, err
+}
+
+func (c *breakerClient) SubscribeFilterLogs(ctx context.Context, query ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	if err := c.breaker.allow(); err != nil {
+		return nil, err
+	}
+	sub, err := c.breaker.client.SubscribeFilterLogs(ctx, query, ch)
+	c.breaker.record(err)
+	return sub, err
+}
+
+func (c *breakerClient) TransactionByHash(ctx context.Context, txHash ethcom.Hash) (*types.Transaction, bool, error) {
+	if err := c.breaker.allow(); err != nil {
+		return nil, false, err
+	}
+	tx, isPending, err := c.breaker.client.TransactionByHash(ctx, txHash)
+	c.breaker.record(err)
+	return tx, isPending, err
+}
+
+func (c *breakerClient) TransactionReceipt(ctx context.Context, txHash ethcom.Hash) (*types.Receipt, error) {
+	if err := c.breaker.allow(); err != nil {
+		return nil, err
+	}
+	receipt, err := c.breaker.client.TransactionReceipt(ctx, txHash)
+	c.breaker.record(err)
+	return receipt, err
+}
+
+// TransactionReceipts is batched if the client of the chain supports it, a failed batch is recorded once
+func (c *breakerClient) TransactionReceipts(ctx context.Context, txHashes []ethcom.Hash) ([]*types.Receipt, []error) {
+	if err := c.breaker.allow(); err != nil {
+		errs := make([]error, len(txHashes))
+		for i := range errs {
+			errs[i] = err
+		}
+		return make([]*types.Receipt, len(txHashes)), errs
+	}
+	receipts, errs := getTransactionReceipts(c.breaker.client, txHashes)
+	var batchErr error
+	for _, err := range errs {
+		if isRPCFailure(err) {
+			batchErr = err
+			break
+		}
+	}
+	c.breaker.record(batchErr)
+	return receipts, errs
+}
+
+func (c *breakerClient) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	if err := c.breaker.allow(); err != nil {
+		return err
+	}
+	err := c.breaker.client.SendTransaction(ctx, tx)
+	c.breaker.record(err)
+	return err
+}
+
+func (c *breakerClient) ChainID(ctx context.Context) (*big.Int, error) {
+	if err := c.breaker.allow(); err != nil {
+		return nil, err
+	}
+	chainID, err := c.breaker.client.ChainID(ctx)
+	c.breaker.record(err)
+	return chainID, err
+}
+
+func (c *breakerClient) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	if err := c.breaker.allow(); err != nil {
+		return nil, err
+	}
+	header, err := c.breaker.client.HeaderByNumber(ctx, number)
+	c.breaker.record(err)
+	return header, err
+}
+
+func (c *breakerClient) BlockByHash(ctx context.Context, hash ethcom.Hash) (*types.Block, error) {
+	if err := c.breaker.allow(); err != nil {
+		return nil, err
+	}
+	block, err := c.breaker.client.BlockByHash(ctx, hash)
+	c.breaker.record(err)
+	return block, err
+}
+
+func (c *breakerClient) PendingNonceAt(ctx context.Context, account ethcom.Address) (uint64, error) {
+	if err := c.breaker.allow(); err != nil {
+		return 0, err
+	}
+	nonce, err := c.breaker.client.PendingNonceAt(ctx, account)
+	c.breaker.record(err)
+	return nonce, err
+}
+
+func (c *breakerClient) NonceAt(ctx context.Context, account ethcom.Address, blockNumber *big.Int) (uint64, error) {
+	if err := c.breaker.allow(); err != nil {
+		return 0, err
+	}
+	nonce, err := c.breaker.client.NonceAt(ctx, account, blockNumber)
+	c.breaker.record(err)
+	return nonce, err
+}
+
+func (c *breakerClient) BalanceAt(ctx context.Context, account ethcom.Address, blockNumber *big.Int) (*big.Int, error) {
+	if err := c.breaker.allow(); err != nil {
+		return nil, err
+	}
+	balance, err := c.breaker.client.BalanceAt(ctx, account, blockNumber)
+	c.breaker.record(err)
+	return balance, err
+}
+
+func (c *breakerClient) CodeAt(ctx context.Context, account ethcom.Address, blockNumber *big.Int) ([]byte, error) {
+	if err := c.breaker.allow(); err != nil {
+		return nil, err
+	}
+	code, err := c.breaker.client.CodeAt(ctx, account, blockNumber)
+	c.breaker.record(err)
+	return code, err
+}
+
+// GetChainHealth returns the state of the circuit breakers of the chain rpcs
+func (engine *SwapEngine) GetChainHealth() []ChainHealth {
+	health := make([]ChainHealth, 0, len(engine.breakers))
+	for _, chain := range []string{common.ChainBSC, common.ChainETH, common.ChainMATIC} {
+		if breaker, ok := engine.breakers[chain]; ok {
+			health = append(health, breaker.Health())
+		}
+	}
+	return health
+}
+
+// isChainDegraded tells whether the circuit breaker of the chain rpc is open, the daemons skip the chain meanwhile
+func (engine *SwapEngine) isChainDegraded(chain string) bool {
+	breaker, ok := engine.breakers[chain]
+	return ok && breaker.IsOpen()
+}
+
+// getDegradedDirections returns the directions to the chains whose circuit breakers are open
+func (engine *SwapEngine) getDegradedDirections() []common.SwapDirection {
+	directions := make([]common.SwapDirection, 0)
+	for _, chain := range []string{common.ChainBSC, common.ChainETH, common.ChainMATIC} {
+		if engine.isChainDegraded(chain) {
+			directions = append(directions, getDirectionsToChain(chain)...)
+		}
+	}
+	return directions
+}
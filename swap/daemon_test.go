@@ -0,0 +1,1240 @@
+package swap
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"occ-swap-server/common"
+	"occ-swap-server/events"
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+var (
+	testSponsor = ethcom.HexToAddress("0x7e5f4552091a69125d5dfcb7b8c2659029395bdf").String()
+	testBEP20   = ethcom.HexToAddress("0x3000000000000000000000000000000000000003").String()
+	testERC20   = ethcom.HexToAddress("0x4000000000000000000000000000000000000004").String()
+)
+
+// newTestEngine returns an engine without chains whose daemons talk to the memory store
+func newTestEngine(store *MemoryStore) *SwapEngine {
+	engine := &SwapEngine{
+		hmacCKey:  "test",
+		config:    &util.Config{},
+		liquidity: make(map[string]*Liquidity),
+	}
+	engine.SetStore(store)
+	return engine
+}
+
+// newTestSwap returns a swap of the engine, its record hash matches
+func newTestSwap(engine *SwapEngine, id uint, status common.SwapStatus, direction common.SwapDirection, amount string) model.Swap {
+	swap := model.Swap{
+		Status:      status,
+		Sponsor:     testSponsor,
+		BEP20Addr:   testBEP20,
+		ERC20Addr:   testERC20,
+		Symbol:      "TEST",
+		Amount:      amount,
+		Decimals:    18,
+		Direction:   direction,
+		StartTxHash: ethcom.BigToHash(big.NewInt(int64(id))).String(),
+		AssetType:   common.AssetTypeFungible,
+		ToChainId:   "4",
+	}
+	swap.ID = id
+	swap.CreatedAt = time.Now()
+	swap.RecordHash = engine.getSwapHMAC(&swap)
+	return swap
+}
+
+// receiptClient returns the receipts of the mined txs, the txs themselves can't be queried
+type receiptClient struct {
+	ChainClient
+	receipts map[ethcom.Hash]*types.Receipt
+}
+
+func (c *receiptClient) TransactionReceipt(ctx context.Context, txHash ethcom.Hash) (*types.Receipt, error) {
+	if receipt, ok := c.receipts[txHash]; ok {
+		return receipt, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func (c *receiptClient) TransactionByHash(ctx context.Context, txHash ethcom.Hash) (*types.Transaction, bool, error) {
+	return nil, false, errors.New("connection refused")
+}
+
+func TestTrackSentSwapTxWithoutRevertReason(t *testing.T) {
+	store := NewMemoryStore()
+	engine := newTestEngine(store)
+	engine.config.ChainConfig.ETHMaxTrackRetry = 3
+
+	fillTxHash := ethcom.BigToHash(big.NewInt(100))
+	engine.ethClient = &receiptClient{receipts: map[ethcom.Hash]*types.Receipt{
+		fillTxHash: {Status: TxFailedStatus, TxHash: fillTxHash, BlockNumber: big.NewInt(10), GasUsed: 21000},
+	}}
+	engine.heads = map[string]*headTracker{
+		common.ChainETH: {chain: common.ChainETH, interval: time.Minute, height: 20, updateTime: time.Now()},
+	}
+
+	swap := newTestSwap(engine, 1, SwapSent, SwapBSC2Eth, "100")
+	swap.FillTxHash = fillTxHash.String()
+	swap.RecordHash = engine.getSwapHMAC(&swap)
+	store.Tables(func(tables *MemoryTables) {
+		tables.Swaps = []model.Swap{swap}
+		tables.FillTxs = []model.SwapFillTx{{SwapID: swap.ID, Direction: SwapBSC2Eth, FillSwapTxHash: swap.FillTxHash,
+			Status: model.FillTxSent, GasPrice: "1"}}
+		tables.FillTxs[0].ID = 1
+	})
+
+	if err := engine.trackSentSwapTxDaemon(common.ChainETH); err != nil {
+		t.Fatal(err)
+	}
+
+	// the failed receipt is final, it is recorded even if the revert reason can't be looked up
+	store.Tables(func(tables *MemoryTables) {
+		if swapTx := tables.FillTxs[0]; swapTx.Status != model.FillTxFailed || swapTx.TrackRetryCounter != 0 ||
+			swapTx.RevertReason != "" || swapTx.Height != 10 {
+			t.Errorf("fill tx is %d at %d after %d tracks, revert reason %q", swapTx.Status, swapTx.Height,
+				swapTx.TrackRetryCounter, swapTx.RevertReason)
+		}
+		if swap := tables.Swaps[0]; swap.Status != SwapSendFailed || swap.FailureClass != common.FailureRevert {
+			t.Errorf("swap is %s of failure %s", swap.Status, swap.FailureClass)
+		}
+	})
+}
+
+func TestAutoRetryFailedSwapsDaemon(t *testing.T) {
+	store := NewMemoryStore()
+	engine := newTestEngine(store)
+
+	due := newTestSwap(engine, 1, SwapSendFailed, SwapBSC2Eth, "100")
+	due.NextRetryAt = time.Now().Add(-time.Minute).Unix()
+	permanent := newTestSwap(engine, 2, SwapSendFailed, SwapBSC2Eth, "100")
+	permanent.NextRetryAt = time.Now().Add(-time.Minute).Unix()
+	permanent.FailureCategory = common.FailurePermanent
+	notDue := newTestSwap(engine, 3, SwapSendFailed, SwapBSC2Eth, "100")
+	notDue.NextRetryAt = time.Now().Add(time.Hour).Unix()
+	retried := newTestSwap(engine, 4, SwapSendFailed, SwapBSC2Eth, "100")
+	retried.NextRetryAt = time.Now().Add(-time.Minute).Unix()
+	store.Tables(func(tables *MemoryTables) {
+		tables.Swaps = []model.Swap{due, permanent, notDue, retried}
+		tables.RetrySwaps = []model.RetrySwap{{SwapID: retried.ID, Status: RetrySwapSent}}
+	})
+
+	if err := engine.autoRetryFailedSwapsDaemon(); err != nil {
+		t.Fatal(err)
+	}
+
+	store.Tables(func(tables *MemoryTables) {
+		retrySwaps := make(map[uint]int)
+		for _, retrySwap := range tables.RetrySwaps {
+			retrySwaps[retrySwap.SwapID]++
+		}
+		if retrySwaps[due.ID] != 1 || retrySwaps[retried.ID] != 1 || len(retrySwaps) != 2 {
+			t.Errorf("got retry swaps %v, want one of swap %d and the former one of swap %d", retrySwaps, due.ID, retried.ID)
+		}
+		for _, swap := range tables.Swaps {
+			if !engine.verifySwap(&swap) {
+				t.Errorf("record hash of swap %d doesn't match", swap.ID)
+			}
+			switch swap.ID {
+			case due.ID:
+				if swap.RetryAttempts != 1 || swap.NextRetryAt != 0 {
+					t.Errorf("due swap has %d attempts, next retry at %d", swap.RetryAttempts, swap.NextRetryAt)
+				}
+			case retried.ID:
+				if swap.RetryAttempts != 0 || swap.NextRetryAt != 0 {
+					t.Errorf("retried swap has %d attempts, next retry at %d", swap.RetryAttempts, swap.NextRetryAt)
+				}
+			case permanent.ID, notDue.ID:
+				if swap.NextRetryAt == 0 {
+					t.Errorf("swap %d is retried", swap.ID)
+				}
+			}
+		}
+	})
+}
+
+func TestWebhookDeliveryDaemon(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(WebhookSignatureHeader) == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/down") {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	store := NewMemoryStore()
+	engine := newTestEngine(store)
+	engine.config.WebhookConfig.AllowPrivateUrls = true
+
+	later := time.Now().Add(time.Hour).Unix()
+	store.Tables(func(tables *MemoryTables) {
+		tables.Webhooks = []model.Webhook{{Url: server.URL + "/up", Secret: "a"}, {Url: server.URL + "/down", Secret: "b"}}
+		tables.Webhooks[0].ID, tables.Webhooks[1].ID = 1, 2
+		tables.WebhookDeliveries = []model.WebhookDelivery{
+			{WebhookID: 1, Status: model.WebhookDeliveryPending, Payload: "{}"},
+			{WebhookID: 2, Status: model.WebhookDeliveryPending, Payload: "{}"},
+			{WebhookID: 3, Status: model.WebhookDeliveryPending, Payload: "{}"},
+			{WebhookID: 1, Status: model.WebhookDeliveryPending, Payload: "{}", NextAttemptAt: later},
+		}
+		for i := range tables.WebhookDeliveries {
+			tables.WebhookDeliveries[i].ID = uint(i + 1)
+		}
+	})
+
+	if err := engine.webhookDeliveryDaemon()(); err != util.RunAgain {
+		t.Fatalf("got %v, want %v", err, util.RunAgain)
+	}
+
+	store.Tables(func(tables *MemoryTables) {
+		deliveries := tables.WebhookDeliveries
+		if d := deliveries[0]; d.Status != model.WebhookDeliverySuccess || d.Attempts != 1 || d.ResponseCode != http.StatusOK {
+			t.Errorf("delivery to the webhook up is %s after %d attempts, response %d", d.Status, d.Attempts, d.ResponseCode)
+		}
+		if d := deliveries[1]; d.Status != model.WebhookDeliveryPending || d.Attempts != 1 || d.NextAttemptAt <= time.Now().Unix() ||
+			d.LastError == "" {
+			t.Errorf("delivery to the webhook down is %s after %d attempts, next attempt at %d, error %q", d.Status,
+				d.Attempts, d.NextAttemptAt, d.LastError)
+		}
+		if d := deliveries[2]; d.Status != model.WebhookDeliveryFailed || d.LastError == "" {
+			t.Errorf("delivery to the unknown webhook is %s, error %q", d.Status, d.LastError)
+		}
+		if d := deliveries[3]; d.Status != model.WebhookDeliveryPending || d.Attempts != 0 || d.NextAttemptAt != later {
+			t.Errorf("delivery not due is %s after %d attempts", d.Status, d.Attempts)
+		}
+	})
+}
+
+func TestReleaseAwaitingLiquiditySwaps(t *testing.T) {
+	store := NewMemoryStore()
+	engine := newTestEngine(store)
+	engine.liquidity[common.ChainETH] = &Liquidity{Chain: common.ChainETH, available: big.NewInt(150)}
+
+	store.Tables(func(tables *MemoryTables) {
+		tables.Swaps = []model.Swap{
+			newTestSwap(engine, 1, SwapAwaitingLiquidity, SwapBSC2Eth, "100"),
+			newTestSwap(engine, 2, SwapAwaitingLiquidity, SwapBSC2Eth, "200"),
+			newTestSwap(engine, 3, SwapAwaitingLiquidity, SwapBSC2Eth, "100"),
+			newTestSwap(engine, 4, SwapAwaitingLiquidity, SwapEth2BSC, "100"),
+		}
+	})
+
+	engine.releaseAwaitingLiquiditySwaps(common.ChainETH)
+
+	// the swaps are released in order, the swap above the liquidity holds back the later ones
+	want := map[uint]common.SwapStatus{1: SwapConfirmed, 2: SwapAwaitingLiquidity, 3: SwapAwaitingLiquidity, 4: SwapAwaitingLiquidity}
+	store.Tables(func(tables *MemoryTables) {
+		for _, swap := range tables.Swaps {
+			if swap.Status != want[swap.ID] {
+				t.Errorf("swap %d is %s, want %s", swap.ID, swap.Status, want[swap.ID])
+			}
+			if !engine.verifySwap(&swap) {
+				t.Errorf("record hash of swap %d doesn't match", swap.ID)
+			}
+		}
+	})
+}
+
+func TestRebalanceDaemon(t *testing.T) {
+	store := NewMemoryStore()
+	engine := newTestEngine(store)
+	engine.config.RebalanceConfig.AutoExecute = true
+
+	store.Tables(func(tables *MemoryTables) {
+		tables.RebalancePlans = []model.RebalancePlan{
+			{Status: model.RebalancePlanExecuting, Transfers: "[]", Inventory: "[]"},
+			{Status: model.RebalancePlanApproved, Transfers: "not json", Inventory: "[]"},
+			{Status: model.RebalancePlanPending, Transfers: "[]", Inventory: "[]"},
+		}
+		for i := range tables.RebalancePlans {
+			tables.RebalancePlans[i].ID = uint(i + 1)
+		}
+	})
+
+	// no plan is proposed while the pending one is open
+	if err := engine.rebalanceDaemon(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []model.RebalancePlanStatus{model.RebalancePlanFailed, model.RebalancePlanFailed, model.RebalancePlanPending}
+	store.Tables(func(tables *MemoryTables) {
+		if len(tables.RebalancePlans) != len(want) {
+			t.Fatalf("got %d plans, want %d", len(tables.RebalancePlans), len(want))
+		}
+		for i, plan := range tables.RebalancePlans {
+			if plan.Status != want[i] {
+				t.Errorf("plan %d is %s, want %s", plan.ID, plan.Status, want[i])
+			}
+			if plan.Status == model.RebalancePlanFailed && plan.ErrorMsg == "" {
+				t.Errorf("plan %d is failed without an error", plan.ID)
+			}
+		}
+	})
+}
+
+func TestExecuteRebalancePlanOfAnotherExecutor(t *testing.T) {
+	store := NewMemoryStore()
+	engine := newTestEngine(store)
+
+	plan := model.RebalancePlan{Status: model.RebalancePlanApproved, Transfers: "[]", Inventory: "[]"}
+	plan.ID = 1
+	store.Tables(func(tables *MemoryTables) {
+		executing := plan
+		executing.Status = model.RebalancePlanExecuting
+		tables.RebalancePlans = []model.RebalancePlan{executing}
+	})
+
+	// the plan was read approved, another replica moved it to executing meanwhile
+	engine.executeRebalancePlan(&plan)
+
+	store.Tables(func(tables *MemoryTables) {
+		if status := tables.RebalancePlans[0].Status; status != model.RebalancePlanExecuting {
+			t.Errorf("plan is %s, want %s", status, model.RebalancePlanExecuting)
+		}
+	})
+}
+
+func TestFailRelayedSwap(t *testing.T) {
+	store := NewMemoryStore()
+	engine := newTestEngine(store)
+
+	relayedSwap := model.RelayedSwap{Chain: common.ChainBSC, Owner: testSponsor, Status: model.RelayedSwapPulling, Amount: "100"}
+	relayedSwap.ID = 1
+	store.Tables(func(tables *MemoryTables) {
+		tables.RelayedSwaps = []model.RelayedSwap{relayedSwap}
+	})
+
+	engine.failRelayedSwap(&relayedSwap, "tx is failed")
+
+	relayedSwaps, err := store.FindRelayedSwaps(openRelayedSwapStatuses, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(relayedSwaps) != 0 {
+		t.Errorf("got %d open relayed swaps, want 0", len(relayedSwaps))
+	}
+	store.Tables(func(tables *MemoryTables) {
+		if saved := tables.RelayedSwaps[0]; saved.Status != model.RelayedSwapFailed || saved.ErrorMsg != "tx is failed" {
+			t.Errorf("relayed swap is %s, error %q", saved.Status, saved.ErrorMsg)
+		}
+	})
+}
+
+func TestRepairSwapAddresses(t *testing.T) {
+	store := NewMemoryStore()
+	engine := newTestEngine(store)
+
+	lowercase := newTestSwap(engine, 1, SwapSuccess, SwapBSC2Eth, "100")
+	lowercase.Sponsor = strings.ToLower(lowercase.Sponsor)
+	lowercase.RecordHash = engine.getSwapHMAC(&lowercase)
+	tampered := newTestSwap(engine, 2, SwapSuccess, SwapBSC2Eth, "100")
+	tampered.Sponsor = strings.ToLower(tampered.Sponsor)
+	invalid := newTestSwap(engine, 3, SwapSuccess, SwapBSC2Eth, "100")
+	invalid.Sponsor = "not an address"
+	invalid.RecordHash = engine.getSwapHMAC(&invalid)
+	store.Tables(func(tables *MemoryTables) {
+		tables.Swaps = []model.Swap{lowercase, tampered, invalid}
+	})
+
+	report := &repairReport{}
+	engine.repairSwapAddresses(report)
+
+	if report.repaired != 1 || report.unverified != 1 || report.invalid != 1 {
+		t.Errorf("got %+v, want 1 repaired, 1 unverified and 1 invalid", *report)
+	}
+	store.Tables(func(tables *MemoryTables) {
+		if swap := tables.Swaps[0]; swap.Sponsor != testSponsor || !engine.verifySwap(&swap) {
+			t.Errorf("repaired swap has sponsor %s", swap.Sponsor)
+		}
+		if swap := tables.Swaps[1]; swap.Sponsor == testSponsor {
+			t.Errorf("swap whose record hash doesn't match is repaired")
+		}
+	})
+}
+
+func TestRepairEnums(t *testing.T) {
+	store := NewMemoryStore()
+	engine := newTestEngine(store)
+
+	upper := newTestSwap(engine, 1, "SENT_SUCCESS", "BSC_ETH", "100")
+	unknown := newTestSwap(engine, 2, "lost", SwapBSC2Eth, "100")
+	store.Tables(func(tables *MemoryTables) {
+		tables.Swaps = []model.Swap{upper, unknown, newTestSwap(engine, 3, SwapSuccess, SwapBSC2Eth, "100")}
+		tables.FillTxs = []model.SwapFillTx{{Direction: "BSC_ETH"}, {Direction: "BSC_ETH"}, {Direction: SwapEth2BSC}}
+		for i := range tables.FillTxs {
+			tables.FillTxs[i].ID = uint(i + 1)
+		}
+	})
+
+	report := &repairReport{}
+	engine.repairSwapEnums(report)
+	engine.repairSwapFillTxDirections(report)
+
+	// the swap and the two fill txs are repaired, the swap of the unknown status is left
+	if report.repaired != 3 || report.invalid != 1 {
+		t.Errorf("got %+v, want 3 repaired and 1 invalid", *report)
+	}
+	store.Tables(func(tables *MemoryTables) {
+		if swap := tables.Swaps[0]; swap.Status != SwapSuccess || swap.Direction != SwapBSC2Eth || !engine.verifySwap(&swap) {
+			t.Errorf("repaired swap is %s of %s", swap.Status, swap.Direction)
+		}
+		for _, swapTx := range tables.FillTxs {
+			if !swapTx.Direction.Valid() {
+				t.Errorf("fill tx %d is of direction %s", swapTx.ID, swapTx.Direction)
+			}
+		}
+	})
+}
+
+func TestDrainBrakeOfReplicas(t *testing.T) {
+	store := NewMemoryStore()
+	// two replicas sharing the store, each sends half of the ceiling
+	first, second := newDrainBrake(util.DrainBrakeConfig{}, store), newDrainBrake(util.DrainBrakeConfig{}, store)
+	key := drainKey{chain: common.ChainBSC, account: ethcom.HexToAddress(testSponsor), token: nativeSpend}
+	ceiling := big.NewInt(100)
+
+	first.record(key, big.NewInt(60), ceiling)
+	if first.IsHalted(common.ChainBSC) {
+		t.Fatal("chain is halted below the ceiling")
+	}
+	second.record(key, big.NewInt(60), ceiling)
+	if !first.IsHalted(common.ChainBSC) || !second.IsHalted(common.ChainBSC) {
+		t.Fatal("chain is not halted above the ceiling summed over the replicas")
+	}
+
+	if err := first.Release(common.ChainBSC, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if second.IsHalted(common.ChainBSC) {
+		t.Error("chain is halted after the release")
+	}
+	if err := second.Release(common.ChainBSC, "test"); err == nil {
+		t.Error("chain is released twice")
+	}
+	// the spend starts again from 0 after the release
+	second.record(key, big.NewInt(60), ceiling)
+	if second.IsHalted(common.ChainBSC) {
+		t.Error("chain is halted by the spends before the release")
+	}
+}
+
+func TestKeepSwapClaimDuringBroadcast(t *testing.T) {
+	store := NewMemoryStore()
+	engine := newTestEngine(store)
+	engine.instanceID = "replica-a"
+	defer func(interval time.Duration) { swapClaimRenewInterval = interval }(swapClaimRenewInterval)
+	swapClaimRenewInterval = 50 * time.Millisecond
+
+	swap := newTestSwap(engine, 1, SwapSending, SwapBSC2Eth, "100")
+	swap.ClaimedBy, swap.ClaimedUntil = engine.instanceID, time.Now().Unix()+1
+	store.Tables(func(tables *MemoryTables) {
+		tables.Swaps = []model.Swap{swap}
+	})
+
+	// the broadcast outlives the lease the swap is claimed with
+	stopClaim := engine.keepSwapClaim(&swap)
+	time.Sleep(2100 * time.Millisecond)
+
+	other, err := store.ClaimFillableSwaps(FillableSwapQuery{Directions: []common.SwapDirection{SwapBSC2Eth},
+		InstanceID: "replica-b", Limit: BatchSize}, time.Now().Add(SwapClaimLease).Unix())
+	stopClaim()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(other) != 0 {
+		t.Fatalf("another replica claimed the swap being broadcast")
+	}
+	if swap.ClaimedUntil <= time.Now().Unix() {
+		t.Errorf("claim of the swap is not moved to the renewal")
+	}
+
+	swapTx := model.SwapFillTx{SwapID: swap.ID, Direction: swap.Direction, FillSwapTxHash: ethcom.BigToHash(big.NewInt(1)).String(),
+		Status: model.FillTxCreated}
+	if err := store.CreateFillTx(&swapTx); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.saveFilledSwap(&swap, &swapTx, nil); err != nil {
+		t.Fatal(err)
+	}
+	store.Tables(func(tables *MemoryTables) {
+		if saved := tables.Swaps[0]; saved.Status != SwapSent || saved.FillTxHash != swapTx.FillSwapTxHash {
+			t.Errorf("swap is %s with fill tx %s", saved.Status, saved.FillTxHash)
+		}
+	})
+}
+
+func TestSaveFilledSwapOfLostClaim(t *testing.T) {
+	store := NewMemoryStore()
+	engine := newTestEngine(store)
+	engine.instanceID = "replica-a"
+
+	swap := newTestSwap(engine, 1, SwapSending, SwapBSC2Eth, "100")
+	swap.ClaimedBy, swap.ClaimedUntil = engine.instanceID, time.Now().Unix()-1
+	store.Tables(func(tables *MemoryTables) {
+		// the lease expired mid-broadcast and another replica claimed the swap
+		claimed := swap
+		claimed.ClaimedBy, claimed.ClaimedUntil = "replica-b", time.Now().Add(SwapClaimLease).Unix()
+		tables.Swaps = []model.Swap{claimed}
+	})
+	swapTx := model.SwapFillTx{SwapID: swap.ID, Direction: swap.Direction, FillSwapTxHash: ethcom.BigToHash(big.NewInt(1)).String(),
+		Status: model.FillTxCreated}
+	if err := store.CreateFillTx(&swapTx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := engine.saveFilledSwap(&swap, &swapTx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	store.Tables(func(tables *MemoryTables) {
+		if saved := tables.Swaps[0]; saved.Status != SwapSending || saved.ClaimedBy != "replica-b" {
+			t.Errorf("swap claimed by another replica is saved as %s by %s", saved.Status, saved.ClaimedBy)
+		}
+		if status := tables.FillTxs[0].Status; status != model.FillTxCreated {
+			t.Errorf("fill tx is %d, want %d", status, model.FillTxCreated)
+		}
+	})
+}
+
+func TestReleaseDelayedSwapsDaemon(t *testing.T) {
+	store := NewMemoryStore()
+	engine := newTestEngine(store)
+
+	due := newTestSwap(engine, 1, SwapDelayed, SwapBSC2Eth, "100")
+	due.DelayedUntil = time.Now().Add(-time.Minute).Unix()
+	due.RecordHash = engine.getSwapHMAC(&due)
+	notDue := newTestSwap(engine, 2, SwapDelayed, SwapBSC2Eth, "100")
+	notDue.DelayedUntil = time.Now().Add(time.Hour).Unix()
+	notDue.RecordHash = engine.getSwapHMAC(&notDue)
+	store.Tables(func(tables *MemoryTables) {
+		tables.Swaps = []model.Swap{due, notDue}
+	})
+
+	if err := engine.releaseDelayedSwapsDaemon(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[uint]common.SwapStatus{due.ID: SwapConfirmed, notDue.ID: SwapDelayed}
+	store.Tables(func(tables *MemoryTables) {
+		for _, swap := range tables.Swaps {
+			if swap.Status != want[swap.ID] {
+				t.Errorf("swap %d is %s, want %s", swap.ID, swap.Status, want[swap.ID])
+			}
+		}
+	})
+}
+
+func TestCheckSendingSwaps(t *testing.T) {
+	store := NewMemoryStore()
+	engine := newTestEngine(store)
+	engine.claimedSwaps = make(map[uint]bool)
+
+	withoutFillTx := newTestSwap(engine, 1, SwapSending, SwapBSC2Eth, "100")
+	withFillTx := newTestSwap(engine, 2, SwapSending, SwapBSC2Eth, "100")
+	recent := newTestSwap(engine, 3, SwapSending, SwapBSC2Eth, "100")
+	withoutFillTx.UpdatedAt = time.Now().Add(-time.Hour)
+	withFillTx.UpdatedAt = time.Now().Add(-time.Hour)
+	recent.UpdatedAt = time.Now()
+	fillTxHash := ethcom.BigToHash(big.NewInt(100)).String()
+	store.Tables(func(tables *MemoryTables) {
+		tables.Swaps = []model.Swap{withoutFillTx, withFillTx, recent}
+		tables.FillTxs = []model.SwapFillTx{{SwapID: withFillTx.ID, Direction: SwapBSC2Eth, FillSwapTxHash: fillTxHash,
+			Status: model.FillTxCreated}}
+		tables.FillTxs[0].ID = 1
+	})
+
+	if stuck := engine.checkSendingSwaps(time.Now().Add(-time.Minute)); len(stuck) != 0 {
+		t.Errorf("got %d stuck swaps, want 0", len(stuck))
+	}
+
+	want := map[uint]common.SwapStatus{withoutFillTx.ID: SwapConfirmed, withFillTx.ID: SwapSent, recent.ID: SwapSending}
+	store.Tables(func(tables *MemoryTables) {
+		for _, swap := range tables.Swaps {
+			if swap.Status != want[swap.ID] {
+				t.Errorf("swap %d is %s, want %s", swap.ID, swap.Status, want[swap.ID])
+			}
+			if !engine.verifySwap(&swap) {
+				t.Errorf("record hash of swap %d doesn't match", swap.ID)
+			}
+		}
+		if swap := tables.Swaps[1]; swap.FillTxHash != fillTxHash {
+			t.Errorf("swap is sent by %s, want %s", swap.FillTxHash, fillTxHash)
+		}
+		if swapTx := tables.FillTxs[0]; swapTx.Status != model.FillTxSent {
+			t.Errorf("fill tx is %d, want %d", swapTx.Status, model.FillTxSent)
+		}
+	})
+}
+
+func TestExpireSwaps(t *testing.T) {
+	store := NewMemoryStore()
+	engine := newTestEngine(store)
+
+	cutoff := time.Now().Add(-time.Hour)
+	expired := newTestSwap(engine, 1, SwapConfirmed, SwapBSC2Eth, "100")
+	retried := newTestSwap(engine, 2, SwapSendFailed, SwapBSC2Eth, "100")
+	claimed := newTestSwap(engine, 3, SwapConfirmed, SwapBSC2Eth, "100")
+	claimed.ClaimedBy, claimed.ClaimedUntil = "replica-b", time.Now().Add(time.Minute).Unix()
+	recent := newTestSwap(engine, 4, SwapConfirmed, SwapBSC2Eth, "100")
+	swaps := []model.Swap{expired, retried, claimed, recent}
+	for i := range swaps[:3] {
+		swaps[i].CreatedAt = cutoff.Add(-time.Minute)
+	}
+	store.Tables(func(tables *MemoryTables) {
+		tables.Swaps = swaps
+		tables.RetrySwaps = []model.RetrySwap{{SwapID: retried.ID, Status: RetrySwapSending}}
+	})
+
+	n, err := engine.expireSwaps(cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expired %d swaps, want 1", n)
+	}
+	want := map[uint]common.SwapStatus{expired.ID: SwapExpired, retried.ID: SwapSendFailed, claimed.ID: SwapConfirmed, recent.ID: SwapConfirmed}
+	store.Tables(func(tables *MemoryTables) {
+		for _, swap := range tables.Swaps {
+			if swap.Status != want[swap.ID] {
+				t.Errorf("swap %d is %s, want %s", swap.ID, swap.Status, want[swap.ID])
+			}
+		}
+	})
+}
+
+func TestRejectTamperedSwap(t *testing.T) {
+	store := NewMemoryStore()
+	engine := newTestEngine(store)
+	engine.claimedSwaps = make(map[uint]bool)
+
+	tampered := newTestSwap(engine, 1, SwapConfirmed, SwapBSC2Eth, "100")
+	tampered.Amount = "1000000"
+	tampered.CreatedAt = time.Now().Add(-time.Hour)
+	store.Tables(func(tables *MemoryTables) {
+		tables.Swaps = []model.Swap{tampered}
+	})
+
+	engine.fillSwapInstance(common.ChainETH, tampered)
+	rejected, err := store.GetSwap(tampered.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rejected.Status != SwapRejected {
+		t.Fatalf("tampered swap is %s, want %s", rejected.Status, SwapRejected)
+	}
+	if !rejected.Status.Valid() {
+		t.Errorf("status %s is not in the registry", rejected.Status)
+	}
+
+	if n, err := engine.expireSwaps(time.Now()); err != nil || n != 0 {
+		t.Errorf("expired %d swaps, err %v, want the rejected swap kept", n, err)
+	}
+	retried, rejectedIDs, err := engine.InsertRetryFailedSwaps([]uint{tampered.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(retried) != 0 || len(rejectedIDs) != 1 {
+		t.Errorf("retried %v, rejected %v, want the rejected swap not retried", retried, rejectedIDs)
+	}
+}
+
+func TestClaimRetrySwapsOfReplicas(t *testing.T) {
+	store := NewMemoryStore()
+	first, second := newTestEngine(store), newTestEngine(store)
+	first.instanceID, second.instanceID = "replica-a", "replica-b"
+	first.drainBrake = newDrainBrake(util.DrainBrakeConfig{}, store)
+	second.drainBrake = newDrainBrake(util.DrainBrakeConfig{}, store)
+
+	store.Tables(func(tables *MemoryTables) {
+		tables.RetrySwaps = []model.RetrySwap{
+			{SwapID: 1, Direction: SwapBSC2Eth, Status: RetrySwapConfirmed},
+			{SwapID: 2, Direction: SwapBSC2Eth, Status: RetrySwapSent},
+		}
+		for i := range tables.RetrySwaps {
+			tables.RetrySwaps[i].ID = uint(i + 1)
+		}
+	})
+
+	if retrySwaps := first.getRetryableSwaps(); len(retrySwaps) != 1 || retrySwaps[0].ID != 1 {
+		t.Fatalf("first replica claims %v, want retry swap 1", retrySwaps)
+	}
+	if retrySwaps := second.getRetryableSwaps(); len(retrySwaps) != 0 {
+		t.Fatalf("second replica claims %d retry swaps of the first one", len(retrySwaps))
+	}
+	if err := store.ReleaseRetrySwapClaim(1, first.instanceID); err != nil {
+		t.Fatal(err)
+	}
+	if retrySwaps := second.getRetryableSwaps(); len(retrySwaps) != 1 || retrySwaps[0].ClaimedBy != second.instanceID {
+		t.Errorf("second replica claims %v after the release, want retry swap 1", retrySwaps)
+	}
+}
+
+// startTxHashAbi is a swap agent abi whose SwapFilled event carries the start tx hash
+const startTxHashAbi = `[{"anonymous":false,"inputs":[
+{"indexed":false,"name":"fromChainId","type":"uint256"},
+{"indexed":true,"name":"toChainId","type":"uint256"},
+{"indexed":true,"name":"fromAddress","type":"address"},
+{"indexed":true,"name":"startTxHash","type":"bytes32"},
+{"indexed":false,"name":"amount","type":"uint256"}],"name":"SwapFilled","type":"event"},
+{"anonymous":false,"inputs":[
+{"indexed":false,"name":"fromChainId","type":"uint256"},
+{"indexed":true,"name":"toChainId","type":"uint256"},
+{"indexed":true,"name":"fromAddress","type":"address"},
+{"indexed":true,"name":"amount","type":"uint256"}],"name":"SwapStarted","type":"event"}]`
+
+// newFilledReceipt sets the SwapFilled event decoder of eth to the engine, and returns a builder of the receipts of
+// the fill txs of the swap which emit the event with the start tx hash
+func newFilledReceipt(t *testing.T, engine *SwapEngine, swap *model.Swap) func(startTxHash ethcom.Hash) *types.Receipt {
+	dir, err := ioutil.TempDir("", "swap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	abiFile := filepath.Join(dir, "v2.json")
+	if err := ioutil.WriteFile(abiFile, []byte(startTxHashAbi), 0600); err != nil {
+		t.Fatal(err)
+	}
+	decoder, err := events.NewDecoder(common.ChainETH, []util.SwapAgentAbiConfig{{Version: "v2", AbiFile: abiFile}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	agentAbi, err := abi.JSON(strings.NewReader(startTxHashAbi))
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.eventDecoders = map[string]*events.Decoder{common.ChainETH: decoder}
+
+	return func(startTxHash ethcom.Hash) *types.Receipt {
+		event := agentAbi.Events["SwapFilled"]
+		data, err := event.Inputs.NonIndexed().Pack(big.NewInt(0), big.NewInt(100))
+		if err != nil {
+			t.Fatal(err)
+		}
+		toChainId, _ := new(big.Int).SetString(swap.ToChainId, 10)
+		log := &types.Log{
+			Address: engine.getSwapAgent(common.ChainETH),
+			Topics:  []ethcom.Hash{event.ID(), ethcom.BigToHash(toChainId), ethcom.HexToAddress(swap.GetRecipient()).Hash(), startTxHash},
+			Data:    data,
+		}
+		return &types.Receipt{Status: types.ReceiptStatusSuccessful, BlockNumber: big.NewInt(1), TxHash: ethcom.HexToHash(swap.FillTxHash), Logs: []*types.Log{log}}
+	}
+}
+
+func TestVerifySwapFilledEventStartTxHash(t *testing.T) {
+	engine := newTestEngine(NewMemoryStore())
+	swap := newTestSwap(engine, 1, SwapSending, SwapBSC2Eth, "100")
+	swap.FillTxHash = ethcom.HexToHash("0xf1").String()
+	filledReceipt := newFilledReceipt(t, engine, &swap)
+
+	if mismatch := engine.verifySwapFilledEvent(filledReceipt(ethcom.HexToHash(swap.StartTxHash)), &swap, swap.FillTxHash); mismatch != "" {
+		t.Errorf("fill of the swap is a mismatch: %s", mismatch)
+	}
+	if mismatch := engine.verifySwapFilledEvent(filledReceipt(ethcom.HexToHash("0x02")), &swap, swap.FillTxHash); !strings.Contains(mismatch, "start tx hash") {
+		t.Errorf("fill of another start tx got mismatch %q, want a start tx hash mismatch", mismatch)
+	}
+}
+
+// revertClient replays the failed tx with the revert payload
+type revertClient struct {
+	ChainClient
+	tx     *types.Transaction
+	result []byte
+}
+
+func (c *revertClient) TransactionByHash(ctx context.Context, txHash ethcom.Hash) (*types.Transaction, bool, error) {
+	return c.tx, false, nil
+}
+
+func (c *revertClient) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return c.result, nil
+}
+
+func TestRevertReasonIsBestEffort(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := types.SignTx(types.NewTransaction(0, ethcom.HexToAddress(testBEP20), big.NewInt(0), 100000, big.NewInt(1), nil),
+		types.NewEIP155Signer(big.NewInt(56)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stringType, _ := abi.NewType("string", "", nil)
+	payload, err := abi.Arguments{{Type: stringType}}.Pack("insufficient liquidity")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &revertClient{tx: tx, result: append(append([]byte{}, revertReasonSelector...), payload...)}
+	reason, err := getRevertReason(client, tx.Hash(), big.NewInt(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := bestEffortRevertPrefix + "insufficient liquidity"; reason != want {
+		t.Errorf("got revert reason %q, want %q", reason, want)
+	}
+}
+
+// testStoreDialects returns the gorm stores to run a store test on, sqlite always, postgres if TEST_POSTGRES_DSN is set
+func testStoreDialects(t *testing.T) map[string]*GormStore {
+	stores := make(map[string]*GormStore)
+	dsns := map[string]string{common.DBDialectSqlite3: model.SqliteInMemoryPath}
+	if dsn := os.Getenv("TEST_POSTGRES_DSN"); dsn != "" {
+		dsns[common.DBDialectPostgres] = dsn
+	}
+	for dialect, dsn := range dsns {
+		db, err := model.OpenDB(dialect, dsn)
+		if err != nil {
+			t.Fatalf("open %s db: %v", dialect, err)
+		}
+		t.Cleanup(func() { db.Close() })
+		stores[dialect] = NewGormStore(db)
+	}
+	return stores
+}
+
+func TestGormStoreUpdatedAtOfTxs(t *testing.T) {
+	for dialect, store := range testStoreDialects(t) {
+		t.Run(dialect, func(t *testing.T) {
+			fillTx := &model.SwapFillTx{
+				Direction:       common.SwapDirectionBSC2ETH,
+				StartSwapTxHash: ethcom.BigToHash(big.NewInt(1)).String(),
+				FillSwapTxHash:  ethcom.BigToHash(big.NewInt(2)).String(),
+				GasPrice:        "1",
+				Status:          model.FillTxCreated,
+			}
+			if err := store.CreateFillTx(fillTx); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.SetFillTxStatus(fillTx.ID, model.FillTxSent); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.IncrFillTxTrackRetry(fillTx.ID); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.SetFillTxRebroadcast(fillTx.ID, 1, true); err != nil {
+				t.Fatal(err)
+			}
+			if moved, err := store.MoveFillTxStatus(fillTx.ID, model.FillTxSent, model.FillTxSent); err != nil || !moved {
+				t.Fatalf("move fill tx status: %v %v", moved, err)
+			}
+			if err := store.SetFillTxReceipt(fillTx.ID, model.FillTxSuccess, 10, "1", ""); err != nil {
+				t.Fatal(err)
+			}
+			savedFillTx, err := store.GetLastFillTxOfSwap(0, model.FillTxSuccess)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if savedFillTx.UpdatedAt.Before(fillTx.CreatedAt) {
+				t.Fatalf("fill tx updated at %v before created at %v", savedFillTx.UpdatedAt, fillTx.CreatedAt)
+			}
+
+			retryTx := &model.RetrySwapTx{
+				RetrySwapID:         1,
+				StartTxHash:         ethcom.BigToHash(big.NewInt(3)).String(),
+				Direction:           common.SwapDirectionBSC2ETH,
+				RetryFillSwapTxHash: ethcom.BigToHash(big.NewInt(4)).String(),
+				Status:              model.FillRetryTxCreated,
+			}
+			if err := store.CreateRetrySwapTx(retryTx); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.SetRetrySwapTxStatus(retryTx.ID, model.FillRetryTxSent, ""); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.IncrRetrySwapTxTrackRetry(retryTx.ID); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.SetRetrySwapTxReceipt(retryTx.ID, model.FillRetryTxSuccess, 10, "1", ""); err != nil {
+				t.Fatal(err)
+			}
+			savedRetryTx, err := store.GetLastRetrySwapTx(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if savedRetryTx.Status != model.FillRetryTxSuccess || savedRetryTx.UpdatedAt.Before(retryTx.CreatedAt) {
+				t.Fatalf("retry tx %v updated at %v, created at %v", savedRetryTx.Status, savedRetryTx.UpdatedAt, retryTx.CreatedAt)
+			}
+			// sqlite takes any value into a timestamp column, so check the stored type, postgres rejects a bigint itself
+			if dialect == common.DBDialectSqlite3 {
+				for _, table := range []string{"swap_fill_txs", "retry_swap_txs"} {
+					var kind string
+					if err := store.db.Raw("select typeof(updated_at) from " + table).Row().Scan(&kind); err != nil {
+						t.Fatal(err)
+					}
+					if kind == "integer" {
+						t.Fatalf("updated_at of %s is stored as %s", table, kind)
+					}
+				}
+			}
+		})
+	}
+}
+
+// sendClient accepts every tx, its pending nonce lags behind the txs sent by the replicas
+type sendClient struct {
+	ChainClient
+	sent []*types.Transaction
+}
+
+func (c *sendClient) PendingNonceAt(ctx context.Context, account ethcom.Address) (uint64, error) {
+	return 0, nil
+}
+
+func (c *sendClient) NonceAt(ctx context.Context, account ethcom.Address, blockNumber *big.Int) (uint64, error) {
+	return 0, nil
+}
+
+func (c *sendClient) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(1), nil
+}
+
+func (c *sendClient) EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error) {
+	return SelfTransferGasLimit, nil
+}
+
+func (c *sendClient) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	c.sent = append(c.sent, tx)
+	return nil
+}
+
+func TestBroadcastersOfReplicasReserveNonces(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryStore()
+	client := &sendClient{}
+	newReplica := func(instanceID string) *Broadcaster {
+		b := NewBroadcaster(common.ChainETH, client, key, big.NewInt(4), util.ExplorerTemplates{}, 1, time.Minute,
+			util.GasLimitPolicy{Multiplier: 1, Max: SelfTransferGasLimit, Fallback: SelfTransferGasLimit})
+		b.OnReserveNonce(func(account ethcom.Address, nonce uint64) (bool, error) {
+			return store.ReserveRelayerNonce(common.ChainETH, account.String(), nonce, instanceID)
+		})
+		return b
+	}
+	a, b := newReplica("a"), newReplica("b")
+
+	req := &broadcastRequest{contract: ethcom.HexToAddress(testERC20), data: []byte{1}}
+	txA, err := a.send(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txB, err := b.send(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txA.Nonce() != 0 || txB.Nonce() != 1 {
+		t.Fatalf("replicas sent nonces %d and %d, want 0 and 1", txA.Nonce(), txB.Nonce())
+	}
+
+	// the nonce of the tx of replica a is pending, replica b never replaces it
+	replacement, err := b.replaceUnderpriced(req, txA)
+	if err != nil || replacement != nil {
+		t.Fatalf("replica b replaced the tx of replica a: %v %v", replacement, err)
+	}
+	if len(client.sent) != 2 {
+		t.Fatalf("%d txs sent, want 2", len(client.sent))
+	}
+}
+
+func TestMarkSwapFilledOfRecordedTx(t *testing.T) {
+	store := NewMemoryStore()
+	engine := newTestEngine(store)
+	swap := newTestSwap(engine, 1, SwapSendFailed, SwapBSC2Eth, "100")
+	swap.FillTxHash = ethcom.HexToHash("0xf1").String()
+	receipt := newFilledReceipt(t, engine, &swap)(ethcom.HexToHash(swap.StartTxHash))
+	engine.ethClient = &receiptClient{receipts: map[ethcom.Hash]*types.Receipt{receipt.TxHash: receipt}}
+	storedSwap := swap
+	storedSwap.FillTxHash = ""
+	store.Tables(func(tables *MemoryTables) {
+		tables.Swaps = append(tables.Swaps, storedSwap)
+		// the tx is a failed retry of another swap
+		tables.RetrySwapTxs = append(tables.RetrySwapTxs, model.RetrySwapTx{RetryFillSwapTxHash: swap.FillTxHash, Status: model.FillRetryTxFailed})
+	})
+
+	// the hash is checked whatever its case
+	err := engine.MarkSwapFilled(swap.StartTxHash, strings.ToUpper(swap.FillTxHash[2:]), "operator")
+	if err == nil || !strings.Contains(err.Error(), "invalid") {
+		t.Fatalf("marked with a hash without 0x prefix, err %v", err)
+	}
+	err = engine.MarkSwapFilled(swap.StartTxHash, "0x"+strings.ToUpper(swap.FillTxHash[2:]), "operator")
+	if err == nil || !strings.Contains(err.Error(), "already attached") {
+		t.Fatalf("marked with a recorded tx, err %v", err)
+	}
+	marked, err := store.GetSwap(swap.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if marked.Status != SwapSendFailed {
+		t.Fatalf("swap status %s, want %s", marked.Status, SwapSendFailed)
+	}
+
+	store.Tables(func(tables *MemoryTables) {
+		tables.RetrySwapTxs = nil
+	})
+	if err := engine.MarkSwapFilled(swap.StartTxHash, "0x"+strings.ToUpper(swap.FillTxHash[2:]), "operator"); err != nil {
+		t.Fatal(err)
+	}
+	if marked, err = store.GetSwap(swap.ID); err != nil {
+		t.Fatal(err)
+	}
+	if marked.Status != SwapSuccess || marked.FillTxHash != swap.FillTxHash {
+		t.Fatalf("swap %s filled by %s, want %s filled by %s", marked.Status, marked.FillTxHash, SwapSuccess, swap.FillTxHash)
+	}
+}
+
+func TestHoldEarlierFill(t *testing.T) {
+	store := NewMemoryStore()
+	engine := newTestEngine(store)
+	engine.instanceID = "replica-a"
+
+	swap := newTestSwap(engine, 1, SwapConfirmed, SwapBSC2Eth, "100")
+	swap.ClaimedBy, swap.ClaimedUntil = engine.instanceID, time.Now().Add(SwapClaimLease).Unix()
+	claimed := swap
+	claimed.ClaimedBy = "replica-b"
+	store.Tables(func(tables *MemoryTables) {
+		tables.Swaps = []model.Swap{claimed}
+	})
+	fill := &earlierFill{
+		tx:     types.NewTransaction(0, engine.getSwapAgent(common.ChainETH), big.NewInt(0), SelfTransferGasLimit, big.NewInt(1), nil),
+		sender: ethcom.HexToAddress(testSponsor),
+	}
+
+	// the swap claimed by another replica is left to it
+	held := swap
+	if ok, err := engine.holdEarlierFill(&held, fill); err != nil || ok {
+		t.Fatalf("swap claimed by another replica is held: %v %v", ok, err)
+	}
+	store.Tables(func(tables *MemoryTables) {
+		if saved := tables.Swaps[0]; saved.Status != SwapConfirmed || saved.ClaimedBy != "replica-b" {
+			t.Errorf("swap claimed by another replica is saved as %s by %s", saved.Status, saved.ClaimedBy)
+		}
+		tables.Swaps[0].ClaimedBy = engine.instanceID
+	})
+
+	held = swap
+	if ok, err := engine.holdEarlierFill(&held, fill); err != nil || !ok {
+		t.Fatalf("swap is not held: %v %v", ok, err)
+	}
+	store.Tables(func(tables *MemoryTables) {
+		// the earlier fill is left for the operators to check, it is not linked to the swap
+		if saved := tables.Swaps[0]; saved.Status != SwapMismatch || saved.FillTxHash != "" {
+			t.Errorf("swap with an earlier fill is saved as %s filled by %q", saved.Status, saved.FillTxHash)
+		}
+		if len(tables.FillTxs) != 0 {
+			t.Errorf("%d fill txs recorded, want 0", len(tables.FillTxs))
+		}
+	})
+}
+
+func TestReserveWithdrawalOfReplicas(t *testing.T) {
+	db, err := model.OpenDB(common.DBDialectSqlite3, model.SqliteInMemoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store := NewGormStore(db)
+	newReplica := func() *SwapEngine {
+		engine := &SwapEngine{
+			hmacCKey:    "test",
+			config:      &util.Config{WithdrawalLimitConfig: util.WithdrawalLimitConfig{MaxPerDay: map[string]string{common.ChainETH: "150"}}},
+			withdrawals: make(map[string]*withdrawalWindow),
+		}
+		engine.SetStore(store)
+		return engine
+	}
+	a, b := newReplica(), newReplica()
+
+	// replica a fills 100 of the limit, replica b can't take another 100 although its own window is empty
+	swap := newTestSwap(a, 1, SwapConfirmed, SwapBSC2Eth, "100")
+	if err := store.CreateSwap(&swap); err != nil {
+		t.Fatal(err)
+	}
+	err = store.Transaction(func(tx SwapStore) error {
+		if !a.reserveWithdrawal(tx, common.ChainETH, big.NewInt(100)) {
+			t.Error("withdrawal within the limit is not reserved")
+		}
+		swap.Status = SwapSending
+		return tx.SaveSwap(&swap)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Transaction(func(tx SwapStore) error {
+		if b.reserveWithdrawal(tx, common.ChainETH, big.NewInt(100)) {
+			t.Error("withdrawal above the limit of the replicas together is reserved")
+		}
+		if !b.reserveWithdrawal(tx, common.ChainETH, big.NewInt(50)) {
+			t.Error("withdrawal within the remaining allowance is not reserved")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateSwapErrorFailsTx(t *testing.T) {
+	store := NewMemoryStore()
+	engine := newTestEngine(store)
+	swap := newTestSwap(engine, 1, SwapSending, SwapBSC2Eth, "100")
+	swapTx := model.SwapFillTx{SwapID: swap.ID, Direction: swap.Direction, FillSwapTxHash: ethcom.BigToHash(big.NewInt(1)).String(),
+		Status: model.FillTxCreated}
+	store.Tables(func(tables *MemoryTables) {
+		tables.Swaps = []model.Swap{swap}
+	})
+	if err := store.CreateFillTx(&swapTx); err != nil {
+		t.Fatal(err)
+	}
+
+	err := store.Transaction(func(tx SwapStore) error {
+		if err := tx.SetFillTxStatus(swapTx.ID, model.FillTxSent); err != nil {
+			return err
+		}
+		swap.Status = "unknown"
+		return engine.updateSwap(tx, &swap)
+	})
+	if err == nil {
+		t.Fatal("swap of an unknown status is saved")
+	}
+	store.Tables(func(tables *MemoryTables) {
+		if saved := tables.Swaps[0]; saved.Status != SwapSending {
+			t.Errorf("swap is %s, want %s", saved.Status, SwapSending)
+		}
+		if status := tables.FillTxs[0].Status; status != model.FillTxCreated {
+			t.Errorf("fill tx of the failed tx is %d, want %d", status, model.FillTxCreated)
+		}
+	})
+}
+
+// testStores returns a memory store and the gorm stores of testStoreDialects, so the daemons are checked against
+// all of them
+func testStores(t *testing.T) map[string]SwapStore {
+	stores := map[string]SwapStore{"memory": NewMemoryStore()}
+	for dialect, store := range testStoreDialects(t) {
+		stores[dialect] = store
+	}
+	return stores
+}
+
+func TestArchiveSwapsOfStores(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			engine := newTestEngine(NewMemoryStore())
+			engine.SetStore(store)
+			swaps := []model.Swap{
+				newTestSwap(engine, 1, SwapSuccess, SwapBSC2Eth, "100"),
+				newTestSwap(engine, 2, SwapSuccess, SwapBSC2Eth, "100"),
+				newTestSwap(engine, 3, SwapSending, SwapBSC2Eth, "100"),
+			}
+			for i := range swaps {
+				if err := store.CreateSwap(&swaps[i]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			txLog := &model.SwapStartTxLog{Chain: common.ChainBSC, TokenAddr: testBEP20, FromAddress: testSponsor,
+				BlockHash: ethcom.BigToHash(big.NewInt(12)).String(), TxHash: swaps[0].StartTxHash, Phase: model.AckRequest}
+			if err := store.CreateStartTxLog(txLog); err != nil {
+				t.Fatal(err)
+			}
+			fillTx := &model.SwapFillTx{SwapID: swaps[0].ID, Direction: SwapBSC2Eth, StartSwapTxHash: swaps[0].StartTxHash,
+				FillSwapTxHash: ethcom.BigToHash(big.NewInt(11)).String(), GasPrice: "1", Status: model.FillTxSuccess}
+			if err := store.CreateFillTx(fillTx); err != nil {
+				t.Fatal(err)
+			}
+			// the swap being retried is kept
+			retrySwap := &model.RetrySwap{SwapID: swaps[1].ID, Sponsor: testSponsor, StartTxHash: swaps[1].StartTxHash, Direction: SwapBSC2Eth,
+				Status: RetrySwapConfirmed}
+			if err := store.CreateRetrySwap(retrySwap); err != nil {
+				t.Fatal(err)
+			}
+
+			archived, err := engine.archiveSwaps(time.Now().Add(time.Minute))
+			if err != nil || archived != 1 {
+				t.Fatalf("archive swaps: %d %v, want 1", archived, err)
+			}
+			left, err := store.FindSwaps(SwapQuery{})
+			if err != nil || len(left) != 2 || left[0].ID != swaps[1].ID || left[1].ID != swaps[2].ID {
+				t.Fatalf("swaps left: %v %v", left, err)
+			}
+			if recorded, err := store.IsSwapRecorded(swaps[0].StartTxHash); err != nil || !recorded {
+				t.Errorf("archived swap is not recorded: %v", err)
+			}
+			if recorded, err := store.IsStartTxRecorded(swaps[0].StartTxHash); err != nil || !recorded {
+				t.Errorf("archived start tx log is not recorded: %v", err)
+			}
+			if recorded, err := store.IsFillTxRecorded(fillTx.FillSwapTxHash); err != nil || !recorded {
+				t.Errorf("archived fill tx is not recorded: %v", err)
+			}
+			if swapTx, err := store.GetFillTxOfSwap(swaps[0].ID); err != nil || swapTx != nil {
+				t.Errorf("fill tx of the archived swap is left: %v %v", swapTx, err)
+			}
+		})
+	}
+}
+
+func TestRollupsOfStores(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			engine := newTestEngine(NewMemoryStore())
+			engine.SetStore(store)
+			hour := time.Now().UTC().Truncate(time.Hour).Add(-2 * time.Hour)
+			for i, status := range []common.SwapStatus{SwapSuccess, SwapExpired, SwapSending} {
+				swap := newTestSwap(engine, uint(i+1), status, SwapBSC2Eth, "100")
+				swap.CreatedAt = hour.Add(time.Duration(i) * time.Minute)
+				if err := store.CreateSwap(&swap); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := engine.rollupHours(hour, hour.Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			// the rollups of the hour are replaced when the hour is recomputed
+			if err := engine.rollupHours(hour, hour.Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			if err := engine.rollupDays(hour.Truncate(24 * time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			for _, period := range []model.RollupPeriod{model.RollupHour, model.RollupDay} {
+				rollups, err := engine.GetRollups(period, 0, hour.Add(24*time.Hour).Unix(), testERC20, "", 10)
+				if err != nil || len(rollups) != 1 {
+					t.Fatalf("%s rollups: %v %v", period, rollups, err)
+				}
+				rollup := rollups[0]
+				if rollup.SwapCount != 3 || rollup.SuccessCount != 1 || rollup.FailedCount != 1 ||
+					rollup.TotalAmount != "300" || rollup.FilledAmount != "100" {
+					t.Errorf("%s rollup: %+v", period, rollup)
+				}
+			}
+			if latest, err := store.GetLatestRollup(model.RollupHour); err != nil || latest == nil || latest.BucketStart != hour.Unix() {
+				t.Errorf("latest hourly rollup: %v %v", latest, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package swap
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"occ-swap-server/common"
+	"occ-swap-server/util"
+)
+
+const (
+	DashboardUID   = "bridge"
+	DashboardTitle = "Bridge"
+
+	dashboardPanelWidth  = 12
+	dashboardPanelHeight = 8
+)
+
+// the structs below only cover the fields of the grafana dashboard model and of the prometheus rule files the
+// generator sets, prometheus parses the rule files as yaml so the json rule files are loaded as they are
+
+type GrafanaDashboard struct {
+	UID           string            `json:"uid"`
+	Title         string            `json:"title"`
+	Tags          []string          `json:"tags"`
+	SchemaVersion int               `json:"schemaVersion"`
+	Refresh       string            `json:"refresh"`
+	Time          grafanaTimeRange  `json:"time"`
+	Templating    grafanaTemplating `json:"templating"`
+	Panels        []GrafanaPanel    `json:"panels"`
+}
+
+type grafanaTimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type grafanaTemplating struct {
+	List []grafanaVariable `json:"list"`
+}
+
+type grafanaVariable struct {
+	Name  string `json:"name"`
+	Label string `json:"label"`
+	Type  string `json:"type"`
+	Query string `json:"query"`
+}
+
+type GrafanaPanel struct {
+	ID          int             `json:"id"`
+	Type        string          `json:"type"`
+	Title       string          `json:"title"`
+	Description string          `json:"description"`
+	Datasource  string          `json:"datasource"`
+	GridPos     grafanaGridPos  `json:"gridPos"`
+	Targets     []grafanaTarget `json:"targets"`
+}
+
+type grafanaGridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type grafanaTarget struct {
+	RefID        string `json:"refId"`
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat"`
+}
+
+type AlertRuleFile struct {
+	Groups []AlertRuleGroup `json:"groups"`
+}
+
+type AlertRuleGroup struct {
+	Name  string      `json:"name"`
+	Rules []AlertRule `json:"rules"`
+}
+
+type AlertRule struct {
+	Alert       string            `json:"alert"`
+	Expr        string            `json:"expr"`
+	For         string            `json:"for"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// BuildGrafanaDashboard returns a dashboard with a panel for every gauge of Metrics, two panels a row
+func BuildGrafanaDashboard() *GrafanaDashboard {
+	dashboard := &GrafanaDashboard{
+		UID:           DashboardUID,
+		Title:         DashboardTitle,
+		Tags:          []string{MetricsNamespace},
+		SchemaVersion: 27,
+		Refresh:       "30s",
+		Time:          grafanaTimeRange{From: "now-6h", To: "now"},
+		Templating: grafanaTemplating{List: []grafanaVariable{
+			{Name: "datasource", Label: "Data source", Type: "datasource", Query: "prometheus"},
+		}},
+		Panels: make([]GrafanaPanel, 0, len(metrics)),
+	}
+	for i, metric := range Metrics() {
+		legend := make([]string, 0, len(metric.Labels))
+		for _, label := range metric.Labels {
+			legend = append(legend, fmt.Sprintf("{{%s}}", label))
+		}
+		dashboard.Panels = append(dashboard.Panels, GrafanaPanel{
+			ID:          i + 1,
+			Type:        "timeseries",
+			Title:       metric.Name,
+			Description: metric.Help,
+			Datasource:  "${datasource}",
+			GridPos: grafanaGridPos{
+				H: dashboardPanelHeight,
+				W: dashboardPanelWidth,
+				X: (i % 2) * dashboardPanelWidth,
+				Y: (i / 2) * dashboardPanelHeight,
+			},
+			Targets: []grafanaTarget{{RefID: "A", Expr: metric.Name, LegendFormat: strings.Join(legend, " ")}},
+		})
+	}
+	return dashboard
+}
+
+// BuildAlertRules returns the alert rules of the gauges, the relayer balance of a chain is alerted below the alert
+// threshold of the chain in the config, and the oldest swap of a stage above the max age of the stage
+func BuildAlertRules(cfg util.ChainConfig, queueAgeCfg util.QueueAgeConfig) *AlertRuleFile {
+	rules := []AlertRule{
+		{
+			Alert:  "BridgeRPCCircuitOpen",
+			Expr:   fmt.Sprintf("%s == 1", circuitOpenMetric.Name),
+			For:    "1m",
+			Labels: map[string]string{"severity": "critical"},
+			Annotations: map[string]string{
+				"summary":     "{{ $labels.chain }} rpc is degraded",
+				"description": "The circuit breaker of the {{ $labels.chain }} rpc is open, the swaps from and to the chain are not processed.",
+			},
+		},
+		{
+			Alert:  "BridgeChainHeadStalled",
+			Expr:   fmt.Sprintf("changes(%s[10m]) == 0", chainHeadMetric.Name),
+			For:    "5m",
+			Labels: map[string]string{"severity": "critical"},
+			Annotations: map[string]string{
+				"summary":     "{{ $labels.chain }} head is not moving",
+				"description": "The head of {{ $labels.chain }} polled by the swap engine didn't change for 15 minutes.",
+			},
+		},
+		{
+			Alert:  "BridgeStuckSwaps",
+			Expr:   fmt.Sprintf("%s > 0", stuckSwapsMetric.Name),
+			For:    "15m",
+			Labels: map[string]string{"severity": "warning"},
+			Annotations: map[string]string{
+				"summary":     "{{ $value }} {{ $labels.kind }} swaps are stuck",
+				"description": "The watchdog can't heal the {{ $labels.kind }} swaps, check them with the admin api.",
+			},
+		},
+		{
+			Alert:  "BridgeLiquidityExhausted",
+			Expr:   fmt.Sprintf("%s <= 0", liquidityAvailableMetric.Name),
+			For:    "5m",
+			Labels: map[string]string{"severity": "critical"},
+			Annotations: map[string]string{
+				"summary":     "no liquidity of token {{ $labels.token }} on {{ $labels.chain }}",
+				"description": "The swap agent on {{ $labels.chain }} can't cover the swaps being filled, new swaps to the chain wait for liquidity.",
+			},
+		},
+	}
+	rules = append(rules, AlertRule{
+		Alert:  "BridgeDaemonPanicked",
+		Expr:   fmt.Sprintf("increase(%s[15m]) > 0", daemonPanicsMetric.Name),
+		For:    "0m",
+		Labels: map[string]string{"severity": "critical"},
+		Annotations: map[string]string{
+			"summary":     "daemon {{ $labels.daemon }} panicked",
+			"description": "The daemon is restarted after the backoff, it keeps panicking on the same row until the row is fixed, check the stack in the logs.",
+		},
+	})
+	rules = append(rules, AlertRule{
+		Alert:  "BridgeRPCCallsHung",
+		Expr:   fmt.Sprintf("increase(%s[15m]) > 0", rpcTimeoutsMetric.Name),
+		For:    "0m",
+		Labels: map[string]string{"severity": "warning"},
+		Annotations: map[string]string{
+			"summary":     "{{ $labels.method }} calls to {{ $labels.chain }} rpc hung",
+			"description": "The calls timed out after the rpc timeout of the chain, the daemons retry them, the circuit breaker opens if the rpc keeps hanging.",
+		},
+	})
+	rules = append(rules, AlertRule{
+		Alert:  "BridgeHeaderCacheStalled",
+		Expr:   fmt.Sprintf("changes(%s[10m]) == 0", quorumHeaderMetric.Name),
+		For:    "5m",
+		Labels: map[string]string{"severity": "critical"},
+		Annotations: map[string]string{
+			"summary":     "header cache of {{ $labels.chain }} is not moving",
+			"description": "The header providers of {{ $labels.chain }} are unreachable or don't agree on the headers, the swaps from the chain wait for their headers.",
+		},
+	})
+	rules = append(rules, AlertRule{
+		Alert:  "BridgeRelayerDrainBrake",
+		Expr:   fmt.Sprintf("%s == 1", drainBrakeHaltedMetric.Name),
+		For:    "0m",
+		Labels: map[string]string{"severity": "critical"},
+		Annotations: map[string]string{
+			"summary":     "fills to {{ $labels.chain }} are halted by the drain brake",
+			"description": "A relayer account of {{ $labels.chain }} sent more than its hourly ceiling, the fills to the chain wait until the admin releases the drain brake.",
+		},
+	})
+	rules = append(rules, AlertRule{
+		Alert:  "BridgeGasGuardHolding",
+		Expr:   fmt.Sprintf("%s == 1", gasGuardHoldingMetric.Name),
+		For:    "30m",
+		Labels: map[string]string{"severity": "warning"},
+		Annotations: map[string]string{
+			"summary":     "fills to {{ $labels.chain }} are held by the gas guard",
+			"description": "The gas price of {{ $labels.chain }} stays above the max gas price of gas_guard_config, the swaps wait in awaiting_gas until it falls to the resume gas price.",
+		},
+	})
+	rules = append(rules, AlertRule{
+		Alert:  "BridgeRelayerTokenLow",
+		Expr:   fmt.Sprintf("%s == 1", relayerTokenLowMetric.Name),
+		For:    "5m",
+		Labels: map[string]string{"severity": "warning"},
+		Annotations: map[string]string{
+			"summary":     "relayer {{ $labels.account }} on {{ $labels.chain }} runs low on token {{ $labels.token }}",
+			"description": "The token balance of the relayer is below the min balance of relayer_token_config, top it up.",
+		},
+	})
+	rules = append(rules, AlertRule{
+		Alert:  "BridgeEventExportLagging",
+		Expr:   fmt.Sprintf("%s > 300", eventExportLagMetric.Name),
+		For:    "5m",
+		Labels: map[string]string{"severity": "warning"},
+		Annotations: map[string]string{
+			"summary":     "bridge events are not published to the {{ $labels.sink }} sink",
+			"description": "The oldest unpublished bridge event is more than 5 minutes old, the event sink is unreachable or rejects the events, see the event_export daemon.",
+		},
+	})
+	for _, stage := range queueStages {
+		rules = append(rules, AlertRule{
+			Alert:  "BridgeQueueLagging",
+			Expr:   fmt.Sprintf("%s{stage=%q} > %d", queueAgeMetric.Name, stage, int64(queueAgeCfg.GetMaxAge(stage).Seconds())),
+			For:    "0m",
+			Labels: map[string]string{"severity": "warning"},
+			Annotations: map[string]string{
+				"summary":     "{{ $labels.direction }} swaps linger in {{ $labels.stage }}",
+				"description": "The oldest {{ $labels.stage }} swap of {{ $labels.direction }} waited {{ $value }} seconds, longer than the max age of queue_age_config, the direction may be stuck.",
+			},
+		})
+	}
+	for _, chain := range []string{common.ChainBSC, common.ChainETH, common.ChainMATIC} {
+		rules = append(rules, AlertRule{
+			Alert:  "BridgeRelayerBalanceLow",
+			Expr:   fmt.Sprintf("%s{chain=%q} <= %s", relayerBalanceMetric.Name, chain, cfg.GetAlertThreshold(chain).String()),
+			For:    "5m",
+			Labels: map[string]string{"severity": "warning"},
+			Annotations: map[string]string{
+				"summary":     "relayer {{ $labels.account }} on {{ $labels.chain }} is drained",
+				"description": "The native coin balance of the relayer is below the alert threshold of the chain, top it up.",
+			},
+		})
+	}
+	return &AlertRuleFile{Groups: []AlertRuleGroup{{Name: MetricsNamespace, Rules: rules}}}
+}
+
+// MarshalDashboards returns the json of the grafana dashboard and of the prometheus alert rules
+func MarshalDashboards(cfg util.ChainConfig, queueAgeCfg util.QueueAgeConfig) (dashboard []byte, rules []byte, err error) {
+	dashboard, err = json.MarshalIndent(BuildGrafanaDashboard(), "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	rules, err = json.MarshalIndent(BuildAlertRules(cfg, queueAgeCfg), "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(dashboard, '\n'), append(rules, '\n'), nil
+}
@@ -0,0 +1,283 @@
+package swap
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"occ-swap-server/common"
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+// window of the spend of the relayer accounts checked against the ceilings of the drain brake
+const drainBrakeWindow = time.Hour
+
+// nativeSpend is the token label of the native coin spent by a relayer account
+const nativeSpend = "native"
+
+// DrainBrakeHalt is a chain whose fills are halted by the drain brake
+type DrainBrakeHalt struct {
+	Chain    string `json:"chain"`
+	Account  string `json:"account"`
+	Token    string `json:"token"`
+	Spent    string `json:"spent"`
+	Ceiling  string `json:"ceiling"`
+	HaltedAt int64  `json:"halted_at"`
+}
+
+// RelayerSpend is the amount of a token, or of the native coin, sent by a relayer account within the last hour
+type RelayerSpend struct {
+	Chain   string `json:"chain"`
+	Account string `json:"account"`
+	Token   string `json:"token"`
+	Spent   string `json:"spent"`
+	Ceiling string `json:"ceiling"`
+}
+
+type DrainBrakeStatus struct {
+	Halts  []DrainBrakeHalt `json:"halts"`
+	Spends []RelayerSpend   `json:"spends"`
+}
+
+type drainKey struct {
+	chain   string
+	account ethcom.Address
+	// erc20 address of the pair, nativeSpend for the native coin
+	token string
+}
+
+// drainBrake sums what every relayer account sent within the last hour, the native coin of all its txs with their
+// gas and the tokens of its fills, and halts the fills to the chain of the account once a sum is above its ceiling.
+// The spends and the halts are saved in the store, so every replica sums the sends of all of them and the halt holds
+// until the admin releases the chain, even across restarts.
+type drainBrake struct {
+	cfg   util.DrainBrakeConfig
+	store SwapStore
+}
+
+func newDrainBrake(cfg util.DrainBrakeConfig, store SwapStore) *drainBrake {
+	return &drainBrake{cfg: cfg, store: store}
+}
+
+// recordTx records the native coin the tx may spend, its value and its gas at the gas price
+func (d *drainBrake) recordTx(chain string, account ethcom.Address, tx *types.Transaction) {
+	ceiling := d.cfg.GetMaxNativePerHour(chain)
+	if ceiling == nil {
+		return
+	}
+	amount := new(big.Int).Mul(tx.GasPrice(), new(big.Int).SetUint64(tx.Gas()))
+	amount.Add(amount, tx.Value())
+	d.record(drainKey{chain: chain, account: account, token: nativeSpend}, amount, ceiling)
+}
+
+// recordFill records the tokens of the pair filled by the fill tx
+func (d *drainBrake) recordFill(chain string, erc20Addr ethcom.Address, amount *big.Int, tx *types.Transaction) {
+	ceiling := d.cfg.GetMaxTokenPerHour(erc20Addr)
+	if ceiling == nil || amount == nil || tx == nil {
+		return
+	}
+	account, err := types.Sender(types.NewEIP155Signer(tx.ChainId()), tx)
+	if err != nil {
+		util.Logger.Errorf("recover sender of fill tx %s on %s error: %s", tx.Hash().String(), chain, err.Error())
+		return
+	}
+	d.record(drainKey{chain: chain, account: account, token: erc20Addr.String()}, amount, ceiling)
+}
+
+func (d *drainBrake) record(key drainKey, amount, ceiling *big.Int) {
+	now := time.Now()
+	spend := &model.RelayerSpend{
+		Chain:   key.chain,
+		Account: key.account.String(),
+		Token:   key.token,
+		Amount:  amount.String(),
+	}
+	if err := d.store.CreateRelayerSpend(spend); err != nil {
+		util.Logger.Errorf("record spend of %s of relayer %s on %s error: %s", spend.Amount, spend.Account,
+			spend.Chain, err.Error())
+		util.SendTelegramMessage(fmt.Sprintf("Urgent alert: record spend of %s of relayer %s on %s error: %s, the drain brake misses it",
+			spend.Amount, spend.Account, spend.Chain, err.Error()))
+		return
+	}
+	if err := d.store.DeleteRelayerSpends("", now.Add(-drainBrakeWindow)); err != nil {
+		util.Logger.Errorf("delete spends of the relayers older than %s error: %s", drainBrakeWindow, err.Error())
+	}
+	spends, err := d.store.FindRelayerSpends(now.Add(-drainBrakeWindow))
+	if err != nil {
+		util.Logger.Errorf("query spends of the relayers error: %s", err.Error())
+		return
+	}
+	spent := sumRelayerSpends(spends)[key]
+	if spent == nil {
+		spent = amount
+	}
+
+	spentFloat, _ := new(big.Float).SetInt(spent).Float64()
+	relayerSpendGauge.WithLabelValues(key.chain, key.account.String(), key.token).Set(spentFloat)
+	if spent.Cmp(ceiling) <= 0 {
+		return
+	}
+	halt := &model.DrainBrakeHalt{
+		Chain:   key.chain,
+		Account: key.account.String(),
+		Token:   key.token,
+		Spent:   spent.String(),
+		Ceiling: ceiling.String(),
+	}
+	// the replica creating the halt alerts, the chain may be halted already by another one
+	created, err := d.store.CreateDrainBrakeHalt(halt)
+	if err != nil {
+		util.Logger.Errorf("halt the fills to %s error: %s", key.chain, err.Error())
+		util.SendTelegramMessage(fmt.Sprintf("Urgent alert: relayer %s on %s sent %s of %s within an hour, above the ceiling %s, but the halt of the fills to %s failed: %s",
+			halt.Account, halt.Chain, halt.Spent, halt.Token, halt.Ceiling, halt.Chain, err.Error()))
+		return
+	}
+	if !created {
+		return
+	}
+	drainBrakeHaltedGauge.WithLabelValues(key.chain).Set(1)
+	util.Logger.Errorf("relayer %s on %s sent %s of %s within an hour, above the ceiling %s, halt the fills to %s",
+		halt.Account, halt.Chain, halt.Spent, halt.Token, halt.Ceiling, halt.Chain)
+	util.SendTelegramMessage(fmt.Sprintf("Urgent alert: relayer %s on %s sent %s of %s within an hour, above the ceiling %s, the fills to %s are halted until the admin releases the drain brake",
+		halt.Account, halt.Chain, halt.Spent, halt.Token, halt.Ceiling, halt.Chain))
+}
+
+// IsHalted returns whether the fills to the chain are halted, they are held as well if the halt can't be queried
+func (d *drainBrake) IsHalted(chain string) bool {
+	halt, err := d.store.GetDrainBrakeHalt(chain)
+	if err != nil {
+		util.Logger.Errorf("query drain brake halt of %s error: %s", chain, err.Error())
+		return true
+	}
+	if halt == nil {
+		drainBrakeHaltedGauge.WithLabelValues(chain).Set(0)
+		return false
+	}
+	drainBrakeHaltedGauge.WithLabelValues(chain).Set(1)
+	return true
+}
+
+// Release resumes the fills to the halted chain, the spend of its relayer accounts starts again from 0
+func (d *drainBrake) Release(chain string, operator string) error {
+	halt, err := d.store.GetDrainBrakeHalt(chain)
+	if err != nil {
+		return err
+	}
+	if halt == nil {
+		return fmt.Errorf("fills to %s are not halted by the drain brake", chain)
+	}
+	var spends []model.RelayerSpend
+	err = d.store.Transaction(func(tx SwapStore) error {
+		deleted, err := tx.DeleteDrainBrakeHalt(chain)
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return fmt.Errorf("fills to %s are not halted by the drain brake", chain)
+		}
+		if spends, err = tx.FindRelayerSpends(time.Now().Add(-drainBrakeWindow)); err != nil {
+			return err
+		}
+		return tx.DeleteRelayerSpends(chain, time.Now())
+	})
+	if err != nil {
+		return err
+	}
+
+	for key := range sumRelayerSpends(spends) {
+		if key.chain == chain {
+			relayerSpendGauge.WithLabelValues(key.chain, key.account.String(), key.token).Set(0)
+		}
+	}
+	drainBrakeHaltedGauge.WithLabelValues(chain).Set(0)
+	util.Logger.Infof("drain brake of %s is released by %s, it was halted by relayer %s sending %s of %s", chain,
+		operator, halt.Account, halt.Spent, halt.Token)
+	return nil
+}
+
+// Status returns the halted chains and the spend of the relayer accounts within the last hour
+func (d *drainBrake) Status() (DrainBrakeStatus, error) {
+	status := DrainBrakeStatus{Halts: make([]DrainBrakeHalt, 0), Spends: make([]RelayerSpend, 0)}
+	halts, err := d.store.FindDrainBrakeHalts()
+	if err != nil {
+		return status, err
+	}
+	for _, halt := range halts {
+		status.Halts = append(status.Halts, DrainBrakeHalt{
+			Chain:    halt.Chain,
+			Account:  halt.Account,
+			Token:    halt.Token,
+			Spent:    halt.Spent,
+			Ceiling:  halt.Ceiling,
+			HaltedAt: halt.CreatedAt.Unix(),
+		})
+	}
+	spends, err := d.store.FindRelayerSpends(time.Now().Add(-drainBrakeWindow))
+	if err != nil {
+		return status, err
+	}
+	for key, spent := range sumRelayerSpends(spends) {
+		spend := RelayerSpend{Chain: key.chain, Account: key.account.String(), Token: key.token, Spent: spent.String()}
+		if key.token == nativeSpend {
+			spend.Ceiling = d.cfg.GetMaxNativePerHour(key.chain).String()
+		} else {
+			spend.Ceiling = d.cfg.GetMaxTokenPerHour(ethcom.HexToAddress(key.token)).String()
+		}
+		status.Spends = append(status.Spends, spend)
+	}
+	sort.Slice(status.Spends, func(i, j int) bool {
+		a, b := status.Spends[i], status.Spends[j]
+		if a.Chain != b.Chain {
+			return a.Chain < b.Chain
+		}
+		if a.Account != b.Account {
+			return a.Account < b.Account
+		}
+		return a.Token < b.Token
+	})
+	return status, nil
+}
+
+// sumRelayerSpends sums the spends of every relayer account by chain and token, the amounts which don't parse are
+// skipped
+func sumRelayerSpends(spends []model.RelayerSpend) map[drainKey]*big.Int {
+	sums := make(map[drainKey]*big.Int)
+	for _, spend := range spends {
+		amount, ok := new(big.Int).SetString(spend.Amount, 10)
+		if !ok {
+			continue
+		}
+		key := drainKey{chain: spend.Chain, account: ethcom.HexToAddress(spend.Account), token: spend.Token}
+		if sums[key] == nil {
+			sums[key] = big.NewInt(0)
+		}
+		sums[key].Add(sums[key], amount)
+	}
+	return sums
+}
+
+// GetDrainBrakeStatus returns the chains halted by the drain brake and the hourly spend of the relayer accounts
+func (engine *SwapEngine) GetDrainBrakeStatus() (DrainBrakeStatus, error) {
+	return engine.drainBrake.Status()
+}
+
+// ReleaseDrainBrake resumes the fills to the chain halted by the drain brake
+func (engine *SwapEngine) ReleaseDrainBrake(chain string, operator string) error {
+	return engine.drainBrake.Release(chain, operator)
+}
+
+// getDrainedDirections returns the directions to the chains halted by the drain brake
+func (engine *SwapEngine) getDrainedDirections() []common.SwapDirection {
+	directions := make([]common.SwapDirection, 0)
+	for _, chain := range []string{common.ChainBSC, common.ChainETH, common.ChainMATIC} {
+		if engine.drainBrake.IsHalted(chain) {
+			directions = append(directions, getDirectionsToChain(chain)...)
+		}
+	}
+	return directions
+}
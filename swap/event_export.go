@@ -0,0 +1,268 @@
+package swap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"occ-swap-server/common"
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+const (
+	// BridgeEventSchemaVersion is the version of BridgeEventPayload, see docs/events.md, it is bumped on the changes
+	// breaking the consumers
+	BridgeEventSchemaVersion = 1
+
+	kafkaRestContentType = "application/vnd.kafka.json.v2+json"
+	// maxEventSinkResponseLength is the max length of the response of the sink read, the offsets of a batch
+	maxEventSinkResponseLength = 1 << 20
+	// eventPruneInterval is how often the published events past the retention are deleted
+	eventPruneInterval = time.Hour
+)
+
+// BridgeEventPayload is a swap lifecycle event in the schema of docs/events.md, an event is published every time a
+// swap is created or changes its status
+type BridgeEventPayload struct {
+	SchemaVersion int    `json:"schema_version"`
+	EventID       uint   `json:"event_id"`
+	Type          string `json:"type"`
+	SwapID        uint   `json:"swap_id"`
+	// empty for the created swaps
+	PreviousStatus common.SwapStatus    `json:"previous_status"`
+	Status         common.SwapStatus    `json:"status"`
+	Direction      common.SwapDirection `json:"direction"`
+	SourceChain    string               `json:"source_chain"`
+	DestChain      string               `json:"dest_chain"`
+	ToChainId      string               `json:"to_chain_id"`
+	Sponsor        string               `json:"sponsor"`
+	Recipient      string               `json:"recipient"`
+	Memo           string               `json:"memo"`
+	BEP20Addr      string               `json:"bep20_addr"`
+	ERC20Addr      string               `json:"erc20_addr"`
+	Symbol         string               `json:"symbol"`
+	Amount         string               `json:"amount"`
+	Decimals       int                  `json:"decimals"`
+	AmountDecimal  string               `json:"amount_decimal"`
+	AssetType      common.AssetType     `json:"asset_type"`
+	TokenId        string               `json:"token_id"`
+	StartTxHash    string               `json:"start_tx_hash"`
+	FillTxHash     string               `json:"fill_tx_hash"`
+	FailureClass   common.FailureClass  `json:"failure_class"`
+	Timestamp      int64                `json:"timestamp"`
+}
+
+// recordBridgeEvent writes the event of the swap in the tx changing it, if the events are exported
+func (engine *SwapEngine) recordBridgeEvent(tx SwapStore, swap *model.Swap, previousStatus common.SwapStatus) error {
+	if !engine.config.EventExportConfig.Enabled() {
+		return nil
+	}
+	event := model.BridgeEvent{
+		Type:    fmt.Sprintf("swap.%s", swap.Status),
+		SwapID:  swap.ID,
+		Payload: "{}",
+	}
+	if err := tx.CreateBridgeEvent(&event); err != nil {
+		return err
+	}
+	route, _ := getSwapRoute(swap.Direction)
+	payload, err := json.Marshal(BridgeEventPayload{
+		SchemaVersion:  BridgeEventSchemaVersion,
+		EventID:        event.ID,
+		Type:           event.Type,
+		SwapID:         swap.ID,
+		PreviousStatus: previousStatus,
+		Status:         swap.Status,
+		Direction:      swap.Direction,
+		SourceChain:    route.SourceChain,
+		DestChain:      route.DestChain,
+		ToChainId:      swap.ToChainId,
+		Sponsor:        swap.Sponsor,
+		Recipient:      swap.GetRecipient(),
+		Memo:           swap.Memo,
+		BEP20Addr:      swap.BEP20Addr,
+		ERC20Addr:      swap.ERC20Addr,
+		Symbol:         swap.Symbol,
+		Amount:         swap.Amount,
+		Decimals:       swap.Decimals,
+		AmountDecimal:  swap.AmountDecimal,
+		AssetType:      swap.AssetType,
+		TokenId:        swap.TokenId,
+		StartTxHash:    swap.StartTxHash,
+		FillTxHash:     swap.FillTxHash,
+		FailureClass:   swap.FailureClass,
+		Timestamp:      time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	return tx.SetBridgeEventPayload(event.ID, string(payload))
+}
+
+// eventExportDaemon publishes the events to the sink of event_export_config in the order of their ids. A batch is
+// marked published once the sink accepts it, a failed batch is published again by the next run, so the events are
+// delivered at least once and the consumers dedupe them by event_id.
+func (engine *SwapEngine) eventExportDaemon() func() error {
+	cfg := engine.config.EventExportConfig
+	util.RegisterSecret(cfg.Secret)
+	publish := newEventSink(cfg)
+	lastPrune := time.Time{}
+	return func() error {
+		if time.Since(lastPrune) >= eventPruneInterval {
+			lastPrune = time.Now()
+			pruned, err := engine.store.DeletePublishedBridgeEvents(time.Now().Add(-cfg.GetRetention()))
+			if err != nil {
+				util.Logger.Errorf("prune published bridge events error: %s", err.Error())
+			} else if pruned > 0 {
+				util.Logger.Infof("%d published bridge events are pruned", pruned)
+			}
+		}
+
+		events, err := engine.store.FindUnpublishedBridgeEvents(cfg.GetBatchSize())
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			eventExportLagGauge.WithLabelValues(cfg.Sink).Set(0)
+			return nil
+		}
+		eventExportLagGauge.WithLabelValues(cfg.Sink).Set(time.Since(events[0].CreatedAt).Seconds())
+
+		if err := publish(events); err != nil {
+			return fmt.Errorf("publish %d bridge events from %d to the %s sink error: %s", len(events), events[0].ID,
+				cfg.Sink, err.Error())
+		}
+		ids := make([]uint, 0, len(events))
+		for _, event := range events {
+			ids = append(ids, event.ID)
+		}
+		if err := engine.store.MarkBridgeEventsPublished(ids); err != nil {
+			return err
+		}
+		if len(events) == cfg.GetBatchSize() {
+			return util.RunAgain
+		}
+		return nil
+	}
+}
+
+// newEventSink returns the func publishing a batch of events to the sink of the config at once, it fails unless the
+// sink accepts all of them
+func newEventSink(cfg util.EventExportConfig) func(events []model.BridgeEvent) error {
+	if cfg.Sink == util.EventSinkNats {
+		publisher, err := util.NewNatsPublisher(cfg.Url)
+		return func(events []model.BridgeEvent) error {
+			if err != nil {
+				return err
+			}
+			return publishNatsEvents(publisher, cfg, events)
+		}
+	}
+	client := &http.Client{Timeout: cfg.GetTimeout()}
+	return func(events []model.BridgeEvent) error {
+		return postBridgeEvents(client, cfg, events)
+	}
+}
+
+// publishNatsEvents publishes every event to the subject of its type under the topic, e.g. bridge.swap.sent_success,
+// so the consumers subscribe to the statuses they react to
+func publishNatsEvents(publisher *util.NatsPublisher, cfg util.EventExportConfig, events []model.BridgeEvent) error {
+	subjects := make([]string, 0, len(events))
+	payloads := make([][]byte, 0, len(events))
+	for _, event := range events {
+		subjects = append(subjects, fmt.Sprintf("%s.%s", cfg.Topic, event.Type))
+		payloads = append(payloads, []byte(event.Payload))
+	}
+	return publisher.Publish(subjects, payloads, cfg.JetStream, cfg.GetTimeout())
+}
+
+// postBridgeEvents posts the events to the webhook or the kafka rest proxy
+func postBridgeEvents(client *http.Client, cfg util.EventExportConfig, events []model.BridgeEvent) error {
+	var body []byte
+	var err error
+	headers := map[string]string{}
+	endpoint := cfg.Url
+	switch cfg.Sink {
+	case util.EventSinkKafkaRest:
+		// the events of a swap are keyed by its id, they stay in order on one partition
+		records := make([]kafkaRestRecord, 0, len(events))
+		for _, event := range events {
+			records = append(records, kafkaRestRecord{Key: strconv.FormatUint(uint64(event.SwapID), 10),
+				Value: json.RawMessage(event.Payload)})
+		}
+		body, err = json.Marshal(kafkaRestRequest{Records: records})
+		headers["Content-Type"] = kafkaRestContentType
+		endpoint = fmt.Sprintf("%s/topics/%s", strings.TrimRight(cfg.Url, "/"), url.PathEscape(cfg.Topic))
+	default:
+		payloads := make([]json.RawMessage, 0, len(events))
+		for _, event := range events {
+			payloads = append(payloads, json.RawMessage(event.Payload))
+		}
+		body, err = json.Marshal(webhookEventBatch{Events: payloads})
+		headers["Content-Type"] = "application/json"
+		headers[WebhookSignatureHeader] = util.NewHmacSigner("", cfg.Secret).Sign(body)
+	}
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxEventSinkResponseLength))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		if len(respBody) > MaxWebhookErrorLength {
+			respBody = respBody[:MaxWebhookErrorLength]
+		}
+		return fmt.Errorf("response status %d: %s", resp.StatusCode, string(respBody))
+	}
+	if cfg.Sink == util.EventSinkKafkaRest {
+		// the rest proxy reports the records it failed to produce by their offsets
+		produced := kafkaRestResponse{}
+		if err := json.Unmarshal(respBody, &produced); err == nil {
+			for _, offset := range produced.Offsets {
+				if offset.ErrorCode != nil {
+					return fmt.Errorf("produce record error %d: %s", *offset.ErrorCode, offset.Error)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+type webhookEventBatch struct {
+	Events []json.RawMessage `json:"events"`
+}
+
+type kafkaRestRecord struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+type kafkaRestRequest struct {
+	Records []kafkaRestRecord `json:"records"`
+}
+
+type kafkaRestResponse struct {
+	Offsets []struct {
+		ErrorCode *int   `json:"error_code"`
+		Error     string `json:"error"`
+	} `json:"offsets"`
+}
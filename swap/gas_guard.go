@@ -0,0 +1,136 @@
+package swap
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"occ-swap-server/common"
+	"occ-swap-server/util"
+)
+
+// GasGuardState is the latest gas price of a destination chain against the ceiling of its gas guard
+type GasGuardState struct {
+	Chain          string `json:"chain"`
+	GasPrice       string `json:"gas_price"`
+	MaxGasPrice    string `json:"max_gas_price"`
+	ResumeGasPrice string `json:"resume_gas_price"`
+	// whether the fills to the chain are held, since when
+	Holding      bool  `json:"holding"`
+	HoldingSince int64 `json:"holding_since,omitempty"`
+	UpdateTime   int64 `json:"update_time"`
+}
+
+// isGasGuardHolding returns whether the fills to the chain are held until its gas price falls, it is false until the
+// gas price is queried first
+func (engine *SwapEngine) isGasGuardHolding(chain string) bool {
+	engine.mutex.RLock()
+	defer engine.mutex.RUnlock()
+	state, ok := engine.gasGuards[chain]
+	return ok && state.Holding
+}
+
+// GetGasGuardState returns the latest gas price of the chain against its ceiling, nil if the chain has no ceiling or
+// its gas price is not queried yet
+func (engine *SwapEngine) GetGasGuardState(chain string) *GasGuardState {
+	engine.mutex.RLock()
+	defer engine.mutex.RUnlock()
+	state, ok := engine.gasGuards[chain]
+	if !ok {
+		return nil
+	}
+	copied := *state
+	return &copied
+}
+
+// gasGuardDaemon queries the gas prices of the chains with a ceiling, holds the fills to a chain once its gas price is
+// above the ceiling and resumes them once it falls to the resume gas price. The swaps held are released while the
+// fills are not held.
+func (engine *SwapEngine) gasGuardDaemon() error {
+	more := false
+	for _, chain := range []string{common.ChainBSC, common.ChainETH, common.ChainMATIC} {
+		maxGasPrice := engine.config.GasGuardConfig.GetMaxGasPrice(chain)
+		if maxGasPrice == nil {
+			continue
+		}
+		resumeGasPrice := engine.config.GasGuardConfig.GetResumeGasPrice(chain)
+		gasPrice, err := engine.getClient(chain).SuggestGasPrice(context.Background())
+		if err != nil {
+			if err != ErrCircuitOpen {
+				util.Logger.Errorf("query gas price of %s error: %s", chain, err.Error())
+			}
+			continue
+		}
+
+		now := time.Now().Unix()
+		engine.mutex.Lock()
+		state, ok := engine.gasGuards[chain]
+		if !ok {
+			state = &GasGuardState{Chain: chain}
+			engine.gasGuards[chain] = state
+		}
+		wasHolding := state.Holding
+		if !state.Holding && gasPrice.Cmp(maxGasPrice) > 0 {
+			state.Holding, state.HoldingSince = true, now
+		} else if state.Holding && gasPrice.Cmp(resumeGasPrice) <= 0 {
+			state.Holding, state.HoldingSince = false, 0
+		}
+		state.GasPrice = gasPrice.String()
+		state.MaxGasPrice = maxGasPrice.String()
+		state.ResumeGasPrice = resumeGasPrice.String()
+		state.UpdateTime = now
+		holding := state.Holding
+		engine.mutex.Unlock()
+
+		if holding {
+			gasGuardHoldingGauge.WithLabelValues(chain).Set(1)
+		} else {
+			gasGuardHoldingGauge.WithLabelValues(chain).Set(0)
+		}
+		if holding && !wasHolding {
+			util.Logger.Errorf("gas price %s of %s is above the ceiling %s, hold the fills until it falls to %s", gasPrice.String(), chain,
+				maxGasPrice.String(), resumeGasPrice.String())
+			util.SendTelegramMessage(fmt.Sprintf("gas price %s of %s is above the ceiling %s, hold the fills until it falls to %s", gasPrice.String(),
+				chain, maxGasPrice.String(), resumeGasPrice.String()))
+		} else if !holding && wasHolding {
+			util.Logger.Infof("gas price %s of %s falls to the resume gas price %s, resume the fills", gasPrice.String(), chain, resumeGasPrice.String())
+			util.SendTelegramMessage(fmt.Sprintf("gas price %s of %s falls to the resume gas price %s, resume the fills", gasPrice.String(),
+				chain, resumeGasPrice.String()))
+		}
+		if !holding && engine.releaseAwaitingGasSwaps(chain) {
+			more = true
+		}
+	}
+	if more {
+		return util.RunAgain
+	}
+	return nil
+}
+
+// releaseAwaitingGasSwaps confirms a batch of the swaps to the chain held by the gas guard again, it returns whether
+// there may be more
+func (engine *SwapEngine) releaseAwaitingGasSwaps(chain string) bool {
+	swaps, err := engine.store.FindSwaps(SwapQuery{Statuses: []common.SwapStatus{SwapAwaitingGas},
+		Directions: getDirectionsToChain(chain), ByPriority: true, Limit: BatchSize})
+	if err != nil {
+		util.Logger.Errorf("query swaps awaiting gas to %s error: %s", chain, err.Error())
+		return false
+	}
+
+	for _, swap := range swaps {
+		util.Logger.Infof("gas price of %s is below the ceiling, release swap, start tx hash %s", chain, swap.StartTxHash)
+		writeDBErr := engine.store.Transaction(func(tx SwapStore) error {
+			if !engine.verifySwap(&swap) {
+				return fmt.Errorf("verify hmac of swap failed: %s", swap.StartTxHash)
+			}
+			swap.Status = SwapConfirmed
+			return engine.updateSwap(tx, &swap)
+		})
+		if writeDBErr != nil {
+			util.Logger.Errorf("write db error: %s", writeDBErr.Error())
+			util.SendTelegramMessage(fmt.Sprintf("write db error: %s", writeDBErr.Error()))
+			return false
+		}
+	}
+	return len(swaps) == BatchSize
+}
@@ -0,0 +1,224 @@
+package swap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+
+	"occ-swap-server/util"
+)
+
+// gasOracleClient suggests the gas price of the external gas oracles of the chain, every other call goes to the rpc.
+// The oracles are asked in order until one answers with a gas price below the ceiling of the chain, and the gas
+// price of the rpc is used if none does. The gas price is cached for the cache seconds of the config.
+type gasOracleClient struct {
+	ChainClient
+	chain   string
+	chainID int64
+	cfg     util.GasOracleConfig
+	client  *http.Client
+
+	mutex     sync.Mutex
+	gasPrice  *big.Int
+	fetchedAt time.Time
+	// the oracles failing are alerted once until one answers again
+	alerted bool
+}
+
+var _ ChainClient = (*gasOracleClient)(nil)
+var _ ReceiptBatcher = (*gasOracleClient)(nil)
+
+func newGasOracleClient(chain string, client ChainClient, chainID int64, cfg util.GasOracleConfig) *gasOracleClient {
+	return &gasOracleClient{
+		ChainClient: client,
+		chain:       chain,
+		chainID:     chainID,
+		cfg:         cfg,
+		client:      &http.Client{Timeout: cfg.GetTimeout()},
+	}
+}
+
+func (c *gasOracleClient) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	c.mutex.Lock()
+	if c.gasPrice != nil && time.Since(c.fetchedAt) < c.cfg.GetCache() {
+		gasPrice := new(big.Int).Set(c.gasPrice)
+		c.mutex.Unlock()
+		return gasPrice, nil
+	}
+	c.mutex.Unlock()
+
+	gasPrice, source := c.queryOracles(ctx)
+	if gasPrice == nil {
+		var err error
+		gasPrice, err = c.ChainClient.SuggestGasPrice(ctx)
+		if err != nil {
+			return nil, err
+		}
+		source = "rpc"
+	}
+	gasPriceGwei, _ := new(big.Float).Quo(new(big.Float).SetInt(gasPrice), big.NewFloat(params.GWei)).Float64()
+	// the chain is priced by one source at a time
+	for _, other := range []string{util.GasOracleEtherscan, util.GasOracleBlocknative, "rpc"} {
+		if other != source {
+			gasOraclePriceGauge.DeleteLabelValues(c.chain, other)
+		}
+	}
+	gasOraclePriceGauge.WithLabelValues(c.chain, source).Set(gasPriceGwei)
+
+	c.mutex.Lock()
+	c.gasPrice, c.fetchedAt = gasPrice, time.Now()
+	c.mutex.Unlock()
+	return new(big.Int).Set(gasPrice), nil
+}
+
+// queryOracles returns the gas price of the first oracle answering and its type, nil if none does
+func (c *gasOracleClient) queryOracles(ctx context.Context) (*big.Int, string) {
+	errs := make([]string, 0)
+	maxGasPrice := c.cfg.GetMaxGasPrice(c.chain)
+	for _, oracle := range c.cfg.GetOracles(c.chain) {
+		var gasPrice *big.Int
+		var err error
+		switch oracle.Type {
+		case util.GasOracleEtherscan:
+			gasPrice, err = c.queryEtherscan(ctx, oracle)
+		case util.GasOracleBlocknative:
+			gasPrice, err = c.queryBlocknative(ctx, oracle)
+		default:
+			err = fmt.Errorf("unknown type")
+		}
+		if err == nil && gasPrice.Sign() <= 0 {
+			err = fmt.Errorf("invalid gas price %s", gasPrice.String())
+		}
+		if err == nil && maxGasPrice != nil && gasPrice.Cmp(maxGasPrice) > 0 {
+			err = fmt.Errorf("gas price %s is above the max gas price %s", gasPrice.String(), maxGasPrice.String())
+		}
+		if err != nil {
+			util.Logger.Errorf("query %s gas oracle of %s error: %s", oracle.Type, c.chain, err.Error())
+			errs = append(errs, fmt.Sprintf("%s: %s", oracle.Type, err.Error()))
+			continue
+		}
+
+		c.mutex.Lock()
+		c.alerted = false
+		c.mutex.Unlock()
+		return gasPrice, oracle.Type
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.alerted {
+		c.alerted = true
+		util.SendTelegramMessage(fmt.Sprintf("no gas oracle of %s answers, the txs are priced by the gas price of the rpc until one does, errors: %s",
+			c.chain, strings.Join(errs, "; ")))
+	}
+	return nil, ""
+}
+
+func (c *gasOracleClient) get(ctx context.Context, url string, header http.Header, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	for key := range header {
+		req.Header.Set(key, header.Get(key))
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("response status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// queryEtherscan reads the gas price of the speed of the oracle from the gasoracle action of an etherscan api, the
+// prices are in gwei
+func (c *gasOracleClient) queryEtherscan(ctx context.Context, oracle util.GasOracle) (*big.Int, error) {
+	var result struct {
+		Status  string          `json:"status"`
+		Message string          `json:"message"`
+		Result  json.RawMessage `json:"result"`
+	}
+	url := fmt.Sprintf("%s?module=gastracker&action=gasoracle&apikey=%s", oracle.GetUrl(), oracle.ApiKey)
+	if err := c.get(ctx, url, nil, &result); err != nil {
+		return nil, err
+	}
+	if result.Status != "1" {
+		return nil, fmt.Errorf("status %s, %s: %s", result.Status, result.Message, string(result.Result))
+	}
+	var prices struct {
+		SafeGasPrice    string `json:"SafeGasPrice"`
+		ProposeGasPrice string `json:"ProposeGasPrice"`
+		FastGasPrice    string `json:"FastGasPrice"`
+	}
+	if err := json.Unmarshal(result.Result, &prices); err != nil {
+		return nil, err
+	}
+	price := prices.ProposeGasPrice
+	switch oracle.GetSpeed() {
+	case "safe":
+		price = prices.SafeGasPrice
+	case "fast":
+		price = prices.FastGasPrice
+	}
+	gwei, ok := new(big.Float).SetString(price)
+	if !ok {
+		return nil, fmt.Errorf("invalid %s gas price %q", oracle.GetSpeed(), price)
+	}
+	return gweiToWei(gwei), nil
+}
+
+// queryBlocknative reads the gas price of the confidence of the oracle from the prices estimated for the next block
+// of the chain, the prices are in gwei
+func (c *gasOracleClient) queryBlocknative(ctx context.Context, oracle util.GasOracle) (*big.Int, error) {
+	var result struct {
+		BlockPrices []struct {
+			EstimatedPrices []struct {
+				Confidence int64   `json:"confidence"`
+				Price      float64 `json:"price"`
+			} `json:"estimatedPrices"`
+		} `json:"blockPrices"`
+	}
+	url := fmt.Sprintf("%s?chainid=%d", oracle.GetUrl(), c.chainID)
+	if err := c.get(ctx, url, http.Header{"Authorization": []string{oracle.ApiKey}}, &result); err != nil {
+		return nil, err
+	}
+	if len(result.BlockPrices) == 0 {
+		return nil, fmt.Errorf("no block prices")
+	}
+	for _, estimated := range result.BlockPrices[0].EstimatedPrices {
+		if estimated.Confidence == oracle.GetConfidence() {
+			return gweiToWei(big.NewFloat(estimated.Price)), nil
+		}
+	}
+	return nil, fmt.Errorf("no price of confidence %d", oracle.GetConfidence())
+}
+
+func gweiToWei(gwei *big.Float) *big.Int {
+	wei, _ := new(big.Float).Mul(gwei, big.NewFloat(params.GWei)).Int(nil)
+	return wei
+}
+
+// TransactionReceipts keeps the receipts of the rpc batched if the client of the chain batches them
+func (c *gasOracleClient) TransactionReceipts(ctx context.Context, txHashes []ethcom.Hash) ([]*types.Receipt, []error) {
+	if batcher, ok := c.ChainClient.(ReceiptBatcher); ok {
+		return batcher.TransactionReceipts(ctx, txHashes)
+	}
+	receipts := make([]*types.Receipt, len(txHashes))
+	errs := make([]error, len(txHashes))
+	for i, txHash := range txHashes {
+		receipts[i], errs[i] = c.TransactionReceipt(ctx, txHash)
+	}
+	return receipts, errs
+}
@@ -0,0 +1,74 @@
+package swap
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"occ-swap-server/util"
+)
+
+// MaxStaleHeadIntervals is the number of poll intervals after which the cached head of a chain is not used
+const MaxStaleHeadIntervals = 3
+
+// headTracker polls the latest block number of a chain once per interval, the daemons tracking the txs of the
+// chain share it rather than querying the latest block for every tx. Only the header is fetched since the
+// ethclient of this geth version has no eth_blockNumber call.
+type headTracker struct {
+	chain    string
+	client   ChainClient
+	interval time.Duration
+
+	mutex      sync.RWMutex
+	height     int64
+	updateTime time.Time
+}
+
+func newHeadTracker(chain string, client ChainClient, interval time.Duration) *headTracker {
+	return &headTracker{
+		chain:    chain,
+		client:   client,
+		interval: interval,
+	}
+}
+
+func (t *headTracker) Start(scheduler *util.Scheduler) {
+	scheduler.Go(util.Daemon{Name: "head_" + t.chain, Interval: t.interval, Run: t.pollDaemon})
+}
+
+// Height returns the latest block number of the chain, it fails if the head is not fetched recently
+func (t *headTracker) Height() (int64, error) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	if t.updateTime.IsZero() {
+		return 0, fmt.Errorf("head of %s is not fetched yet", t.chain)
+	}
+	if time.Since(t.updateTime) > MaxStaleHeadIntervals*t.interval {
+		return 0, fmt.Errorf("head of %s is stale, last updated at %s", t.chain, t.updateTime.Format(time.RFC3339))
+	}
+	return t.height, nil
+}
+
+func (t *headTracker) pollDaemon() error {
+	if err := t.update(); err != nil {
+		// the failures are counted by the circuit breaker of the chain, the head is polled again at the interval
+		util.Logger.Debugf("%s, query latest header failed: %s", t.chain, err.Error())
+	}
+	return nil
+}
+
+func (t *headTracker) update() error {
+	header, err := t.client.HeaderByNumber(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	chainHeadGauge.WithLabelValues(t.chain).Set(float64(header.Number.Int64()))
+
+	t.mutex.Lock()
+	t.height = header.Number.Int64()
+	t.updateTime = time.Now()
+	t.mutex.Unlock()
+	return nil
+}
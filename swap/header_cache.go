@@ -0,0 +1,235 @@
+package swap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"occ-swap-server/util"
+)
+
+// headers synced a run, the daemon runs again at once while it is behind the providers
+const headerSyncBatch = 100
+
+// errHeaderNotSynced is returned for the heights above the synced tip of the header cache
+var errHeaderNotSynced = errors.New("header is not synced yet")
+
+// cachedHeader is the part of a block header the source events are checked against. The hash is the one the
+// providers agree on rather than the hash of the rlp header, the hashes of some chains, e.g. cronos, are not.
+type cachedHeader struct {
+	Number      *hexutil.Big `json:"number"`
+	Hash        ethcom.Hash  `json:"hash"`
+	ParentHash  ethcom.Hash  `json:"parentHash"`
+	TxHash      ethcom.Hash  `json:"transactionsRoot"`
+	ReceiptHash ethcom.Hash  `json:"receiptsRoot"`
+}
+
+func (h *cachedHeader) Height() int64 {
+	return h.Number.ToInt().Int64()
+}
+
+// quorumHeaderCache keeps the last headers of a chain synced from its header providers, independent of the rpc of
+// the engine. A header is synced once the quorum of the providers return it, and it must be the child of the tip,
+// the tip is dropped otherwise so that the reorged blocks are synced again. It is not a light client, the headers
+// are not checked against the consensus of the chain, e.g. the validator signatures of parlia on bsc or the
+// tendermint commits on cronos, so it holds against a compromised rpc as long as less than the quorum of the
+// providers are compromised together.
+type quorumHeaderCache struct {
+	chain     string
+	providers []rpcProvider
+	quorum    int
+	window    int64
+	interval  time.Duration
+
+	mutex   sync.RWMutex
+	headers map[int64]*cachedHeader
+	// synced once the first header is synced
+	synced bool
+	tip    int64
+}
+
+func newQuorumHeaderCache(chain string, providers []rpcProvider, cfg util.HeaderVerificationConfig) *quorumHeaderCache {
+	return &quorumHeaderCache{
+		chain:     chain,
+		providers: providers,
+		quorum:    cfg.GetQuorum(chain),
+		window:    cfg.GetWindow(),
+		interval:  cfg.GetInterval(),
+		headers:   make(map[int64]*cachedHeader),
+	}
+}
+
+// newQuorumHeaderCaches dials the header providers of the chains with header verification enabled
+func newQuorumHeaderCaches(cfg util.HeaderVerificationConfig, chains []string) (map[string]*quorumHeaderCache, error) {
+	headerCaches := make(map[string]*quorumHeaderCache)
+	for _, chain := range chains {
+		if !cfg.Enabled(chain) {
+			continue
+		}
+		providers, err := dialProviders(chain, cfg.GetProviders(chain))
+		if err != nil {
+			return nil, err
+		}
+		headerCaches[chain] = newQuorumHeaderCache(chain, providers, cfg)
+	}
+	return headerCaches, nil
+}
+
+func (c *quorumHeaderCache) Start(scheduler *util.Scheduler) {
+	scheduler.Go(util.Daemon{Name: "sync_headers_" + c.chain, Interval: c.interval, Run: c.syncDaemon})
+}
+
+// Header returns the header of the quorum at the height, the heights below the synced window are asked to the quorum
+// of the providers
+func (c *quorumHeaderCache) Header(height int64) (*cachedHeader, error) {
+	c.mutex.RLock()
+	header, ok := c.headers[height]
+	synced, tip := c.synced, c.tip
+	c.mutex.RUnlock()
+	if ok {
+		return header, nil
+	}
+	if !synced || height > tip {
+		return nil, fmt.Errorf("%w, height %d of %s, synced to %d", errHeaderNotSynced, height, c.chain, tip)
+	}
+	return c.quorumHeader(height)
+}
+
+// syncDaemon syncs the headers from the tip to the height the quorum of the providers has reached
+func (c *quorumHeaderCache) syncDaemon() error {
+	latest, err := c.quorumHeight()
+	if err != nil {
+		return err
+	}
+
+	c.mutex.RLock()
+	synced, tip := c.synced, c.tip
+	c.mutex.RUnlock()
+	if !synced {
+		// the first header is the checkpoint of the chain, the older headers are only asked to the quorum
+		header, err := c.quorumHeader(latest)
+		if err != nil {
+			return err
+		}
+		c.append(header)
+		util.Logger.Infof("header cache of %s starts at %d, hash %s", c.chain, latest, header.Hash.String())
+		return nil
+	}
+
+	for height := tip + 1; height <= latest && height <= tip+headerSyncBatch; height++ {
+		header, err := c.quorumHeader(height)
+		if err != nil {
+			return err
+		}
+		if !c.append(header) {
+			// a block a run is dropped, the providers returning the headers of different forks can't spin the daemon
+			util.Logger.Infof("header %d of %s doesn't link to the tip, the tip is reorged", height, c.chain)
+			return nil
+		}
+	}
+	if latest > tip+headerSyncBatch {
+		return util.RunAgain
+	}
+	return nil
+}
+
+// append adds the header on top of the tip, the tip is dropped instead if the header isn't its child
+func (c *quorumHeaderCache) append(header *cachedHeader) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.synced {
+		parent := c.headers[c.tip]
+		if header.ParentHash != parent.Hash {
+			delete(c.headers, c.tip)
+			c.tip--
+			if _, ok := c.headers[c.tip]; !ok {
+				// the whole window is reorged, the cache starts again from a new checkpoint
+				util.Logger.Errorf("header cache of %s is reorged below its first header %d, it starts again", c.chain, c.tip+1)
+				c.synced = false
+				c.headers = make(map[int64]*cachedHeader)
+			}
+			return false
+		}
+	}
+	c.synced = true
+	c.tip = header.Height()
+	c.headers[c.tip] = header
+	delete(c.headers, c.tip-c.window)
+	quorumHeaderGauge.WithLabelValues(c.chain).Set(float64(c.tip))
+	return true
+}
+
+// quorumHeight returns the highest height the quorum of the providers has reached
+func (c *quorumHeaderCache) quorumHeight() (int64, error) {
+	heights := make([]int64, 0, len(c.providers))
+	for _, provider := range c.providers {
+		header, err := c.getHeader(provider, "latest")
+		if err != nil {
+			util.Logger.Debugf("query latest header of %s from header provider error: %s", c.chain, err.Error())
+			continue
+		}
+		heights = append(heights, header.Height())
+	}
+	if len(heights) < c.quorum {
+		return 0, fmt.Errorf("only %d of the %d header providers of %s are reachable, quorum is %d",
+			len(heights), len(c.providers), c.chain, c.quorum)
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] > heights[j] })
+	return heights[c.quorum-1], nil
+}
+
+// quorumHeader returns the header at the height once the quorum of the providers return the same header
+func (c *quorumHeaderCache) quorumHeader(height int64) (*cachedHeader, error) {
+	votes := make(map[[4]ethcom.Hash]int)
+	for _, provider := range c.providers {
+		header, err := c.getHeader(provider, hexutil.EncodeBig(big.NewInt(height)))
+		if err != nil {
+			util.Logger.Debugf("query header %d of %s from header provider error: %s", height, c.chain, err.Error())
+			continue
+		}
+		if header.Height() != height {
+			continue
+		}
+		vote := [4]ethcom.Hash{header.Hash, header.ParentHash, header.TxHash, header.ReceiptHash}
+		votes[vote]++
+		if votes[vote] >= c.quorum {
+			return header, nil
+		}
+	}
+	return nil, fmt.Errorf("header providers of %s don't agree on header %d, quorum is %d of %d", c.chain, height,
+		c.quorum, len(c.providers))
+}
+
+func (c *quorumHeaderCache) getHeader(provider rpcProvider, number string) (*cachedHeader, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), providerTimeout)
+	defer cancel()
+
+	var header *cachedHeader
+	if err := provider.CallContext(ctx, &header, "eth_getBlockByNumber", number, false); err != nil {
+		return nil, err
+	}
+	if header == nil || header.Number == nil {
+		return nil, fmt.Errorf("header %s not found", number)
+	}
+	return header, nil
+}
+
+// quorumReceipt returns the receipt of the tx once the quorum of the providers return the same receipt, for the
+// chains whose headers don't commit to the receipts
+func (c *quorumHeaderCache) quorumReceipt(txHash ethcom.Hash) (*types.Receipt, error) {
+	receipt, votes := tallyReceipts(c.chain, c.providers, txHash).leader()
+	if votes < c.quorum || receipt == nil {
+		return nil, fmt.Errorf("header providers of %s don't agree on the receipt of tx %s, quorum is %d of %d", c.chain,
+			txHash.String(), c.quorum, len(c.providers))
+	}
+	return receipt, nil
+}
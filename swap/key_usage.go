@@ -0,0 +1,39 @@
+package swap
+
+import (
+	"math/big"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"occ-swap-server/model"
+)
+
+// KeyUse is what a relayer key signs a tx for, it is recorded in the key usage audit log with every tx signed
+type KeyUse struct {
+	Purpose string
+	// the daemon signing the tx
+	Daemon string
+	// the token and the amount moved by the tx, nil amount if it moves none
+	Token  ethcom.Address
+	Amount *big.Int
+}
+
+// recordKeyUsage saves the signature of the tx by the relayer account, the tx must not be sent if it fails so no
+// signature is missing from the audit log
+func (engine *SwapEngine) recordKeyUsage(chain string, account ethcom.Address, use KeyUse, signedTx *types.Transaction) error {
+	keyUsage := &model.KeyUsage{
+		Purpose:  use.Purpose,
+		Chain:    chain,
+		Account:  account.String(),
+		TxHash:   signedTx.Hash().String(),
+		Nonce:    signedTx.Nonce(),
+		Daemon:   use.Daemon,
+		Instance: engine.instanceID,
+	}
+	if use.Amount != nil {
+		keyUsage.Token = use.Token.String()
+		keyUsage.Amount = use.Amount.String()
+	}
+	return engine.store.CreateKeyUsage(keyUsage)
+}
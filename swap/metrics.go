@@ -0,0 +1,75 @@
+package swap
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const MetricsNamespace = "bridge"
+
+// Metric describes a gauge exposed by the server, the dashboards and the alert rules are generated from them
+type Metric struct {
+	// full name of the metric, prefixed by the namespace
+	Name   string
+	Help   string
+	Labels []string
+}
+
+// metrics lists the gauges in the order they are registered
+var metrics = make([]Metric, 0)
+
+// Metrics returns the gauges exposed by the server
+func Metrics() []Metric {
+	return append([]Metric(nil), metrics...)
+}
+
+func newGaugeVec(name, help string, labels ...string) (*prometheus.GaugeVec, Metric) {
+	metric := Metric{Name: prometheus.BuildFQName(MetricsNamespace, "", name), Help: help, Labels: labels}
+	metrics = append(metrics, metric)
+	return promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: MetricsNamespace,
+		Name:      name,
+		Help:      help,
+	}, labels), metric
+}
+
+var (
+	liquidityBalanceGauge, liquidityBalanceMetric = newGaugeVec("liquidity_balance",
+		"Token balance of the swap agent on the destination chain.", "chain", "token")
+	liquidityAvailableGauge, liquidityAvailableMetric = newGaugeVec("liquidity_available",
+		"Token balance of the swap agent on the destination chain minus the amount of the swaps being filled.", "chain", "token")
+	relayerBalanceGauge, relayerBalanceMetric = newGaugeVec("relayer_balance",
+		"Native coin balance of the relayer accounts on the destination chain.", "chain", "account")
+	stuckSwapsGauge, stuckSwapsMetric = newGaugeVec("stuck_swaps",
+		"Number of the swaps lingering in an intermediate state which the watchdog can't heal.", "kind")
+	chainHeadGauge, chainHeadMetric = newGaugeVec("chain_head_height",
+		"Latest block number of the chain polled by the swap engine.", "chain")
+	circuitOpenGauge, circuitOpenMetric = newGaugeVec("rpc_circuit_open",
+		"Whether the circuit breaker of the chain rpc is open, 1 while the chain is degraded.", "chain")
+	daemonPanicsGauge, daemonPanicsMetric = newGaugeVec("daemon_panics",
+		"Number of the panics of the daemon recovered by the scheduler since the server started.", "daemon")
+	quorumHeaderGauge, quorumHeaderMetric = newGaugeVec("quorum_header_height",
+		"Tip of the header cache synced from the quorum of the header providers of the chain.", "chain")
+	rpcTimeoutsGauge, rpcTimeoutsMetric = newGaugeVec("rpc_call_timeouts",
+		"Number of the calls to the chain rpc which hung until the rpc timeout since the server started.", "chain", "method")
+	relayerSpendGauge, relayerSpendMetric = newGaugeVec("relayer_hourly_spend",
+		"Native coin or tokens of a pair sent by the relayer account within the last hour.", "chain", "account", "token")
+	drainBrakeHaltedGauge, drainBrakeHaltedMetric = newGaugeVec("drain_brake_halted",
+		"Whether the fills to the chain are halted by the drain brake, 1 until the admin releases it.", "chain")
+	relayerTokenBalanceGauge, relayerTokenBalanceMetric = newGaugeVec("relayer_token_balance",
+		"Balance of a token of a pair held by the relayer account.", "chain", "account", "token")
+	relayerTokenLowGauge, relayerTokenLowMetric = newGaugeVec("relayer_token_low",
+		"Whether the balance of a token held by the relayer account is below the min balance of the token, 1 while it is.", "chain", "account", "token")
+	eventExportLagGauge, eventExportLagMetric = newGaugeVec("event_export_lag_seconds",
+		"Age of the oldest bridge event not published to the event sink yet, 0 once all are published.", "sink")
+	inventoryImbalanceGauge, inventoryImbalanceMetric = newGaugeVec("inventory_imbalance",
+		"Points of the total bridged token the inventory of the swap agent is off its rebalance target, positive for a surplus.", "chain")
+	withdrawalRemainingGauge, withdrawalRemainingMetric = newGaugeVec("withdrawal_limit_remaining",
+		"Tokens which may still be filled to the chain within the current day of its withdrawal limit.", "chain")
+	gasGuardHoldingGauge, gasGuardHoldingMetric = newGaugeVec("gas_guard_holding",
+		"Whether the fills to the chain are held by the gas guard while its gas price is above the ceiling, 1 while they are.", "chain")
+	gasOraclePriceGauge, gasOraclePriceMetric = newGaugeVec("gas_oracle_price_gwei",
+		"Gas price in gwei the txs of the chain are priced by, and the gas oracle or the rpc it is from.", "chain", "source")
+	queueAgeGauge, queueAgeMetric = newGaugeVec("queue_oldest_age_seconds",
+		"Seconds the oldest swap of the direction waits in the processing stage, 0 if none does.", "stage", "direction")
+)
@@ -0,0 +1,159 @@
+package swap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"occ-swap-server/common"
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+// NonceReconciliation is the result of reconciling the nonces of a relayer account with its fill txs which are
+// signed but not mined, it is done on startup
+type NonceReconciliation struct {
+	Chain        string `json:"chain"`
+	Address      string `json:"address"`
+	MinedNonce   uint64 `json:"mined_nonce"`
+	PendingNonce uint64 `json:"pending_nonce"`
+	// nonces of the stored fill txs sent again
+	Rebroadcast []uint64 `json:"rebroadcast"`
+	// nonces without any stored fill tx, filled by self transfers
+	Plugged []uint64 `json:"plugged"`
+	Error   string   `json:"error"`
+	Time    int64    `json:"time"`
+}
+
+// reconcileRelayerNonces finds the nonce gaps of the relayer accounts left by the fill txs which are signed but
+// never mined, e.g. when the server stops before they are sent. The stored raw txs of the gaps are rebroadcast, the
+// gaps without any stored tx are plugged by self transfers, otherwise the fill txs after a gap are never mined.
+func (engine *SwapEngine) reconcileRelayerNonces() {
+	results := make([]NonceReconciliation, 0)
+	for _, chain := range []string{common.ChainBSC, common.ChainETH, common.ChainMATIC} {
+		for _, broadcaster := range engine.relayerPools[chain].getBroadcasters() {
+			result := engine.reconcileNonces(chain, broadcaster)
+			results = append(results, result)
+
+			summary := fmt.Sprintf("reconcile nonces of relayer %s on %s, mined nonce %d, pending nonce %d, rebroadcast %v, plugged %v",
+				result.Address, chain, result.MinedNonce, result.PendingNonce, result.Rebroadcast, result.Plugged)
+			if result.Error != "" {
+				util.Logger.Errorf("%s, err: %s", summary, result.Error)
+				util.SendTelegramMessage(fmt.Sprintf("%s, err: %s", summary, result.Error))
+			} else if len(result.Rebroadcast) != 0 || len(result.Plugged) != 0 {
+				util.Logger.Infof(summary)
+				util.SendTelegramMessage(summary)
+			} else {
+				util.Logger.Infof(summary)
+			}
+		}
+	}
+
+	engine.mutex.Lock()
+	engine.nonceReconciliations = results
+	engine.mutex.Unlock()
+}
+
+func (engine *SwapEngine) reconcileNonces(chain string, broadcaster *Broadcaster) NonceReconciliation {
+	result := NonceReconciliation{
+		Chain:       chain,
+		Address:     broadcaster.Account().String(),
+		Rebroadcast: make([]uint64, 0),
+		Plugged:     make([]uint64, 0),
+		Time:        time.Now().Unix(),
+	}
+	client := engine.getClient(chain)
+	minedNonce, err := client.NonceAt(context.Background(), broadcaster.Account(), nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	pendingNonce, err := client.PendingNonceAt(context.Background(), broadcaster.Account())
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.MinedNonce = minedNonce
+	result.PendingNonce = pendingNonce
+
+	signedTxs, err := engine.getUnminedFillTxs(chain, broadcaster, minedNonce)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	maxNonce := minedNonce
+	for nonce := range signedTxs {
+		if nonce > maxNonce {
+			maxNonce = nonce
+		}
+	}
+
+	// the nonces below the pending nonce are known by the node already
+	for nonce := pendingNonce; nonce <= maxNonce && len(signedTxs) != 0; nonce++ {
+		if signedTx, ok := signedTxs[nonce]; ok {
+			if err := client.SendTransaction(context.Background(), signedTx); err != nil && !isKnownTxErr(err) {
+				result.Error = fmt.Sprintf("rebroadcast tx %s of nonce %d error: %s", signedTx.Hash().String(), nonce, err.Error())
+				return result
+			}
+			result.Rebroadcast = append(result.Rebroadcast, nonce)
+			continue
+		}
+		// the nonce may be reserved by another replica which is about to sign its tx
+		if reserved, err := broadcaster.reserve(nonce); err != nil || !reserved {
+			if err != nil {
+				result.Error = fmt.Sprintf("plug nonce %d error: %s", nonce, err.Error())
+				return result
+			}
+			continue
+		}
+		if _, err := broadcaster.sendSelfTransfer(KeyUse{Purpose: model.KeyUsageNoncePlug, Daemon: "reconcile_nonces"}, nonce); err != nil {
+			result.Error = fmt.Sprintf("plug nonce %d error: %s", nonce, err.Error())
+			return result
+		}
+		result.Plugged = append(result.Plugged, nonce)
+	}
+	return result
+}
+
+// getUnminedFillTxs returns the stored fill txs of the relayer account which are created or sent and whose nonces
+// are not mined, the one with the highest gas price is returned for a nonce
+func (engine *SwapEngine) getUnminedFillTxs(chain string, broadcaster *Broadcaster, minedNonce uint64) (map[uint64]*types.Transaction, error) {
+	swapTxs, err := engine.store.FindUnminedFillTxs(getDirectionsToChain(chain))
+	if err != nil {
+		return nil, err
+	}
+
+	signedTxs := make(map[uint64]*types.Transaction)
+	signer := types.NewEIP155Signer(broadcaster.chainId)
+	for i := range swapTxs {
+		signedTx, err := decodeRawTx(&swapTxs[i])
+		if err != nil {
+			util.Logger.Errorf("decode raw tx of fill tx %s error: %s", swapTxs[i].FillSwapTxHash, err.Error())
+			continue
+		}
+		sender, err := types.Sender(signer, signedTx)
+		if err != nil || sender != broadcaster.Account() || signedTx.Nonce() < minedNonce {
+			continue
+		}
+		if existing, ok := signedTxs[signedTx.Nonce()]; ok && existing.GasPrice().Cmp(signedTx.GasPrice()) >= 0 {
+			continue
+		}
+		signedTxs[signedTx.Nonce()] = signedTx
+	}
+	return signedTxs, nil
+}
+
+// isKnownTxErr is true if the node has the tx already
+func isKnownTxErr(err error) bool {
+	return strings.Contains(err.Error(), "already known") || strings.Contains(err.Error(), "known transaction")
+}
+
+// GetNonceReconciliations returns the results of reconciling the nonces of the relayer accounts on startup
+func (engine *SwapEngine) GetNonceReconciliations() []NonceReconciliation {
+	engine.mutex.RLock()
+	defer engine.mutex.RUnlock()
+	return engine.nonceReconciliations
+}
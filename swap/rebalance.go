@@ -0,0 +1,471 @@
+package swap
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+// RebalancePlanner is the proposer of the plans of rebalanceDaemon
+const RebalancePlanner = "rebalance_planner"
+
+// openRebalancePlanStatuses are the statuses of the plans which are not done, no other plan is proposed meanwhile
+var openRebalancePlanStatuses = []model.RebalancePlanStatus{model.RebalancePlanPending, model.RebalancePlanApproved,
+	model.RebalancePlanExecuting}
+
+// ChainInventory is the bridged token held by the swap agent of a chain against its target share of the total
+type ChainInventory struct {
+	Chain string `json:"chain"`
+	Token string `json:"token"`
+	// balance of the swap agent minus the swaps being filled
+	Inventory     string `json:"inventory"`
+	Target        string `json:"target"`
+	TargetPercent int64  `json:"target_percent"`
+	// points of the total the inventory is off the target, positive for a surplus
+	ImbalancePercent float64 `json:"imbalance_percent"`
+
+	inventory *big.Int
+	target    *big.Int
+}
+
+// RebalanceTransfer moves the amount of the inventory from the swap agent of FromChain, which has a surplus, to the
+// swap agent of ToChain. A relayer of ToChain executes it by swapping its own tokens of ToChain to FromChain.
+type RebalanceTransfer struct {
+	FromChain string `json:"from_chain"`
+	ToChain   string `json:"to_chain"`
+	Amount    string `json:"amount"`
+	// swap tx of the relayer, set once the transfer is executed by the relayer
+	TxHash string `json:"tx_hash,omitempty"`
+}
+
+// RebalancePlanDetail is a rebalance plan with its transfers and inventory decoded
+type RebalancePlanDetail struct {
+	ID         uint                      `json:"id"`
+	Status     model.RebalancePlanStatus `json:"status"`
+	Transfers  []RebalanceTransfer       `json:"transfers"`
+	Inventory  []ChainInventory          `json:"inventory"`
+	Proposer   string                    `json:"proposer"`
+	Approver   string                    `json:"approver"`
+	ErrorMsg   string                    `json:"error_msg"`
+	CreateTime int64                     `json:"create_time"`
+	UpdateTime int64                     `json:"update_time"`
+}
+
+func newRebalancePlanDetail(plan *model.RebalancePlan) (*RebalancePlanDetail, error) {
+	detail := &RebalancePlanDetail{
+		ID:         plan.ID,
+		Status:     plan.Status,
+		Proposer:   plan.Proposer,
+		Approver:   plan.Approver,
+		ErrorMsg:   plan.ErrorMsg,
+		CreateTime: plan.CreatedAt.Unix(),
+		UpdateTime: plan.UpdatedAt.Unix(),
+	}
+	if err := json.Unmarshal([]byte(plan.Transfers), &detail.Transfers); err != nil {
+		return nil, fmt.Errorf("decode transfers of rebalance plan %d error: %s", plan.ID, err.Error())
+	}
+	if err := json.Unmarshal([]byte(plan.Inventory), &detail.Inventory); err != nil {
+		return nil, fmt.Errorf("decode inventory of rebalance plan %d error: %s", plan.ID, err.Error())
+	}
+	return detail, nil
+}
+
+// GetInventory returns the inventory of the chains with a target, from the liquidity tracked by the liquidity daemon
+func (engine *SwapEngine) GetInventory() ([]ChainInventory, error) {
+	cfg := engine.config.RebalanceConfig
+	if !cfg.Enabled() {
+		return nil, fmt.Errorf("rebalance_config has no target_percents")
+	}
+	liquidity := make(map[string]Liquidity)
+	for _, l := range engine.GetLiquidity() {
+		liquidity[l.Chain] = l
+	}
+
+	chains := make([]string, 0, len(cfg.TargetPercents))
+	for chain := range cfg.TargetPercents {
+		chains = append(chains, chain)
+	}
+	sort.Strings(chains)
+	inventory := make([]ChainInventory, 0, len(chains))
+	total := big.NewInt(0)
+	for _, chain := range chains {
+		l, ok := liquidity[chain]
+		if !ok {
+			return nil, fmt.Errorf("liquidity of %s is not tracked yet", chain)
+		}
+		balance, _ := big.NewInt(0).SetString(l.Balance, 10)
+		pending, _ := big.NewInt(0).SetString(l.Pending, 10)
+		if balance == nil {
+			return nil, fmt.Errorf("invalid liquidity balance %s of %s", l.Balance, chain)
+		}
+		amount := new(big.Int).Set(balance)
+		if pending != nil {
+			amount.Sub(amount, pending)
+		}
+		if amount.Sign() < 0 {
+			amount.SetInt64(0)
+		}
+		total.Add(total, amount)
+		inventory = append(inventory, ChainInventory{Chain: chain, Token: l.Token, TargetPercent: cfg.TargetPercents[chain],
+			inventory: amount})
+	}
+
+	for i := range inventory {
+		target := new(big.Int).Mul(total, big.NewInt(inventory[i].TargetPercent))
+		target.Div(target, big.NewInt(100))
+		inventory[i].target = target
+		inventory[i].Inventory = inventory[i].inventory.String()
+		inventory[i].Target = target.String()
+		if total.Sign() > 0 {
+			diff := new(big.Float).SetInt(new(big.Int).Sub(inventory[i].inventory, target))
+			percent, _ := diff.Quo(diff, new(big.Float).SetInt(total)).Float64()
+			inventory[i].ImbalancePercent = percent * 100
+		}
+		inventoryImbalanceGauge.WithLabelValues(inventory[i].Chain).Set(inventory[i].ImbalancePercent)
+	}
+	return inventory, nil
+}
+
+// planRebalance pairs the largest surplus with the largest deficit until every chain is within the min transfer of
+// its target. Nothing is planned unless a chain is more than the threshold off its target. A transfer is split into
+// transfers of at most the max transfer of its destination chain, the upper bound of a swap started there.
+func planRebalance(inventory []ChainInventory, thresholdPercent int64, minTransfer *big.Int,
+	maxTransfer map[string]*big.Int) []RebalanceTransfer {
+	transfers := make([]RebalanceTransfer, 0)
+	imbalanced := false
+	for _, chain := range inventory {
+		if chain.ImbalancePercent > float64(thresholdPercent) || chain.ImbalancePercent < -float64(thresholdPercent) {
+			imbalanced = true
+		}
+	}
+	if !imbalanced {
+		return transfers
+	}
+
+	diffs := make(map[string]*big.Int, len(inventory))
+	for _, chain := range inventory {
+		diffs[chain.Chain] = new(big.Int).Sub(chain.inventory, chain.target)
+	}
+	for {
+		var from, to string
+		for _, chain := range inventory {
+			diff := diffs[chain.Chain]
+			if diff.Sign() > 0 && (from == "" || diff.Cmp(diffs[from]) > 0) {
+				from = chain.Chain
+			}
+			if diff.Sign() < 0 && (to == "" || diff.Cmp(diffs[to]) < 0) {
+				to = chain.Chain
+			}
+		}
+		if from == "" || to == "" {
+			break
+		}
+		amount := new(big.Int).Neg(diffs[to])
+		if diffs[from].Cmp(amount) < 0 {
+			amount.Set(diffs[from])
+		}
+		if amount.Cmp(minTransfer) < 0 || amount.Sign() == 0 {
+			break
+		}
+		diffs[from].Sub(diffs[from], amount)
+		diffs[to].Add(diffs[to], amount)
+		for amount.Sign() > 0 {
+			part := new(big.Int).Set(amount)
+			if max := maxTransfer[to]; max != nil && max.Sign() > 0 && part.Cmp(max) > 0 {
+				part.Set(max)
+			}
+			transfers = append(transfers, RebalanceTransfer{FromChain: from, ToChain: to, Amount: part.String()})
+			amount.Sub(amount, part)
+		}
+	}
+	return transfers
+}
+
+// ProposeRebalancePlan plans the transfers from the current inventory and saves them as a pending plan, nil if no
+// transfer is needed. It fails while another plan is not done.
+func (engine *SwapEngine) ProposeRebalancePlan(proposer string) (*RebalancePlanDetail, error) {
+	inventory, err := engine.GetInventory()
+	if err != nil {
+		return nil, err
+	}
+	maxTransfer := make(map[string]*big.Int)
+	for _, chain := range inventory {
+		if pair, err := engine.getFungiblePair(chain.Chain, chain.Token); err == nil {
+			maxTransfer[chain.Chain] = pair.UpperBound
+		}
+	}
+	cfg := engine.config.RebalanceConfig
+	transfers := planRebalance(inventory, cfg.ThresholdPercent, cfg.GetMinTransfer(), maxTransfer)
+	if len(transfers) == 0 {
+		return nil, nil
+	}
+
+	transfersJson, err := json.Marshal(transfers)
+	if err != nil {
+		return nil, err
+	}
+	inventoryJson, err := json.Marshal(inventory)
+	if err != nil {
+		return nil, err
+	}
+	plan := &model.RebalancePlan{
+		Status:    model.RebalancePlanPending,
+		Transfers: string(transfersJson),
+		Inventory: string(inventoryJson),
+		Proposer:  proposer,
+	}
+	err = engine.store.Transaction(func(tx SwapStore) error {
+		open, err := tx.CountRebalancePlans(openRebalancePlanStatuses)
+		if err != nil {
+			return err
+		}
+		if open != 0 {
+			return fmt.Errorf("another rebalance plan is not done")
+		}
+		return tx.CreateRebalancePlan(plan)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	util.Logger.Infof("rebalance plan %d is proposed by %s: %s", plan.ID, proposer, plan.Transfers)
+	util.SendTelegramMessage(fmt.Sprintf("rebalance plan %d of %d transfers is proposed by %s, the inventory is %s, approve or reject it with POST /rebalance_plan",
+		plan.ID, len(transfers), proposer, plan.Inventory))
+	return newRebalancePlanDetail(plan)
+}
+
+// GetRebalancePlans returns the latest rebalance plans
+func (engine *SwapEngine) GetRebalancePlans(limit int) ([]RebalancePlanDetail, error) {
+	plans, err := engine.store.FindLatestRebalancePlans(limit)
+	if err != nil {
+		return nil, err
+	}
+	details := make([]RebalancePlanDetail, 0, len(plans))
+	for i := range plans {
+		detail, err := newRebalancePlanDetail(&plans[i])
+		if err != nil {
+			return nil, err
+		}
+		details = append(details, *detail)
+	}
+	return details, nil
+}
+
+// ApproveRebalancePlan approves the pending plan by another operator than its proposer, the approved plans are
+// executed by rebalanceDaemon if auto_execute of rebalance_config is set, else by hand
+func (engine *SwapEngine) ApproveRebalancePlan(id uint, approver string) (*RebalancePlanDetail, error) {
+	return engine.updateRebalancePlan(id, approver, []model.RebalancePlanStatus{model.RebalancePlanPending},
+		func(plan *model.RebalancePlan) error {
+			if plan.Proposer == approver {
+				return fmt.Errorf("rebalance plan %d is proposed by %s, it is approved by another operator", id, approver)
+			}
+			plan.Status, plan.Approver = model.RebalancePlanApproved, approver
+			return nil
+		})
+}
+
+// RejectRebalancePlan rejects the pending or approved plan, so the next plan is proposed
+func (engine *SwapEngine) RejectRebalancePlan(id uint, operator, reason string) (*RebalancePlanDetail, error) {
+	return engine.updateRebalancePlan(id, operator, []model.RebalancePlanStatus{model.RebalancePlanPending, model.RebalancePlanApproved},
+		func(plan *model.RebalancePlan) error {
+			plan.Status, plan.Approver, plan.ErrorMsg = model.RebalancePlanRejected, operator, reason
+			return nil
+		})
+}
+
+// MarkRebalancePlanExecuted marks the approved plan executed by hand, only without auto_execute
+func (engine *SwapEngine) MarkRebalancePlanExecuted(id uint, operator string) (*RebalancePlanDetail, error) {
+	if engine.config.RebalanceConfig.AutoExecute {
+		return nil, fmt.Errorf("the approved rebalance plans are executed by the rebalance daemon with auto_execute")
+	}
+	return engine.updateRebalancePlan(id, operator, []model.RebalancePlanStatus{model.RebalancePlanApproved},
+		func(plan *model.RebalancePlan) error {
+			plan.Status = model.RebalancePlanExecuted
+			return nil
+		})
+}
+
+func (engine *SwapEngine) updateRebalancePlan(id uint, operator string, from []model.RebalancePlanStatus,
+	update func(plan *model.RebalancePlan) error) (*RebalancePlanDetail, error) {
+	plan, err := engine.store.GetRebalancePlan(id)
+	if err != nil {
+		return nil, fmt.Errorf("rebalance plan %d is not found", id)
+	}
+	allowed := false
+	for _, status := range from {
+		allowed = allowed || plan.Status == status
+	}
+	if !allowed {
+		return nil, fmt.Errorf("rebalance plan %d is %s", id, plan.Status)
+	}
+	status := plan.Status
+	if err := update(plan); err != nil {
+		return nil, err
+	}
+	reviewed, err := engine.store.ReviewRebalancePlan(plan, status)
+	if err != nil {
+		return nil, err
+	}
+	if !reviewed {
+		return nil, fmt.Errorf("rebalance plan %d is updated meanwhile, try again", id)
+	}
+	if plan, err = engine.store.GetRebalancePlan(id); err != nil {
+		return nil, err
+	}
+	util.Logger.Infof("rebalance plan %d is %s by %s", id, plan.Status, operator)
+	return newRebalancePlanDetail(plan)
+}
+
+// rebalanceDaemon executes the approved plans if auto_execute is set, and proposes a plan once no plan is open and
+// the inventory is imbalanced
+func (engine *SwapEngine) rebalanceDaemon() error {
+	if engine.config.RebalanceConfig.AutoExecute {
+		plans, err := engine.store.FindRebalancePlans([]model.RebalancePlanStatus{model.RebalancePlanApproved, model.RebalancePlanExecuting})
+		if err != nil {
+			return err
+		}
+		for i := range plans {
+			engine.executeRebalancePlan(&plans[i])
+		}
+	}
+	open, err := engine.store.CountRebalancePlans(openRebalancePlanStatuses)
+	if err != nil {
+		return err
+	}
+	if open != 0 {
+		return nil
+	}
+	_, err = engine.ProposeRebalancePlan(RebalancePlanner)
+	return err
+}
+
+// executeRebalancePlan sends the swap tx of every transfer by a relayer of its destination chain. A plan found
+// executing was interrupted while its txs were sent, it fails so the operators check the txs instead of sending them
+// twice.
+func (engine *SwapEngine) executeRebalancePlan(plan *model.RebalancePlan) {
+	if plan.Status == model.RebalancePlanExecuting {
+		engine.failRebalancePlan(plan, "execution is interrupted, check the sent swap txs of the transfers")
+		return
+	}
+	executing, err := engine.store.SetRebalancePlanStatus(plan.ID, model.RebalancePlanApproved, model.RebalancePlanExecuting)
+	if err != nil {
+		util.Logger.Errorf("execute rebalance plan %d error: %s", plan.ID, err.Error())
+		return
+	}
+	// another executor replica is executing the plan
+	if !executing {
+		util.Logger.Infof("rebalance plan %d is executed by another executor, skip it", plan.ID)
+		return
+	}
+	detail, err := newRebalancePlanDetail(plan)
+	if err != nil {
+		engine.failRebalancePlan(plan, err.Error())
+		return
+	}
+	for i := range detail.Transfers {
+		transfer := &detail.Transfers[i]
+		txHash, err := engine.executeRebalanceTransfer(transfer)
+		if err != nil {
+			engine.saveRebalanceTransfers(plan, detail.Transfers)
+			engine.failRebalancePlan(plan, fmt.Sprintf("transfer %d of %s from %s to %s error: %s", i, transfer.Amount,
+				transfer.FromChain, transfer.ToChain, err.Error()))
+			return
+		}
+		transfer.TxHash = txHash.String()
+		util.Logger.Infof("transfer %d of rebalance plan %d, %s from %s to %s, is sent by tx %s", i, plan.ID,
+			transfer.Amount, transfer.FromChain, transfer.ToChain, transfer.TxHash)
+	}
+	engine.saveRebalanceTransfers(plan, detail.Transfers)
+	if _, err := engine.store.SetRebalancePlanStatus(plan.ID, model.RebalancePlanExecuting, model.RebalancePlanExecuted); err != nil {
+		util.Logger.Errorf("update rebalance plan %d error: %s", plan.ID, err.Error())
+		return
+	}
+	util.Logger.Infof("rebalance plan %d is executed", plan.ID)
+}
+
+// executeRebalanceTransfer swaps the amount from the destination chain of the transfer to its source chain with the
+// tokens of a relayer of the destination chain. The swap agent of the destination chain gets the amount and the swap
+// agent of the source chain fills it to the relayer.
+func (engine *SwapEngine) executeRebalanceTransfer(transfer *RebalanceTransfer) (ethcom.Hash, error) {
+	amount, ok := big.NewInt(0).SetString(transfer.Amount, 10)
+	if !ok {
+		return ethcom.Hash{}, fmt.Errorf("invalid amount %s", transfer.Amount)
+	}
+	chain := transfer.ToChain
+	fromChainID := engine.getChainID(transfer.FromChain)
+	direction, err := engine.getSwapDirection(chain, strconv.FormatInt(fromChainID, 10))
+	if err != nil {
+		return ethcom.Hash{}, err
+	}
+	if engine.IsDirectionPaused(direction) {
+		return ethcom.Hash{}, fmt.Errorf("direction %s is paused", direction)
+	}
+	pool, ok := engine.relayerPools[chain]
+	if !ok {
+		return ethcom.Hash{}, fmt.Errorf("no relayer on %s", chain)
+	}
+	token, err := engine.getAgentToken(chain)
+	if err != nil {
+		return ethcom.Hash{}, err
+	}
+	swapAgent := engine.getSwapAgent(chain)
+
+	// the relayer holding the amount and allowing it to the swap agent, see relayer_token_config
+	var broadcaster *Broadcaster
+	for _, b := range pool.getBroadcasters() {
+		balance, allowance := big.NewInt(0), big.NewInt(0)
+		if err := engine.callToken(chain, token, &erc20TokenABI, &balance, "balanceOf", b.Account()); err != nil {
+			return ethcom.Hash{}, err
+		}
+		if err := engine.callToken(chain, token, &erc20TokenABI, &allowance, "allowance", b.Account(), swapAgent); err != nil {
+			return ethcom.Hash{}, err
+		}
+		if balance.Cmp(amount) >= 0 && allowance.Cmp(amount) >= 0 {
+			broadcaster = b
+			break
+		}
+	}
+	if broadcaster == nil {
+		return ethcom.Hash{}, fmt.Errorf("no relayer on %s holds and allows %s of token %s to the swap agent", chain,
+			amount.String(), token.String())
+	}
+	swapFee, err := engine.querySwapFee(chain)
+	if err != nil {
+		return ethcom.Hash{}, err
+	}
+	data, err := engine.swapAgentABI.Pack("swap", big.NewInt(engine.getChainID(chain)), big.NewInt(fromChainID), amount)
+	if err != nil {
+		return ethcom.Hash{}, err
+	}
+	use := KeyUse{Purpose: model.KeyUsageRebalance, Daemon: "rebalance", Token: token, Amount: amount}
+	tx, err := broadcaster.BroadcastValue(BroadcastPriorityNormal, use, swapAgent, swapFee, data, nil)
+	if err != nil {
+		return ethcom.Hash{}, err
+	}
+	return tx.Hash(), nil
+}
+
+func (engine *SwapEngine) saveRebalanceTransfers(plan *model.RebalancePlan, transfers []RebalanceTransfer) {
+	transfersJson, err := json.Marshal(transfers)
+	if err == nil {
+		err = engine.store.SetRebalancePlanTransfers(plan.ID, string(transfersJson))
+	}
+	if err != nil {
+		util.Logger.Errorf("save transfers of rebalance plan %d error: %s", plan.ID, err.Error())
+	}
+}
+
+func (engine *SwapEngine) failRebalancePlan(plan *model.RebalancePlan, errMsg string) {
+	if err := engine.store.FailRebalancePlan(plan.ID, errMsg); err != nil {
+		util.Logger.Errorf("update rebalance plan %d error: %s", plan.ID, err.Error())
+	}
+	util.Logger.Errorf("rebalance plan %d is failed: %s", plan.ID, errMsg)
+	util.SendTelegramMessage(fmt.Sprintf("Urgent alert: rebalance plan %d is failed, %s", plan.ID, errMsg))
+}
@@ -0,0 +1,87 @@
+package swap
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// MaxReceiptBatchSize is the max number of the receipts queried in one json-rpc batch request
+const MaxReceiptBatchSize = 100
+
+// ReceiptBatcher is implemented by the chain clients which query the receipts of several txs in one round trip
+type ReceiptBatcher interface {
+	TransactionReceipts(ctx context.Context, txHashes []ethcom.Hash) ([]*types.Receipt, []error)
+}
+
+// BatchClient is an ethclient which also sends batched json-rpc requests over the same connection
+type BatchClient struct {
+	*ethclient.Client
+	rpcClient *rpc.Client
+}
+
+var _ ChainClient = (*BatchClient)(nil)
+var _ ReceiptBatcher = (*BatchClient)(nil)
+
+func DialBatchClient(rawurl string) (*BatchClient, error) {
+	rpcClient, err := rpc.Dial(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return &BatchClient{
+		Client:    ethclient.NewClient(rpcClient),
+		rpcClient: rpcClient,
+	}, nil
+}
+
+// TransactionReceipts queries the receipts in batches of MaxReceiptBatchSize. The results are in the order of the
+// hashes, a receipt which is not found yet gets ethereum.NotFound like TransactionReceipt.
+func (c *BatchClient) TransactionReceipts(ctx context.Context, txHashes []ethcom.Hash) ([]*types.Receipt, []error) {
+	receipts := make([]*types.Receipt, len(txHashes))
+	errs := make([]error, len(txHashes))
+	for start := 0; start < len(txHashes); start += MaxReceiptBatchSize {
+		end := start + MaxReceiptBatchSize
+		if end > len(txHashes) {
+			end = len(txHashes)
+		}
+
+		elems := make([]rpc.BatchElem, 0, end-start)
+		for i := start; i < end; i++ {
+			elems = append(elems, rpc.BatchElem{
+				Method: "eth_getTransactionReceipt",
+				Args:   []interface{}{txHashes[i]},
+				Result: &receipts[i],
+			})
+		}
+		if err := c.rpcClient.BatchCallContext(ctx, elems); err != nil {
+			for i := start; i < end; i++ {
+				receipts[i], errs[i] = nil, err
+			}
+			continue
+		}
+		for i, elem := range elems {
+			errs[start+i] = elem.Error
+			if elem.Error == nil && receipts[start+i] == nil {
+				errs[start+i] = ethereum.NotFound
+			}
+		}
+	}
+	return receipts, errs
+}
+
+// getTransactionReceipts queries the receipts with batched requests if the client supports them, or one by one
+func getTransactionReceipts(client ChainClient, txHashes []ethcom.Hash) ([]*types.Receipt, []error) {
+	if batcher, ok := client.(ReceiptBatcher); ok {
+		return batcher.TransactionReceipts(context.Background(), txHashes)
+	}
+	receipts := make([]*types.Receipt, len(txHashes))
+	errs := make([]error, len(txHashes))
+	for i, txHash := range txHashes {
+		receipts[i], errs[i] = client.TransactionReceipt(context.Background(), txHash)
+	}
+	return receipts, errs
+}
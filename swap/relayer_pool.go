@@ -0,0 +1,196 @@
+package swap
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"occ-swap-server/util"
+)
+
+// RelayerAccount is the latest state of a relayer account of a destination chain
+type RelayerAccount struct {
+	Chain   string `json:"chain"`
+	Address string `json:"address"`
+	Balance string `json:"balance"`
+	// drained accounts are skipped until their balance is above the alert threshold again
+	Drained    bool  `json:"drained"`
+	InFlight   int   `json:"in_flight"`
+	UpdateTime int64 `json:"update_time"`
+}
+
+type relayer struct {
+	broadcaster *Broadcaster
+	balance     *big.Int
+	drained     bool
+	updateTime  int64
+}
+
+// RelayerPool assigns the txs of a chain to its relayer accounts in turn. Every account has its own broadcaster
+// so the nonces are tracked per account, and the accounts whose native coin balance is not above the alert
+// threshold are skipped.
+type RelayerPool struct {
+	chain     string
+	client    ChainClient
+	threshold *big.Int
+
+	mutex    sync.Mutex
+	relayers []*relayer
+	next     int
+}
+
+func NewRelayerPool(chain string, client ChainClient, privateKeys []*ecdsa.PrivateKey, chainId *big.Int, threshold *big.Int,
+	explorer util.ExplorerTemplates, maxInFlight int, rebroadcastTimeout time.Duration, gasPolicy util.GasLimitPolicy) *RelayerPool {
+	pool := &RelayerPool{
+		chain:     chain,
+		client:    client,
+		threshold: threshold,
+		relayers:  make([]*relayer, 0, len(privateKeys)),
+	}
+	for _, privateKey := range privateKeys {
+		pool.relayers = append(pool.relayers, &relayer{
+			broadcaster: NewBroadcaster(chain, client, privateKey, chainId, explorer, maxInFlight, rebroadcastTimeout, gasPolicy),
+		})
+	}
+	return pool
+}
+
+// OnSent sets the hook called with every tx sent by the relayer accounts of the pool, before the pool starts
+func (p *RelayerPool) OnSent(onSent func(account ethcom.Address, tx *types.Transaction)) {
+	for _, r := range p.relayers {
+		r.broadcaster.OnSent(onSent)
+	}
+}
+
+// OnSign sets the hook called with every tx signed by the relayer keys of the pool, before the pool starts
+func (p *RelayerPool) OnSign(onSign func(use KeyUse, account ethcom.Address, tx *types.Transaction) error) {
+	for _, r := range p.relayers {
+		r.broadcaster.OnSign(onSign)
+	}
+}
+
+// OnReserveNonce sets the hook reserving the nonces of the relayer accounts of the pool, before the pool starts
+func (p *RelayerPool) OnReserveNonce(reserveNonce func(account ethcom.Address, nonce uint64) (bool, error)) {
+	for _, r := range p.relayers {
+		r.broadcaster.OnReserveNonce(reserveNonce)
+	}
+}
+
+func (p *RelayerPool) Start(scheduler *util.Scheduler, balanceInterval time.Duration) {
+	for _, r := range p.relayers {
+		r.broadcaster.Start(scheduler)
+	}
+	scheduler.Go(util.Daemon{Name: "relayer_balance_" + p.chain, Interval: balanceInterval, WaitFirst: true, Run: p.trackBalanceDaemon})
+}
+
+// Broadcast sends the contract call with the next relayer account which is not drained
+func (p *RelayerPool) Broadcast(priority BroadcastPriority, use KeyUse, contract ethcom.Address, data []byte,
+	onSigned func(signedTx *types.Transaction, gasEstimate uint64) error) (*types.Transaction, error) {
+	broadcaster, err := p.nextBroadcaster()
+	if err != nil {
+		return nil, err
+	}
+	return broadcaster.Broadcast(priority, use, contract, data, onSigned)
+}
+
+func (p *RelayerPool) nextBroadcaster() (*Broadcaster, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for i := 0; i < len(p.relayers); i++ {
+		idx := (p.next + i) % len(p.relayers)
+		if !p.relayers[idx].drained {
+			p.next = idx + 1
+			return p.relayers[idx].broadcaster, nil
+		}
+	}
+	return nil, fmt.Errorf("all the relayer accounts of %s are drained", p.chain)
+}
+
+func (p *RelayerPool) getBroadcasters() []*Broadcaster {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	broadcasters := make([]*Broadcaster, 0, len(p.relayers))
+	for _, r := range p.relayers {
+		broadcasters = append(broadcasters, r.broadcaster)
+	}
+	return broadcasters
+}
+
+// getBroadcaster returns the broadcaster of the relayer account, nil if the account is not in the pool
+func (p *RelayerPool) getBroadcaster(account ethcom.Address) *Broadcaster {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, r := range p.relayers {
+		if r.broadcaster.Account() == account {
+			return r.broadcaster
+		}
+	}
+	return nil
+}
+
+// GetAccounts returns the latest state of the relayer accounts
+func (p *RelayerPool) GetAccounts() []RelayerAccount {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	accounts := make([]RelayerAccount, 0, len(p.relayers))
+	for _, r := range p.relayers {
+		account := RelayerAccount{
+			Chain:      p.chain,
+			Address:    r.broadcaster.Account().String(),
+			Drained:    r.drained,
+			InFlight:   r.broadcaster.InFlightCount(),
+			UpdateTime: r.updateTime,
+		}
+		if r.balance != nil {
+			account.Balance = r.balance.String()
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts
+}
+
+func (p *RelayerPool) trackBalanceDaemon() error {
+	for _, r := range p.relayers {
+		if err := p.updateBalance(r); err != nil && err != ErrCircuitOpen {
+			util.Logger.Errorf("query balance of relayer %s on %s error: %s", r.broadcaster.Account().String(), p.chain, err.Error())
+		}
+	}
+	return nil
+}
+
+func (p *RelayerPool) updateBalance(r *relayer) error {
+	account := r.broadcaster.Account()
+	balance, err := p.client.BalanceAt(context.Background(), account, nil)
+	if err != nil {
+		return err
+	}
+	balanceFloat, _ := new(big.Float).SetInt(balance).Float64()
+	relayerBalanceGauge.WithLabelValues(p.chain, account.String()).Set(balanceFloat)
+
+	drained := balance.Cmp(p.threshold) <= 0
+
+	p.mutex.Lock()
+	wasDrained := r.drained
+	r.balance = balance
+	r.drained = drained
+	r.updateTime = time.Now().Unix()
+	p.mutex.Unlock()
+
+	if drained && !wasDrained {
+		util.Logger.Errorf("relayer %s on %s is drained, balance %s, alert threshold %s, skip it", account.String(), p.chain, balance.String(), p.threshold.String())
+		util.SendTelegramMessage(fmt.Sprintf("relayer %s on %s is drained, balance %s, alert threshold %s, skip it", account.String(), p.chain, balance.String(), p.threshold.String()))
+	} else if !drained && wasDrained {
+		util.Logger.Infof("relayer %s on %s is refilled, balance %s, use it again", account.String(), p.chain, balance.String())
+	}
+	return nil
+}
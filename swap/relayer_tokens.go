@@ -0,0 +1,168 @@
+package swap
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	ethcom "github.com/ethereum/go-ethereum/common"
+
+	"occ-swap-server/common"
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+// ApproveTxTimeout is how long the approve tx of a relayer may stay unmined before it is sent again
+const ApproveTxTimeout = 30 * time.Minute
+
+type relayerTokenKey struct {
+	chain   string
+	account ethcom.Address
+	token   ethcom.Address
+}
+
+type approveTx struct {
+	hash   ethcom.Hash
+	sentAt time.Time
+	// a failed approve tx is sent again after ApproveTxTimeout, so it is not alerted every round
+	failed bool
+}
+
+// relayerTokensDaemon checks the tokens of relayer_token_config held by every relayer account of their chains. The
+// allowance to the swap agent below the min allowance is topped up by an approve tx of the relayer, one at a time, and
+// the balance below the min balance is alerted once until it is refilled.
+func (engine *SwapEngine) relayerTokensDaemon() func() error {
+	approving := make(map[relayerTokenKey]approveTx)
+	low := make(map[relayerTokenKey]bool)
+	return func() error {
+		for _, cfg := range engine.config.RelayerTokenConfig.Tokens {
+			if engine.isChainDegraded(cfg.Chain) {
+				continue
+			}
+			pool, ok := engine.relayerPools[cfg.Chain]
+			if !ok {
+				continue
+			}
+			token, err := engine.getRelayerToken(cfg.Chain, ethcom.HexToAddress(cfg.ERC20Addr))
+			if err != nil {
+				util.Logger.Errorf("resolve relayer token %s of %s error: %s", cfg.ERC20Addr, cfg.Chain, err.Error())
+				continue
+			}
+			for _, broadcaster := range pool.getBroadcasters() {
+				key := relayerTokenKey{chain: cfg.Chain, account: broadcaster.Account(), token: token}
+				if minBalance := cfg.GetMinBalance(); minBalance != nil {
+					if err := engine.checkRelayerTokenBalance(key, minBalance, low); err != nil {
+						util.Logger.Errorf("check balance of token %s of relayer %s on %s error: %s", token.String(),
+							key.account.String(), key.chain, err.Error())
+					}
+				}
+				if minAllowance := cfg.GetMinAllowance(); minAllowance != nil {
+					if err := engine.topUpRelayerAllowance(broadcaster, key, minAllowance, cfg.GetApproveAmount(), approving); err != nil {
+						util.Logger.Errorf("top up allowance of token %s of relayer %s on %s error: %s", token.String(),
+							key.account.String(), key.chain, err.Error())
+					}
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// getRelayerToken returns the token of the pair on the chain, the bep20 address on BSC and the erc20 address on the
+// other chains
+func (engine *SwapEngine) getRelayerToken(chain string, erc20Addr ethcom.Address) (ethcom.Address, error) {
+	pair, err := engine.GetSwapPairInstance(erc20Addr)
+	if err != nil {
+		return ethcom.Address{}, err
+	}
+	if chain == common.ChainBSC {
+		return pair.BEP20Addr, nil
+	}
+	return pair.ERC20Addr, nil
+}
+
+func (engine *SwapEngine) checkRelayerTokenBalance(key relayerTokenKey, minBalance *big.Int, low map[relayerTokenKey]bool) error {
+	balance := big.NewInt(0)
+	if err := engine.callToken(key.chain, key.token, &erc20TokenABI, &balance, "balanceOf", key.account); err != nil {
+		return err
+	}
+	balanceFloat, _ := new(big.Float).SetInt(balance).Float64()
+	relayerTokenBalanceGauge.WithLabelValues(key.chain, key.account.String(), key.token.String()).Set(balanceFloat)
+
+	isLow := balance.Cmp(minBalance) < 0
+	if isLow {
+		relayerTokenLowGauge.WithLabelValues(key.chain, key.account.String(), key.token.String()).Set(1)
+	} else {
+		relayerTokenLowGauge.WithLabelValues(key.chain, key.account.String(), key.token.String()).Set(0)
+	}
+	if isLow == low[key] {
+		return nil
+	}
+	low[key] = isLow
+	if isLow {
+		util.Logger.Errorf("relayer %s on %s holds %s of token %s, below the min balance %s", key.account.String(),
+			key.chain, balance.String(), key.token.String(), minBalance.String())
+		util.SendTelegramMessage(fmt.Sprintf("relayer %s on %s holds %s of token %s, below the min balance %s, top it up",
+			key.account.String(), key.chain, balance.String(), key.token.String(), minBalance.String()))
+	} else {
+		util.Logger.Infof("relayer %s on %s is refilled with token %s, balance %s", key.account.String(), key.chain,
+			key.token.String(), balance.String())
+	}
+	return nil
+}
+
+// topUpRelayerAllowance sends the approve tx of the relayer if its allowance to the swap agent is below the min
+// allowance, no other approve tx is sent until the former one is mined or times out, or for ApproveTxTimeout after
+// it fails
+func (engine *SwapEngine) topUpRelayerAllowance(broadcaster *Broadcaster, key relayerTokenKey, minAllowance,
+	approveAmount *big.Int, approving map[relayerTokenKey]approveTx) error {
+	if pending, ok := approving[key]; ok && pending.failed {
+		if time.Since(pending.sentAt) < ApproveTxTimeout {
+			return nil
+		}
+		delete(approving, key)
+	} else if ok {
+		receipt, err := engine.getClient(key.chain).TransactionReceipt(context.Background(), pending.hash)
+		if err == ethereum.NotFound {
+			if time.Since(pending.sentAt) < ApproveTxTimeout {
+				return nil
+			}
+			util.Logger.Errorf("approve tx %s of relayer %s on %s is not mined in %s, send it again", pending.hash.String(),
+				key.account.String(), key.chain, ApproveTxTimeout.String())
+		} else if err != nil {
+			return err
+		} else if receipt.Status == TxFailedStatus {
+			util.Logger.Errorf("approve tx %s of relayer %s on %s is failed", pending.hash.String(), key.account.String(), key.chain)
+			util.SendTelegramMessage(fmt.Sprintf("approve tx %s of token %s by relayer %s on %s is failed, check it",
+				pending.hash.String(), key.token.String(), key.account.String(), key.chain))
+			approving[key] = approveTx{hash: pending.hash, sentAt: time.Now(), failed: true}
+			return nil
+		}
+		delete(approving, key)
+	}
+
+	swapAgent := engine.getSwapAgent(key.chain)
+	allowance := big.NewInt(0)
+	if err := engine.callToken(key.chain, key.token, &erc20TokenABI, &allowance, "allowance", key.account, swapAgent); err != nil {
+		return err
+	}
+	if allowance.Cmp(minAllowance) >= 0 {
+		return nil
+	}
+	data, err := erc20TokenABI.Pack("approve", swapAgent, approveAmount)
+	if err != nil {
+		return err
+	}
+	use := KeyUse{Purpose: model.KeyUsageApprove, Daemon: "relayer_tokens", Token: key.token, Amount: approveAmount}
+	tx, err := broadcaster.Broadcast(BroadcastPriorityNormal, use, key.token, data, nil)
+	if err != nil {
+		return err
+	}
+	approving[key] = approveTx{hash: tx.Hash(), sentAt: time.Now()}
+	util.Logger.Infof("relayer %s on %s approves %s of token %s to swap agent %s, allowance %s is below %s",
+		key.account.String(), key.chain, approveAmount.String(), key.token.String(), swapAgent.String(),
+		allowance.String(), minAllowance.String())
+	return nil
+}
@@ -0,0 +1,91 @@
+package swap
+
+import (
+	"fmt"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+
+	"occ-swap-server/common"
+	"occ-swap-server/util"
+)
+
+// swapRoute is a swap direction between two chains
+type swapRoute struct {
+	Direction   common.SwapDirection
+	SourceChain string
+	DestChain   string
+}
+
+// swapRoutes are all the supported swap directions, a new chain is supported by adding the routes to and from it
+var swapRoutes = []swapRoute{
+	{SwapBSC2Eth, common.ChainBSC, common.ChainETH},
+	{SwapBSC2MATIC, common.ChainBSC, common.ChainMATIC},
+	{SwapEth2BSC, common.ChainETH, common.ChainBSC},
+	{SwapEth2MATIC, common.ChainETH, common.ChainMATIC},
+	{SwapMATIC2BSC, common.ChainMATIC, common.ChainBSC},
+	{SwapMATIC2Eth, common.ChainMATIC, common.ChainETH},
+}
+
+func getSwapRoute(direction common.SwapDirection) (swapRoute, bool) {
+	for _, route := range swapRoutes {
+		if route.Direction == direction {
+			return route, true
+		}
+	}
+	return swapRoute{}, false
+}
+
+type routeKey struct {
+	sourceChain string
+	toChainId   string
+}
+
+// routingEntry is where the swaps started on the source chain to the destination chain id are filled
+type routingEntry struct {
+	swapRoute
+	ToChainId string
+	SwapAgent ethcom.Address
+}
+
+// routingTable maps the source chain and the destination chain id of a swap to its route
+type routingTable map[routeKey]routingEntry
+
+// buildRoutingTable routes the chain ids of the environment profile through the enabled swap routes. It fails if
+// a direction of the profile is unknown, a chain id is routed twice or a destination chain has no swap agent.
+func buildRoutingTable(profile util.EnvironmentProfile, swapAgents map[string]ethcom.Address) (routingTable, error) {
+	for _, direction := range profile.Directions {
+		if _, ok := getSwapRoute(common.SwapDirection(direction)); !ok {
+			return nil, fmt.Errorf("unknown swap direction %s in the %s environment", direction, profile.Name)
+		}
+	}
+
+	table := make(routingTable)
+	for _, route := range swapRoutes {
+		if !profile.IsDirectionEnabled(string(route.Direction)) {
+			continue
+		}
+		swapAgent := swapAgents[route.DestChain]
+		if swapAgent == (ethcom.Address{}) {
+			return nil, fmt.Errorf("no swap agent of %s for swap direction %s", route.DestChain, route.Direction)
+		}
+		if len(profile.ChainIds[route.DestChain]) == 0 {
+			return nil, fmt.Errorf("no chain id of %s in the %s environment for swap direction %s", route.DestChain,
+				profile.Name, route.Direction)
+		}
+		for _, toChainId := range profile.ChainIds[route.DestChain] {
+			if chain, _ := profile.GetChainByID(toChainId); chain != route.DestChain {
+				return nil, fmt.Errorf("chain id %s of %s is recognized as %s in the %s environment", toChainId,
+					route.DestChain, chain, profile.Name)
+			}
+			key := routeKey{sourceChain: route.SourceChain, toChainId: toChainId}
+			if existing, ok := table[key]; ok {
+				return nil, fmt.Errorf("chain id %s from %s is routed by both %s and %s", toChainId, route.SourceChain,
+					existing.Direction, route.Direction)
+			}
+			table[key] = routingEntry{swapRoute: route, ToChainId: toChainId, SwapAgent: swapAgent}
+			util.Logger.Infof("Load swap route, %s to chain id %s, direction %s, swap agent %s", route.SourceChain,
+				toChainId, route.Direction, swapAgent.String())
+		}
+	}
+	return table, nil
+}
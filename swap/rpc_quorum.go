@@ -0,0 +1,177 @@
+package swap
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"occ-swap-server/util"
+)
+
+const providerTimeout = 10 * time.Second
+
+// rpcProvider is the part of the rpc client of an independent provider used by the quorums, implemented by
+// *rpc.Client
+type rpcProvider interface {
+	CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error
+}
+
+func dialProviders(chain string, urls []string) ([]rpcProvider, error) {
+	providers := make([]rpcProvider, 0, len(urls))
+	for _, url := range urls {
+		client, err := rpc.Dial(url)
+		if err != nil {
+			return nil, fmt.Errorf("dial rpc provider of %s error: %s", chain, err.Error())
+		}
+		providers = append(providers, client)
+	}
+	return providers, nil
+}
+
+// rpcQuorum confirms the swap start txs of a chain by the quorum of its providers before the swaps are filled
+type rpcQuorum struct {
+	chain     string
+	providers []rpcProvider
+	quorum    int
+
+	mutex sync.Mutex
+	// swap start txs whose disagreement is alerted, they are alerted once until the providers agree
+	alerted map[ethcom.Hash]bool
+}
+
+// newRPCQuorums dials the providers of the chains with the rpc quorum enabled
+func newRPCQuorums(cfg util.RPCQuorumConfig, chains []string) (map[string]*rpcQuorum, error) {
+	quorums := make(map[string]*rpcQuorum)
+	for _, chain := range chains {
+		if !cfg.Enabled(chain) {
+			continue
+		}
+		providers, err := dialProviders(chain, cfg.GetProviders(chain))
+		if err != nil {
+			return nil, err
+		}
+		quorums[chain] = &rpcQuorum{
+			chain:     chain,
+			providers: providers,
+			quorum:    cfg.GetQuorum(chain),
+			alerted:   make(map[ethcom.Hash]bool),
+		}
+	}
+	return quorums, nil
+}
+
+// Receipt returns the receipt of the tx the quorum of the providers agree on, nil if the quorum doesn't have the
+// tx. The providers returning different receipts are alerted, and it fails if no receipt reaches the quorum.
+func (q *rpcQuorum) Receipt(txHash ethcom.Hash) (*types.Receipt, error) {
+	tally := tallyReceipts(q.chain, q.providers, txHash)
+	disagree := tally.disagree()
+	q.mutex.Lock()
+	alerted := q.alerted[txHash]
+	if disagree {
+		q.alerted[txHash] = true
+	} else {
+		delete(q.alerted, txHash)
+	}
+	q.mutex.Unlock()
+	if disagree && !alerted {
+		util.Logger.Errorf("rpc providers of %s disagree on the receipt of swap start tx %s: %s", q.chain, txHash.String(), tally.String())
+		util.SendTelegramMessage(fmt.Sprintf("Urgent alert: rpc providers of %s disagree on the receipt of swap start tx %s: %s",
+			q.chain, txHash.String(), tally.String()))
+	}
+
+	receipt, votes := tally.leader()
+	if votes < q.quorum {
+		return nil, fmt.Errorf("%d of the %d rpc providers of %s agree on the receipt of tx %s, quorum is %d", votes,
+			len(q.providers), q.chain, txHash.String(), q.quorum)
+	}
+	return receipt, nil
+}
+
+// receiptTally counts the receipts of a tx returned by the providers by their votes, the providers without the
+// tx vote for the zero hash and the unreachable providers don't vote
+type receiptTally struct {
+	votes    map[ethcom.Hash]int
+	receipts map[ethcom.Hash]*types.Receipt
+}
+
+func tallyReceipts(chain string, providers []rpcProvider, txHash ethcom.Hash) *receiptTally {
+	tally := &receiptTally{votes: make(map[ethcom.Hash]int), receipts: make(map[ethcom.Hash]*types.Receipt)}
+	for _, provider := range providers {
+		receipt, err := getReceipt(provider, txHash)
+		if err != nil {
+			util.Logger.Debugf("query receipt of %s on %s from rpc provider error: %s", txHash.String(), chain, err.Error())
+			continue
+		}
+		var vote ethcom.Hash
+		if receipt != nil {
+			if vote, err = receiptVote(receipt); err != nil {
+				util.Logger.Errorf("encode receipt of %s on %s error: %s", txHash.String(), chain, err.Error())
+				continue
+			}
+		}
+		tally.votes[vote]++
+		tally.receipts[vote] = receipt
+	}
+	return tally
+}
+
+func (t *receiptTally) disagree() bool {
+	return len(t.votes) > 1
+}
+
+// leader returns the receipt with the most votes, nil for the missing tx
+func (t *receiptTally) leader() (*types.Receipt, int) {
+	var leader ethcom.Hash
+	max := 0
+	for vote, count := range t.votes {
+		if count > max {
+			leader, max = vote, count
+		}
+	}
+	return t.receipts[leader], max
+}
+
+func (t *receiptTally) String() string {
+	versions := make([]string, 0, len(t.votes))
+	for vote, count := range t.votes {
+		receipt := t.receipts[vote]
+		if receipt == nil {
+			versions = append(versions, fmt.Sprintf("%d without the tx", count))
+			continue
+		}
+		versions = append(versions, fmt.Sprintf("%d with the receipt in block %s", count, receipt.BlockHash.String()))
+	}
+	sort.Strings(versions)
+	return strings.Join(versions, ", ")
+}
+
+// getReceipt returns nil if the provider doesn't have the tx
+func getReceipt(provider rpcProvider, txHash ethcom.Hash) (*types.Receipt, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), providerTimeout)
+	defer cancel()
+
+	var receipt *types.Receipt
+	if err := provider.CallContext(ctx, &receipt, "eth_getTransactionReceipt", txHash); err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
+
+// receiptVote hashes the consensus fields of the receipt with the block and the index of the tx, the receipts
+// the providers agree on have the same vote
+func receiptVote(receipt *types.Receipt) (ethcom.Hash, error) {
+	encoded, err := rlp.EncodeToBytes([]interface{}{receipt, receipt.BlockHash, receipt.TransactionIndex, receipt.TxHash})
+	if err != nil {
+		return ethcom.Hash{}, err
+	}
+	return crypto.Keccak256Hash(encoded), nil
+}
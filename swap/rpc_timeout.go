@@ -0,0 +1,213 @@
+package swap
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"occ-swap-server/util"
+)
+
+// timeoutClient bounds every call to the chain rpc by the rpc timeout of the chain, the callers passing
+// context.Background() can't hang a daemon. The calls which time out are counted by method, and they are failures of
+// the rpc for the circuit breaker on top of it.
+type timeoutClient struct {
+	chain   string
+	client  ChainClient
+	timeout time.Duration
+}
+
+var _ ChainClient = (*timeoutClient)(nil)
+var _ ReceiptBatcher = (*timeoutClient)(nil)
+
+func newTimeoutClient(chain string, client ChainClient, timeout time.Duration) *timeoutClient {
+	return &timeoutClient{chain: chain, client: client, timeout: timeout}
+}
+
+// call runs the call with the timeout, a shorter deadline of the caller is kept
+func (c *timeoutClient) call(ctx context.Context, method string, call func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	err := call(ctx)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		c.timedOut(method)
+		return fmt.Errorf("%s to %s rpc timed out: %w", method, c.chain, err)
+	}
+	return err
+}
+
+func (c *timeoutClient) timedOut(method string) {
+	rpcTimeoutsGauge.WithLabelValues(c.chain, method).Inc()
+	util.Logger.Errorf("%s to %s rpc hung, it timed out after %s", method, c.chain, c.timeout)
+}
+
+func (c *timeoutClient) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	var result []byte
+	err := c.call(ctx, "eth_call", func(ctx context.Context) (err error) {
+		result, err = c.client.CallContract(ctx, call, blockNumber)
+		return err
+	})
+	return result, err
+}
+
+func (c *timeoutClient) EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+	var gas uint64
+	err := c.call(ctx, "eth_estimateGas", func(ctx context.Context) (err error) {
+		gas, err = c.client.EstimateGas(ctx, call)
+		return err
+	})
+	return gas, err
+}
+
+func (c *timeoutClient) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	var gasPrice *big.Int
+	err := c.call(ctx, "eth_gasPrice", func(ctx context.Context) (err error) {
+		gasPrice, err = c.client.SuggestGasPrice(ctx)
+		return err
+	})
+	return gasPrice, err
+}
+
+func (c *timeoutClient) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	var logs []types.Log
+	err := c.call(ctx, "eth_getLogs", func(ctx context.Context) (err error) {
+		logs, err = c.client.FilterLogs(ctx, query)
+		return err
+	})
+	return logs, err
+}
+
+// SubscribeFilterLogs only bounds setting up the subscription, the subscription outlives the context
+func (c *timeoutClient) SubscribeFilterLogs(ctx context.Context, query ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	var sub ethereum.Subscription
+	err := c.call(ctx, "eth_subscribe", func(ctx context.Context) (err error) {
+		sub, err = c.client.SubscribeFilterLogs(ctx, query, ch)
+		return err
+	})
+	return sub, err
+}
+
+func (c *timeoutClient) TransactionByHash(ctx context.Context, txHash ethcom.Hash) (*types.Transaction, bool, error) {
+	var tx *types.Transaction
+	var isPending bool
+	err := c.call(ctx, "eth_getTransactionByHash", func(ctx context.Context) (err error) {
+		tx, isPending, err = c.client.TransactionByHash(ctx, txHash)
+		return err
+	})
+	return tx, isPending, err
+}
+
+func (c *timeoutClient) TransactionReceipt(ctx context.Context, txHash ethcom.Hash) (*types.Receipt, error) {
+	var receipt *types.Receipt
+	err := c.call(ctx, "eth_getTransactionReceipt", func(ctx context.Context) (err error) {
+		receipt, err = c.client.TransactionReceipt(ctx, txHash)
+		return err
+	})
+	return receipt, err
+}
+
+// TransactionReceipts bounds each batch of the receipts by the timeout, or each receipt if the client of the chain
+// doesn't batch them
+func (c *timeoutClient) TransactionReceipts(ctx context.Context, txHashes []ethcom.Hash) ([]*types.Receipt, []error) {
+	batcher, ok := c.client.(ReceiptBatcher)
+	if !ok {
+		receipts := make([]*types.Receipt, len(txHashes))
+		errs := make([]error, len(txHashes))
+		for i, txHash := range txHashes {
+			receipts[i], errs[i] = c.TransactionReceipt(ctx, txHash)
+		}
+		return receipts, errs
+	}
+	receipts := make([]*types.Receipt, 0, len(txHashes))
+	errs := make([]error, 0, len(txHashes))
+	for start := 0; start < len(txHashes); start += MaxReceiptBatchSize {
+		end := start + MaxReceiptBatchSize
+		if end > len(txHashes) {
+			end = len(txHashes)
+		}
+		batchCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		batchReceipts, batchErrs := batcher.TransactionReceipts(batchCtx, txHashes[start:end])
+		if batchCtx.Err() == context.DeadlineExceeded {
+			c.timedOut("eth_getTransactionReceipt_batch")
+		}
+		cancel()
+		receipts = append(receipts, batchReceipts...)
+		errs = append(errs, batchErrs...)
+	}
+	return receipts, errs
+}
+
+func (c *timeoutClient) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	return c.call(ctx, "eth_sendRawTransaction", func(ctx context.Context) error {
+		return c.client.SendTransaction(ctx, tx)
+	})
+}
+
+func (c *timeoutClient) ChainID(ctx context.Context) (*big.Int, error) {
+	var chainID *big.Int
+	err := c.call(ctx, "eth_chainId", func(ctx context.Context) (err error) {
+		chainID, err = c.client.ChainID(ctx)
+		return err
+	})
+	return chainID, err
+}
+
+func (c *timeoutClient) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	var header *types.Header
+	err := c.call(ctx, "eth_getBlockByNumber", func(ctx context.Context) (err error) {
+		header, err = c.client.HeaderByNumber(ctx, number)
+		return err
+	})
+	return header, err
+}
+
+func (c *timeoutClient) BlockByHash(ctx context.Context, hash ethcom.Hash) (*types.Block, error) {
+	var block *types.Block
+	err := c.call(ctx, "eth_getBlockByHash", func(ctx context.Context) (err error) {
+		block, err = c.client.BlockByHash(ctx, hash)
+		return err
+	})
+	return block, err
+}
+
+func (c *timeoutClient) PendingNonceAt(ctx context.Context, account ethcom.Address) (uint64, error) {
+	var nonce uint64
+	err := c.call(ctx, "eth_getTransactionCount", func(ctx context.Context) (err error) {
+		nonce, err = c.client.PendingNonceAt(ctx, account)
+		return err
+	})
+	return nonce, err
+}
+
+func (c *timeoutClient) NonceAt(ctx context.Context, account ethcom.Address, blockNumber *big.Int) (uint64, error) {
+	var nonce uint64
+	err := c.call(ctx, "eth_getTransactionCount", func(ctx context.Context) (err error) {
+		nonce, err = c.client.NonceAt(ctx, account, blockNumber)
+		return err
+	})
+	return nonce, err
+}
+
+func (c *timeoutClient) BalanceAt(ctx context.Context, account ethcom.Address, blockNumber *big.Int) (*big.Int, error) {
+	var balance *big.Int
+	err := c.call(ctx, "eth_getBalance", func(ctx context.Context) (err error) {
+		balance, err = c.client.BalanceAt(ctx, account, blockNumber)
+		return err
+	})
+	return balance, err
+}
+
+func (c *timeoutClient) CodeAt(ctx context.Context, account ethcom.Address, blockNumber *big.Int) ([]byte, error) {
+	var code []byte
+	err := c.call(ctx, "eth_getCode", func(ctx context.Context) (err error) {
+		code, err = c.client.CodeAt(ctx, account, blockNumber)
+		return err
+	})
+	return code, err
+}
@@ -0,0 +1,1522 @@
+package swap
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/jinzhu/gorm"
+
+	"occ-swap-server/common"
+	"occ-swap-server/model"
+)
+
+// SwapStore is the storage the swap lifecycle daemons talk to, from the seen swap start txs to the mined fill txs:
+// monitor_swap_request, confirm_swap_request, the fill daemons of the chains and their workers, the trackers of the
+// sent fill txs, queue_age, track_liquidity, auto_retry_failed_swaps, webhook_delivery, relay_swaps, rebalance and
+// the drain brake, the retries of the failed swaps and the trackers of their fill txs, the watchdog, the release of
+// the delayed and held swaps, the rebroadcast of the dropped fill txs, the expiry, the latency stats and the anomaly
+// detector, the archive, the integrity sweep, the receipt proofs, the rollups, the event export, the deployments of
+// the pegged tokens, the key usage audit log, the nonce reconciliation, and the address and enum repairs run on
+// start. The engine operations of the admin api, the relayed swaps, the recovery, the import, the fee audit and the
+// sponsor tiers, go through it as well. GormStore keeps them in the db and MemoryStore in memory, so the logic of
+// the daemons can be run without a db. Only the migrations run once on start, the backfill of the amount decimals,
+// the linking of the fill txs, the db constraints and the records of the applied migrations, and the migration of
+// a paused swap pair query the db directly.
+type SwapStore interface {
+	// Transaction runs fn with the store of a tx, the writes of fn through it are rolled back if fn returns an error
+	Transaction(fn func(store SwapStore) error) error
+
+	// FindStartTxLogs returns the swap start tx logs of the phase by height, of any of the statuses if there are any
+	FindStartTxLogs(phase model.TxPhase, limit int, statuses ...model.TxStatus) ([]model.SwapStartTxLog, error)
+	// GetStartTxLog returns the swap start tx log of the tx hash
+	GetStartTxLog(txHash string) (*model.SwapStartTxLog, error)
+	// LockStartTxLog locks the swap start tx log in the tx, it is false if the log is not in the phase any more, or
+	// is being handled by another executor replica
+	LockStartTxLog(id int64, phase model.TxPhase) bool
+	SetStartTxLogPhase(id int64, phase model.TxPhase) error
+	// SetStartTxLogsPhase moves all the swap start tx logs of the tx hash to the phase
+	SetStartTxLogsPhase(txHash string, phase model.TxPhase) error
+	// OldestStartTxLog returns the swap start tx log of the phase, chain and to chain id updated first, nil if none
+	OldestStartTxLog(phase model.TxPhase, chain, toChainId string) (*model.SwapStartTxLog, error)
+
+	CreateSwap(swap *model.Swap) error
+	SaveSwap(swap *model.Swap) error
+	GetSwap(id uint) (*model.Swap, error)
+	GetSwapByStartTxHash(txHash string) (*model.Swap, error)
+	// ClaimFillableSwaps locks the fillable swaps of the query in the tx and claims them for the instance until
+	// claimedUntil, without touching their updated_at. It returns the swaps it claims, those claimed by another
+	// replica in the meantime are left out.
+	ClaimFillableSwaps(query FillableSwapQuery, claimedUntil int64) ([]model.Swap, error)
+	// RenewSwapClaim extends the claim of the instance on the swap of the status to claimedUntil, it is false if the
+	// swap is not of the status or the claim is expired or held by another replica
+	RenewSwapClaim(id uint, status common.SwapStatus, instanceID string, claimedUntil int64) (bool, error)
+	// ReleaseSwapClaim releases the claim of the instance on the swap, so the other replicas can pick it at once
+	ReleaseSwapClaim(id uint, instanceID string) error
+	// SumFilledAmount sums the amounts of the fungible swaps of the directions being filled, or whose fill tx is
+	// sent since the time, the swaps whose fill failed are left out
+	SumFilledAmount(directions []common.SwapDirection, since time.Time) (*big.Int, error)
+	// LockWithdrawals locks the withdrawals to the chain until the tx ends, the replicas checking the withdrawal limit
+	// of the chain in their txs wait for each other
+	LockWithdrawals(chain string) error
+	// OldestSwap returns the swap of the status and direction updated first, nil if none
+	OldestSwap(status common.SwapStatus, direction common.SwapDirection) (*model.Swap, error)
+	// FindSwaps returns the swaps of the query by id
+	FindSwaps(query SwapQuery) ([]model.Swap, error)
+	// FindRetryDueSwaps returns the failed swaps whose auto retry is due at the time by id, the permanent failures,
+	// the swaps of the excluded directions and those created before createdSince, unless it is zero, are left out
+	FindRetryDueSwaps(dueAt int64, excludedDirections []common.SwapDirection, createdSince time.Time, limit int) ([]model.Swap, error)
+	// FindSwapsOfUnknownEnums returns the swaps after the id whose status, or direction if any, is not registered
+	FindSwapsOfUnknownEnums(afterID uint, limit int) ([]model.Swap, error)
+	// LockExpirableSwaps locks the swaps of the statuses created before the time in the tx by id, the claimed swaps,
+	// the imported swaps and the swaps being retried are left out
+	LockExpirableSwaps(statuses []common.SwapStatus, createdBefore time.Time, limit int) ([]model.Swap, error)
+	// FindSwapActivity returns the erc20 address, the amount and the creation time of the swaps created since the time
+	FindSwapActivity(since time.Time) ([]model.Swap, error)
+	// CountPairReferences counts the swaps, the retry swaps and the archived swaps of the erc20 or the bep20 address
+	CountPairReferences(erc20Addr, bep20Addr string) (int, error)
+
+	CreateRetrySwap(retrySwap *model.RetrySwap) error
+	SaveRetrySwap(retrySwap *model.RetrySwap) error
+	GetRetrySwap(id uint) (*model.RetrySwap, error)
+	// ClaimRetrySwaps locks the confirmed retry swaps and the retry swaps being sent in the tx and claims them for the
+	// instance until claimedUntil, by id. The retry swaps of the excluded directions are left out, and so are those
+	// claimed by another replica.
+	ClaimRetrySwaps(excludedDirections []common.SwapDirection, instanceID string, limit int, claimedUntil int64) ([]model.RetrySwap, error)
+	// ReleaseRetrySwapClaim releases the claim of the instance on the retry swap, so the other replicas can pick it
+	ReleaseRetrySwapClaim(id uint, instanceID string) error
+	// CountActiveRetrySwaps counts the retry swaps of the swap whose fill tx may still be sent or mined
+	CountActiveRetrySwaps(swapID uint) (int, error)
+	// FindRetrySwapsAfter returns the retry swaps after the id by id
+	FindRetrySwapsAfter(afterID uint, limit int) ([]model.RetrySwap, error)
+	// FindRetrySwapsOfUnknownDirections returns the retry swaps after the id whose direction is not registered
+	FindRetrySwapsOfUnknownDirections(afterID uint, limit int) ([]model.RetrySwap, error)
+
+	CreateFillTx(swapTx *model.SwapFillTx) error
+	DeleteFillTx(id uint) error
+	// GetFillTxOfSwap returns the first fill tx of the swap, nil if none
+	GetFillTxOfSwap(swapID uint) (*model.SwapFillTx, error)
+	// FindSentFillTxs returns the sent fill txs of the directions by id, those tracked less than maxTrackRetry times,
+	// or at least that many times if exceeded
+	FindSentFillTxs(directions []common.SwapDirection, maxTrackRetry int64, exceeded bool, limit int) ([]model.SwapFillTx, error)
+	SetFillTxStatus(id uint, status model.FillTxStatus) error
+	IncrFillTxTrackRetry(id uint) error
+	// SetFillTxReceipt records the status, the height and the gas fee of the mined fill tx
+	SetFillTxReceipt(id uint, status model.FillTxStatus, height int64, consumedFeeAmount, revertReason string) error
+	// IsFillTxRecorded returns whether the tx is a fill tx, archived or retried, of any swap
+	IsFillTxRecorded(txHash string) (bool, error)
+	// OldestFillTx returns the fill tx of the status and direction created first, nil if none
+	OldestFillTx(status model.FillTxStatus, direction common.SwapDirection) (*model.SwapFillTx, error)
+	// GetFillTxByHash returns the last fill tx of the tx hash
+	GetFillTxByHash(txHash string) (*model.SwapFillTx, error)
+	// GetLastFillTxOfSwap returns the last fill tx of the swap of the status, nil if none
+	GetLastFillTxOfSwap(swapID uint, status model.FillTxStatus) (*model.SwapFillTx, error)
+	// FindFillTxsOfSwaps returns the fill txs of the swaps by id
+	FindFillTxsOfSwaps(swapIDs []uint) ([]model.SwapFillTx, error)
+	// FindFillTxsCreatedBefore returns the fill txs of the status created before the time by id
+	FindFillTxsCreatedBefore(status model.FillTxStatus, createdBefore time.Time, limit int) ([]model.SwapFillTx, error)
+	// FindDroppedFillTxs returns the sent fill txs of the directions with a raw tx, broadcast before the time and
+	// rebroadcast less than maxRebroadcast times, by id
+	FindDroppedFillTxs(directions []common.SwapDirection, broadcastBefore int64, maxRebroadcast int64, limit int) ([]model.SwapFillTx, error)
+	// MoveFillTxStatus moves the fill tx from the status to the next one, it is false if it is not of the status
+	MoveFillTxStatus(id uint, from, to model.FillTxStatus) (bool, error)
+	// SetFillTxRebroadcast records the rebroadcast of the fill tx, it is tracked from scratch if resetTrackRetry is set
+	SetFillTxRebroadcast(id uint, rebroadcastCounter int64, resetTrackRetry bool) error
+
+	// FindStartTxLogsAfter returns the swap start tx logs after the id by id, the repairs page through them
+	FindStartTxLogsAfter(afterID int64, limit int) ([]model.SwapStartTxLog, error)
+	// FindStartTxLogsUpdatedBefore returns the swap start tx logs of the phase updated before the time by height
+	FindStartTxLogsUpdatedBefore(phase model.TxPhase, updatedBefore time.Time, limit int) ([]model.SwapStartTxLog, error)
+	// FindStartTxLogsOfTxs returns the swap start tx logs of the tx hashes
+	FindStartTxLogsOfTxs(txHashes []string) ([]model.SwapStartTxLog, error)
+	SaveStartTxLog(txEventLog *model.SwapStartTxLog) error
+	FindFillTxsAfter(afterID uint, limit int) ([]model.SwapFillTx, error)
+	SaveFillTx(swapTx *model.SwapFillTx) error
+	// FindUnknownFillTxDirections returns the distinct directions of the fill txs which are not registered
+	FindUnknownFillTxDirections() ([]string, error)
+	// RenameFillTxDirection sets the direction of the fill txs of the value, it returns how many are renamed
+	RenameFillTxDirection(value string, direction common.SwapDirection) (int64, error)
+	FindRetrySwapTxsAfter(afterID uint, limit int) ([]model.RetrySwapTx, error)
+	SaveRetrySwapTx(retrySwapTx *model.RetrySwapTx) error
+	CreateRetrySwapTx(retrySwapTx *model.RetrySwapTx) error
+	DeleteRetrySwapTx(id uint) error
+	// GetLastRetrySwapTx returns the last retry fill tx of the retry swap, nil if none
+	GetLastRetrySwapTx(retrySwapID uint) (*model.RetrySwapTx, error)
+	// FindSentRetrySwapTxs returns the sent retry fill txs by id, those tracked less than maxTrackRetry times, or at
+	// least that many times if exceeded
+	FindSentRetrySwapTxs(maxTrackRetry int64, exceeded bool, limit int) ([]model.RetrySwapTx, error)
+	// SetRetrySwapTxStatus sets the status of the retry fill tx, and its error if there is one
+	SetRetrySwapTxStatus(id uint, status model.FillRetryTxStatus, errorMsg string) error
+	IncrRetrySwapTxTrackRetry(id uint) error
+	// SetRetrySwapTxReceipt records the status, the height and the gas fee of the mined retry fill tx
+	SetRetrySwapTxReceipt(id uint, status model.FillRetryTxStatus, height int64, consumedFeeAmount, revertReason string) error
+
+	FindSwapPairs() ([]model.SwapPair, error)
+	SaveSwapPair(pair *model.SwapPair) error
+	// GetSwapPair returns the swap pair of the erc20 address
+	GetSwapPair(erc20Addr string) (*model.SwapPair, error)
+	// DeleteSwapPair deletes the swap pair of the erc20 address along with its owners, its pause and its fill methods
+	DeleteSwapPair(erc20Addr string) error
+	CreatePausedPair(pausedPair *model.PausedPair) error
+	DeletePausedPair(erc20Addr string) error
+	// FindLatencyStats returns the latency stats of the stages of the direction by id
+	FindLatencyStats(direction common.SwapDirection) ([]model.SwapLatencyStat, error)
+	SaveLatencyStat(stat *model.SwapLatencyStat) error
+
+	// GetRelayedSwap returns the relayed swap started by the tx on the chain, nil if none
+	GetRelayedSwap(chain, startTxHash string) (*model.RelayedSwap, error)
+	// FindRelayedSwaps returns the relayed swaps of the statuses by id, all of them if limit is 0
+	FindRelayedSwaps(statuses []model.RelayedSwapStatus, limit int) ([]model.RelayedSwap, error)
+	SaveRelayedSwap(relayedSwap *model.RelayedSwap) error
+	// GetSponsorTier returns the tier of the sponsor, nil if the sponsor doesn't have one
+	GetSponsorTier(sponsor string) (*model.SponsorTier, error)
+
+	// FindWebhooks returns the webhooks of the sponsors
+	FindWebhooks(sponsors []string) ([]model.Webhook, error)
+	// IsPairOwner returns whether the scoped api key owns the pair of the erc20 address
+	IsPairOwner(apiKey, erc20Addr string) (bool, error)
+	CreateWebhookDelivery(delivery *model.WebhookDelivery) error
+	SetWebhookDeliveryPayload(id uint, payload string) error
+	// FindDueWebhookDeliveries returns the pending webhook deliveries due at the time by id
+	FindDueWebhookDeliveries(dueAt int64, limit int) ([]model.WebhookDelivery, error)
+	GetWebhook(id uint) (*model.Webhook, error)
+	SaveWebhookDelivery(delivery *model.WebhookDelivery) error
+	CreateBridgeEvent(event *model.BridgeEvent) error
+	SetBridgeEventPayload(id uint, payload string) error
+
+	// FindRebalancePlans returns the rebalance plans of the statuses by id
+	FindRebalancePlans(statuses []model.RebalancePlanStatus) ([]model.RebalancePlan, error)
+	// FindLatestRebalancePlans returns the latest rebalance plans, the latest first
+	FindLatestRebalancePlans(limit int) ([]model.RebalancePlan, error)
+	GetRebalancePlan(id uint) (*model.RebalancePlan, error)
+	CountRebalancePlans(statuses []model.RebalancePlanStatus) (int, error)
+	CreateRebalancePlan(plan *model.RebalancePlan) error
+	// SetRebalancePlanStatus moves the rebalance plan from the status to the next one, it is false if the plan is not
+	// of the status any more, e.g. another executor replica moved it first
+	SetRebalancePlanStatus(id uint, from, to model.RebalancePlanStatus) (bool, error)
+	SetRebalancePlanTransfers(id uint, transfers string) error
+	FailRebalancePlan(id uint, errorMsg string) error
+	// ReviewRebalancePlan saves the status, the approver and the error of the reviewed plan, it is false if the plan
+	// is not of the status any more, e.g. another operator reviewed it first
+	ReviewRebalancePlan(plan *model.RebalancePlan, from model.RebalancePlanStatus) (bool, error)
+
+	// GetDrainBrakeHalt returns the halt of the fills to the chain, nil if none
+	GetDrainBrakeHalt(chain string) (*model.DrainBrakeHalt, error)
+	FindDrainBrakeHalts() ([]model.DrainBrakeHalt, error)
+	// CreateDrainBrakeHalt halts the fills to the chain of the halt, it is false if the chain is halted already,
+	// e.g. by another replica
+	CreateDrainBrakeHalt(halt *model.DrainBrakeHalt) (bool, error)
+	// DeleteDrainBrakeHalt releases the chain, it is false if the chain is not halted
+	DeleteDrainBrakeHalt(chain string) (bool, error)
+	CreateRelayerSpend(spend *model.RelayerSpend) error
+	// FindRelayerSpends returns the spends of the relayer accounts recorded since the time by id
+	FindRelayerSpends(since time.Time) ([]model.RelayerSpend, error)
+	// DeleteRelayerSpends deletes the spends of the chain recorded before the time, of every chain if it is empty
+	DeleteRelayerSpends(chain string, before time.Time) error
+	// ReserveRelayerNonce reserves the nonce of the relayer account for the instance, it is false if the nonce is
+	// reserved by another instance already
+	ReserveRelayerNonce(chain, account string, nonce uint64, instanceID string) (bool, error)
+	CreateKeyUsage(keyUsage *model.KeyUsage) error
+	// FindUnminedFillTxs returns the created and the sent fill txs of the directions with a raw tx
+	FindUnminedFillTxs(directions []common.SwapDirection) ([]model.SwapFillTx, error)
+
+	// FindUnpublishedBridgeEvents returns the bridge events which are not published yet by id
+	FindUnpublishedBridgeEvents(limit int) ([]model.BridgeEvent, error)
+	MarkBridgeEventsPublished(ids []uint) error
+	// DeletePublishedBridgeEvents deletes the published bridge events created before the time, it returns how many
+	// are deleted
+	DeletePublishedBridgeEvents(createdBefore time.Time) (int64, error)
+
+	// ArchiveSwaps moves the swaps of the statuses updated before the time to the archive tables by id, with their
+	// start tx logs and fill txs, it returns how many are archived. The swaps which still have an active retry swap
+	// are kept.
+	ArchiveSwaps(statuses []common.SwapStatus, updatedBefore time.Time, limit int) (int, error)
+	// IsStartTxRecorded returns whether the tx has a swap start tx log, archived or not
+	IsStartTxRecorded(txHash string) (bool, error)
+	// IsSwapRecorded returns whether the tx started a swap, archived or deleted
+	IsSwapRecorded(startTxHash string) (bool, error)
+	CreateStartTxLog(txEventLog *model.SwapStartTxLog) error
+	// GetLatestBlockLog returns the block log of the chain at the highest height, nil if none
+	GetLatestBlockLog(chain string) (*model.BlockLog, error)
+	CreateBlockLog(blockLog *model.BlockLog) error
+	// LockSwap locks the swap in the tx
+	LockSwap(id uint) (*model.Swap, error)
+	// QuarantineSwap saves the quarantined swap and deletes the swap of it
+	QuarantineSwap(quarantinedSwap *model.QuarantinedSwap) error
+	// FindUnprovenSwaps returns the filled swaps after the id without a receipt proof by id
+	FindUnprovenSwaps(afterID uint, limit int) ([]model.Swap, error)
+	CreateSwapProof(proof *model.SwapProof) error
+
+	// IsTokenInPair returns whether the token is the bep20 or the erc20 token of a swap pair
+	IsTokenInPair(token string) (bool, error)
+	CreateSwapPair(pair *model.SwapPair) error
+	CreatePairFillMethod(fillMethod *model.PairFillMethod) error
+	// CountPeggedTokenDeployments counts the deployments of the pegged tokens of the source token of the statuses
+	CountPeggedTokenDeployments(sourceToken string, statuses []model.PeggedTokenStatus) (int, error)
+	CreatePeggedTokenDeployment(deployment *model.PeggedTokenDeployment) error
+	GetPeggedTokenDeployment(id uint) (*model.PeggedTokenDeployment, error)
+	// FindPeggedTokenDeployments returns the deployments of the statuses by id
+	FindPeggedTokenDeployments(statuses []model.PeggedTokenStatus, limit int) ([]model.PeggedTokenDeployment, error)
+	// SetPeggedTokenDeploymentTx records the deploy tx of the deployment, the tx hash is empty once the tx is dropped
+	SetPeggedTokenDeploymentTx(id uint, status model.PeggedTokenStatus, txHash string) error
+	SetPeggedTokenDeploymentToken(id uint, token string) error
+	// SetPeggedTokenDeploymentStatus sets the status of the deployment, and its error if there is one
+	SetPeggedTokenDeploymentStatus(id uint, status model.PeggedTokenStatus, errorMsg string) error
+
+	// GetLatestRollup returns the rollup of the period of the latest bucket, nil if none
+	GetLatestRollup(period model.RollupPeriod) (*model.SwapRollup, error)
+	// FindRollups returns the rollups of the query by bucket then by id
+	FindRollups(query RollupQuery) ([]model.SwapRollup, error)
+	// DeleteRollups deletes the rollups of the period whose buckets start from start to end
+	DeleteRollups(period model.RollupPeriod, start, end int64) error
+	CreateRollup(rollup *model.SwapRollup) error
+
+	// CountPendingRelayedSwaps counts the relayed swaps of the owner on the chain which are neither started nor failed
+	CountPendingRelayedSwaps(chain, owner string) (int, error)
+	// CountRelayedSwapsOfOwner counts the relayed swaps of the owner created since the time
+	CountRelayedSwapsOfOwner(owner string, since time.Time) (int64, error)
+	// CountRelayedSwapsOfIP counts the relayed swaps requested from the ip since the time
+	CountRelayedSwapsOfIP(remoteIP string, since time.Time) (int64, error)
+	CreateRelayedSwap(relayedSwap *model.RelayedSwap) error
+
+	// FindSponsorTiers returns the tiers of the sponsors by priority then by id
+	FindSponsorTiers() ([]model.SponsorTier, error)
+	SaveSponsorTier(tier *model.SponsorTier) error
+	// DeleteSponsorTier deletes the tier of the sponsor, it is false if the sponsor doesn't have one
+	DeleteSponsorTier(sponsor string) (bool, error)
+	// SetSponsorPriority sets the priority of the swaps of the sponsor of the statuses
+	SetSponsorPriority(sponsor string, statuses []common.SwapStatus, priority int64) error
+}
+
+// SwapQuery selects the swaps after AfterID of any of the statuses and the directions, of any status or direction if
+// they are empty, by id. All the selected swaps are returned if Limit is 0.
+type SwapQuery struct {
+	Statuses   []common.SwapStatus
+	Directions []common.SwapDirection
+	// the swaps of the ids if there are any
+	IDs     []uint
+	AfterID uint
+	// the swaps updated before UpdatedBefore, and those whose delay ends by DelayedBy, unless they are zero
+	UpdatedBefore time.Time
+	DelayedBy     int64
+	// the swaps created from CreatedSince to CreatedBefore, unless they are zero
+	CreatedSince  time.Time
+	CreatedBefore time.Time
+	// the swaps are selected by priority then by id if ByPriority is set, the latest first if Latest is set
+	ByPriority bool
+	Latest     bool
+	Limit      int
+}
+
+// FillableSwapQuery selects the confirmed swaps and the swaps being sent of the directions which are claimable by the
+// instance, by priority then by id
+type FillableSwapQuery struct {
+	Directions []common.SwapDirection
+	// the swaps of the paused pairs and the panicked swaps are skipped
+	ExcludedPairs []string
+	ExcludedIDs   []uint
+	// the confirmed swaps created before the cutoff are expired, they are not selected if Expires is set
+	Expires    bool
+	Cutoff     time.Time
+	InstanceID string
+	Limit      int
+}
+
+// RollupQuery selects the rollups of the period whose buckets start from Start to End, of the pair and the direction
+// if they are set. The buckets after Start are selected if End is 0, and all of them if Limit is 0.
+type RollupQuery struct {
+	Period    model.RollupPeriod
+	Start     int64
+	End       int64
+	ERC20Addr string
+	Direction common.SwapDirection
+	Limit     int
+}
+
+// GormStore is the SwapStore of the db
+type GormStore struct {
+	db *gorm.DB
+}
+
+var _ SwapStore = (*GormStore)(nil)
+
+func NewGormStore(db *gorm.DB) *GormStore {
+	return &GormStore{db: db}
+}
+
+func (s *GormStore) Transaction(fn func(store SwapStore) error) error {
+	tx := s.db.Begin()
+	if err := tx.Error; err != nil {
+		return err
+	}
+	if err := fn(&GormStore{db: tx}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func (s *GormStore) FindStartTxLogs(phase model.TxPhase, limit int, statuses ...model.TxStatus) ([]model.SwapStartTxLog, error) {
+	txEventLogs := make([]model.SwapStartTxLog, 0)
+	query := s.db.Where("phase = ?", phase)
+	if len(statuses) != 0 {
+		query = query.Where("status in (?)", statuses)
+	}
+	err := query.Order("height asc").Limit(limit).Find(&txEventLogs).Error
+	return txEventLogs, err
+}
+
+func (s *GormStore) GetStartTxLog(txHash string) (*model.SwapStartTxLog, error) {
+	txEventLog := model.SwapStartTxLog{}
+	if err := s.db.Where("tx_hash = ?", txHash).First(&txEventLog).Error; err != nil {
+		return nil, err
+	}
+	return &txEventLog, nil
+}
+
+func (s *GormStore) LockStartTxLog(id int64, phase model.TxPhase) bool {
+	txEventLog := model.SwapStartTxLog{}
+	return model.LockForUpdate(s.db).Where("id = ? and phase = ?", id, phase).First(&txEventLog).Error == nil
+}
+
+func (s *GormStore) SetStartTxLogPhase(id int64, phase model.TxPhase) error {
+	return s.db.Model(model.SwapStartTxLog{}).Where("id = ?", id).Updates(
+		map[string]interface{}{
+			"phase":       phase,
+			"update_time": time.Now().Unix(),
+		}).Error
+}
+
+func (s *GormStore) SetStartTxLogsPhase(txHash string, phase model.TxPhase) error {
+	return s.db.Model(model.SwapStartTxLog{}).Where("tx_hash = ?", txHash).Updates(
+		map[string]interface{}{
+			"phase":       phase,
+			"update_time": time.Now().Unix(),
+		}).Error
+}
+
+func (s *GormStore) OldestStartTxLog(phase model.TxPhase, chain, toChainId string) (*model.SwapStartTxLog, error) {
+	txEventLog := model.SwapStartTxLog{}
+	query := s.db.Where("phase = ? and chain = ? and to_chain_id = ?", phase, chain, toChainId).
+		Order("update_time asc").First(&txEventLog)
+	if query.RecordNotFound() {
+		return nil, nil
+	}
+	if query.Error != nil {
+		return nil, query.Error
+	}
+	return &txEventLog, nil
+}
+
+func (s *GormStore) CreateSwap(swap *model.Swap) error {
+	return s.db.Create(swap).Error
+}
+
+func (s *GormStore) SaveSwap(swap *model.Swap) error {
+	return s.db.Save(swap).Error
+}
+
+func (s *GormStore) GetSwap(id uint) (*model.Swap, error) {
+	swap := model.Swap{}
+	if err := s.db.Where("id = ?", id).First(&swap).Error; err != nil {
+		return nil, err
+	}
+	return &swap, nil
+}
+
+func (s *GormStore) GetSwapByStartTxHash(txHash string) (*model.Swap, error) {
+	swap := model.Swap{}
+	if err := s.db.Where("start_tx_hash = ?", txHash).First(&swap).Error; err != nil {
+		return nil, err
+	}
+	return &swap, nil
+}
+
+func (s *GormStore) ClaimFillableSwaps(query FillableSwapQuery, claimedUntil int64) ([]model.Swap, error) {
+	swaps := make([]model.Swap, 0)
+	statuses := []common.SwapStatus{SwapConfirmed, SwapSending}
+	db := model.LockForUpdate(s.db).Where("status in (?) and direction in (?)", statuses, query.Directions)
+	if len(query.ExcludedPairs) != 0 {
+		db = db.Where("erc20_addr not in (?)", query.ExcludedPairs)
+	}
+	if len(query.ExcludedIDs) != 0 {
+		db = db.Where("id not in (?)", query.ExcludedIDs)
+	}
+	// the swaps being sent are finished whatever their age, a fill tx may be in flight
+	if query.Expires {
+		db = db.Where("status = ? or created_at >= ?", SwapSending, query.Cutoff)
+	}
+	if err := claimableBy(db, query.InstanceID).Order("priority desc, id asc").Limit(query.Limit).Find(&swaps).Error; err != nil {
+		return nil, err
+	}
+	if len(swaps) == 0 {
+		return swaps, nil
+	}
+	ids := make([]uint, 0, len(swaps))
+	for _, swap := range swaps {
+		ids = append(ids, swap.ID)
+	}
+	claimed, err := claimRows(s.db, model.Swap{}, ids, statuses, query.InstanceID, claimedUntil)
+	if err != nil {
+		return nil, err
+	}
+	claimedSwaps := make([]model.Swap, 0, len(claimed))
+	for _, swap := range swaps {
+		if !claimed[swap.ID] {
+			continue
+		}
+		swap.ClaimedBy = query.InstanceID
+		swap.ClaimedUntil = claimedUntil
+		claimedSwaps = append(claimedSwaps, swap)
+	}
+	return claimedSwaps, nil
+}
+
+func (s *GormStore) RenewSwapClaim(id uint, status common.SwapStatus, instanceID string, claimedUntil int64) (bool, error) {
+	res := s.db.Model(model.Swap{}).
+		Where("id = ? and status = ? and claimed_by = ? and claimed_until >= ?", id, status, instanceID, time.Now().Unix()).
+		UpdateColumn("claimed_until", claimedUntil)
+	return res.RowsAffected == 1, res.Error
+}
+
+func (s *GormStore) ReleaseSwapClaim(id uint, instanceID string) error {
+	return s.db.Model(model.Swap{}).Where("id = ? and claimed_by = ?", id, instanceID).UpdateColumn("claimed_until", 0).Error
+}
+
+func (s *GormStore) LockWithdrawals(chain string) error {
+	// the update locks the row, mysql only counts the changed rows, so the value is always new
+	res := s.db.Model(model.WithdrawalLock{}).Where("chain = ?", chain).UpdateColumn("locked_at", time.Now().UnixNano())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("withdrawal lock of %s is not found", chain)
+	}
+	return nil
+}
+
+func (s *GormStore) SumFilledAmount(directions []common.SwapDirection, since time.Time) (*big.Int, error) {
+	swaps := make([]model.Swap, 0)
+	err := s.db.Select("amount").
+		Where("direction in (?) and asset_type = ?", directions, common.AssetTypeFungible).
+		Where("status = ? or (status in (?) and id in (select swap_id from swap_fill_txs where created_at >= ? and deleted_at is null))",
+			SwapSending, []common.SwapStatus{SwapSent, SwapSuccess}, since).
+		Find(&swaps).Error
+	if err != nil {
+		return nil, err
+	}
+	return sumSwapAmounts(swaps), nil
+}
+
+func (s *GormStore) OldestSwap(status common.SwapStatus, direction common.SwapDirection) (*model.Swap, error) {
+	swap := model.Swap{}
+	query := s.db.Where("status = ? and direction = ?", status, direction).Order("updated_at asc").First(&swap)
+	if query.RecordNotFound() {
+		return nil, nil
+	}
+	if query.Error != nil {
+		return nil, query.Error
+	}
+	return &swap, nil
+}
+
+func (s *GormStore) FindSwaps(query SwapQuery) ([]model.Swap, error) {
+	swaps := make([]model.Swap, 0)
+	db := s.db.Where("id > ?", query.AfterID)
+	if len(query.Statuses) != 0 {
+		db = db.Where("status in (?)", query.Statuses)
+	}
+	if len(query.Directions) != 0 {
+		db = db.Where("direction in (?)", query.Directions)
+	}
+	if len(query.IDs) != 0 {
+		db = db.Where("id in (?)", query.IDs)
+	}
+	if !query.UpdatedBefore.IsZero() {
+		db = db.Where("updated_at < ?", query.UpdatedBefore)
+	}
+	if query.DelayedBy != 0 {
+		db = db.Where("delayed_until <= ?", query.DelayedBy)
+	}
+	if !query.CreatedSince.IsZero() {
+		db = db.Where("created_at >= ?", query.CreatedSince)
+	}
+	if !query.CreatedBefore.IsZero() {
+		db = db.Where("created_at < ?", query.CreatedBefore)
+	}
+	order := "id asc"
+	if query.ByPriority {
+		order = "priority desc, id asc"
+	} else if query.Latest {
+		order = "id desc"
+	}
+	err := limitRows(db.Order(order), query.Limit).Find(&swaps).Error
+	return swaps, err
+}
+
+func (s *GormStore) FindRetryDueSwaps(dueAt int64, excludedDirections []common.SwapDirection, createdSince time.Time, limit int) ([]model.Swap, error) {
+	swaps := make([]model.Swap, 0)
+	db := s.db.Where("status = ? and next_retry_at > 0 and next_retry_at <= ? and failure_category <> ?",
+		SwapSendFailed, dueAt, common.FailurePermanent)
+	if len(excludedDirections) != 0 {
+		db = db.Where("direction not in (?)", excludedDirections)
+	}
+	if !createdSince.IsZero() {
+		db = db.Where("created_at >= ?", createdSince)
+	}
+	err := db.Order("id asc").Limit(limit).Find(&swaps).Error
+	return swaps, err
+}
+
+func (s *GormStore) FindSwapsOfUnknownEnums(afterID uint, limit int) ([]model.Swap, error) {
+	swaps := make([]model.Swap, 0)
+	statusColumn, directionColumn := s.enumColumn("status"), s.enumColumn("direction")
+	err := s.db.Where(fmt.Sprintf("id > ? and (%s not in (?) or (%s not in (?) and direction <> ''))", statusColumn, directionColumn),
+		afterID, common.SwapStatuses, common.SwapDirections).Order("id asc").Limit(limit).Find(&swaps).Error
+	return swaps, err
+}
+
+func (s *GormStore) LockExpirableSwaps(statuses []common.SwapStatus, createdBefore time.Time, limit int) ([]model.Swap, error) {
+	activeRetrySwaps := s.db.Model(model.RetrySwap{}).Select("swap_id").
+		Where("status in (?)", activeRetrySwapStatuses).QueryExpr()
+	swaps := make([]model.Swap, 0)
+	err := model.LockForUpdate(s.db).Where("status in (?) and created_at < ? and claimed_until < ? and imported_from = ?",
+		statuses, createdBefore, time.Now().Unix(), "").
+		Where("id not in (?)", activeRetrySwaps).
+		Order("id asc").Limit(limit).Find(&swaps).Error
+	return swaps, err
+}
+
+func (s *GormStore) FindSwapActivity(since time.Time) ([]model.Swap, error) {
+	swaps := make([]model.Swap, 0)
+	err := s.db.Select("erc20_addr, amount, created_at").Where("created_at >= ?", since).Find(&swaps).Error
+	return swaps, err
+}
+
+func (s *GormStore) CountPairReferences(erc20Addr, bep20Addr string) (int, error) {
+	total := 0
+	for _, table := range []interface{}{model.Swap{}, model.RetrySwap{}, model.ArchivedSwap{}} {
+		count := 0
+		err := s.db.Model(table).Where("erc20_addr = ? or bep20_addr = ?", erc20Addr, bep20Addr).Count(&count).Error
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// enumColumn returns the column compared case sensitively, see model.CaseSensitiveColumn
+func (s *GormStore) enumColumn(column string) string {
+	return model.CaseSensitiveColumn(s.db.Dialect().GetName(), column)
+}
+
+func (s *GormStore) CreateRetrySwap(retrySwap *model.RetrySwap) error {
+	return s.db.Create(retrySwap).Error
+}
+
+func (s *GormStore) SaveRetrySwap(retrySwap *model.RetrySwap) error {
+	return s.db.Save(retrySwap).Error
+}
+
+func (s *GormStore) GetRetrySwap(id uint) (*model.RetrySwap, error) {
+	retrySwap := model.RetrySwap{}
+	if err := s.db.Where("id = ?", id).First(&retrySwap).Error; err != nil {
+		return nil, err
+	}
+	return &retrySwap, nil
+}
+
+func (s *GormStore) ClaimRetrySwaps(excludedDirections []common.SwapDirection, instanceID string, limit int, claimedUntil int64) ([]model.RetrySwap, error) {
+	retrySwaps := make([]model.RetrySwap, 0)
+	statuses := []common.RetrySwapStatus{RetrySwapConfirmed, RetrySwapSending}
+	db := model.LockForUpdate(s.db).Where("status in (?)", statuses)
+	if len(excludedDirections) != 0 {
+		db = db.Where("direction not in (?)", excludedDirections)
+	}
+	if err := claimableBy(db, instanceID).Order("id asc").Limit(limit).Find(&retrySwaps).Error; err != nil {
+		return nil, err
+	}
+	if len(retrySwaps) == 0 {
+		return retrySwaps, nil
+	}
+	ids := make([]uint, 0, len(retrySwaps))
+	for _, retrySwap := range retrySwaps {
+		ids = append(ids, retrySwap.ID)
+	}
+	claimed, err := claimRows(s.db, model.RetrySwap{}, ids, statuses, instanceID, claimedUntil)
+	if err != nil {
+		return nil, err
+	}
+	claimedRetrySwaps := make([]model.RetrySwap, 0, len(claimed))
+	for _, retrySwap := range retrySwaps {
+		if !claimed[retrySwap.ID] {
+			continue
+		}
+		retrySwap.ClaimedBy = instanceID
+		retrySwap.ClaimedUntil = claimedUntil
+		claimedRetrySwaps = append(claimedRetrySwaps, retrySwap)
+	}
+	return claimedRetrySwaps, nil
+}
+
+func (s *GormStore) ReleaseRetrySwapClaim(id uint, instanceID string) error {
+	return s.db.Model(model.RetrySwap{}).Where("id = ? and claimed_by = ?", id, instanceID).UpdateColumn("claimed_until", 0).Error
+}
+
+func (s *GormStore) CountActiveRetrySwaps(swapID uint) (int, error) {
+	count := 0
+	err := s.db.Model(model.RetrySwap{}).Where("swap_id = ? and status in (?)", swapID, activeRetrySwapStatuses).Count(&count).Error
+	return count, err
+}
+
+func (s *GormStore) FindRetrySwapsAfter(afterID uint, limit int) ([]model.RetrySwap, error) {
+	retrySwaps := make([]model.RetrySwap, 0)
+	err := s.db.Where("id > ?", afterID).Order("id asc").Limit(limit).Find(&retrySwaps).Error
+	return retrySwaps, err
+}
+
+func (s *GormStore) FindRetrySwapsOfUnknownDirections(afterID uint, limit int) ([]model.RetrySwap, error) {
+	retrySwaps := make([]model.RetrySwap, 0)
+	err := s.db.Where(fmt.Sprintf("id > ? and %s not in (?)", s.enumColumn("direction")), afterID, common.SwapDirections).
+		Order("id asc").Limit(limit).Find(&retrySwaps).Error
+	return retrySwaps, err
+}
+
+func (s *GormStore) CreateFillTx(swapTx *model.SwapFillTx) error {
+	return s.db.Create(swapTx).Error
+}
+
+func (s *GormStore) DeleteFillTx(id uint) error {
+	return s.db.Where("id = ?", id).Delete(model.SwapFillTx{}).Error
+}
+
+func (s *GormStore) GetFillTxOfSwap(swapID uint) (*model.SwapFillTx, error) {
+	swapTx := model.SwapFillTx{}
+	query := s.db.Where("swap_id = ?", swapID).First(&swapTx)
+	if query.RecordNotFound() {
+		return nil, nil
+	}
+	if query.Error != nil {
+		return nil, query.Error
+	}
+	return &swapTx, nil
+}
+
+func (s *GormStore) FindSentFillTxs(directions []common.SwapDirection, maxTrackRetry int64, exceeded bool, limit int) ([]model.SwapFillTx, error) {
+	swapTxs := make([]model.SwapFillTx, 0)
+	retry := "track_retry_counter < ?"
+	if exceeded {
+		retry = "track_retry_counter >= ?"
+	}
+	err := s.db.Where("status = ? and direction in (?)", model.FillTxSent, directions).Where(retry, maxTrackRetry).
+		Order("id asc").Limit(limit).Find(&swapTxs).Error
+	return swapTxs, err
+}
+
+func (s *GormStore) SetFillTxStatus(id uint, status model.FillTxStatus) error {
+	return s.db.Model(model.SwapFillTx{}).Where("id = ?", id).Updates(
+		map[string]interface{}{
+			"status":     status,
+			"updated_at": time.Now(),
+		}).Error
+}
+
+func (s *GormStore) IncrFillTxTrackRetry(id uint) error {
+	return s.db.Model(model.SwapFillTx{}).Where("id = ?", id).Updates(
+		map[string]interface{}{
+			"track_retry_counter": gorm.Expr("track_retry_counter + 1"),
+			"updated_at":          time.Now(),
+		}).Error
+}
+
+func (s *GormStore) SetFillTxReceipt(id uint, status model.FillTxStatus, height int64, consumedFeeAmount, revertReason string) error {
+	updates := map[string]interface{}{
+		"status":              status,
+		"height":              height,
+		"consumed_fee_amount": consumedFeeAmount,
+		"updated_at":          time.Now(),
+	}
+	if revertReason != "" {
+		updates["revert_reason"] = revertReason
+	}
+	return s.db.Model(model.SwapFillTx{}).Where("id = ?", id).Updates(updates).Error
+}
+
+func (s *GormStore) IsFillTxRecorded(txHash string) (bool, error) {
+	for _, table := range []interface{}{model.SwapFillTx{}, model.ArchivedSwapFillTx{}} {
+		count := 0
+		if err := s.db.Model(table).Where("fill_swap_tx_hash = ?", txHash).Count(&count).Error; err != nil {
+			return false, err
+		}
+		if count > 0 {
+			return true, nil
+		}
+	}
+	count := 0
+	if err := s.db.Model(model.RetrySwapTx{}).Where("retry_fill_swap_tx_hash = ?", txHash).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (s *GormStore) OldestFillTx(status model.FillTxStatus, direction common.SwapDirection) (*model.SwapFillTx, error) {
+	swapTx := model.SwapFillTx{}
+	query := s.db.Where("status = ? and direction = ?", status, direction).Order("created_at asc").First(&swapTx)
+	if query.RecordNotFound() {
+		return nil, nil
+	}
+	if query.Error != nil {
+		return nil, query.Error
+	}
+	return &swapTx, nil
+}
+
+func (s *GormStore) GetFillTxByHash(txHash string) (*model.SwapFillTx, error) {
+	swapTx := model.SwapFillTx{}
+	if err := s.db.Where("fill_swap_tx_hash = ?", txHash).Order("id desc").First(&swapTx).Error; err != nil {
+		return nil, err
+	}
+	return &swapTx, nil
+}
+
+func (s *GormStore) GetLastFillTxOfSwap(swapID uint, status model.FillTxStatus) (*model.SwapFillTx, error) {
+	swapTx := model.SwapFillTx{}
+	query := s.db.Where("swap_id = ? and status = ?", swapID, status).Order("id desc").First(&swapTx)
+	if query.RecordNotFound() {
+		return nil, nil
+	}
+	if query.Error != nil {
+		return nil, query.Error
+	}
+	return &swapTx, nil
+}
+
+func (s *GormStore) FindFillTxsOfSwaps(swapIDs []uint) ([]model.SwapFillTx, error) {
+	swapTxs := make([]model.SwapFillTx, 0)
+	err := s.db.Where("swap_id in (?)", swapIDs).Order("id asc").Find(&swapTxs).Error
+	return swapTxs, err
+}
+
+func (s *GormStore) FindFillTxsCreatedBefore(status model.FillTxStatus, createdBefore time.Time, limit int) ([]model.SwapFillTx, error) {
+	swapTxs := make([]model.SwapFillTx, 0)
+	err := s.db.Where("status = ? and created_at < ?", status, createdBefore).Order("id asc").Limit(limit).Find(&swapTxs).Error
+	return swapTxs, err
+}
+
+func (s *GormStore) FindDroppedFillTxs(directions []common.SwapDirection, broadcastBefore int64, maxRebroadcast int64, limit int) ([]model.SwapFillTx, error) {
+	swapTxs := make([]model.SwapFillTx, 0)
+	err := s.db.Where("status = ? and direction in (?) and raw_tx <> ? and broadcast_time < ? and rebroadcast_counter < ?",
+		model.FillTxSent, directions, "", broadcastBefore, maxRebroadcast).
+		Order("id asc").Limit(limit).Find(&swapTxs).Error
+	return swapTxs, err
+}
+
+func (s *GormStore) MoveFillTxStatus(id uint, from, to model.FillTxStatus) (bool, error) {
+	res := s.db.Model(model.SwapFillTx{}).Where("id = ? and status = ?", id, from).Updates(
+		map[string]interface{}{
+			"status":     to,
+			"updated_at": time.Now(),
+		})
+	return res.RowsAffected == 1, res.Error
+}
+
+func (s *GormStore) SetFillTxRebroadcast(id uint, rebroadcastCounter int64, resetTrackRetry bool) error {
+	updates := map[string]interface{}{
+		"rebroadcast_counter": rebroadcastCounter,
+		"broadcast_time":      time.Now().Unix(),
+		"updated_at":          time.Now(),
+	}
+	if resetTrackRetry {
+		updates["track_retry_counter"] = 0
+	}
+	return s.db.Model(model.SwapFillTx{}).Where("id = ?", id).Updates(updates).Error
+}
+
+func (s *GormStore) FindStartTxLogsAfter(afterID int64, limit int) ([]model.SwapStartTxLog, error) {
+	txEventLogs := make([]model.SwapStartTxLog, 0)
+	err := s.db.Where("id > ?", afterID).Order("id asc").Limit(limit).Find(&txEventLogs).Error
+	return txEventLogs, err
+}
+
+func (s *GormStore) FindStartTxLogsUpdatedBefore(phase model.TxPhase, updatedBefore time.Time, limit int) ([]model.SwapStartTxLog, error) {
+	txEventLogs := make([]model.SwapStartTxLog, 0)
+	err := s.db.Where("phase = ? and update_time < ?", phase, updatedBefore.Unix()).
+		Order("height asc").Limit(limit).Find(&txEventLogs).Error
+	return txEventLogs, err
+}
+
+func (s *GormStore) FindStartTxLogsOfTxs(txHashes []string) ([]model.SwapStartTxLog, error) {
+	txEventLogs := make([]model.SwapStartTxLog, 0)
+	err := s.db.Where("tx_hash in (?)", txHashes).Find(&txEventLogs).Error
+	return txEventLogs, err
+}
+
+func (s *GormStore) SaveStartTxLog(txEventLog *model.SwapStartTxLog) error {
+	return s.db.Save(txEventLog).Error
+}
+
+func (s *GormStore) FindFillTxsAfter(afterID uint, limit int) ([]model.SwapFillTx, error) {
+	swapTxs := make([]model.SwapFillTx, 0)
+	err := s.db.Where("id > ?", afterID).Order("id asc").Limit(limit).Find(&swapTxs).Error
+	return swapTxs, err
+}
+
+func (s *GormStore) SaveFillTx(swapTx *model.SwapFillTx) error {
+	return s.db.Save(swapTx).Error
+}
+
+func (s *GormStore) FindUnknownFillTxDirections() ([]string, error) {
+	column := s.enumColumn("direction")
+	values := make([]string, 0)
+	err := s.db.Model(model.SwapFillTx{}).Where(fmt.Sprintf("%s not in (?)", column), common.SwapDirections).
+		Pluck(fmt.Sprintf("distinct %s", column), &values).Error
+	return values, err
+}
+
+func (s *GormStore) RenameFillTxDirection(value string, direction common.SwapDirection) (int64, error) {
+	res := s.db.Model(model.SwapFillTx{}).Where(fmt.Sprintf("%s = ?", s.enumColumn("direction")), value).
+		UpdateColumn("direction", direction)
+	return res.RowsAffected, res.Error
+}
+
+func (s *GormStore) FindRetrySwapTxsAfter(afterID uint, limit int) ([]model.RetrySwapTx, error) {
+	retrySwapTxs := make([]model.RetrySwapTx, 0)
+	err := s.db.Where("id > ?", afterID).Order("id asc").Limit(limit).Find(&retrySwapTxs).Error
+	return retrySwapTxs, err
+}
+
+func (s *GormStore) SaveRetrySwapTx(retrySwapTx *model.RetrySwapTx) error {
+	return s.db.Save(retrySwapTx).Error
+}
+
+func (s *GormStore) CreateRetrySwapTx(retrySwapTx *model.RetrySwapTx) error {
+	return s.db.Create(retrySwapTx).Error
+}
+
+func (s *GormStore) DeleteRetrySwapTx(id uint) error {
+	return s.db.Where("id = ?", id).Delete(model.RetrySwapTx{}).Error
+}
+
+func (s *GormStore) GetLastRetrySwapTx(retrySwapID uint) (*model.RetrySwapTx, error) {
+	retrySwapTx := model.RetrySwapTx{}
+	query := s.db.Where("retry_swap_id = ?", retrySwapID).Order("id desc").First(&retrySwapTx)
+	if query.RecordNotFound() {
+		return nil, nil
+	}
+	if query.Error != nil {
+		return nil, query.Error
+	}
+	return &retrySwapTx, nil
+}
+
+func (s *GormStore) FindSentRetrySwapTxs(maxTrackRetry int64, exceeded bool, limit int) ([]model.RetrySwapTx, error) {
+	retrySwapTxs := make([]model.RetrySwapTx, 0)
+	retry := "track_retry_counter < ?"
+	if exceeded {
+		retry = "track_retry_counter >= ?"
+	}
+	err := s.db.Where("status = ?", model.FillRetryTxSent).Where(retry, maxTrackRetry).
+		Order("id asc").Limit(limit).Find(&retrySwapTxs).Error
+	return retrySwapTxs, err
+}
+
+func (s *GormStore) SetRetrySwapTxStatus(id uint, status model.FillRetryTxStatus, errorMsg string) error {
+	updates := map[string]interface{}{
+		"status":     status,
+		"updated_at": time.Now(),
+	}
+	if errorMsg != "" {
+		updates["error_msg"] = errorMsg
+	}
+	return s.db.Model(model.RetrySwapTx{}).Where("id = ?", id).Updates(updates).Error
+}
+
+func (s *GormStore) IncrRetrySwapTxTrackRetry(id uint) error {
+	return s.db.Model(model.RetrySwapTx{}).Where("id = ?", id).Updates(
+		map[string]interface{}{
+			"track_retry_counter": gorm.Expr("track_retry_counter + 1"),
+			"updated_at":          time.Now(),
+		}).Error
+}
+
+func (s *GormStore) SetRetrySwapTxReceipt(id uint, status model.FillRetryTxStatus, height int64, consumedFeeAmount, revertReason string) error {
+	updates := map[string]interface{}{
+		"status":              status,
+		"height":              height,
+		"consumed_fee_amount": consumedFeeAmount,
+		"updated_at":          time.Now(),
+	}
+	if revertReason != "" {
+		updates["revert_reason"] = revertReason
+	}
+	return s.db.Model(model.RetrySwapTx{}).Where("id = ?", id).Updates(updates).Error
+}
+
+func (s *GormStore) FindSwapPairs() ([]model.SwapPair, error) {
+	pairs := make([]model.SwapPair, 0)
+	err := s.db.Order("id asc").Find(&pairs).Error
+	return pairs, err
+}
+
+func (s *GormStore) SaveSwapPair(pair *model.SwapPair) error {
+	return s.db.Save(pair).Error
+}
+
+func (s *GormStore) GetSwapPair(erc20Addr string) (*model.SwapPair, error) {
+	pair := model.SwapPair{}
+	if err := s.db.Where("erc20_addr = ?", erc20Addr).First(&pair).Error; err != nil {
+		return nil, err
+	}
+	return &pair, nil
+}
+
+func (s *GormStore) DeleteSwapPair(erc20Addr string) error {
+	for _, table := range []interface{}{model.SwapPair{}, model.PairOwner{}, model.PausedPair{}, model.PairFillMethod{}} {
+		if err := s.db.Unscoped().Where("erc20_addr = ?", erc20Addr).Delete(table).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *GormStore) CreatePausedPair(pausedPair *model.PausedPair) error {
+	return s.db.Create(pausedPair).Error
+}
+
+func (s *GormStore) DeletePausedPair(erc20Addr string) error {
+	return s.db.Unscoped().Where("erc20_addr = ?", erc20Addr).Delete(model.PausedPair{}).Error
+}
+
+func (s *GormStore) FindLatencyStats(direction common.SwapDirection) ([]model.SwapLatencyStat, error) {
+	stats := make([]model.SwapLatencyStat, 0)
+	err := s.db.Where("direction = ?", direction).Order("id asc").Find(&stats).Error
+	return stats, err
+}
+
+func (s *GormStore) SaveLatencyStat(stat *model.SwapLatencyStat) error {
+	return s.db.Save(stat).Error
+}
+
+func (s *GormStore) GetRelayedSwap(chain, startTxHash string) (*model.RelayedSwap, error) {
+	relayedSwap := model.RelayedSwap{}
+	query := s.db.Where("chain = ? and start_tx_hash = ?", chain, startTxHash).First(&relayedSwap)
+	if query.RecordNotFound() {
+		return nil, nil
+	}
+	if query.Error != nil {
+		return nil, query.Error
+	}
+	return &relayedSwap, nil
+}
+
+func (s *GormStore) FindRelayedSwaps(statuses []model.RelayedSwapStatus, limit int) ([]model.RelayedSwap, error) {
+	relayedSwaps := make([]model.RelayedSwap, 0)
+	err := limitRows(s.db.Where("status in (?)", statuses).Order("id asc"), limit).Find(&relayedSwaps).Error
+	return relayedSwaps, err
+}
+
+func (s *GormStore) SaveRelayedSwap(relayedSwap *model.RelayedSwap) error {
+	return s.db.Save(relayedSwap).Error
+}
+
+func (s *GormStore) GetSponsorTier(sponsor string) (*model.SponsorTier, error) {
+	tier := model.SponsorTier{}
+	query := s.db.Where("sponsor = ?", ethcom.HexToAddress(sponsor).String()).First(&tier)
+	if query.RecordNotFound() {
+		return nil, nil
+	}
+	if query.Error != nil {
+		return nil, query.Error
+	}
+	return &tier, nil
+}
+
+func (s *GormStore) FindWebhooks(sponsors []string) ([]model.Webhook, error) {
+	webhooks := make([]model.Webhook, 0)
+	err := s.db.Where("sponsor in (?)", sponsors).Find(&webhooks).Error
+	return webhooks, err
+}
+
+func (s *GormStore) IsPairOwner(apiKey, erc20Addr string) (bool, error) {
+	count := 0
+	err := s.db.Model(model.PairOwner{}).Where("api_key = ? and erc20_addr = ?", apiKey, erc20Addr).Count(&count).Error
+	return count > 0, err
+}
+
+func (s *GormStore) CreateWebhookDelivery(delivery *model.WebhookDelivery) error {
+	return s.db.Create(delivery).Error
+}
+
+func (s *GormStore) SetWebhookDeliveryPayload(id uint, payload string) error {
+	return s.db.Model(model.WebhookDelivery{}).Where("id = ?", id).Update("payload", payload).Error
+}
+
+func (s *GormStore) FindDueWebhookDeliveries(dueAt int64, limit int) ([]model.WebhookDelivery, error) {
+	deliveries := make([]model.WebhookDelivery, 0)
+	err := s.db.Where("status = ? and next_attempt_at <= ?", model.WebhookDeliveryPending, dueAt).
+		Order("id asc").Limit(limit).Find(&deliveries).Error
+	return deliveries, err
+}
+
+func (s *GormStore) GetWebhook(id uint) (*model.Webhook, error) {
+	webhook := model.Webhook{}
+	if err := s.db.Where("id = ?", id).First(&webhook).Error; err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func (s *GormStore) SaveWebhookDelivery(delivery *model.WebhookDelivery) error {
+	return s.db.Save(delivery).Error
+}
+
+func (s *GormStore) CreateBridgeEvent(event *model.BridgeEvent) error {
+	return s.db.Create(event).Error
+}
+
+func (s *GormStore) SetBridgeEventPayload(id uint, payload string) error {
+	return s.db.Model(model.BridgeEvent{}).Where("id = ?", id).Update("payload", payload).Error
+}
+
+func (s *GormStore) FindRebalancePlans(statuses []model.RebalancePlanStatus) ([]model.RebalancePlan, error) {
+	plans := make([]model.RebalancePlan, 0)
+	err := s.db.Where("status in (?)", statuses).Order("id asc").Find(&plans).Error
+	return plans, err
+}
+
+func (s *GormStore) FindLatestRebalancePlans(limit int) ([]model.RebalancePlan, error) {
+	plans := make([]model.RebalancePlan, 0)
+	err := s.db.Order("id desc").Limit(limit).Find(&plans).Error
+	return plans, err
+}
+
+func (s *GormStore) GetRebalancePlan(id uint) (*model.RebalancePlan, error) {
+	plan := model.RebalancePlan{}
+	if err := s.db.Where("id = ?", id).First(&plan).Error; err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+func (s *GormStore) CountRebalancePlans(statuses []model.RebalancePlanStatus) (int, error) {
+	count := 0
+	err := s.db.Model(model.RebalancePlan{}).Where("status in (?)", statuses).Count(&count).Error
+	return count, err
+}
+
+func (s *GormStore) CreateRebalancePlan(plan *model.RebalancePlan) error {
+	return s.db.Create(plan).Error
+}
+
+func (s *GormStore) SetRebalancePlanStatus(id uint, from, to model.RebalancePlanStatus) (bool, error) {
+	res := s.db.Model(model.RebalancePlan{}).Where("id = ? and status = ?", id, from).Update("status", to)
+	return res.RowsAffected == 1, res.Error
+}
+
+func (s *GormStore) SetRebalancePlanTransfers(id uint, transfers string) error {
+	return s.db.Model(model.RebalancePlan{}).Where("id = ?", id).Update("transfers", transfers).Error
+}
+
+func (s *GormStore) FailRebalancePlan(id uint, errorMsg string) error {
+	return s.db.Model(model.RebalancePlan{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":    model.RebalancePlanFailed,
+		"error_msg": errorMsg,
+	}).Error
+}
+
+func (s *GormStore) ReviewRebalancePlan(plan *model.RebalancePlan, from model.RebalancePlanStatus) (bool, error) {
+	res := s.db.Model(model.RebalancePlan{}).Where("id = ? and status = ?", plan.ID, from).Updates(map[string]interface{}{
+		"status":    plan.Status,
+		"approver":  plan.Approver,
+		"error_msg": plan.ErrorMsg,
+	})
+	return res.RowsAffected == 1, res.Error
+}
+
+func (s *GormStore) GetDrainBrakeHalt(chain string) (*model.DrainBrakeHalt, error) {
+	halt := model.DrainBrakeHalt{}
+	if err := s.db.Where("chain = ?", chain).First(&halt).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &halt, nil
+}
+
+func (s *GormStore) FindDrainBrakeHalts() ([]model.DrainBrakeHalt, error) {
+	halts := make([]model.DrainBrakeHalt, 0)
+	err := s.db.Order("chain asc").Find(&halts).Error
+	return halts, err
+}
+
+func (s *GormStore) CreateDrainBrakeHalt(halt *model.DrainBrakeHalt) (bool, error) {
+	if existing, err := s.GetDrainBrakeHalt(halt.Chain); err != nil || existing != nil {
+		return false, err
+	}
+	if err := s.db.Create(halt).Error; err != nil {
+		// the unique index of the chain rejects the halt if another replica created one in the meantime
+		if existing, getErr := s.GetDrainBrakeHalt(halt.Chain); getErr == nil && existing != nil {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *GormStore) DeleteDrainBrakeHalt(chain string) (bool, error) {
+	res := s.db.Unscoped().Where("chain = ?", chain).Delete(model.DrainBrakeHalt{})
+	return res.RowsAffected != 0, res.Error
+}
+
+func (s *GormStore) CreateRelayerSpend(spend *model.RelayerSpend) error {
+	return s.db.Create(spend).Error
+}
+
+func (s *GormStore) FindRelayerSpends(since time.Time) ([]model.RelayerSpend, error) {
+	spends := make([]model.RelayerSpend, 0)
+	err := s.db.Where("created_at >= ?", since).Order("id asc").Find(&spends).Error
+	return spends, err
+}
+
+func (s *GormStore) DeleteRelayerSpends(chain string, before time.Time) error {
+	query := s.db.Unscoped().Where("created_at < ?", before)
+	if chain != "" {
+		query = query.Where("chain = ?", chain)
+	}
+	return query.Delete(model.RelayerSpend{}).Error
+}
+
+func (s *GormStore) getRelayerNonce(chain, account string, nonce uint64) (*model.RelayerNonce, error) {
+	reserved := model.RelayerNonce{}
+	if err := s.db.Where("chain = ? and account = ? and nonce = ?", chain, account, nonce).First(&reserved).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &reserved, nil
+}
+
+func (s *GormStore) ReserveRelayerNonce(chain, account string, nonce uint64, instanceID string) (bool, error) {
+	if reserved, err := s.getRelayerNonce(chain, account, nonce); err != nil || reserved != nil {
+		return reserved != nil && reserved.Instance == instanceID, err
+	}
+	if err := s.db.Create(&model.RelayerNonce{Chain: chain, Account: account, Nonce: nonce, Instance: instanceID}).Error; err != nil {
+		// the unique index rejects the nonce if another replica reserved it in the meantime
+		if reserved, getErr := s.getRelayerNonce(chain, account, nonce); getErr == nil && reserved != nil {
+			return reserved.Instance == instanceID, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *GormStore) CreateKeyUsage(keyUsage *model.KeyUsage) error {
+	return s.db.Create(keyUsage).Error
+}
+
+func (s *GormStore) FindUnminedFillTxs(directions []common.SwapDirection) ([]model.SwapFillTx, error) {
+	swapTxs := make([]model.SwapFillTx, 0)
+	err := s.db.Where("status in (?) and direction in (?) and raw_tx != ?",
+		[]model.FillTxStatus{model.FillTxCreated, model.FillTxSent}, directions, "").Find(&swapTxs).Error
+	return swapTxs, err
+}
+
+func (s *GormStore) FindUnpublishedBridgeEvents(limit int) ([]model.BridgeEvent, error) {
+	events := make([]model.BridgeEvent, 0)
+	err := s.db.Where("published = ?", false).Order("id asc").Limit(limit).Find(&events).Error
+	return events, err
+}
+
+func (s *GormStore) MarkBridgeEventsPublished(ids []uint) error {
+	return s.db.Model(model.BridgeEvent{}).Where("id in (?)", ids).
+		Updates(map[string]interface{}{"published": true, "published_at": time.Now().Unix()}).Error
+}
+
+func (s *GormStore) DeletePublishedBridgeEvents(createdBefore time.Time) (int64, error) {
+	res := s.db.Unscoped().Where("published = ? and created_at < ?", true, createdBefore).Delete(model.BridgeEvent{})
+	return res.RowsAffected, res.Error
+}
+
+func (s *GormStore) ArchiveSwaps(statuses []common.SwapStatus, updatedBefore time.Time, limit int) (int, error) {
+	activeRetrySwaps := s.db.Model(model.RetrySwap{}).Select("start_tx_hash").
+		Where("status in (?)", activeRetrySwapStatuses).QueryExpr()
+	swaps := make([]model.Swap, 0)
+	err := model.LockForUpdate(s.db).Where("status in (?) and updated_at < ?", statuses, updatedBefore).
+		Where("start_tx_hash not in (?)", activeRetrySwaps).
+		Order("id asc").Limit(limit).Find(&swaps).Error
+	if err != nil || len(swaps) == 0 {
+		return 0, err
+	}
+
+	archivedAt := time.Now().Unix()
+	ids := make([]uint, 0, len(swaps))
+	startTxHashes := make([]string, 0, len(swaps))
+	for i := range swaps {
+		if err := s.db.Create(model.NewArchivedSwap(&swaps[i], archivedAt)).Error; err != nil {
+			return 0, err
+		}
+		ids = append(ids, swaps[i].ID)
+		startTxHashes = append(startTxHashes, swaps[i].StartTxHash)
+	}
+
+	txEventLogs := make([]model.SwapStartTxLog, 0)
+	if err := s.db.Where("tx_hash in (?)", startTxHashes).Find(&txEventLogs).Error; err != nil {
+		return 0, err
+	}
+	for i := range txEventLogs {
+		if err := s.db.Create(model.NewArchivedSwapStartTxLog(&txEventLogs[i], archivedAt)).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	// the deleted fill txs, e.g. the underpriced ones, are dropped
+	swapTxs := make([]model.SwapFillTx, 0)
+	if err := s.db.Where("swap_id in (?)", ids).Find(&swapTxs).Error; err != nil {
+		return 0, err
+	}
+	for i := range swapTxs {
+		if err := s.db.Create(model.NewArchivedSwapFillTx(&swapTxs[i], archivedAt)).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	if err := s.db.Unscoped().Where("swap_id in (?)", ids).Delete(model.SwapFillTx{}).Error; err != nil {
+		return 0, err
+	}
+	if err := s.db.Where("tx_hash in (?)", startTxHashes).Delete(model.SwapStartTxLog{}).Error; err != nil {
+		return 0, err
+	}
+	if err := s.db.Unscoped().Where("id in (?)", ids).Delete(model.Swap{}).Error; err != nil {
+		return 0, err
+	}
+	return len(swaps), nil
+}
+
+func (s *GormStore) IsStartTxRecorded(txHash string) (bool, error) {
+	for _, table := range []interface{}{model.SwapStartTxLog{}, model.ArchivedSwapStartTxLog{}} {
+		count := 0
+		if err := s.db.Model(table).Where("tx_hash = ?", txHash).Count(&count).Error; err != nil || count > 0 {
+			return count > 0, err
+		}
+	}
+	return false, nil
+}
+
+func (s *GormStore) IsSwapRecorded(startTxHash string) (bool, error) {
+	for _, db := range []*gorm.DB{s.db.Model(model.Swap{}).Unscoped(), s.db.Model(model.ArchivedSwap{})} {
+		count := 0
+		if err := db.Where("start_tx_hash = ?", startTxHash).Count(&count).Error; err != nil || count > 0 {
+			return count > 0, err
+		}
+	}
+	return false, nil
+}
+
+func (s *GormStore) CreateStartTxLog(txEventLog *model.SwapStartTxLog) error {
+	return s.db.Create(txEventLog).Error
+}
+
+func (s *GormStore) GetLatestBlockLog(chain string) (*model.BlockLog, error) {
+	blockLog := model.BlockLog{}
+	query := s.db.Where("chain = ?", chain).Order("height desc").First(&blockLog)
+	if query.RecordNotFound() {
+		return nil, nil
+	}
+	if query.Error != nil {
+		return nil, query.Error
+	}
+	return &blockLog, nil
+}
+
+func (s *GormStore) CreateBlockLog(blockLog *model.BlockLog) error {
+	return s.db.Create(blockLog).Error
+}
+
+func (s *GormStore) LockSwap(id uint) (*model.Swap, error) {
+	swap := model.Swap{}
+	if err := model.LockForUpdate(s.db).Where("id = ?", id).First(&swap).Error; err != nil {
+		return nil, err
+	}
+	return &swap, nil
+}
+
+func (s *GormStore) QuarantineSwap(quarantinedSwap *model.QuarantinedSwap) error {
+	if err := s.db.Create(quarantinedSwap).Error; err != nil {
+		return err
+	}
+	return s.db.Where("id = ?", quarantinedSwap.SwapID).Delete(model.Swap{}).Error
+}
+
+func (s *GormStore) FindUnprovenSwaps(afterID uint, limit int) ([]model.Swap, error) {
+	swaps := make([]model.Swap, 0)
+	err := s.db.Select("swaps.*").
+		Joins("left join swap_proofs on swap_proofs.start_tx_hash = swaps.start_tx_hash").
+		Where("swaps.status = ? and swaps.id > ? and swap_proofs.id is null", SwapSuccess, afterID).
+		Order("swaps.id asc").Limit(limit).Find(&swaps).Error
+	return swaps, err
+}
+
+func (s *GormStore) CreateSwapProof(proof *model.SwapProof) error {
+	return s.db.Create(proof).Error
+}
+
+func (s *GormStore) IsTokenInPair(token string) (bool, error) {
+	count := 0
+	err := s.db.Model(model.SwapPair{}).Where("bep20_addr = ? or erc20_addr = ?", token, token).Count(&count).Error
+	return count > 0, err
+}
+
+func (s *GormStore) CreateSwapPair(pair *model.SwapPair) error {
+	return s.db.Create(pair).Error
+}
+
+func (s *GormStore) CreatePairFillMethod(fillMethod *model.PairFillMethod) error {
+	return s.db.Create(fillMethod).Error
+}
+
+func (s *GormStore) CountPeggedTokenDeployments(sourceToken string, statuses []model.PeggedTokenStatus) (int, error) {
+	count := 0
+	err := s.db.Model(model.PeggedTokenDeployment{}).Where("source_token = ? and status in (?)", sourceToken, statuses).
+		Count(&count).Error
+	return count, err
+}
+
+func (s *GormStore) CreatePeggedTokenDeployment(deployment *model.PeggedTokenDeployment) error {
+	return s.db.Create(deployment).Error
+}
+
+func (s *GormStore) GetPeggedTokenDeployment(id uint) (*model.PeggedTokenDeployment, error) {
+	deployment := model.PeggedTokenDeployment{}
+	if err := s.db.Where("id = ?", id).First(&deployment).Error; err != nil {
+		return nil, err
+	}
+	return &deployment, nil
+}
+
+func (s *GormStore) FindPeggedTokenDeployments(statuses []model.PeggedTokenStatus, limit int) ([]model.PeggedTokenDeployment, error) {
+	deployments := make([]model.PeggedTokenDeployment, 0)
+	err := s.db.Where("status in (?)", statuses).Order("id asc").Limit(limit).Find(&deployments).Error
+	return deployments, err
+}
+
+func (s *GormStore) SetPeggedTokenDeploymentTx(id uint, status model.PeggedTokenStatus, txHash string) error {
+	return s.db.Model(model.PeggedTokenDeployment{}).Where("id = ?", id).Updates(
+		map[string]interface{}{
+			"status":  status,
+			"tx_hash": txHash,
+		}).Error
+}
+
+func (s *GormStore) SetPeggedTokenDeploymentToken(id uint, token string) error {
+	return s.db.Model(model.PeggedTokenDeployment{}).Where("id = ?", id).Update("token", token).Error
+}
+
+func (s *GormStore) SetPeggedTokenDeploymentStatus(id uint, status model.PeggedTokenStatus, errorMsg string) error {
+	return s.db.Model(model.PeggedTokenDeployment{}).Where("id = ?", id).Updates(
+		map[string]interface{}{
+			"status":    status,
+			"error_msg": errorMsg,
+		}).Error
+}
+
+func (s *GormStore) GetLatestRollup(period model.RollupPeriod) (*model.SwapRollup, error) {
+	rollup := model.SwapRollup{}
+	query := s.db.Where("period = ?", period).Order("bucket_start desc").First(&rollup)
+	if query.RecordNotFound() {
+		return nil, nil
+	}
+	if query.Error != nil {
+		return nil, query.Error
+	}
+	return &rollup, nil
+}
+
+func (s *GormStore) FindRollups(query RollupQuery) ([]model.SwapRollup, error) {
+	db := s.db.Where("period = ? and bucket_start >= ?", query.Period, query.Start)
+	if query.End != 0 {
+		db = db.Where("bucket_start < ?", query.End)
+	}
+	if query.ERC20Addr != "" {
+		db = db.Where("erc20_addr = ?", query.ERC20Addr)
+	}
+	if query.Direction != "" {
+		db = db.Where("direction = ?", query.Direction)
+	}
+	rollups := make([]model.SwapRollup, 0)
+	err := limitRows(db.Order("bucket_start asc, id asc"), query.Limit).Find(&rollups).Error
+	return rollups, err
+}
+
+func (s *GormStore) DeleteRollups(period model.RollupPeriod, start, end int64) error {
+	return s.db.Unscoped().Where("period = ? and bucket_start >= ? and bucket_start < ?", period, start, end).
+		Delete(model.SwapRollup{}).Error
+}
+
+func (s *GormStore) CreateRollup(rollup *model.SwapRollup) error {
+	return s.db.Create(rollup).Error
+}
+
+func (s *GormStore) CountPendingRelayedSwaps(chain, owner string) (int, error) {
+	count := 0
+	err := s.db.Model(model.RelayedSwap{}).Where("chain = ? and owner = ? and status not in (?)", chain, owner,
+		[]model.RelayedSwapStatus{model.RelayedSwapStarted, model.RelayedSwapFailed}).Count(&count).Error
+	return count, err
+}
+
+func (s *GormStore) CountRelayedSwapsOfOwner(owner string, since time.Time) (int64, error) {
+	count := int64(0)
+	err := s.db.Model(model.RelayedSwap{}).Where("owner = ? and created_at >= ?", owner, since).Count(&count).Error
+	return count, err
+}
+
+func (s *GormStore) CountRelayedSwapsOfIP(remoteIP string, since time.Time) (int64, error) {
+	count := int64(0)
+	err := s.db.Model(model.RelayedSwap{}).Where("remote_ip = ? and created_at >= ?", remoteIP, since).Count(&count).Error
+	return count, err
+}
+
+func (s *GormStore) CreateRelayedSwap(relayedSwap *model.RelayedSwap) error {
+	return s.db.Create(relayedSwap).Error
+}
+
+func (s *GormStore) FindSponsorTiers() ([]model.SponsorTier, error) {
+	tiers := make([]model.SponsorTier, 0)
+	err := s.db.Order("priority desc, id asc").Find(&tiers).Error
+	return tiers, err
+}
+
+func (s *GormStore) SaveSponsorTier(tier *model.SponsorTier) error {
+	return s.db.Save(tier).Error
+}
+
+func (s *GormStore) DeleteSponsorTier(sponsor string) (bool, error) {
+	res := s.db.Unscoped().Where("sponsor = ?", sponsor).Delete(model.SponsorTier{})
+	return res.RowsAffected != 0, res.Error
+}
+
+func (s *GormStore) SetSponsorPriority(sponsor string, statuses []common.SwapStatus, priority int64) error {
+	return s.db.Model(model.Swap{}).Where("sponsor = ? and status in (?)", sponsor, statuses).
+		UpdateColumn("priority", priority).Error
+}
+
+// limitRows limits the query to n rows, all of them are selected if n is 0
+func limitRows(db *gorm.DB, n int) *gorm.DB {
+	if n > 0 {
+		return db.Limit(n)
+	}
+	return db
+}
+
+func sumSwapAmounts(swaps []model.Swap) *big.Int {
+	sum := big.NewInt(0)
+	for _, swap := range swaps {
+		if amount, ok := big.NewInt(0).SetString(swap.Amount, 10); ok {
+			sum.Add(sum, amount)
+		}
+	}
+	return sum
+}
@@ -0,0 +1,2129 @@
+package swap
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/jinzhu/gorm"
+
+	"occ-swap-server/common"
+	"occ-swap-server/model"
+)
+
+// MemoryTables are the rows of the MemoryStore, the tables the swap lifecycle daemons only read are seeded by the
+// callers
+type MemoryTables struct {
+	StartTxLogs         []model.SwapStartTxLog
+	Swaps               []model.Swap
+	FillTxs             []model.SwapFillTx
+	ArchivedFillTxs     []model.ArchivedSwapFillTx
+	RetrySwaps          []model.RetrySwap
+	RetrySwapTxs        []model.RetrySwapTx
+	SwapPairs           []model.SwapPair
+	RelayedSwaps        []model.RelayedSwap
+	SponsorTiers        []model.SponsorTier
+	Webhooks            []model.Webhook
+	PairOwners          []model.PairOwner
+	WebhookDeliveries   []model.WebhookDelivery
+	BridgeEvents        []model.BridgeEvent
+	RebalancePlans      []model.RebalancePlan
+	DrainBrakeHalts     []model.DrainBrakeHalt
+	RelayerSpends       []model.RelayerSpend
+	RelayerNonces       []model.RelayerNonce
+	ArchivedSwaps       []model.ArchivedSwap
+	PausedPairs         []model.PausedPair
+	PairFillMethods     []model.PairFillMethod
+	LatencyStats        []model.SwapLatencyStat
+	KeyUsages           []model.KeyUsage
+	ArchivedStartTxLogs []model.ArchivedSwapStartTxLog
+	BlockLogs           []model.BlockLog
+	QuarantinedSwaps    []model.QuarantinedSwap
+	SwapProofs          []model.SwapProof
+	PeggedTokens        []model.PeggedTokenDeployment
+	Rollups             []model.SwapRollup
+}
+
+func (tables *MemoryTables) copy() *MemoryTables {
+	return &MemoryTables{
+		StartTxLogs:         append([]model.SwapStartTxLog(nil), tables.StartTxLogs...),
+		Swaps:               append([]model.Swap(nil), tables.Swaps...),
+		FillTxs:             append([]model.SwapFillTx(nil), tables.FillTxs...),
+		ArchivedFillTxs:     append([]model.ArchivedSwapFillTx(nil), tables.ArchivedFillTxs...),
+		RetrySwaps:          append([]model.RetrySwap(nil), tables.RetrySwaps...),
+		RetrySwapTxs:        append([]model.RetrySwapTx(nil), tables.RetrySwapTxs...),
+		SwapPairs:           append([]model.SwapPair(nil), tables.SwapPairs...),
+		RelayedSwaps:        append([]model.RelayedSwap(nil), tables.RelayedSwaps...),
+		SponsorTiers:        append([]model.SponsorTier(nil), tables.SponsorTiers...),
+		Webhooks:            append([]model.Webhook(nil), tables.Webhooks...),
+		PairOwners:          append([]model.PairOwner(nil), tables.PairOwners...),
+		WebhookDeliveries:   append([]model.WebhookDelivery(nil), tables.WebhookDeliveries...),
+		BridgeEvents:        append([]model.BridgeEvent(nil), tables.BridgeEvents...),
+		RebalancePlans:      append([]model.RebalancePlan(nil), tables.RebalancePlans...),
+		DrainBrakeHalts:     append([]model.DrainBrakeHalt(nil), tables.DrainBrakeHalts...),
+		RelayerSpends:       append([]model.RelayerSpend(nil), tables.RelayerSpends...),
+		RelayerNonces:       append([]model.RelayerNonce(nil), tables.RelayerNonces...),
+		ArchivedSwaps:       append([]model.ArchivedSwap(nil), tables.ArchivedSwaps...),
+		PausedPairs:         append([]model.PausedPair(nil), tables.PausedPairs...),
+		PairFillMethods:     append([]model.PairFillMethod(nil), tables.PairFillMethods...),
+		LatencyStats:        append([]model.SwapLatencyStat(nil), tables.LatencyStats...),
+		KeyUsages:           append([]model.KeyUsage(nil), tables.KeyUsages...),
+		ArchivedStartTxLogs: append([]model.ArchivedSwapStartTxLog(nil), tables.ArchivedStartTxLogs...),
+		BlockLogs:           append([]model.BlockLog(nil), tables.BlockLogs...),
+		QuarantinedSwaps:    append([]model.QuarantinedSwap(nil), tables.QuarantinedSwaps...),
+		SwapProofs:          append([]model.SwapProof(nil), tables.SwapProofs...),
+		PeggedTokens:        append([]model.PeggedTokenDeployment(nil), tables.PeggedTokens...),
+		Rollups:             append([]model.SwapRollup(nil), tables.Rollups...),
+	}
+}
+
+// MemoryStore is the SwapStore keeping the rows in memory, e.g. to run the daemons in the unit tests. The txs are
+// serialized, the store is locked until the tx ends and its writes are dropped if it fails.
+type MemoryStore struct {
+	mutex  *sync.Mutex
+	tables **MemoryTables
+	// the store of a tx holds the lock already
+	inTx bool
+}
+
+var _ SwapStore = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	tables := &MemoryTables{}
+	return &MemoryStore{mutex: &sync.Mutex{}, tables: &tables}
+}
+
+// Tables runs fn with the rows of the store locked, to seed them or to check them
+func (s *MemoryStore) Tables(fn func(tables *MemoryTables)) {
+	defer s.lock()()
+	fn(*s.tables)
+}
+
+func (s *MemoryStore) lock() func() {
+	if s.inTx {
+		return func() {}
+	}
+	s.mutex.Lock()
+	return s.mutex.Unlock
+}
+
+func (s *MemoryStore) Transaction(fn func(store SwapStore) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	snapshot := (*s.tables).copy()
+	if err := fn(&MemoryStore{mutex: s.mutex, tables: s.tables, inTx: true}); err != nil {
+		*s.tables = snapshot
+		return err
+	}
+	return nil
+}
+
+func (s *MemoryStore) findStartTxLog(id int64) *model.SwapStartTxLog {
+	for i := range (*s.tables).StartTxLogs {
+		if (*s.tables).StartTxLogs[i].Id == id {
+			return &(*s.tables).StartTxLogs[i]
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) findSwap(id uint) *model.Swap {
+	for i := range (*s.tables).Swaps {
+		if (*s.tables).Swaps[i].ID == id {
+			return &(*s.tables).Swaps[i]
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) findFillTx(id uint) *model.SwapFillTx {
+	for i := range (*s.tables).FillTxs {
+		if (*s.tables).FillTxs[i].ID == id {
+			return &(*s.tables).FillTxs[i]
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) FindStartTxLogs(phase model.TxPhase, limit int, statuses ...model.TxStatus) ([]model.SwapStartTxLog, error) {
+	defer s.lock()()
+	txEventLogs := make([]model.SwapStartTxLog, 0)
+	for _, txEventLog := range (*s.tables).StartTxLogs {
+		if txEventLog.Phase == phase && (len(statuses) == 0 || containsTxStatus(statuses, txEventLog.Status)) {
+			txEventLogs = append(txEventLogs, txEventLog)
+		}
+	}
+	sort.SliceStable(txEventLogs, func(i, j int) bool { return txEventLogs[i].Height < txEventLogs[j].Height })
+	if len(txEventLogs) > limit {
+		txEventLogs = txEventLogs[:limit]
+	}
+	return txEventLogs, nil
+}
+
+func (s *MemoryStore) GetStartTxLog(txHash string) (*model.SwapStartTxLog, error) {
+	defer s.lock()()
+	for _, txEventLog := range (*s.tables).StartTxLogs {
+		if txEventLog.TxHash == txHash {
+			return &txEventLog, nil
+		}
+	}
+	return nil, fmt.Errorf("swap start tx log %s is not found", txHash)
+}
+
+func (s *MemoryStore) LockStartTxLog(id int64, phase model.TxPhase) bool {
+	defer s.lock()()
+	txEventLog := s.findStartTxLog(id)
+	return txEventLog != nil && txEventLog.Phase == phase
+}
+
+func (s *MemoryStore) SetStartTxLogPhase(id int64, phase model.TxPhase) error {
+	defer s.lock()()
+	if txEventLog := s.findStartTxLog(id); txEventLog != nil {
+		txEventLog.Phase = phase
+		txEventLog.UpdateTime = time.Now().Unix()
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetStartTxLogsPhase(txHash string, phase model.TxPhase) error {
+	defer s.lock()()
+	for i := range (*s.tables).StartTxLogs {
+		if txEventLog := &(*s.tables).StartTxLogs[i]; txEventLog.TxHash == txHash {
+			txEventLog.Phase = phase
+			txEventLog.UpdateTime = time.Now().Unix()
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) OldestStartTxLog(phase model.TxPhase, chain, toChainId string) (*model.SwapStartTxLog, error) {
+	defer s.lock()()
+	var oldest *model.SwapStartTxLog
+	for i, txEventLog := range (*s.tables).StartTxLogs {
+		if txEventLog.Phase != phase || txEventLog.Chain != chain || txEventLog.ToChainId != toChainId {
+			continue
+		}
+		if oldest == nil || txEventLog.UpdateTime < oldest.UpdateTime {
+			oldest = &(*s.tables).StartTxLogs[i]
+		}
+	}
+	if oldest == nil {
+		return nil, nil
+	}
+	txEventLog := *oldest
+	return &txEventLog, nil
+}
+
+func (s *MemoryStore) CreateSwap(swap *model.Swap) error {
+	defer s.lock()()
+	var lastID uint
+	for _, existing := range (*s.tables).Swaps {
+		if swap.ID != 0 && existing.ID == swap.ID {
+			return fmt.Errorf("swap %d exists", swap.ID)
+		}
+		if existing.ID > lastID {
+			lastID = existing.ID
+		}
+	}
+	if swap.ID == 0 {
+		swap.ID = lastID + 1
+	}
+	now := time.Now()
+	if swap.CreatedAt.IsZero() {
+		swap.CreatedAt = now
+	}
+	swap.UpdatedAt = now
+	(*s.tables).Swaps = append((*s.tables).Swaps, *swap)
+	return nil
+}
+
+func (s *MemoryStore) SaveSwap(swap *model.Swap) error {
+	defer s.lock()()
+	existing := s.findSwap(swap.ID)
+	if existing == nil {
+		return fmt.Errorf("swap %d is not found", swap.ID)
+	}
+	swap.UpdatedAt = time.Now()
+	*existing = *swap
+	return nil
+}
+
+func (s *MemoryStore) GetSwap(id uint) (*model.Swap, error) {
+	defer s.lock()()
+	existing := s.findSwap(id)
+	if existing == nil {
+		return nil, fmt.Errorf("swap %d is not found", id)
+	}
+	swap := *existing
+	return &swap, nil
+}
+
+func (s *MemoryStore) GetSwapByStartTxHash(txHash string) (*model.Swap, error) {
+	defer s.lock()()
+	for _, swap := range (*s.tables).Swaps {
+		if swap.StartTxHash == txHash {
+			return &swap, nil
+		}
+	}
+	return nil, fmt.Errorf("swap of start tx %s is not found", txHash)
+}
+
+func (s *MemoryStore) ClaimFillableSwaps(query FillableSwapQuery, claimedUntil int64) ([]model.Swap, error) {
+	defer s.lock()()
+	now := time.Now().Unix()
+	claimed := make([]*model.Swap, 0)
+	for i := range (*s.tables).Swaps {
+		swap := &(*s.tables).Swaps[i]
+		if swap.Status != SwapConfirmed && swap.Status != SwapSending {
+			continue
+		}
+		if !containsDirection(query.Directions, swap.Direction) || containsString(query.ExcludedPairs, swap.ERC20Addr) ||
+			containsID(query.ExcludedIDs, swap.ID) {
+			continue
+		}
+		if query.Expires && swap.Status != SwapSending && swap.CreatedAt.Before(query.Cutoff) {
+			continue
+		}
+		if swap.ClaimedUntil >= now && swap.ClaimedBy != query.InstanceID {
+			continue
+		}
+		claimed = append(claimed, swap)
+	}
+	sort.SliceStable(claimed, func(i, j int) bool {
+		if claimed[i].Priority != claimed[j].Priority {
+			return claimed[i].Priority > claimed[j].Priority
+		}
+		return claimed[i].ID < claimed[j].ID
+	})
+	if len(claimed) > query.Limit {
+		claimed = claimed[:query.Limit]
+	}
+	swaps := make([]model.Swap, 0, len(claimed))
+	for _, swap := range claimed {
+		swap.ClaimedBy = query.InstanceID
+		swap.ClaimedUntil = claimedUntil
+		swaps = append(swaps, *swap)
+	}
+	return swaps, nil
+}
+
+func (s *MemoryStore) RenewSwapClaim(id uint, status common.SwapStatus, instanceID string, claimedUntil int64) (bool, error) {
+	defer s.lock()()
+	swap := s.findSwap(id)
+	if swap == nil || swap.Status != status || swap.ClaimedBy != instanceID || swap.ClaimedUntil < time.Now().Unix() {
+		return false, nil
+	}
+	swap.ClaimedUntil = claimedUntil
+	return true, nil
+}
+
+func (s *MemoryStore) ReleaseSwapClaim(id uint, instanceID string) error {
+	defer s.lock()()
+	if swap := s.findSwap(id); swap != nil && swap.ClaimedBy == instanceID {
+		swap.ClaimedUntil = 0
+	}
+	return nil
+}
+
+// LockWithdrawals does nothing, the txs of the memory store are serialized already
+func (s *MemoryStore) LockWithdrawals(chain string) error {
+	return nil
+}
+
+func (s *MemoryStore) SumFilledAmount(directions []common.SwapDirection, since time.Time) (*big.Int, error) {
+	defer s.lock()()
+	sentSince := make(map[uint]bool)
+	for _, swapTx := range (*s.tables).FillTxs {
+		if !swapTx.CreatedAt.Before(since) {
+			sentSince[swapTx.SwapID] = true
+		}
+	}
+	swaps := make([]model.Swap, 0)
+	for _, swap := range (*s.tables).Swaps {
+		if !containsDirection(directions, swap.Direction) || swap.AssetType != common.AssetTypeFungible {
+			continue
+		}
+		if swap.Status == SwapSending || ((swap.Status == SwapSent || swap.Status == SwapSuccess) && sentSince[swap.ID]) {
+			swaps = append(swaps, swap)
+		}
+	}
+	return sumSwapAmounts(swaps), nil
+}
+
+func (s *MemoryStore) OldestSwap(status common.SwapStatus, direction common.SwapDirection) (*model.Swap, error) {
+	defer s.lock()()
+	var oldest *model.Swap
+	for i, swap := range (*s.tables).Swaps {
+		if swap.Status != status || swap.Direction != direction {
+			continue
+		}
+		if oldest == nil || swap.UpdatedAt.Before(oldest.UpdatedAt) {
+			oldest = &(*s.tables).Swaps[i]
+		}
+	}
+	if oldest == nil {
+		return nil, nil
+	}
+	swap := *oldest
+	return &swap, nil
+}
+
+func (s *MemoryStore) FindSwaps(query SwapQuery) ([]model.Swap, error) {
+	defer s.lock()()
+	swaps := make([]model.Swap, 0)
+	for _, swap := range (*s.tables).Swaps {
+		if swap.ID <= query.AfterID || (len(query.Statuses) != 0 && !containsStatus(query.Statuses, swap.Status)) ||
+			(len(query.Directions) != 0 && !containsDirection(query.Directions, swap.Direction)) ||
+			(len(query.IDs) != 0 && !containsID(query.IDs, swap.ID)) ||
+			(!query.UpdatedBefore.IsZero() && !swap.UpdatedAt.Before(query.UpdatedBefore)) ||
+			(query.DelayedBy != 0 && swap.DelayedUntil > query.DelayedBy) ||
+			(!query.CreatedSince.IsZero() && swap.CreatedAt.Before(query.CreatedSince)) ||
+			(!query.CreatedBefore.IsZero() && !swap.CreatedAt.Before(query.CreatedBefore)) {
+			continue
+		}
+		swaps = append(swaps, swap)
+	}
+	if query.ByPriority {
+		sort.SliceStable(swaps, func(i, j int) bool {
+			if swaps[i].Priority != swaps[j].Priority {
+				return swaps[i].Priority > swaps[j].Priority
+			}
+			return swaps[i].ID < swaps[j].ID
+		})
+	} else if query.Latest {
+		sort.SliceStable(swaps, func(i, j int) bool { return swaps[i].ID > swaps[j].ID })
+	} else {
+		return limitSwaps(swaps, query.Limit), nil
+	}
+	if query.Limit > 0 && len(swaps) > query.Limit {
+		swaps = swaps[:query.Limit]
+	}
+	return swaps, nil
+}
+
+func (s *MemoryStore) FindRetryDueSwaps(dueAt int64, excludedDirections []common.SwapDirection, createdSince time.Time, limit int) ([]model.Swap, error) {
+	defer s.lock()()
+	swaps := make([]model.Swap, 0)
+	for _, swap := range (*s.tables).Swaps {
+		if swap.Status != SwapSendFailed || swap.NextRetryAt <= 0 || swap.NextRetryAt > dueAt ||
+			swap.FailureCategory == common.FailurePermanent || containsDirection(excludedDirections, swap.Direction) ||
+			swap.CreatedAt.Before(createdSince) {
+			continue
+		}
+		swaps = append(swaps, swap)
+	}
+	return limitSwaps(swaps, limit), nil
+}
+
+func (s *MemoryStore) FindSwapsOfUnknownEnums(afterID uint, limit int) ([]model.Swap, error) {
+	defer s.lock()()
+	swaps := make([]model.Swap, 0)
+	for _, swap := range (*s.tables).Swaps {
+		if swap.ID > afterID && (!swap.Status.Valid() || (swap.Direction != "" && !swap.Direction.Valid())) {
+			swaps = append(swaps, swap)
+		}
+	}
+	return limitSwaps(swaps, limit), nil
+}
+
+func (s *MemoryStore) LockExpirableSwaps(statuses []common.SwapStatus, createdBefore time.Time, limit int) ([]model.Swap, error) {
+	defer s.lock()()
+	retried := make(map[uint]bool)
+	for _, retrySwap := range (*s.tables).RetrySwaps {
+		for _, status := range activeRetrySwapStatuses {
+			if retrySwap.Status == status {
+				retried[retrySwap.SwapID] = true
+			}
+		}
+	}
+	now := time.Now().Unix()
+	swaps := make([]model.Swap, 0)
+	for _, swap := range (*s.tables).Swaps {
+		if !containsStatus(statuses, swap.Status) || !swap.CreatedAt.Before(createdBefore) || swap.ClaimedUntil >= now ||
+			swap.ImportedFrom != "" || retried[swap.ID] {
+			continue
+		}
+		swaps = append(swaps, swap)
+	}
+	return limitSwaps(swaps, limit), nil
+}
+
+func (s *MemoryStore) FindSwapActivity(since time.Time) ([]model.Swap, error) {
+	defer s.lock()()
+	swaps := make([]model.Swap, 0)
+	for _, swap := range (*s.tables).Swaps {
+		if !swap.CreatedAt.Before(since) {
+			swaps = append(swaps, model.Swap{ERC20Addr: swap.ERC20Addr, Amount: swap.Amount, Model: gorm.Model{CreatedAt: swap.CreatedAt}})
+		}
+	}
+	return swaps, nil
+}
+
+func (s *MemoryStore) CountPairReferences(erc20Addr, bep20Addr string) (int, error) {
+	defer s.lock()()
+	count := 0
+	for _, swap := range (*s.tables).Swaps {
+		if swap.ERC20Addr == erc20Addr || swap.BEP20Addr == bep20Addr {
+			count++
+		}
+	}
+	for _, retrySwap := range (*s.tables).RetrySwaps {
+		if retrySwap.ERC20Addr == erc20Addr || retrySwap.BEP20Addr == bep20Addr {
+			count++
+		}
+	}
+	for _, swap := range (*s.tables).ArchivedSwaps {
+		if swap.ERC20Addr == erc20Addr || swap.BEP20Addr == bep20Addr {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// limitSwaps sorts the swaps by id and keeps the first n of them, all of them if n is 0
+func limitSwaps(swaps []model.Swap, n int) []model.Swap {
+	sort.SliceStable(swaps, func(i, j int) bool { return swaps[i].ID < swaps[j].ID })
+	if n > 0 && len(swaps) > n {
+		swaps = swaps[:n]
+	}
+	return swaps
+}
+
+func (s *MemoryStore) CreateRetrySwap(retrySwap *model.RetrySwap) error {
+	defer s.lock()()
+	retrySwaps := (*s.tables).RetrySwaps
+	retrySwap.ID = 1
+	if len(retrySwaps) != 0 {
+		retrySwap.ID = retrySwaps[len(retrySwaps)-1].ID + 1
+	}
+	retrySwap.CreatedAt, retrySwap.UpdatedAt = time.Now(), time.Now()
+	(*s.tables).RetrySwaps = append(retrySwaps, *retrySwap)
+	return nil
+}
+
+func (s *MemoryStore) SaveRetrySwap(retrySwap *model.RetrySwap) error {
+	defer s.lock()()
+	for i := range (*s.tables).RetrySwaps {
+		if existing := &(*s.tables).RetrySwaps[i]; existing.ID == retrySwap.ID {
+			retrySwap.UpdatedAt = time.Now()
+			*existing = *retrySwap
+			return nil
+		}
+	}
+	return fmt.Errorf("retry swap %d is not found", retrySwap.ID)
+}
+
+func (s *MemoryStore) findRetrySwap(id uint) *model.RetrySwap {
+	for i := range (*s.tables).RetrySwaps {
+		if (*s.tables).RetrySwaps[i].ID == id {
+			return &(*s.tables).RetrySwaps[i]
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetRetrySwap(id uint) (*model.RetrySwap, error) {
+	defer s.lock()()
+	existing := s.findRetrySwap(id)
+	if existing == nil {
+		return nil, fmt.Errorf("retry swap %d is not found", id)
+	}
+	retrySwap := *existing
+	return &retrySwap, nil
+}
+
+func (s *MemoryStore) ClaimRetrySwaps(excludedDirections []common.SwapDirection, instanceID string, limit int, claimedUntil int64) ([]model.RetrySwap, error) {
+	defer s.lock()()
+	now := time.Now().Unix()
+	claimed := make([]*model.RetrySwap, 0)
+	for i := range (*s.tables).RetrySwaps {
+		retrySwap := &(*s.tables).RetrySwaps[i]
+		if retrySwap.Status != RetrySwapConfirmed && retrySwap.Status != RetrySwapSending {
+			continue
+		}
+		if containsDirection(excludedDirections, retrySwap.Direction) ||
+			(retrySwap.ClaimedUntil >= now && retrySwap.ClaimedBy != instanceID) {
+			continue
+		}
+		claimed = append(claimed, retrySwap)
+	}
+	sort.SliceStable(claimed, func(i, j int) bool { return claimed[i].ID < claimed[j].ID })
+	if len(claimed) > limit {
+		claimed = claimed[:limit]
+	}
+	retrySwaps := make([]model.RetrySwap, 0, len(claimed))
+	for _, retrySwap := range claimed {
+		retrySwap.ClaimedBy = instanceID
+		retrySwap.ClaimedUntil = claimedUntil
+		retrySwaps = append(retrySwaps, *retrySwap)
+	}
+	return retrySwaps, nil
+}
+
+func (s *MemoryStore) ReleaseRetrySwapClaim(id uint, instanceID string) error {
+	defer s.lock()()
+	if retrySwap := s.findRetrySwap(id); retrySwap != nil && retrySwap.ClaimedBy == instanceID {
+		retrySwap.ClaimedUntil = 0
+	}
+	return nil
+}
+
+func (s *MemoryStore) CountActiveRetrySwaps(swapID uint) (int, error) {
+	defer s.lock()()
+	count := 0
+	for _, retrySwap := range (*s.tables).RetrySwaps {
+		if retrySwap.SwapID != swapID {
+			continue
+		}
+		for _, status := range activeRetrySwapStatuses {
+			if retrySwap.Status == status {
+				count++
+			}
+		}
+	}
+	return count, nil
+}
+
+func (s *MemoryStore) FindRetrySwapsAfter(afterID uint, limit int) ([]model.RetrySwap, error) {
+	return s.findRetrySwaps(afterID, limit, func(retrySwap *model.RetrySwap) bool { return true })
+}
+
+func (s *MemoryStore) FindRetrySwapsOfUnknownDirections(afterID uint, limit int) ([]model.RetrySwap, error) {
+	return s.findRetrySwaps(afterID, limit, func(retrySwap *model.RetrySwap) bool { return !retrySwap.Direction.Valid() })
+}
+
+func (s *MemoryStore) findRetrySwaps(afterID uint, limit int, match func(retrySwap *model.RetrySwap) bool) ([]model.RetrySwap, error) {
+	defer s.lock()()
+	retrySwaps := make([]model.RetrySwap, 0)
+	for _, retrySwap := range (*s.tables).RetrySwaps {
+		if retrySwap.ID > afterID && match(&retrySwap) {
+			retrySwaps = append(retrySwaps, retrySwap)
+		}
+	}
+	sort.SliceStable(retrySwaps, func(i, j int) bool { return retrySwaps[i].ID < retrySwaps[j].ID })
+	if len(retrySwaps) > limit {
+		retrySwaps = retrySwaps[:limit]
+	}
+	return retrySwaps, nil
+}
+
+func (s *MemoryStore) CreateFillTx(swapTx *model.SwapFillTx) error {
+	defer s.lock()()
+	for _, existing := range (*s.tables).FillTxs {
+		if swapTx.ID != 0 && existing.ID == swapTx.ID {
+			return fmt.Errorf("fill tx %d exists", swapTx.ID)
+		}
+	}
+	if swapTx.ID == 0 {
+		swapTx.ID = s.nextFillTxID()
+	}
+	now := time.Now()
+	if swapTx.CreatedAt.IsZero() {
+		swapTx.CreatedAt = now
+	}
+	swapTx.UpdatedAt = now
+	(*s.tables).FillTxs = append((*s.tables).FillTxs, *swapTx)
+	return nil
+}
+
+// nextFillTxID doesn't reuse the ids of the deleted fill txs, like the sequences of the db
+func (s *MemoryStore) nextFillTxID() uint {
+	var id uint
+	for _, swapTx := range (*s.tables).FillTxs {
+		if swapTx.ID > id {
+			id = swapTx.ID
+		}
+	}
+	for _, swapTx := range (*s.tables).ArchivedFillTxs {
+		if swapTx.ID > id {
+			id = swapTx.ID
+		}
+	}
+	return id + 1
+}
+
+func (s *MemoryStore) DeleteFillTx(id uint) error {
+	defer s.lock()()
+	for i, swapTx := range (*s.tables).FillTxs {
+		if swapTx.ID == id {
+			(*s.tables).FillTxs = append((*s.tables).FillTxs[:i:i], (*s.tables).FillTxs[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetFillTxOfSwap(swapID uint) (*model.SwapFillTx, error) {
+	defer s.lock()()
+	for _, swapTx := range (*s.tables).FillTxs {
+		if swapTx.SwapID == swapID {
+			return &swapTx, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) FindSentFillTxs(directions []common.SwapDirection, maxTrackRetry int64, exceeded bool, limit int) ([]model.SwapFillTx, error) {
+	defer s.lock()()
+	swapTxs := make([]model.SwapFillTx, 0)
+	for _, swapTx := range (*s.tables).FillTxs {
+		if swapTx.Status != model.FillTxSent || !containsDirection(directions, swapTx.Direction) ||
+			(swapTx.TrackRetryCounter >= maxTrackRetry) != exceeded {
+			continue
+		}
+		swapTxs = append(swapTxs, swapTx)
+	}
+	sort.SliceStable(swapTxs, func(i, j int) bool { return swapTxs[i].ID < swapTxs[j].ID })
+	if len(swapTxs) > limit {
+		swapTxs = swapTxs[:limit]
+	}
+	return swapTxs, nil
+}
+
+func (s *MemoryStore) SetFillTxStatus(id uint, status model.FillTxStatus) error {
+	defer s.lock()()
+	if swapTx := s.findFillTx(id); swapTx != nil {
+		swapTx.Status = status
+		swapTx.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (s *MemoryStore) IncrFillTxTrackRetry(id uint) error {
+	defer s.lock()()
+	if swapTx := s.findFillTx(id); swapTx != nil {
+		swapTx.TrackRetryCounter++
+		swapTx.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetFillTxReceipt(id uint, status model.FillTxStatus, height int64, consumedFeeAmount, revertReason string) error {
+	defer s.lock()()
+	if swapTx := s.findFillTx(id); swapTx != nil {
+		swapTx.Status = status
+		swapTx.Height = height
+		swapTx.ConsumedFeeAmount = consumedFeeAmount
+		if revertReason != "" {
+			swapTx.RevertReason = revertReason
+		}
+		swapTx.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (s *MemoryStore) IsFillTxRecorded(txHash string) (bool, error) {
+	defer s.lock()()
+	for _, swapTx := range (*s.tables).FillTxs {
+		if swapTx.FillSwapTxHash == txHash {
+			return true, nil
+		}
+	}
+	for _, swapTx := range (*s.tables).ArchivedFillTxs {
+		if swapTx.FillSwapTxHash == txHash {
+			return true, nil
+		}
+	}
+	for _, retryTx := range (*s.tables).RetrySwapTxs {
+		if retryTx.RetryFillSwapTxHash == txHash {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *MemoryStore) OldestFillTx(status model.FillTxStatus, direction common.SwapDirection) (*model.SwapFillTx, error) {
+	defer s.lock()()
+	var oldest *model.SwapFillTx
+	for i, swapTx := range (*s.tables).FillTxs {
+		if swapTx.Status != status || swapTx.Direction != direction {
+			continue
+		}
+		if oldest == nil || swapTx.CreatedAt.Before(oldest.CreatedAt) {
+			oldest = &(*s.tables).FillTxs[i]
+		}
+	}
+	if oldest == nil {
+		return nil, nil
+	}
+	swapTx := *oldest
+	return &swapTx, nil
+}
+
+func (s *MemoryStore) GetFillTxByHash(txHash string) (*model.SwapFillTx, error) {
+	defer s.lock()()
+	var last *model.SwapFillTx
+	for i, swapTx := range (*s.tables).FillTxs {
+		if swapTx.FillSwapTxHash == txHash && (last == nil || swapTx.ID > last.ID) {
+			last = &(*s.tables).FillTxs[i]
+		}
+	}
+	if last == nil {
+		return nil, fmt.Errorf("fill tx %s is not found", txHash)
+	}
+	swapTx := *last
+	return &swapTx, nil
+}
+
+func (s *MemoryStore) GetLastFillTxOfSwap(swapID uint, status model.FillTxStatus) (*model.SwapFillTx, error) {
+	defer s.lock()()
+	var last *model.SwapFillTx
+	for i, swapTx := range (*s.tables).FillTxs {
+		if swapTx.SwapID == swapID && swapTx.Status == status && (last == nil || swapTx.ID > last.ID) {
+			last = &(*s.tables).FillTxs[i]
+		}
+	}
+	if last == nil {
+		return nil, nil
+	}
+	swapTx := *last
+	return &swapTx, nil
+}
+
+func (s *MemoryStore) FindFillTxsOfSwaps(swapIDs []uint) ([]model.SwapFillTx, error) {
+	return s.findFillTxs(0, func(swapTx *model.SwapFillTx) bool { return containsID(swapIDs, swapTx.SwapID) })
+}
+
+func (s *MemoryStore) FindFillTxsCreatedBefore(status model.FillTxStatus, createdBefore time.Time, limit int) ([]model.SwapFillTx, error) {
+	return s.findFillTxs(limit, func(swapTx *model.SwapFillTx) bool {
+		return swapTx.Status == status && swapTx.CreatedAt.Before(createdBefore)
+	})
+}
+
+func (s *MemoryStore) FindDroppedFillTxs(directions []common.SwapDirection, broadcastBefore int64, maxRebroadcast int64, limit int) ([]model.SwapFillTx, error) {
+	return s.findFillTxs(limit, func(swapTx *model.SwapFillTx) bool {
+		return swapTx.Status == model.FillTxSent && containsDirection(directions, swapTx.Direction) && swapTx.RawTx != "" &&
+			swapTx.BroadcastTime < broadcastBefore && swapTx.RebroadcastCounter < maxRebroadcast
+	})
+}
+
+// findFillTxs returns the fill txs matched by id, all of them if limit is 0
+func (s *MemoryStore) findFillTxs(limit int, match func(swapTx *model.SwapFillTx) bool) ([]model.SwapFillTx, error) {
+	defer s.lock()()
+	swapTxs := make([]model.SwapFillTx, 0)
+	for _, swapTx := range (*s.tables).FillTxs {
+		if match(&swapTx) {
+			swapTxs = append(swapTxs, swapTx)
+		}
+	}
+	sort.SliceStable(swapTxs, func(i, j int) bool { return swapTxs[i].ID < swapTxs[j].ID })
+	if limit > 0 && len(swapTxs) > limit {
+		swapTxs = swapTxs[:limit]
+	}
+	return swapTxs, nil
+}
+
+func (s *MemoryStore) MoveFillTxStatus(id uint, from, to model.FillTxStatus) (bool, error) {
+	defer s.lock()()
+	swapTx := s.findFillTx(id)
+	if swapTx == nil || swapTx.Status != from {
+		return false, nil
+	}
+	swapTx.Status = to
+	swapTx.UpdatedAt = time.Now()
+	return true, nil
+}
+
+func (s *MemoryStore) SetFillTxRebroadcast(id uint, rebroadcastCounter int64, resetTrackRetry bool) error {
+	defer s.lock()()
+	if swapTx := s.findFillTx(id); swapTx != nil {
+		swapTx.RebroadcastCounter = rebroadcastCounter
+		swapTx.BroadcastTime = time.Now().Unix()
+		if resetTrackRetry {
+			swapTx.TrackRetryCounter = 0
+		}
+		swapTx.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (s *MemoryStore) FindStartTxLogsAfter(afterID int64, limit int) ([]model.SwapStartTxLog, error) {
+	defer s.lock()()
+	txEventLogs := make([]model.SwapStartTxLog, 0)
+	for _, txEventLog := range (*s.tables).StartTxLogs {
+		if txEventLog.Id > afterID {
+			txEventLogs = append(txEventLogs, txEventLog)
+		}
+	}
+	sort.SliceStable(txEventLogs, func(i, j int) bool { return txEventLogs[i].Id < txEventLogs[j].Id })
+	if len(txEventLogs) > limit {
+		txEventLogs = txEventLogs[:limit]
+	}
+	return txEventLogs, nil
+}
+
+func (s *MemoryStore) FindStartTxLogsUpdatedBefore(phase model.TxPhase, updatedBefore time.Time, limit int) ([]model.SwapStartTxLog, error) {
+	defer s.lock()()
+	txEventLogs := make([]model.SwapStartTxLog, 0)
+	for _, txEventLog := range (*s.tables).StartTxLogs {
+		if txEventLog.Phase == phase && txEventLog.UpdateTime < updatedBefore.Unix() {
+			txEventLogs = append(txEventLogs, txEventLog)
+		}
+	}
+	sort.SliceStable(txEventLogs, func(i, j int) bool { return txEventLogs[i].Height < txEventLogs[j].Height })
+	if len(txEventLogs) > limit {
+		txEventLogs = txEventLogs[:limit]
+	}
+	return txEventLogs, nil
+}
+
+func (s *MemoryStore) FindStartTxLogsOfTxs(txHashes []string) ([]model.SwapStartTxLog, error) {
+	defer s.lock()()
+	txEventLogs := make([]model.SwapStartTxLog, 0)
+	for _, txEventLog := range (*s.tables).StartTxLogs {
+		if containsString(txHashes, txEventLog.TxHash) {
+			txEventLogs = append(txEventLogs, txEventLog)
+		}
+	}
+	return txEventLogs, nil
+}
+
+func (s *MemoryStore) SaveStartTxLog(txEventLog *model.SwapStartTxLog) error {
+	defer s.lock()()
+	existing := s.findStartTxLog(txEventLog.Id)
+	if existing == nil {
+		return fmt.Errorf("swap start tx log %d is not found", txEventLog.Id)
+	}
+	*existing = *txEventLog
+	return nil
+}
+
+func (s *MemoryStore) FindFillTxsAfter(afterID uint, limit int) ([]model.SwapFillTx, error) {
+	defer s.lock()()
+	swapTxs := make([]model.SwapFillTx, 0)
+	for _, swapTx := range (*s.tables).FillTxs {
+		if swapTx.ID > afterID {
+			swapTxs = append(swapTxs, swapTx)
+		}
+	}
+	sort.SliceStable(swapTxs, func(i, j int) bool { return swapTxs[i].ID < swapTxs[j].ID })
+	if len(swapTxs) > limit {
+		swapTxs = swapTxs[:limit]
+	}
+	return swapTxs, nil
+}
+
+func (s *MemoryStore) SaveFillTx(swapTx *model.SwapFillTx) error {
+	defer s.lock()()
+	existing := s.findFillTx(swapTx.ID)
+	if existing == nil {
+		return fmt.Errorf("fill tx %d is not found", swapTx.ID)
+	}
+	swapTx.UpdatedAt = time.Now()
+	*existing = *swapTx
+	return nil
+}
+
+func (s *MemoryStore) FindUnknownFillTxDirections() ([]string, error) {
+	defer s.lock()()
+	values := make([]string, 0)
+	for _, swapTx := range (*s.tables).FillTxs {
+		if !swapTx.Direction.Valid() && !containsString(values, string(swapTx.Direction)) {
+			values = append(values, string(swapTx.Direction))
+		}
+	}
+	return values, nil
+}
+
+func (s *MemoryStore) RenameFillTxDirection(value string, direction common.SwapDirection) (int64, error) {
+	defer s.lock()()
+	renamed := int64(0)
+	for i := range (*s.tables).FillTxs {
+		if swapTx := &(*s.tables).FillTxs[i]; string(swapTx.Direction) == value {
+			swapTx.Direction = direction
+			renamed++
+		}
+	}
+	return renamed, nil
+}
+
+func (s *MemoryStore) FindRetrySwapTxsAfter(afterID uint, limit int) ([]model.RetrySwapTx, error) {
+	defer s.lock()()
+	retrySwapTxs := make([]model.RetrySwapTx, 0)
+	for _, retrySwapTx := range (*s.tables).RetrySwapTxs {
+		if retrySwapTx.ID > afterID {
+			retrySwapTxs = append(retrySwapTxs, retrySwapTx)
+		}
+	}
+	sort.SliceStable(retrySwapTxs, func(i, j int) bool { return retrySwapTxs[i].ID < retrySwapTxs[j].ID })
+	if len(retrySwapTxs) > limit {
+		retrySwapTxs = retrySwapTxs[:limit]
+	}
+	return retrySwapTxs, nil
+}
+
+func (s *MemoryStore) SaveRetrySwapTx(retrySwapTx *model.RetrySwapTx) error {
+	defer s.lock()()
+	for i := range (*s.tables).RetrySwapTxs {
+		if existing := &(*s.tables).RetrySwapTxs[i]; existing.ID == retrySwapTx.ID {
+			retrySwapTx.UpdatedAt = time.Now()
+			*existing = *retrySwapTx
+			return nil
+		}
+	}
+	return fmt.Errorf("retry fill tx %d is not found", retrySwapTx.ID)
+}
+
+func (s *MemoryStore) findRetrySwapTx(id uint) *model.RetrySwapTx {
+	for i := range (*s.tables).RetrySwapTxs {
+		if (*s.tables).RetrySwapTxs[i].ID == id {
+			return &(*s.tables).RetrySwapTxs[i]
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) CreateRetrySwapTx(retrySwapTx *model.RetrySwapTx) error {
+	defer s.lock()()
+	retrySwapTxs := (*s.tables).RetrySwapTxs
+	retrySwapTx.ID = 1
+	if len(retrySwapTxs) != 0 {
+		retrySwapTx.ID = retrySwapTxs[len(retrySwapTxs)-1].ID + 1
+	}
+	retrySwapTx.CreatedAt, retrySwapTx.UpdatedAt = time.Now(), time.Now()
+	(*s.tables).RetrySwapTxs = append(retrySwapTxs, *retrySwapTx)
+	return nil
+}
+
+func (s *MemoryStore) DeleteRetrySwapTx(id uint) error {
+	defer s.lock()()
+	for i, retrySwapTx := range (*s.tables).RetrySwapTxs {
+		if retrySwapTx.ID == id {
+			(*s.tables).RetrySwapTxs = append((*s.tables).RetrySwapTxs[:i:i], (*s.tables).RetrySwapTxs[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetLastRetrySwapTx(retrySwapID uint) (*model.RetrySwapTx, error) {
+	defer s.lock()()
+	var last *model.RetrySwapTx
+	for i, retrySwapTx := range (*s.tables).RetrySwapTxs {
+		if retrySwapTx.RetrySwapID == retrySwapID && (last == nil || retrySwapTx.ID > last.ID) {
+			last = &(*s.tables).RetrySwapTxs[i]
+		}
+	}
+	if last == nil {
+		return nil, nil
+	}
+	retrySwapTx := *last
+	return &retrySwapTx, nil
+}
+
+func (s *MemoryStore) FindSentRetrySwapTxs(maxTrackRetry int64, exceeded bool, limit int) ([]model.RetrySwapTx, error) {
+	defer s.lock()()
+	retrySwapTxs := make([]model.RetrySwapTx, 0)
+	for _, retrySwapTx := range (*s.tables).RetrySwapTxs {
+		if retrySwapTx.Status == model.FillRetryTxSent && (retrySwapTx.TrackRetryCounter >= maxTrackRetry) == exceeded {
+			retrySwapTxs = append(retrySwapTxs, retrySwapTx)
+		}
+	}
+	sort.SliceStable(retrySwapTxs, func(i, j int) bool { return retrySwapTxs[i].ID < retrySwapTxs[j].ID })
+	if len(retrySwapTxs) > limit {
+		retrySwapTxs = retrySwapTxs[:limit]
+	}
+	return retrySwapTxs, nil
+}
+
+func (s *MemoryStore) SetRetrySwapTxStatus(id uint, status model.FillRetryTxStatus, errorMsg string) error {
+	defer s.lock()()
+	if retrySwapTx := s.findRetrySwapTx(id); retrySwapTx != nil {
+		retrySwapTx.Status = status
+		if errorMsg != "" {
+			retrySwapTx.ErrorMsg = errorMsg
+		}
+		retrySwapTx.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (s *MemoryStore) IncrRetrySwapTxTrackRetry(id uint) error {
+	defer s.lock()()
+	if retrySwapTx := s.findRetrySwapTx(id); retrySwapTx != nil {
+		retrySwapTx.TrackRetryCounter++
+		retrySwapTx.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetRetrySwapTxReceipt(id uint, status model.FillRetryTxStatus, height int64, consumedFeeAmount, revertReason string) error {
+	defer s.lock()()
+	if retrySwapTx := s.findRetrySwapTx(id); retrySwapTx != nil {
+		retrySwapTx.Status = status
+		retrySwapTx.Height = height
+		retrySwapTx.ConsumedFeeAmount = consumedFeeAmount
+		if revertReason != "" {
+			retrySwapTx.RevertReason = revertReason
+		}
+		retrySwapTx.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (s *MemoryStore) FindSwapPairs() ([]model.SwapPair, error) {
+	defer s.lock()()
+	pairs := append([]model.SwapPair(nil), (*s.tables).SwapPairs...)
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].ID < pairs[j].ID })
+	return pairs, nil
+}
+
+func (s *MemoryStore) SaveSwapPair(pair *model.SwapPair) error {
+	defer s.lock()()
+	for i := range (*s.tables).SwapPairs {
+		if existing := &(*s.tables).SwapPairs[i]; existing.ID == pair.ID {
+			pair.UpdatedAt = time.Now()
+			*existing = *pair
+			return nil
+		}
+	}
+	return fmt.Errorf("swap pair %d is not found", pair.ID)
+}
+
+func (s *MemoryStore) GetSwapPair(erc20Addr string) (*model.SwapPair, error) {
+	defer s.lock()()
+	for _, pair := range (*s.tables).SwapPairs {
+		if pair.ERC20Addr == erc20Addr {
+			return &pair, nil
+		}
+	}
+	return nil, fmt.Errorf("swap pair %s is not found", erc20Addr)
+}
+
+func (s *MemoryStore) DeleteSwapPair(erc20Addr string) error {
+	defer s.lock()()
+	pairs := (*s.tables).SwapPairs[:0]
+	for _, pair := range (*s.tables).SwapPairs {
+		if pair.ERC20Addr != erc20Addr {
+			pairs = append(pairs, pair)
+		}
+	}
+	(*s.tables).SwapPairs = pairs
+	owners := (*s.tables).PairOwners[:0]
+	for _, owner := range (*s.tables).PairOwners {
+		if owner.ERC20Addr != erc20Addr {
+			owners = append(owners, owner)
+		}
+	}
+	(*s.tables).PairOwners = owners
+	fillMethods := (*s.tables).PairFillMethods[:0]
+	for _, fillMethod := range (*s.tables).PairFillMethods {
+		if fillMethod.ERC20Addr != erc20Addr {
+			fillMethods = append(fillMethods, fillMethod)
+		}
+	}
+	(*s.tables).PairFillMethods = fillMethods
+	s.deletePausedPair(erc20Addr)
+	return nil
+}
+
+func (s *MemoryStore) CreatePausedPair(pausedPair *model.PausedPair) error {
+	defer s.lock()()
+	pausedPairs := (*s.tables).PausedPairs
+	for _, existing := range pausedPairs {
+		if existing.ERC20Addr == pausedPair.ERC20Addr {
+			return fmt.Errorf("swap pair %s is paused", pausedPair.ERC20Addr)
+		}
+	}
+	pausedPair.ID = 1
+	if len(pausedPairs) != 0 {
+		pausedPair.ID = pausedPairs[len(pausedPairs)-1].ID + 1
+	}
+	pausedPair.CreatedAt, pausedPair.UpdatedAt = time.Now(), time.Now()
+	(*s.tables).PausedPairs = append(pausedPairs, *pausedPair)
+	return nil
+}
+
+func (s *MemoryStore) DeletePausedPair(erc20Addr string) error {
+	defer s.lock()()
+	s.deletePausedPair(erc20Addr)
+	return nil
+}
+
+func (s *MemoryStore) deletePausedPair(erc20Addr string) {
+	pausedPairs := (*s.tables).PausedPairs[:0]
+	for _, pausedPair := range (*s.tables).PausedPairs {
+		if pausedPair.ERC20Addr != erc20Addr {
+			pausedPairs = append(pausedPairs, pausedPair)
+		}
+	}
+	(*s.tables).PausedPairs = pausedPairs
+}
+
+func (s *MemoryStore) FindLatencyStats(direction common.SwapDirection) ([]model.SwapLatencyStat, error) {
+	defer s.lock()()
+	stats := make([]model.SwapLatencyStat, 0)
+	for _, stat := range (*s.tables).LatencyStats {
+		if stat.Direction == direction {
+			stats = append(stats, stat)
+		}
+	}
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+	return stats, nil
+}
+
+func (s *MemoryStore) SaveLatencyStat(stat *model.SwapLatencyStat) error {
+	defer s.lock()()
+	stats := (*s.tables).LatencyStats
+	for i := range stats {
+		if stat.ID != 0 && stats[i].ID == stat.ID {
+			stat.UpdatedAt = time.Now()
+			stats[i] = *stat
+			return nil
+		}
+	}
+	stat.ID = 1
+	if len(stats) != 0 {
+		stat.ID = stats[len(stats)-1].ID + 1
+	}
+	stat.CreatedAt, stat.UpdatedAt = time.Now(), time.Now()
+	(*s.tables).LatencyStats = append(stats, *stat)
+	return nil
+}
+
+func (s *MemoryStore) GetRelayedSwap(chain, startTxHash string) (*model.RelayedSwap, error) {
+	defer s.lock()()
+	for _, relayedSwap := range (*s.tables).RelayedSwaps {
+		if relayedSwap.Chain == chain && relayedSwap.StartTxHash == startTxHash {
+			return &relayedSwap, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) FindRelayedSwaps(statuses []model.RelayedSwapStatus, limit int) ([]model.RelayedSwap, error) {
+	defer s.lock()()
+	relayedSwaps := make([]model.RelayedSwap, 0)
+	for _, relayedSwap := range (*s.tables).RelayedSwaps {
+		for _, status := range statuses {
+			if relayedSwap.Status == status {
+				relayedSwaps = append(relayedSwaps, relayedSwap)
+			}
+		}
+	}
+	sort.SliceStable(relayedSwaps, func(i, j int) bool { return relayedSwaps[i].ID < relayedSwaps[j].ID })
+	if limit > 0 && len(relayedSwaps) > limit {
+		relayedSwaps = relayedSwaps[:limit]
+	}
+	return relayedSwaps, nil
+}
+
+func (s *MemoryStore) SaveRelayedSwap(relayedSwap *model.RelayedSwap) error {
+	defer s.lock()()
+	for i := range (*s.tables).RelayedSwaps {
+		if existing := &(*s.tables).RelayedSwaps[i]; existing.ID == relayedSwap.ID {
+			relayedSwap.UpdatedAt = time.Now()
+			*existing = *relayedSwap
+			return nil
+		}
+	}
+	return fmt.Errorf("relayed swap %d is not found", relayedSwap.ID)
+}
+
+func (s *MemoryStore) GetSponsorTier(sponsor string) (*model.SponsorTier, error) {
+	defer s.lock()()
+	sponsor = ethcom.HexToAddress(sponsor).String()
+	for _, tier := range (*s.tables).SponsorTiers {
+		if tier.Sponsor == sponsor {
+			return &tier, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) FindWebhooks(sponsors []string) ([]model.Webhook, error) {
+	defer s.lock()()
+	webhooks := make([]model.Webhook, 0)
+	for _, webhook := range (*s.tables).Webhooks {
+		if containsString(sponsors, webhook.Sponsor) {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	return webhooks, nil
+}
+
+func (s *MemoryStore) IsPairOwner(apiKey, erc20Addr string) (bool, error) {
+	defer s.lock()()
+	for _, owner := range (*s.tables).PairOwners {
+		if owner.ApiKey == apiKey && owner.ERC20Addr == erc20Addr {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *MemoryStore) CreateWebhookDelivery(delivery *model.WebhookDelivery) error {
+	defer s.lock()()
+	deliveries := (*s.tables).WebhookDeliveries
+	delivery.ID = 1
+	if len(deliveries) != 0 {
+		delivery.ID = deliveries[len(deliveries)-1].ID + 1
+	}
+	delivery.CreatedAt, delivery.UpdatedAt = time.Now(), time.Now()
+	(*s.tables).WebhookDeliveries = append(deliveries, *delivery)
+	return nil
+}
+
+func (s *MemoryStore) SetWebhookDeliveryPayload(id uint, payload string) error {
+	defer s.lock()()
+	for i := range (*s.tables).WebhookDeliveries {
+		if delivery := &(*s.tables).WebhookDeliveries[i]; delivery.ID == id {
+			delivery.Payload = payload
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) FindDueWebhookDeliveries(dueAt int64, limit int) ([]model.WebhookDelivery, error) {
+	defer s.lock()()
+	deliveries := make([]model.WebhookDelivery, 0)
+	for _, delivery := range (*s.tables).WebhookDeliveries {
+		if delivery.Status == model.WebhookDeliveryPending && delivery.NextAttemptAt <= dueAt {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+func (s *MemoryStore) GetWebhook(id uint) (*model.Webhook, error) {
+	defer s.lock()()
+	for _, webhook := range (*s.tables).Webhooks {
+		if webhook.ID == id {
+			return &webhook, nil
+		}
+	}
+	return nil, fmt.Errorf("webhook %d is not found", id)
+}
+
+func (s *MemoryStore) SaveWebhookDelivery(delivery *model.WebhookDelivery) error {
+	defer s.lock()()
+	for i := range (*s.tables).WebhookDeliveries {
+		if existing := &(*s.tables).WebhookDeliveries[i]; existing.ID == delivery.ID {
+			delivery.UpdatedAt = time.Now()
+			*existing = *delivery
+			return nil
+		}
+	}
+	return fmt.Errorf("webhook delivery %d is not found", delivery.ID)
+}
+
+func (s *MemoryStore) CreateBridgeEvent(event *model.BridgeEvent) error {
+	defer s.lock()()
+	events := (*s.tables).BridgeEvents
+	event.ID = 1
+	if len(events) != 0 {
+		event.ID = events[len(events)-1].ID + 1
+	}
+	event.CreatedAt, event.UpdatedAt = time.Now(), time.Now()
+	(*s.tables).BridgeEvents = append(events, *event)
+	return nil
+}
+
+func (s *MemoryStore) SetBridgeEventPayload(id uint, payload string) error {
+	defer s.lock()()
+	for i := range (*s.tables).BridgeEvents {
+		if event := &(*s.tables).BridgeEvents[i]; event.ID == id {
+			event.Payload = payload
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) FindRebalancePlans(statuses []model.RebalancePlanStatus) ([]model.RebalancePlan, error) {
+	defer s.lock()()
+	plans := make([]model.RebalancePlan, 0)
+	for _, plan := range (*s.tables).RebalancePlans {
+		if containsPlanStatus(statuses, plan.Status) {
+			plans = append(plans, plan)
+		}
+	}
+	return plans, nil
+}
+
+func (s *MemoryStore) FindLatestRebalancePlans(limit int) ([]model.RebalancePlan, error) {
+	defer s.lock()()
+	plans := append([]model.RebalancePlan(nil), (*s.tables).RebalancePlans...)
+	sort.SliceStable(plans, func(i, j int) bool { return plans[i].ID > plans[j].ID })
+	if len(plans) > limit {
+		plans = plans[:limit]
+	}
+	return plans, nil
+}
+
+func (s *MemoryStore) GetRebalancePlan(id uint) (*model.RebalancePlan, error) {
+	defer s.lock()()
+	existing := s.findRebalancePlan(id)
+	if existing == nil {
+		return nil, fmt.Errorf("rebalance plan %d is not found", id)
+	}
+	plan := *existing
+	return &plan, nil
+}
+
+func (s *MemoryStore) CountRebalancePlans(statuses []model.RebalancePlanStatus) (int, error) {
+	plans, err := s.FindRebalancePlans(statuses)
+	return len(plans), err
+}
+
+func (s *MemoryStore) CreateRebalancePlan(plan *model.RebalancePlan) error {
+	defer s.lock()()
+	plans := (*s.tables).RebalancePlans
+	plan.ID = 1
+	if len(plans) != 0 {
+		plan.ID = plans[len(plans)-1].ID + 1
+	}
+	plan.CreatedAt, plan.UpdatedAt = time.Now(), time.Now()
+	(*s.tables).RebalancePlans = append(plans, *plan)
+	return nil
+}
+
+func (s *MemoryStore) findRebalancePlan(id uint) *model.RebalancePlan {
+	for i := range (*s.tables).RebalancePlans {
+		if (*s.tables).RebalancePlans[i].ID == id {
+			return &(*s.tables).RebalancePlans[i]
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetRebalancePlanStatus(id uint, from, to model.RebalancePlanStatus) (bool, error) {
+	defer s.lock()()
+	plan := s.findRebalancePlan(id)
+	if plan == nil || plan.Status != from {
+		return false, nil
+	}
+	plan.Status = to
+	plan.UpdatedAt = time.Now()
+	return true, nil
+}
+
+func (s *MemoryStore) SetRebalancePlanTransfers(id uint, transfers string) error {
+	defer s.lock()()
+	if plan := s.findRebalancePlan(id); plan != nil {
+		plan.Transfers = transfers
+		plan.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (s *MemoryStore) FailRebalancePlan(id uint, errorMsg string) error {
+	defer s.lock()()
+	if plan := s.findRebalancePlan(id); plan != nil {
+		plan.Status = model.RebalancePlanFailed
+		plan.ErrorMsg = errorMsg
+		plan.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (s *MemoryStore) ReviewRebalancePlan(plan *model.RebalancePlan, from model.RebalancePlanStatus) (bool, error) {
+	defer s.lock()()
+	existing := s.findRebalancePlan(plan.ID)
+	if existing == nil || existing.Status != from {
+		return false, nil
+	}
+	existing.Status = plan.Status
+	existing.Approver = plan.Approver
+	existing.ErrorMsg = plan.ErrorMsg
+	existing.UpdatedAt = time.Now()
+	return true, nil
+}
+
+func (s *MemoryStore) GetDrainBrakeHalt(chain string) (*model.DrainBrakeHalt, error) {
+	defer s.lock()()
+	for _, halt := range (*s.tables).DrainBrakeHalts {
+		if halt.Chain == chain {
+			return &halt, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) FindDrainBrakeHalts() ([]model.DrainBrakeHalt, error) {
+	defer s.lock()()
+	halts := append([]model.DrainBrakeHalt{}, (*s.tables).DrainBrakeHalts...)
+	sort.Slice(halts, func(i, j int) bool { return halts[i].Chain < halts[j].Chain })
+	return halts, nil
+}
+
+func (s *MemoryStore) CreateDrainBrakeHalt(halt *model.DrainBrakeHalt) (bool, error) {
+	defer s.lock()()
+	halts := (*s.tables).DrainBrakeHalts
+	for _, existing := range halts {
+		if existing.Chain == halt.Chain {
+			return false, nil
+		}
+	}
+	halt.ID = 1
+	if len(halts) != 0 {
+		halt.ID = halts[len(halts)-1].ID + 1
+	}
+	halt.CreatedAt, halt.UpdatedAt = time.Now(), time.Now()
+	(*s.tables).DrainBrakeHalts = append(halts, *halt)
+	return true, nil
+}
+
+func (s *MemoryStore) DeleteDrainBrakeHalt(chain string) (bool, error) {
+	defer s.lock()()
+	halts := (*s.tables).DrainBrakeHalts[:0]
+	deleted := false
+	for _, halt := range (*s.tables).DrainBrakeHalts {
+		if halt.Chain == chain {
+			deleted = true
+			continue
+		}
+		halts = append(halts, halt)
+	}
+	(*s.tables).DrainBrakeHalts = halts
+	return deleted, nil
+}
+
+func (s *MemoryStore) CreateRelayerSpend(spend *model.RelayerSpend) error {
+	defer s.lock()()
+	spends := (*s.tables).RelayerSpends
+	spend.ID = 1
+	if len(spends) != 0 {
+		spend.ID = spends[len(spends)-1].ID + 1
+	}
+	spend.CreatedAt, spend.UpdatedAt = time.Now(), time.Now()
+	(*s.tables).RelayerSpends = append(spends, *spend)
+	return nil
+}
+
+func (s *MemoryStore) FindRelayerSpends(since time.Time) ([]model.RelayerSpend, error) {
+	defer s.lock()()
+	spends := make([]model.RelayerSpend, 0)
+	for _, spend := range (*s.tables).RelayerSpends {
+		if !spend.CreatedAt.Before(since) {
+			spends = append(spends, spend)
+		}
+	}
+	return spends, nil
+}
+
+func (s *MemoryStore) DeleteRelayerSpends(chain string, before time.Time) error {
+	defer s.lock()()
+	spends := (*s.tables).RelayerSpends[:0]
+	for _, spend := range (*s.tables).RelayerSpends {
+		if spend.CreatedAt.Before(before) && (chain == "" || spend.Chain == chain) {
+			continue
+		}
+		spends = append(spends, spend)
+	}
+	(*s.tables).RelayerSpends = spends
+	return nil
+}
+
+func (s *MemoryStore) ReserveRelayerNonce(chain, account string, nonce uint64, instanceID string) (bool, error) {
+	defer s.lock()()
+	nonces := (*s.tables).RelayerNonces
+	for _, reserved := range nonces {
+		if reserved.Chain == chain && reserved.Account == account && reserved.Nonce == nonce {
+			return reserved.Instance == instanceID, nil
+		}
+	}
+	reserved := model.RelayerNonce{Chain: chain, Account: account, Nonce: nonce, Instance: instanceID}
+	reserved.ID = 1
+	if len(nonces) != 0 {
+		reserved.ID = nonces[len(nonces)-1].ID + 1
+	}
+	reserved.CreatedAt, reserved.UpdatedAt = time.Now(), time.Now()
+	(*s.tables).RelayerNonces = append(nonces, reserved)
+	return true, nil
+}
+
+func (s *MemoryStore) CreateKeyUsage(keyUsage *model.KeyUsage) error {
+	defer s.lock()()
+	keyUsages := (*s.tables).KeyUsages
+	keyUsage.ID = 1
+	if len(keyUsages) != 0 {
+		keyUsage.ID = keyUsages[len(keyUsages)-1].ID + 1
+	}
+	keyUsage.CreatedAt, keyUsage.UpdatedAt = time.Now(), time.Now()
+	(*s.tables).KeyUsages = append(keyUsages, *keyUsage)
+	return nil
+}
+
+func (s *MemoryStore) FindUnminedFillTxs(directions []common.SwapDirection) ([]model.SwapFillTx, error) {
+	return s.findFillTxs(0, func(swapTx *model.SwapFillTx) bool {
+		return (swapTx.Status == model.FillTxCreated || swapTx.Status == model.FillTxSent) &&
+			containsDirection(directions, swapTx.Direction) && swapTx.RawTx != ""
+	})
+}
+
+func (s *MemoryStore) FindUnpublishedBridgeEvents(limit int) ([]model.BridgeEvent, error) {
+	defer s.lock()()
+	events := make([]model.BridgeEvent, 0)
+	for _, event := range (*s.tables).BridgeEvents {
+		if !event.Published && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (s *MemoryStore) MarkBridgeEventsPublished(ids []uint) error {
+	defer s.lock()()
+	for i := range (*s.tables).BridgeEvents {
+		if event := &(*s.tables).BridgeEvents[i]; containsID(ids, event.ID) {
+			event.Published = true
+			event.PublishedAt = time.Now().Unix()
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeletePublishedBridgeEvents(createdBefore time.Time) (int64, error) {
+	defer s.lock()()
+	events := (*s.tables).BridgeEvents[:0]
+	deleted := int64(0)
+	for _, event := range (*s.tables).BridgeEvents {
+		if event.Published && event.CreatedAt.Before(createdBefore) {
+			deleted++
+			continue
+		}
+		events = append(events, event)
+	}
+	(*s.tables).BridgeEvents = events
+	return deleted, nil
+}
+
+func (s *MemoryStore) ArchiveSwaps(statuses []common.SwapStatus, updatedBefore time.Time, limit int) (int, error) {
+	defer s.lock()()
+	retried := make(map[string]bool)
+	for _, retrySwap := range (*s.tables).RetrySwaps {
+		for _, status := range activeRetrySwapStatuses {
+			if retrySwap.Status == status {
+				retried[retrySwap.StartTxHash] = true
+			}
+		}
+	}
+
+	archivedAt := time.Now().Unix()
+	archived := make(map[uint]bool)
+	startTxHashes := make([]string, 0)
+	swaps := (*s.tables).Swaps[:0]
+	for _, swap := range (*s.tables).Swaps {
+		if len(archived) < limit && containsStatus(statuses, swap.Status) && swap.UpdatedAt.Before(updatedBefore) &&
+			!retried[swap.StartTxHash] {
+			(*s.tables).ArchivedSwaps = append((*s.tables).ArchivedSwaps, *model.NewArchivedSwap(&swap, archivedAt))
+			archived[swap.ID] = true
+			startTxHashes = append(startTxHashes, swap.StartTxHash)
+			continue
+		}
+		swaps = append(swaps, swap)
+	}
+	(*s.tables).Swaps = swaps
+
+	txEventLogs := (*s.tables).StartTxLogs[:0]
+	for _, txEventLog := range (*s.tables).StartTxLogs {
+		if containsString(startTxHashes, txEventLog.TxHash) {
+			(*s.tables).ArchivedStartTxLogs = append((*s.tables).ArchivedStartTxLogs,
+				*model.NewArchivedSwapStartTxLog(&txEventLog, archivedAt))
+			continue
+		}
+		txEventLogs = append(txEventLogs, txEventLog)
+	}
+	(*s.tables).StartTxLogs = txEventLogs
+
+	swapTxs := (*s.tables).FillTxs[:0]
+	for _, swapTx := range (*s.tables).FillTxs {
+		if archived[swapTx.SwapID] {
+			(*s.tables).ArchivedFillTxs = append((*s.tables).ArchivedFillTxs, *model.NewArchivedSwapFillTx(&swapTx, archivedAt))
+			continue
+		}
+		swapTxs = append(swapTxs, swapTx)
+	}
+	(*s.tables).FillTxs = swapTxs
+	return len(archived), nil
+}
+
+func (s *MemoryStore) IsStartTxRecorded(txHash string) (bool, error) {
+	defer s.lock()()
+	for _, txEventLog := range (*s.tables).StartTxLogs {
+		if txEventLog.TxHash == txHash {
+			return true, nil
+		}
+	}
+	for _, txEventLog := range (*s.tables).ArchivedStartTxLogs {
+		if txEventLog.TxHash == txHash {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *MemoryStore) IsSwapRecorded(startTxHash string) (bool, error) {
+	defer s.lock()()
+	for _, swap := range (*s.tables).Swaps {
+		if swap.StartTxHash == startTxHash {
+			return true, nil
+		}
+	}
+	for _, swap := range (*s.tables).ArchivedSwaps {
+		if swap.StartTxHash == startTxHash {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *MemoryStore) CreateStartTxLog(txEventLog *model.SwapStartTxLog) error {
+	defer s.lock()()
+	var lastID int64
+	for _, existing := range (*s.tables).StartTxLogs {
+		if existing.Id > lastID {
+			lastID = existing.Id
+		}
+	}
+	txEventLog.Id = lastID + 1
+	(*s.tables).StartTxLogs = append((*s.tables).StartTxLogs, *txEventLog)
+	return nil
+}
+
+func (s *MemoryStore) GetLatestBlockLog(chain string) (*model.BlockLog, error) {
+	defer s.lock()()
+	var latest *model.BlockLog
+	for i, blockLog := range (*s.tables).BlockLogs {
+		if blockLog.Chain == chain && (latest == nil || blockLog.Height > latest.Height) {
+			latest = &(*s.tables).BlockLogs[i]
+		}
+	}
+	if latest == nil {
+		return nil, nil
+	}
+	blockLog := *latest
+	return &blockLog, nil
+}
+
+func (s *MemoryStore) CreateBlockLog(blockLog *model.BlockLog) error {
+	defer s.lock()()
+	blockLogs := (*s.tables).BlockLogs
+	blockLog.Id = 1
+	if len(blockLogs) != 0 {
+		blockLog.Id = blockLogs[len(blockLogs)-1].Id + 1
+	}
+	(*s.tables).BlockLogs = append(blockLogs, *blockLog)
+	return nil
+}
+
+func (s *MemoryStore) LockSwap(id uint) (*model.Swap, error) {
+	return s.GetSwap(id)
+}
+
+func (s *MemoryStore) QuarantineSwap(quarantinedSwap *model.QuarantinedSwap) error {
+	defer s.lock()()
+	quarantinedSwaps := (*s.tables).QuarantinedSwaps
+	quarantinedSwap.ID = 1
+	if len(quarantinedSwaps) != 0 {
+		quarantinedSwap.ID = quarantinedSwaps[len(quarantinedSwaps)-1].ID + 1
+	}
+	quarantinedSwap.CreatedAt, quarantinedSwap.UpdatedAt = time.Now(), time.Now()
+	(*s.tables).QuarantinedSwaps = append(quarantinedSwaps, *quarantinedSwap)
+	swaps := (*s.tables).Swaps[:0]
+	for _, swap := range (*s.tables).Swaps {
+		if swap.ID != quarantinedSwap.SwapID {
+			swaps = append(swaps, swap)
+		}
+	}
+	(*s.tables).Swaps = swaps
+	return nil
+}
+
+func (s *MemoryStore) FindUnprovenSwaps(afterID uint, limit int) ([]model.Swap, error) {
+	defer s.lock()()
+	proven := make(map[string]bool)
+	for _, proof := range (*s.tables).SwapProofs {
+		proven[proof.StartTxHash] = true
+	}
+	swaps := make([]model.Swap, 0)
+	for _, swap := range (*s.tables).Swaps {
+		if swap.Status == SwapSuccess && swap.ID > afterID && !proven[swap.StartTxHash] {
+			swaps = append(swaps, swap)
+		}
+	}
+	return limitSwaps(swaps, limit), nil
+}
+
+func (s *MemoryStore) CreateSwapProof(proof *model.SwapProof) error {
+	defer s.lock()()
+	proofs := (*s.tables).SwapProofs
+	for _, existing := range proofs {
+		if existing.StartTxHash == proof.StartTxHash {
+			return fmt.Errorf("proof of swap start tx %s exists", proof.StartTxHash)
+		}
+	}
+	proof.ID = 1
+	if len(proofs) != 0 {
+		proof.ID = proofs[len(proofs)-1].ID + 1
+	}
+	proof.CreatedAt, proof.UpdatedAt = time.Now(), time.Now()
+	(*s.tables).SwapProofs = append(proofs, *proof)
+	return nil
+}
+
+func (s *MemoryStore) IsTokenInPair(token string) (bool, error) {
+	defer s.lock()()
+	for _, pair := range (*s.tables).SwapPairs {
+		if pair.BEP20Addr == token || pair.ERC20Addr == token {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *MemoryStore) CreateSwapPair(pair *model.SwapPair) error {
+	defer s.lock()()
+	var lastID uint
+	for _, existing := range (*s.tables).SwapPairs {
+		if existing.ERC20Addr == pair.ERC20Addr {
+			return fmt.Errorf("swap pair %s exists", pair.ERC20Addr)
+		}
+		if existing.ID > lastID {
+			lastID = existing.ID
+		}
+	}
+	pair.ID = lastID + 1
+	pair.CreatedAt, pair.UpdatedAt = time.Now(), time.Now()
+	(*s.tables).SwapPairs = append((*s.tables).SwapPairs, *pair)
+	return nil
+}
+
+func (s *MemoryStore) CreatePairFillMethod(fillMethod *model.PairFillMethod) error {
+	defer s.lock()()
+	var lastID uint
+	for _, existing := range (*s.tables).PairFillMethods {
+		if existing.ERC20Addr == fillMethod.ERC20Addr && existing.Chain == fillMethod.Chain {
+			return fmt.Errorf("fill method of %s on %s exists", fillMethod.ERC20Addr, fillMethod.Chain)
+		}
+		if existing.ID > lastID {
+			lastID = existing.ID
+		}
+	}
+	fillMethod.ID = lastID + 1
+	fillMethod.CreatedAt, fillMethod.UpdatedAt = time.Now(), time.Now()
+	(*s.tables).PairFillMethods = append((*s.tables).PairFillMethods, *fillMethod)
+	return nil
+}
+
+func (s *MemoryStore) CountPeggedTokenDeployments(sourceToken string, statuses []model.PeggedTokenStatus) (int, error) {
+	defer s.lock()()
+	count := 0
+	for _, deployment := range (*s.tables).PeggedTokens {
+		if deployment.SourceToken == sourceToken && containsPeggedTokenStatus(statuses, deployment.Status) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *MemoryStore) CreatePeggedTokenDeployment(deployment *model.PeggedTokenDeployment) error {
+	defer s.lock()()
+	deployments := (*s.tables).PeggedTokens
+	deployment.ID = 1
+	if len(deployments) != 0 {
+		deployment.ID = deployments[len(deployments)-1].ID + 1
+	}
+	deployment.CreatedAt, deployment.UpdatedAt = time.Now(), time.Now()
+	(*s.tables).PeggedTokens = append(deployments, *deployment)
+	return nil
+}
+
+func (s *MemoryStore) findPeggedTokenDeployment(id uint) *model.PeggedTokenDeployment {
+	for i := range (*s.tables).PeggedTokens {
+		if (*s.tables).PeggedTokens[i].ID == id {
+			return &(*s.tables).PeggedTokens[i]
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetPeggedTokenDeployment(id uint) (*model.PeggedTokenDeployment, error) {
+	defer s.lock()()
+	deployment := s.findPeggedTokenDeployment(id)
+	if deployment == nil {
+		return nil, fmt.Errorf("pegged token deployment %d is not found", id)
+	}
+	found := *deployment
+	return &found, nil
+}
+
+func (s *MemoryStore) FindPeggedTokenDeployments(statuses []model.PeggedTokenStatus, limit int) ([]model.PeggedTokenDeployment, error) {
+	defer s.lock()()
+	deployments := make([]model.PeggedTokenDeployment, 0)
+	for _, deployment := range (*s.tables).PeggedTokens {
+		if containsPeggedTokenStatus(statuses, deployment.Status) && len(deployments) < limit {
+			deployments = append(deployments, deployment)
+		}
+	}
+	return deployments, nil
+}
+
+func (s *MemoryStore) SetPeggedTokenDeploymentTx(id uint, status model.PeggedTokenStatus, txHash string) error {
+	defer s.lock()()
+	if deployment := s.findPeggedTokenDeployment(id); deployment != nil {
+		deployment.Status = status
+		deployment.TxHash = txHash
+		deployment.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetPeggedTokenDeploymentToken(id uint, token string) error {
+	defer s.lock()()
+	if deployment := s.findPeggedTokenDeployment(id); deployment != nil {
+		deployment.Token = token
+		deployment.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetPeggedTokenDeploymentStatus(id uint, status model.PeggedTokenStatus, errorMsg string) error {
+	defer s.lock()()
+	if deployment := s.findPeggedTokenDeployment(id); deployment != nil {
+		deployment.Status = status
+		deployment.ErrorMsg = errorMsg
+		deployment.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetLatestRollup(period model.RollupPeriod) (*model.SwapRollup, error) {
+	defer s.lock()()
+	var latest *model.SwapRollup
+	for i, rollup := range (*s.tables).Rollups {
+		if rollup.Period == period && (latest == nil || rollup.BucketStart > latest.BucketStart) {
+			latest = &(*s.tables).Rollups[i]
+		}
+	}
+	if latest == nil {
+		return nil, nil
+	}
+	rollup := *latest
+	return &rollup, nil
+}
+
+func (s *MemoryStore) FindRollups(query RollupQuery) ([]model.SwapRollup, error) {
+	defer s.lock()()
+	rollups := make([]model.SwapRollup, 0)
+	for _, rollup := range (*s.tables).Rollups {
+		if rollup.Period != query.Period || rollup.BucketStart < query.Start || (query.End != 0 && rollup.BucketStart >= query.End) ||
+			(query.ERC20Addr != "" && rollup.ERC20Addr != query.ERC20Addr) ||
+			(query.Direction != "" && rollup.Direction != query.Direction) {
+			continue
+		}
+		rollups = append(rollups, rollup)
+	}
+	sort.SliceStable(rollups, func(i, j int) bool {
+		if rollups[i].BucketStart != rollups[j].BucketStart {
+			return rollups[i].BucketStart < rollups[j].BucketStart
+		}
+		return rollups[i].ID < rollups[j].ID
+	})
+	if query.Limit > 0 && len(rollups) > query.Limit {
+		rollups = rollups[:query.Limit]
+	}
+	return rollups, nil
+}
+
+func (s *MemoryStore) DeleteRollups(period model.RollupPeriod, start, end int64) error {
+	defer s.lock()()
+	rollups := (*s.tables).Rollups[:0]
+	for _, rollup := range (*s.tables).Rollups {
+		if rollup.Period == period && rollup.BucketStart >= start && rollup.BucketStart < end {
+			continue
+		}
+		rollups = append(rollups, rollup)
+	}
+	(*s.tables).Rollups = rollups
+	return nil
+}
+
+func (s *MemoryStore) CreateRollup(rollup *model.SwapRollup) error {
+	defer s.lock()()
+	var lastID uint
+	for _, existing := range (*s.tables).Rollups {
+		if existing.ID > lastID {
+			lastID = existing.ID
+		}
+	}
+	rollup.ID = lastID + 1
+	rollup.CreatedAt, rollup.UpdatedAt = time.Now(), time.Now()
+	(*s.tables).Rollups = append((*s.tables).Rollups, *rollup)
+	return nil
+}
+
+func (s *MemoryStore) CountPendingRelayedSwaps(chain, owner string) (int, error) {
+	defer s.lock()()
+	count := 0
+	for _, relayedSwap := range (*s.tables).RelayedSwaps {
+		if relayedSwap.Chain == chain && relayedSwap.Owner == owner && relayedSwap.Status != model.RelayedSwapStarted &&
+			relayedSwap.Status != model.RelayedSwapFailed {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *MemoryStore) CountRelayedSwapsOfOwner(owner string, since time.Time) (int64, error) {
+	defer s.lock()()
+	count := int64(0)
+	for _, relayedSwap := range (*s.tables).RelayedSwaps {
+		if relayedSwap.Owner == owner && !relayedSwap.CreatedAt.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *MemoryStore) CountRelayedSwapsOfIP(remoteIP string, since time.Time) (int64, error) {
+	defer s.lock()()
+	count := int64(0)
+	for _, relayedSwap := range (*s.tables).RelayedSwaps {
+		if relayedSwap.RemoteIP == remoteIP && !relayedSwap.CreatedAt.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *MemoryStore) CreateRelayedSwap(relayedSwap *model.RelayedSwap) error {
+	defer s.lock()()
+	relayedSwaps := (*s.tables).RelayedSwaps
+	relayedSwap.ID = 1
+	if len(relayedSwaps) != 0 {
+		relayedSwap.ID = relayedSwaps[len(relayedSwaps)-1].ID + 1
+	}
+	relayedSwap.CreatedAt, relayedSwap.UpdatedAt = time.Now(), time.Now()
+	(*s.tables).RelayedSwaps = append(relayedSwaps, *relayedSwap)
+	return nil
+}
+
+func (s *MemoryStore) FindSponsorTiers() ([]model.SponsorTier, error) {
+	defer s.lock()()
+	tiers := append([]model.SponsorTier(nil), (*s.tables).SponsorTiers...)
+	sort.SliceStable(tiers, func(i, j int) bool {
+		if tiers[i].Priority != tiers[j].Priority {
+			return tiers[i].Priority > tiers[j].Priority
+		}
+		return tiers[i].ID < tiers[j].ID
+	})
+	return tiers, nil
+}
+
+func (s *MemoryStore) SaveSponsorTier(tier *model.SponsorTier) error {
+	defer s.lock()()
+	var lastID uint
+	for i := range (*s.tables).SponsorTiers {
+		existing := &(*s.tables).SponsorTiers[i]
+		if tier.ID != 0 && existing.ID == tier.ID {
+			tier.UpdatedAt = time.Now()
+			*existing = *tier
+			return nil
+		}
+		if existing.ID > lastID {
+			lastID = existing.ID
+		}
+	}
+	tier.ID = lastID + 1
+	tier.CreatedAt, tier.UpdatedAt = time.Now(), time.Now()
+	(*s.tables).SponsorTiers = append((*s.tables).SponsorTiers, *tier)
+	return nil
+}
+
+func (s *MemoryStore) DeleteSponsorTier(sponsor string) (bool, error) {
+	defer s.lock()()
+	deleted := false
+	tiers := (*s.tables).SponsorTiers[:0]
+	for _, tier := range (*s.tables).SponsorTiers {
+		if tier.Sponsor == sponsor {
+			deleted = true
+			continue
+		}
+		tiers = append(tiers, tier)
+	}
+	(*s.tables).SponsorTiers = tiers
+	return deleted, nil
+}
+
+func (s *MemoryStore) SetSponsorPriority(sponsor string, statuses []common.SwapStatus, priority int64) error {
+	defer s.lock()()
+	for i := range (*s.tables).Swaps {
+		if swap := &(*s.tables).Swaps[i]; swap.Sponsor == sponsor && containsStatus(statuses, swap.Status) {
+			swap.Priority = priority
+		}
+	}
+	return nil
+}
+
+func containsTxStatus(statuses []model.TxStatus, status model.TxStatus) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func containsDirection(directions []common.SwapDirection, direction common.SwapDirection) bool {
+	for _, d := range directions {
+		if d == direction {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsID(ids []uint, id uint) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func containsStatus(statuses []common.SwapStatus, status common.SwapStatus) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func containsPlanStatus(statuses []model.RebalancePlanStatus, status model.RebalancePlanStatus) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func containsPeggedTokenStatus(statuses []model.PeggedTokenStatus, status model.PeggedTokenStatus) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
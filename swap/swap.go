@@ -2,29 +2,34 @@ package swap
 
 import (
 	"context"
+	"crypto/ecdsa"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"math/big"
 	"strings"
 	"time"
 
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	ethcom "github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/jinzhu/gorm"
 
 	sabi "occ-swap-server/abi"
 	"occ-swap-server/common"
+	"occ-swap-server/events"
 	"occ-swap-server/model"
+	"occ-swap-server/price"
 	"occ-swap-server/util"
 )
 
 // NewSwapEngine returns the swapEngine instance
-func NewSwapEngine(db *gorm.DB, cfg *util.Config, bscClient, ethClient, maticClient *ethclient.Client) (*SwapEngine, error) {
+func NewSwapEngine(db *gorm.DB, cfg *util.Config, bscClient, ethClient, maticClient ChainClient) (*SwapEngine, error) {
 	pairs := make([]model.SwapPair, 0)
 	db.Find(&pairs)
 
@@ -39,25 +44,55 @@ func NewSwapEngine(db *gorm.DB, cfg *util.Config, bscClient, ethClient, maticCli
 		ethContractAddrToBscContractAddr[ethcom.HexToAddress(token.ERC20Addr)] = ethcom.HexToAddress(token.BEP20Addr)
 	}
 
-	keyConfig, err := GetKeyConfig(cfg)
-	if err != nil {
+	pausedPairs := make([]model.PausedPair, 0)
+	if err := db.Find(&pausedPairs).Error; err != nil {
 		return nil, err
 	}
-
-	bscPrivateKey, _, err := BuildKeys(keyConfig.BSCPrivateKey)
+	pausedPairAddrs := make(map[ethcom.Address]bool)
+	for _, pausedPair := range pausedPairs {
+		pausedPairAddrs[ethcom.HexToAddress(pausedPair.ERC20Addr)] = true
+	}
+	fillMethods, err := loadFillMethods(db)
 	if err != nil {
 		return nil, err
 	}
 
-	ethPrivateKey, _, err := BuildKeys(keyConfig.ETHPrivateKey)
+	keyConfig, err := GetKeyConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	maticPrivateKey, _, err := BuildKeys(keyConfig.MATICPrivateKey)
-	if err != nil {
-		return nil, err
+	relayerKeys := make(map[string][]*ecdsa.PrivateKey)
+	for _, chain := range []string{common.ChainBSC, common.ChainETH, common.ChainMATIC} {
+		for _, privateKeyStr := range keyConfig.GetRelayerPrivateKeys(chain) {
+			privateKey, _, err := BuildKeys(privateKeyStr)
+			if err != nil {
+				return nil, err
+			}
+			relayerKeys[chain] = append(relayerKeys[chain], privateKey)
+		}
 	}
+	bscPrivateKey := relayerKeys[common.ChainBSC][0]
+	ethPrivateKey := relayerKeys[common.ChainETH][0]
+	maticPrivateKey := relayerKeys[common.ChainMATIC][0]
+
+	// the panics of the daemons are recovered by the scheduler and restarted after the backoff
+	scheduler := util.NewScheduler(cfg.SchedulerConfig)
+	scheduler.OnPanic(func(daemon string, panics int64) {
+		daemonPanicsGauge.WithLabelValues(daemon).Set(float64(panics))
+	})
+	// the calls which hang are failures of the rpc for the breakers
+	bscClient = newTimeoutClient(common.ChainBSC, bscClient, cfg.ChainConfig.GetRPCTimeout(common.ChainBSC))
+	ethClient = newTimeoutClient(common.ChainETH, ethClient, cfg.ChainConfig.GetRPCTimeout(common.ChainETH))
+	maticClient = newTimeoutClient(common.ChainMATIC, maticClient, cfg.ChainConfig.GetRPCTimeout(common.ChainMATIC))
+	breakers := map[string]*circuitBreaker{
+		common.ChainBSC:   newCircuitBreaker(common.ChainBSC, bscClient, cfg.CircuitBreakerConfig),
+		common.ChainETH:   newCircuitBreaker(common.ChainETH, ethClient, cfg.CircuitBreakerConfig),
+		common.ChainMATIC: newCircuitBreaker(common.ChainMATIC, maticClient, cfg.CircuitBreakerConfig),
+	}
+	bscClient = breakers[common.ChainBSC].Client()
+	ethClient = breakers[common.ChainETH].Client()
+	maticClient = breakers[common.ChainMATIC].Client()
 
 	bscChainID, err := bscClient.ChainID(context.Background())
 	if err != nil {
@@ -72,15 +107,35 @@ func NewSwapEngine(db *gorm.DB, cfg *util.Config, bscClient, ethClient, maticCli
 	if err != nil {
 		return nil, err
 	}
+	// the txs of the chains with the gas oracles are priced by the oracles, the rpc is the fallback
+	if cfg.GasOracleConfig.Enabled(common.ChainBSC) {
+		bscClient = newGasOracleClient(common.ChainBSC, bscClient, bscChainID.Int64(), cfg.GasOracleConfig)
+	}
+	if cfg.GasOracleConfig.Enabled(common.ChainETH) {
+		ethClient = newGasOracleClient(common.ChainETH, ethClient, ethChainID.Int64(), cfg.GasOracleConfig)
+	}
+	if cfg.GasOracleConfig.Enabled(common.ChainMATIC) {
+		maticClient = newGasOracleClient(common.ChainMATIC, maticClient, maticChainID.Int64(), cfg.GasOracleConfig)
+	}
 
 	SwapAgentAbi, err := abi.JSON(strings.NewReader(sabi.SwapAgentABI))
 	if err != nil {
 		return nil, err
 	}
 
+	eventDecoders := make(map[string]*events.Decoder)
+	for _, chain := range []string{common.ChainBSC, common.ChainETH, common.ChainMATIC} {
+		eventDecoders[chain], err = events.NewDecoder(chain, cfg.ChainConfig.GetSwapAgentHistoricalAbis(chain))
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	swapEngine := &SwapEngine{
 		db:                     db,
+		store:                  NewGormStore(db),
 		config:                 cfg,
+		profile:                cfg.EnvironmentConfig.GetProfile(),
 		hmacCKey:               keyConfig.HMACKey,
 		ethPrivateKey:          ethPrivateKey,
 		bscPrivateKey:          bscPrivateKey,
@@ -95,67 +150,259 @@ func NewSwapEngine(db *gorm.DB, cfg *util.Config, bscClient, ethClient, maticCli
 		bep20ToERC20:           bscContractAddrToEthContractAddr,
 		erc20ToBEP20:           ethContractAddrToBscContractAddr,
 		swapAgentABI:           &SwapAgentAbi,
+		eventDecoders:          eventDecoders,
+		breakers:               breakers,
+		pausedDirections:       make(map[common.SwapDirection]bool),
+		pausedPairs:            pausedPairAddrs,
+		fillMethods:            fillMethods,
+		liquidity:              make(map[string]*Liquidity),
+		claimedSwaps:           make(map[uint]bool),
+		panickedSwaps:          make(map[uint]bool),
+		instanceID:             getInstanceID(),
 		ethSwapAgent:           ethcom.HexToAddress(cfg.ChainConfig.ETHSwapAgentAddr),
 		bscSwapAgent:           ethcom.HexToAddress(cfg.ChainConfig.BSCSwapAgentAddr),
 		maticSwapAgent:         ethcom.HexToAddress(cfg.ChainConfig.MATICSwapAgentAddr),
+		queue:                  util.NewQueue(cfg.QueueConfig),
+		wakeMonitor:            make(chan struct{}, 1),
+		scheduler:              scheduler,
+		wakeConfirm:            make(chan struct{}, 1),
+		wakeFill: map[string]chan struct{}{
+			common.ChainBSC:   make(chan struct{}, 1),
+			common.ChainETH:   make(chan struct{}, 1),
+			common.ChainMATIC: make(chan struct{}, 1),
+		},
+	}
+	swapEngine.routes, err = buildRoutingTable(swapEngine.profile, map[string]ethcom.Address{
+		common.ChainBSC:   swapEngine.bscSwapAgent,
+		common.ChainETH:   swapEngine.ethSwapAgent,
+		common.ChainMATIC: swapEngine.maticSwapAgent,
+	})
+	if err != nil {
+		return nil, err
+	}
+	swapEngine.prices = price.NewOracle(cfg.PriceConfig, func(chain string) ethereum.ContractCaller {
+		return swapEngine.getClient(chain)
+	})
+	swapEngine.heads = map[string]*headTracker{
+		common.ChainBSC:   newHeadTracker(common.ChainBSC, bscClient, cfg.ChainConfig.GetTrackTxInterval(common.ChainBSC)),
+		common.ChainETH:   newHeadTracker(common.ChainETH, ethClient, cfg.ChainConfig.GetTrackTxInterval(common.ChainETH)),
+		common.ChainMATIC: newHeadTracker(common.ChainMATIC, maticClient, cfg.ChainConfig.GetTrackTxInterval(common.ChainMATIC)),
+	}
+	swapEngine.headerCaches, err = newQuorumHeaderCaches(cfg.HeaderVerificationConfig,
+		[]string{common.ChainBSC, common.ChainETH, common.ChainMATIC})
+	if err != nil {
+		return nil, err
+	}
+	swapEngine.rpcQuorums, err = newRPCQuorums(cfg.RPCQuorumConfig, []string{common.ChainBSC, common.ChainETH, common.ChainMATIC})
+	if err != nil {
+		return nil, err
+	}
+	swapEngine.relayerPools = map[string]*RelayerPool{
+		common.ChainBSC: NewRelayerPool(common.ChainBSC, bscClient, relayerKeys[common.ChainBSC], bscChainID,
+			cfg.ChainConfig.GetAlertThreshold(common.ChainBSC), cfg.GetExplorerTemplates(common.ChainBSC),
+			cfg.ChainConfig.GetMaxInFlightTxs(common.ChainBSC), cfg.ChainConfig.GetRebroadcastTimeout(common.ChainBSC),
+			cfg.ChainConfig.GetGasLimitPolicy(common.ChainBSC)),
+		common.ChainETH: NewRelayerPool(common.ChainETH, ethClient, relayerKeys[common.ChainETH], ethChainID,
+			cfg.ChainConfig.GetAlertThreshold(common.ChainETH), cfg.GetExplorerTemplates(common.ChainETH),
+			cfg.ChainConfig.GetMaxInFlightTxs(common.ChainETH), cfg.ChainConfig.GetRebroadcastTimeout(common.ChainETH),
+			cfg.ChainConfig.GetGasLimitPolicy(common.ChainETH)),
+		common.ChainMATIC: NewRelayerPool(common.ChainMATIC, maticClient, relayerKeys[common.ChainMATIC], maticChainID,
+			cfg.ChainConfig.GetAlertThreshold(common.ChainMATIC), cfg.GetExplorerTemplates(common.ChainMATIC),
+			cfg.ChainConfig.GetMaxInFlightTxs(common.ChainMATIC), cfg.ChainConfig.GetRebroadcastTimeout(common.ChainMATIC),
+			cfg.ChainConfig.GetGasLimitPolicy(common.ChainMATIC)),
+	}
+	swapEngine.drainBrake = newDrainBrake(cfg.DrainBrakeConfig, swapEngine.store)
+	swapEngine.withdrawals = make(map[string]*withdrawalWindow)
+	swapEngine.gasGuards = make(map[string]*GasGuardState)
+	for chain, pool := range swapEngine.relayerPools {
+		chain := chain
+		pool.OnSent(func(account ethcom.Address, tx *types.Transaction) {
+			swapEngine.drainBrake.recordTx(chain, account, tx)
+		})
+		pool.OnSign(func(use KeyUse, account ethcom.Address, tx *types.Transaction) error {
+			return swapEngine.recordKeyUsage(chain, account, use, tx)
+		})
+		pool.OnReserveNonce(func(account ethcom.Address, nonce uint64) (bool, error) {
+			return swapEngine.store.ReserveRelayerNonce(chain, account.String(), nonce, swapEngine.instanceID)
+		})
 	}
 
 	return swapEngine, nil
 }
 
+// SetStore replaces the storage of the swap lifecycle daemons, it is called before Start
+func (engine *SwapEngine) SetStore(store SwapStore) {
+	engine.store = store
+	if engine.drainBrake != nil {
+		engine.drainBrake.store = store
+	}
+}
+
 func (engine *SwapEngine) Start() {
-	go engine.monitorSwapRequestDaemon()
-	go engine.confirmSwapRequestDaemon()
-	go engine.swapInstanceDaemon(SwapEth2BSC, SwapMATIC2BSC)
-	go engine.swapInstanceDaemon(SwapBSC2Eth, SwapMATIC2Eth)
-	go engine.swapInstanceDaemon(SwapBSC2MATIC, SwapEth2MATIC)
-	go engine.trackSwapTxDaemon()
-	go engine.retryFailedSwapsDaemon()
-	go engine.trackRetrySwapTxDaemon()
-}
-
-func (engine *SwapEngine) monitorSwapRequestDaemon() {
-	for {
-		// fmt.Printf("monitorSwapRequestDaemon start 0\n")
-		swapStartTxLogs := make([]model.SwapStartTxLog, 0)
-		engine.db.Where("phase = ?", model.SeenRequest).Order("height asc").Limit(BatchSize).Find(&swapStartTxLogs)
-
-		if len(swapStartTxLogs) == 0 {
-			time.Sleep(SleepTime * time.Second)
+	// the rows written before the addresses were validated are repaired before the daemons read them
+	engine.repairAddresses()
+	engine.normalizeEnums()
+	engine.linkFillTxs()
+	engine.fillAmountDecimals()
+	for _, breaker := range engine.breakers {
+		breaker.Start(engine.scheduler)
+	}
+	for _, head := range engine.heads {
+		head.Start(engine.scheduler)
+	}
+	for _, headers := range engine.headerCaches {
+		headers.Start(engine.scheduler)
+	}
+	// the broadcasters follow the pending nonces, which are only right once the nonce gaps are filled
+	engine.reconcileRelayerNonces()
+	for _, pool := range engine.relayerPools {
+		pool.Start(engine.scheduler, engine.getBalanceMonitorInterval())
+	}
+	chainCfg := engine.config.ChainConfig
+	engine.scheduler.Go(util.Daemon{Name: "monitor_swap_request", Interval: chainCfg.GetMonitorSwapRequestInterval(),
+		Wait: engine.waitForWorkOf(engine.wakeMonitor), Run: engine.monitorSwapRequestDaemon})
+	engine.scheduler.Go(util.Daemon{Name: "confirm_swap_request", Interval: chainCfg.GetConfirmSwapRequestInterval(),
+		Wait: engine.waitForWorkOf(engine.wakeConfirm), Run: engine.confirmSwapRequestDaemon})
+	engine.scheduler.Go(util.Daemon{Name: "release_delayed_swaps", Interval: chainCfg.GetConfirmSwapRequestInterval(),
+		WaitFirst: true, Run: engine.releaseDelayedSwapsDaemon})
+	engine.startSwapDaemon(common.ChainBSC)
+	engine.startSwapDaemon(common.ChainETH)
+	engine.startSwapDaemon(common.ChainMATIC)
+	engine.trackSwapTxDaemon()
+	engine.trackDroppedSwapTxDaemon()
+	engine.scheduler.Go(util.Daemon{Name: "track_liquidity", Interval: engine.getBalanceMonitorInterval(),
+		Run: engine.trackLiquidityDaemon()})
+	engine.scheduler.Go(util.Daemon{Name: "retry_failed_swaps", Interval: chainCfg.GetRetryFailedSwapInterval(),
+		Run: engine.retryFailedSwapsDaemon})
+	engine.scheduler.Go(util.Daemon{Name: "auto_retry_failed_swaps", Interval: chainCfg.GetRetryFailedSwapInterval(),
+		WaitFirst: true, Run: engine.autoRetryFailedSwapsDaemon})
+	engine.trackRetrySwapTxDaemon()
+	engine.scheduler.Go(util.Daemon{Name: "watchdog", Interval: chainCfg.GetWatchdogInterval(), WaitFirst: true,
+		Run: engine.watchdogDaemon()})
+	engine.scheduler.Go(util.Daemon{Name: "swap_proof", Interval: chainCfg.GetSwapProofInterval(), WaitFirst: true,
+		Run: engine.swapProofDaemon()})
+	if engine.config.AnomalyConfig.Enabled() {
+		engine.scheduler.Go(util.Daemon{Name: "anomaly_detector", Interval: engine.config.AnomalyConfig.GetInterval(),
+			WaitFirst: true, Run: engine.anomalyDetectorDaemon})
+	}
+	engine.scheduler.Go(util.Daemon{Name: "latency_stats", Interval: chainCfg.GetLatencyStatsInterval(),
+		Run: engine.latencyStatsDaemon})
+	engine.scheduler.Go(util.Daemon{Name: "queue_age", Interval: engine.config.QueueAgeConfig.GetInterval(),
+		Run: engine.queueAgeDaemon()})
+	if engine.config.EventExportConfig.Enabled() {
+		engine.scheduler.Go(util.Daemon{Name: "event_export", Interval: engine.config.EventExportConfig.GetInterval(),
+			Run: engine.eventExportDaemon()})
+	}
+	engine.scheduler.Go(util.Daemon{Name: "rollups", Interval: engine.config.RollupConfig.GetInterval(),
+		Run: engine.rollupDaemon})
+	engine.scheduler.Go(util.Daemon{Name: "webhook_delivery", Interval: engine.config.WebhookConfig.GetInterval(),
+		Run: engine.webhookDeliveryDaemon()})
+	if engine.queue != nil {
+		engine.scheduler.Go(util.Daemon{Name: "consume_swap_events", Interval: chainCfg.GetConfirmSwapRequestInterval(),
+			Run: engine.consumeSwapEventsDaemon})
+	}
+	if engine.config.ArchiveConfig.Enabled() {
+		engine.scheduler.Go(util.Daemon{Name: "archive_swaps", Interval: engine.config.ArchiveConfig.GetInterval(),
+			Run: engine.archiveSwapsDaemon})
+	}
+	if engine.config.RelayConfig.Enabled() {
+		engine.scheduler.Go(util.Daemon{Name: "relay_swaps", Interval: engine.config.RelayConfig.GetInterval(),
+			Run: engine.relaySwapsDaemon})
+	}
+	if engine.config.IntegrityConfig.Enabled() {
+		engine.scheduler.Go(util.Daemon{Name: "integrity_sweep", Interval: engine.config.IntegrityConfig.GetInterval(),
+			WaitFirst: true, Run: engine.integritySweepDaemon})
+	}
+	if engine.config.ExpiryConfig.Enabled() {
+		engine.scheduler.Go(util.Daemon{Name: "expire_swaps", Interval: engine.config.ExpiryConfig.GetInterval(),
+			Run: engine.expireSwapsDaemon})
+	}
+	if engine.config.PeggedTokenConfig.Enabled() {
+		engine.scheduler.Go(util.Daemon{Name: "deploy_pegged_tokens", Interval: engine.config.PeggedTokenConfig.GetInterval(),
+			Run: engine.deployPeggedTokensDaemon})
+	}
+	if engine.config.RelayerTokenConfig.Enabled() {
+		engine.scheduler.Go(util.Daemon{Name: "relayer_tokens", Interval: engine.config.RelayerTokenConfig.GetInterval(),
+			Run: engine.relayerTokensDaemon()})
+	}
+	if engine.config.WithdrawalLimitConfig.Enabled() {
+		engine.scheduler.Go(util.Daemon{Name: "withdrawal_limit", Interval: engine.config.WithdrawalLimitConfig.GetInterval(),
+			Run: engine.withdrawalLimitDaemon})
+	}
+	if engine.config.GasGuardConfig.Enabled() {
+		engine.scheduler.Go(util.Daemon{Name: "gas_guard", Interval: engine.config.GasGuardConfig.GetInterval(),
+			Run: engine.gasGuardDaemon})
+	}
+	if engine.config.RebalanceConfig.Enabled() {
+		engine.scheduler.Go(util.Daemon{Name: "rebalance", Interval: engine.config.RebalanceConfig.GetInterval(),
+			Run: engine.rebalanceDaemon})
+	}
+}
+
+// GetDaemonStatus returns the last runs and errors of the daemons of the engine
+func (engine *SwapEngine) GetDaemonStatus() []util.DaemonStatus {
+	return engine.scheduler.Status()
+}
+
+// monitorSwapRequestDaemon creates the swaps of a batch of the seen swap start txs, it is run again at once while
+// there are more
+func (engine *SwapEngine) monitorSwapRequestDaemon() error {
+	// fmt.Printf("monitorSwapRequestDaemon start 0\n")
+	swapStartTxLogs, err := engine.store.FindStartTxLogs(model.SeenRequest, BatchSize)
+	if err != nil {
+		return err
+	}
+
+	if len(swapStartTxLogs) == 0 {
+		return nil
+	}
+	fmt.Printf("monitorSwapRequestDaemon start 1\n")
+	for _, swapEventLog := range swapStartTxLogs {
+		swap, err := engine.createSwap(&swapEventLog)
+		if err != nil {
+			// the log is left for the next round
+			util.Logger.Errorf("create swap of %s error: %s", swapEventLog.TxHash, err.Error())
 			continue
 		}
-		fmt.Printf("monitorSwapRequestDaemon start 1\n")
-		for _, swapEventLog := range swapStartTxLogs {
-			swap := engine.createSwap(&swapEventLog)
-			writeDBErr := func() error {
-				tx := engine.db.Begin()
-				if err := tx.Error; err != nil {
-					return err
-				}
-				if err := engine.insertSwap(tx, swap); err != nil {
-					tx.Rollback()
-					return err
-				}
-				tx.Model(model.SwapStartTxLog{}).Where("tx_hash = ?", swap.StartTxHash).Updates(
-					map[string]interface{}{
-						"phase":       model.ConfirmRequest,
-						"update_time": time.Now().Unix(),
-					})
-				return tx.Commit().Error
-			}()
-
-			if writeDBErr != nil {
-				util.Logger.Errorf("write db error: %s", writeDBErr.Error())
-				util.SendTelegramMessage(fmt.Sprintf("write db error: %s", writeDBErr.Error()))
+		writeDBErr := engine.store.Transaction(func(tx SwapStore) error {
+			// the log may be handled by another executor replica meanwhile
+			if !tx.LockStartTxLog(swapEventLog.Id, model.SeenRequest) {
+				return nil
+			}
+			if err := engine.insertSwap(tx, swap); err != nil {
+				return err
 			}
+			return tx.SetStartTxLogsPhase(swap.StartTxHash, model.ConfirmRequest)
+		})
+
+		if writeDBErr != nil {
+			util.Logger.Errorf("write db error: %s", writeDBErr.Error())
+			util.SendTelegramMessage(fmt.Sprintf("write db error: %s", writeDBErr.Error()))
 		}
-		fmt.Printf("monitorSwapRequestDaemon start 2\n")
 	}
+	fmt.Printf("monitorSwapRequestDaemon start 2\n")
+	// the swaps created may be confirmed already
+	notify(engine.wakeConfirm)
+	return util.RunAgain
 }
 
 func (engine *SwapEngine) getSwapHMAC(swap *model.Swap) string {
 	material := fmt.Sprintf("%s#%s#%s#%s#%s#%s#%d#%s#%s#%s",
 		swap.Status, swap.Sponsor, swap.BEP20Addr, swap.ERC20Addr, swap.Symbol, swap.Amount, swap.Decimals, swap.Direction, swap.StartTxHash, swap.FillTxHash)
+	// the material of the fungible swaps is kept, so their record hashes stay valid
+	if isNFTSwap(swap.AssetType) {
+		material = fmt.Sprintf("%s#%s#%s", material, swap.AssetType, swap.TokenId)
+	}
+	// and so are those of the swaps paying the sponsor without a memo
+	if swap.Memo != "" {
+		material = fmt.Sprintf("%s#%s#%s", material, swap.Recipient, swap.Memo)
+	} else if swap.Recipient != "" {
+		material = fmt.Sprintf("%s#%s", material, swap.Recipient)
+	}
+	// the imported swaps can't be passed off as the swaps of this deployment
+	if swap.ImportedFrom != "" {
+		material = fmt.Sprintf("%s#%s", material, swap.ImportedFrom)
+	}
 	mac := hmac.New(sha256.New, []byte(engine.hmacCKey))
 	mac.Write([]byte(material))
 
@@ -166,42 +413,80 @@ func (engine *SwapEngine) verifySwap(swap *model.Swap) bool {
 	return swap.RecordHash == engine.getSwapHMAC(swap)
 }
 
-func (engine *SwapEngine) insertSwap(tx *gorm.DB, swap *model.Swap) error {
+// VerifySwap checks the record hash of the swap, it is false if the swap is not written by the engine
+func (engine *SwapEngine) VerifySwap(swap *model.Swap) bool {
+	return engine.verifySwap(swap)
+}
+
+func (engine *SwapEngine) insertSwap(tx SwapStore, swap *model.Swap) error {
+	if err := swap.Normalize(); err != nil {
+		return err
+	}
 	swap.RecordHash = engine.getSwapHMAC(swap)
-	return tx.Create(swap).Error
+	if err := tx.CreateSwap(swap); err != nil {
+		return err
+	}
+	return engine.recordBridgeEvent(tx, swap, "")
 }
 
-func (engine *SwapEngine) updateSwap(tx *gorm.DB, swap *model.Swap) {
+// updateSwap saves the swap with its new record hash, the webhooks are queued in the same tx once the swap
+// reaches one of the webhookStatuses, and the bridge event is recorded once its status changes. Any error fails the
+// tx of the caller, so the swap is never saved without them.
+func (engine *SwapEngine) updateSwap(tx SwapStore, swap *model.Swap) error {
+	// a swap of an unknown status is never picked up again, it is left in its previous one
+	if err := swap.CheckEnums(); err != nil {
+		util.Logger.Errorf("save swap error, start tx hash %s, err: %s", swap.StartTxHash, err.Error())
+		util.SendTelegramMessage(fmt.Sprintf("Urgent alert: save swap error, start tx hash %s, err: %s", swap.StartTxHash, err.Error()))
+		return err
+	}
 	swap.RecordHash = engine.getSwapHMAC(swap)
-	tx.Save(swap)
+	exported := engine.config.EventExportConfig.Enabled()
+	statusChanged := false
+	var previousStatus common.SwapStatus
+	if exported || isWebhookStatus(swap.Status) {
+		previous, err := tx.GetSwap(swap.ID)
+		if err != nil {
+			return err
+		}
+		previousStatus = previous.Status
+		statusChanged = previousStatus != swap.Status
+	}
+	if err := tx.SaveSwap(swap); err != nil {
+		return err
+	}
+	// the deliveries and the events are written in the tx of the swap, they are lost with it otherwise
+	if statusChanged && isWebhookStatus(swap.Status) {
+		if err := engine.queueWebhookDeliveries(tx, swap); err != nil {
+			return fmt.Errorf("queue webhook deliveries error: %s", err.Error())
+		}
+	}
+	if statusChanged && exported {
+		if err := engine.recordBridgeEvent(tx, swap, previousStatus); err != nil {
+			return fmt.Errorf("record bridge event error: %s", err.Error())
+		}
+	}
+	return nil
 }
 
-func (engine *SwapEngine) createSwap(txEventLog *model.SwapStartTxLog) *model.Swap {
+// createSwap returns the swap of the start tx log, it is an error if the relayed swap of the tx can't be queried,
+// the swap would be filled to the relayer otherwise
+func (engine *SwapEngine) createSwap(txEventLog *model.SwapStartTxLog) (*model.Swap, error) {
 	sponsor := txEventLog.FromAddress
+	// the relayer starts the relayed swaps, they are filled to the owners of the permits
+	if relayedSwap, err := engine.store.GetRelayedSwap(txEventLog.Chain, txEventLog.TxHash); err != nil {
+		return nil, fmt.Errorf("query relayed swap of %s error: %s", txEventLog.TxHash, err.Error())
+	} else if relayedSwap != nil {
+		sponsor = relayedSwap.Owner
+	}
+	// the recipient is only kept if it is not the sponsor, so the swaps paying the sponsor keep their record hashes
+	recipient := txEventLog.Recipient
+	if recipient != "" && ethcom.HexToAddress(recipient) == ethcom.HexToAddress(sponsor) {
+		recipient = ""
+	}
 	amount := txEventLog.Amount
 	toChainId := txEventLog.ToChainId
 	swapStartTxHash := txEventLog.TxHash
-	swapDirection := SwapBSC2Eth
-
-	if txEventLog.Chain == common.ChainBSC {
-		if toChainId == "1" || toChainId == "4" {
-			swapDirection = SwapBSC2Eth
-		} else {
-			swapDirection = SwapBSC2MATIC
-		}
-	} else if txEventLog.Chain == common.ChainETH {
-		if toChainId == "56" || toChainId == "97" {
-			swapDirection = SwapEth2BSC
-		} else {
-			swapDirection = SwapEth2MATIC
-		}
-	} else if txEventLog.Chain == common.ChainMATIC {
-		if toChainId == "56" || toChainId == "97" {
-			swapDirection = SwapMATIC2BSC
-		} else {
-			swapDirection = SwapMATIC2Eth
-		}
-	}
+	swapDirection, directionErr := engine.getSwapDirection(txEventLog.Chain, toChainId)
 
 	fmt.Printf("createSwap(1): %s\n", sponsor)
 
@@ -210,13 +495,46 @@ func (engine *SwapEngine) createSwap(txEventLog *model.SwapStartTxLog) *model.Sw
 	var ok bool
 	decimals := 0
 	var symbol string
+	assetType := common.AssetTypeFungible
+	if txEventLog.TokenId != "" {
+		assetType = common.AssetTypeERC721
+	}
 	swapStatus := SwapQuoteRejected
 	err := func() error {
+		if directionErr != nil {
+			return directionErr
+		}
+		var pair *SwapPairIns
+		var err error
+		if isNFTSwap(assetType) {
+			pair, err = engine.getNFTPair(txEventLog.Chain, ethcom.HexToAddress(txEventLog.TokenAddr))
+			if err != nil {
+				return err
+			}
+			bep20Addr, erc20Addr, symbol = pair.BEP20Addr, pair.ERC20Addr, pair.Symbol
+		} else {
+			pair, err = engine.getFungiblePair(txEventLog.Chain, txEventLog.TokenAddr)
+			if err != nil {
+				return err
+			}
+			bep20Addr, erc20Addr, symbol, decimals = pair.BEP20Addr, pair.ERC20Addr, pair.Symbol, pair.Decimals
+		}
+		// the rejected swaps of a disabled pair still record its metadata
+		if !pair.Available {
+			return fmt.Errorf("swap pair %s is disabled", pair.Symbol)
+		}
 		swapAmount := big.NewInt(0)
 		_, ok = swapAmount.SetString(txEventLog.Amount, 10)
 		if !ok {
 			return fmt.Errorf("unrecongnized swap amount: %s", txEventLog.Amount)
 		}
+		payee := sponsor
+		if recipient != "" {
+			payee = recipient
+		}
+		if err := engine.checkRecipient(getDestChain(swapDirection), payee); err != nil {
+			return err
+		}
 
 		swapStatus = SwapTokenReceived
 		return nil
@@ -232,222 +550,381 @@ func (engine *SwapEngine) createSwap(txEventLog *model.SwapStartTxLog) *model.Sw
 	swap := &model.Swap{
 		Status:      swapStatus,
 		Sponsor:     sponsor,
+		Recipient:   recipient,
+		Memo:        txEventLog.Memo,
 		ToChainId:   toChainId,
-		BEP20Addr:   bep20Addr.String(),
-		ERC20Addr:   erc20Addr.String(),
+		BEP20Addr:   model.OptionalAddress(bep20Addr),
+		ERC20Addr:   model.OptionalAddress(erc20Addr),
 		Symbol:      symbol,
 		Amount:      amount,
 		Decimals:    decimals,
 		Direction:   swapDirection,
+		AssetType:   assetType,
+		TokenId:     txEventLog.TokenId,
 		StartTxHash: swapStartTxHash,
 		FillTxHash:  "",
 		Log:         log,
 	}
+	// the rejected swaps may have an invalid amount, they are left without the decimal amount
+	swap.AmountDecimal, _ = model.FormatRawAmount(amount, decimals)
+	engine.applySponsorTier(swap, txEventLog)
 
-	return swap
+	return swap, nil
 }
 
-func (engine *SwapEngine) confirmSwapRequestDaemon() {
-	for {
-		txEventLogs := make([]model.SwapStartTxLog, 0)
-		engine.db.Where("status = ? and phase = ?", model.TxStatusConfirmed, model.ConfirmRequest).
-			Order("height asc").Limit(BatchSize).Find(&txEventLogs)
+// confirmSwapRequestDaemon verifies a batch of the confirmed swap start txs and confirms their swaps, it is run again
+// at once while there are more, unless some of them can't be verified yet
+func (engine *SwapEngine) confirmSwapRequestDaemon() error {
+	txEventLogs, err := engine.store.FindStartTxLogs(model.ConfirmRequest, BatchSize, model.TxStatusConfirmed)
+	if err != nil {
+		return err
+	}
 
-		if len(txEventLogs) == 0 {
-			time.Sleep(SleepTime * time.Second)
+	if len(txEventLogs) == 0 {
+		return nil
+	}
+
+	util.Logger.Debugf("found %d confirmed event logs", len(txEventLogs))
+
+	verifyFailed := false
+	for _, txEventLog := range txEventLogs {
+		if engine.isChainDegraded(txEventLog.Chain) {
+			// the receipt is verified once the rpc of the source chain is back
+			verifyFailed = true
 			continue
 		}
+		rejectReason, verifyErr := engine.verifySwapStartEvent(&txEventLog)
+		if errors.Is(verifyErr, errSwapStartNotFinal) {
+			// the head of the engine is behind the observer, or the tx is reorged to a later block
+			util.Logger.Infof("wait for the finality of swap start tx, chain %s, %s", txEventLog.Chain, verifyErr.Error())
+			verifyFailed = true
+			continue
+		}
+		if verifyErr != nil {
+			// the receipt can't be fetched right now, verify it again later
+			util.Logger.Errorf("verify swap start event error, chain %s, tx hash %s, err: %s", txEventLog.Chain, txEventLog.TxHash, verifyErr.Error())
+			verifyFailed = true
+			continue
+		}
+		if rejectReason != "" {
+			util.Logger.Errorf("reject swap, %s", rejectReason)
+			util.SendTelegramMessage(fmt.Sprintf("Urgent alert: reject swap, %s", rejectReason))
+		}
 
-		util.Logger.Debugf("found %d confirmed event logs", len(txEventLogs))
-
-		for _, txEventLog := range txEventLogs {
-			writeDBErr := func() error {
-				tx := engine.db.Begin()
-				if err := tx.Error; err != nil {
+		writeDBErr := engine.store.Transaction(func(tx SwapStore) error {
+			if !tx.LockStartTxLog(txEventLog.Id, model.ConfirmRequest) {
+				return nil
+			}
+			fmt.Printf("confirmSwapRequestDaemon start 0\n")
+			swap, err := engine.getSwapByStartTxHash(tx, txEventLog.TxHash)
+			if err != nil {
+				util.Logger.Errorf("verify hmac of swap failed: %s", txEventLog.TxHash)
+				util.SendTelegramMessage(fmt.Sprintf("Urgent alert: verify hmac of swap failed: %s", txEventLog.TxHash))
+				return err
+			}
+			fmt.Printf("confirmSwapRequestDaemon start 1\n")
+			if rejectReason != "" {
+				swap.Status = SwapRejected
+				swap.Log = rejectReason
+				if err := engine.updateSwap(tx, swap); err != nil {
 					return err
 				}
-				fmt.Printf("confirmSwapRequestDaemon start 0\n")
-				swap, err := engine.getSwapByStartTxHash(tx, txEventLog.TxHash)
-				if err != nil {
-					util.Logger.Errorf("verify hmac of swap failed: %s", txEventLog.TxHash)
-					util.SendTelegramMessage(fmt.Sprintf("Urgent alert: verify hmac of swap failed: %s", txEventLog.TxHash))
-					return err
+			} else if swap.Status == SwapTokenReceived {
+				swap.Status = SwapConfirmed
+				if delay := engine.getSwapDelay(swap); delay > 0 {
+					swap.Status = SwapDelayed
+					swap.DelayedUntil = time.Now().Add(delay).Unix()
+					swap.Log = fmt.Sprintf("large swap is delayed for %s", delay.String())
+					util.Logger.Infof("delay swap for %s, start tx hash %s, direction %s, amount %s", delay.String(), swap.StartTxHash, swap.Direction, swap.Amount)
+					util.SendTelegramMessage(fmt.Sprintf("large swap is delayed for %s, start tx hash %s, direction %s, amount %s", delay.String(), swap.StartTxHash, swap.Direction, swap.Amount))
 				}
-				fmt.Printf("confirmSwapRequestDaemon start 1\n")
-				if swap.Status == SwapTokenReceived {
-					swap.Status = SwapConfirmed
-					engine.updateSwap(tx, swap)
-					fmt.Printf("confirmSwapRequestDaemon start 11\n")
+				if err := engine.updateSwap(tx, swap); err != nil {
+					return err
 				}
-				fmt.Printf("confirmSwapRequestDaemon start 2\n")
-				tx.Model(model.SwapStartTxLog{}).Where("id = ?", txEventLog.Id).Updates(
-					map[string]interface{}{
-						"phase":       model.AckRequest,
-						"update_time": time.Now().Unix(),
-					})
-				return tx.Commit().Error
-			}()
-			fmt.Printf("confirmSwapRequestDaemon start 3\n")
-			if writeDBErr != nil {
-				util.Logger.Errorf("write db error: %s", writeDBErr.Error())
-				util.SendTelegramMessage(fmt.Sprintf("write db error: %s", writeDBErr.Error()))
+				fmt.Printf("confirmSwapRequestDaemon start 11\n")
 			}
-			fmt.Printf("confirmSwapRequestDaemon start final\n")
+			fmt.Printf("confirmSwapRequestDaemon start 2\n")
+			return tx.SetStartTxLogPhase(txEventLog.Id, model.AckRequest)
+		})
+		fmt.Printf("confirmSwapRequestDaemon start 3\n")
+		if writeDBErr != nil {
+			util.Logger.Errorf("write db error: %s", writeDBErr.Error())
+			util.SendTelegramMessage(fmt.Sprintf("write db error: %s", writeDBErr.Error()))
+		} else if rejectReason == "" {
+			engine.wakeFillDaemon(&txEventLog)
 		}
+		fmt.Printf("confirmSwapRequestDaemon start final\n")
 	}
+	if verifyFailed {
+		return nil
+	}
+	return util.RunAgain
 }
 
-func (engine *SwapEngine) swapInstanceDaemon(direction1, direction2 common.SwapDirection) {
-	util.Logger.Infof("start swap daemon, direction %s", direction1, direction2)
-	for {
+// startSwapDaemon starts the daemon sending the fill txs of all the swaps whose destination is the given chain
+func (engine *SwapEngine) startSwapDaemon(destChain string) {
+	util.Logger.Infof("start swap daemon, destination chain %s, directions %v", destChain, getDirectionsToChain(destChain))
+	run := func() error { return engine.swapInstanceDaemon(destChain) }
+	if workers := engine.config.ChainConfig.GetSwapWorkers(destChain); workers > 1 {
+		run = engine.swapWorkerPool(destChain, workers)
+	}
+	engine.scheduler.Go(util.Daemon{
+		Name:     "swap_" + destChain,
+		Interval: engine.config.ChainConfig.GetSwapDaemonInterval(destChain),
+		Wait:     engine.waitForWorkOf(engine.wakeFill[destChain]),
+		Run:      run,
+	})
+}
 
-		swaps := make([]model.Swap, 0)
-		engine.db.Where("status in (?) and (direction = ? or direction = ?)", []common.SwapStatus{SwapConfirmed, SwapSending}, direction1, direction2).Order("id asc").Limit(BatchSize).Find(&swaps)
-		if len(swaps) == 0 {
-			time.Sleep(SwapSleepSecond * time.Second)
-			continue
-		}
+// swapInstanceDaemon fills a batch of the fillable swaps to the destination chain, it is run again at once while
+// there are more
+func (engine *SwapEngine) swapInstanceDaemon(destChain string) error {
+	swaps := engine.getFillableSwaps(destChain)
+	if len(swaps) == 0 {
+		return nil
+	}
 
-		util.Logger.Debugf("found %d confirmed swap requests", len(swaps))
+	util.Logger.Debugf("found %d confirmed swap requests", len(swaps))
 
-		for _, swap := range swaps {
-			var swapPairInstance *SwapPairIns
-			// var err error
-			retryCheckErr := func() error {
-				if !engine.verifySwap(&swap) {
-					return fmt.Errorf("verify hmac of swap failed: %s", swap.StartTxHash)
-				}
-				fmt.Printf("swapInstanceDaemon start 1\n")
-				return nil
-			}()
-			if retryCheckErr != nil {
-				writeDBErr := func() error {
-					tx := engine.db.Begin()
-					if err := tx.Error; err != nil {
-						return err
-					}
-					swap.Status = SwapQuoteRejected
-					swap.Log = retryCheckErr.Error()
-					engine.updateSwap(tx, &swap)
-					return tx.Commit().Error
-				}()
-				if writeDBErr != nil {
-					util.Logger.Errorf("write db error: %s", writeDBErr.Error())
-					util.SendTelegramMessage(fmt.Sprintf("write db error: %s", writeDBErr.Error()))
-				}
-				continue
+	for _, swap := range swaps {
+		engine.fillSwapInstance(destChain, swap)
+		time.Sleep(engine.config.ChainConfig.GetWaitBetweenSwaps(destChain))
+	}
+	fmt.Printf("swapInstanceDaemon start final\n")
+	return util.RunAgain
+}
+
+// getFillableSwaps returns the confirmed swaps of the active directions to the given chain by the priority of the
+// sponsor tiers then by id, the swaps of the paused pairs are skipped. The swaps are claimed in the same tx, the swaps claimed by the other executor replicas
+// or locked by their txs are skipped, so a swap is never filled by two replicas.
+func (engine *SwapEngine) getFillableSwaps(destChain string) []model.Swap {
+	swaps := make([]model.Swap, 0)
+	directions := engine.getActiveDirections(destChain)
+	if len(directions) == 0 || engine.isChainDegraded(destChain) || engine.drainBrake.IsHalted(destChain) {
+		return swaps
+	}
+	query := FillableSwapQuery{
+		Directions:    directions,
+		ExcludedPairs: engine.getPausedPairs(),
+		ExcludedIDs:   engine.getPanickedSwaps(),
+		InstanceID:    engine.instanceID,
+		Limit:         BatchSize,
+	}
+	query.Cutoff, query.Expires = engine.getExpiryCutoff()
+	// the swaps are saved as a whole while they are filled, which must keep the claims
+	err := engine.store.Transaction(func(tx SwapStore) error {
+		var err error
+		swaps, err = tx.ClaimFillableSwaps(query, time.Now().Add(SwapClaimLease).Unix())
+		return err
+	})
+	if err != nil {
+		util.Logger.Errorf("claim fillable swaps to %s error: %s", destChain, err.Error())
+		return make([]model.Swap, 0)
+	}
+	return swaps
+}
+
+// fillSwapInstance sends the fill tx of the swap and records the result
+func (engine *SwapEngine) fillSwapInstance(destChain string, swap model.Swap) {
+	if !engine.claimSwap(swap.ID) {
+		util.Logger.Debugf("swap is being healed, skip it, start tx hash %s", swap.StartTxHash)
+		return
+	}
+	defer engine.releaseSwap(swap.ID)
+	defer engine.store.ReleaseSwapClaim(swap.ID, engine.instanceID)
+	defer engine.skipPanickedSwap(swap)
+
+	var swapPairInstance *SwapPairIns
+	// var err error
+	retryCheckErr := func() error {
+		if !engine.verifySwap(&swap) {
+			return fmt.Errorf("verify hmac of swap failed: %s", swap.StartTxHash)
+		}
+		fmt.Printf("swapInstanceDaemon start 1\n")
+		return nil
+	}()
+	if retryCheckErr != nil {
+		util.Logger.Errorf("reject swap, %s", retryCheckErr.Error())
+		util.SendTelegramMessage(fmt.Sprintf("Urgent alert: reject swap, %s", retryCheckErr.Error()))
+		writeDBErr := engine.store.Transaction(func(tx SwapStore) error {
+			swap.Status = SwapRejected
+			swap.Log = retryCheckErr.Error()
+			return engine.updateSwap(tx, &swap)
+		})
+		if writeDBErr != nil {
+			util.Logger.Errorf("write db error: %s", writeDBErr.Error())
+			util.SendTelegramMessage(fmt.Sprintf("write db error: %s", writeDBErr.Error()))
+		}
+		return
+	}
+	if swap.Status == SwapConfirmed && engine.holdUneconomicSwap(destChain, &swap) {
+		return
+	}
+	fmt.Printf("swapInstanceDaemon start 2\n")
+	isSkip := false
+	writeDBErr := engine.store.Transaction(func(tx SwapStore) error {
+		// another replica may have claimed the swap while the batch was filled, the status is only changed under the
+		// claim of this one
+		if renewed, err := engine.renewSwapClaim(tx, &swap); err != nil {
+			return err
+		} else if !renewed {
+			util.Logger.Infof("claim of swap is lost, skip it, start tx hash %s", swap.StartTxHash)
+			isSkip = true
+			return nil
+		}
+		if swap.Status == SwapSending {
+			swapTx, err := tx.GetFillTxOfSwap(swap.ID)
+			if err != nil {
+				return err
 			}
-			fmt.Printf("swapInstanceDaemon start 2\n")
-			skip, writeDBErr := func() (bool, error) {
-				isSkip := false
-				tx := engine.db.Begin()
-				if err := tx.Error; err != nil {
-					return false, err
+			fmt.Printf("swapInstanceDaemon start 3\n")
+			if swapTx == nil || swapTx.FillSwapTxHash == "" {
+				util.Logger.Infof("retry swap, start tx hash %s, symbol %s, amount %s, direction %s",
+					swap.StartTxHash, swap.Symbol, swap.Amount, swap.Direction)
+				swap.Status = SwapConfirmed
+				if err := engine.updateSwap(tx, &swap); err != nil {
+					return err
 				}
-				if swap.Status == SwapSending {
-					var swapTx model.SwapFillTx
-					engine.db.Where("start_swap_tx_hash = ?", swap.StartTxHash).First(&swapTx)
-					fmt.Printf("swapInstanceDaemon start 3\n")
-					if swapTx.FillSwapTxHash == "" {
-						util.Logger.Infof("retry swap, start tx hash %s, symbol %s, amount %s, direction %s",
-							swap.StartTxHash, swap.Symbol, swap.Amount, swap.Direction)
-						swap.Status = SwapConfirmed
-						engine.updateSwap(tx, &swap)
-					} else {
-						util.Logger.Infof("swap tx is built successfully, but the swap tx status is uncertain, just mark the swap and swap tx status as sent, swap ID %d", swap.ID)
-						tx.Model(model.SwapFillTx{}).Where("fill_swap_tx_hash = ?", swapTx.FillSwapTxHash).Updates(
-							map[string]interface{}{
-								"status":     model.FillTxSent,
-								"updated_at": time.Now().Unix(),
-							})
-						fmt.Printf("swapInstanceDaemon start 4\n")
-						swap.Status = SwapSent
-						swap.FillTxHash = swapTx.FillSwapTxHash
-						engine.updateSwap(tx, &swap)
-
-						isSkip = true
-					}
-				} else {
-					fmt.Printf("swapInstanceDaemon start 5\n")
-					swap.Status = SwapSending
-					engine.updateSwap(tx, &swap)
+			} else {
+				util.Logger.Infof("swap tx is built successfully, but the swap tx status is uncertain, just mark the swap and swap tx status as sent, swap ID %d", swap.ID)
+				if err := tx.SetFillTxStatus(swapTx.ID, model.FillTxSent); err != nil {
+					return err
+				}
+				fmt.Printf("swapInstanceDaemon start 4\n")
+				swap.Status = SwapSent
+				swap.FillTxHash = swapTx.FillSwapTxHash
+				if err := engine.updateSwap(tx, &swap); err != nil {
+					return err
 				}
-				return isSkip, tx.Commit().Error
-			}()
-			fmt.Printf("swapInstanceDaemon start 6\n")
-			if writeDBErr != nil {
-				util.Logger.Errorf("write db error: %s", writeDBErr.Error())
-				util.SendTelegramMessage(fmt.Sprintf("write db error: %s", writeDBErr.Error()))
-				continue
+
+				isSkip = true
+			}
+		} else if engine.isGasGuardHolding(destChain) {
+			util.Logger.Infof("gas price of %s is above the ceiling, hold swap, start tx hash %s", destChain, swap.StartTxHash)
+			swap.Status = SwapAwaitingGas
+			swap.Log = fmt.Sprintf("gas price of %s is above the ceiling", destChain)
+			if err := engine.updateSwap(tx, &swap); err != nil {
+				return err
 			}
-			if skip {
-				util.Logger.Debugf("skip this swap, start tx hash %s", swap.StartTxHash)
-				continue
+
+			isSkip = true
+		} else if amount, ok := big.NewInt(0).SetString(swap.Amount, 10); ok && !isNFTSwap(swap.AssetType) && !engine.reserveWithdrawal(tx, destChain, amount) {
+			util.Logger.Infof("daily withdrawal limit of %s is reached, hold swap, start tx hash %s, amount %s", destChain, swap.StartTxHash, swap.Amount)
+			swap.Status = SwapAwaitingWindow
+			swap.Log = fmt.Sprintf("daily withdrawal limit of %s is reached", destChain)
+			if err := engine.updateSwap(tx, &swap); err != nil {
+				return err
+			}
+
+			isSkip = true
+		} else if ok && !isNFTSwap(swap.AssetType) && !engine.reserveLiquidity(destChain, amount) {
+			util.Logger.Infof("liquidity of %s is not enough, hold swap, start tx hash %s, amount %s", destChain, swap.StartTxHash, swap.Amount)
+			util.SendTelegramMessage(fmt.Sprintf("liquidity of %s is not enough, hold swap, start tx hash %s, amount %s", destChain, swap.StartTxHash, swap.Amount))
+			swap.Status = SwapAwaitingLiquidity
+			swap.Log = fmt.Sprintf("liquidity of %s is not enough", destChain)
+			if err := engine.updateSwap(tx, &swap); err != nil {
+				return err
 			}
-			fmt.Printf("swapInstanceDaemon start 7\n")
-			util.Logger.Infof("Swap token %s, direction %s, sponsor: %s, amount %s, decimals %d", swap.BEP20Addr, swap.Direction, swap.Sponsor, swap.Amount, swap.Decimals)
-			swapTx, swapErr := engine.doSwap(&swap, swapPairInstance)
 
-			writeDBErr = func() error {
-				tx := engine.db.Begin()
-				if err := tx.Error; err != nil {
+			isSkip = true
+		} else {
+			fmt.Printf("swapInstanceDaemon start 5\n")
+			swap.Status = SwapSending
+			if err := engine.updateSwap(tx, &swap); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	fmt.Printf("swapInstanceDaemon start 6\n")
+	if writeDBErr != nil {
+		util.Logger.Errorf("write db error: %s", writeDBErr.Error())
+		util.SendTelegramMessage(fmt.Sprintf("write db error: %s", writeDBErr.Error()))
+		return
+	}
+	if isSkip {
+		util.Logger.Debugf("skip this swap, start tx hash %s", swap.StartTxHash)
+		return
+	}
+	if engine.skipFilledSwap(&swap) {
+		return
+	}
+	fmt.Printf("swapInstanceDaemon start 7\n")
+	util.Logger.Infof("Swap token %s, direction %s, sponsor: %s, amount %s, decimals %d", swap.BEP20Addr, swap.Direction, swap.Sponsor, swap.Amount, swap.Decimals)
+	stopClaim := engine.keepSwapClaim(&swap)
+	swapTx, swapErr := engine.doSwap(&swap, swapPairInstance)
+	stopClaim()
+
+	writeDBErr = engine.saveFilledSwap(&swap, swapTx, swapErr)
+	fmt.Printf("swapInstanceDaemon start doSwap\n")
+	if writeDBErr != nil {
+		util.Logger.Errorf("write db error: %s", writeDBErr.Error())
+		util.SendTelegramMessage(fmt.Sprintf("write db error: %s", writeDBErr.Error()))
+	}
+
+}
+
+// saveFilledSwap records the result of the fill of the swap under the claim of the instance. A swap whose claim is
+// lost is left to the replica holding it, which finds the fill tx saved when it was signed and marks the swap sent.
+func (engine *SwapEngine) saveFilledSwap(swap *model.Swap, swapTx *model.SwapFillTx, swapErr error) error {
+	claimLost := false
+	err := engine.store.Transaction(func(tx SwapStore) error {
+		if renewed, err := engine.renewSwapClaim(tx, swap); err != nil {
+			return err
+		} else if !renewed {
+			claimLost = true
+			return nil
+		}
+		if swapErr != nil {
+			util.Logger.Errorf("do swap failed: %s, start hash %s", swapErr.Error(), swap.StartTxHash)
+			util.SendTelegramMessage(fmt.Sprintf("do swap failed: %s, start hash %s", swapErr.Error(), swap.StartTxHash))
+			failureClass := classifySwapError(swapErr)
+			if failureClass == common.FailureUnderpriced && swapTx != nil {
+				// the nonce of the fill tx is mined or can't be replaced, so the swap is retried with a new nonce
+				// after the backoff, delete the fill swap tx
+				if err := tx.DeleteFillTx(swapTx.ID); err != nil {
 					return err
 				}
-				if swapErr != nil {
-					util.Logger.Errorf("do swap failed: %s, start hash %s", swapErr.Error(), swap.StartTxHash)
-					util.SendTelegramMessage(fmt.Sprintf("do swap failed: %s, start hash %s", swapErr.Error(), swap.StartTxHash))
-					if swapErr.Error() == core.ErrReplaceUnderpriced.Error() {
-						//delete the fill swap tx
-						tx.Where("fill_swap_tx_hash = ?", swapTx.FillSwapTxHash).Delete(model.SwapFillTx{})
-						// retry this swap
-						swap.Status = SwapConfirmed
-						swap.Log = fmt.Sprintf("do swap failure: %s", swapErr.Error())
-
-						engine.updateSwap(tx, &swap)
-					} else {
-						fillTxHash := ""
-						if swapTx != nil {
-							tx.Model(model.SwapFillTx{}).Where("fill_swap_tx_hash = ?", swapTx.FillSwapTxHash).Updates(
-								map[string]interface{}{
-									"status":     model.FillTxFailed,
-									"updated_at": time.Now().Unix(),
-								})
-							fillTxHash = swapTx.FillSwapTxHash
-						}
-
-						swap.Status = SwapSendFailed
-						swap.FillTxHash = fillTxHash
-						swap.Log = fmt.Sprintf("do swap failure: %s", swapErr.Error())
-						engine.updateSwap(tx, &swap)
+				swap.Log = fmt.Sprintf("do swap failure: %s", swapErr.Error())
+				engine.failSwap(swap, common.FailureUnderpriced, swapErr.Error())
+				if err := engine.updateSwap(tx, swap); err != nil {
+					return err
+				}
+			} else {
+				fillTxHash := ""
+				if swapTx != nil {
+					if err := tx.SetFillTxStatus(swapTx.ID, model.FillTxFailed); err != nil {
+						return err
 					}
-				} else {
-					tx.Model(model.SwapFillTx{}).Where("fill_swap_tx_hash = ?", swapTx.FillSwapTxHash).Updates(
-						map[string]interface{}{
-							"status":     model.FillTxSent,
-							"updated_at": time.Now().Unix(),
-						})
-
-					swap.Status = SwapSent
-					swap.FillTxHash = swapTx.FillSwapTxHash
-					engine.updateSwap(tx, &swap)
+					fillTxHash = swapTx.FillSwapTxHash
 				}
 
-				return tx.Commit().Error
-			}()
-			fmt.Printf("swapInstanceDaemon start doSwap\n")
-			if writeDBErr != nil {
-				util.Logger.Errorf("write db error: %s", writeDBErr.Error())
-				util.SendTelegramMessage(fmt.Sprintf("write db error: %s", writeDBErr.Error()))
+				swap.FillTxHash = fillTxHash
+				swap.Log = fmt.Sprintf("do swap failure: %s", swapErr.Error())
+				engine.failSwap(swap, failureClass, swapErr.Error())
+				if err := engine.updateSwap(tx, swap); err != nil {
+					return err
+				}
+			}
+		} else {
+			if err := tx.SetFillTxStatus(swapTx.ID, model.FillTxSent); err != nil {
+				return err
 			}
 
-			if swap.Direction == SwapEth2BSC {
-				time.Sleep(time.Duration(engine.config.ChainConfig.BSCWaitMilliSecBetweenSwaps) * time.Millisecond)
-			} else {
-				time.Sleep(time.Duration(engine.config.ChainConfig.ETHWaitMilliSecBetweenSwaps) * time.Millisecond)
+			swap.Status = SwapSent
+			swap.FillTxHash = swapTx.FillSwapTxHash
+			if err := engine.updateSwap(tx, swap); err != nil {
+				return err
 			}
 		}
-		fmt.Printf("swapInstanceDaemon start final\n")
+		return nil
+	})
+	if err == nil && claimLost {
+		util.Logger.Errorf("claim of swap is lost after its fill, the result is left to the replica holding it, start tx hash %s", swap.StartTxHash)
+		util.SendTelegramMessage(fmt.Sprintf("Urgent alert: claim of swap is lost after its fill, the result is left to the replica holding it, start tx hash %s",
+			swap.StartTxHash))
 	}
+	return err
 }
 
 func (engine *SwapEngine) doSwap(swap *model.Swap, swapPairInstance *SwapPairIns) (*model.SwapFillTx, error) {
@@ -462,297 +939,299 @@ func (engine *SwapEngine) doSwap(swap *model.Swap, swapPairInstance *SwapPairIns
 		return nil, fmt.Errorf("invalid chainId: %s", swap.ToChainId)
 	}
 
-	if swap.Direction == SwapEth2BSC || swap.Direction == SwapMATIC2BSC {
-		bscClientMutex.Lock()
-		defer bscClientMutex.Unlock()
-		data, err := abiEncodeFillSwap(toChainId, ethcom.HexToAddress(swap.Sponsor), amount, engine.swapAgentABI)
-		if err != nil {
-			return nil, err
-		}
-		signedTx, err := buildSignedTransaction(engine.bscSwapAgent, engine.bscClient, data, engine.bscPrivateKey, toChainId)
-		if err != nil {
-			return nil, err
-		}
-		swapTx := &model.SwapFillTx{
-			Direction:       swap.Direction,
-			StartSwapTxHash: swap.StartTxHash,
-			FillSwapTxHash:  signedTx.Hash().String(),
-			GasPrice:        signedTx.GasPrice().String(),
-			Status:          model.FillTxCreated,
-		}
-		err = engine.insertSwapTxToDB(swapTx)
-		if err != nil {
-			return nil, err
-		}
-		err = engine.bscClient.SendTransaction(context.Background(), signedTx)
-		if err != nil {
-			util.Logger.Errorf("broadcast tx to BSC error: %s", err.Error())
-			return nil, err
-		}
-		util.Logger.Infof("Send transaction to BSC, %s/%s", engine.config.ChainConfig.BSCExplorerUrl, signedTx.Hash().String())
-		return swapTx, nil
-	} else if swap.Direction == SwapBSC2Eth || swap.Direction == SwapMATIC2Eth {
-		ethClientMutex.Lock()
-		defer ethClientMutex.Unlock()
-		data, err := abiEncodeFillSwap(toChainId, ethcom.HexToAddress(swap.Sponsor), amount, engine.swapAgentABI)
-		if err != nil {
-			return nil, err
-		}
-		signedTx, err := buildSignedTransaction(engine.ethSwapAgent, engine.ethClient, data, engine.ethPrivateKey, toChainId)
-		if err != nil {
-			return nil, err
-		}
-		swapTx := &model.SwapFillTx{
-			Direction:       swap.Direction,
-			StartSwapTxHash: swap.StartTxHash,
-			GasPrice:        signedTx.GasPrice().String(),
-			FillSwapTxHash:  signedTx.Hash().String(),
-			Status:          model.FillTxCreated,
-		}
-		err = engine.insertSwapTxToDB(swapTx)
-		if err != nil {
-			return nil, err
-		}
-		err = engine.ethClient.SendTransaction(context.Background(), signedTx)
-		if err != nil {
-			util.Logger.Errorf("broadcast tx to ETH error: %s", err.Error())
-			return nil, err
-		} else {
-			util.Logger.Infof("Send transaction to ETH, %s/%s", engine.config.ChainConfig.ETHExplorerUrl, signedTx.Hash().String())
-		}
-		return swapTx, nil
+	destChain := getDestChain(swap.Direction)
+	if err := engine.screenRecipient(swap.GetRecipient()); err != nil {
+		return nil, err
+	}
+	var data []byte
+	var err error
+	if isNFTSwap(swap.AssetType) {
+		data, err = abiEncodeFillNFTSwap(destChain, toChainId, ethcom.HexToAddress(swap.GetRecipient()), swap.BEP20Addr, swap.ERC20Addr, swap.TokenId)
 	} else {
-		maticClientMutex.Lock()
-		defer maticClientMutex.Unlock()
-		data, err := abiEncodeFillSwap(toChainId, ethcom.HexToAddress(swap.Sponsor), amount, engine.swapAgentABI)
-		if err != nil {
-			return nil, err
-		}
-		signedTx, err := buildSignedTransaction(engine.maticSwapAgent, engine.maticClient, data, engine.maticPrivateKey, toChainId)
-		if err != nil {
-			return nil, err
-		}
-		swapTx := &model.SwapFillTx{
-			Direction:       swap.Direction,
-			StartSwapTxHash: swap.StartTxHash,
-			FillSwapTxHash:  signedTx.Hash().String(),
-			GasPrice:        signedTx.GasPrice().String(),
-			Status:          model.FillTxCreated,
-		}
-		err = engine.insertSwapTxToDB(swapTx)
-		if err != nil {
-			return nil, err
-		}
-		err = engine.maticClient.SendTransaction(context.Background(), signedTx)
-		if err != nil {
-			util.Logger.Errorf("broadcast tx to MATIC error: %s", err.Error())
-			return nil, err
-		}
-		util.Logger.Infof("Send transaction to MATIC, %s/%s", engine.config.ChainConfig.MATICExplorerUrl, signedTx.Hash().String())
-		return swapTx, nil
+		data, err = engine.encodeFillSwap(newFillCall(swap, toChainId, amount))
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	var swapTx *model.SwapFillTx
+	use := KeyUse{Purpose: model.KeyUsageFill, Daemon: "swap_" + destChain, Token: ethcom.HexToAddress(swap.ERC20Addr), Amount: amount}
+	signedTx, err := engine.relayerPools[destChain].Broadcast(BroadcastPriorityNormal, use, engine.getSwapAgent(destChain), data,
+		func(signedTx *types.Transaction, gasEstimate uint64) error {
+			rawTx, err := rlp.EncodeToBytes(signedTx)
+			if err != nil {
+				return err
+			}
+			if swapTx != nil {
+				// the previous fill tx is underpriced and replaced, it is never sent
+				engine.store.DeleteFillTx(swapTx.ID)
+			}
+			swapTx = &model.SwapFillTx{
+				SwapID:          swap.ID,
+				Direction:       swap.Direction,
+				StartSwapTxHash: swap.StartTxHash,
+				FillSwapTxHash:  signedTx.Hash().String(),
+				GasPrice:        signedTx.GasPrice().String(),
+				GasLimit:        int64(signedTx.Gas()),
+				GasEstimate:     int64(gasEstimate),
+				Status:          model.FillTxCreated,
+				RawTx:           hexutil.Encode(rawTx),
+				BroadcastTime:   time.Now().Unix(),
+			}
+			return engine.store.CreateFillTx(swapTx)
+		})
+	if err == nil && !isNFTSwap(swap.AssetType) {
+		engine.drainBrake.recordFill(destChain, ethcom.HexToAddress(swap.ERC20Addr), amount, signedTx)
+	}
+	return swapTx, err
 }
 
 func (engine *SwapEngine) trackSwapTxDaemon() {
-	go func() {
-		for {
-			time.Sleep(SleepTime * time.Second)
+	for _, chain := range []string{common.ChainBSC, common.ChainETH, common.ChainMATIC} {
+		chain := chain
+		interval := engine.config.ChainConfig.GetTrackTxInterval(chain)
+		engine.scheduler.Go(util.Daemon{Name: "track_missing_swap_tx_" + chain, Interval: interval, WaitFirst: true,
+			Run: func() error { return engine.trackMissingSwapTxDaemon(chain) }})
+		engine.scheduler.Go(util.Daemon{Name: "track_sent_swap_tx_" + chain, Interval: interval, WaitFirst: true,
+			Run: func() error { return engine.trackSentSwapTxDaemon(chain) }})
+	}
+}
+
+// trackMissingSwapTxDaemon marks the fill txs of the given destination chain as missing if their status
+// is still uncertain after the max track retry
+func (engine *SwapEngine) trackMissingSwapTxDaemon(chainName string) error {
+	interval := engine.config.ChainConfig.GetTrackTxInterval(chainName)
+	maxRetry := engine.config.ChainConfig.GetMaxTrackRetry(chainName)
+	if engine.isChainDegraded(chainName) {
+		return nil
+	}
 
-			swapTxs := make([]model.SwapFillTx, 0)
-			engine.db.Where("status = ? and track_retry_counter >= ?", model.FillTxSent, engine.config.ChainConfig.ETHMaxTrackRetry).
-				Order("id asc").Limit(TrackSentTxBatchSize).Find(&swapTxs)
+	swapTxs, err := engine.store.FindSentFillTxs(getDirectionsToChain(chainName), maxRetry, true, TrackSentTxBatchSize)
+	if err != nil {
+		return err
+	}
 
-			if len(swapTxs) > 0 {
-				util.Logger.Infof("%d fill tx are missing, mark these swaps as failed", len(swapTxs))
-			}
+	if len(swapTxs) > 0 {
+		util.Logger.Infof("%d fill tx are missing, mark these swaps as failed", len(swapTxs))
+	}
 
-			for _, swapTx := range swapTxs {
-				chainName := "ETH"
-				maxRetry := engine.config.ChainConfig.ETHMaxTrackRetry
-				if swapTx.Direction == SwapEth2BSC || swapTx.Direction == SwapMATIC2BSC {
-					chainName = "BSC"
-					maxRetry = engine.config.ChainConfig.BSCMaxTrackRetry
-				}
-				if swapTx.Direction == SwapBSC2MATIC || swapTx.Direction == SwapEth2MATIC {
-					chainName = "MATIC"
-					maxRetry = engine.config.ChainConfig.MATICMaxTrackRetry
-				}
-				util.Logger.Errorf("The fill tx is sent, however, after %d seconds its status is still uncertain. Mark tx as missing and mark swap as failed, chain %s, fill hash %s", SleepTime*maxRetry, chainName, swapTx.StartSwapTxHash)
-				util.SendTelegramMessage(fmt.Sprintf("The fill tx is sent, however, after %d seconds its status is still uncertain. Mark tx as missing and mark swap as failed, chain %s, start hash %s", SleepTime*maxRetry, chainName, swapTx.StartSwapTxHash))
+	for _, swapTx := range swapTxs {
+		util.Logger.Errorf("The fill tx is sent, however, after %d seconds its status is still uncertain. Mark tx as missing and mark swap as failed, chain %s, fill hash %s", int64(interval.Seconds())*maxRetry, chainName, swapTx.StartSwapTxHash)
+		util.SendTelegramMessage(fmt.Sprintf("The fill tx is sent, however, after %d seconds its status is still uncertain. Mark tx as missing and mark swap as failed, chain %s, start hash %s", int64(interval.Seconds())*maxRetry, chainName, swapTx.StartSwapTxHash))
 
-				writeDBErr := func() error {
-					tx := engine.db.Begin()
-					if err := tx.Error; err != nil {
-						return err
-					}
-					tx.Model(model.SwapFillTx{}).Where("id = ?", swapTx.ID).Updates(
-						map[string]interface{}{
-							"status":     model.FillTxMissing,
-							"updated_at": time.Now().Unix(),
-						})
+		writeDBErr := engine.store.Transaction(func(tx SwapStore) error {
+			if err := tx.SetFillTxStatus(swapTx.ID, model.FillTxMissing); err != nil {
+				return err
+			}
 
-					swap, err := engine.getSwapByStartTxHash(tx, swapTx.StartSwapTxHash)
-					if err != nil {
-						tx.Rollback()
-						return err
-					}
-					swap.Status = SwapSendFailed
-					swap.Log = fmt.Sprintf("track fill tx for more than %d times, the fill tx status is still uncertain", maxRetry)
-					engine.updateSwap(tx, swap)
-
-					return tx.Commit().Error
-				}()
-				if writeDBErr != nil {
-					util.Logger.Errorf("write db error: %s", writeDBErr.Error())
-					util.SendTelegramMessage(fmt.Sprintf("write db error: %s", writeDBErr.Error()))
-				}
+			swap, err := engine.getSwapByID(tx, swapTx.SwapID)
+			if err != nil {
+				return err
 			}
+			swap.Status = SwapSendFailed
+			swap.Log = fmt.Sprintf("track fill tx for more than %d times, the fill tx status is still uncertain", maxRetry)
+			return engine.updateSwap(tx, swap)
+		})
+		if writeDBErr != nil {
+			util.Logger.Errorf("write db error: %s", writeDBErr.Error())
+			util.SendTelegramMessage(fmt.Sprintf("write db error: %s", writeDBErr.Error()))
 		}
-	}()
-
-	go func() {
-		for {
-			time.Sleep(SleepTime * time.Second)
-
-			ethSwapTxs := make([]model.SwapFillTx, 0)
-			engine.db.Where("status = ? and direction = ? and track_retry_counter < ?", model.FillTxSent, SwapBSC2Eth, SwapMATIC2Eth, engine.config.ChainConfig.ETHMaxTrackRetry).
-				Order("id asc").Limit(TrackSentTxBatchSize).Find(&ethSwapTxs)
+	}
+	return nil
+}
 
-			bscSwapTxs := make([]model.SwapFillTx, 0)
-			engine.db.Where("status = ? and direction = ? and track_retry_counter < ?", model.FillTxSent, SwapEth2BSC, SwapMATIC2BSC, engine.config.ChainConfig.BSCMaxTrackRetry).
-				Order("id asc").Limit(TrackSentTxBatchSize).Find(&bscSwapTxs)
+// trackSentSwapTxDaemon tracks the sent fill txs of the given destination chain until they are finalized
+func (engine *SwapEngine) trackSentSwapTxDaemon(chainName string) error {
+	maxRetry := engine.config.ChainConfig.GetMaxTrackRetry(chainName)
+	client := engine.getClient(chainName)
+	// the retries of the fill txs are not counted while the rpc is down
+	if engine.isChainDegraded(chainName) {
+		return nil
+	}
 
-			maticSwapTxs := make([]model.SwapFillTx, 0)
-			engine.db.Where("status = ? and direction = ? and track_retry_counter < ?", model.FillTxSent, SwapEth2MATIC, SwapBSC2MATIC, engine.config.ChainConfig.MATICMaxTrackRetry).
-				Order("id asc").Limit(TrackSentTxBatchSize).Find(&maticSwapTxs)
+	swapTxs, err := engine.store.FindSentFillTxs(getDirectionsToChain(chainName), maxRetry, false, TrackSentTxBatchSize)
+	if err != nil {
+		return err
+	}
 
-			swapTxs := append(ethSwapTxs, bscSwapTxs...)
-			swapTxs = append(swapTxs, maticSwapTxs...)
+	if len(swapTxs) > 0 {
+		util.Logger.Debugf("Track %d non-finalized swap txs", len(swapTxs))
+	}
 
-			if len(swapTxs) > 0 {
-				util.Logger.Debugf("Track %d non-finalized swap txs", len(swapTxs))
+	txHashes := make([]ethcom.Hash, 0, len(swapTxs))
+	for _, swapTx := range swapTxs {
+		txHashes = append(txHashes, ethcom.HexToHash(swapTx.FillSwapTxHash))
+	}
+	receipts, receiptErrs := getTransactionReceipts(client, txHashes)
+
+	for idx, swapTx := range swapTxs {
+		gasPrice := big.NewInt(0)
+		gasPrice.SetString(swapTx.GasPrice, 10)
+
+		var txRecipient *types.Receipt
+		var revertReason string
+		queryTxStatusErr := func() error {
+			height, err := engine.heads[chainName].Height()
+			if err != nil {
+				util.Logger.Debugf("%s, query block failed: %s", chainName, err.Error())
+				return err
 			}
+			txRecipient, err = receipts[idx], receiptErrs[idx]
+			if err != nil {
+				util.Logger.Debugf("%s, query tx failed: %s", chainName, err.Error())
+				return err
+			}
+			if height < txRecipient.BlockNumber.Int64()+engine.config.ChainConfig.ETHConfirmNum {
+				return fmt.Errorf("%s, swap tx is still not finalized", chainName)
+			}
+			if txRecipient.Status == TxFailedStatus {
+				revertReason, err = getRevertReason(client, txRecipient.TxHash, txRecipient.BlockNumber)
+				if err != nil {
+					// the failed tx is recorded without its revert reason, the receipt is final whether the replay works or not
+					util.Logger.Errorf("%s, get revert reason of tx %s failed: %s", chainName, txRecipient.TxHash.String(), err.Error())
+					revertReason = ""
+				}
+			}
+			return nil
+		}()
 
-			for _, swapTx := range swapTxs {
-				gasPrice := big.NewInt(0)
-				gasPrice.SetString(swapTx.GasPrice, 10)
+		writeDBErr := engine.store.Transaction(func(tx SwapStore) error {
+			if queryTxStatusErr != nil {
+				return tx.IncrFillTxTrackRetry(swapTx.ID)
+			} else {
+				txFee := big.NewInt(1).Mul(gasPrice, big.NewInt(int64(txRecipient.GasUsed))).String()
+				if txRecipient.Status == TxFailedStatus {
+					util.Logger.Infof(fmt.Sprintf("fill swap tx is failed, chain %s, txHash: %s, revert reason: %s", chainName, txRecipient.TxHash.String(), revertReason))
+					util.SendTelegramMessage(fmt.Sprintf("fill swap tx is failed, chain %s, txHash: %s, revert reason: %s %s", chainName, txRecipient.TxHash.String(), revertReason,
+						engine.config.ExplorerLink(chainName, util.ExplorerTx, txRecipient.TxHash.String())))
+					if err := tx.SetFillTxReceipt(swapTx.ID, model.FillTxFailed, txRecipient.BlockNumber.Int64(), txFee, revertReason); err != nil {
+						return err
+					}
 
-				var client *ethclient.Client
-				chainName := "ETH"
-				client = engine.ethClient
-				if swapTx.Direction == SwapEth2BSC || swapTx.Direction == SwapMATIC2BSC {
-					chainName = "BSC"
-					client = engine.bscClient
-				}
-				if swapTx.Direction == SwapBSC2MATIC || swapTx.Direction == SwapEth2MATIC {
-					chainName = "MATIC"
-					client = engine.maticClient
-				}
-				var txRecipient *types.Receipt
-				queryTxStatusErr := func() error {
-					block, err := client.BlockByNumber(context.Background(), nil)
+					swap, err := engine.getSwapByID(tx, swapTx.SwapID)
 					if err != nil {
-						util.Logger.Debugf("%s, query block failed: %s", chainName, err.Error())
 						return err
 					}
-					txRecipient, err = client.TransactionReceipt(context.Background(), ethcom.HexToHash(swapTx.FillSwapTxHash))
-					if err != nil {
-						util.Logger.Debugf("%s, query tx failed: %s", chainName, err.Error())
+					swap.Log = fmt.Sprintf("fill tx is failed, revert reason: %s", revertReason)
+					swap.RevertReason = revertReason
+					engine.failSwap(swap, common.FailureRevert, swap.Log)
+					if err := engine.updateSwap(tx, swap); err != nil {
 						return err
 					}
-					if block.Number().Int64() < txRecipient.BlockNumber.Int64()+engine.config.ChainConfig.ETHConfirmNum {
-						return fmt.Errorf("%s, swap tx is still not finalized", chainName)
+				} else {
+					util.Logger.Infof(fmt.Sprintf("fill swap tx is success, chain %s, txHash: %s", chainName, txRecipient.TxHash.String()))
+					if err := tx.SetFillTxReceipt(swapTx.ID, model.FillTxSuccess, txRecipient.BlockNumber.Int64(), txFee, ""); err != nil {
+						return err
 					}
-					return nil
-				}()
 
-				writeDBErr := func() error {
-					tx := engine.db.Begin()
-					if err := tx.Error; err != nil {
+					swap, err := engine.getSwapByID(tx, swapTx.SwapID)
+					if err != nil {
 						return err
 					}
-					if queryTxStatusErr != nil {
-						tx.Model(model.SwapFillTx{}).Where("id = ?", swapTx.ID).Updates(
-							map[string]interface{}{
-								"track_retry_counter": gorm.Expr("track_retry_counter + 1"),
-								"updated_at":          time.Now().Unix(),
-							})
+					if mismatch := engine.verifySwapFilledEvent(txRecipient, swap, swap.FillTxHash); mismatch != "" {
+						util.Logger.Errorf("fill swap tx mismatch, chain %s, start hash %s, %s", chainName, swap.StartTxHash, mismatch)
+						util.SendTelegramMessage(fmt.Sprintf("Urgent alert: fill swap tx mismatch, chain %s, start hash %s, %s", chainName, swap.StartTxHash, mismatch))
+						swap.Status = SwapMismatch
+						swap.Log = mismatch
 					} else {
-						txFee := big.NewInt(1).Mul(gasPrice, big.NewInt(int64(txRecipient.GasUsed))).String()
-						if txRecipient.Status == TxFailedStatus {
-							util.Logger.Infof(fmt.Sprintf("fill swap tx is failed, chain %s, txHash: %s", chainName, txRecipient.TxHash.String()))
-							util.SendTelegramMessage(fmt.Sprintf("fill swap tx is failed, chain %s, txHash: %s", chainName, txRecipient.TxHash.String()))
-							tx.Model(model.SwapFillTx{}).Where("id = ?", swapTx.ID).Updates(
-								map[string]interface{}{
-									"status":              model.FillTxFailed,
-									"height":              txRecipient.BlockNumber.Int64(),
-									"consumed_fee_amount": txFee,
-									"updated_at":          time.Now().Unix(),
-								})
-
-							swap, err := engine.getSwapByStartTxHash(tx, swapTx.StartSwapTxHash)
-							if err != nil {
-								tx.Rollback()
-								return err
-							}
-							swap.Status = SwapSendFailed
-							swap.Log = "fill tx is failed"
-							engine.updateSwap(tx, swap)
-						} else {
-							util.Logger.Infof(fmt.Sprintf("fill swap tx is success, chain %s, txHash: %s", chainName, txRecipient.TxHash.String()))
-							tx.Model(model.SwapFillTx{}).Where("id = ?", swapTx.ID).Updates(
-								map[string]interface{}{
-									"status":              model.FillTxSuccess,
-									"height":              txRecipient.BlockNumber.Int64(),
-									"consumed_fee_amount": txFee,
-									"updated_at":          time.Now().Unix(),
-								})
-
-							swap, err := engine.getSwapByStartTxHash(tx, swapTx.StartSwapTxHash)
-							if err != nil {
-								tx.Rollback()
-								return err
-							}
-							swap.Status = SwapSuccess
-							engine.updateSwap(tx, swap)
-						}
+						swap.Status = SwapSuccess
+					}
+					if err := engine.updateSwap(tx, swap); err != nil {
+						return err
 					}
-					return tx.Commit().Error
-				}()
-				if writeDBErr != nil {
-					util.Logger.Errorf("update db failure3: %s", writeDBErr.Error())
-					util.SendTelegramMessage(fmt.Sprintf("Upgent alert: update db failure3: %s", writeDBErr.Error()))
 				}
-
 			}
+			return nil
+		})
+		if writeDBErr != nil {
+			util.Logger.Errorf("update db failure3: %s", writeDBErr.Error())
+			util.SendTelegramMessage(fmt.Sprintf("Upgent alert: update db failure3: %s", writeDBErr.Error()))
 		}
-	}()
+
+	}
+	return nil
 }
 
-func (engine *SwapEngine) getSwapByStartTxHash(tx *gorm.DB, txHash string) (*model.Swap, error) {
-	swap := model.Swap{}
-	err := tx.Where("start_tx_hash = ?", txHash).First(&swap).Error
+// PauseDirection pauses or resumes sending the fill txs of the given direction
+func (engine *SwapEngine) PauseDirection(direction common.SwapDirection, paused bool) error {
+	if !isValidDirection(direction) {
+		return fmt.Errorf("unknown swap direction: %s", direction)
+	}
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+
+	if paused {
+		engine.pausedDirections[direction] = true
+	} else {
+		delete(engine.pausedDirections, direction)
+	}
+	util.Logger.Infof("swap direction %s paused: %v", direction, paused)
+	return nil
+}
+
+// GetPausedDirections returns the paused directions in the order of the swap routes
+func (engine *SwapEngine) GetPausedDirections() []common.SwapDirection {
+	engine.mutex.RLock()
+	defer engine.mutex.RUnlock()
+
+	directions := make([]common.SwapDirection, 0)
+	for _, route := range swapRoutes {
+		if engine.pausedDirections[route.Direction] {
+			directions = append(directions, route.Direction)
+		}
+	}
+	return directions
+}
+
+func (engine *SwapEngine) IsDirectionPaused(direction common.SwapDirection) bool {
+	engine.mutex.RLock()
+	defer engine.mutex.RUnlock()
+
+	return engine.pausedDirections[direction]
+}
+
+// getActiveDirections returns the directions to the given chain which are not paused
+func (engine *SwapEngine) getActiveDirections(destChain string) []common.SwapDirection {
+	directions := make([]common.SwapDirection, 0)
+	for _, direction := range getDirectionsToChain(destChain) {
+		if !engine.IsDirectionPaused(direction) {
+			directions = append(directions, direction)
+		}
+	}
+	return directions
+}
+
+func (engine *SwapEngine) getClient(chain string) ChainClient {
+	switch chain {
+	case common.ChainBSC:
+		return engine.bscClient
+	case common.ChainMATIC:
+		return engine.maticClient
+	default:
+		return engine.ethClient
+	}
+}
+
+func (engine *SwapEngine) getSwapByStartTxHash(tx SwapStore, txHash string) (*model.Swap, error) {
+	swap, err := tx.GetSwapByStartTxHash(txHash)
 	if err != nil {
 		return nil, err
 	}
-	if !engine.verifySwap(&swap) {
+	if !engine.verifySwap(swap) {
 		return nil, fmt.Errorf("hmac verification failure")
 	}
-	return &swap, nil
+	return swap, nil
 }
 
-func (engine *SwapEngine) insertSwapTxToDB(data *model.SwapFillTx) error {
-	tx := engine.db.Begin()
-	if err := tx.Error; err != nil {
-		return err
+func (engine *SwapEngine) getSwapByID(tx SwapStore, id uint) (*model.Swap, error) {
+	swap, err := tx.GetSwap(id)
+	if err != nil {
+		return nil, err
 	}
-
-	if err := tx.Create(data).Error; err != nil {
-		tx.Rollback()
-		return err
+	if !engine.verifySwap(swap) {
+		return nil, fmt.Errorf("hmac verification failure")
 	}
-
-	return tx.Commit().Error
+	return swap, nil
 }
 
 func (engine *SwapEngine) AddSwapPairInstance(swapPair *model.SwapPair) error {
@@ -777,6 +1256,9 @@ func (engine *SwapEngine) AddSwapPairInstance(swapPair *model.SwapPair) error {
 		UpperBound: upperBound,
 		BEP20Addr:  ethcom.HexToAddress(swapPair.BEP20Addr),
 		ERC20Addr:  ethcom.HexToAddress(swapPair.ERC20Addr),
+		AssetType:  swapPair.AssetType,
+		NFTEnabled: swapPair.NFTEnabled,
+		Available:  swapPair.Available,
 	}
 	engine.bep20ToERC20[ethcom.HexToAddress(swapPair.BEP20Addr)] = ethcom.HexToAddress(swapPair.ERC20Addr)
 	engine.erc20ToBEP20[ethcom.HexToAddress(swapPair.ERC20Addr)] = ethcom.HexToAddress(swapPair.BEP20Addr)
@@ -786,6 +1268,8 @@ func (engine *SwapEngine) AddSwapPairInstance(swapPair *model.SwapPair) error {
 	return nil
 }
 
+// GetSwapPairInstance returns the swap pair of the erc20 address, the disabled pairs are returned as well so the
+// in-flight and historical swaps keep their metadata
 func (engine *SwapEngine) GetSwapPairInstance(erc20Addr ethcom.Address) (*SwapPairIns, error) {
 	engine.mutex.RLock()
 	defer engine.mutex.RUnlock()
@@ -797,28 +1281,87 @@ func (engine *SwapEngine) GetSwapPairInstance(erc20Addr ethcom.Address) (*SwapPa
 	return tokenInstance, nil
 }
 
+// getFungiblePair returns the swap pair of the token of the source chain, the token is the bep20 address of the
+// pair on BSC and the erc20 address on the other chains
+func (engine *SwapEngine) getFungiblePair(chain, token string) (*SwapPairIns, error) {
+	if token == "" {
+		return nil, fmt.Errorf("token of the swap on %s is unknown", chain)
+	}
+	engine.mutex.RLock()
+	defer engine.mutex.RUnlock()
+
+	erc20Addr := ethcom.HexToAddress(token)
+	if chain == common.ChainBSC {
+		erc20Addr = engine.bep20ToERC20[erc20Addr]
+	}
+	pair, ok := engine.swapPairsFromERC20Addr[erc20Addr]
+	if !ok || isNFTSwap(pair.AssetType) {
+		return nil, fmt.Errorf("token %s on %s is not a registered swap pair", token, chain)
+	}
+	return pair, nil
+}
+
+// UpdateSwapInstance applies the update of the swap pair, an unavailable pair is only disabled, it stays resolvable
+// for its in-flight and historical swaps until it is deleted by DeleteSwapPair
 func (engine *SwapEngine) UpdateSwapInstance(swapPair *model.SwapPair) {
 	engine.mutex.Lock()
 	defer engine.mutex.Unlock()
 
-	bscTokenAddr := ethcom.HexToAddress(swapPair.BEP20Addr)
-	tokenInstance, ok := engine.swapPairsFromERC20Addr[bscTokenAddr]
+	erc20Addr := ethcom.HexToAddress(swapPair.ERC20Addr)
+	tokenInstance, ok := engine.swapPairsFromERC20Addr[erc20Addr]
 	if !ok {
 		return
 	}
 
-	if !swapPair.Available {
-		delete(engine.swapPairsFromERC20Addr, bscTokenAddr)
-		return
+	tokenInstance.Available = swapPair.Available
+	tokenInstance.AssetType = swapPair.AssetType
+	tokenInstance.NFTEnabled = swapPair.NFTEnabled
+
+	if upperBound, ok := big.NewInt(0).SetString(swapPair.UpperBound, 10); ok {
+		tokenInstance.UpperBound = upperBound
+	}
+	if lowBound, ok := big.NewInt(0).SetString(swapPair.LowBound, 10); ok {
+		tokenInstance.LowBound = lowBound
 	}
 
-	upperBound := big.NewInt(0)
-	_, ok = upperBound.SetString(swapPair.UpperBound, 10)
-	tokenInstance.UpperBound = upperBound
+	engine.swapPairsFromERC20Addr[erc20Addr] = tokenInstance
+}
 
-	lowBound := big.NewInt(0)
-	_, ok = upperBound.SetString(swapPair.LowBound, 10)
-	tokenInstance.LowBound = lowBound
+// CountSwapPairReferences counts the swaps, the retry swaps and the archived swaps of the swap pair
+func (engine *SwapEngine) CountSwapPairReferences(swapPair *model.SwapPair) (int, error) {
+	return engine.store.CountPairReferences(swapPair.ERC20Addr, swapPair.BEP20Addr)
+}
+
+// DeleteSwapPair removes the swap pair of the erc20 address along with its owners, its pause and its fill methods, it fails if any
+// swap references the pair, such a pair can only be disabled
+func (engine *SwapEngine) DeleteSwapPair(erc20Addr ethcom.Address) (*model.SwapPair, error) {
+	swapPair, err := engine.store.GetSwapPair(erc20Addr.String())
+	if err != nil {
+		return nil, fmt.Errorf("swapPair %s is not found", erc20Addr.String())
+	}
+	references, err := engine.CountSwapPairReferences(swapPair)
+	if err != nil {
+		return nil, err
+	}
+	if references > 0 {
+		return nil, fmt.Errorf("swapPair %s is referenced by %d swaps, disable it instead", erc20Addr.String(), references)
+	}
 
-	engine.swapPairsFromERC20Addr[bscTokenAddr] = tokenInstance
+	err = engine.store.Transaction(func(tx SwapStore) error {
+		return tx.DeleteSwapPair(swapPair.ERC20Addr)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	engine.mutex.Lock()
+	delete(engine.swapPairsFromERC20Addr, erc20Addr)
+	delete(engine.bep20ToERC20, ethcom.HexToAddress(swapPair.BEP20Addr))
+	delete(engine.erc20ToBEP20, erc20Addr)
+	delete(engine.pausedPairs, erc20Addr)
+	delete(engine.fillMethods, erc20Addr)
+	engine.mutex.Unlock()
+
+	util.Logger.Infof("swap pair %s is deleted, bep20 address %s, erc20 address %s", swapPair.Symbol, swapPair.BEP20Addr, swapPair.ERC20Addr)
+	return swapPair, nil
 }
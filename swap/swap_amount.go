@@ -0,0 +1,76 @@
+package swap
+
+import (
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+// FillAmountDecimalsMigration is the data migration setting the decimal amounts of the swaps written before the
+// swaps kept them
+const FillAmountDecimalsMigration = "fill_amount_decimals"
+
+// fillAmountDecimals runs the decimal amount backfill once, the decimal amounts of the swaps and the archived swaps
+// are derived from their amounts and decimals. The swaps with an invalid amount are left without one.
+func (engine *SwapEngine) fillAmountDecimals() {
+	if model.IsMigrationApplied(engine.db, FillAmountDecimalsMigration) {
+		return
+	}
+	filled, invalid := 0, 0
+	var lastID uint
+	for {
+		swaps := make([]model.Swap, 0)
+		err := engine.db.Unscoped().Where("id > ? and amount_decimal = ?", lastID, "").Order("id asc").Limit(BatchSize).Find(&swaps).Error
+		if err != nil {
+			util.Logger.Errorf("query swaps without decimal amount error: %s", err.Error())
+			return
+		}
+		if len(swaps) == 0 {
+			break
+		}
+		for _, swap := range swaps {
+			lastID = swap.ID
+			amountDecimal, err := model.FormatRawAmount(swap.Amount, swap.Decimals)
+			if err != nil {
+				invalid++
+				continue
+			}
+			if err := engine.db.Unscoped().Model(model.Swap{}).Where("id = ?", swap.ID).UpdateColumn("amount_decimal", amountDecimal).Error; err != nil {
+				util.Logger.Errorf("fill decimal amount of swap %d error: %s", swap.ID, err.Error())
+				return
+			}
+			filled++
+		}
+	}
+
+	lastID = 0
+	for {
+		swaps := make([]model.ArchivedSwap, 0)
+		err := engine.db.Where("id > ? and amount_decimal = ?", lastID, "").Order("id asc").Limit(BatchSize).Find(&swaps).Error
+		if err != nil {
+			util.Logger.Errorf("query archived swaps without decimal amount error: %s", err.Error())
+			return
+		}
+		if len(swaps) == 0 {
+			break
+		}
+		for _, swap := range swaps {
+			lastID = swap.ID
+			amountDecimal, err := model.FormatRawAmount(swap.Amount, swap.Decimals)
+			if err != nil {
+				invalid++
+				continue
+			}
+			if err := engine.db.Model(model.ArchivedSwap{}).Where("id = ?", swap.ID).UpdateColumn("amount_decimal", amountDecimal).Error; err != nil {
+				util.Logger.Errorf("fill decimal amount of archived swap %d error: %s", swap.ID, err.Error())
+				return
+			}
+			filled++
+		}
+	}
+
+	if err := model.MarkMigrationApplied(engine.db, FillAmountDecimalsMigration); err != nil {
+		util.Logger.Errorf("mark migration %s applied error: %s", FillAmountDecimalsMigration, err.Error())
+		return
+	}
+	util.Logger.Infof("decimal amount backfill is done, %d swaps filled, %d swaps with an invalid amount", filled, invalid)
+}
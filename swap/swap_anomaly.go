@@ -0,0 +1,181 @@
+package swap
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+// AnomalyDetectorOperator is the operator recorded for the pairs paused by the anomaly detector
+const AnomalyDetectorOperator = "anomaly_detector"
+
+// pairActivity is the number and the volume of the swaps of a pair in a period
+type pairActivity struct {
+	count  int64
+	volume *big.Int
+}
+
+func (a *pairActivity) add(amount string) {
+	a.count++
+	if value, ok := big.NewInt(0).SetString(amount, 10); ok {
+		a.volume.Add(a.volume, value)
+	}
+}
+
+func newPairActivity() *pairActivity {
+	return &pairActivity{volume: big.NewInt(0)}
+}
+
+// PausePair stops filling the swaps of the pair until it is resumed, the pause survives restarts
+func (engine *SwapEngine) PausePair(erc20Addr ethcom.Address, reason, operator string) error {
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+
+	if engine.pausedPairs[erc20Addr] {
+		return nil
+	}
+	pausedPair := model.PausedPair{
+		ERC20Addr: erc20Addr.String(),
+		Reason:    reason,
+		Operator:  operator,
+	}
+	if err := engine.store.CreatePausedPair(&pausedPair); err != nil {
+		return err
+	}
+	engine.pausedPairs[erc20Addr] = true
+	util.Logger.Infof("swap pair %s paused by %s: %s", erc20Addr.String(), operator, reason)
+	return nil
+}
+
+// ResumePair fills the swaps of the paused pair again
+func (engine *SwapEngine) ResumePair(erc20Addr ethcom.Address, operator string) error {
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+
+	if !engine.pausedPairs[erc20Addr] {
+		return fmt.Errorf("swap pair %s is not paused", erc20Addr.String())
+	}
+	if err := engine.store.DeletePausedPair(erc20Addr.String()); err != nil {
+		return err
+	}
+	delete(engine.pausedPairs, erc20Addr)
+	util.Logger.Infof("swap pair %s resumed by %s", erc20Addr.String(), operator)
+	return nil
+}
+
+func (engine *SwapEngine) IsPairPaused(erc20Addr ethcom.Address) bool {
+	engine.mutex.RLock()
+	defer engine.mutex.RUnlock()
+
+	return engine.pausedPairs[erc20Addr]
+}
+
+// getPausedPairs returns the erc20 addresses of the paused pairs as they are saved in the swaps
+func (engine *SwapEngine) getPausedPairs() []string {
+	engine.mutex.RLock()
+	defer engine.mutex.RUnlock()
+
+	pairs := make([]string, 0, len(engine.pausedPairs))
+	for erc20Addr := range engine.pausedPairs {
+		pairs = append(pairs, erc20Addr.String())
+	}
+	return pairs
+}
+
+// anomalyDetectorDaemon is a tripwire against exploits, it pauses the pairs whose swap rate or volume spikes
+func (engine *SwapEngine) anomalyDetectorDaemon() error {
+	engine.detectAnomalies(time.Now())
+	return nil
+}
+
+// checkVolumeLimit returns why the usd value of the swaps of the pair in the last window is over the limit, the
+// volume is not checked if the token can't be valued
+func (engine *SwapEngine) checkVolumeLimit(erc20Addr ethcom.Address, activity *pairActivity, window time.Duration) string {
+	maxVolumeUsd := engine.config.AnomalyConfig.MaxVolumeUsd
+	if maxVolumeUsd <= 0 {
+		return ""
+	}
+	value, err := engine.prices.TokenValue(erc20Addr.String(), activity.volume)
+	if err != nil {
+		util.Logger.Errorf("value volume of swap pair %s error: %s", erc20Addr.String(), err.Error())
+		return ""
+	}
+	if value <= maxVolumeUsd {
+		return ""
+	}
+	return fmt.Sprintf("volume of %.2f usd in the last %s, the limit is %.2f usd", value, window, maxVolumeUsd)
+}
+
+// checkTrailingAverage returns why the swaps of the pair in the last window spike against the trailing average
+func checkTrailingAverage(cfg util.AnomalyConfig, activity, average *pairActivity, window time.Duration, windows float64) string {
+	if average == nil {
+		average = newPairActivity()
+	}
+	averageCount := float64(average.count) / windows
+	averageVolume := new(big.Float).Quo(new(big.Float).SetInt(average.volume), big.NewFloat(windows))
+
+	if cfg.RateMultiple > 0 && float64(activity.count) > cfg.RateMultiple*averageCount {
+		return fmt.Sprintf("%d swaps in the last %s, the trailing average is %.2f", activity.count, window, averageCount)
+	}
+	if volume := new(big.Float).SetInt(activity.volume); cfg.VolumeMultiple > 0 &&
+		volume.Cmp(new(big.Float).Mul(averageVolume, big.NewFloat(cfg.VolumeMultiple))) > 0 {
+		return fmt.Sprintf("volume %s in the last %s, the trailing average is %s", activity.volume.String(), window, averageVolume.Text('f', 0))
+	}
+	return ""
+}
+
+func (engine *SwapEngine) detectAnomalies(now time.Time) {
+	cfg := engine.config.AnomalyConfig
+	window, trailingPeriod := cfg.GetWindow(), cfg.GetTrailingPeriod()
+	windowStart := now.Add(-window)
+
+	swaps, err := engine.store.FindSwapActivity(windowStart.Add(-trailingPeriod))
+	if err != nil {
+		util.Logger.Errorf("query swaps for anomaly detection error: %s", err.Error())
+		return
+	}
+
+	recent := make(map[ethcom.Address]*pairActivity)
+	trailing := make(map[ethcom.Address]*pairActivity)
+	for _, swap := range swaps {
+		activities := trailing
+		if !swap.CreatedAt.Before(windowStart) {
+			activities = recent
+		}
+		erc20Addr := ethcom.HexToAddress(swap.ERC20Addr)
+		if activities[erc20Addr] == nil {
+			activities[erc20Addr] = newPairActivity()
+		}
+		activities[erc20Addr].add(swap.Amount)
+	}
+
+	// the trailing activity is averaged to the length of a window
+	windows := float64(trailingPeriod) / float64(window)
+	for erc20Addr, activity := range recent {
+		if engine.IsPairPaused(erc20Addr) {
+			continue
+		}
+		reason := engine.checkVolumeLimit(erc20Addr, activity, window)
+		if reason == "" && activity.count >= cfg.GetMinSwaps() {
+			reason = checkTrailingAverage(cfg, activity, trailing[erc20Addr], window, windows)
+		}
+		if reason == "" {
+			continue
+		}
+
+		if err := engine.PausePair(erc20Addr, reason, AnomalyDetectorOperator); err != nil {
+			util.Logger.Errorf("pause swap pair %s error: %s", erc20Addr.String(), err.Error())
+			util.SendTelegramMessage(fmt.Sprintf("Urgent alert: anomaly of swap pair %s detected but the pair can't be paused, %s, err: %s",
+				erc20Addr.String(), reason, err.Error()))
+			continue
+		}
+		util.Logger.Errorf("anomaly of swap pair %s detected, the pair is paused until the admin resumes it, %s", erc20Addr.String(), reason)
+		util.SendTelegramMessage(fmt.Sprintf("Urgent alert: anomaly of swap pair %s detected, the pair is paused until the admin resumes it, %s",
+			erc20Addr.String(), reason))
+	}
+}
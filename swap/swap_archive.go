@@ -0,0 +1,47 @@
+package swap
+
+import (
+	"fmt"
+	"time"
+
+	"occ-swap-server/common"
+	"occ-swap-server/util"
+)
+
+// archivedSwapStatuses are the statuses of the swaps which are never updated again, only these swaps are archived
+var archivedSwapStatuses = []common.SwapStatus{SwapSuccess, SwapQuoteRejected}
+
+// archiveSwapsDaemon moves the old swaps to the archive tables, so the daemons scanning the hot tables stay fast as
+// the swaps pile up. The batches are archived one after another until no old swap is left.
+func (engine *SwapEngine) archiveSwapsDaemon() error {
+	before := time.Now().Add(-engine.config.ArchiveConfig.GetRetention())
+	total := 0
+	for {
+		archived, err := engine.archiveSwaps(before)
+		if err != nil {
+			util.Logger.Errorf("archive swaps error: %s", err.Error())
+			util.SendTelegramMessage(fmt.Sprintf("archive swaps error: %s", err.Error()))
+			break
+		}
+		total += archived
+		if int64(archived) < engine.config.ArchiveConfig.GetBatchSize() {
+			break
+		}
+	}
+	if total != 0 {
+		util.Logger.Infof("archive %d swaps updated before %s", total, before.String())
+	}
+	return nil
+}
+
+// archiveSwaps moves a batch of the swaps which are finished before the given time to the archive tables, with
+// their start tx logs and fill txs. The swaps which still have an active retry swap are kept.
+func (engine *SwapEngine) archiveSwaps(before time.Time) (int, error) {
+	archived := 0
+	err := engine.store.Transaction(func(tx SwapStore) error {
+		var err error
+		archived, err = tx.ArchiveSwaps(archivedSwapStatuses, before, int(engine.config.ArchiveConfig.GetBatchSize()))
+		return err
+	})
+	return archived, err
+}
@@ -0,0 +1,170 @@
+package swap
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"occ-swap-server/common"
+	"occ-swap-server/executor"
+)
+
+// UnsignedTx is a tx the wallet of the user signs and sends as it is, the gas is left to the wallet
+type UnsignedTx struct {
+	ChainID string `json:"chain_id"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	// value in wei, the swap fee of the swap tx
+	Value string `json:"value"`
+	Data  string `json:"data"`
+}
+
+// SwapTxs are the txs starting a swap, the approve tx is only set if the allowance of the owner to the swap agent is
+// less than the amount, it is sent and mined before the swap tx
+type SwapTxs struct {
+	Direction string      `json:"direction"`
+	Symbol    string      `json:"symbol"`
+	Token     string      `json:"token"`
+	SwapAgent string      `json:"swap_agent"`
+	Amount    string      `json:"amount"`
+	SwapFee   string      `json:"swap_fee"`
+	Allowance string      `json:"allowance"`
+	Approve   *UnsignedTx `json:"approve,omitempty"`
+	Swap      UnsignedTx  `json:"swap"`
+}
+
+// swapStart is a swap of the amount of the pair the owner starts on the chain, checked to be filled
+type swapStart struct {
+	direction common.SwapDirection
+	pair      *SwapPairIns
+	token     ethcom.Address
+	swapAgent ethcom.Address
+	chainID   int64
+	toChainID *big.Int
+	amount    *big.Int
+	swapFee   *big.Int
+}
+
+// checkSwapStart rejects the swaps which would not be filled, e.g. of a paused direction or out of the bounds of the
+// pair, and the swaps of more than the balance of the owner
+func (engine *SwapEngine) checkSwapStart(chain string, erc20Addr ethcom.Address, amount *big.Int, toChainId string,
+	owner ethcom.Address) (*swapStart, error) {
+	direction, err := engine.getSwapDirection(chain, toChainId)
+	if err != nil {
+		return nil, err
+	}
+	if engine.IsDirectionPaused(direction) {
+		return nil, fmt.Errorf("direction %s is paused", direction)
+	}
+	pair, err := engine.GetSwapPairInstance(erc20Addr)
+	if err != nil {
+		return nil, err
+	}
+	if !pair.Available || isNFTSwap(pair.AssetType) {
+		return nil, fmt.Errorf("swap pair %s is not available for the token swaps", pair.Symbol)
+	}
+	if engine.IsPairPaused(erc20Addr) {
+		return nil, fmt.Errorf("swap pair %s is paused", pair.Symbol)
+	}
+	if amount.Cmp(pair.LowBound) < 0 || amount.Cmp(pair.UpperBound) > 0 {
+		return nil, fmt.Errorf("amount %s is out of the bounds of swap pair %s, from %s to %s", amount.String(),
+			pair.Symbol, pair.LowBound.String(), pair.UpperBound.String())
+	}
+
+	// the swap tx deposits the token of the swap agent, it must be the token of the pair on the chain
+	pairToken := pair.ERC20Addr
+	if chain == common.ChainBSC {
+		pairToken = pair.BEP20Addr
+	}
+	token, err := engine.getAgentToken(chain)
+	if err != nil {
+		return nil, err
+	}
+	if token != pairToken {
+		return nil, fmt.Errorf("token %s of swap pair %s on %s is not the token %s of the swap agent", pairToken.String(),
+			pair.Symbol, chain, token.String())
+	}
+	toChainID, ok := big.NewInt(0).SetString(toChainId, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid to chain id %s", toChainId)
+	}
+
+	balance := big.NewInt(0)
+	if err := engine.callToken(chain, token, &erc20TokenABI, &balance, "balanceOf", owner); err != nil {
+		return nil, fmt.Errorf("query balance of %s error: %s", owner.String(), err.Error())
+	}
+	if balance.Cmp(amount) < 0 {
+		return nil, fmt.Errorf("balance %s of the owner is less than the amount", balance.String())
+	}
+	swapFee, err := engine.querySwapFee(chain)
+	if err != nil {
+		return nil, fmt.Errorf("query swap fee of %s error: %s", chain, err.Error())
+	}
+	return &swapStart{
+		direction: direction,
+		pair:      pair,
+		token:     token,
+		swapAgent: engine.getSwapAgent(chain),
+		chainID:   engine.getChainID(chain),
+		toChainID: toChainID,
+		amount:    amount,
+		swapFee:   swapFee,
+	}, nil
+}
+
+// BuildSwapTxs builds the txs the owner sends to the swap agent of the chain to swap the amount of the pair to the
+// chain id, so the frontends don't encode the calls of the swap agent themselves. The memo, if any, is appended to
+// the call data of the swap tx. The swaps which would not be filled are rejected, see checkSwapStart.
+func (engine *SwapEngine) BuildSwapTxs(chain string, erc20Addr ethcom.Address, amount *big.Int, toChainId string, owner ethcom.Address,
+	memo string) (*SwapTxs, error) {
+	if err := executor.CheckSwapMemo(memo); err != nil {
+		return nil, err
+	}
+	start, err := engine.checkSwapStart(chain, erc20Addr, amount, toChainId, owner)
+	if err != nil {
+		return nil, err
+	}
+	allowance := big.NewInt(0)
+	if err := engine.callToken(chain, start.token, &erc20TokenABI, &allowance, "allowance", owner, start.swapAgent); err != nil {
+		return nil, fmt.Errorf("query allowance of %s error: %s", owner.String(), err.Error())
+	}
+	data, err := engine.swapAgentABI.Pack("swap", big.NewInt(start.chainID), start.toChainID, amount)
+	if err != nil {
+		return nil, err
+	}
+	data = append(data, []byte(memo)...)
+
+	txs := &SwapTxs{
+		Direction: string(start.direction),
+		Symbol:    start.pair.Symbol,
+		Token:     start.token.String(),
+		SwapAgent: start.swapAgent.String(),
+		Amount:    amount.String(),
+		SwapFee:   start.swapFee.String(),
+		Allowance: allowance.String(),
+		Swap: UnsignedTx{
+			ChainID: strconv.FormatInt(start.chainID, 10),
+			From:    owner.String(),
+			To:      start.swapAgent.String(),
+			Value:   start.swapFee.String(),
+			Data:    hexutil.Encode(data),
+		},
+	}
+	if allowance.Cmp(amount) < 0 {
+		approveData, err := erc20TokenABI.Pack("approve", start.swapAgent, amount)
+		if err != nil {
+			return nil, err
+		}
+		txs.Approve = &UnsignedTx{
+			ChainID: txs.Swap.ChainID,
+			From:    owner.String(),
+			To:      start.token.String(),
+			Value:   "0",
+			Data:    hexutil.Encode(approveData),
+		}
+	}
+	return txs, nil
+}
@@ -0,0 +1,120 @@
+package swap
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+// SwapClaimLease is how long the swaps picked by an executor replica are claimed by it, the swaps of a replica which
+// stops while filling them are picked by the other replicas once the claim expires
+const SwapClaimLease = 10 * time.Minute
+
+// swapClaimRenewInterval is how often the claim of a swap is renewed while its fill tx is broadcast, well within the
+// lease
+var swapClaimRenewInterval = SwapClaimLease / 3
+
+// getInstanceID returns the id of the executor replica, the pid is part of it so a restarted replica gets a new id,
+// the swaps claimed before the restart are picked again once their claims expire
+func getInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s:%d", hostname, os.Getpid())
+}
+
+// claimableBy selects the rows which are not claimed, or whose claim is expired or held by the instance
+func claimableBy(query *gorm.DB, instanceID string) *gorm.DB {
+	return query.Where("claimed_until < ? or claimed_by = ?", time.Now().Unix(), instanceID)
+}
+
+// claimRows claims the rows of the table for the instance until claimedUntil in the tx and returns the ids of the
+// rows it claims. The selected rows are only locked on postgres, so the update checks again that the rows are of
+// the statuses and claimable, two replicas selecting the same rows on mysql claim every row once. The columns are
+// updated without touching updated_at, which the watchdog uses to find the lingering swaps.
+func claimRows(tx *gorm.DB, value interface{}, ids []uint, statuses interface{}, instanceID string, claimedUntil int64) (map[uint]bool, error) {
+	claimed := make(map[uint]bool, len(ids))
+	if len(ids) == 0 {
+		return claimed, nil
+	}
+	err := claimableBy(tx.Model(value).Where("id in (?) and status in (?)", ids, statuses), instanceID).UpdateColumns(
+		map[string]interface{}{
+			"claimed_by":    instanceID,
+			"claimed_until": claimedUntil,
+		}).Error
+	if err != nil {
+		return nil, err
+	}
+	claimedIDs := make([]uint, 0, len(ids))
+	err = tx.Model(value).Where("id in (?) and claimed_by = ? and claimed_until = ?", ids, instanceID, claimedUntil).
+		Pluck("id", &claimedIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range claimedIDs {
+		claimed[id] = true
+	}
+	return claimed, nil
+}
+
+// renewSwapClaim renews the claim of the instance on the swap in the tx, right before the swap is saved. It is false
+// if the swap is not of its status any more or the claim is expired or taken by another replica, which may fill the
+// swap then, so the stale swap is skipped. The update locks the row until the tx ends.
+func (engine *SwapEngine) renewSwapClaim(tx SwapStore, swap *model.Swap) (bool, error) {
+	claimedUntil := time.Now().Add(SwapClaimLease).Unix()
+	// mysql counts the changed rows only, so the claim is always moved forward
+	if claimedUntil <= swap.ClaimedUntil {
+		claimedUntil = swap.ClaimedUntil + 1
+	}
+	renewed, err := tx.RenewSwapClaim(swap.ID, swap.Status, engine.instanceID, claimedUntil)
+	if err != nil || !renewed {
+		return false, err
+	}
+	swap.ClaimedBy = engine.instanceID
+	swap.ClaimedUntil = claimedUntil
+	return true, nil
+}
+
+// keepSwapClaim renews the claim of the instance on the swap until the returned func is called, the broadcast of the
+// fill tx may wait longer than the lease for a nonce of the relayer accounts and another replica must not pick the
+// swap meanwhile. The func stops the renewals and moves the claim of the swap to the last renewal, so the swap is
+// saved with it.
+func (engine *SwapEngine) keepSwapClaim(swap *model.Swap) func() {
+	claim := *swap
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(swapClaimRenewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			renewed, err := engine.renewSwapClaim(engine.store, &claim)
+			if err != nil {
+				util.Logger.Errorf("renew claim of swap error, start tx hash %s, err: %s", claim.StartTxHash, err.Error())
+				continue
+			}
+			if !renewed {
+				util.Logger.Errorf("claim of swap is lost while its fill tx is broadcast, start tx hash %s", claim.StartTxHash)
+				util.SendTelegramMessage(fmt.Sprintf("Urgent alert: claim of swap is lost while its fill tx is broadcast, start tx hash %s",
+					claim.StartTxHash))
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		swap.ClaimedUntil = claim.ClaimedUntil
+	}
+}
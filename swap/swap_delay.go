@@ -0,0 +1,82 @@
+package swap
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"occ-swap-server/common"
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+func (engine *SwapEngine) getSwapDelay(swap *model.Swap) time.Duration {
+	amount, ok := big.NewInt(0).SetString(swap.Amount, 10)
+	if !ok {
+		return 0
+	}
+	return engine.config.ChainConfig.GetSwapDelay(swap.Direction, amount, func() (float64, error) {
+		return engine.prices.TokenValue(swap.ERC20Addr, amount)
+	})
+}
+
+// releaseDelayedSwapsDaemon makes the delayed swaps eligible for fill once their delay is expired
+func (engine *SwapEngine) releaseDelayedSwapsDaemon() error {
+	swaps, err := engine.store.FindSwaps(SwapQuery{Statuses: []common.SwapStatus{SwapDelayed}, DelayedBy: time.Now().Unix(), Limit: BatchSize})
+	if err != nil {
+		return err
+	}
+
+	for _, swap := range swaps {
+		util.Logger.Infof("delay of swap is expired, start tx hash %s", swap.StartTxHash)
+		writeDBErr := engine.store.Transaction(func(tx SwapStore) error {
+			if !engine.verifySwap(&swap) {
+				return fmt.Errorf("verify hmac of swap failed: %s", swap.StartTxHash)
+			}
+			swap.Status = SwapConfirmed
+			return engine.updateSwap(tx, &swap)
+		})
+		if writeDBErr != nil {
+			util.Logger.Errorf("write db error: %s", writeDBErr.Error())
+			util.SendTelegramMessage(fmt.Sprintf("write db error: %s", writeDBErr.Error()))
+		}
+	}
+	return nil
+}
+
+func (engine *SwapEngine) getDelayedSwap(tx SwapStore, startTxHash string) (*model.Swap, error) {
+	swap, err := engine.getSwapByStartTxHash(tx, startTxHash)
+	if err != nil {
+		return nil, err
+	}
+	if swap.Status != SwapDelayed {
+		return nil, fmt.Errorf("swap %s is not delayed, status %s", startTxHash, swap.Status)
+	}
+	return swap, nil
+}
+
+// ExpediteDelayedSwap makes the delayed swap eligible for fill immediately
+func (engine *SwapEngine) ExpediteDelayedSwap(startTxHash string) error {
+	return engine.store.Transaction(func(tx SwapStore) error {
+		swap, err := engine.getDelayedSwap(tx, startTxHash)
+		if err != nil {
+			return err
+		}
+		swap.Status = SwapConfirmed
+		swap.Log = "delayed swap is expedited by admin"
+		return engine.updateSwap(tx, swap)
+	})
+}
+
+// CancelDelayedSwap rejects the delayed swap so that it will never be filled, canceller is the admin or the sponsor
+func (engine *SwapEngine) CancelDelayedSwap(startTxHash, reason, canceller string) error {
+	return engine.store.Transaction(func(tx SwapStore) error {
+		swap, err := engine.getDelayedSwap(tx, startTxHash)
+		if err != nil {
+			return err
+		}
+		swap.Status = SwapQuoteRejected
+		swap.Log = fmt.Sprintf("delayed swap is cancelled by %s: %s", canceller, reason)
+		return engine.updateSwap(tx, swap)
+	})
+}
@@ -0,0 +1,167 @@
+package swap
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+// earlierFill is a fill tx on the destination chain sent by a relayer account which already paid the recipient of a
+// swap
+type earlierFill struct {
+	tx     *types.Transaction
+	sender ethcom.Address
+}
+
+// findEarlierFill searches the SwapFilled events of the last lookback blocks of the destination chain for a fill of
+// the swap, it is nil if there is none or the fills to the chain are not checked. The swap agent keeps no record of
+// the filled start txs, so a fill matches if it pays the recipient the amount of the swap, to the chain id of the
+// swap, after the swap is created, from a relayer account, and its tx isn't recorded for any swap. The erc721 swaps
+// are not searched, the swap agent can't send the same token twice.
+func (engine *SwapEngine) findEarlierFill(swap *model.Swap) (*earlierFill, error) {
+	destChain := getDestChain(swap.Direction)
+	lookback := engine.config.DuplicateFillConfig.GetLookbackBlocks(destChain)
+	if lookback <= 0 || isNFTSwap(swap.AssetType) {
+		return nil, nil
+	}
+	client := engine.getClient(destChain)
+	// the latest head rather than the tracked one, the fill may be in the last block
+	head, err := client.HeaderByNumber(context.Background(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("get %s head error: %s", destChain, err.Error())
+	}
+	height := head.Number.Int64()
+	fromHeight := height - lookback + 1
+	if fromHeight < 0 {
+		fromHeight = 0
+	}
+
+	decoder := engine.eventDecoders[destChain]
+	recipient := ethcom.HexToAddress(swap.GetRecipient())
+	for from := fromHeight; from <= height; from += RecoveryLogsBatch {
+		to := from + RecoveryLogsBatch - 1
+		if to > height {
+			to = height
+		}
+		logs, err := client.FilterLogs(context.Background(), ethereum.FilterQuery{
+			FromBlock: big.NewInt(from),
+			ToBlock:   big.NewInt(to),
+			Addresses: []ethcom.Address{engine.getSwapAgent(destChain)},
+			Topics:    [][]ethcom.Hash{decoder.SwapFilledTopics()},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("filter %s logs from %d to %d error: %s", destChain, from, to, err.Error())
+		}
+		for i := range logs {
+			log := &logs[i]
+			if log.Removed {
+				continue
+			}
+			event, err := decoder.DecodeSwapFilled(log)
+			if err != nil {
+				continue
+			}
+			if !engine.isFillOfSwapChains(event.FromChainId, event.ToChainId, swap) || event.ToAddress != recipient || event.Amount.String() != swap.Amount {
+				continue
+			}
+			fill, err := engine.getEarlierFill(destChain, swap, log)
+			if err != nil {
+				return nil, err
+			}
+			if fill != nil {
+				return fill, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// getEarlierFill returns the fill tx of the matching log, nil if the tx is recorded, mined before the swap is created
+// or not sent by a relayer account. Anyone can call the swap agent with the same event, e.g. a former relayer key,
+// so the fills of the other accounts are never taken for the fill of the swap.
+func (engine *SwapEngine) getEarlierFill(destChain string, swap *model.Swap, log *types.Log) (*earlierFill, error) {
+	txHash := log.TxHash.String()
+	if recorded, err := engine.store.IsFillTxRecorded(txHash); err != nil || recorded {
+		return nil, err
+	}
+
+	client := engine.getClient(destChain)
+	header, err := client.HeaderByNumber(context.Background(), big.NewInt(int64(log.BlockNumber)))
+	if err != nil {
+		return nil, fmt.Errorf("get %s header %d error: %s", destChain, log.BlockNumber, err.Error())
+	}
+	if int64(header.Time) < swap.CreatedAt.Unix() {
+		return nil, nil
+	}
+	tx, _, err := client.TransactionByHash(context.Background(), log.TxHash)
+	if err != nil {
+		return nil, fmt.Errorf("get %s fill tx %s error: %s", destChain, txHash, err.Error())
+	}
+	sender, err := types.Sender(types.NewEIP155Signer(big.NewInt(engine.getChainID(destChain))), tx)
+	if err != nil {
+		return nil, fmt.Errorf("get sender of %s fill tx %s error: %s", destChain, txHash, err.Error())
+	}
+	if pool := engine.relayerPools[destChain]; pool == nil || pool.getBroadcaster(sender) == nil {
+		util.Logger.Warningf("fill tx %s on %s matches swap %s but is sent by %s, which is not a relayer account", txHash,
+			destChain, swap.StartTxHash, sender.String())
+		return nil, nil
+	}
+	return &earlierFill{tx: tx, sender: sender}, nil
+}
+
+// holdEarlierFill holds the swap as a mismatch instead of sending another fill tx, the earlier fill is not linked to
+// the swap, the operators check it and mark the swap as filled by it. It is false if the claim of the swap is lost,
+// the swap is left to the replica holding it then.
+func (engine *SwapEngine) holdEarlierFill(swap *model.Swap, fill *earlierFill) (bool, error) {
+	claimLost := false
+	err := engine.store.Transaction(func(tx SwapStore) error {
+		if renewed, err := engine.renewSwapClaim(tx, swap); err != nil {
+			return err
+		} else if !renewed {
+			claimLost = true
+			return nil
+		}
+		swap.Status = SwapMismatch
+		swap.Log = fmt.Sprintf("earlier fill tx %s of relayer %s found on %s, check it and mark the swap as filled by it",
+			fill.tx.Hash().String(), fill.sender.String(), getDestChain(swap.Direction))
+		return engine.updateSwap(tx, swap)
+	})
+	return err == nil && !claimLost, err
+}
+
+// skipFilledSwap holds the swap if there is an earlier fill of it, it returns true if the swap should not be filled
+// now, the search is tried again in the next round if it fails
+func (engine *SwapEngine) skipFilledSwap(swap *model.Swap) bool {
+	fill, err := engine.findEarlierFill(swap)
+	if err != nil {
+		util.Logger.Errorf("search earlier fill of swap error, start tx hash %s, err: %s", swap.StartTxHash, err.Error())
+		return true
+	}
+	if fill == nil {
+		return false
+	}
+	held, err := engine.holdEarlierFill(swap, fill)
+	if err != nil {
+		util.Logger.Errorf("write db error: %s", err.Error())
+		util.SendTelegramMessage(fmt.Sprintf("write db error: %s", err.Error()))
+		return true
+	}
+	if !held {
+		util.Logger.Infof("claim of swap is lost, the earlier fill tx %s is left to the replica holding it, start tx hash %s",
+			fill.tx.Hash().String(), swap.StartTxHash)
+		return true
+	}
+	util.Logger.Errorf("swap is held, earlier fill tx %s of relayer %s found, start tx hash %s", fill.tx.Hash().String(),
+		fill.sender.String(), swap.StartTxHash)
+	util.SendTelegramMessage(fmt.Sprintf("Urgent alert: swap is held, earlier fill tx %s of relayer %s found, it is not filled again, check the fill and mark the swap as filled, start tx hash %s %s",
+		fill.tx.Hash().String(), fill.sender.String(), swap.StartTxHash,
+		engine.config.ExplorerLink(getDestChain(swap.Direction), util.ExplorerTx, fill.tx.Hash().String())))
+	return true
+}
@@ -0,0 +1,150 @@
+package swap
+
+import (
+	"fmt"
+
+	"occ-swap-server/common"
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+// NormalizeEnumsMigration is the data migration normalizing the statuses and directions written before they were
+// checked against the registries, e.g. in upper case
+const NormalizeEnumsMigration = "normalize_enums"
+
+// normalizeEnums runs the enum repair once, then replaces the enum constraints whose values changed if they are
+// enabled. A constraint is only replaced by the replicas knowing the new values, so it never rejects the values
+// written by the replicas of the previous release.
+func (engine *SwapEngine) normalizeEnums() {
+	if !model.IsMigrationApplied(engine.db, NormalizeEnumsMigration) {
+		report := &repairReport{}
+		engine.repairSwapEnums(report)
+		engine.repairRetrySwapDirections(report)
+		engine.repairSwapFillTxDirections(report)
+		if err := model.MarkMigrationApplied(engine.db, NormalizeEnumsMigration); err != nil {
+			util.Logger.Errorf("mark migration %s applied error: %s", NormalizeEnumsMigration, err.Error())
+			return
+		}
+		util.Logger.Infof("enum repair is done, %d rows repaired, %d rows invalid, %d swaps not verified",
+			report.repaired, report.invalid, report.unverified)
+		if report.invalid > 0 || report.unverified > 0 {
+			util.SendTelegramMessage(fmt.Sprintf("Urgent alert: enum repair left %d rows of unknown statuses or directions and %d swaps whose record hashes don't match, check the logs",
+				report.invalid, report.unverified))
+		}
+	}
+
+	if !engine.config.DBConfig.EnumConstraints {
+		return
+	}
+	dialect := engine.db.Dialect().GetName()
+	for _, constraint := range model.EnumConstraints() {
+		if model.IsMigrationApplied(engine.db, constraint.Version(dialect)) {
+			continue
+		}
+		if err := model.ReplaceEnumConstraint(engine.db, constraint); err != nil {
+			util.Logger.Errorf("%s", err.Error())
+			continue
+		}
+		if err := model.MarkMigrationApplied(engine.db, constraint.Version(dialect)); err != nil {
+			util.Logger.Errorf("mark migration %s applied error: %s", constraint.Version(dialect), err.Error())
+		}
+	}
+}
+
+func (engine *SwapEngine) repairSwapEnums(report *repairReport) {
+	var lastID uint
+	for {
+		swaps, err := engine.store.FindSwapsOfUnknownEnums(lastID, BatchSize)
+		if err != nil {
+			util.Logger.Errorf("repair swaps error: %s", err.Error())
+			return
+		}
+		if len(swaps) == 0 {
+			return
+		}
+		for i := range swaps {
+			swap := &swaps[i]
+			lastID = swap.ID
+
+			status, err := common.ParseSwapStatus(string(swap.Status))
+			direction := swap.Direction
+			if err == nil && direction != "" {
+				direction, err = common.ParseSwapDirection(string(direction))
+			}
+			if err != nil {
+				report.invalid++
+				util.Logger.Errorf("repair swap %d error: %s", swap.ID, err.Error())
+				continue
+			}
+			if !engine.verifySwap(swap) {
+				report.unverified++
+				util.Logger.Errorf("repair swap %d error: record hash doesn't match, start tx hash %s", swap.ID, swap.StartTxHash)
+				continue
+			}
+			// the status is only spelled otherwise, no webhook or bridge event is sent
+			swap.Status, swap.Direction = status, direction
+			swap.RecordHash = engine.getSwapHMAC(swap)
+			if err := engine.store.SaveSwap(swap); err != nil {
+				util.Logger.Errorf("repair swap %d error: %s", swap.ID, err.Error())
+				continue
+			}
+			report.repaired++
+		}
+	}
+}
+
+func (engine *SwapEngine) repairRetrySwapDirections(report *repairReport) {
+	var lastID uint
+	for {
+		retrySwaps, err := engine.store.FindRetrySwapsOfUnknownDirections(lastID, BatchSize)
+		if err != nil {
+			util.Logger.Errorf("repair retry swaps error: %s", err.Error())
+			return
+		}
+		if len(retrySwaps) == 0 {
+			return
+		}
+		for i := range retrySwaps {
+			retrySwap := &retrySwaps[i]
+			lastID = retrySwap.ID
+
+			direction, err := common.ParseSwapDirection(string(retrySwap.Direction))
+			if err != nil {
+				report.invalid++
+				util.Logger.Errorf("repair retry swap %d error: %s", retrySwap.ID, err.Error())
+				continue
+			}
+			if !engine.verifyRetrySwap(retrySwap) {
+				report.unverified++
+				util.Logger.Errorf("repair retry swap %d error: record hash doesn't match, start tx hash %s", retrySwap.ID, retrySwap.StartTxHash)
+				continue
+			}
+			retrySwap.Direction = direction
+			engine.updateRetrySwap(engine.store, retrySwap)
+			report.repaired++
+		}
+	}
+}
+
+// repairSwapFillTxDirections normalizes the directions of the fill txs by value, they have no record hash
+func (engine *SwapEngine) repairSwapFillTxDirections(report *repairReport) {
+	values, err := engine.store.FindUnknownFillTxDirections()
+	if err != nil {
+		util.Logger.Errorf("repair fill txs error: %s", err.Error())
+		return
+	}
+	for _, value := range values {
+		direction, err := common.ParseSwapDirection(value)
+		if err != nil {
+			report.invalid++
+			util.Logger.Errorf("repair fill txs error: %s", err.Error())
+			continue
+		}
+		renamed, err := engine.store.RenameFillTxDirection(value, direction)
+		if err != nil {
+			util.Logger.Errorf("repair fill txs of direction %q error: %s", value, err.Error())
+			continue
+		}
+		report.repaired += int(renamed)
+	}
+}
@@ -0,0 +1,77 @@
+package swap
+
+import (
+	"fmt"
+	"time"
+
+	"occ-swap-server/common"
+	"occ-swap-server/util"
+)
+
+// expirableSwapStatuses are the statuses of the swaps without a fill tx in flight, the swaps keeping one of them
+// past the max age are expired. The rejected swaps are not refund eligible, they are never expired.
+var expirableSwapStatuses = []common.SwapStatus{SwapConfirmed, SwapDelayed, SwapAwaitingLiquidity, SwapAwaitingWindow,
+	SwapAwaitingGas, SwapUneconomic, SwapSendFailed, SwapAbandoned}
+
+// getExpiryCutoff returns the creation time before which the swaps are expired, false if the swaps never expire
+func (engine *SwapEngine) getExpiryCutoff() (time.Time, bool) {
+	if !engine.config.ExpiryConfig.Enabled() {
+		return time.Time{}, false
+	}
+	return time.Now().Add(-engine.config.ExpiryConfig.GetMaxAge()), true
+}
+
+// expireSwapsDaemon expires the swaps which couldn't be filled within the max age. The swaps claimed by an
+// instance or with an active retry swap may have a fill tx in flight, they are expired once it fails.
+func (engine *SwapEngine) expireSwapsDaemon() error {
+	cutoff, ok := engine.getExpiryCutoff()
+	if !ok {
+		return nil
+	}
+	expired, err := engine.expireSwaps(cutoff)
+	if err != nil {
+		util.Logger.Errorf("expire swaps error: %s", err.Error())
+		util.SendTelegramMessage(fmt.Sprintf("expire swaps error: %s", err.Error()))
+		return err
+	}
+	if expired == 0 {
+		return nil
+	}
+	util.Logger.Infof("expire %d swaps confirmed before %s", expired, cutoff.Format(time.RFC3339))
+	util.SendTelegramMessage(fmt.Sprintf("%d swaps confirmed before %s are expired without being filled, refund them",
+		expired, cutoff.Format(time.RFC3339)))
+	if expired == BatchSize {
+		return util.RunAgain
+	}
+	return nil
+}
+
+func (engine *SwapEngine) expireSwaps(cutoff time.Time) (int, error) {
+	expired := 0
+	err := engine.store.Transaction(func(tx SwapStore) error {
+		swaps, err := tx.LockExpirableSwaps(expirableSwapStatuses, cutoff, BatchSize)
+		if err != nil {
+			return err
+		}
+		for i := range swaps {
+			swap := &swaps[i]
+			if !engine.verifySwap(swap) {
+				util.Logger.Errorf("verify hmac of swap failed, it is not expired, start tx hash %s", swap.StartTxHash)
+				continue
+			}
+			swap.Log = fmt.Sprintf("expired in status %s, not filled within %s, refund eligible", swap.Status,
+				engine.config.ExpiryConfig.GetMaxAge().String())
+			swap.Status = SwapExpired
+			swap.NextRetryAt = 0
+			if err := engine.updateSwap(tx, swap); err != nil {
+				return err
+			}
+			expired++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return expired, nil
+}
@@ -0,0 +1,148 @@
+package swap
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+
+	"occ-swap-server/model"
+)
+
+const FeeAuditBatchSize = 1000
+
+// FeeRepricing is a swap charged other fees than the current fee schedule would charge, the bridge fee is paid to
+// the swap agent of the source chain in wei of its native token and the rebate is the part owed back to the sponsor.
+// NetFeeDiff is the net fee charged less the net fee expected, it is positive if the swap was overcharged.
+type FeeRepricing struct {
+	SwapID         uint
+	CreatedAt      time.Time
+	Chain          string
+	Direction      string
+	Sponsor        string
+	StartTxHash    string
+	ChargedFee     *big.Int
+	ExpectedFee    *big.Int
+	ChargedRebate  *big.Int
+	ExpectedRebate *big.Int
+	// the current tier of the sponsor, empty without one
+	Tier       string
+	NetFeeDiff *big.Int
+}
+
+// FeeSchedule is the current swap fee of the swap agents and the current tiers of the sponsors
+type FeeSchedule struct {
+	swapFees map[string]*big.Int
+	tiers    map[string]*model.SponsorTier
+}
+
+func (engine *SwapEngine) GetFeeSchedule() (*FeeSchedule, error) {
+	schedule := &FeeSchedule{swapFees: make(map[string]*big.Int), tiers: make(map[string]*model.SponsorTier)}
+	for _, route := range swapRoutes {
+		if _, ok := schedule.swapFees[route.SourceChain]; ok {
+			continue
+		}
+		swapFee, err := engine.querySwapFee(route.SourceChain)
+		if err != nil {
+			return nil, fmt.Errorf("query swap fee of %s error: %s", route.SourceChain, err.Error())
+		}
+		schedule.swapFees[route.SourceChain] = swapFee
+	}
+	tiers, err := engine.GetSponsorTiers()
+	if err != nil {
+		return nil, err
+	}
+	for i := range tiers {
+		schedule.tiers[tiers[i].Sponsor] = &tiers[i]
+	}
+	return schedule, nil
+}
+
+// AuditSwapFees re-prices the swaps created in the time range under the fee schedule, the swap fee of the swap agent
+// of the source chain and the rebate of the tier of the sponsor, and passes the swaps charged otherwise to report in
+// order of their ids. It returns the number of the swaps re-priced.
+func (engine *SwapEngine) AuditSwapFees(schedule *FeeSchedule, from, to time.Time, report func(*FeeRepricing) error) (int, error) {
+	audited := 0
+	var lastID uint
+	for {
+		swaps, err := engine.store.FindSwaps(SwapQuery{AfterID: lastID, CreatedSince: from, CreatedBefore: to, Limit: FeeAuditBatchSize})
+		if err != nil {
+			return audited, err
+		}
+		if len(swaps) == 0 {
+			return audited, nil
+		}
+
+		startTxHashes := make([]string, 0, len(swaps))
+		for _, swap := range swaps {
+			startTxHashes = append(startTxHashes, swap.StartTxHash)
+		}
+		txEventLogs, err := engine.store.FindStartTxLogsOfTxs(startTxHashes)
+		if err != nil {
+			return audited, err
+		}
+		txEventLogsByHash := make(map[string]*model.SwapStartTxLog, len(txEventLogs))
+		for i := range txEventLogs {
+			txEventLogsByHash[txEventLogs[i].TxHash] = &txEventLogs[i]
+		}
+
+		for i := range swaps {
+			swap := &swaps[i]
+			lastID = swap.ID
+			txEventLog, ok := txEventLogsByHash[swap.StartTxHash]
+			if !ok {
+				continue
+			}
+			audited++
+			repricing := schedule.reprice(swap, txEventLog)
+			if repricing == nil {
+				continue
+			}
+			if err := report(repricing); err != nil {
+				return audited, err
+			}
+		}
+	}
+}
+
+// reprice returns the fees of the swap under the schedule, nil if the swap was charged the same
+func (schedule *FeeSchedule) reprice(swap *model.Swap, txEventLog *model.SwapStartTxLog) *FeeRepricing {
+	chargedFee, ok := big.NewInt(0).SetString(txEventLog.FeeAmount, 10)
+	if !ok {
+		chargedFee = big.NewInt(0)
+	}
+	chargedRebate, ok := big.NewInt(0).SetString(swap.FeeRebate, 10)
+	if !ok {
+		chargedRebate = big.NewInt(0)
+	}
+	expectedFee, ok := schedule.swapFees[txEventLog.Chain]
+	if !ok {
+		return nil
+	}
+	tier := schedule.tiers[ethcom.HexToAddress(swap.Sponsor).String()]
+	expectedRebate := getFeeRebate(expectedFee, tier)
+	if chargedFee.Cmp(expectedFee) == 0 && chargedRebate.Cmp(expectedRebate) == 0 {
+		return nil
+	}
+
+	netFeeDiff := new(big.Int).Sub(chargedFee, chargedRebate)
+	netFeeDiff.Sub(netFeeDiff, new(big.Int).Sub(expectedFee, expectedRebate))
+	repricing := &FeeRepricing{
+		SwapID:         swap.ID,
+		CreatedAt:      swap.CreatedAt,
+		Chain:          txEventLog.Chain,
+		Direction:      string(swap.Direction),
+		Sponsor:        swap.Sponsor,
+		StartTxHash:    swap.StartTxHash,
+		ChargedFee:     chargedFee,
+		ExpectedFee:    expectedFee,
+		ChargedRebate:  chargedRebate,
+		ExpectedRebate: expectedRebate,
+		NetFeeDiff:     netFeeDiff,
+	}
+	if tier != nil {
+		repricing.Tier = tier.Tier
+	}
+	return repricing
+}
@@ -0,0 +1,244 @@
+package swap
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/jinzhu/gorm"
+
+	"occ-swap-server/common"
+	"occ-swap-server/model"
+)
+
+// the values passed as the arguments of a fill method
+const (
+	// the zero value of the argument type
+	FillArgZero = "zero"
+	// the chain id of the source chain of the swap
+	FillArgFromChainId = "from_chain_id"
+	FillArgToChainId   = "to_chain_id"
+	FillArgRecipient   = "recipient"
+	FillArgAmount      = "amount"
+	// the token of the pair on the destination chain
+	FillArgToken       = "token"
+	FillArgStartTxHash = "start_tx_hash"
+)
+
+// fillArgTypes are the abi types of the arguments each value may be passed as
+var fillArgTypes = map[string][]string{
+	FillArgZero:        {"uint256", "address", "bytes32", "bool"},
+	FillArgFromChainId: {"uint256"},
+	FillArgToChainId:   {"uint256"},
+	FillArgRecipient:   {"address"},
+	FillArgAmount:      {"uint256"},
+	FillArgToken:       {"address"},
+	FillArgStartTxHash: {"bytes32"},
+}
+
+// FillMethodRequest is the method of the swap agent of Chain filling the swaps of a pair instead of fillSwap, Abi
+// is the json abi holding the method and Args are the values passed as its arguments in order
+type FillMethodRequest struct {
+	Chain  string
+	Method string
+	Abi    string
+	Args   []string
+}
+
+// fillMethod is the parsed fill method of a pair on a chain
+type fillMethod struct {
+	abi    abi.ABI
+	method abi.Method
+	args   []string
+}
+
+// fillCall is the swap a fill tx is encoded for
+type fillCall struct {
+	direction   common.SwapDirection
+	bep20Addr   string
+	erc20Addr   string
+	toChainId   *big.Int
+	recipient   ethcom.Address
+	amount      *big.Int
+	startTxHash string
+}
+
+func newFillCall(swap *model.Swap, toChainId, amount *big.Int) fillCall {
+	return fillCall{
+		direction:   swap.Direction,
+		bep20Addr:   swap.BEP20Addr,
+		erc20Addr:   swap.ERC20Addr,
+		toChainId:   toChainId,
+		recipient:   ethcom.HexToAddress(swap.GetRecipient()),
+		amount:      amount,
+		startTxHash: swap.StartTxHash,
+	}
+}
+
+// parseFillMethod parses the fill method of the pair, the type of every argument must fit the value passed as it
+func parseFillMethod(pairFillMethod *model.PairFillMethod) (*fillMethod, error) {
+	parsed, err := abi.JSON(strings.NewReader(pairFillMethod.Abi))
+	if err != nil {
+		return nil, fmt.Errorf("invalid abi of fill method %s: %s", pairFillMethod.Method, err.Error())
+	}
+	method, ok := parsed.Methods[pairFillMethod.Method]
+	if !ok {
+		return nil, fmt.Errorf("no method %s in the abi of the fill method", pairFillMethod.Method)
+	}
+	args := make([]string, 0)
+	if pairFillMethod.Args != "" {
+		args = strings.Split(pairFillMethod.Args, ",")
+	}
+	if len(args) != len(method.Inputs) {
+		return nil, fmt.Errorf("fill method %s has %d arguments, %d args are given", method.Sig(), len(method.Inputs), len(args))
+	}
+	for i, arg := range args {
+		types, ok := fillArgTypes[arg]
+		if !ok {
+			return nil, fmt.Errorf("unknown arg %s of fill method %s", arg, method.Sig())
+		}
+		argType := method.Inputs[i].Type.String()
+		fits := false
+		for _, t := range types {
+			fits = fits || t == argType
+		}
+		if !fits {
+			return nil, fmt.Errorf("arg %s can't be passed as the %s argument %d of fill method %s", arg, argType, i, method.Sig())
+		}
+	}
+	return &fillMethod{abi: parsed, method: method, args: args}, nil
+}
+
+// checkFillMethodCode checks the selector of the fill method is dispatched by the code of the swap agent of the
+// chain, the agents behind a proxy don't have it in their own code and are rejected
+func (engine *SwapEngine) checkFillMethodCode(chain string, method *fillMethod) error {
+	swapAgent := engine.getSwapAgent(chain)
+	code, err := engine.getClient(chain).CodeAt(context.Background(), swapAgent, nil)
+	if err != nil {
+		return fmt.Errorf("get code of swap agent %s on %s error: %s", swapAgent.String(), chain, err.Error())
+	}
+	if len(code) == 0 {
+		return fmt.Errorf("no code at swap agent %s on %s", swapAgent.String(), chain)
+	}
+	if !bytes.Contains(code, append([]byte{byte(vm.PUSH4)}, method.method.ID()...)) {
+		return fmt.Errorf("selector %x of fill method %s is not in the code of swap agent %s on %s",
+			method.method.ID(), method.method.Sig(), swapAgent.String(), chain)
+	}
+	return nil
+}
+
+// newPairFillMethods validates the fill methods of the new pair of the erc20 address, at most one a chain
+func (engine *SwapEngine) newPairFillMethods(erc20Addr string, reqs []FillMethodRequest) ([]*model.PairFillMethod, error) {
+	pairFillMethods := make([]*model.PairFillMethod, 0, len(reqs))
+	chains := make(map[string]bool)
+	for _, req := range reqs {
+		chain := strings.ToUpper(req.Chain)
+		if chain != common.ChainBSC && chain != common.ChainETH && chain != common.ChainMATIC {
+			return nil, fmt.Errorf("unknown chain %s of fill method", req.Chain)
+		}
+		if chains[chain] {
+			return nil, fmt.Errorf("more than one fill method on %s", chain)
+		}
+		chains[chain] = true
+
+		pairFillMethod := &model.PairFillMethod{
+			ERC20Addr: erc20Addr,
+			Chain:     chain,
+			Method:    req.Method,
+			Abi:       req.Abi,
+			Args:      strings.Join(req.Args, ","),
+		}
+		method, err := parseFillMethod(pairFillMethod)
+		if err != nil {
+			return nil, err
+		}
+		if err := engine.checkFillMethodCode(chain, method); err != nil {
+			return nil, err
+		}
+		pairFillMethods = append(pairFillMethods, pairFillMethod)
+	}
+	return pairFillMethods, nil
+}
+
+// loadFillMethods returns the fill methods of the pairs by the erc20 address and the chain
+func loadFillMethods(db *gorm.DB) (map[ethcom.Address]map[string]*fillMethod, error) {
+	pairFillMethods := make([]model.PairFillMethod, 0)
+	if err := db.Find(&pairFillMethods).Error; err != nil {
+		return nil, err
+	}
+	fillMethods := make(map[ethcom.Address]map[string]*fillMethod)
+	for i := range pairFillMethods {
+		if err := addFillMethod(fillMethods, &pairFillMethods[i]); err != nil {
+			return nil, err
+		}
+	}
+	return fillMethods, nil
+}
+
+func addFillMethod(fillMethods map[ethcom.Address]map[string]*fillMethod, pairFillMethod *model.PairFillMethod) error {
+	method, err := parseFillMethod(pairFillMethod)
+	if err != nil {
+		return fmt.Errorf("fill method of pair %s on %s: %s", pairFillMethod.ERC20Addr, pairFillMethod.Chain, err.Error())
+	}
+	erc20Addr := ethcom.HexToAddress(pairFillMethod.ERC20Addr)
+	if fillMethods[erc20Addr] == nil {
+		fillMethods[erc20Addr] = make(map[string]*fillMethod)
+	}
+	fillMethods[erc20Addr][pairFillMethod.Chain] = method
+	return nil
+}
+
+func (engine *SwapEngine) getFillMethod(erc20Addr ethcom.Address, chain string) *fillMethod {
+	engine.mutex.RLock()
+	defer engine.mutex.RUnlock()
+	return engine.fillMethods[erc20Addr][chain]
+}
+
+// encodeFillSwap returns the call data of the fill tx of the swap, the fill method of the pair on the destination
+// chain if it has one, fillSwap otherwise
+func (engine *SwapEngine) encodeFillSwap(call fillCall) ([]byte, error) {
+	destChain := getDestChain(call.direction)
+	method := engine.getFillMethod(ethcom.HexToAddress(call.erc20Addr), destChain)
+	if method == nil {
+		return abiEncodeFillSwap(call.toChainId, call.recipient, call.amount, engine.swapAgentABI)
+	}
+
+	values := make([]interface{}, 0, len(method.args))
+	for i, arg := range method.args {
+		switch arg {
+		case FillArgZero:
+			switch method.method.Inputs[i].Type.String() {
+			case "uint256":
+				values = append(values, big.NewInt(0))
+			case "address":
+				values = append(values, ethcom.Address{})
+			case "bytes32":
+				values = append(values, [32]byte{})
+			default:
+				values = append(values, false)
+			}
+		case FillArgFromChainId:
+			values = append(values, big.NewInt(engine.getChainID(getSourceChain(call.direction))))
+		case FillArgToChainId:
+			values = append(values, call.toChainId)
+		case FillArgRecipient:
+			values = append(values, call.recipient)
+		case FillArgAmount:
+			values = append(values, call.amount)
+		case FillArgToken:
+			token := ethcom.HexToAddress(call.erc20Addr)
+			if destChain == common.ChainBSC {
+				token = ethcom.HexToAddress(call.bep20Addr)
+			}
+			values = append(values, token)
+		case FillArgStartTxHash:
+			values = append(values, [32]byte(ethcom.HexToHash(call.startTxHash)))
+		}
+	}
+	return method.abi.Pack(method.method.Name, values...)
+}
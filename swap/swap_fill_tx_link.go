@@ -0,0 +1,50 @@
+package swap
+
+import (
+	"fmt"
+
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+// LinkFillTxsMigration is the data migration setting the swap ids of the fill txs written before the fill txs
+// referenced their swaps by id
+const LinkFillTxsMigration = "link_fill_txs"
+
+// linkFillTxs runs the fill tx linkage until the foreign keys are added, the swap ids of the fill txs are set from
+// the swaps of their start tx hashes, then the foreign keys are added. The fill txs without a swap are alerted and
+// left unlinked, mysql checks the existing rows so they block the foreign keys there until they are linked or
+// deleted, the linkage runs again on the next start.
+func (engine *SwapEngine) linkFillTxs() {
+	if model.IsMigrationApplied(engine.db, LinkFillTxsMigration) {
+		return
+	}
+	linked := engine.db.Exec("UPDATE swap_fill_txs SET swap_id = (SELECT id FROM swaps WHERE swaps.start_tx_hash = swap_fill_txs.start_swap_tx_hash) " +
+		"WHERE swap_id = 0 AND EXISTS (SELECT 1 FROM swaps WHERE swaps.start_tx_hash = swap_fill_txs.start_swap_tx_hash)")
+	if linked.Error != nil {
+		util.Logger.Errorf("link fill txs to their swaps error: %s", linked.Error.Error())
+		return
+	}
+	unlinked := 0
+	if err := engine.db.Unscoped().Model(model.SwapFillTx{}).Where("swap_id = ?", 0).Count(&unlinked).Error; err != nil {
+		util.Logger.Errorf("count unlinked fill txs error: %s", err.Error())
+		return
+	}
+	if unlinked > 0 {
+		util.SendTelegramMessage(fmt.Sprintf("Urgent alert: %d fill txs don't have a swap of their start tx hash and are left unlinked, check the logs",
+			unlinked))
+	}
+	if errs := model.CreateForeignKeys(engine.db); len(errs) > 0 {
+		for _, err := range errs {
+			util.Logger.Errorf("%s", err.Error())
+		}
+		util.SendTelegramMessage(fmt.Sprintf("Urgent alert: the foreign keys of the fill txs can't be added, %d fill txs are unlinked, the linkage runs again on the next start",
+			unlinked))
+		return
+	}
+	if err := model.MarkMigrationApplied(engine.db, LinkFillTxsMigration); err != nil {
+		util.Logger.Errorf("mark migration %s applied error: %s", LinkFillTxsMigration, err.Error())
+		return
+	}
+	util.Logger.Infof("fill tx linkage is done, %d fill txs linked, %d fill txs without a swap", linked.RowsAffected, unlinked)
+}
@@ -0,0 +1,144 @@
+package swap
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"occ-swap-server/common"
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+// importedSwapStatuses maps the statuses of the dumps of the previous deployments to the swap statuses, only the
+// final ones are imported, the swaps in flight are left to the deployment they are started on
+var importedSwapStatuses = map[string]common.SwapStatus{
+	"sent_success": SwapSuccess,
+	"success":      SwapSuccess,
+	"completed":    SwapSuccess,
+	"filled":       SwapSuccess,
+	"sent_fail":    SwapSendFailed,
+	"failed":       SwapSendFailed,
+	"rejected":     SwapQuoteRejected,
+	"invalid":      SwapQuoteRejected,
+	"expired":      SwapExpired,
+	"refunded":     SwapExpired,
+}
+
+// ImportedSwap is a swap of the dump of a previous bridge deployment
+type ImportedSwap struct {
+	StartTxHash string               `json:"start_tx_hash"`
+	FillTxHash  string               `json:"fill_tx_hash"`
+	Status      string               `json:"status"`
+	Direction   common.SwapDirection `json:"direction"`
+	ToChainId   string               `json:"to_chain_id"`
+	Sponsor     string               `json:"sponsor"`
+	Recipient   string               `json:"recipient"`
+	Memo        string               `json:"memo"`
+	BEP20Addr   string               `json:"bep20_addr"`
+	ERC20Addr   string               `json:"erc20_addr"`
+	Symbol      string               `json:"symbol"`
+	Amount      string               `json:"amount"`
+	Decimals    int                  `json:"decimals"`
+	Log         string               `json:"log"`
+	// unix time the swap is started, the history keeps its order
+	CreateTime int64 `json:"create_time"`
+}
+
+// ImportFailure is a swap of the dump which is not imported
+type ImportFailure struct {
+	Index       int    `json:"index"`
+	StartTxHash string `json:"start_tx_hash"`
+	Error       string `json:"error"`
+}
+
+// ImportReport is the result of the import of a dump, the swaps already there are skipped so a dump can be imported
+// again after a failure
+type ImportReport struct {
+	Imported int             `json:"imported"`
+	Skipped  int             `json:"skipped"`
+	Failed   []ImportFailure `json:"failed"`
+}
+
+// newImportedSwap returns the swap of the dump, with the status mapped and the decimal amount derived
+func newImportedSwap(source string, imported *ImportedSwap) (*model.Swap, error) {
+	status, ok := importedSwapStatuses[strings.ToLower(strings.TrimSpace(imported.Status))]
+	if !ok {
+		return nil, fmt.Errorf("status %q can't be imported", imported.Status)
+	}
+	if !isValidDirection(imported.Direction) {
+		return nil, fmt.Errorf("invalid direction: %s", imported.Direction)
+	}
+	if status == SwapSuccess && imported.FillTxHash == "" {
+		return nil, fmt.Errorf("filled swap without fill tx hash")
+	}
+	if imported.ToChainId == "" {
+		return nil, fmt.Errorf("missing to chain id")
+	}
+	if imported.CreateTime <= 0 {
+		return nil, fmt.Errorf("missing create time")
+	}
+	amountDecimal, err := model.FormatRawAmount(imported.Amount, imported.Decimals)
+	if err != nil {
+		return nil, err
+	}
+	createdAt := time.Unix(imported.CreateTime, 0)
+	swap := &model.Swap{
+		Model:         gorm.Model{CreatedAt: createdAt, UpdatedAt: createdAt},
+		Status:        status,
+		Sponsor:       imported.Sponsor,
+		ToChainId:     imported.ToChainId,
+		Recipient:     imported.Recipient,
+		Memo:          imported.Memo,
+		BEP20Addr:     imported.BEP20Addr,
+		ERC20Addr:     imported.ERC20Addr,
+		Symbol:        imported.Symbol,
+		Amount:        imported.Amount,
+		Decimals:      imported.Decimals,
+		Direction:     imported.Direction,
+		AmountDecimal: amountDecimal,
+		AssetType:     common.AssetTypeFungible,
+		StartTxHash:   imported.StartTxHash,
+		FillTxHash:    imported.FillTxHash,
+		Log:           imported.Log,
+		ImportedFrom:  source,
+	}
+	if err := swap.Normalize(); err != nil {
+		return nil, err
+	}
+	return swap, nil
+}
+
+// ImportSwaps saves the swaps of the dump of the previous deployment source with the record hashes of the current
+// key, so the history of the sponsors goes on across the deployments. They are marked imported, they are never
+// filled, retried or expired here, and no webhook or bridge event is sent for them.
+func (engine *SwapEngine) ImportSwaps(source string, importedSwaps []ImportedSwap) (*ImportReport, error) {
+	report := &ImportReport{Failed: make([]ImportFailure, 0)}
+	if source == "" {
+		return report, fmt.Errorf("missing source")
+	}
+	for i := range importedSwaps {
+		swap, err := newImportedSwap(source, &importedSwaps[i])
+		if err != nil {
+			report.Failed = append(report.Failed, ImportFailure{Index: i, StartTxHash: importedSwaps[i].StartTxHash, Error: err.Error()})
+			continue
+		}
+		recorded, err := engine.store.IsSwapRecorded(swap.StartTxHash)
+		if err != nil {
+			return report, err
+		}
+		if recorded {
+			report.Skipped++
+			continue
+		}
+		swap.RecordHash = engine.getSwapHMAC(swap)
+		if err := engine.store.CreateSwap(swap); err != nil {
+			return report, err
+		}
+		report.Imported++
+	}
+	util.Logger.Infof("import swaps of %s, %d imported, %d skipped, %d failed", source, report.Imported, report.Skipped, len(report.Failed))
+	return report, nil
+}
@@ -0,0 +1,137 @@
+package swap
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"occ-swap-server/common"
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+// IntegrityFinding is a swap which fails the integrity sweep
+type IntegrityFinding struct {
+	SwapID      uint                 `json:"swap_id"`
+	StartTxHash string               `json:"start_tx_hash"`
+	Status      common.SwapStatus    `json:"status"`
+	Issue       model.IntegrityIssue `json:"issue"`
+	Quarantined bool                 `json:"quarantined"`
+}
+
+// IntegrityReport is the result of an integrity sweep of the swaps table
+type IntegrityReport struct {
+	StartedAt  int64              `json:"started_at"`
+	FinishedAt int64              `json:"finished_at"`
+	Scanned    int64              `json:"scanned"`
+	Tampered   int64              `json:"tampered"`
+	Legacy     int64              `json:"legacy"`
+	Issues     []IntegrityFinding `json:"issues"`
+	// set if the sweep is stopped by a db error, the swaps after the last scanned one are not verified
+	Error string `json:"error,omitempty"`
+}
+
+func (engine *SwapEngine) getIntegrityIssue(swap *model.Swap) (model.IntegrityIssue, bool) {
+	if swap.RecordHash == "" {
+		return model.IntegrityLegacy, true
+	}
+	if !engine.verifySwap(swap) {
+		return model.IntegrityTampered, true
+	}
+	return "", false
+}
+
+// SweepIntegrity verifies the record hashes of all the swaps, the swaps failing it are moved to the quarantined
+// swaps if quarantine is set
+func (engine *SwapEngine) SweepIntegrity(quarantine bool) *IntegrityReport {
+	report := &IntegrityReport{StartedAt: time.Now().Unix(), Issues: make([]IntegrityFinding, 0)}
+	batchSize := engine.config.IntegrityConfig.GetBatchSize()
+	var lastID uint
+	for {
+		swaps, err := engine.store.FindSwaps(SwapQuery{AfterID: lastID, Limit: int(batchSize)})
+		if err != nil {
+			report.Error = fmt.Sprintf("query swaps after id %d error: %s", lastID, err.Error())
+			break
+		}
+		for i := range swaps {
+			report.Scanned++
+			issue, ok := engine.getIntegrityIssue(&swaps[i])
+			if !ok {
+				continue
+			}
+			if issue == model.IntegrityTampered {
+				report.Tampered++
+			} else {
+				report.Legacy++
+			}
+			result := IntegrityFinding{SwapID: swaps[i].ID, StartTxHash: swaps[i].StartTxHash, Status: swaps[i].Status, Issue: issue}
+			if quarantine {
+				if err := engine.quarantineSwap(swaps[i].ID); err != nil {
+					util.Logger.Errorf("quarantine swap %d error: %s", swaps[i].ID, err.Error())
+				} else {
+					result.Quarantined = true
+				}
+			}
+			report.Issues = append(report.Issues, result)
+		}
+		if int64(len(swaps)) < batchSize {
+			break
+		}
+		lastID = swaps[len(swaps)-1].ID
+	}
+	report.FinishedAt = time.Now().Unix()
+
+	engine.mutex.Lock()
+	engine.integrityReport = report
+	engine.mutex.Unlock()
+	return report
+}
+
+// GetIntegrityReport returns the report of the latest integrity sweep, it is nil if no sweep has run
+func (engine *SwapEngine) GetIntegrityReport() *IntegrityReport {
+	engine.mutex.RLock()
+	defer engine.mutex.RUnlock()
+	return engine.integrityReport
+}
+
+// quarantineSwap moves the swap to the quarantined swaps, the swap is read again in the tx, so a swap which is
+// updated by the engine since it is scanned is verified again
+func (engine *SwapEngine) quarantineSwap(id uint) error {
+	return engine.store.Transaction(func(tx SwapStore) error {
+		swap, err := tx.LockSwap(id)
+		if err != nil {
+			return err
+		}
+		issue, ok := engine.getIntegrityIssue(swap)
+		if !ok {
+			return fmt.Errorf("swap %d passes the verification now", id)
+		}
+		snapshot, err := json.Marshal(swap)
+		if err != nil {
+			return err
+		}
+		return tx.QuarantineSwap(&model.QuarantinedSwap{
+			SwapID:      swap.ID,
+			StartTxHash: swap.StartTxHash,
+			Status:      swap.Status,
+			Issue:       issue,
+			Snapshot:    string(snapshot),
+		})
+	})
+}
+
+// integritySweepDaemon sweeps the swaps on the schedule of the config, the tampered swaps are alerted
+func (engine *SwapEngine) integritySweepDaemon() error {
+	cfg := engine.config.IntegrityConfig
+	report := engine.SweepIntegrity(cfg.Quarantine)
+	util.Logger.Infof("integrity sweep of %d swaps, %d tampered, %d legacy", report.Scanned, report.Tampered, report.Legacy)
+	if report.Error != "" {
+		util.Logger.Errorf("integrity sweep error: %s", report.Error)
+		util.SendTelegramMessage(fmt.Sprintf("integrity sweep error: %s", report.Error))
+	}
+	if report.Tampered != 0 {
+		util.SendTelegramMessage(fmt.Sprintf("Urgent alert: integrity sweep found %d tampered swaps of %d swaps, quarantined: %v",
+			report.Tampered, report.Scanned, cfg.Quarantine))
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package swap
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"occ-swap-server/common"
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+const (
+	LatencyStageConfirm = "observed_confirmed" // swap start tx observed to the swap created once it is confirmed
+	LatencyStageSend    = "confirmed_sent"     // swap created to the first fill tx sent
+	LatencyStageMine    = "sent_mined"         // first fill tx sent to the swap succeeding
+	LatencyStageTotal   = "total"              // swap start tx observed to the swap succeeding
+
+	// LatencySampleSize is the number of the recent filled swaps of a direction the percentiles are computed from
+	LatencySampleSize = 200
+)
+
+var latencyStages = []string{LatencyStageConfirm, LatencyStageSend, LatencyStageMine, LatencyStageTotal}
+
+// latencyStatsDaemon recomputes the latency percentiles of the stages of every direction from the recent filled
+// swaps and saves them in the swap_latency_stats table
+func (engine *SwapEngine) latencyStatsDaemon() error {
+	for _, route := range swapRoutes {
+		if err := engine.updateLatencyStats(route.Direction); err != nil {
+			util.Logger.Errorf("update latency stats of %s error: %s", route.Direction, err.Error())
+		}
+	}
+	return nil
+}
+
+func (engine *SwapEngine) updateLatencyStats(direction common.SwapDirection) error {
+	swaps, err := engine.store.FindSwaps(SwapQuery{Statuses: []common.SwapStatus{SwapSuccess},
+		Directions: []common.SwapDirection{direction}, Latest: true, Limit: LatencySampleSize})
+	if err != nil {
+		return err
+	}
+	if len(swaps) == 0 {
+		return nil
+	}
+
+	startTxHashes := make([]string, 0, len(swaps))
+	swapIDs := make([]uint, 0, len(swaps))
+	for _, swap := range swaps {
+		startTxHashes = append(startTxHashes, swap.StartTxHash)
+		swapIDs = append(swapIDs, swap.ID)
+	}
+	txEventLogs, err := engine.store.FindStartTxLogsOfTxs(startTxHashes)
+	if err != nil {
+		return err
+	}
+	observed := make(map[string]time.Time, len(txEventLogs))
+	for _, txEventLog := range txEventLogs {
+		observed[txEventLog.TxHash] = time.Unix(txEventLog.CreateTime, 0)
+	}
+	swapTxs, err := engine.store.FindFillTxsOfSwaps(swapIDs)
+	if err != nil {
+		return err
+	}
+	// the latency of sending is measured to the first fill tx, the retries are part of mining
+	sent := make(map[uint]time.Time, len(swapTxs))
+	for _, swapTx := range swapTxs {
+		if _, ok := sent[swapTx.SwapID]; !ok {
+			sent[swapTx.SwapID] = swapTx.CreatedAt
+		}
+	}
+
+	latencies := make(map[string][]time.Duration, len(latencyStages))
+	for _, swap := range swaps {
+		observedAt, ok := observed[swap.StartTxHash]
+		if !ok {
+			continue
+		}
+		latencies[LatencyStageConfirm] = append(latencies[LatencyStageConfirm], nonNegative(swap.CreatedAt.Sub(observedAt)))
+		latencies[LatencyStageTotal] = append(latencies[LatencyStageTotal], nonNegative(swap.UpdatedAt.Sub(observedAt)))
+		if sentAt, ok := sent[swap.ID]; ok {
+			latencies[LatencyStageSend] = append(latencies[LatencyStageSend], nonNegative(sentAt.Sub(swap.CreatedAt)))
+			latencies[LatencyStageMine] = append(latencies[LatencyStageMine], nonNegative(swap.UpdatedAt.Sub(sentAt)))
+		}
+	}
+
+	return engine.store.Transaction(func(tx SwapStore) error {
+		stats, err := tx.FindLatencyStats(direction)
+		if err != nil {
+			return err
+		}
+		for _, stage := range latencyStages {
+			samples := latencies[stage]
+			if len(samples) == 0 {
+				continue
+			}
+			sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+			stat := model.SwapLatencyStat{}
+			for _, saved := range stats {
+				if saved.Stage == stage {
+					stat = saved
+				}
+			}
+			stat.Direction = direction
+			stat.Stage = stage
+			stat.Samples = len(samples)
+			stat.P50 = percentileSeconds(samples, 0.5)
+			stat.P90 = percentileSeconds(samples, 0.9)
+			stat.P99 = percentileSeconds(samples, 0.99)
+			if err := tx.SaveLatencyStat(&stat); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func nonNegative(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// percentileSeconds returns the nearest-rank percentile of the sorted latencies in seconds
+func percentileSeconds(sorted []time.Duration, p float64) int64 {
+	idx := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return int64(sorted[idx] / time.Second)
+}
+
+func (engine *SwapEngine) GetLatencyStats(direction common.SwapDirection) ([]model.SwapLatencyStat, error) {
+	return engine.store.FindLatencyStats(direction)
+}
+
+// getEta returns the median total latency of the direction in seconds and the number of its samples
+func getEta(stats []model.SwapLatencyStat) (int64, int) {
+	for _, stat := range stats {
+		if stat.Stage == LatencyStageTotal {
+			return stat.P50, stat.Samples
+		}
+	}
+	return 0, 0
+}
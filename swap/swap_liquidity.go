@@ -0,0 +1,233 @@
+package swap
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethcom "github.com/ethereum/go-ethereum/common"
+
+	sabi "occ-swap-server/abi"
+	"occ-swap-server/common"
+	"occ-swap-server/util"
+)
+
+const DefaultBalanceMonitorInterval = 60 * time.Second
+
+// Liquidity is the amount of token the swap agent of a destination chain can transfer to fill the swaps
+type Liquidity struct {
+	Chain      string                 `json:"chain"`
+	Directions []common.SwapDirection `json:"directions"`
+	SwapAgent  string                 `json:"swap_agent"`
+	Token      string                 `json:"token"`
+	Balance    string                 `json:"balance"`
+	// the amount of the swaps which are being filled
+	Pending string `json:"pending"`
+	// Balance - Pending - the amount of the swaps allowed by the fill daemon since the last update
+	Available  string `json:"available"`
+	UpdateTime int64  `json:"update_time"`
+
+	available *big.Int
+}
+
+func (engine *SwapEngine) getChainID(chain string) int64 {
+	switch chain {
+	case common.ChainBSC:
+		return engine.bscChainID
+	case common.ChainMATIC:
+		return engine.maticChainID
+	default:
+		return engine.ethChainID
+	}
+}
+
+// GetLiquidity returns the latest liquidity of all the destination chains
+func (engine *SwapEngine) GetLiquidity() []Liquidity {
+	engine.mutex.RLock()
+	defer engine.mutex.RUnlock()
+
+	liquidity := make([]Liquidity, 0, len(engine.liquidity))
+	for _, chain := range []string{common.ChainBSC, common.ChainETH, common.ChainMATIC} {
+		if l, ok := engine.liquidity[chain]; ok {
+			chainLiquidity := *l
+			chainLiquidity.Available = l.available.String()
+			liquidity = append(liquidity, chainLiquidity)
+		}
+	}
+	return liquidity
+}
+
+// reserveLiquidity deducts the amount from the available liquidity of the destination chain, it returns false if
+// the liquidity is not enough. Swaps are never held if the liquidity of the chain is unknown.
+func (engine *SwapEngine) reserveLiquidity(chain string, amount *big.Int) bool {
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+
+	l, ok := engine.liquidity[chain]
+	if !ok {
+		return true
+	}
+	if l.available.Cmp(amount) < 0 {
+		return false
+	}
+	l.available.Sub(l.available, amount)
+	return true
+}
+
+func (engine *SwapEngine) getBalanceMonitorInterval() time.Duration {
+	if engine.config.ChainConfig.BalanceMonitorInterval > 0 {
+		return time.Duration(engine.config.ChainConfig.BalanceMonitorInterval) * time.Second
+	}
+	return DefaultBalanceMonitorInterval
+}
+
+// GetRelayerAccounts returns the latest state of the relayer accounts of all the destination chains
+func (engine *SwapEngine) GetRelayerAccounts() []RelayerAccount {
+	accounts := make([]RelayerAccount, 0)
+	for _, chain := range []string{common.ChainBSC, common.ChainETH, common.ChainMATIC} {
+		accounts = append(accounts, engine.relayerPools[chain].GetAccounts()...)
+	}
+	return accounts
+}
+
+func (engine *SwapEngine) trackLiquidityDaemon() func() error {
+	erc20ABI, err := abi.JSON(strings.NewReader(sabi.ERC20ABI))
+	if err != nil {
+		panic(err)
+	}
+	return func() error {
+		for _, chain := range []string{common.ChainBSC, common.ChainETH, common.ChainMATIC} {
+			if engine.isChainDegraded(chain) {
+				continue
+			}
+			if err := engine.updateLiquidity(chain, &erc20ABI); err != nil {
+				util.Logger.Errorf("update liquidity of %s error: %s", chain, err.Error())
+				continue
+			}
+			engine.releaseAwaitingLiquiditySwaps(chain)
+		}
+		return nil
+	}
+}
+
+// getAgentToken returns the token of the swap agent of the chain, the token transferred by the fill tx is
+// registered by setToken under the chain id of the destination chain, the same token is deposited by the swap tx
+func (engine *SwapEngine) getAgentToken(chain string) (ethcom.Address, error) {
+	swapAgent := engine.getSwapAgent(chain)
+	data, err := engine.swapAgentABI.Pack("tokenAddresses", big.NewInt(engine.getChainID(chain)))
+	if err != nil {
+		return ethcom.Address{}, err
+	}
+	output, err := engine.getClient(chain).CallContract(context.Background(), ethereum.CallMsg{To: &swapAgent, Data: data}, nil)
+	if err != nil {
+		return ethcom.Address{}, err
+	}
+	var token ethcom.Address
+	if err := engine.swapAgentABI.Unpack(&token, "tokenAddresses", output); err != nil {
+		return ethcom.Address{}, err
+	}
+	if token == (ethcom.Address{}) {
+		return ethcom.Address{}, fmt.Errorf("no token is registered in swap agent %s", swapAgent.String())
+	}
+	return token, nil
+}
+
+func (engine *SwapEngine) updateLiquidity(chain string, erc20ABI *abi.ABI) error {
+	client := engine.getClient(chain)
+	swapAgent := engine.getSwapAgent(chain)
+
+	token, err := engine.getAgentToken(chain)
+	if err != nil {
+		return err
+	}
+
+	data, err := erc20ABI.Pack("balanceOf", swapAgent)
+	if err != nil {
+		return err
+	}
+	output, err := client.CallContract(context.Background(), ethereum.CallMsg{To: &token, Data: data}, nil)
+	if err != nil {
+		return err
+	}
+	balance := big.NewInt(0)
+	if err := erc20ABI.Unpack(&balance, "balanceOf", output); err != nil {
+		return err
+	}
+
+	directions := getDirectionsToChain(chain)
+	swaps, err := engine.store.FindSwaps(SwapQuery{Statuses: []common.SwapStatus{SwapSending, SwapSent}, Directions: directions})
+	if err != nil {
+		return err
+	}
+	pending := big.NewInt(0)
+	for _, swap := range swaps {
+		if amount, ok := big.NewInt(0).SetString(swap.Amount, 10); ok {
+			pending.Add(pending, amount)
+		}
+	}
+	available := big.NewInt(0).Sub(balance, pending)
+
+	balanceFloat, _ := new(big.Float).SetInt(balance).Float64()
+	availableFloat, _ := new(big.Float).SetInt(available).Float64()
+	liquidityBalanceGauge.WithLabelValues(chain, token.String()).Set(balanceFloat)
+	liquidityAvailableGauge.WithLabelValues(chain, token.String()).Set(availableFloat)
+
+	engine.mutex.Lock()
+	engine.liquidity[chain] = &Liquidity{
+		Chain:      chain,
+		Directions: directions,
+		SwapAgent:  swapAgent.String(),
+		Token:      token.String(),
+		Balance:    balance.String(),
+		Pending:    pending.String(),
+		UpdateTime: time.Now().Unix(),
+		available:  available,
+	}
+	engine.mutex.Unlock()
+	return nil
+}
+
+// releaseAwaitingLiquiditySwaps makes the swaps waiting for the liquidity of the chain eligible for fill again in
+// order, as long as the liquidity can cover them
+func (engine *SwapEngine) releaseAwaitingLiquiditySwaps(chain string) {
+	swaps, err := engine.store.FindSwaps(SwapQuery{Statuses: []common.SwapStatus{SwapAwaitingLiquidity},
+		Directions: getDirectionsToChain(chain), Limit: BatchSize})
+	if err != nil {
+		util.Logger.Errorf("query swaps awaiting liquidity of %s error: %s", chain, err.Error())
+		return
+	}
+
+	for _, swap := range swaps {
+		amount, ok := big.NewInt(0).SetString(swap.Amount, 10)
+		if !ok {
+			continue
+		}
+		if !engine.hasLiquidity(chain, amount) {
+			break
+		}
+		util.Logger.Infof("liquidity of %s is enough for swap, start tx hash %s, amount %s", chain, swap.StartTxHash, swap.Amount)
+		writeDBErr := engine.store.Transaction(func(tx SwapStore) error {
+			if !engine.verifySwap(&swap) {
+				return fmt.Errorf("verify hmac of swap failed: %s", swap.StartTxHash)
+			}
+			swap.Status = SwapConfirmed
+			return engine.updateSwap(tx, &swap)
+		})
+		if writeDBErr != nil {
+			util.Logger.Errorf("write db error: %s", writeDBErr.Error())
+			util.SendTelegramMessage(fmt.Sprintf("write db error: %s", writeDBErr.Error()))
+		}
+	}
+}
+
+func (engine *SwapEngine) hasLiquidity(chain string, amount *big.Int) bool {
+	engine.mutex.RLock()
+	defer engine.mutex.RUnlock()
+
+	l, ok := engine.liquidity[chain]
+	return !ok || l.available.Cmp(amount) >= 0
+}
@@ -0,0 +1,136 @@
+package swap
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"occ-swap-server/common"
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+// manuallyFillableStatuses are the statuses of the swaps which have no fill tx in flight
+var manuallyFillableStatuses = map[common.SwapStatus]bool{
+	SwapConfirmed:         true,
+	SwapDelayed:           true,
+	SwapAwaitingLiquidity: true,
+	SwapAwaitingWindow:    true,
+	SwapAwaitingGas:       true,
+	SwapSendFailed:        true,
+	SwapMismatch:          true,
+	SwapAbandoned:         true,
+	SwapUneconomic:        true,
+}
+
+var erc20TransferTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+
+// verifyManualFillTx checks that the external fill tx pays the swap amount to the recipient of the swap, either by a
+// fillSwap call of the swap agent or by a plain transfer of the token of the destination chain
+func (engine *SwapEngine) verifyManualFillTx(receipt *types.Receipt, swap *model.Swap) error {
+	if engine.verifySwapFilledEvent(receipt, swap, receipt.TxHash.String()) == "" {
+		return nil
+	}
+
+	destChain := getDestChain(swap.Direction)
+	var token ethcom.Address
+	engine.mutex.RLock()
+	if l, ok := engine.liquidity[destChain]; ok {
+		token = ethcom.HexToAddress(l.Token)
+	}
+	engine.mutex.RUnlock()
+	if token == (ethcom.Address{}) {
+		return fmt.Errorf("no matching SwapFilled event in tx %s and the token of %s is unknown", receipt.TxHash.String(), destChain)
+	}
+
+	for _, log := range receipt.Logs {
+		if log.Address != token || len(log.Topics) != 3 || log.Topics[0] != erc20TransferTopic {
+			continue
+		}
+		recipient := ethcom.BytesToAddress(log.Topics[2].Bytes())
+		amount := new(big.Int).SetBytes(log.Data)
+		if recipient == ethcom.HexToAddress(swap.GetRecipient()) && amount.String() == swap.Amount {
+			return nil
+		}
+	}
+	return fmt.Errorf("tx %s doesn't transfer %s of token %s to %s", receipt.TxHash.String(), swap.Amount, token.String(), swap.GetRecipient())
+}
+
+// MarkSwapFilled attaches the fill tx sent by the operators from another wallet to the swap and marks the swap as
+// success after the receipt is verified on chain
+func (engine *SwapEngine) MarkSwapFilled(startTxHash, fillTxHash, operator string) error {
+	fillTxHash, err := model.NormalizeTxHash("fill tx hash", fillTxHash)
+	if err != nil {
+		return err
+	}
+	swap, err := engine.getSwapByStartTxHash(engine.store, startTxHash)
+	if err != nil {
+		return err
+	}
+	if !manuallyFillableStatuses[swap.Status] {
+		return fmt.Errorf("swap %s can't be marked as filled, status %s", startTxHash, swap.Status)
+	}
+
+	destChain := getDestChain(swap.Direction)
+	receipt, err := engine.getClient(destChain).TransactionReceipt(context.Background(), ethcom.HexToHash(fillTxHash))
+	if err != nil {
+		return fmt.Errorf("query receipt of %s on %s error: %s", fillTxHash, destChain, err.Error())
+	}
+	if receipt.Status == TxFailedStatus {
+		return fmt.Errorf("fill tx %s is failed", fillTxHash)
+	}
+	if err := engine.verifyManualFillTx(receipt, swap); err != nil {
+		return err
+	}
+
+	var previousStatus common.SwapStatus
+	var swapTx *model.SwapFillTx
+	err = engine.store.Transaction(func(tx SwapStore) error {
+		// the tx may be attached to another swap, of any status, archived or retried, and the swap may be picked up
+		// by the fill daemon in the meantime
+		recorded, err := tx.IsFillTxRecorded(fillTxHash)
+		if err != nil {
+			return err
+		}
+		if recorded {
+			return fmt.Errorf("fill tx %s is already attached to a swap", fillTxHash)
+		}
+		swap, err := engine.getSwapByStartTxHash(tx, startTxHash)
+		if err != nil {
+			return err
+		}
+		if !manuallyFillableStatuses[swap.Status] {
+			return fmt.Errorf("swap %s can't be marked as filled, status %s", startTxHash, swap.Status)
+		}
+		swapTx = &model.SwapFillTx{
+			SwapID:          swap.ID,
+			Direction:       swap.Direction,
+			StartSwapTxHash: swap.StartTxHash,
+			FillSwapTxHash:  fillTxHash,
+			GasPrice:        "0",
+			Height:          receipt.BlockNumber.Int64(),
+			Status:          model.FillTxSuccess,
+		}
+		if err := tx.CreateFillTx(swapTx); err != nil {
+			return err
+		}
+		previousStatus = swap.Status
+		swap.Status = SwapSuccess
+		swap.FillTxHash = swapTx.FillSwapTxHash
+		swap.Log = fmt.Sprintf("manually filled by %s with tx %s", operator, swapTx.FillSwapTxHash)
+		return engine.updateSwap(tx, swap)
+	})
+	if err != nil {
+		return err
+	}
+
+	util.Logger.Infof("swap is manually marked as filled by %s, start tx hash %s, fill tx hash %s, previous status %s",
+		operator, startTxHash, swapTx.FillSwapTxHash, previousStatus)
+	util.SendTelegramMessage(fmt.Sprintf("swap is manually marked as filled by %s, start tx hash %s, fill tx hash %s, previous status %s %s",
+		operator, startTxHash, swapTx.FillSwapTxHash, previousStatus, engine.config.ExplorerLink(destChain, util.ExplorerTx, swapTx.FillSwapTxHash)))
+	return nil
+}
@@ -0,0 +1,118 @@
+package swap
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"occ-swap-server/common"
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+func (engine *SwapEngine) trackDroppedSwapTxDaemon() {
+	for _, chain := range []string{common.ChainBSC, common.ChainETH, common.ChainMATIC} {
+		chain := chain
+		engine.scheduler.Go(util.Daemon{Name: "track_dropped_swap_tx_" + chain,
+			Interval: engine.config.ChainConfig.GetRebroadcastTimeout(chain), WaitFirst: true,
+			Run: func() error { return engine.trackDroppedSwapTxOfChainDaemon(chain) }})
+	}
+}
+
+// trackDroppedSwapTxOfChainDaemon rebroadcasts the stored raw tx of the sent fill txs of the given destination chain
+// which are neither mined nor known by the node any more
+func (engine *SwapEngine) trackDroppedSwapTxOfChainDaemon(chainName string) error {
+	timeout := engine.config.ChainConfig.GetRebroadcastTimeout(chainName)
+	client := engine.getClient(chainName)
+	swapTxs, err := engine.store.FindDroppedFillTxs(getDirectionsToChain(chainName), time.Now().Add(-timeout).Unix(),
+		MaxRebroadcastTimes, TrackSentTxBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, swapTx := range swapTxs {
+		txHash := ethcom.HexToHash(swapTx.FillSwapTxHash)
+		if _, err := client.TransactionReceipt(context.Background(), txHash); err == nil {
+			// mined, left to the tx tracking daemon
+			continue
+		}
+		if _, _, err := client.TransactionByHash(context.Background(), txHash); err == nil {
+			continue
+		} else if err != ethereum.NotFound {
+			util.Logger.Debugf("%s, query tx %s failed: %s", chainName, swapTx.FillSwapTxHash, err.Error())
+			continue
+		}
+
+		util.Logger.Infof("fill tx is dropped by %s node, rebroadcast it, start hash %s, fill hash %s", chainName, swapTx.StartSwapTxHash, swapTx.FillSwapTxHash)
+		rebroadcastErr := engine.rebroadcastSwapTx(chainName, &swapTx)
+		if rebroadcastErr != nil {
+			util.Logger.Errorf("rebroadcast fill tx to %s error: %s, fill hash %s", chainName, rebroadcastErr.Error(), swapTx.FillSwapTxHash)
+			util.SendTelegramMessage(fmt.Sprintf("rebroadcast fill tx to %s error: %s, fill hash %s", chainName, rebroadcastErr.Error(), swapTx.FillSwapTxHash))
+		}
+
+		// the tx is tracked from scratch after rebroadcast
+		err := engine.store.SetFillTxRebroadcast(swapTx.ID, swapTx.RebroadcastCounter+1, rebroadcastErr == nil)
+		if err != nil {
+			util.Logger.Errorf("write db error: %s", err.Error())
+			util.SendTelegramMessage(fmt.Sprintf("write db error: %s", err.Error()))
+		}
+	}
+	return nil
+}
+
+func (engine *SwapEngine) rebroadcastSwapTx(chainName string, swapTx *model.SwapFillTx) error {
+	signedTx, err := decodeRawTx(swapTx)
+	if err != nil {
+		return err
+	}
+	return engine.getClient(chainName).SendTransaction(context.Background(), signedTx)
+}
+
+// decodeRawTx returns the stored signed tx of the fill tx
+func decodeRawTx(swapTx *model.SwapFillTx) (*types.Transaction, error) {
+	rawTx, err := hexutil.Decode(swapTx.RawTx)
+	if err != nil {
+		return nil, err
+	}
+	var signedTx types.Transaction
+	if err := rlp.DecodeBytes(rawTx, &signedTx); err != nil {
+		return nil, err
+	}
+	if signedTx.Hash().String() != swapTx.FillSwapTxHash {
+		return nil, fmt.Errorf("hash of the stored raw tx %s doesn't match fill tx hash", signedTx.Hash().String())
+	}
+	return &signedTx, nil
+}
+
+// RebroadcastFillTx re-sends the exact signed bytes of the pending fill tx, it is never signed again, so the nonce
+// and the gas price are unchanged and the fill can't be doubled. The rebroadcast limit of the daemon is not applied.
+func (engine *SwapEngine) RebroadcastFillTx(fillTxHash, operator string) error {
+	swapTx, err := engine.store.GetFillTxByHash(fillTxHash)
+	if err != nil {
+		return fmt.Errorf("query fill tx %s error: %s", fillTxHash, err.Error())
+	}
+	if swapTx.Status != model.FillTxCreated && swapTx.Status != model.FillTxSent {
+		return fmt.Errorf("fill tx %s is not pending, status %d", fillTxHash, swapTx.Status)
+	}
+	if swapTx.RawTx == "" {
+		return fmt.Errorf("raw tx of fill tx %s is not stored", fillTxHash)
+	}
+
+	chainName := getDestChain(swapTx.Direction)
+	if _, err := engine.getClient(chainName).TransactionReceipt(context.Background(), ethcom.HexToHash(fillTxHash)); err == nil {
+		return fmt.Errorf("fill tx %s is mined already", fillTxHash)
+	}
+	if err := engine.rebroadcastSwapTx(chainName, swapTx); err != nil {
+		return fmt.Errorf("rebroadcast fill tx to %s error: %s", chainName, err.Error())
+	}
+	util.Logger.Infof("fill tx rebroadcast to %s by %s, start hash %s, fill hash %s", chainName, operator,
+		swapTx.StartSwapTxHash, swapTx.FillSwapTxHash)
+
+	return engine.store.SetFillTxRebroadcast(swapTx.ID, swapTx.RebroadcastCounter+1, true)
+}
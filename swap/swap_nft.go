@@ -0,0 +1,56 @@
+package swap
+
+import (
+	"fmt"
+	"math/big"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+
+	sabi "occ-swap-server/abi"
+	"occ-swap-server/common"
+)
+
+var nftAgentABI = mustParseABI(sabi.NFTSwapAgentABI)
+
+func isNFTSwap(assetType common.AssetType) bool {
+	return assetType == common.AssetTypeERC721
+}
+
+// getNFTCollection returns the collection of the erc721 pair on the chain, it is the bep20 address of the pair on
+// BSC and the erc20 address on the other chains
+func getNFTCollection(chain string, bep20Addr, erc20Addr string) ethcom.Address {
+	if chain == common.ChainBSC {
+		return ethcom.HexToAddress(bep20Addr)
+	}
+	return ethcom.HexToAddress(erc20Addr)
+}
+
+// getNFTPair returns the erc721 pair of the collection on the chain, it is error if the swaps of the collection
+// are not enabled
+func (engine *SwapEngine) getNFTPair(chain string, collection ethcom.Address) (*SwapPairIns, error) {
+	engine.mutex.RLock()
+	defer engine.mutex.RUnlock()
+
+	erc20Addr := collection
+	if chain == common.ChainBSC {
+		erc20Addr = engine.bep20ToERC20[collection]
+	}
+	pair, ok := engine.swapPairsFromERC20Addr[erc20Addr]
+	if !ok || !isNFTSwap(pair.AssetType) {
+		return nil, fmt.Errorf("collection %s on %s is not an erc721 swap pair", collection.String(), chain)
+	}
+	if !pair.NFTEnabled {
+		return nil, fmt.Errorf("swaps of collection %s on %s are not enabled", collection.String(), chain)
+	}
+	return pair, nil
+}
+
+// abiEncodeFillNFTSwap encodes the fill tx of an erc721 swap, the token is sent from the collection of the
+// destination chain of the swap
+func abiEncodeFillNFTSwap(destChain string, toChainId *big.Int, toAddress ethcom.Address, bep20Addr, erc20Addr, tokenId string) ([]byte, error) {
+	id, ok := big.NewInt(0).SetString(tokenId, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid token id: %s", tokenId)
+	}
+	return nftAgentABI.Pack("fillNFTSwap", big.NewInt(0), toChainId, getNFTCollection(destChain, bep20Addr, erc20Addr), toAddress, id)
+}
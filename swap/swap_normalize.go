@@ -0,0 +1,260 @@
+package swap
+
+import (
+	"fmt"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+// NormalizeAddressesMigration is the data migration normalizing the addresses and tx hashes of the rows written
+// before they were validated on insert
+const NormalizeAddressesMigration = "normalize_addresses"
+
+// repairReport counts the rows of the address repair
+type repairReport struct {
+	repaired int
+	// the rows which can't be normalized, e.g. the sponsor is zero
+	invalid int
+	// the swaps whose record hash doesn't match, they are left to the integrity sweep instead of being re-hashed
+	unverified int
+}
+
+// repairAddresses runs the address repair once, the zero token addresses the engine used to write for the
+// fungible swaps are cleared, the addresses are checksummed and the tx hashes lowercased, then the address
+// constraints are added to the tables
+func (engine *SwapEngine) repairAddresses() {
+	if model.IsMigrationApplied(engine.db, NormalizeAddressesMigration) {
+		return
+	}
+	report := &repairReport{}
+	engine.repairSwapAddresses(report)
+	engine.repairRetrySwapAddresses(report)
+	engine.repairSwapStartTxHashes(report)
+	engine.repairSwapFillTxHashes(report)
+	engine.repairRetrySwapTxHashes(report)
+	engine.repairSwapPairAddresses(report)
+	for _, err := range model.CreateAddressConstraints(engine.db) {
+		util.Logger.Errorf("%s", err.Error())
+	}
+	if err := model.MarkMigrationApplied(engine.db, NormalizeAddressesMigration); err != nil {
+		util.Logger.Errorf("mark migration %s applied error: %s", NormalizeAddressesMigration, err.Error())
+		return
+	}
+
+	util.Logger.Infof("address repair is done, %d rows repaired, %d rows invalid, %d swaps not verified",
+		report.repaired, report.invalid, report.unverified)
+	if report.invalid > 0 || report.unverified > 0 {
+		util.SendTelegramMessage(fmt.Sprintf("Urgent alert: address repair left %d invalid rows and %d swaps whose record hashes don't match, check the logs",
+			report.invalid, report.unverified))
+	}
+}
+
+// clearZeroAddress empties an optional address column holding the zero address
+func clearZeroAddress(addr *string) {
+	if ethcom.IsHexAddress(*addr) && ethcom.HexToAddress(*addr) == (ethcom.Address{}) {
+		*addr = ""
+	}
+}
+
+func (engine *SwapEngine) repairSwapAddresses(report *repairReport) {
+	var lastID uint
+	for {
+		swaps, err := engine.store.FindSwaps(SwapQuery{AfterID: lastID, Limit: BatchSize})
+		if err != nil {
+			util.Logger.Errorf("repair swaps error: %s", err.Error())
+			return
+		}
+		if len(swaps) == 0 {
+			return
+		}
+		for i := range swaps {
+			original := swaps[i]
+			swap := swaps[i]
+			lastID = swap.ID
+
+			clearZeroAddress(&swap.BEP20Addr)
+			clearZeroAddress(&swap.ERC20Addr)
+			if err := swap.Normalize(); err != nil {
+				report.invalid++
+				util.Logger.Errorf("repair swap %d error: %s", swap.ID, err.Error())
+				continue
+			}
+			if swap == original {
+				continue
+			}
+			if !engine.verifySwap(&original) {
+				report.unverified++
+				util.Logger.Errorf("repair swap %d error: record hash doesn't match, start tx hash %s", swap.ID, swap.StartTxHash)
+				continue
+			}
+			if err := engine.updateSwap(engine.store, &swap); err != nil {
+				util.Logger.Errorf("repair swap %d error: %s", swap.ID, err.Error())
+				continue
+			}
+			report.repaired++
+		}
+	}
+}
+
+func (engine *SwapEngine) repairRetrySwapAddresses(report *repairReport) {
+	var lastID uint
+	for {
+		retrySwaps, err := engine.store.FindRetrySwapsAfter(lastID, BatchSize)
+		if err != nil {
+			util.Logger.Errorf("repair retry swaps error: %s", err.Error())
+			return
+		}
+		if len(retrySwaps) == 0 {
+			return
+		}
+		for i := range retrySwaps {
+			original := retrySwaps[i]
+			retrySwap := retrySwaps[i]
+			lastID = retrySwap.ID
+
+			clearZeroAddress(&retrySwap.BEP20Addr)
+			clearZeroAddress(&retrySwap.ERC20Addr)
+			if err := retrySwap.Normalize(); err != nil {
+				report.invalid++
+				util.Logger.Errorf("repair retry swap %d error: %s", retrySwap.ID, err.Error())
+				continue
+			}
+			if retrySwap == original {
+				continue
+			}
+			if !engine.verifyRetrySwap(&original) {
+				report.unverified++
+				util.Logger.Errorf("repair retry swap %d error: record hash doesn't match, start tx hash %s", retrySwap.ID, retrySwap.StartTxHash)
+				continue
+			}
+			engine.updateRetrySwap(engine.store, &retrySwap)
+			report.repaired++
+		}
+	}
+}
+
+func (engine *SwapEngine) repairSwapStartTxHashes(report *repairReport) {
+	var lastID int64
+	for {
+		txEventLogs, err := engine.store.FindStartTxLogsAfter(lastID, BatchSize)
+		if err != nil {
+			util.Logger.Errorf("repair swap start txs error: %s", err.Error())
+			return
+		}
+		if len(txEventLogs) == 0 {
+			return
+		}
+		for i := range txEventLogs {
+			original := txEventLogs[i]
+			txEventLog := txEventLogs[i]
+			lastID = txEventLog.Id
+
+			clearZeroAddress(&txEventLog.TokenAddr)
+			if err := txEventLog.Normalize(); err != nil {
+				report.invalid++
+				util.Logger.Errorf("repair swap start tx %d error: %s", txEventLog.Id, err.Error())
+				continue
+			}
+			if txEventLog != original {
+				if err := engine.store.SaveStartTxLog(&txEventLog); err != nil {
+					util.Logger.Errorf("repair swap start tx %d error: %s", txEventLog.Id, err.Error())
+					continue
+				}
+				report.repaired++
+			}
+		}
+	}
+}
+
+func (engine *SwapEngine) repairSwapFillTxHashes(report *repairReport) {
+	var lastID uint
+	for {
+		swapTxs, err := engine.store.FindFillTxsAfter(lastID, BatchSize)
+		if err != nil {
+			util.Logger.Errorf("repair fill txs error: %s", err.Error())
+			return
+		}
+		if len(swapTxs) == 0 {
+			return
+		}
+		for i := range swapTxs {
+			original := swapTxs[i]
+			swapTx := swapTxs[i]
+			lastID = swapTx.ID
+
+			if err := swapTx.Normalize(); err != nil {
+				report.invalid++
+				util.Logger.Errorf("repair fill tx %d error: %s", swapTx.ID, err.Error())
+				continue
+			}
+			if swapTx != original {
+				if err := engine.store.SaveFillTx(&swapTx); err != nil {
+					util.Logger.Errorf("repair fill tx %d error: %s", swapTx.ID, err.Error())
+					continue
+				}
+				report.repaired++
+			}
+		}
+	}
+}
+
+func (engine *SwapEngine) repairRetrySwapTxHashes(report *repairReport) {
+	var lastID uint
+	for {
+		retrySwapTxs, err := engine.store.FindRetrySwapTxsAfter(lastID, BatchSize)
+		if err != nil {
+			util.Logger.Errorf("repair retry fill txs error: %s", err.Error())
+			return
+		}
+		if len(retrySwapTxs) == 0 {
+			return
+		}
+		for i := range retrySwapTxs {
+			original := retrySwapTxs[i]
+			retrySwapTx := retrySwapTxs[i]
+			lastID = retrySwapTx.ID
+
+			if err := retrySwapTx.Normalize(); err != nil {
+				report.invalid++
+				util.Logger.Errorf("repair retry fill tx %d error: %s", retrySwapTx.ID, err.Error())
+				continue
+			}
+			if retrySwapTx != original {
+				if err := engine.store.SaveRetrySwapTx(&retrySwapTx); err != nil {
+					util.Logger.Errorf("repair retry fill tx %d error: %s", retrySwapTx.ID, err.Error())
+					continue
+				}
+				report.repaired++
+			}
+		}
+	}
+}
+
+// repairSwapPairAddresses only checksums the addresses, the pairs tracked by the engine are keyed by address so
+// they are not affected
+func (engine *SwapEngine) repairSwapPairAddresses(report *repairReport) {
+	pairs, err := engine.store.FindSwapPairs()
+	if err != nil {
+		util.Logger.Errorf("repair swap pairs error: %s", err.Error())
+		return
+	}
+	for i := range pairs {
+		original := pairs[i]
+		pair := pairs[i]
+		if err := pair.Normalize(); err != nil {
+			report.invalid++
+			util.Logger.Errorf("repair swap pair %d error: %s", pair.ID, err.Error())
+			continue
+		}
+		if pair != original {
+			if err := engine.store.SaveSwapPair(&pair); err != nil {
+				util.Logger.Errorf("repair swap pair %d error: %s", pair.ID, err.Error())
+				continue
+			}
+			report.repaired++
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package swap
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/jinzhu/gorm"
+
+	"occ-swap-server/common"
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+// migratableSwapStatuses are the statuses of the swaps mapped to the new tokens of a migrated pair, the unfilled
+// swaps and the failed swaps which may be retried
+var migratableSwapStatuses = append([]common.SwapStatus{SwapSendFailed, SwapAbandoned}, unfilledSwapStatuses...)
+
+type MigrateSwapPairRequest struct {
+	// the erc20 address of the pair to migrate, and the new tokens of the pair, a token which doesn't migrate is the
+	// same as the old one
+	OldERC20Addr string
+	BEP20Addr    string
+	ERC20Addr    string
+	Reason       string
+	Operator     string
+}
+
+// MigrateSwapPair moves the swap pair to new token contracts. The old pair must be paused first, so that none of
+// its swaps is being filled, the migration fails while any of them is still sent or retried. In one db tx the old
+// pair is disabled and soft deleted, the new pair is registered with the metadata of the old one, the unfilled and
+// the failed swaps of the old pair are mapped to the new tokens, and the migration is recorded. The owners, the
+// pause and the fill methods of the old pair move to the new pair, it is resumed by the admin once the new tokens
+// are checked.
+func (engine *SwapEngine) MigrateSwapPair(req MigrateSwapPairRequest) (*model.PairMigration, *model.SwapPair, error) {
+	oldERC20Addr := ethcom.HexToAddress(req.OldERC20Addr)
+	if !engine.IsPairPaused(oldERC20Addr) {
+		return nil, nil, fmt.Errorf("swap pair %s is not paused, pause it first so that none of its swaps is being filled",
+			oldERC20Addr.String())
+	}
+
+	var migration *model.PairMigration
+	var newPair *model.SwapPair
+	err := func() error {
+		tx := engine.db.Begin()
+		if err := tx.Error; err != nil {
+			return err
+		}
+		var err error
+		migration, newPair, err = engine.migrateSwapPair(tx, oldERC20Addr, req)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit().Error
+	}()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	engine.mutex.Lock()
+	delete(engine.swapPairsFromERC20Addr, oldERC20Addr)
+	delete(engine.bep20ToERC20, ethcom.HexToAddress(migration.OldBEP20Addr))
+	delete(engine.erc20ToBEP20, oldERC20Addr)
+	delete(engine.pausedPairs, oldERC20Addr)
+	engine.pausedPairs[ethcom.HexToAddress(newPair.ERC20Addr)] = true
+	if fillMethods, ok := engine.fillMethods[oldERC20Addr]; ok {
+		delete(engine.fillMethods, oldERC20Addr)
+		engine.fillMethods[ethcom.HexToAddress(newPair.ERC20Addr)] = fillMethods
+	}
+	engine.mutex.Unlock()
+	if err := engine.AddSwapPairInstance(newPair); err != nil {
+		return nil, nil, err
+	}
+
+	util.Logger.Infof("swap pair %s is migrated by %s from bep20 address %s, erc20 address %s to bep20 address %s, erc20 address %s, %d swaps are mapped: %s",
+		migration.Symbol, migration.Operator, migration.OldBEP20Addr, migration.OldERC20Addr, migration.NewBEP20Addr,
+		migration.NewERC20Addr, migration.MigratedSwaps, migration.Reason)
+	return migration, newPair, nil
+}
+
+func (engine *SwapEngine) migrateSwapPair(tx *gorm.DB, oldERC20Addr ethcom.Address, req MigrateSwapPairRequest) (*model.PairMigration, *model.SwapPair, error) {
+	oldPair := model.SwapPair{}
+	if err := model.LockForUpdate(tx).Where("erc20_addr = ?", oldERC20Addr.String()).First(&oldPair).Error; err != nil {
+		return nil, nil, fmt.Errorf("swapPair %s is not found", oldERC20Addr.String())
+	}
+	newBEP20Addr := ethcom.HexToAddress(req.BEP20Addr).String()
+	newERC20Addr := ethcom.HexToAddress(req.ERC20Addr).String()
+	if newBEP20Addr == oldPair.BEP20Addr && newERC20Addr == oldPair.ERC20Addr {
+		return nil, nil, fmt.Errorf("the tokens of swap pair %s don't change", oldPair.Symbol)
+	}
+	for _, tokens := range [][2]string{{newBEP20Addr, oldPair.BEP20Addr}, {newERC20Addr, oldPair.ERC20Addr}} {
+		if tokens[0] == tokens[1] {
+			continue
+		}
+		if err := checkPairNotExist(NewGormStore(tx), tokens[0]); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	pairQuery := "bep20_addr = ? and erc20_addr = ?"
+	inFlight := 0
+	err := tx.Model(model.Swap{}).Where(pairQuery, oldPair.BEP20Addr, oldPair.ERC20Addr).
+		Where("status in (?) or claimed_until >= ?", []common.SwapStatus{SwapSending, SwapSent}, time.Now().Unix()).
+		Count(&inFlight).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	retried := 0
+	err = tx.Model(model.RetrySwap{}).Where(pairQuery, oldPair.BEP20Addr, oldPair.ERC20Addr).
+		Where("status in (?)", activeRetrySwapStatuses).Count(&retried).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	if inFlight+retried != 0 {
+		return nil, nil, fmt.Errorf("%d swaps of swap pair %s are being filled or retried, migrate it once they finish",
+			inFlight+retried, oldPair.Symbol)
+	}
+
+	swaps := make([]model.Swap, 0)
+	err = model.LockForUpdate(tx).Where(pairQuery, oldPair.BEP20Addr, oldPair.ERC20Addr).
+		Where("status in (?)", migratableSwapStatuses).Order("id asc").Find(&swaps).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range swaps {
+		if !engine.verifySwap(&swaps[i]) {
+			return nil, nil, fmt.Errorf("verify hmac of swap failed, start tx hash %s, it is not migrated", swaps[i].StartTxHash)
+		}
+	}
+
+	newPair := oldPair
+	newPair.Model = gorm.Model{}
+	newPair.BEP20Addr = newBEP20Addr
+	newPair.ERC20Addr = newERC20Addr
+	if err := tx.Create(&newPair).Error; err != nil {
+		return nil, nil, err
+	}
+	startTxHashes := make([]string, 0, len(swaps))
+	for i := range swaps {
+		swap := &swaps[i]
+		swap.BEP20Addr = newPair.BEP20Addr
+		swap.ERC20Addr = newPair.ERC20Addr
+		if err := engine.updateSwap(NewGormStore(tx), swap); err != nil {
+			return nil, nil, err
+		}
+		startTxHashes = append(startTxHashes, swap.StartTxHash)
+	}
+	if newPair.ERC20Addr != oldPair.ERC20Addr {
+		for _, table := range []interface{}{model.PairOwner{}, model.PausedPair{}, model.PairFillMethod{}} {
+			if err := tx.Model(table).Where("erc20_addr = ?", oldPair.ERC20Addr).UpdateColumn("erc20_addr", newPair.ERC20Addr).Error; err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	oldPair.Available = false
+	if err := tx.Save(&oldPair).Error; err != nil {
+		return nil, nil, err
+	}
+	if err := tx.Delete(&oldPair).Error; err != nil {
+		return nil, nil, err
+	}
+
+	hashes, err := json.Marshal(startTxHashes)
+	if err != nil {
+		return nil, nil, err
+	}
+	migration := &model.PairMigration{
+		OldPairID:     oldPair.ID,
+		NewPairID:     newPair.ID,
+		Symbol:        oldPair.Symbol,
+		OldBEP20Addr:  oldPair.BEP20Addr,
+		OldERC20Addr:  oldPair.ERC20Addr,
+		NewBEP20Addr:  newPair.BEP20Addr,
+		NewERC20Addr:  newPair.ERC20Addr,
+		MigratedSwaps: int64(len(swaps)),
+		StartTxHashes: string(hashes),
+		Reason:        req.Reason,
+		Operator:      req.Operator,
+	}
+	if err := tx.Create(migration).Error; err != nil {
+		return nil, nil, err
+	}
+	return migration, &newPair, nil
+}
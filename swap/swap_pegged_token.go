@@ -0,0 +1,330 @@
+package swap
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	sabi "occ-swap-server/abi"
+	"occ-swap-server/common"
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+// PeggedTokenTxTimeout is how long the deploy tx of a pegged token may stay unmined before the deployment fails
+const PeggedTokenTxTimeout = 30 * time.Minute
+
+var peggedTokenFactoryABI = mustParseABI(sabi.PeggedTokenFactoryABI)
+
+// activePeggedTokenStatuses are the statuses of the deployments whose deploy tx may still be sent or mined
+var activePeggedTokenStatuses = []model.PeggedTokenStatus{model.PeggedTokenPending, model.PeggedTokenDeploying}
+
+// RegisterSwapPairRequest is a new swap pair. If PeggedTokenChain is set, the token of the pair on that chain
+// doesn't exist yet and is deployed by the factory of the chain, its address is left empty: the bep20 address on
+// BSC, or the erc20 address on the other chains. FillMethods replace the fillSwap call on their chains, they are
+// checked against the code of the swap agents.
+type RegisterSwapPairRequest struct {
+	Sponsor    string
+	BEP20Addr  string
+	ERC20Addr  string
+	Symbol     string
+	Name       string
+	Decimals   int
+	LowBound   string
+	UpperBound string
+	IconUrl    string
+	Available  bool
+
+	PeggedTokenChain string
+	FillMethods      []FillMethodRequest
+}
+
+// RegisterSwapPair saves the swap pair of the existing tokens, or the deployment of the missing pegged token which
+// saves the swap pair once the token is deployed, see deployPeggedTokensDaemon
+func (engine *SwapEngine) RegisterSwapPair(req RegisterSwapPairRequest) (*model.SwapPair, *model.PeggedTokenDeployment, error) {
+	if req.PeggedTokenChain == "" {
+		if req.BEP20Addr == "" || req.ERC20Addr == "" {
+			return nil, nil, fmt.Errorf("bep20_addr and erc20_addr are required unless the pegged token is deployed")
+		}
+		swapPair := &model.SwapPair{
+			Sponsor:    req.Sponsor,
+			Symbol:     req.Symbol,
+			Name:       req.Name,
+			Decimals:   req.Decimals,
+			BEP20Addr:  ethcom.HexToAddress(req.BEP20Addr).String(),
+			ERC20Addr:  ethcom.HexToAddress(req.ERC20Addr).String(),
+			Available:  req.Available,
+			LowBound:   req.LowBound,
+			UpperBound: req.UpperBound,
+			IconUrl:    req.IconUrl,
+			AssetType:  common.AssetTypeFungible,
+		}
+		fillMethods, err := engine.newPairFillMethods(swapPair.ERC20Addr, req.FillMethods)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := engine.createSwapPair(swapPair, fillMethods...); err != nil {
+			return nil, nil, err
+		}
+		return swapPair, nil, nil
+	}
+	if len(req.FillMethods) != 0 {
+		return nil, nil, fmt.Errorf("fill_methods can't be set while the pegged token is deployed")
+	}
+
+	chain := req.PeggedTokenChain
+	if _, ok := engine.config.PeggedTokenConfig.GetFactory(chain); !ok {
+		return nil, nil, fmt.Errorf("no pegged token factory on %s", chain)
+	}
+	sourceToken := req.BEP20Addr
+	if chain == common.ChainBSC {
+		sourceToken = req.ERC20Addr
+		if req.BEP20Addr != "" {
+			return nil, nil, fmt.Errorf("bep20_addr should be empty, the pegged token on %s is deployed", chain)
+		}
+	} else if req.ERC20Addr != "" {
+		return nil, nil, fmt.Errorf("erc20_addr should be empty, the pegged token on %s is deployed", chain)
+	}
+	if sourceToken == "" {
+		return nil, nil, fmt.Errorf("the token pegged by the deployed token is required")
+	}
+	if req.Decimals <= 0 || req.Decimals > 255 {
+		return nil, nil, fmt.Errorf("decimals should be between 1 and 255")
+	}
+	broadcasters := engine.relayerPools[chain].getBroadcasters()
+	if len(broadcasters) == 0 {
+		return nil, nil, fmt.Errorf("no relayer on %s", chain)
+	}
+
+	deployment := &model.PeggedTokenDeployment{
+		Chain:       chain,
+		SourceToken: ethcom.HexToAddress(sourceToken).String(),
+		Deployer:    broadcasters[0].Account().String(),
+		Sponsor:     req.Sponsor,
+		Symbol:      req.Symbol,
+		Name:        req.Name,
+		Decimals:    req.Decimals,
+		LowBound:    req.LowBound,
+		UpperBound:  req.UpperBound,
+		IconUrl:     req.IconUrl,
+		Available:   req.Available,
+		Status:      model.PeggedTokenPending,
+	}
+	err := engine.store.Transaction(func(tx SwapStore) error {
+		if err := checkPairNotExist(tx, deployment.SourceToken); err != nil {
+			return err
+		}
+		active, err := tx.CountPeggedTokenDeployments(deployment.SourceToken, activePeggedTokenStatuses)
+		if err != nil {
+			return err
+		}
+		if active != 0 {
+			return fmt.Errorf("the pegged token of %s is being deployed", deployment.SourceToken)
+		}
+		return tx.CreatePeggedTokenDeployment(deployment)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	util.Logger.Infof("deploy pegged token %s of %s on %s, deployment %d", deployment.Symbol, deployment.SourceToken,
+		deployment.Chain, deployment.ID)
+	return nil, deployment, nil
+}
+
+// checkPairNotExist returns error if the token is in a swap pair already
+func checkPairNotExist(tx SwapStore, token string) error {
+	exists, err := tx.IsTokenInPair(token)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("token %s is in a swap pair already", token)
+	}
+	return nil
+}
+
+// createSwapPair saves the swap pair and its fill methods, the swaps of the pair are filled at once if it is available
+func (engine *SwapEngine) createSwapPair(swapPair *model.SwapPair, fillMethods ...*model.PairFillMethod) error {
+	err := engine.store.Transaction(func(tx SwapStore) error {
+		for _, token := range []string{swapPair.BEP20Addr, swapPair.ERC20Addr} {
+			if err := checkPairNotExist(tx, token); err != nil {
+				return err
+			}
+		}
+		if err := tx.CreateSwapPair(swapPair); err != nil {
+			return err
+		}
+		for _, fillMethod := range fillMethods {
+			if err := tx.CreatePairFillMethod(fillMethod); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	engine.mutex.Lock()
+	for _, fillMethod := range fillMethods {
+		if err := addFillMethod(engine.fillMethods, fillMethod); err != nil {
+			util.Logger.Errorf("add fill method error: %s", err.Error())
+		}
+	}
+	engine.mutex.Unlock()
+	if swapPair.Available {
+		return engine.AddSwapPairInstance(swapPair)
+	}
+	return nil
+}
+
+// GetPeggedTokenDeployment returns the deployment of the id
+func (engine *SwapEngine) GetPeggedTokenDeployment(id uint) (*model.PeggedTokenDeployment, error) {
+	return engine.store.GetPeggedTokenDeployment(id)
+}
+
+// deployPeggedTokensDaemon sends the deploy txs of the pending deployments and saves the swap pairs once the txs
+// are confirmed. The deployments whose deployer is the relayer of another executor are left to it.
+func (engine *SwapEngine) deployPeggedTokensDaemon() error {
+	deployments, err := engine.store.FindPeggedTokenDeployments(activePeggedTokenStatuses, BatchSize)
+	if err != nil {
+		return err
+	}
+
+	for i := range deployments {
+		deployment := &deployments[i]
+		pool, ok := engine.relayerPools[deployment.Chain]
+		if !ok || engine.isChainDegraded(deployment.Chain) {
+			continue
+		}
+		broadcaster := pool.getBroadcaster(ethcom.HexToAddress(deployment.Deployer))
+		if broadcaster == nil {
+			continue
+		}
+		if err := engine.deployPeggedToken(broadcaster, deployment); err != nil {
+			engine.failPeggedTokenDeployment(deployment, err.Error())
+		}
+	}
+	return nil
+}
+
+func (engine *SwapEngine) deployPeggedToken(broadcaster *Broadcaster, deployment *model.PeggedTokenDeployment) error {
+	factory, ok := engine.config.PeggedTokenConfig.GetFactory(deployment.Chain)
+	if !ok {
+		return fmt.Errorf("no pegged token factory on %s", deployment.Chain)
+	}
+
+	switch deployment.Status {
+	case model.PeggedTokenPending:
+		data, err := peggedTokenFactoryABI.Pack("createPeggedToken", deployment.Name, deployment.Symbol,
+			uint8(deployment.Decimals), ethcom.HexToAddress(deployment.SourceToken), engine.getSwapAgent(deployment.Chain))
+		if err != nil {
+			return err
+		}
+		use := KeyUse{Purpose: model.KeyUsageDeploy, Daemon: "deploy_pegged_tokens"}
+		_, err = broadcaster.Broadcast(BroadcastPriorityNormal, use, factory, data, func(signedTx *types.Transaction, gasEstimate uint64) error {
+			return engine.store.SetPeggedTokenDeploymentTx(deployment.ID, model.PeggedTokenDeploying, signedTx.Hash().String())
+		})
+		if err != nil {
+			util.Logger.Errorf("send deploy tx of pegged token deployment %d error: %s", deployment.ID, err.Error())
+			// the deploy tx is sent again in the next round
+			if err := engine.store.SetPeggedTokenDeploymentTx(deployment.ID, model.PeggedTokenPending, ""); err != nil {
+				util.Logger.Errorf("write db error: %s", err.Error())
+			}
+		}
+		return nil
+	case model.PeggedTokenDeploying:
+		token, err := engine.getDeployedPeggedToken(deployment, factory)
+		if err != nil || token == (ethcom.Address{}) {
+			return err
+		}
+		return engine.savePeggedTokenPair(deployment, token)
+	}
+	return nil
+}
+
+// getDeployedPeggedToken returns the token created by the deploy tx once the tx is confirmed, it is the zero
+// address until then. It is error if the tx is failed, is not mined in PeggedTokenTxTimeout or created no token.
+func (engine *SwapEngine) getDeployedPeggedToken(deployment *model.PeggedTokenDeployment, factory ethcom.Address) (ethcom.Address, error) {
+	receipt, err := engine.getClient(deployment.Chain).TransactionReceipt(context.Background(), ethcom.HexToHash(deployment.TxHash))
+	if err == ethereum.NotFound {
+		if time.Since(deployment.UpdatedAt) > PeggedTokenTxTimeout {
+			return ethcom.Address{}, fmt.Errorf("deploy tx %s is not mined in %s", deployment.TxHash, PeggedTokenTxTimeout.String())
+		}
+		return ethcom.Address{}, nil
+	} else if err != nil {
+		util.Logger.Errorf("query receipt of deploy tx %s of pegged token deployment %d error: %s", deployment.TxHash, deployment.ID, err.Error())
+		return ethcom.Address{}, nil
+	}
+	if receipt.Status == TxFailedStatus {
+		return ethcom.Address{}, fmt.Errorf("deploy tx %s is failed", deployment.TxHash)
+	}
+	height, err := engine.heads[deployment.Chain].Height()
+	if err != nil {
+		return ethcom.Address{}, nil
+	}
+	if height-receipt.BlockNumber.Int64()+1 < engine.config.ChainConfig.GetConfirmNum(deployment.Chain) {
+		return ethcom.Address{}, nil
+	}
+
+	event := peggedTokenFactoryABI.Events["PeggedTokenCreated"]
+	for _, log := range receipt.Logs {
+		if log.Address != factory || len(log.Topics) != 3 || log.Topics[0] != event.ID() {
+			continue
+		}
+		if ethcom.BytesToAddress(log.Topics[1].Bytes()) != ethcom.HexToAddress(deployment.SourceToken) {
+			continue
+		}
+		return ethcom.BytesToAddress(log.Topics[2].Bytes()), nil
+	}
+	return ethcom.Address{}, fmt.Errorf("deploy tx %s has no PeggedTokenCreated event of %s", deployment.TxHash, deployment.SourceToken)
+}
+
+// savePeggedTokenPair saves the swap pair with the deployed token and marks the deployment as deployed
+func (engine *SwapEngine) savePeggedTokenPair(deployment *model.PeggedTokenDeployment, token ethcom.Address) error {
+	swapPair := &model.SwapPair{
+		Sponsor:    deployment.Sponsor,
+		Symbol:     deployment.Symbol,
+		Name:       deployment.Name,
+		Decimals:   deployment.Decimals,
+		BEP20Addr:  deployment.SourceToken,
+		ERC20Addr:  token.String(),
+		Available:  deployment.Available,
+		LowBound:   deployment.LowBound,
+		UpperBound: deployment.UpperBound,
+		IconUrl:    deployment.IconUrl,
+		AssetType:  common.AssetTypeFungible,
+	}
+	if deployment.Chain == common.ChainBSC {
+		swapPair.BEP20Addr, swapPair.ERC20Addr = token.String(), deployment.SourceToken
+	}
+	// the token is kept even if the swap pair can't be saved
+	if err := engine.store.SetPeggedTokenDeploymentToken(deployment.ID, token.String()); err != nil {
+		return err
+	}
+	if err := engine.createSwapPair(swapPair); err != nil {
+		return fmt.Errorf("save swap pair of deployed token %s error: %s", token.String(), err.Error())
+	}
+	if err := engine.store.SetPeggedTokenDeploymentStatus(deployment.ID, model.PeggedTokenDeployed, ""); err != nil {
+		util.Logger.Errorf("write db error: %s", err.Error())
+	}
+	util.Logger.Infof("pegged token %s of %s is deployed on %s at %s, swap pair %d is saved", deployment.Symbol,
+		deployment.SourceToken, deployment.Chain, token.String(), swapPair.ID)
+	util.SendTelegramMessage(fmt.Sprintf("pegged token %s of %s is deployed on %s at %s, swap pair is saved, available %t",
+		deployment.Symbol, deployment.SourceToken, deployment.Chain, token.String(), swapPair.Available))
+	return nil
+}
+
+func (engine *SwapEngine) failPeggedTokenDeployment(deployment *model.PeggedTokenDeployment, errorMsg string) {
+	if err := engine.store.SetPeggedTokenDeploymentStatus(deployment.ID, model.PeggedTokenFailed, errorMsg); err != nil {
+		util.Logger.Errorf("write db error: %s", err.Error())
+		return
+	}
+	msg := fmt.Sprintf("pegged token deployment %d of %s on %s is failed at %s: %s", deployment.ID, deployment.SourceToken,
+		deployment.Chain, deployment.Status, errorMsg)
+	util.Logger.Errorf(msg)
+	util.SendTelegramMessage(msg)
+}
@@ -0,0 +1,244 @@
+package swap
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	sabi "occ-swap-server/abi"
+)
+
+var (
+	permit2ABI      = mustParseABI(sabi.Permit2ABI)
+	permit2AgentABI = mustParseABI(sabi.Permit2SwapAgentABI)
+
+	tokenPermissionsTypeHash   = crypto.Keccak256Hash([]byte("TokenPermissions(address token,uint256 amount)"))
+	permitTransferFromTypeHash = crypto.Keccak256Hash([]byte("PermitTransferFrom(TokenPermissions permitted,address spender,uint256 nonce,uint256 deadline)TokenPermissions(address token,uint256 amount)"))
+)
+
+// Permit2Params are the fields of the permit2 transfer the owner signs to start a swap in one tx, the spender is the
+// swap agent pulling the amount in swapWithPermit2. The approve tx of permit2 is only set if the allowance of the
+// owner to permit2 is less than the amount, it is sent once and mined before the swap tx.
+type Permit2Params struct {
+	Chain           string      `json:"chain"`
+	Direction       string      `json:"direction"`
+	Symbol          string      `json:"symbol"`
+	Permit2         string      `json:"permit2"`
+	Token           string      `json:"token"`
+	Spender         string      `json:"spender"`
+	Amount          string      `json:"amount"`
+	Nonce           string      `json:"nonce"`
+	Deadline        int64       `json:"deadline"`
+	DomainSeparator string      `json:"domain_separator"`
+	SwapFee         string      `json:"swap_fee"`
+	Allowance       string      `json:"allowance"`
+	Approve         *UnsignedTx `json:"approve,omitempty"`
+	// the EIP-712 typed data of the permit for eth_signTypedData_v4
+	TypedData interface{} `json:"typed_data"`
+}
+
+// Permit2SwapRequest is a swap of the owner started with the permit2 transfer signed by the owner
+type Permit2SwapRequest struct {
+	Chain     string
+	Pair      ethcom.Address
+	Owner     ethcom.Address
+	ToChainId string
+	Amount    *big.Int
+	Nonce     *big.Int
+	Deadline  int64
+	Signature []byte
+}
+
+// GetPermit2Params returns the fields of the permit2 transfer the owner signs to swap the amount of the pair on the
+// chain to the chain id, the nonce is an unused bit of a random word of the nonce bitmap of the owner
+func (engine *SwapEngine) GetPermit2Params(chain string, erc20Addr ethcom.Address, amount *big.Int, toChainId string,
+	owner ethcom.Address) (*Permit2Params, error) {
+	if !engine.config.Permit2Config.IsPermit2Chain(chain) {
+		return nil, fmt.Errorf("swaps on %s can't be started with permit2", chain)
+	}
+	start, err := engine.checkSwapStart(chain, erc20Addr, amount, toChainId, owner)
+	if err != nil {
+		return nil, err
+	}
+	permit2 := engine.config.Permit2Config.GetPermit2Addr(chain)
+	var domainSeparator [32]byte
+	if err := engine.callToken(chain, permit2, &permit2ABI, &domainSeparator, "DOMAIN_SEPARATOR"); err != nil {
+		return nil, fmt.Errorf("query domain separator of permit2 %s error: %s", permit2.String(), err.Error())
+	}
+	nonce, err := engine.findPermit2Nonce(chain, permit2, owner)
+	if err != nil {
+		return nil, err
+	}
+	allowance := big.NewInt(0)
+	if err := engine.callToken(chain, start.token, &erc20TokenABI, &allowance, "allowance", owner, permit2); err != nil {
+		return nil, fmt.Errorf("query allowance of %s error: %s", owner.String(), err.Error())
+	}
+	deadline := time.Now().Add(engine.config.Permit2Config.GetDeadline()).Unix()
+
+	params := &Permit2Params{
+		Chain:           chain,
+		Direction:       string(start.direction),
+		Symbol:          start.pair.Symbol,
+		Permit2:         permit2.String(),
+		Token:           start.token.String(),
+		Spender:         start.swapAgent.String(),
+		Amount:          amount.String(),
+		Nonce:           nonce.String(),
+		Deadline:        deadline,
+		DomainSeparator: hexutil.Encode(domainSeparator[:]),
+		SwapFee:         start.swapFee.String(),
+		Allowance:       allowance.String(),
+		TypedData:       permit2TypedData(start, permit2, nonce, deadline),
+	}
+	if allowance.Cmp(amount) < 0 {
+		// permit2 is approved the max amount once, the permits limit what it transfers
+		approveData, err := erc20TokenABI.Pack("approve", permit2, math.MaxBig256)
+		if err != nil {
+			return nil, err
+		}
+		params.Approve = &UnsignedTx{
+			ChainID: strconv.FormatInt(start.chainID, 10),
+			From:    owner.String(),
+			To:      start.token.String(),
+			Value:   "0",
+			Data:    hexutil.Encode(approveData),
+		}
+	}
+	return params, nil
+}
+
+// BuildPermit2SwapTx checks the permit2 signature of the request and builds the swapWithPermit2 tx the owner sends to
+// the swap agent, only the permits signed by the owner account itself are accepted
+func (engine *SwapEngine) BuildPermit2SwapTx(req Permit2SwapRequest) (*UnsignedTx, error) {
+	if !engine.config.Permit2Config.IsPermit2Chain(req.Chain) {
+		return nil, fmt.Errorf("swaps on %s can't be started with permit2", req.Chain)
+	}
+	if req.Deadline <= time.Now().Unix() {
+		return nil, fmt.Errorf("deadline %d of the permit is passed", req.Deadline)
+	}
+	start, err := engine.checkSwapStart(req.Chain, req.Pair, req.Amount, req.ToChainId, req.Owner)
+	if err != nil {
+		return nil, err
+	}
+	permit2 := engine.config.Permit2Config.GetPermit2Addr(req.Chain)
+	var domainSeparator [32]byte
+	if err := engine.callToken(req.Chain, permit2, &permit2ABI, &domainSeparator, "DOMAIN_SEPARATOR"); err != nil {
+		return nil, fmt.Errorf("query domain separator of permit2 %s error: %s", permit2.String(), err.Error())
+	}
+	signer, err := recoverPermit2Signer(domainSeparator, start.token, start.swapAgent, req.Amount, req.Nonce, req.Deadline, req.Signature)
+	if err != nil {
+		return nil, err
+	}
+	if signer != req.Owner {
+		return nil, fmt.Errorf("permit is signed by %s instead of the owner %s", signer.String(), req.Owner.String())
+	}
+	allowance := big.NewInt(0)
+	if err := engine.callToken(req.Chain, start.token, &erc20TokenABI, &allowance, "allowance", req.Owner, permit2); err != nil {
+		return nil, fmt.Errorf("query allowance of %s error: %s", req.Owner.String(), err.Error())
+	}
+	if allowance.Cmp(req.Amount) < 0 {
+		return nil, fmt.Errorf("allowance %s of the owner to permit2 is less than the amount", allowance.String())
+	}
+
+	data, err := permit2AgentABI.Pack("swapWithPermit2", big.NewInt(start.chainID), start.toChainID, req.Amount,
+		req.Nonce, big.NewInt(req.Deadline), req.Signature)
+	if err != nil {
+		return nil, err
+	}
+	return &UnsignedTx{
+		ChainID: strconv.FormatInt(start.chainID, 10),
+		From:    req.Owner.String(),
+		To:      start.swapAgent.String(),
+		Value:   start.swapFee.String(),
+		Data:    hexutil.Encode(data),
+	}, nil
+}
+
+// findPermit2Nonce returns an unused nonce of the owner, the nonces of permit2 are the bits of the words of the
+// nonce bitmap, so a random word is picked and its first unset bit is used
+func (engine *SwapEngine) findPermit2Nonce(chain string, permit2, owner ethcom.Address) (*big.Int, error) {
+	for i := 0; i < 3; i++ {
+		wordPos, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 248))
+		if err != nil {
+			return nil, err
+		}
+		bitmap := big.NewInt(0)
+		if err := engine.callToken(chain, permit2, &permit2ABI, &bitmap, "nonceBitmap", owner, wordPos); err != nil {
+			return nil, fmt.Errorf("query nonce bitmap of %s error: %s", owner.String(), err.Error())
+		}
+		for bit := 0; bit < 256; bit++ {
+			if bitmap.Bit(bit) == 0 {
+				return new(big.Int).Or(new(big.Int).Lsh(wordPos, 8), big.NewInt(int64(bit))), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no unused permit2 nonce of %s found", owner.String())
+}
+
+// permit2TypedData is the PermitTransferFrom of the swap for the wallets to sign
+func permit2TypedData(start *swapStart, permit2 ethcom.Address, nonce *big.Int, deadline int64) interface{} {
+	type field struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+	return map[string]interface{}{
+		"types": map[string][]field{
+			"EIP712Domain":       {{"name", "string"}, {"chainId", "uint256"}, {"verifyingContract", "address"}},
+			"TokenPermissions":   {{"token", "address"}, {"amount", "uint256"}},
+			"PermitTransferFrom": {{"permitted", "TokenPermissions"}, {"spender", "address"}, {"nonce", "uint256"}, {"deadline", "uint256"}},
+		},
+		"primaryType": "PermitTransferFrom",
+		"domain": map[string]interface{}{
+			"name":              "Permit2",
+			"chainId":           strconv.FormatInt(start.chainID, 10),
+			"verifyingContract": permit2.String(),
+		},
+		"message": map[string]interface{}{
+			"permitted": map[string]string{"token": start.token.String(), "amount": start.amount.String()},
+			"spender":   start.swapAgent.String(),
+			"nonce":     nonce.String(),
+			"deadline":  strconv.FormatInt(deadline, 10),
+		},
+	}
+}
+
+func recoverPermit2Signer(domainSeparator ethcom.Hash, token, spender ethcom.Address, amount, nonce *big.Int,
+	deadline int64, signature []byte) (ethcom.Address, error) {
+	if len(signature) != 65 {
+		return ethcom.Address{}, fmt.Errorf("signature should be 65 bytes")
+	}
+	if nonce == nil || nonce.Sign() < 0 || nonce.BitLen() > 256 {
+		return ethcom.Address{}, fmt.Errorf("invalid nonce")
+	}
+	permittedHash := crypto.Keccak256(
+		tokenPermissionsTypeHash.Bytes(),
+		ethcom.LeftPadBytes(token.Bytes(), 32),
+		ethcom.LeftPadBytes(amount.Bytes(), 32),
+	)
+	structHash := crypto.Keccak256(
+		permitTransferFromTypeHash.Bytes(),
+		permittedHash,
+		ethcom.LeftPadBytes(spender.Bytes(), 32),
+		ethcom.LeftPadBytes(nonce.Bytes(), 32),
+		ethcom.LeftPadBytes(big.NewInt(deadline).Bytes(), 32),
+	)
+	digest := crypto.Keccak256([]byte("\x19\x01"), domainSeparator.Bytes(), structHash)
+
+	sig := make([]byte, 65)
+	copy(sig, signature)
+	if sig[64] >= 27 {
+		sig[64] -= 27
+	}
+	publicKey, err := crypto.SigToPub(digest, sig)
+	if err != nil {
+		return ethcom.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*publicKey), nil
+}
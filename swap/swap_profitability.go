@@ -0,0 +1,162 @@
+package swap
+
+import (
+	"fmt"
+	"math/big"
+
+	"occ-swap-server/model"
+	"occ-swap-server/price"
+	"occ-swap-server/util"
+)
+
+// isProfitabilityChecked returns whether the swap is small enough for the fill gas to be checked
+func (engine *SwapEngine) isProfitabilityChecked(pairCfg util.PairProfitabilityConfig, swap *model.Swap, amount *big.Int) (bool, error) {
+	if maxAmount, ok := big.NewInt(0).SetString(pairCfg.MaxAmount, 10); ok && amount.Cmp(maxAmount) <= 0 {
+		return true, nil
+	}
+	if pairCfg.MaxAmountUsd <= 0 {
+		return false, nil
+	}
+	value, err := engine.prices.TokenValue(swap.ERC20Addr, amount)
+	if err != nil {
+		return false, err
+	}
+	return value <= pairCfg.MaxAmountUsd, nil
+}
+
+// checkFillProfitability returns why the swap is uneconomic, it is empty if the bridge fee of the swap covers the
+// gas of its fill tx or the swap is not checked. The swaps of the tiered sponsors are not checked, their fees are
+// rebated by the deals of the tiers.
+func (engine *SwapEngine) checkFillProfitability(destChain string, swap *model.Swap) (string, error) {
+	cfg := engine.config.ProfitabilityConfig
+	if !cfg.Enabled() || swap.FillOverride || isNFTSwap(swap.AssetType) {
+		return "", nil
+	}
+	if tier, err := engine.getSponsorTier(swap.Sponsor); err != nil || tier != nil {
+		return "", err
+	}
+	pairCfg, ok := cfg.GetPair(swap.ERC20Addr)
+	if !ok {
+		return "", nil
+	}
+	amount, ok := big.NewInt(0).SetString(swap.Amount, 10)
+	if !ok {
+		return "", nil
+	}
+	if checked, err := engine.isProfitabilityChecked(pairCfg, swap, amount); err != nil || !checked {
+		return "", err
+	}
+	token, ok := engine.config.PriceConfig.GetToken(swap.ERC20Addr)
+	if !ok {
+		return "", fmt.Errorf("token %s is not valued", swap.ERC20Addr)
+	}
+
+	txEventLog, err := engine.store.GetStartTxLog(swap.StartTxHash)
+	if err != nil {
+		return "", fmt.Errorf("query start tx log of swap %s error: %s", swap.StartTxHash, err.Error())
+	}
+	fee, ok := big.NewInt(0).SetString(txEventLog.FeeAmount, 10)
+	if !ok {
+		fee = big.NewInt(0)
+	}
+
+	toChainID, _ := big.NewInt(0).SetString(swap.ToChainId, 10)
+	data, err := engine.encodeFillSwap(newFillCall(swap, toChainID, amount))
+	if err != nil {
+		return "", err
+	}
+	gasPrice, gasLimit, err := engine.estimateFillGas(destChain, data)
+	if err != nil {
+		return "", err
+	}
+	gasCost := new(big.Int).Mul(gasPrice, new(big.Int).SetUint64(gasLimit))
+
+	tokenPrice, err := engine.prices.Price(token.Symbol)
+	if err != nil {
+		return "", err
+	}
+	sourcePrice, err := engine.prices.NativePrice(txEventLog.Chain)
+	if err != nil {
+		return "", err
+	}
+	destPrice, err := engine.prices.NativePrice(destChain)
+	if err != nil {
+		return "", err
+	}
+
+	// the native tokens of all the chains have 18 decimals
+	feeInToken := price.FromFloat(price.ToFloat(fee, 18)*sourcePrice/tokenPrice, token.Decimals)
+	gasInToken := price.FromFloat(price.ToFloat(gasCost, 18)*destPrice/tokenPrice, token.Decimals)
+	if feeInToken.Cmp(gasInToken) >= 0 {
+		return "", nil
+	}
+	return fmt.Sprintf("bridge fee %s %s doesn't cover fill gas %s %s on %s", feeInToken.String(), token.Symbol,
+		gasInToken.String(), token.Symbol, destChain), nil
+}
+
+func (engine *SwapEngine) getUneconomicSwap(tx SwapStore, startTxHash string) (*model.Swap, error) {
+	swap, err := engine.getSwapByStartTxHash(tx, startTxHash)
+	if err != nil {
+		return nil, err
+	}
+	if swap.Status != SwapUneconomic {
+		return nil, fmt.Errorf("swap %s is not uneconomic, status %s", startTxHash, swap.Status)
+	}
+	return swap, nil
+}
+
+// FillUneconomicSwap makes the uneconomic swap eligible for fill, the fill gas is not checked again
+func (engine *SwapEngine) FillUneconomicSwap(startTxHash string) error {
+	return engine.store.Transaction(func(tx SwapStore) error {
+		swap, err := engine.getUneconomicSwap(tx, startTxHash)
+		if err != nil {
+			return err
+		}
+		swap.Status = SwapConfirmed
+		swap.FillOverride = true
+		swap.Log = "uneconomic swap is filled by admin"
+		return engine.updateSwap(tx, swap)
+	})
+}
+
+// RejectUneconomicSwap rejects the uneconomic swap so that it will never be filled
+func (engine *SwapEngine) RejectUneconomicSwap(startTxHash, reason string) error {
+	return engine.store.Transaction(func(tx SwapStore) error {
+		swap, err := engine.getUneconomicSwap(tx, startTxHash)
+		if err != nil {
+			return err
+		}
+		swap.Status = SwapQuoteRejected
+		swap.Log = fmt.Sprintf("uneconomic swap is rejected by admin: %s", reason)
+		return engine.updateSwap(tx, swap)
+	})
+}
+
+// holdUneconomicSwap returns true if the swap is held since its bridge fee doesn't cover the fill gas, or if the claim
+// of the swap is lost meanwhile, the swap is filled if the check fails
+func (engine *SwapEngine) holdUneconomicSwap(destChain string, swap *model.Swap) bool {
+	reason, err := engine.checkFillProfitability(destChain, swap)
+	if err != nil {
+		util.Logger.Errorf("check fill profitability of swap %s error: %s", swap.StartTxHash, err.Error())
+		return false
+	}
+	if reason == "" {
+		return false
+	}
+	util.Logger.Infof("swap is uneconomic, hold it, start tx hash %s: %s", swap.StartTxHash, reason)
+	util.SendTelegramMessage(fmt.Sprintf("swap is uneconomic, hold it, start tx hash %s: %s", swap.StartTxHash, reason))
+
+	err = engine.store.Transaction(func(tx SwapStore) error {
+		if renewed, err := engine.renewSwapClaim(tx, swap); err != nil || !renewed {
+			return err
+		}
+		swap.Status = SwapUneconomic
+		swap.Log = reason
+		return engine.updateSwap(tx, swap)
+	})
+	if err != nil {
+		util.Logger.Errorf("write db error: %s", err.Error())
+		util.SendTelegramMessage(fmt.Sprintf("write db error: %s", err.Error()))
+	}
+	return true
+}
@@ -0,0 +1,166 @@
+package swap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+// swapProofDaemon stores the receipt proofs of the SwapStarted events of the filled swaps. The swaps whose proof
+// can't be built yet, e.g. the node is not reachable, are tried again in the next round.
+func (engine *SwapEngine) swapProofDaemon() func() error {
+	var lastID uint
+	return func() error {
+		swaps, err := engine.store.FindUnprovenSwaps(lastID, BatchSize)
+		if err != nil {
+			return err
+		}
+		if len(swaps) == 0 {
+			lastID = 0
+			return nil
+		}
+
+		for _, swap := range swaps {
+			lastID = swap.ID
+			chain := getSourceChain(swap.Direction)
+			proof, err := engine.buildSwapProof(chain, swap.StartTxHash)
+			if err != nil {
+				util.Logger.Debugf("build proof of swap start tx %s error: %s", swap.StartTxHash, err.Error())
+				continue
+			}
+			if !proof.Verified {
+				util.Logger.Errorf("receipt proof of swap start tx %s is not verified: %s", swap.StartTxHash, proof.Error)
+				util.SendTelegramMessage(fmt.Sprintf("receipt proof of swap start tx %s is not verified: %s", swap.StartTxHash, proof.Error))
+			}
+			if err := engine.store.CreateSwapProof(proof); err != nil {
+				util.Logger.Errorf("save proof of swap start tx %s error: %s", swap.StartTxHash, err.Error())
+			}
+		}
+		return nil
+	}
+}
+
+// buildSwapProof proves the receipt of the swap start tx against the receipts root of its block. The receipts of
+// all the txs of the block are fetched to rebuild the receipt trie.
+func (engine *SwapEngine) buildSwapProof(chain, startTxHash string) (*model.SwapProof, error) {
+	client := engine.getClient(chain)
+	swapAgent := engine.getSwapAgent(chain)
+	receipt, err := client.TransactionReceipt(context.Background(), ethcom.HexToHash(startTxHash))
+	if err != nil {
+		return nil, err
+	}
+	block, err := client.BlockByHash(context.Background(), receipt.BlockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	txHashes := make([]ethcom.Hash, 0, len(block.Transactions()))
+	for _, tx := range block.Transactions() {
+		txHashes = append(txHashes, tx.Hash())
+	}
+	receipts, errs := getTransactionReceipts(client, txHashes)
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	logIndex := -1
+	for i, log := range receipt.Logs {
+		if log.Address == swapAgent {
+			if _, err := engine.eventDecoders[chain].DecodeSwapStarted(log); err == nil {
+				logIndex = i
+				break
+			}
+			if _, err := engine.eventDecoders[chain].DecodeSwapNFTStarted(log); err == nil {
+				logIndex = i
+				break
+			}
+		}
+	}
+	if logIndex < 0 {
+		return nil, fmt.Errorf("no SwapStarted event emitted by swap agent %s in tx %s", swapAgent.String(), startTxHash)
+	}
+
+	header, err := rlp.EncodeToBytes(block.Header())
+	if err != nil {
+		return nil, err
+	}
+	encodedReceipt, err := rlp.EncodeToBytes(receipt)
+	if err != nil {
+		return nil, err
+	}
+	proof := &model.SwapProof{
+		StartTxHash:  startTxHash,
+		Chain:        chain,
+		BlockHash:    block.Hash().String(),
+		Height:       block.Number().Int64(),
+		ReceiptsRoot: block.ReceiptHash().String(),
+		Header:       hexutil.Encode(header),
+		Receipt:      hexutil.Encode(encodedReceipt),
+		TxIndex:      receipt.TransactionIndex,
+		LogIndex:     uint(logIndex),
+	}
+
+	nodes, err := proveReceipt(types.Receipts(receipts), receipt.TransactionIndex, block.ReceiptHash())
+	if err != nil {
+		proof.Error = err.Error()
+		return proof, nil
+	}
+	encodedNodes, err := json.Marshal(nodes)
+	if err != nil {
+		return nil, err
+	}
+	proof.Proof = string(encodedNodes)
+	proof.Verified = true
+	return proof, nil
+}
+
+// proveReceipt rebuilds the receipt trie of the block the same way as types.DeriveSha, and returns the hex
+// encoded nodes on the path of the receipt at txIndex once the proof is verified against the receipts root
+func proveReceipt(receipts types.Receipts, txIndex uint, receiptsRoot ethcom.Hash) ([]string, error) {
+	receiptTrie, err := trie.New(ethcom.Hash{}, trie.NewDatabase(memorydb.New()))
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < receipts.Len(); i++ {
+		key, err := rlp.EncodeToBytes(uint(i))
+		if err != nil {
+			return nil, err
+		}
+		receiptTrie.Update(key, receipts.GetRlp(i))
+	}
+	if root := receiptTrie.Hash(); root != receiptsRoot {
+		return nil, fmt.Errorf("receipts root mismatch, derived %s, header %s", root.String(), receiptsRoot.String())
+	}
+
+	key, err := rlp.EncodeToBytes(txIndex)
+	if err != nil {
+		return nil, err
+	}
+	proofDb := memorydb.New()
+	if err := receiptTrie.Prove(key, 0, proofDb); err != nil {
+		return nil, err
+	}
+	if _, _, err := trie.VerifyProof(receiptsRoot, key, proofDb); err != nil {
+		return nil, err
+	}
+
+	nodes := make([]string, 0)
+	it := proofDb.NewIterator()
+	defer it.Release()
+	for it.Next() {
+		nodes = append(nodes, hexutil.Encode(it.Value()))
+	}
+	return nodes, nil
+}
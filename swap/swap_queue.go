@@ -0,0 +1,70 @@
+package swap
+
+import (
+	"fmt"
+	"time"
+
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+// queuePopTimeout is how long the consumer waits for a swap start event before waiting again
+const queuePopTimeout = 5 * time.Second
+
+// consumeSwapEventsDaemon wakes the swap daemons on the swap start events pushed by the observers. The events are
+// only notifications, the daemons still read the swaps from db, so a lost event only delays its swap until the
+// next poll.
+func (engine *SwapEngine) consumeSwapEventsDaemon() error {
+	message, ok, err := engine.queue.Pop(queuePopTimeout)
+	if err != nil {
+		return fmt.Errorf("pop swap start event error: %s", err.Error())
+	}
+	if ok {
+		util.Logger.Debugf("receive swap start event %s", message)
+		notify(engine.wakeMonitor)
+		notify(engine.wakeConfirm)
+	}
+	return util.RunAgain
+}
+
+// waitForWork sleeps for the interval of the daemon, or until it is woken if the queue is enabled. The db is polled
+// less often with the queue, at the poll interval of the queue if it is longer than the interval of the daemon.
+func (engine *SwapEngine) waitForWork(wake chan struct{}, interval time.Duration) {
+	if engine.queue == nil {
+		time.Sleep(interval)
+		return
+	}
+	if pollInterval := engine.config.QueueConfig.GetPollInterval(); pollInterval > interval {
+		interval = pollInterval
+	}
+	select {
+	case <-wake:
+	case <-time.After(interval):
+	}
+}
+
+// waitForWorkOf returns the wait of the scheduler of a daemon woken by the channel
+func (engine *SwapEngine) waitForWorkOf(wake chan struct{}) func(interval time.Duration) {
+	return func(interval time.Duration) {
+		engine.waitForWork(wake, interval)
+	}
+}
+
+// wakeFillDaemon wakes the daemon filling the swap of the swap start tx
+func (engine *SwapEngine) wakeFillDaemon(txEventLog *model.SwapStartTxLog) {
+	direction, err := engine.getSwapDirection(txEventLog.Chain, txEventLog.ToChainId)
+	if err != nil {
+		return
+	}
+	if wake, ok := engine.wakeFill[getDestChain(direction)]; ok {
+		notify(wake)
+	}
+}
+
+// notify wakes the daemon waiting on the channel, it never blocks as a pending wakeup already covers the new work
+func notify(wake chan struct{}) {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
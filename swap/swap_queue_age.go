@@ -0,0 +1,101 @@
+package swap
+
+import (
+	"fmt"
+	"time"
+
+	"occ-swap-server/common"
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+var queueStages = []string{util.QueueStageSeenRequest, util.QueueStageConfirmRequest, util.QueueStageConfirmed,
+	util.QueueStageFillTxSent}
+
+// queueAgeDaemon exposes the age of the oldest swap of every stage and direction, and alerts the stages whose
+// oldest swap waited longer than the max age of the stage once until it moves on
+func (engine *SwapEngine) queueAgeDaemon() func() error {
+	alerted := make(map[string]bool)
+	return func() error {
+		now := time.Now()
+		for _, stage := range queueStages {
+			oldest, err := engine.queryOldestOfStage(stage)
+			if err != nil {
+				util.Logger.Errorf("query oldest swaps of stage %s error: %s", stage, err.Error())
+				continue
+			}
+			maxAge := engine.config.QueueAgeConfig.GetMaxAge(stage)
+			for _, route := range swapRoutes {
+				age := time.Duration(0)
+				if since, ok := oldest[route.Direction]; ok {
+					age = nonNegative(now.Sub(since))
+				}
+				queueAgeGauge.WithLabelValues(stage, string(route.Direction)).Set(age.Seconds())
+
+				key := fmt.Sprintf("%s/%s", stage, route.Direction)
+				if age <= maxAge {
+					delete(alerted, key)
+					continue
+				}
+				if alerted[key] {
+					continue
+				}
+				alerted[key] = true
+				util.Logger.Errorf("the oldest %s swap of %s waited %s, longer than %s", stage, route.Direction, age.Truncate(time.Second), maxAge)
+				util.SendTelegramMessage(fmt.Sprintf("Urgent alert: the oldest %s swap of %s waited %s, longer than %s, the direction may be stuck",
+					stage, route.Direction, age.Truncate(time.Second), maxAge))
+			}
+		}
+		return nil
+	}
+}
+
+// queryOldestOfStage returns since when the oldest swap of every direction is in the stage, a direction without a
+// swap in the stage is left out
+func (engine *SwapEngine) queryOldestOfStage(stage string) (map[common.SwapDirection]time.Time, error) {
+	oldest := make(map[common.SwapDirection]time.Time)
+	switch stage {
+	case util.QueueStageSeenRequest, util.QueueStageConfirmRequest:
+		phase := model.SeenRequest
+		if stage == util.QueueStageConfirmRequest {
+			phase = model.ConfirmRequest
+		}
+		// the swap start txs have no direction yet, it is the route of their chain and to chain id
+		for key, entry := range engine.routes {
+			txEventLog, err := engine.store.OldestStartTxLog(phase, key.sourceChain, key.toChainId)
+			if err != nil {
+				return nil, err
+			}
+			if txEventLog == nil {
+				continue
+			}
+			since := time.Unix(txEventLog.UpdateTime, 0)
+			if current, ok := oldest[entry.Direction]; !ok || since.Before(current) {
+				oldest[entry.Direction] = since
+			}
+		}
+	case util.QueueStageConfirmed:
+		for _, route := range swapRoutes {
+			swap, err := engine.store.OldestSwap(SwapConfirmed, route.Direction)
+			if err != nil {
+				return nil, err
+			}
+			if swap == nil {
+				continue
+			}
+			oldest[route.Direction] = swap.UpdatedAt
+		}
+	case util.QueueStageFillTxSent:
+		for _, route := range swapRoutes {
+			swapTx, err := engine.store.OldestFillTx(model.FillTxSent, route.Direction)
+			if err != nil {
+				return nil, err
+			}
+			if swapTx == nil {
+				continue
+			}
+			oldest[route.Direction] = swapTx.CreatedAt
+		}
+	}
+	return oldest, nil
+}
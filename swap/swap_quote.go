@@ -0,0 +1,163 @@
+package swap
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	ethcom "github.com/ethereum/go-ethereum/common"
+
+	"occ-swap-server/model"
+)
+
+// SwapQuote is the estimation of a swap shown to the users before they start it, the fee and the gas amounts are
+// in wei of the native tokens of the chains
+type SwapQuote struct {
+	Direction string `json:"direction"`
+	Symbol    string `json:"symbol"`
+	Amount    string `json:"amount"`
+	// the amount in the unit of the token of the decimals
+	AmountDecimal string `json:"amount_decimal"`
+	Decimals      int    `json:"decimals"`
+	// swap fee paid to the swap agent of the source chain along with the swap tx
+	BridgeFee string `json:"bridge_fee"`
+	// tier of the sponsor and the part of the bridge fee rebated to it, empty without a sponsor or a tier
+	Tier         string `json:"tier,omitempty"`
+	FeeRebate    string `json:"fee_rebate,omitempty"`
+	DestGasLimit uint64 `json:"dest_gas_limit"`
+	DestGasPrice string `json:"dest_gas_price"`
+	DestGasFee   string `json:"dest_gas_fee"`
+	// usd value of the amount, 0 if the token is not valued
+	AmountUsd    float64 `json:"amount_usd"`
+	MinAmount    string  `json:"min_amount"`
+	MaxAmount    string  `json:"max_amount"`
+	WithinBounds bool    `json:"within_bounds"`
+	Liquidity    bool    `json:"liquidity"`
+	Paused       bool    `json:"paused"`
+	// false if the pair is disabled, its new swaps are rejected
+	Available bool `json:"available"`
+	// estimated seconds from the swap tx being observed to the swap being filled, 0 if no swap is filled recently
+	EtaSeconds     int64                   `json:"eta_seconds"`
+	LatencySamples int                     `json:"latency_samples"`
+	Latency        []model.SwapLatencyStat `json:"latency"`
+}
+
+// QuoteSwap estimates the fees and the eta of swapping the amount of the pair from the chain to the chain id, the
+// bridge fee rebate follows the tier of the sponsor if it is given
+func (engine *SwapEngine) QuoteSwap(fromChain string, erc20Addr ethcom.Address, amount *big.Int, toChainId, sponsor string) (*SwapQuote, error) {
+	direction, err := engine.getSwapDirection(fromChain, toChainId)
+	if err != nil {
+		return nil, err
+	}
+	pair, err := engine.GetSwapPairInstance(erc20Addr)
+	if err != nil {
+		return nil, err
+	}
+	destChain := getDestChain(direction)
+
+	quote := &SwapQuote{
+		Direction:     string(direction),
+		Symbol:        pair.Symbol,
+		Amount:        amount.String(),
+		AmountDecimal: model.FormatAmount(amount, pair.Decimals),
+		Decimals:      pair.Decimals,
+		MinAmount:     pair.LowBound.String(),
+		MaxAmount:     pair.UpperBound.String(),
+		WithinBounds:  amount.Cmp(pair.LowBound) >= 0 && amount.Cmp(pair.UpperBound) <= 0,
+		Liquidity:     engine.hasLiquidity(destChain, amount),
+		Paused:        engine.IsDirectionPaused(direction) || engine.IsPairPaused(erc20Addr),
+		Available:     pair.Available,
+	}
+
+	if engine.config.PriceConfig.Enabled() {
+		if value, err := engine.prices.TokenValue(erc20Addr.String(), amount); err == nil {
+			quote.AmountUsd = value
+		}
+	}
+
+	bridgeFee, err := engine.querySwapFee(fromChain)
+	if err != nil {
+		return nil, fmt.Errorf("query swap fee of %s error: %s", fromChain, err.Error())
+	}
+	quote.BridgeFee = bridgeFee.String()
+	if sponsor != "" {
+		tier, err := engine.getSponsorTier(sponsor)
+		if err != nil {
+			return nil, fmt.Errorf("query tier of sponsor %s error: %s", sponsor, err.Error())
+		}
+		if tier != nil {
+			quote.Tier = tier.Tier
+			quote.FeeRebate = getFeeRebate(bridgeFee, tier).String()
+		}
+	}
+
+	toChainID, _ := big.NewInt(0).SetString(toChainId, 10)
+	data, err := engine.encodeFillSwap(fillCall{
+		direction: direction,
+		bep20Addr: pair.BEP20Addr.String(),
+		erc20Addr: erc20Addr.String(),
+		toChainId: toChainID,
+		amount:    amount,
+	})
+	if err != nil {
+		return nil, err
+	}
+	gasPrice, gasLimit, err := engine.estimateFillGas(destChain, data)
+	if err != nil {
+		return nil, err
+	}
+	quote.DestGasLimit = gasLimit
+	quote.DestGasPrice = gasPrice.String()
+	quote.DestGasFee = big.NewInt(0).Mul(gasPrice, big.NewInt(int64(gasLimit))).String()
+
+	quote.Latency, err = engine.GetLatencyStats(direction)
+	if err != nil {
+		return nil, err
+	}
+	eta, samples := getEta(quote.Latency)
+	if samples > 0 {
+		delay := engine.getSwapDelay(&model.Swap{Direction: direction, ERC20Addr: erc20Addr.String(), Amount: amount.String()})
+		quote.EtaSeconds = eta + int64(delay/time.Second)
+	}
+	quote.LatencySamples = samples
+	return quote, nil
+}
+
+// estimateFillGas returns the gas price and the gas limit of the fill tx of the data sent by a relayer of the
+// destination chain
+func (engine *SwapEngine) estimateFillGas(destChain string, data []byte) (*big.Int, uint64, error) {
+	client := engine.getClient(destChain)
+	gasPrice, err := client.SuggestGasPrice(context.Background())
+	if err != nil {
+		return nil, 0, fmt.Errorf("query gas price of %s error: %s", destChain, err.Error())
+	}
+	var relayerAddr ethcom.Address
+	if accounts := engine.relayerPools[destChain].GetAccounts(); len(accounts) != 0 {
+		relayerAddr = ethcom.HexToAddress(accounts[0].Address)
+	}
+	swapAgent := engine.getSwapAgent(destChain)
+	gasLimit, err := client.EstimateGas(context.Background(), ethereum.CallMsg{From: relayerAddr, To: &swapAgent, GasPrice: gasPrice, Data: data})
+	if err != nil {
+		return nil, 0, fmt.Errorf("estimate gas of fill tx on %s error: %s", destChain, err.Error())
+	}
+	return gasPrice, gasLimit, nil
+}
+
+func (engine *SwapEngine) querySwapFee(chain string) (*big.Int, error) {
+	swapAgent := engine.getSwapAgent(chain)
+	data, err := engine.swapAgentABI.Pack("swapFee")
+	if err != nil {
+		return nil, err
+	}
+	output, err := engine.getClient(chain).CallContract(context.Background(), ethereum.CallMsg{To: &swapAgent, Data: data}, nil)
+	if err != nil {
+		return nil, err
+	}
+	fee := big.NewInt(0)
+	if err := engine.swapAgentABI.Unpack(&fee, "swapFee", output); err != nil {
+		return nil, err
+	}
+	return fee, nil
+}
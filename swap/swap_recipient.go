@@ -0,0 +1,37 @@
+package swap
+
+import (
+	"errors"
+	"fmt"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+)
+
+// errRecipientBlocked is returned for the swaps whose recipient is in the blocked addresses of the screening config,
+// their fills are failed permanently
+var errRecipientBlocked = errors.New("recipient is blocked")
+
+// checkRecipient checks the account paid by the fill on the destination chain, the fill to the zero address or the
+// swap agent itself would lock the tokens, and the blocked recipients are not paid at all
+func (engine *SwapEngine) checkRecipient(destChain, recipient string) error {
+	if !ethcom.IsHexAddress(recipient) {
+		return fmt.Errorf("invalid recipient %s", recipient)
+	}
+	addr := ethcom.HexToAddress(recipient)
+	if addr == (ethcom.Address{}) {
+		return fmt.Errorf("recipient should not be the zero address")
+	}
+	if addr == engine.getSwapAgent(destChain) {
+		return fmt.Errorf("recipient should not be the swap agent of %s", destChain)
+	}
+	return engine.screenRecipient(recipient)
+}
+
+// screenRecipient returns errRecipientBlocked if the recipient is blocked, the blocked addresses may be added after
+// the swap is created so they are screened again before every fill
+func (engine *SwapEngine) screenRecipient(recipient string) error {
+	if engine.config.ScreeningConfig.IsBlocked(ethcom.HexToAddress(recipient)) {
+		return fmt.Errorf("%w: %s", errRecipientBlocked, recipient)
+	}
+	return nil
+}
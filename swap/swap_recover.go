@@ -0,0 +1,417 @@
+package swap
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"occ-swap-server/common"
+	"occ-swap-server/events"
+	"occ-swap-server/executor"
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+// RecoveryLogsBatch is the max number of blocks of a log filter of the recovery
+const RecoveryLogsBatch = 2000
+
+// RecoveryRange is the block range of a chain whose swap events are recovered
+type RecoveryRange struct {
+	Chain      string `json:"chain"`
+	FromHeight int64  `json:"from_height"`
+	ToHeight   int64  `json:"to_height"`
+}
+
+// RecoveryIssue is an event of the recovery ranges which isn't recovered, or is recovered with a guess
+type RecoveryIssue struct {
+	Chain  string `json:"chain"`
+	TxHash string `json:"tx_hash"`
+	Issue  string `json:"issue"`
+}
+
+// RecoveryReport is the result of the recovery of the swaps from the chain events
+type RecoveryReport struct {
+	StartedAt  int64           `json:"started_at"`
+	FinishedAt int64           `json:"finished_at"`
+	Ranges     []RecoveryRange `json:"ranges"`
+	// the SwapStarted and SwapFilled events found in the ranges
+	StartEvents int `json:"start_events"`
+	FillEvents  int `json:"fill_events"`
+	// the events already in the db, they are left as they are
+	ExistingStarts int `json:"existing_starts"`
+	ExistingFills  int `json:"existing_fills"`
+	RecoveredSwaps int `json:"recovered_swaps"`
+	RecoveredFills int `json:"recovered_fills"`
+	// the recovered swaps without a fill in the ranges, they are abandoned for the operators
+	UnfilledSwaps int             `json:"unfilled_swaps"`
+	Issues        []RecoveryIssue `json:"issues"`
+	// set if the recovery is stopped by an error, nothing is written to the db if it fails before the writes
+	Error string `json:"error,omitempty"`
+}
+
+func (report *RecoveryReport) addIssue(chain, txHash, format string, args ...interface{}) {
+	issue := RecoveryIssue{Chain: chain, TxHash: txHash, Issue: fmt.Sprintf(format, args...)}
+	util.Logger.Warningf("recover swaps, chain %s, tx hash %s, %s", chain, txHash, issue.Issue)
+	report.Issues = append(report.Issues, issue)
+}
+
+type recoveredStart struct {
+	txLog *model.SwapStartTxLog
+	swap  *model.Swap
+	fill  *recoveredFill
+}
+
+type recoveredFill struct {
+	chain   string
+	event   *events.SwapFilled
+	tx      *types.Transaction
+	receipt *types.Receipt
+}
+
+// ParseRecoveryRanges parses the ranges of the --recover flag, e.g. BSC:1000-2000,ETH:500-900
+func ParseRecoveryRanges(s string) ([]RecoveryRange, error) {
+	ranges := make([]RecoveryRange, 0)
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		chainAndRange := strings.SplitN(part, ":", 2)
+		bounds := strings.SplitN(chainAndRange[len(chainAndRange)-1], "-", 2)
+		if len(chainAndRange) != 2 || len(bounds) != 2 {
+			return nil, fmt.Errorf("recovery range should be chain:from-to, e.g. BSC:1000-2000: %s", part)
+		}
+		chain := strings.ToUpper(chainAndRange[0])
+		if chain != common.ChainBSC && chain != common.ChainETH && chain != common.ChainMATIC {
+			return nil, fmt.Errorf("unknown chain of recovery range: %s", part)
+		}
+		if seen[chain] {
+			return nil, fmt.Errorf("duplicate recovery range of chain %s", chain)
+		}
+		seen[chain] = true
+		fromHeight, err := strconv.ParseInt(bounds[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from height of recovery range: %s", part)
+		}
+		toHeight, err := strconv.ParseInt(bounds[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid to height of recovery range: %s", part)
+		}
+		if fromHeight < 0 || fromHeight > toHeight {
+			return nil, fmt.Errorf("from height of recovery range should be between 0 and the to height: %s", part)
+		}
+		ranges = append(ranges, RecoveryRange{Chain: chain, FromHeight: fromHeight, ToHeight: toHeight})
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no recovery range")
+	}
+	return ranges, nil
+}
+
+// Recover rebuilds the swaps and their fill txs from the SwapStarted and SwapFilled events of the swap agents in the
+// ranges, for the disaster recovery of a lost or corrupted db. It must run before the engine and the observers are
+// started. The ranges of the destination chains should cover the fills of the swaps started in the source ranges.
+//
+// The SwapFilled event doesn't carry the swap start tx hash, so a fill is only recovered if its tx is sent by a
+// relayer account of the chain, and it is matched to the earliest unfilled start of the same recipient, amount and
+// destination. The swaps without a fill are abandoned instead of filled again, the operators retry them once they
+// have checked the fill isn't out of the ranges. The to heights are capped to the confirmed heights, and the block
+// logs are saved at them so the observers resume after the ranges.
+func (engine *SwapEngine) Recover(ranges []RecoveryRange) *RecoveryReport {
+	report := &RecoveryReport{StartedAt: time.Now().Unix(), Issues: make([]RecoveryIssue, 0)}
+	err := engine.recover(ranges, report)
+	if err != nil {
+		report.Error = err.Error()
+		util.Logger.Errorf("recover swaps error: %s", err.Error())
+	}
+	report.Ranges = ranges
+	report.FinishedAt = time.Now().Unix()
+	return report
+}
+
+func (engine *SwapEngine) recover(ranges []RecoveryRange, report *RecoveryReport) error {
+	heads := make(map[string]*types.Header)
+	for i := range ranges {
+		r := &ranges[i]
+		head, err := engine.getClient(r.Chain).HeaderByNumber(context.Background(), nil)
+		if err != nil {
+			return fmt.Errorf("get %s head error: %s", r.Chain, err.Error())
+		}
+		heads[r.Chain] = head
+		if confirmedHeight := head.Number.Int64() + 1 - engine.config.ChainConfig.GetConfirmNum(r.Chain); r.ToHeight > confirmedHeight {
+			r.ToHeight = confirmedHeight
+		}
+		if r.ToHeight < r.FromHeight {
+			return fmt.Errorf("no confirmed block in recovery range of chain %s from %d", r.Chain, r.FromHeight)
+		}
+	}
+
+	starts := make([]*recoveredStart, 0)
+	fills := make([]*recoveredFill, 0)
+	for _, r := range ranges {
+		logs, err := engine.filterSwapAgentLogs(r)
+		if err != nil {
+			return err
+		}
+		for i := range logs {
+			log := &logs[i]
+			if event, err := engine.eventDecoders[r.Chain].DecodeSwapStarted(log); err == nil {
+				report.StartEvents++
+				start, err := engine.recoverSwapStart(r.Chain, heads[r.Chain], event, log)
+				if err != nil {
+					return err
+				}
+				if start == nil {
+					report.ExistingStarts++
+					continue
+				}
+				starts = append(starts, start)
+			} else if event, err := engine.eventDecoders[r.Chain].DecodeSwapFilled(log); err == nil {
+				report.FillEvents++
+				fill, err := engine.recoverSwapFill(r.Chain, event, log, report)
+				if err != nil {
+					return err
+				}
+				if fill != nil {
+					fills = append(fills, fill)
+				}
+			}
+		}
+	}
+
+	for _, fill := range fills {
+		engine.matchRecoveredFill(fill, starts, report)
+	}
+
+	for _, start := range starts {
+		if err := engine.saveRecoveredSwap(start); err != nil {
+			return fmt.Errorf("save recovered swap error, start tx hash %s, err: %s", start.txLog.TxHash, err.Error())
+		}
+		report.RecoveredSwaps++
+		if start.fill != nil {
+			report.RecoveredFills++
+		} else if start.swap.Status == SwapAbandoned {
+			report.UnfilledSwaps++
+		}
+	}
+	for _, r := range ranges {
+		if err := engine.saveRecoveredBlockLog(r); err != nil {
+			return fmt.Errorf("save block log of chain %s error: %s", r.Chain, err.Error())
+		}
+	}
+	return nil
+}
+
+// filterSwapAgentLogs returns the SwapStarted and SwapFilled logs of the swap agent in the range, in the chain order
+func (engine *SwapEngine) filterSwapAgentLogs(r RecoveryRange) ([]types.Log, error) {
+	decoder := engine.eventDecoders[r.Chain]
+	topics := append(decoder.SwapStartedTopics(), decoder.SwapFilledTopics()...)
+	logs := make([]types.Log, 0)
+	for from := r.FromHeight; from <= r.ToHeight; from += RecoveryLogsBatch {
+		to := from + RecoveryLogsBatch - 1
+		if to > r.ToHeight {
+			to = r.ToHeight
+		}
+		batch, err := engine.getClient(r.Chain).FilterLogs(context.Background(), ethereum.FilterQuery{
+			FromBlock: big.NewInt(from),
+			ToBlock:   big.NewInt(to),
+			Addresses: []ethcom.Address{engine.getSwapAgent(r.Chain)},
+			Topics:    [][]ethcom.Hash{topics},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("filter %s logs from %d to %d error: %s", r.Chain, from, to, err.Error())
+		}
+		for _, log := range batch {
+			if !log.Removed {
+				logs = append(logs, log)
+			}
+		}
+		util.Logger.Infof("recover swaps, %d logs of chain %s from %d to %d", len(batch), r.Chain, from, to)
+	}
+	return logs, nil
+}
+
+// recoverSwapStart returns the start tx log and the swap of the SwapStarted event, nil if the start is in the db
+func (engine *SwapEngine) recoverSwapStart(chain string, head *types.Header, event *events.SwapStarted, log *types.Log) (*recoveredStart, error) {
+	txHash := log.TxHash.String()
+	if recorded, err := engine.store.IsStartTxRecorded(txHash); err != nil || recorded {
+		return nil, err
+	}
+	if recorded, err := engine.store.IsSwapRecorded(txHash); err != nil || recorded {
+		return nil, err
+	}
+
+	txLog := executor.ToSwapStartTxLog(event, log)
+	txLog.Chain = chain
+	if txLog.TokenAddr == "" {
+		token, err := executor.ResolveSwapToken(context.Background(), engine.getClient(chain), engine.getSwapAgent(chain), event, log)
+		if err != nil {
+			return nil, err
+		}
+		txLog.TokenAddr = model.OptionalAddress(token)
+	}
+	memo, err := executor.ResolveSwapMemo(context.Background(), engine.getClient(chain), engine.getSwapAgent(chain), log)
+	if err != nil {
+		return nil, err
+	}
+	txLog.Memo = memo
+	txLog.Status = model.TxStatusConfirmed
+	txLog.ConfirmedNum = head.Number.Int64() + 1 - txLog.Height
+	txLog.Phase = model.AckRequest
+	swap, err := engine.createSwap(txLog)
+	if err != nil {
+		return nil, err
+	}
+	return &recoveredStart{txLog: txLog, swap: swap}, nil
+}
+
+// recoverSwapFill returns the fill of the SwapFilled event, nil if the fill is in the db or isn't sent by a relayer
+// account of the chain
+func (engine *SwapEngine) recoverSwapFill(chain string, event *events.SwapFilled, log *types.Log, report *RecoveryReport) (*recoveredFill, error) {
+	txHash := log.TxHash.String()
+	recorded, err := engine.store.IsFillTxRecorded(txHash)
+	if err != nil {
+		return nil, err
+	}
+	if recorded {
+		report.ExistingFills++
+		return nil, nil
+	}
+
+	client := engine.getClient(chain)
+	tx, _, err := client.TransactionByHash(context.Background(), log.TxHash)
+	if err != nil {
+		return nil, fmt.Errorf("get %s fill tx %s error: %s", chain, txHash, err.Error())
+	}
+	receipt, err := client.TransactionReceipt(context.Background(), log.TxHash)
+	if err != nil {
+		return nil, fmt.Errorf("get %s fill tx receipt %s error: %s", chain, txHash, err.Error())
+	}
+	sender, err := types.Sender(types.NewEIP155Signer(big.NewInt(engine.getChainID(chain))), tx)
+	if err != nil {
+		report.addIssue(chain, txHash, "sender of fill tx is unknown: %s", err.Error())
+		return nil, nil
+	}
+	if !engine.isRelayerAccount(chain, sender) {
+		report.addIssue(chain, txHash, "fill tx is sent by %s, which is not a relayer account", sender.String())
+		return nil, nil
+	}
+	return &recoveredFill{chain: chain, event: event, tx: tx, receipt: receipt}, nil
+}
+
+func (engine *SwapEngine) isRelayerAccount(chain string, account ethcom.Address) bool {
+	for _, relayer := range engine.relayerPools[chain].GetAccounts() {
+		if ethcom.HexToAddress(relayer.Address) == account {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRecoveredFill links the fill to the earliest unfilled start whose recipient, amount and destination match the
+// SwapFilled event, and to the source chain of the event if it is set
+func (engine *SwapEngine) matchRecoveredFill(fill *recoveredFill, starts []*recoveredStart, report *RecoveryReport) {
+	txHash := fill.tx.Hash().String()
+	candidates := make([]*recoveredStart, 0)
+	for _, start := range starts {
+		swap := start.swap
+		if start.fill != nil || swap.Status != SwapTokenReceived || getDestChain(swap.Direction) != fill.chain {
+			continue
+		}
+		if swap.ToChainId != fill.event.ToChainId.String() || ethcom.HexToAddress(swap.GetRecipient()) != fill.event.ToAddress ||
+			swap.Amount != fill.event.Amount.String() {
+			continue
+		}
+		// the fill txs of the engine are sent with a 0 fromChainId
+		if fromChainId := fill.event.FromChainId; fromChainId != nil && fromChainId.Sign() != 0 && fromChainId.Int64() != engine.getChainID(start.txLog.Chain) {
+			continue
+		}
+		candidates = append(candidates, start)
+	}
+	if len(candidates) == 0 {
+		report.addIssue(fill.chain, txHash, "no start tx in the ranges matches the fill of %s to %s",
+			fill.event.Amount.String(), fill.event.ToAddress.String())
+		return
+	}
+	if len(candidates) > 1 {
+		report.addIssue(fill.chain, txHash, "%d start txs match the fill, it is linked to the earliest one %s",
+			len(candidates), candidates[0].txLog.TxHash)
+	}
+	candidates[0].fill = fill
+}
+
+func (engine *SwapEngine) saveRecoveredSwap(start *recoveredStart) error {
+	swap := start.swap
+	var swapTx *model.SwapFillTx
+	if start.fill != nil {
+		tx, receipt := start.fill.tx, start.fill.receipt
+		swapTx = &model.SwapFillTx{
+			Direction:         swap.Direction,
+			StartSwapTxHash:   swap.StartTxHash,
+			FillSwapTxHash:    tx.Hash().String(),
+			GasPrice:          tx.GasPrice().String(),
+			GasLimit:          int64(tx.Gas()),
+			ConsumedFeeAmount: big.NewInt(0).Mul(tx.GasPrice(), big.NewInt(int64(receipt.GasUsed))).String(),
+			Height:            receipt.BlockNumber.Int64(),
+			Status:            model.FillTxSuccess,
+		}
+		swap.Status = SwapSuccess
+		swap.FillTxHash = swapTx.FillSwapTxHash
+		swap.Log = "recovered from chain events"
+	} else if swap.Status == SwapTokenReceived {
+		swap.Status = SwapAbandoned
+		swap.Log = "recovered from chain events without a fill tx in the recovery ranges, check the destination chain before retrying"
+	}
+
+	err := engine.store.Transaction(func(tx SwapStore) error {
+		if err := tx.CreateStartTxLog(start.txLog); err != nil {
+			return err
+		}
+		if err := engine.insertSwap(tx, swap); err != nil {
+			return err
+		}
+		if swapTx != nil {
+			swapTx.SwapID = swap.ID
+			return tx.CreateFillTx(swapTx)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	util.Logger.Infof("recover swap, start tx hash %s, direction %s, status %s, fill tx hash %s",
+		swap.StartTxHash, swap.Direction, swap.Status, swap.FillTxHash)
+	return nil
+}
+
+// saveRecoveredBlockLog saves the block log at the end of the range, the observer of the chain resumes after it
+// instead of saving the recovered start txs again
+func (engine *SwapEngine) saveRecoveredBlockLog(r RecoveryRange) error {
+	blockLog, err := engine.store.GetLatestBlockLog(r.Chain)
+	if err != nil {
+		return err
+	}
+	if blockLog != nil && blockLog.Height >= r.ToHeight {
+		return nil
+	}
+	header, err := engine.getClient(r.Chain).HeaderByNumber(context.Background(), big.NewInt(r.ToHeight))
+	if err != nil {
+		return err
+	}
+	return engine.store.CreateBlockLog(&model.BlockLog{
+		Chain:      r.Chain,
+		BlockHash:  header.Hash().String(),
+		ParentHash: header.ParentHash.String(),
+		Height:     r.ToHeight,
+		BlockTime:  int64(header.Time),
+		CreateTime: time.Now().Unix(),
+	})
+}
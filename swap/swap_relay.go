@@ -0,0 +1,486 @@
+package swap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	sabi "occ-swap-server/abi"
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+const (
+	// RelayMinDeadline is how long a permit must stay valid when it is submitted, the txs of the relayed swap are
+	// mined one after another before the swap is started
+	RelayMinDeadline = 10 * time.Minute
+	// RelayTxTimeout is how long a tx of a relayed swap may stay unmined before the relayed swap fails
+	RelayTxTimeout = 30 * time.Minute
+	// RelayRateWindow is the window the relayed swaps of an owner and of an ip are counted in
+	RelayRateWindow = time.Hour
+
+	// permitABI is the EIP-2612 extension of the tokens
+	permitABI = `[{"inputs":[{"name":"owner","type":"address"},{"name":"spender","type":"address"},{"name":"value","type":"uint256"},{"name":"deadline","type":"uint256"},{"name":"v","type":"uint8"},{"name":"r","type":"bytes32"},{"name":"s","type":"bytes32"}],"name":"permit","outputs":[],"stateMutability":"nonpayable","type":"function"},{"inputs":[{"name":"owner","type":"address"}],"name":"nonces","outputs":[{"name":"","type":"uint256"}],"stateMutability":"view","type":"function"},{"inputs":[],"name":"DOMAIN_SEPARATOR","outputs":[{"name":"","type":"bytes32"}],"stateMutability":"view","type":"function"}]`
+)
+
+var permitTypeHash = crypto.Keccak256Hash([]byte("Permit(address owner,address spender,uint256 value,uint256 nonce,uint256 deadline)"))
+
+// ErrTooManyRelayedSwaps is returned if the owner or the ip submitted the most relayed swaps of RelayRateWindow
+var ErrTooManyRelayedSwaps = errors.New("too many relayed swaps, try again later")
+
+// openRelayedSwapStatuses are the statuses of the relayed swaps which are not started or failed yet
+var openRelayedSwapStatuses = []model.RelayedSwapStatus{model.RelayedSwapPending, model.RelayedSwapPermitting,
+	model.RelayedSwapPulling, model.RelayedSwapApproving, model.RelayedSwapStarting}
+
+var (
+	permitTokenABI = mustParseABI(permitABI)
+	erc20TokenABI  = mustParseABI(sabi.ERC20ABI)
+)
+
+func mustParseABI(definition string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(definition))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+// RelayParams are the fields of the permit the owner signs for a relayed swap, the spender is the relayer which
+// starts the swap and pays the swap fee. The permitted amount should be at least MinAmount, the relayer keeps
+// RelayFee of it and starts the swap with the rest.
+type RelayParams struct {
+	Chain           string `json:"chain"`
+	Token           string `json:"token"`
+	Spender         string `json:"spender"`
+	Nonce           string `json:"nonce"`
+	DomainSeparator string `json:"domain_separator"`
+	SwapFee         string `json:"swap_fee"`
+	MinAmount       string `json:"min_amount"`
+	RelayFee        string `json:"relay_fee"`
+	// the deadline of the permit should not be earlier
+	MinDeadline int64 `json:"min_deadline"`
+}
+
+// RelaySwapRequest is a swap of the owner to be started by the relayer, with the permit signed by the owner
+type RelaySwapRequest struct {
+	Chain     string
+	Owner     ethcom.Address
+	ToChainId string
+	Amount    *big.Int
+	Deadline  int64
+	Signature []byte
+	// the ip the request is sent from
+	RemoteIP string
+}
+
+// GetRelayParams returns the fields of the permit the owner signs for a relayed swap started on the chain
+func (engine *SwapEngine) GetRelayParams(chain string, owner ethcom.Address) (*RelayParams, error) {
+	if !engine.config.RelayConfig.IsRelayChain(chain) {
+		return nil, fmt.Errorf("swaps started on %s are not relayed", chain)
+	}
+	fee, ok := engine.config.RelayConfig.GetFee(chain)
+	if !ok {
+		return nil, fmt.Errorf("no relay fee of %s", chain)
+	}
+	broadcasters := engine.relayerPools[chain].getBroadcasters()
+	if len(broadcasters) == 0 {
+		return nil, fmt.Errorf("no relayer on %s", chain)
+	}
+	token, err := engine.getAgentToken(chain)
+	if err != nil {
+		return nil, err
+	}
+	nonce := big.NewInt(0)
+	if err := engine.callToken(chain, token, &permitTokenABI, &nonce, "nonces", owner); err != nil {
+		return nil, fmt.Errorf("query permit nonce of token %s error: %s", token.String(), err.Error())
+	}
+	var domainSeparator [32]byte
+	if err := engine.callToken(chain, token, &permitTokenABI, &domainSeparator, "DOMAIN_SEPARATOR"); err != nil {
+		return nil, fmt.Errorf("query domain separator of token %s error: %s", token.String(), err.Error())
+	}
+	swapFee, err := engine.querySwapFee(chain)
+	if err != nil {
+		return nil, err
+	}
+	return &RelayParams{
+		Chain:           chain,
+		Token:           token.String(),
+		Spender:         broadcasters[0].Account().String(),
+		Nonce:           nonce.String(),
+		DomainSeparator: hexutil.Encode(domainSeparator[:]),
+		SwapFee:         swapFee.String(),
+		MinAmount:       fee.GetMinAmount().String(),
+		RelayFee:        fee.GetFee().String(),
+		MinDeadline:     time.Now().Add(RelayMinDeadline).Unix(),
+	}, nil
+}
+
+// RelaySwap checks the permit of the request and saves the relayed swap, it is started by relaySwapsDaemon
+func (engine *SwapEngine) RelaySwap(req RelaySwapRequest) (*model.RelayedSwap, error) {
+	direction, err := engine.getSwapDirection(req.Chain, req.ToChainId)
+	if err != nil {
+		return nil, err
+	}
+	if engine.IsDirectionPaused(direction) {
+		return nil, fmt.Errorf("direction %s is paused", direction)
+	}
+	if req.Amount == nil || req.Amount.Sign() <= 0 {
+		return nil, fmt.Errorf("amount should be larger than 0")
+	}
+	fee, ok := engine.config.RelayConfig.GetFee(req.Chain)
+	if !ok {
+		return nil, fmt.Errorf("swaps started on %s are not relayed", req.Chain)
+	}
+	if req.Amount.Cmp(fee.GetMinAmount()) < 0 {
+		return nil, fmt.Errorf("amount should not be less than %s", fee.GetMinAmount().String())
+	}
+	if req.Deadline < time.Now().Add(RelayMinDeadline).Unix() {
+		return nil, fmt.Errorf("deadline should not be earlier than %s later", RelayMinDeadline.String())
+	}
+	params, err := engine.GetRelayParams(req.Chain, req.Owner)
+	if err != nil {
+		return nil, err
+	}
+	token, spender := ethcom.HexToAddress(params.Token), ethcom.HexToAddress(params.Spender)
+	nonce, _ := big.NewInt(0).SetString(params.Nonce, 10)
+	signer, err := recoverPermitSigner(ethcom.HexToHash(params.DomainSeparator), req.Owner, spender, req.Amount, nonce, req.Deadline, req.Signature)
+	if err != nil {
+		return nil, err
+	}
+	if signer != req.Owner {
+		return nil, fmt.Errorf("permit is signed by %s instead of the owner %s", signer.String(), req.Owner.String())
+	}
+	balance := big.NewInt(0)
+	if err := engine.callToken(req.Chain, token, &erc20TokenABI, &balance, "balanceOf", req.Owner); err != nil {
+		return nil, err
+	}
+	if balance.Cmp(req.Amount) < 0 {
+		return nil, fmt.Errorf("balance %s of the owner is less than the amount", balance.String())
+	}
+
+	relayedSwap := &model.RelayedSwap{
+		Chain:     req.Chain,
+		Owner:     req.Owner.String(),
+		Spender:   spender.String(),
+		Token:     token.String(),
+		ToChainId: req.ToChainId,
+		Amount:    req.Amount.String(),
+		Fee:       fee.GetFee().String(),
+		Deadline:  req.Deadline,
+		Signature: hexutil.Encode(req.Signature),
+		Status:    model.RelayedSwapPending,
+		RemoteIP:  req.RemoteIP,
+	}
+	err = engine.store.Transaction(func(tx SwapStore) error {
+		// the permits of the owner share the nonce, only one of them can be used
+		active, err := tx.CountPendingRelayedSwaps(req.Chain, relayedSwap.Owner)
+		if err != nil {
+			return err
+		}
+		if active != 0 {
+			return fmt.Errorf("a relayed swap of %s is in progress", relayedSwap.Owner)
+		}
+		// the relayer pays the gas of the failed relayed swaps too, so they are counted as well
+		since := time.Now().Add(-RelayRateWindow)
+		ofOwner, err := tx.CountRelayedSwapsOfOwner(relayedSwap.Owner, since)
+		if err != nil {
+			return err
+		}
+		ofIP, err := tx.CountRelayedSwapsOfIP(relayedSwap.RemoteIP, since)
+		if err != nil {
+			return err
+		}
+		if ofOwner >= engine.config.RelayConfig.GetMaxSwapsPerOwner() || ofIP >= engine.config.RelayConfig.GetMaxSwapsPerIP() {
+			return ErrTooManyRelayedSwaps
+		}
+		return tx.CreateRelayedSwap(relayedSwap)
+	})
+	if err != nil {
+		return nil, err
+	}
+	util.Logger.Infof("relay swap %d of %s on %s, amount %s, to chain id %s", relayedSwap.ID, relayedSwap.Owner,
+		relayedSwap.Chain, relayedSwap.Amount, relayedSwap.ToChainId)
+	return relayedSwap, nil
+}
+
+// recoverPermitSigner returns the signer of the EIP-2612 permit, the signature is r, s and v
+func recoverPermitSigner(domainSeparator ethcom.Hash, owner, spender ethcom.Address, value, nonce *big.Int,
+	deadline int64, signature []byte) (ethcom.Address, error) {
+	if len(signature) != 65 {
+		return ethcom.Address{}, fmt.Errorf("signature should be 65 bytes")
+	}
+	structHash := crypto.Keccak256(
+		permitTypeHash.Bytes(),
+		ethcom.LeftPadBytes(owner.Bytes(), 32),
+		ethcom.LeftPadBytes(spender.Bytes(), 32),
+		ethcom.LeftPadBytes(value.Bytes(), 32),
+		ethcom.LeftPadBytes(nonce.Bytes(), 32),
+		ethcom.LeftPadBytes(big.NewInt(deadline).Bytes(), 32),
+	)
+	digest := crypto.Keccak256([]byte("\x19\x01"), domainSeparator.Bytes(), structHash)
+
+	sig := make([]byte, 65)
+	copy(sig, signature)
+	if sig[64] >= 27 {
+		sig[64] -= 27
+	}
+	publicKey, err := crypto.SigToPub(digest, sig)
+	if err != nil {
+		return ethcom.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*publicKey), nil
+}
+
+func (engine *SwapEngine) callToken(chain string, token ethcom.Address, tokenABI *abi.ABI, out interface{}, method string, args ...interface{}) error {
+	data, err := tokenABI.Pack(method, args...)
+	if err != nil {
+		return err
+	}
+	output, err := engine.getClient(chain).CallContract(context.Background(), ethereum.CallMsg{To: &token, Data: data}, nil)
+	if err != nil {
+		return err
+	}
+	return tokenABI.Unpack(out, method, output)
+}
+
+// relaySwapsDaemon moves the relayed swaps one step at a time, a tx is sent once the tx of the former step is mined.
+// The relayed swaps whose spender is the relayer of another executor are left to it. The allowance of a relayer to
+// the swap agent is shared by its relayed swaps, so a relayer approves and starts one relayed swap at a time, the
+// others wait with their pulled tokens.
+func (engine *SwapEngine) relaySwapsDaemon() error {
+	approving, err := engine.store.FindRelayedSwaps([]model.RelayedSwapStatus{model.RelayedSwapApproving, model.RelayedSwapStarting}, 0)
+	if err != nil {
+		return err
+	}
+	busy := make(map[string]bool)
+	for _, relayedSwap := range approving {
+		busy[relayerKey(relayedSwap.Chain, relayedSwap.Spender)] = true
+	}
+
+	relayedSwaps, err := engine.store.FindRelayedSwaps(openRelayedSwapStatuses, BatchSize)
+	if err != nil {
+		return err
+	}
+
+	for i := range relayedSwaps {
+		relayedSwap := &relayedSwaps[i]
+		pool, ok := engine.relayerPools[relayedSwap.Chain]
+		if !ok {
+			continue
+		}
+		broadcaster := pool.getBroadcaster(ethcom.HexToAddress(relayedSwap.Spender))
+		if broadcaster == nil {
+			continue
+		}
+		key := relayerKey(relayedSwap.Chain, relayedSwap.Spender)
+		if relayedSwap.Status == model.RelayedSwapPulling && busy[key] {
+			continue
+		}
+		if err := engine.relaySwap(broadcaster, relayedSwap); err != nil {
+			engine.failRelayedSwap(relayedSwap, err.Error())
+		} else if relayedSwap.Status == model.RelayedSwapApproving {
+			busy[key] = true
+		}
+	}
+	return nil
+}
+
+func relayerKey(chain, spender string) string {
+	return chain + "/" + ethcom.HexToAddress(spender).String()
+}
+
+// relaySwap sends the tx of the next step of the relayed swap if the tx of the current step is mined
+func (engine *SwapEngine) relaySwap(broadcaster *Broadcaster, relayedSwap *model.RelayedSwap) error {
+	owner, spender := ethcom.HexToAddress(relayedSwap.Owner), ethcom.HexToAddress(relayedSwap.Spender)
+	token := ethcom.HexToAddress(relayedSwap.Token)
+	amount, ok := big.NewInt(0).SetString(relayedSwap.Amount, 10)
+	if !ok {
+		return fmt.Errorf("invalid amount %s", relayedSwap.Amount)
+	}
+
+	switch relayedSwap.Status {
+	case model.RelayedSwapPending:
+		if relayedSwap.Deadline <= time.Now().Unix() {
+			return fmt.Errorf("permit is expired")
+		}
+		signature, err := hexutil.Decode(relayedSwap.Signature)
+		if err != nil || len(signature) != 65 {
+			return fmt.Errorf("invalid signature %s", relayedSwap.Signature)
+		}
+		v := signature[64]
+		if v < 27 {
+			v += 27
+		}
+		var r, s [32]byte
+		copy(r[:], signature[:32])
+		copy(s[:], signature[32:64])
+		data, err := permitTokenABI.Pack("permit", owner, spender, amount, big.NewInt(relayedSwap.Deadline), v, r, s)
+		if err != nil {
+			return err
+		}
+		return engine.sendRelayTx(broadcaster, relayedSwap, token, nil, data, model.RelayedSwapPermitting, &relayedSwap.PermitTxHash)
+	case model.RelayedSwapPermitting:
+		if mined, err := engine.isRelayTxMined(relayedSwap, relayedSwap.PermitTxHash); !mined || err != nil {
+			return err
+		}
+		data, err := erc20TokenABI.Pack("transferFrom", owner, spender, amount)
+		if err != nil {
+			return err
+		}
+		return engine.sendRelayTx(broadcaster, relayedSwap, token, nil, data, model.RelayedSwapPulling, &relayedSwap.PullTxHash)
+	case model.RelayedSwapPulling:
+		if mined, err := engine.isRelayTxMined(relayedSwap, relayedSwap.PullTxHash); !mined || err != nil {
+			return err
+		}
+		swapAmount, err := relayedSwapAmount(relayedSwap)
+		if err != nil {
+			return err
+		}
+		// the allowance is set to the amount of this swap, whatever is left of the former ones, so the swap tx
+		// spends exactly the approve of its own
+		data, err := erc20TokenABI.Pack("approve", engine.getSwapAgent(relayedSwap.Chain), swapAmount)
+		if err != nil {
+			return err
+		}
+		return engine.sendRelayTx(broadcaster, relayedSwap, token, nil, data, model.RelayedSwapApproving, &relayedSwap.ApproveTxHash)
+	case model.RelayedSwapApproving:
+		if mined, err := engine.isRelayTxMined(relayedSwap, relayedSwap.ApproveTxHash); !mined || err != nil {
+			return err
+		}
+		swapAmount, err := relayedSwapAmount(relayedSwap)
+		if err != nil {
+			return err
+		}
+		return engine.startRelayedSwap(broadcaster, relayedSwap, swapAmount)
+	case model.RelayedSwapStarting:
+		if mined, err := engine.isRelayTxMined(relayedSwap, relayedSwap.StartTxHash); !mined || err != nil {
+			return err
+		}
+		util.Logger.Infof("relayed swap %d of %s is started, start tx hash %s", relayedSwap.ID, relayedSwap.Owner, relayedSwap.StartTxHash)
+		relayedSwap.Status = model.RelayedSwapStarted
+		return engine.store.SaveRelayedSwap(relayedSwap)
+	}
+	return nil
+}
+
+// relayedSwapAmount returns the amount the relayed swap is started with, the permitted amount without the relay fee
+func relayedSwapAmount(relayedSwap *model.RelayedSwap) (*big.Int, error) {
+	amount, ok := big.NewInt(0).SetString(relayedSwap.Amount, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid amount %s", relayedSwap.Amount)
+	}
+	fee, ok := big.NewInt(0).SetString(relayedSwap.Fee, 10)
+	if !ok || fee.Sign() < 0 || fee.Cmp(amount) >= 0 {
+		return nil, fmt.Errorf("invalid relay fee %s", relayedSwap.Fee)
+	}
+	return amount.Sub(amount, fee), nil
+}
+
+// startRelayedSwap sends the swap tx of the relayer with the swap fee, the start tx hash is saved before the tx is
+// sent, so the swap is filled to the owner however early the observer sees it
+func (engine *SwapEngine) startRelayedSwap(broadcaster *Broadcaster, relayedSwap *model.RelayedSwap, amount *big.Int) error {
+	direction, err := engine.getSwapDirection(relayedSwap.Chain, relayedSwap.ToChainId)
+	if err != nil {
+		return err
+	}
+	if engine.IsDirectionPaused(direction) {
+		// the tokens stay with the relayer until the direction is resumed
+		return nil
+	}
+	toChainId, ok := big.NewInt(0).SetString(relayedSwap.ToChainId, 10)
+	if !ok {
+		return fmt.Errorf("invalid to chain id %s", relayedSwap.ToChainId)
+	}
+	swapFee, err := engine.querySwapFee(relayedSwap.Chain)
+	if err != nil {
+		// the tokens are pulled already, the swap is started in the next round
+		util.Logger.Errorf("query swap fee of relayed swap %d error: %s", relayedSwap.ID, err.Error())
+		return nil
+	}
+	data, err := engine.swapAgentABI.Pack("swap", big.NewInt(engine.getChainID(relayedSwap.Chain)), toChainId, amount)
+	if err != nil {
+		return err
+	}
+	return engine.sendRelayTx(broadcaster, relayedSwap, engine.getSwapAgent(relayedSwap.Chain), swapFee, data,
+		model.RelayedSwapStarting, &relayedSwap.StartTxHash)
+}
+
+// sendRelayTx sends the tx of the next step, the step and the tx hash, in the field of the step, are saved once the
+// tx is signed, before it is sent. The step is never rolled back once the tx is signed, the node may have accepted it even if the send errors,
+// so the tx is tracked by its hash and the relayed swap fails if it is not mined in RelayTxTimeout.
+func (engine *SwapEngine) sendRelayTx(broadcaster *Broadcaster, relayedSwap *model.RelayedSwap, contract ethcom.Address,
+	value *big.Int, data []byte, status model.RelayedSwapStatus, txHash *string) error {
+	use := KeyUse{Purpose: model.KeyUsageRelay, Daemon: "relay_swaps", Token: ethcom.HexToAddress(relayedSwap.Token)}
+	use.Amount, _ = big.NewInt(0).SetString(relayedSwap.Amount, 10)
+	signed := false
+	_, err := broadcaster.BroadcastValue(BroadcastPriorityNormal, use, contract, value, data, func(signedTx *types.Transaction, gasEstimate uint64) error {
+		previousStatus, previousTxHash := relayedSwap.Status, *txHash
+		relayedSwap.Status, *txHash = status, signedTx.Hash().String()
+		if err := engine.store.SaveRelayedSwap(relayedSwap); err != nil {
+			relayedSwap.Status, *txHash = previousStatus, previousTxHash
+			return err
+		}
+		signed = true
+		return nil
+	})
+	if err != nil && signed {
+		util.Logger.Errorf("send %s tx of relayed swap %d error, the tx is tracked by its hash: %s", status, relayedSwap.ID, err.Error())
+	} else if err != nil {
+		// nothing is saved, the step is sent again in the next round
+		util.Logger.Errorf("send %s tx of relayed swap %d error: %s", status, relayedSwap.ID, err.Error())
+	}
+	return nil
+}
+
+// isRelayTxMined returns whether the tx of the current step is mined successfully, it is error if the tx is
+// failed or is not mined in RelayTxTimeout
+func (engine *SwapEngine) isRelayTxMined(relayedSwap *model.RelayedSwap, txHash string) (bool, error) {
+	receipt, err := engine.getClient(relayedSwap.Chain).TransactionReceipt(context.Background(), ethcom.HexToHash(txHash))
+	if err == ethereum.NotFound {
+		if time.Since(relayedSwap.UpdatedAt) > RelayTxTimeout {
+			return false, fmt.Errorf("tx %s is not mined in %s", txHash, RelayTxTimeout.String())
+		}
+		return false, nil
+	} else if err != nil {
+		util.Logger.Errorf("query receipt of tx %s of relayed swap %d error: %s", txHash, relayedSwap.ID, err.Error())
+		return false, nil
+	}
+	if receipt.Status == TxFailedStatus {
+		return false, fmt.Errorf("tx %s is failed", txHash)
+	}
+	return true, nil
+}
+
+func (engine *SwapEngine) failRelayedSwap(relayedSwap *model.RelayedSwap, errorMsg string) {
+	step := relayedSwap.Status
+	relayedSwap.Status, relayedSwap.ErrorMsg = model.RelayedSwapFailed, errorMsg
+	if err := engine.store.SaveRelayedSwap(relayedSwap); err != nil {
+		util.Logger.Errorf("write db error: %s", err.Error())
+		return
+	}
+	msg := fmt.Sprintf("relayed swap %d of %s on %s is failed at %s: %s", relayedSwap.ID, relayedSwap.Owner,
+		relayedSwap.Chain, step, errorMsg)
+	util.Logger.Errorf(msg)
+	// the tokens are pulled to the relayer once the pull tx is mined, the pull tx of a failed pulling step may be
+	// mined or not
+	switch step {
+	case model.RelayedSwapPulling:
+		util.SendTelegramMessage(fmt.Sprintf("Urgent alert: %s, %s tokens of the owner may be held by relayer %s, check pull tx %s",
+			msg, relayedSwap.Amount, relayedSwap.Spender, relayedSwap.PullTxHash))
+	case model.RelayedSwapApproving, model.RelayedSwapStarting:
+		util.SendTelegramMessage(fmt.Sprintf("Urgent alert: %s, %s tokens of the owner are held by relayer %s",
+			msg, relayedSwap.Amount, relayedSwap.Spender))
+	default:
+		util.SendTelegramMessage(msg)
+	}
+}
@@ -14,12 +14,10 @@ import (
 	sabi "occ-swap-server/abi"
 
 	"github.com/ethereum/go-ethereum/accounts/abi"
-	"github.com/ethereum/go-ethereum/core"
 
 	ethcom "github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/jinzhu/gorm"
+	"github.com/ethereum/go-ethereum/crypto"
 
 	"occ-swap-server/common"
 	"occ-swap-server/model"
@@ -30,6 +28,12 @@ func (engine *SwapEngine) getRetrySwapHMAC(retrySwap *model.RetrySwap) string {
 	material := fmt.Sprintf("%d#%s#%s#%s#%s#%s#%s#%s#%s#%d#%s",
 		retrySwap.SwapID, retrySwap.Direction, retrySwap.StartTxHash, retrySwap.FillTxHash, retrySwap.Sponsor,
 		retrySwap.BEP20Addr, retrySwap.ERC20Addr, retrySwap.Symbol, retrySwap.Amount, retrySwap.Decimals, retrySwap.Status)
+	if isNFTSwap(retrySwap.AssetType) {
+		material = fmt.Sprintf("%s#%s#%s", material, retrySwap.AssetType, retrySwap.TokenId)
+	}
+	if retrySwap.Recipient != "" {
+		material = fmt.Sprintf("%s#%s", material, retrySwap.Recipient)
+	}
 	mac := hmac.New(sha256.New, []byte(engine.hmacCKey))
 	mac.Write([]byte(material))
 
@@ -40,40 +44,28 @@ func (engine *SwapEngine) verifyRetrySwap(retrySwap *model.RetrySwap) bool {
 	return retrySwap.RecordHash == engine.getRetrySwapHMAC(retrySwap)
 }
 
-func (engine *SwapEngine) insertRetrySwap(tx *gorm.DB, swap *model.RetrySwap) error {
+func (engine *SwapEngine) insertRetrySwap(tx SwapStore, swap *model.RetrySwap) error {
+	if err := swap.Normalize(); err != nil {
+		return err
+	}
 	swap.RecordHash = engine.getRetrySwapHMAC(swap)
-	return tx.Create(swap).Error
+	return tx.CreateRetrySwap(swap)
 }
 
-func (engine *SwapEngine) updateRetrySwap(tx *gorm.DB, retrySwap *model.RetrySwap) {
+func (engine *SwapEngine) updateRetrySwap(tx SwapStore, retrySwap *model.RetrySwap) {
 	retrySwap.RecordHash = engine.getRetrySwapHMAC(retrySwap)
-	tx.Save(retrySwap)
+	tx.SaveRetrySwap(retrySwap)
 }
 
-func (engine *SwapEngine) getRetrySwapByID(tx *gorm.DB, id uint) (*model.RetrySwap, error) {
-	retrySwap := model.RetrySwap{}
-	err := tx.Where("id = ?", id).First(&retrySwap).Error
+func (engine *SwapEngine) getRetrySwapByID(tx SwapStore, id uint) (*model.RetrySwap, error) {
+	retrySwap, err := tx.GetRetrySwap(id)
 	if err != nil {
 		return nil, err
 	}
-	if !engine.verifyRetrySwap(&retrySwap) {
+	if !engine.verifyRetrySwap(retrySwap) {
 		return nil, fmt.Errorf("hmac verification failure")
 	}
-	return &retrySwap, nil
-}
-
-func (engine *SwapEngine) insertRetrySwapTxsToDB(data *model.RetrySwapTx) error {
-	tx := engine.db.Begin()
-	if err := tx.Error; err != nil {
-		return err
-	}
-
-	if err := tx.Create(data).Error; err != nil {
-		tx.Rollback()
-		return err
-	}
-
-	return tx.Commit().Error
+	return retrySwap, nil
 }
 
 func (engine *SwapEngine) doRetrySwap(retrySwap *model.RetrySwap, swapPairInstance *SwapPairIns) (*model.RetrySwapTx, error) {
@@ -87,236 +79,197 @@ func (engine *SwapEngine) doRetrySwap(retrySwap *model.RetrySwap, swapPairInstan
 	if !okk {
 		return nil, fmt.Errorf("invalid chainId: %s", retrySwap.ToChainId)
 	}
-	if retrySwap.Direction == SwapEth2BSC || retrySwap.Direction == SwapMATIC2BSC {
-		bscClientMutex.Lock()
-		defer bscClientMutex.Unlock()
-		data, err := abiEncodeFillSwap(toChainId, ethcom.HexToAddress(retrySwap.Sponsor), amount, engine.swapAgentABI)
-		if err != nil {
-			return nil, err
-		}
-		signedTx, err := buildSignedTransaction(engine.bscSwapAgent, engine.bscClient, data, engine.bscPrivateKey, toChainId)
-		if err != nil {
-			return nil, err
-		}
-		retrySwapTx := &model.RetrySwapTx{
-			RetrySwapID:         retrySwap.ID,
-			StartTxHash:         retrySwap.StartTxHash,
-			Direction:           retrySwap.Direction,
-			RetryFillSwapTxHash: signedTx.Hash().String(),
-			Status:              model.FillRetryTxCreated,
-			GasPrice:            signedTx.GasPrice().String(),
-		}
-		err = engine.insertRetrySwapTxsToDB(retrySwapTx)
-		if err != nil {
-			return nil, err
-		}
-		err = engine.bscClient.SendTransaction(context.Background(), signedTx)
-		if err != nil {
-			util.Logger.Errorf("broadcast tx to BSC error: %s", err.Error())
-			return nil, err
-		}
-		util.Logger.Infof("Send transaction to BSC, %s/%s", engine.config.ChainConfig.BSCExplorerUrl, signedTx.Hash().String())
-		return retrySwapTx, nil
-	} else if retrySwap.Direction == SwapBSC2Eth || retrySwap.Direction == SwapMATIC2Eth {
-		ethClientMutex.Lock()
-		defer ethClientMutex.Unlock()
-		data, err := abiEncodeFillSwap(toChainId, ethcom.HexToAddress(retrySwap.Sponsor), amount, engine.swapAgentABI)
-		if err != nil {
-			return nil, err
-		}
-		signedTx, err := buildSignedTransaction(engine.ethSwapAgent, engine.ethClient, data, engine.ethPrivateKey, toChainId)
-		if err != nil {
-			return nil, err
-		}
-		retrySwapTx := &model.RetrySwapTx{
-			RetrySwapID:         retrySwap.ID,
-			StartTxHash:         retrySwap.StartTxHash,
-			Direction:           retrySwap.Direction,
-			RetryFillSwapTxHash: signedTx.Hash().String(),
-			GasPrice:            signedTx.GasPrice().String(),
-			Status:              model.FillRetryTxCreated,
-		}
-		err = engine.insertRetrySwapTxsToDB(retrySwapTx)
-		if err != nil {
-			return nil, err
-		}
-		err = engine.ethClient.SendTransaction(context.Background(), signedTx)
-		if err != nil {
-			util.Logger.Errorf("broadcast tx to ETH error: %s", err.Error())
-			return nil, err
-		} else {
-			util.Logger.Infof("Send transaction to ETH, %s/%s", engine.config.ChainConfig.ETHExplorerUrl, signedTx.Hash().String())
-		}
-		return retrySwapTx, nil
+
+	destChain := getDestChain(retrySwap.Direction)
+	if err := engine.screenRecipient(retrySwap.GetRecipient()); err != nil {
+		return nil, err
+	}
+	var data []byte
+	var err error
+	if isNFTSwap(retrySwap.AssetType) {
+		data, err = abiEncodeFillNFTSwap(destChain, toChainId, ethcom.HexToAddress(retrySwap.GetRecipient()), retrySwap.BEP20Addr, retrySwap.ERC20Addr, retrySwap.TokenId)
 	} else {
-		maticClientMutex.Lock()
-		defer maticClientMutex.Unlock()
-		data, err := abiEncodeFillSwap(toChainId, ethcom.HexToAddress(retrySwap.Sponsor), amount, engine.swapAgentABI)
-		if err != nil {
-			return nil, err
-		}
-		signedTx, err := buildSignedTransaction(engine.maticSwapAgent, engine.maticClient, data, engine.maticPrivateKey, toChainId)
-		if err != nil {
-			return nil, err
-		}
-		retrySwapTx := &model.RetrySwapTx{
-			RetrySwapID:         retrySwap.ID,
-			StartTxHash:         retrySwap.StartTxHash,
-			Direction:           retrySwap.Direction,
-			RetryFillSwapTxHash: signedTx.Hash().String(),
-			GasPrice:            signedTx.GasPrice().String(),
-			Status:              model.FillRetryTxCreated,
-		}
-		err = engine.insertRetrySwapTxsToDB(retrySwapTx)
-		if err != nil {
-			return nil, err
-		}
-		err = engine.maticClient.SendTransaction(context.Background(), signedTx)
-		if err != nil {
-			util.Logger.Errorf("broadcast tx to MATIC error: %s", err.Error())
-			return nil, err
-		}
-		util.Logger.Infof("Send transaction to MATIC, %s/%s", engine.config.ChainConfig.MATICExplorerUrl, signedTx.Hash().String())
-		return retrySwapTx, nil
+		data, err = engine.encodeFillSwap(fillCall{
+			direction:   retrySwap.Direction,
+			bep20Addr:   retrySwap.BEP20Addr,
+			erc20Addr:   retrySwap.ERC20Addr,
+			toChainId:   toChainId,
+			recipient:   ethcom.HexToAddress(retrySwap.GetRecipient()),
+			amount:      amount,
+			startTxHash: retrySwap.StartTxHash,
+		})
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the retried swaps have been waiting for long, send them before the new ones
+	var retrySwapTx *model.RetrySwapTx
+	use := KeyUse{Purpose: model.KeyUsageRetryFill, Daemon: "retry_failed_swaps", Token: ethcom.HexToAddress(retrySwap.ERC20Addr), Amount: amount}
+	signedTx, err := engine.relayerPools[destChain].Broadcast(BroadcastPriorityHigh, use, engine.getSwapAgent(destChain), data,
+		func(signedTx *types.Transaction, gasEstimate uint64) error {
+			if retrySwapTx != nil {
+				// the previous retry fill tx is underpriced and replaced, it is never sent
+				engine.store.DeleteRetrySwapTx(retrySwapTx.ID)
+			}
+			retrySwapTx = &model.RetrySwapTx{
+				RetrySwapID:         retrySwap.ID,
+				StartTxHash:         retrySwap.StartTxHash,
+				Direction:           retrySwap.Direction,
+				RetryFillSwapTxHash: signedTx.Hash().String(),
+				GasPrice:            signedTx.GasPrice().String(),
+				GasLimit:            int64(signedTx.Gas()),
+				GasEstimate:         int64(gasEstimate),
+				Status:              model.FillRetryTxCreated,
+			}
+			return engine.store.CreateRetrySwapTx(retrySwapTx)
+		})
+	if err == nil && !isNFTSwap(retrySwap.AssetType) {
+		engine.drainBrake.recordFill(destChain, ethcom.HexToAddress(retrySwap.ERC20Addr), amount, signedTx)
+	}
+	return retrySwapTx, err
 }
 
-func (engine *SwapEngine) retryFailedSwapsDaemon() {
-	for {
-		retrySwaps := make([]model.RetrySwap, 0)
-		engine.db.Where("status in (?)", []common.RetrySwapStatus{RetrySwapConfirmed, RetrySwapSending}).Order("id asc").Limit(BatchSize).Find(&retrySwaps)
-
-		for _, retrySwap := range retrySwaps {
-			var swapPairInstance *SwapPairIns
-			// var err error
-			retryCheckErr := func() error {
-				valid := engine.verifyRetrySwap(&retrySwap)
-				if !valid {
-					return fmt.Errorf("verify hmac of retry swap failed: %s", retrySwap.StartTxHash)
-				}
+// retryFailedSwapsDaemon fills a batch of the retry swaps, it is run again at once while there are more
+func (engine *SwapEngine) retryFailedSwapsDaemon() error {
+	retrySwaps := engine.getRetryableSwaps()
+	if len(retrySwaps) == 0 {
+		return nil
+	}
 
-				return nil
-			}()
-			if retryCheckErr != nil {
-				writeDBErr := func() error {
-					tx := engine.db.Begin()
-					if err := tx.Error; err != nil {
-						return err
-					}
-					retrySwap.Status = RetrySwapSendFailed
-					retrySwap.ErrorMsg = retryCheckErr.Error()
-					engine.updateRetrySwap(tx, &retrySwap)
-					return tx.Commit().Error
-				}()
-				if writeDBErr != nil {
-					util.Logger.Errorf("write db error: %s", writeDBErr.Error())
-					util.SendTelegramMessage(fmt.Sprintf("write db error: %s", writeDBErr.Error()))
-				}
-				continue
+	for _, retrySwap := range retrySwaps {
+		var swapPairInstance *SwapPairIns
+		// var err error
+		retryCheckErr := func() error {
+			valid := engine.verifyRetrySwap(&retrySwap)
+			if !valid {
+				return fmt.Errorf("verify hmac of retry swap failed: %s", retrySwap.StartTxHash)
 			}
 
-			skip, writeDBErr := func() (bool, error) {
-				isSkip := false
-				tx := engine.db.Begin()
-				if err := tx.Error; err != nil {
-					return false, err
-				}
-				if retrySwap.Status == RetrySwapSending {
-					var retrySwapTx model.RetrySwapTx
-					engine.db.Where("start_swap_tx_hash = ?", retrySwap.StartTxHash).First(&retrySwapTx)
-					if retrySwapTx.RetryFillSwapTxHash == "" {
-						util.Logger.Infof("retry the retrySwap, start tx hash %s, symbol %s, amount %s, direction",
-							retrySwap.StartTxHash, retrySwap.Symbol, retrySwap.Amount, retrySwap.Direction)
-						retrySwap.Status = RetrySwapConfirmed
-						engine.updateRetrySwap(tx, &retrySwap)
-					} else {
-						util.Logger.Infof("retry swap tx is built successfully, but the retry swap tx status is uncertain, just mark the swap and swap tx status as sent, retry swap ID %d", retrySwap.ID)
-						tx.Model(model.RetrySwapTx{}).Where("id = ?", retrySwapTx.ID).Updates(
-							map[string]interface{}{
-								"status":     model.FillRetryTxSent,
-								"updated_at": time.Now().Unix(),
-							})
-						retrySwap.Status = RetrySwapSent
-						retrySwap.FillTxHash = retrySwapTx.RetryFillSwapTxHash
-						engine.updateRetrySwap(tx, &retrySwap)
-
-						isSkip = true
-					}
-				} else {
-					retrySwap.Status = RetrySwapSending
-					engine.updateRetrySwap(tx, &retrySwap)
-				}
-				return isSkip, tx.Commit().Error
-			}()
+			return nil
+		}()
+		if retryCheckErr != nil {
+			writeDBErr := engine.store.Transaction(func(tx SwapStore) error {
+				retrySwap.Status = RetrySwapSendFailed
+				retrySwap.ErrorMsg = retryCheckErr.Error()
+				engine.updateRetrySwap(tx, &retrySwap)
+				return nil
+			})
 			if writeDBErr != nil {
 				util.Logger.Errorf("write db error: %s", writeDBErr.Error())
 				util.SendTelegramMessage(fmt.Sprintf("write db error: %s", writeDBErr.Error()))
-				continue
 			}
-			if skip {
-				util.Logger.Debugf("skip this swap, start tx hash %s", retrySwap.StartTxHash)
-				continue
-			}
-
-			util.Logger.Infof("Retry to handle swap, id: %d, direction %s, symbol %s, bep20 address %s, erc20 address %s, amount %s, sponsor %s",
-				retrySwap.ID, retrySwap.Direction, retrySwap.Symbol, retrySwap.BEP20Addr, retrySwap.ERC20Addr, retrySwap.Amount, retrySwap.Sponsor)
+			continue
+		}
 
-			retrySwapTx, doRetrySwapErr := engine.doRetrySwap(&retrySwap, swapPairInstance)
-			writeDBErr = func() error {
-				tx := engine.db.Begin()
-				if err := tx.Error; err != nil {
+		skip := false
+		writeDBErr := engine.store.Transaction(func(tx SwapStore) error {
+			if retrySwap.Status == RetrySwapSending {
+				retrySwapTx, err := tx.GetLastRetrySwapTx(retrySwap.ID)
+				if err != nil {
 					return err
 				}
-				if doRetrySwapErr != nil {
-					if doRetrySwapErr.Error() == core.ErrReplaceUnderpriced.Error() {
-						// delete the fill retry swap tx
-						tx.Where("retry_fill_swap_tx_hash = ?", retrySwapTx.RetryFillSwapTxHash).Delete(model.RetrySwapTx{})
-						// retry this swap
-						retrySwap.ErrorMsg = doRetrySwapErr.Error()
-						engine.updateRetrySwap(tx, &retrySwap)
-						util.Logger.Infof("Just try again for the retrySwap, start TxHash %s", retrySwap.StartTxHash)
-					} else {
-						util.Logger.Errorf("do retry swap failed: %s, start hash %s", doRetrySwapErr.Error(), retrySwap.StartTxHash)
-						util.SendTelegramMessage(fmt.Sprintf("do retry swap failed: %s, start hash %s", doRetrySwapErr.Error(), retrySwap.StartTxHash))
-
-						retrySwap.Status = RetrySwapSendFailed
-						retrySwap.ErrorMsg = doRetrySwapErr.Error()
-						engine.updateRetrySwap(tx, &retrySwap)
-
-						tx.Model(model.RetrySwapTx{}).Where("retry_fill_swap_tx_hash = ?", retrySwapTx.RetryFillSwapTxHash).Updates(
-							map[string]interface{}{
-								"status":     model.FillRetryTxFailed,
-								"error_msg":  doRetrySwapErr.Error(),
-								"updated_at": time.Now().Unix(),
-							})
-					}
+				if retrySwapTx == nil || retrySwapTx.RetryFillSwapTxHash == "" {
+					util.Logger.Infof("retry the retrySwap, start tx hash %s, symbol %s, amount %s, direction %s",
+						retrySwap.StartTxHash, retrySwap.Symbol, retrySwap.Amount, retrySwap.Direction)
+					retrySwap.Status = RetrySwapConfirmed
+					engine.updateRetrySwap(tx, &retrySwap)
 				} else {
-					tx.Model(model.RetrySwapTx{}).Where("retry_fill_swap_tx_hash = ?", retrySwapTx.RetryFillSwapTxHash).Updates(
-						map[string]interface{}{
-							"status":     model.FillRetryTxSent,
-							"updated_at": time.Now().Unix(),
-						})
+					util.Logger.Infof("retry swap tx is built successfully, but the retry swap tx status is uncertain, just mark the swap and swap tx status as sent, retry swap ID %d", retrySwap.ID)
+					tx.SetRetrySwapTxStatus(retrySwapTx.ID, model.FillRetryTxSent, "")
 					retrySwap.Status = RetrySwapSent
+					retrySwap.FillTxHash = retrySwapTx.RetryFillSwapTxHash
 					engine.updateRetrySwap(tx, &retrySwap)
+
+					skip = true
 				}
-				return tx.Commit().Error
-			}()
-			if writeDBErr != nil {
-				util.Logger.Errorf("write db error: %s", writeDBErr.Error())
-				util.SendTelegramMessage(fmt.Sprintf("write db error: %s", writeDBErr.Error()))
+			} else {
+				retrySwap.Status = RetrySwapSending
+				engine.updateRetrySwap(tx, &retrySwap)
 			}
+			return nil
+		})
+		if writeDBErr != nil {
+			util.Logger.Errorf("write db error: %s", writeDBErr.Error())
+			util.SendTelegramMessage(fmt.Sprintf("write db error: %s", writeDBErr.Error()))
+			continue
+		}
+		if skip {
+			util.Logger.Debugf("skip this swap, start tx hash %s", retrySwap.StartTxHash)
+			continue
+		}
+
+		util.Logger.Infof("Retry to handle swap, id: %d, direction %s, symbol %s, bep20 address %s, erc20 address %s, amount %s, sponsor %s",
+			retrySwap.ID, retrySwap.Direction, retrySwap.Symbol, retrySwap.BEP20Addr, retrySwap.ERC20Addr, retrySwap.Amount, retrySwap.Sponsor)
+
+		retrySwapTx, doRetrySwapErr := engine.doRetrySwap(&retrySwap, swapPairInstance)
+		writeDBErr = engine.store.Transaction(func(tx SwapStore) error {
+			if doRetrySwapErr != nil {
+				failureClass := classifySwapError(doRetrySwapErr)
+				if failureClass == common.FailureUnderpriced && retrySwapTx != nil {
+					// the nonce of the retry fill tx is mined or can't be replaced, delete the fill retry swap tx, the
+					// swap is retried again with a new nonce after the backoff
+					tx.DeleteRetrySwapTx(retrySwapTx.ID)
+					if err := engine.failRetrySwap(tx, &retrySwap, common.FailureUnderpriced, doRetrySwapErr.Error()); err != nil {
+						return err
+					}
+					util.Logger.Infof("retry swap tx is underpriced, start TxHash %s", retrySwap.StartTxHash)
+				} else {
+					util.Logger.Errorf("do retry swap failed: %s, start hash %s", doRetrySwapErr.Error(), retrySwap.StartTxHash)
+					util.SendTelegramMessage(fmt.Sprintf("do retry swap failed: %s, start hash %s", doRetrySwapErr.Error(), retrySwap.StartTxHash))
+
+					if err := engine.failRetrySwap(tx, &retrySwap, failureClass, doRetrySwapErr.Error()); err != nil {
+						return err
+					}
+
+					if retrySwapTx != nil {
+						tx.SetRetrySwapTxStatus(retrySwapTx.ID, model.FillRetryTxFailed, doRetrySwapErr.Error())
+					}
+				}
+			} else {
+				tx.SetRetrySwapTxStatus(retrySwapTx.ID, model.FillRetryTxSent, "")
+				retrySwap.Status = RetrySwapSent
+				retrySwap.FillTxHash = retrySwapTx.RetryFillSwapTxHash
+				engine.updateRetrySwap(tx, &retrySwap)
+			}
+			return nil
+		})
+		if writeDBErr != nil {
+			util.Logger.Errorf("write db error: %s", writeDBErr.Error())
+			util.SendTelegramMessage(fmt.Sprintf("write db error: %s", writeDBErr.Error()))
 		}
 	}
+	for _, retrySwap := range retrySwaps {
+		engine.store.ReleaseRetrySwapClaim(retrySwap.ID, engine.instanceID)
+	}
+	return util.RunAgain
 }
 
-func (engine *SwapEngine) trackRetrySwapTxDaemon() {
-	go func() {
-		for {
-			time.Sleep(SleepTime * time.Second)
+// getRetryableSwaps returns the confirmed retry swaps and claims them in the same tx, the retry swaps claimed by the
+// other executor replicas or locked by their txs are skipped
+func (engine *SwapEngine) getRetryableSwaps() []model.RetrySwap {
+	var retrySwaps []model.RetrySwap
+	// the drain brake halts are queried before the tx, the sqlite dbs have only one connection
+	excludedDirections := append(engine.getDegradedDirections(), engine.getDrainedDirections()...)
+	err := engine.store.Transaction(func(tx SwapStore) error {
+		var err error
+		retrySwaps, err = tx.ClaimRetrySwaps(excludedDirections, engine.instanceID, BatchSize, time.Now().Add(SwapClaimLease).Unix())
+		return err
+	})
+	if err != nil {
+		util.Logger.Errorf("claim retry swaps error: %s", err.Error())
+		return make([]model.RetrySwap, 0)
+	}
+	return retrySwaps
+}
 
-			retrySwapTxs := make([]model.RetrySwapTx, 0)
-			engine.db.Where("status = ? and track_retry_counter >= ?", model.FillRetryTxSent, engine.config.ChainConfig.ETHMaxTrackRetry).
-				Order("id asc").Limit(TrackSentTxBatchSize).Find(&retrySwapTxs)
+func (engine *SwapEngine) trackRetrySwapTxDaemon() {
+	engine.scheduler.Go(util.Daemon{Name: "track_missing_retry_swap_tx", Interval: engine.config.ChainConfig.GetTrackRetrySwapTxInterval(), WaitFirst: true,
+		Run: func() error {
+			retrySwapTxs, err := engine.store.FindSentRetrySwapTxs(engine.config.ChainConfig.ETHMaxTrackRetry, true, TrackSentTxBatchSize)
+			if err != nil {
+				return err
+			}
 
 			if len(retrySwapTxs) > 0 {
 				util.Logger.Infof("%d retry fill tx are missing, mark these retry swaps as failed", len(retrySwapTxs))
@@ -333,56 +286,60 @@ func (engine *SwapEngine) trackRetrySwapTxDaemon() {
 					chainName = "MATIC"
 					maxRetry = engine.config.ChainConfig.MATICMaxTrackRetry
 				}
-				util.Logger.Errorf("The retry fill tx is sent, however, after %d seconds its status is still uncertain. Mark tx as missing and mark swap as failed, chain %s, fill hash %s", SleepTime*maxRetry, chainName, retrySwapTx.RetryFillSwapTxHash)
-				util.SendTelegramMessage(fmt.Sprintf("The retry fill tx is sent, however, after %d seconds its status is still uncertain. Mark tx as missing and mark swap as failed, chain %s, start hash %s", SleepTime*maxRetry, chainName, retrySwapTx.RetryFillSwapTxHash))
+				util.Logger.Errorf("The retry fill tx is sent, however, after %d seconds its status is still uncertain. Mark tx as missing and mark swap as failed, chain %s, fill hash %s", int64(engine.config.ChainConfig.GetTrackRetrySwapTxInterval().Seconds())*maxRetry, chainName, retrySwapTx.RetryFillSwapTxHash)
+				util.SendTelegramMessage(fmt.Sprintf("The retry fill tx is sent, however, after %d seconds its status is still uncertain. Mark tx as missing and mark swap as failed, chain %s, start hash %s", int64(engine.config.ChainConfig.GetTrackRetrySwapTxInterval().Seconds())*maxRetry, chainName, retrySwapTx.RetryFillSwapTxHash))
 
-				writeDBErr := func() error {
-					tx := engine.db.Begin()
-					if err := tx.Error; err != nil {
-						return err
-					}
-					tx.Model(model.RetrySwapTx{}).Where("id = ?", retrySwapTx.ID).Updates(
-						map[string]interface{}{
-							"status":     model.FillRetryTxMissing,
-							"updated_at": time.Now().Unix(),
-						})
+				writeDBErr := engine.store.Transaction(func(tx SwapStore) error {
+					tx.SetRetrySwapTxStatus(retrySwapTx.ID, model.FillRetryTxMissing, "")
 
 					retrySwap, err := engine.getRetrySwapByID(tx, retrySwapTx.RetrySwapID)
 					if err != nil {
-						tx.Rollback()
 						return err
 					}
 					retrySwap.Status = RetrySwapSendFailed
 					retrySwap.ErrorMsg = fmt.Sprintf("track fill retry swap tx for more than %d times, the fill retry swap tx status is still uncertain", maxRetry)
 					engine.updateRetrySwap(tx, retrySwap)
-
-					return tx.Commit().Error
-				}()
+					return nil
+				})
 				if writeDBErr != nil {
 					util.Logger.Errorf("write db error: %s", writeDBErr.Error())
 					util.SendTelegramMessage(fmt.Sprintf("write db error: %s", writeDBErr.Error()))
 				}
 			}
-		}
-	}()
-
-	go func() {
-		for {
-			time.Sleep(SleepTime * time.Second)
+			return nil
+		}})
 
-			retrySwapTxs := make([]model.RetrySwapTx, 0)
-			engine.db.Where("status = ? and track_retry_counter < ?", model.FillRetryTxSent, engine.config.ChainConfig.ETHMaxTrackRetry).
-				Order("id asc").Limit(TrackSentTxBatchSize).Find(&retrySwapTxs)
+	engine.scheduler.Go(util.Daemon{Name: "track_sent_retry_swap_tx", Interval: engine.config.ChainConfig.GetTrackRetrySwapTxInterval(), WaitFirst: true,
+		Run: func() error {
+			retrySwapTxs, err := engine.store.FindSentRetrySwapTxs(engine.config.ChainConfig.ETHMaxTrackRetry, false, TrackSentTxBatchSize)
+			if err != nil {
+				return err
+			}
 
 			if len(retrySwapTxs) > 0 {
 				util.Logger.Debugf("Track %d non-finalized retry swap txs", len(retrySwapTxs))
 			}
 
+			// the retry txs are sent to different chains, their receipts are batched per chain
+			txHashes := make(map[string][]ethcom.Hash)
+			for _, retrySwapTx := range retrySwapTxs {
+				chain := getDestChain(retrySwapTx.Direction)
+				txHashes[chain] = append(txHashes[chain], ethcom.HexToHash(retrySwapTx.RetryFillSwapTxHash))
+			}
+			receipts := make(map[ethcom.Hash]*types.Receipt)
+			receiptErrs := make(map[ethcom.Hash]error)
+			for chain, hashes := range txHashes {
+				chainReceipts, errs := getTransactionReceipts(engine.getClient(chain), hashes)
+				for i, txHash := range hashes {
+					receipts[txHash], receiptErrs[txHash] = chainReceipts[i], errs[i]
+				}
+			}
+
 			for _, retrySwapTx := range retrySwapTxs {
 				gasPrice := big.NewInt(0)
 				gasPrice.SetString(retrySwapTx.GasPrice, 10)
 
-				var client *ethclient.Client
+				var client ChainClient
 				var chainName string
 				client = engine.ethClient
 				if retrySwapTx.Direction == SwapEth2BSC || retrySwapTx.Direction == SwapMATIC2BSC {
@@ -394,75 +351,63 @@ func (engine *SwapEngine) trackRetrySwapTxDaemon() {
 					client = engine.maticClient
 				}
 				var txRecipient *types.Receipt
+				var revertReason string
 				queryTxStatusErr := func() error {
-					block, err := client.BlockByNumber(context.Background(), nil)
+					height, err := engine.heads[getDestChain(retrySwapTx.Direction)].Height()
 					if err != nil {
 						util.Logger.Debugf("%s, query block failed: %s", chainName, err.Error())
 						return err
 					}
-					txRecipient, err = client.TransactionReceipt(context.Background(), ethcom.HexToHash(retrySwapTx.RetryFillSwapTxHash))
+					txHash := ethcom.HexToHash(retrySwapTx.RetryFillSwapTxHash)
+					txRecipient, err = receipts[txHash], receiptErrs[txHash]
 					if err != nil {
 						util.Logger.Debugf("%s, query tx failed: %s", chainName, err.Error())
 						return err
 					}
-					if block.Number().Int64() < txRecipient.BlockNumber.Int64()+engine.config.ChainConfig.ETHConfirmNum {
+					if height < txRecipient.BlockNumber.Int64()+engine.config.ChainConfig.ETHConfirmNum {
 						return fmt.Errorf("%s, swap tx is still not finalized", chainName)
 					}
+					if txRecipient.Status == TxFailedStatus {
+						revertReason, err = getRevertReason(client, txRecipient.TxHash, txRecipient.BlockNumber)
+						if err != nil {
+							// the failed tx is recorded without its revert reason, the receipt is final whether the replay works or not
+							util.Logger.Errorf("%s, get revert reason of tx %s failed: %s", chainName, txRecipient.TxHash.String(), err.Error())
+							revertReason = ""
+						}
+					}
 					return nil
 				}()
 
-				writeDBErr := func() error {
-					tx := engine.db.Begin()
-					if err := tx.Error; err != nil {
-						return err
-					}
+				writeDBErr := engine.store.Transaction(func(tx SwapStore) error {
 					if queryTxStatusErr != nil {
-						tx.Model(model.RetrySwapTx{}).Where("id = ?", retrySwapTx.ID).Updates(
-							map[string]interface{}{
-								"track_retry_counter": gorm.Expr("track_retry_counter + 1"),
-								"updated_at":          time.Now().Unix(),
-							})
+						tx.IncrRetrySwapTxTrackRetry(retrySwapTx.ID)
 					} else {
 						txFee := big.NewInt(1).Mul(gasPrice, big.NewInt(int64(txRecipient.GasUsed))).String()
 						if txRecipient.Status == TxFailedStatus {
-							util.Logger.Infof(fmt.Sprintf("fill retry swap tx is failed, chain %s, txHash: %s", chainName, txRecipient.TxHash.String()))
-							util.SendTelegramMessage(fmt.Sprintf("fill retry swap tx is failed, chain %s, txHash: %s", chainName, txRecipient.TxHash.String()))
-							err := tx.Model(model.RetrySwapTx{}).Where("id = ?", retrySwapTx.ID).Updates(
-								map[string]interface{}{
-									"status":              model.FillRetryTxFailed,
-									"height":              txRecipient.BlockNumber.Int64(),
-									"consumed_fee_amount": txFee,
-									"updated_at":          time.Now().Unix(),
-								}).Error
+							util.Logger.Infof(fmt.Sprintf("fill retry swap tx is failed, chain %s, txHash: %s, revert reason: %s", chainName, txRecipient.TxHash.String(), revertReason))
+							util.SendTelegramMessage(fmt.Sprintf("fill retry swap tx is failed, chain %s, txHash: %s, revert reason: %s %s", chainName, txRecipient.TxHash.String(), revertReason,
+								engine.config.ExplorerLink(getDestChain(retrySwapTx.Direction), util.ExplorerTx, txRecipient.TxHash.String())))
+							err := tx.SetRetrySwapTxReceipt(retrySwapTx.ID, model.FillRetryTxFailed, txRecipient.BlockNumber.Int64(), txFee, revertReason)
 							if err != nil {
-								tx.Rollback()
 								return err
 							}
 							retrySwap, err := engine.getRetrySwapByID(tx, retrySwapTx.RetrySwapID)
 							if err != nil {
-								tx.Rollback()
 								return err
 							}
-							retrySwap.Status = RetrySwapSendFailed
-							retrySwap.ErrorMsg = "fill retry swap tx is failed"
-							engine.updateRetrySwap(tx, retrySwap)
+							if err := engine.failRetrySwap(tx, retrySwap, common.FailureRevert,
+								fmt.Sprintf("fill retry swap tx is failed, revert reason: %s", revertReason)); err != nil {
+								return err
+							}
 						} else {
 							util.Logger.Infof(fmt.Sprintf("fill retry swap tx is success, chain %s, txHash: %s", chainName, txRecipient.TxHash.String()))
-							err := tx.Model(model.RetrySwapTx{}).Where("id = ?", retrySwapTx.ID).Updates(
-								map[string]interface{}{
-									"status":              model.FillRetryTxSuccess,
-									"height":              txRecipient.BlockNumber.Int64(),
-									"consumed_fee_amount": txFee,
-									"updated_at":          time.Now().Unix(),
-								}).Error
+							err := tx.SetRetrySwapTxReceipt(retrySwapTx.ID, model.FillRetryTxSuccess, txRecipient.BlockNumber.Int64(), txFee, "")
 							if err != nil {
-								tx.Rollback()
 								return err
 							}
 
 							retrySwap, err := engine.getRetrySwapByID(tx, retrySwapTx.RetrySwapID)
 							if err != nil {
-								tx.Rollback()
 								return err
 							}
 							retrySwap.Status = RetrySwapSuccess
@@ -471,71 +416,74 @@ func (engine *SwapEngine) trackRetrySwapTxDaemon() {
 
 							swap, err := engine.getSwapByStartTxHash(tx, retrySwapTx.StartTxHash)
 							if err != nil {
-								tx.Rollback()
 								return err
 							}
-							swap.Status = SwapSuccess
-							swap.Log = fmt.Sprintf("retry success, retry txHash %s", retrySwapTx.RetryFillSwapTxHash)
-							engine.updateSwap(tx, swap)
+							if mismatch := engine.verifySwapFilledEvent(txRecipient, swap, retrySwap.FillTxHash); mismatch != "" {
+								util.Logger.Errorf("fill retry swap tx mismatch, chain %s, start hash %s, %s", chainName, swap.StartTxHash, mismatch)
+								util.SendTelegramMessage(fmt.Sprintf("Urgent alert: fill retry swap tx mismatch, chain %s, start hash %s, %s", chainName, swap.StartTxHash, mismatch))
+								swap.Status = SwapMismatch
+								swap.Log = mismatch
+							} else {
+								swap.Status = SwapSuccess
+								swap.Log = fmt.Sprintf("retry success, retry txHash %s", retrySwapTx.RetryFillSwapTxHash)
+							}
+							if err := engine.updateSwap(tx, swap); err != nil {
+								return err
+							}
 						}
 					}
-					return tx.Commit().Error
-				}()
+					return nil
+				})
 				if writeDBErr != nil {
 					util.Logger.Errorf("update db failure2: %s", writeDBErr.Error())
 					util.SendTelegramMessage(fmt.Sprintf("Upgent alert: update db failure2: %s", writeDBErr.Error()))
 				}
 			}
-		}
-	}()
+			return nil
+		}})
 }
 
 func (engine *SwapEngine) InsertRetryFailedSwaps(swapIDList []uint) ([]uint, []uint, error) {
-	swaps := make([]model.Swap, 0)
-	engine.db.Where("id  in (?)", swapIDList).Find(&swaps)
-
+	swaps, err := engine.store.FindSwaps(SwapQuery{IDs: swapIDList})
+	if err != nil {
+		return nil, nil, err
+	}
 	if len(swaps) == 0 {
 		return nil, nil, fmt.Errorf("no matched swap")
 	}
 
 	retrySwapList := make([]uint, 0, len(swapIDList))
 	rejectedRetrySwapList := make([]uint, 0, len(swapIDList))
-	writeDBErr := func() error {
-		tx := engine.db.Begin()
-		if err := tx.Error; err != nil {
-			return err
-		}
+	cutoff, expiryEnabled := engine.getExpiryCutoff()
+	writeDBErr := engine.store.Transaction(func(tx SwapStore) error {
 		for _, swap := range swaps {
 			if !engine.verifySwap(&swap) {
 				rejectedRetrySwapList = append(rejectedRetrySwapList, swap.ID)
 				continue
 			}
-			if swap.Status != SwapSendFailed {
+			// only the failed fills are retried, not the rejected swaps, and the imported swaps are filled, or refunded,
+			// by the deployment they are imported from
+			if swap.Status != SwapSendFailed && swap.Status != SwapAbandoned || swap.ImportedFrom != "" {
+				rejectedRetrySwapList = append(rejectedRetrySwapList, swap.ID)
+				continue
+			}
+			// the swaps past the max age are left to the expiry daemon
+			if expiryEnabled && swap.CreatedAt.Before(cutoff) {
 				rejectedRetrySwapList = append(rejectedRetrySwapList, swap.ID)
 				continue
 			}
 			retrySwapList = append(retrySwapList, swap.ID)
-			retrySwap := &model.RetrySwap{
-				Status:      RetrySwapConfirmed,
-				SwapID:      swap.ID,
-				Direction:   swap.Direction,
-				StartTxHash: swap.StartTxHash,
-				FillTxHash:  swap.FillTxHash,
-				Sponsor:     swap.Sponsor,
-				BEP20Addr:   swap.BEP20Addr,
-				ERC20Addr:   swap.ERC20Addr,
-				Symbol:      swap.Symbol,
-				Amount:      swap.Amount,
-				Decimals:    swap.Decimals,
-				ToChainId:   swap.ToChainId,
+			if err := engine.insertRetrySwap(tx, newRetrySwap(&swap)); err != nil {
+				return err
 			}
-			if err := engine.insertRetrySwap(tx, retrySwap); err != nil {
-				tx.Rollback()
+			// the auto retry is cancelled, it is scheduled again if the manual retry fails
+			swap.NextRetryAt = 0
+			if err := engine.updateSwap(tx, &swap); err != nil {
 				return err
 			}
 		}
-		return tx.Commit().Error
-	}()
+		return nil
+	})
 	return retrySwapList, rejectedRetrySwapList, writeDBErr
 }
 
@@ -547,17 +495,18 @@ func (engine *SwapEngine) WithdrawToken(chain string, tokenAddr, recipient ethco
 	emptyAddr := ethcom.Address{}
 	privateKey := engine.bscPrivateKey
 	client := engine.bscClient
-	explorerUrl := engine.config.ChainConfig.BSCExplorerUrl
 	if chain == common.ChainETH {
 		privateKey = engine.ethPrivateKey
 		client = engine.ethClient
-		explorerUrl = engine.config.ChainConfig.ETHExplorerUrl
 		ethClientMutex.Lock()
 		defer ethClientMutex.Unlock()
 	} else {
 		bscClientMutex.Lock()
 		defer bscClientMutex.Unlock()
 	}
+	// the withdrawals are sent by the operators through the admin api
+	account := crypto.PubkeyToAddress(privateKey.PublicKey)
+	use := KeyUse{Purpose: model.KeyUsageWithdraw, Daemon: "admin", Token: tokenAddr, Amount: amount}
 	// withdraw native token
 	if bytes.Equal(tokenAddr[:], emptyAddr[:]) {
 		signedTx, err := buildNativeCoinTransferTx(recipient, client, amount, privateKey)
@@ -565,12 +514,15 @@ func (engine *SwapEngine) WithdrawToken(chain string, tokenAddr, recipient ethco
 			util.Logger.Errorf("build native coin transfer error: %s", err.Error())
 			return "", err
 		}
+		if err := engine.recordKeyUsage(chain, account, use, signedTx); err != nil {
+			return "", err
+		}
 		err = client.SendTransaction(context.Background(), signedTx)
 		if err != nil {
 			util.Logger.Errorf("broadcast tx to %s error: %s", chain, err.Error())
 			return "", err
 		}
-		util.Logger.Infof("Send transaction to %s, %s/%s", chain, explorerUrl, signedTx.Hash().String())
+		util.Logger.Infof("Send transaction to %s, %s %s", chain, signedTx.Hash().String(), engine.config.ExplorerLink(chain, util.ExplorerTx, signedTx.Hash().String()))
 		return signedTx.Hash().String(), nil
 	}
 	// withdraw BEP20 or ERC20 token
@@ -582,11 +534,14 @@ func (engine *SwapEngine) WithdrawToken(chain string, tokenAddr, recipient ethco
 	if err != nil {
 		return "", err
 	}
+	if err := engine.recordKeyUsage(chain, account, use, signedTx); err != nil {
+		return "", err
+	}
 	err = client.SendTransaction(context.Background(), signedTx)
 	if err != nil {
 		util.Logger.Errorf("broadcast tx to %s error: %s", chain, err.Error())
 		return "", err
 	}
-	util.Logger.Infof("Send transaction to %s, %s/%s", chain, explorerUrl, signedTx.Hash().String())
+	util.Logger.Infof("Send transaction to %s, %s %s", chain, signedTx.Hash().String(), engine.config.ExplorerLink(chain, util.ExplorerTx, signedTx.Hash().String()))
 	return signedTx.Hash().String(), nil
 }
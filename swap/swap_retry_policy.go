@@ -0,0 +1,155 @@
+package swap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core"
+
+	"occ-swap-server/common"
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+// activeRetrySwapStatuses are the statuses of the retry swaps whose fill tx may still be sent or mined
+var activeRetrySwapStatuses = []common.RetrySwapStatus{RetrySwapConfirmed, RetrySwapSending, RetrySwapSent}
+
+// classifySwapError returns the failure class of the error of sending a fill tx, the unknown errors are FailureRPC
+func classifySwapError(err error) common.FailureClass {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return common.FailureTimeout
+	}
+	if errors.Is(err, errRecipientBlocked) {
+		return common.FailureBlocked
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return common.FailureTimeout
+	}
+
+	msg := strings.ToLower(err.Error())
+	switch {
+	case msg == strings.ToLower(core.ErrReplaceUnderpriced.Error()):
+		return common.FailureUnderpriced
+	case strings.Contains(msg, "execution reverted"):
+		return common.FailureRevert
+	case strings.Contains(msg, "nonce too low"):
+		return common.FailureNonce
+	case strings.Contains(msg, "insufficient funds"):
+		return common.FailureInsufficientFunds
+	case strings.Contains(msg, "connection refused"), strings.Contains(msg, "connection reset"),
+		strings.Contains(msg, "no such host"), strings.HasSuffix(msg, "eof"):
+		return common.FailureConnection
+	case strings.Contains(msg, "timeout"), strings.Contains(msg, "timed out"):
+		return common.FailureTimeout
+	}
+	return common.FailureRPC
+}
+
+// failSwap marks the swap as failed and schedules its auto retry by the retry policy of the failure class, the
+// swap is abandoned if it has been retried for the max attempts, the permanent failures are never retried. The
+// caller saves the swap.
+func (engine *SwapEngine) failSwap(swap *model.Swap, class common.FailureClass, reason string) {
+	swap.Status = SwapSendFailed
+	swap.FailureClass = class
+	swap.FailureCategory = class.Category()
+	swap.NextRetryAt = 0
+
+	if swap.FailureCategory == common.FailurePermanent {
+		util.Logger.Infof("swap failure %s is permanent, it is not retried automatically, start tx hash %s", class, swap.StartTxHash)
+		return
+	}
+	policy, ok := engine.config.ChainConfig.GetRetryPolicy(class)
+	if !ok {
+		return
+	}
+	if swap.RetryAttempts >= policy.MaxAttempts {
+		swap.Status = SwapAbandoned
+		swap.Log = fmt.Sprintf("abandoned after %d retries, last failure %s: %s", swap.RetryAttempts, class, reason)
+		util.Logger.Errorf("swap is abandoned, start tx hash %s, %s", swap.StartTxHash, swap.Log)
+		util.SendTelegramMessage(fmt.Sprintf("Urgent alert: swap is abandoned, start tx hash %s, %s", swap.StartTxHash, swap.Log))
+		return
+	}
+	swap.NextRetryAt = time.Now().Add(policy.GetRetryBackoff(swap.RetryAttempts)).Unix()
+	util.Logger.Infof("schedule retry %d/%d of swap at %d, start tx hash %s, failure %s",
+		swap.RetryAttempts+1, policy.MaxAttempts, swap.NextRetryAt, swap.StartTxHash, class)
+}
+
+// failRetrySwap marks the retry swap as failed and schedules the next auto retry of its swap
+func (engine *SwapEngine) failRetrySwap(tx SwapStore, retrySwap *model.RetrySwap, class common.FailureClass, reason string) error {
+	retrySwap.Status = RetrySwapSendFailed
+	retrySwap.ErrorMsg = reason
+	engine.updateRetrySwap(tx, retrySwap)
+
+	swap, err := engine.getSwapByStartTxHash(tx, retrySwap.StartTxHash)
+	if err != nil {
+		return err
+	}
+	if swap.Status != SwapSendFailed && swap.Status != SwapAbandoned {
+		return nil
+	}
+	engine.failSwap(swap, class, reason)
+	return engine.updateSwap(tx, swap)
+}
+
+func newRetrySwap(swap *model.Swap) *model.RetrySwap {
+	return &model.RetrySwap{
+		Status:      RetrySwapConfirmed,
+		SwapID:      swap.ID,
+		Direction:   swap.Direction,
+		StartTxHash: swap.StartTxHash,
+		FillTxHash:  swap.FillTxHash,
+		Sponsor:     swap.Sponsor,
+		Recipient:   swap.Recipient,
+		BEP20Addr:   swap.BEP20Addr,
+		ERC20Addr:   swap.ERC20Addr,
+		Symbol:      swap.Symbol,
+		Amount:      swap.Amount,
+		Decimals:    swap.Decimals,
+		AssetType:   swap.AssetType,
+		TokenId:     swap.TokenId,
+		ToChainId:   swap.ToChainId,
+	}
+}
+
+// autoRetryFailedSwapsDaemon creates the retry swaps of the failed swaps whose backoff is expired, the swaps to the
+// degraded chains wait until the rpc is back so their transient failures don't use up the attempts
+func (engine *SwapEngine) autoRetryFailedSwapsDaemon() error {
+	// the swaps past the max age are left to the expiry daemon
+	cutoff, _ := engine.getExpiryCutoff()
+	swaps, err := engine.store.FindRetryDueSwaps(time.Now().Unix(), engine.getDegradedDirections(), cutoff, BatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, swap := range swaps {
+		writeDBErr := engine.store.Transaction(func(tx SwapStore) error {
+			if !engine.verifySwap(&swap) {
+				return fmt.Errorf("verify hmac of swap failed: %s", swap.StartTxHash)
+			}
+
+			activeCount, err := tx.CountActiveRetrySwaps(swap.ID)
+			if err != nil {
+				return err
+			}
+			if activeCount == 0 {
+				if err := engine.insertRetrySwap(tx, newRetrySwap(&swap)); err != nil {
+					return err
+				}
+				swap.RetryAttempts++
+				util.Logger.Infof("auto retry %d of swap, start tx hash %s, failure %s", swap.RetryAttempts, swap.StartTxHash, swap.FailureClass)
+			}
+			swap.NextRetryAt = 0
+			return engine.updateSwap(tx, &swap)
+		})
+		if writeDBErr != nil {
+			util.Logger.Errorf("write db error: %s", writeDBErr.Error())
+			util.SendTelegramMessage(fmt.Sprintf("write db error: %s", writeDBErr.Error()))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package swap
+
+import (
+	"math/big"
+	"time"
+
+	"occ-swap-server/common"
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+// RollupChunk is the range of the swaps aggregated at once, the backfill of the first run is aggregated chunk by chunk
+const RollupChunk = 24 * time.Hour
+
+var rollupFailedStatuses = []common.SwapStatus{SwapSendFailed, SwapAbandoned, SwapExpired, SwapRejected}
+
+type rollupKey struct {
+	bucketStart int64
+	erc20Addr   string
+	direction   common.SwapDirection
+}
+
+// rollupDaemon recomputes the hourly rollups of the buckets which are not settled yet from the swaps, and the daily
+// rollups of their days from the hourly rollups. The swaps moved to the archive tables are not in the buckets
+// recomputed after, so the settle window is kept below the max age of the archive.
+func (engine *SwapEngine) rollupDaemon() error {
+	cfg := engine.config.RollupConfig
+	now := time.Now().UTC()
+
+	from := now.Add(-cfg.GetBackfillWindow())
+	latest, err := engine.store.GetLatestRollup(model.RollupHour)
+	if err != nil {
+		return err
+	}
+	if latest != nil {
+		if settled := time.Unix(latest.BucketStart, 0).UTC().Add(-cfg.GetSettleWindow()); settled.After(from) {
+			from = settled
+		}
+	}
+	from = from.Truncate(time.Hour)
+
+	for start := from; start.Before(now); start = start.Add(RollupChunk) {
+		end := start.Add(RollupChunk)
+		if end.After(now) {
+			end = now.Truncate(time.Hour).Add(time.Hour)
+		}
+		if err := engine.rollupHours(start, end); err != nil {
+			return err
+		}
+	}
+	return engine.rollupDays(from.Truncate(24 * time.Hour))
+}
+
+// rollupHours replaces the hourly rollups of the buckets from start to end, both on the hour
+func (engine *SwapEngine) rollupHours(start, end time.Time) error {
+	swaps, err := engine.store.FindSwaps(SwapQuery{CreatedSince: start, CreatedBefore: end})
+	if err != nil {
+		return err
+	}
+
+	rollups := make(map[rollupKey]*model.SwapRollup)
+	keys := make([]rollupKey, 0)
+	for _, swap := range swaps {
+		key := rollupKey{bucketStart: swap.CreatedAt.UTC().Truncate(time.Hour).Unix(), erc20Addr: swap.ERC20Addr,
+			direction: swap.Direction}
+		rollup, ok := rollups[key]
+		if !ok {
+			rollup = &model.SwapRollup{Period: model.RollupHour, BucketStart: key.bucketStart, ERC20Addr: swap.ERC20Addr,
+				Direction: swap.Direction, Symbol: swap.Symbol, TotalAmount: "0", FilledAmount: "0"}
+			rollups[key] = rollup
+			keys = append(keys, key)
+		}
+		amount, ok := big.NewInt(0).SetString(swap.Amount, 10)
+		if !ok {
+			amount = big.NewInt(0)
+		}
+		rollup.SwapCount++
+		rollup.TotalAmount = addAmounts(rollup.TotalAmount, amount)
+		if swap.Status == SwapSuccess {
+			rollup.SuccessCount++
+			rollup.FilledAmount = addAmounts(rollup.FilledAmount, amount)
+			rollup.LatencySum += int64(nonNegative(swap.UpdatedAt.Sub(swap.CreatedAt)) / time.Second)
+		}
+		for _, status := range rollupFailedStatuses {
+			if swap.Status == status {
+				rollup.FailedCount++
+			}
+		}
+	}
+
+	ordered := make([]*model.SwapRollup, 0, len(keys))
+	for _, key := range keys {
+		ordered = append(ordered, rollups[key])
+	}
+	return engine.replaceRollups(model.RollupHour, start, end, ordered)
+}
+
+// rollupDays replaces the daily rollups from the day of start, from the hourly rollups of the days
+func (engine *SwapEngine) rollupDays(start time.Time) error {
+	hours, err := engine.store.FindRollups(RollupQuery{Period: model.RollupHour, Start: start.Unix()})
+	if err != nil {
+		return err
+	}
+
+	rollups := make(map[rollupKey]*model.SwapRollup)
+	ordered := make([]*model.SwapRollup, 0)
+	for _, hour := range hours {
+		key := rollupKey{bucketStart: time.Unix(hour.BucketStart, 0).UTC().Truncate(24 * time.Hour).Unix(),
+			erc20Addr: hour.ERC20Addr, direction: hour.Direction}
+		rollup, ok := rollups[key]
+		if !ok {
+			rollup = &model.SwapRollup{Period: model.RollupDay, BucketStart: key.bucketStart, ERC20Addr: hour.ERC20Addr,
+				Direction: hour.Direction, Symbol: hour.Symbol, TotalAmount: "0", FilledAmount: "0"}
+			rollups[key] = rollup
+			ordered = append(ordered, rollup)
+		}
+		total, _ := big.NewInt(0).SetString(hour.TotalAmount, 10)
+		filled, _ := big.NewInt(0).SetString(hour.FilledAmount, 10)
+		rollup.SwapCount += hour.SwapCount
+		rollup.SuccessCount += hour.SuccessCount
+		rollup.FailedCount += hour.FailedCount
+		rollup.TotalAmount = addAmounts(rollup.TotalAmount, total)
+		rollup.FilledAmount = addAmounts(rollup.FilledAmount, filled)
+		rollup.LatencySum += hour.LatencySum
+	}
+	return engine.replaceRollups(model.RollupDay, start, time.Now().UTC().Truncate(24*time.Hour).Add(24*time.Hour), ordered)
+}
+
+// replaceRollups deletes the rollups of the period from start to end and saves the recomputed ones at once
+func (engine *SwapEngine) replaceRollups(period model.RollupPeriod, start, end time.Time, rollups []*model.SwapRollup) error {
+	err := engine.store.Transaction(func(tx SwapStore) error {
+		if err := tx.DeleteRollups(period, start.Unix(), end.Unix()); err != nil {
+			return err
+		}
+		for _, rollup := range rollups {
+			if rollup.SuccessCount > 0 {
+				rollup.AvgLatency = rollup.LatencySum / rollup.SuccessCount
+			}
+			if err := tx.CreateRollup(rollup); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	util.Logger.Debugf("%d %s rollups from %s to %s are saved", len(rollups), period, start.String(), end.String())
+	return nil
+}
+
+// GetRollups returns the rollups of the period whose buckets start from start to end, of the pair and the direction
+// if they are set
+func (engine *SwapEngine) GetRollups(period model.RollupPeriod, start, end int64, erc20Addr string,
+	direction common.SwapDirection, limit int) ([]model.SwapRollup, error) {
+	return engine.store.FindRollups(RollupQuery{Period: period, Start: start, End: end, ERC20Addr: erc20Addr,
+		Direction: direction, Limit: limit})
+}
+
+func addAmounts(sum string, amount *big.Int) string {
+	total, ok := big.NewInt(0).SetString(sum, 10)
+	if !ok || amount == nil {
+		return sum
+	}
+	return total.Add(total, amount).String()
+}
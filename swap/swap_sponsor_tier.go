@@ -0,0 +1,105 @@
+package swap
+
+import (
+	"fmt"
+	"math/big"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+
+	"occ-swap-server/common"
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+// unfilledSwapStatuses are the statuses of the swaps waiting for their fill, their priorities follow the tier of
+// the sponsor
+var unfilledSwapStatuses = []common.SwapStatus{SwapTokenReceived, SwapConfirmed, SwapDelayed, SwapAwaitingLiquidity,
+	SwapAwaitingWindow, SwapAwaitingGas, SwapUneconomic}
+
+// getSponsorTier returns the tier of the sponsor, nil if the sponsor doesn't have one
+func (engine *SwapEngine) getSponsorTier(sponsor string) (*model.SponsorTier, error) {
+	return engine.store.GetSponsorTier(sponsor)
+}
+
+// getFeeRebate returns the part of the bridge fee rebated to the sponsor of the tier
+func getFeeRebate(fee *big.Int, tier *model.SponsorTier) *big.Int {
+	if tier == nil || fee == nil {
+		return big.NewInt(0)
+	}
+	rebate := new(big.Int).Mul(fee, big.NewInt(tier.FeeRebatePercent))
+	return rebate.Div(rebate, big.NewInt(100))
+}
+
+// applySponsorTier sets the priority and the fee rebate of the new swap by the tier of its sponsor
+func (engine *SwapEngine) applySponsorTier(swap *model.Swap, txEventLog *model.SwapStartTxLog) {
+	tier, err := engine.getSponsorTier(swap.Sponsor)
+	if err != nil {
+		util.Logger.Errorf("query tier of sponsor %s error: %s", swap.Sponsor, err.Error())
+		return
+	}
+	if tier == nil {
+		return
+	}
+	swap.Priority = tier.Priority
+	if fee, ok := big.NewInt(0).SetString(txEventLog.FeeAmount, 10); ok {
+		if rebate := getFeeRebate(fee, tier); rebate.Sign() > 0 {
+			swap.FeeRebate = rebate.String()
+		}
+	}
+}
+
+func (engine *SwapEngine) GetSponsorTiers() ([]model.SponsorTier, error) {
+	return engine.store.FindSponsorTiers()
+}
+
+// SetSponsorTier adds or replaces the tier of the sponsor, the unfilled swaps of the sponsor are reordered by the
+// new priority, their fee rebates are kept
+func (engine *SwapEngine) SetSponsorTier(sponsor ethcom.Address, name string, feeRebatePercent, priority int64, operator string) (*model.SponsorTier, error) {
+	if feeRebatePercent < 0 || feeRebatePercent > 100 {
+		return nil, fmt.Errorf("fee rebate percent %d is not between 0 and 100", feeRebatePercent)
+	}
+	tier := model.SponsorTier{}
+	err := engine.store.Transaction(func(tx SwapStore) error {
+		existing, err := tx.GetSponsorTier(sponsor.String())
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			tier = *existing
+		}
+		tier.Sponsor = sponsor.String()
+		tier.Tier = name
+		tier.FeeRebatePercent = feeRebatePercent
+		tier.Priority = priority
+		tier.Operator = operator
+		if err := tx.SaveSponsorTier(&tier); err != nil {
+			return err
+		}
+		return tx.SetSponsorPriority(sponsor.String(), unfilledSwapStatuses, priority)
+	})
+	if err != nil {
+		return nil, err
+	}
+	util.Logger.Infof("tier of sponsor %s is set to %s by %s, fee rebate %d%%, priority %d", sponsor.String(), name,
+		operator, feeRebatePercent, priority)
+	return &tier, nil
+}
+
+// RemoveSponsorTier removes the tier of the sponsor, its unfilled swaps lose their priority
+func (engine *SwapEngine) RemoveSponsorTier(sponsor ethcom.Address, operator string) error {
+	err := engine.store.Transaction(func(tx SwapStore) error {
+		deleted, err := tx.DeleteSponsorTier(sponsor.String())
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return fmt.Errorf("sponsor %s doesn't have a tier", sponsor.String())
+		}
+		return tx.SetSponsorPriority(sponsor.String(), unfilledSwapStatuses, 0)
+	})
+	if err != nil {
+		return err
+	}
+	util.Logger.Infof("tier of sponsor %s is removed by %s", sponsor.String(), operator)
+	return nil
+}
@@ -0,0 +1,96 @@
+package swap
+
+import (
+	"fmt"
+	"math/big"
+
+	"occ-swap-server/common"
+	"occ-swap-server/model"
+)
+
+const (
+	DirectionOperational = "operational"
+	DirectionDegraded    = "degraded"
+	DirectionPaused      = "paused"
+)
+
+// DirectionStatus is the operational state and the latency percentiles of a swap direction. The reason of a
+// degraded direction is meant for the public, it doesn't reveal the balances or the accounts.
+type DirectionStatus struct {
+	Direction  common.SwapDirection    `json:"direction"`
+	State      string                  `json:"state"`
+	Reason     string                  `json:"reason"`
+	Paused     bool                    `json:"paused"`
+	EtaSeconds int64                   `json:"eta_seconds"`
+	Latency    []model.SwapLatencyStat `json:"latency"`
+	// allowance left to the destination chain within the day, nil if the chain has no withdrawal limit
+	WithdrawalAllowance *WithdrawalAllowance `json:"withdrawal_allowance,omitempty"`
+	// gas price of the destination chain against its ceiling, nil if the chain has no gas guard
+	GasGuard *GasGuardState `json:"gas_guard,omitempty"`
+}
+
+// getDirectionState returns whether the swaps of the direction are filled normally, slowly or not at all
+func (engine *SwapEngine) getDirectionState(direction common.SwapDirection) (string, string) {
+	if engine.IsDirectionPaused(direction) {
+		return DirectionPaused, "swaps are paused by the operators"
+	}
+	sourceChain, destChain := getSourceChain(direction), getDestChain(direction)
+	if engine.isChainDegraded(sourceChain) || engine.isChainDegraded(destChain) {
+		return DirectionDegraded, "a chain of the direction is unreachable, swaps are filled once it is back"
+	}
+	if _, err := engine.heads[sourceChain].Height(); err != nil {
+		return DirectionDegraded, fmt.Sprintf("%s is not synced, new swaps may be observed late", sourceChain)
+	}
+	if _, err := engine.heads[destChain].Height(); err != nil {
+		return DirectionDegraded, fmt.Sprintf("%s is not synced, swaps may be filled late", destChain)
+	}
+	if !engine.hasLiquidity(destChain, big.NewInt(1)) {
+		return DirectionDegraded, fmt.Sprintf("liquidity on %s is being refilled, swaps may be filled late", destChain)
+	}
+	drained := true
+	for _, account := range engine.relayerPools[destChain].GetAccounts() {
+		drained = drained && account.Drained
+	}
+	if drained {
+		return DirectionDegraded, fmt.Sprintf("relayers on %s are being refilled, swaps may be filled late", destChain)
+	}
+	return DirectionOperational, ""
+}
+
+// GetDirectionStatuses returns the status of the directions enabled in the environment
+func (engine *SwapEngine) GetDirectionStatuses() ([]DirectionStatus, error) {
+	statuses := make([]DirectionStatus, 0)
+	for _, route := range swapRoutes {
+		if !engine.profile.IsDirectionEnabled(string(route.Direction)) {
+			continue
+		}
+		stats, err := engine.GetLatencyStats(route.Direction)
+		if err != nil {
+			return nil, fmt.Errorf("query latency stats of %s error: %s", route.Direction, err.Error())
+		}
+		eta, _ := getEta(stats)
+		state, reason := engine.getDirectionState(route.Direction)
+		allowance, err := engine.GetWithdrawalAllowance(route.DestChain)
+		if err != nil {
+			return nil, fmt.Errorf("query withdrawal allowance of %s error: %s", route.DestChain, err.Error())
+		}
+		if state == DirectionOperational && allowance != nil && allowance.Reached {
+			state, reason = DirectionDegraded, fmt.Sprintf("daily withdrawal limit of %s is reached, swaps are filled from the next day", route.DestChain)
+		}
+		gasGuard := engine.GetGasGuardState(route.DestChain)
+		if state == DirectionOperational && gasGuard != nil && gasGuard.Holding {
+			state, reason = DirectionDegraded, fmt.Sprintf("gas price on %s is spiking, swaps are filled once it falls", route.DestChain)
+		}
+		statuses = append(statuses, DirectionStatus{
+			Direction:           route.Direction,
+			State:               state,
+			Reason:              reason,
+			Paused:              state == DirectionPaused,
+			EtaSeconds:          eta,
+			Latency:             stats,
+			WithdrawalAllowance: allowance,
+			GasGuard:            gasGuard,
+		})
+	}
+	return statuses, nil
+}
@@ -0,0 +1,302 @@
+package swap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"occ-swap-server/common"
+	"occ-swap-server/model"
+)
+
+func (engine *SwapEngine) getSwapAgent(chain string) ethcom.Address {
+	switch chain {
+	case common.ChainBSC:
+		return engine.bscSwapAgent
+	case common.ChainMATIC:
+		return engine.maticSwapAgent
+	default:
+		return engine.ethSwapAgent
+	}
+}
+
+// errSwapStartNotFinal is returned by verifySwapStartEvent if the swap start tx doesn't have the confirmations of
+// the source chain, or of the risk class of its pair, yet
+var errSwapStartNotFinal = errors.New("swap start tx is not final")
+
+// verifySwapStartEvent re-fetches the receipt of the swap start tx and checks that it contains a SwapStarted
+// event emitted by the configured swap agent which matches the event log saved by the observer.
+// The receipt must have the confirmations of the source chain, or of the risk class of the pair, by the head of the
+// engine, the confirmed status of the observer isn't trusted alone. It returns a non-empty reject reason if the
+// verification fails, and an error if the receipt can't be fetched or the tx is not final yet.
+func (engine *SwapEngine) verifySwapStartEvent(txEventLog *model.SwapStartTxLog) (string, error) {
+	client := engine.getClient(txEventLog.Chain)
+	receipt, err := client.TransactionReceipt(context.Background(), ethcom.HexToHash(txEventLog.TxHash))
+	if err != nil {
+		return "", err
+	}
+	if receipt.Status == TxFailedStatus {
+		return fmt.Sprintf("swap start tx %s is failed", txEventLog.TxHash), nil
+	}
+	height, err := engine.heads[txEventLog.Chain].Height()
+	if err != nil {
+		return "", err
+	}
+	confirmNum := engine.getStartConfirmNum(txEventLog)
+	if confirmations := height - receipt.BlockNumber.Int64() + 1; confirmations < confirmNum {
+		return "", fmt.Errorf("%w, tx hash %s has %d of %d confirmations", errSwapStartNotFinal, txEventLog.TxHash,
+			confirmations, confirmNum)
+	}
+
+	if rejectReason, err := engine.verifySwapStartHeader(txEventLog.Chain, receipt); rejectReason != "" || err != nil {
+		return rejectReason, err
+	}
+	if rejectReason, err := engine.verifySwapStartQuorum(txEventLog.Chain, receipt); rejectReason != "" || err != nil {
+		return rejectReason, err
+	}
+
+	swapAgent := engine.getSwapAgent(txEventLog.Chain)
+	decoder := engine.eventDecoders[txEventLog.Chain]
+	for _, log := range receipt.Logs {
+		if log.Address != swapAgent || log.BlockHash.Hex() != txEventLog.BlockHash {
+			continue
+		}
+		if txEventLog.TokenId != "" {
+			event, err := decoder.DecodeSwapNFTStarted(log)
+			if err != nil {
+				continue
+			}
+			if event.ToChainId.String() != txEventLog.ToChainId ||
+				event.FromAddress.String() != txEventLog.FromAddress ||
+				event.Collection.String() != txEventLog.TokenAddr ||
+				event.TokenId.String() != txEventLog.TokenId {
+				continue
+			}
+			return "", nil
+		}
+		event, err := decoder.DecodeSwapStarted(log)
+		if err != nil {
+			continue
+		}
+		if event.ToChainId.String() != txEventLog.ToChainId ||
+			event.FromAddress.String() != txEventLog.FromAddress ||
+			event.Amount.String() != txEventLog.Amount ||
+			(event.Token != (ethcom.Address{}) && event.Token.String() != txEventLog.TokenAddr) ||
+			model.OptionalAddress(event.Recipient) != txEventLog.Recipient {
+			continue
+		}
+		return "", nil
+	}
+	return fmt.Sprintf("no matching SwapStarted event emitted by swap agent %s is found in tx %s", swapAgent.String(), txEventLog.TxHash), nil
+}
+
+// verifySwapStartHeader checks the receipt of the swap start tx returned by the rpc against the header cache
+// synced from the quorum of the header providers, so a compromised rpc can't forge the event unless the quorum of
+// the providers is compromised too. The block of the receipt must be the block of the quorum at its height, the tx
+// must be at its index in the transactions of the block and the receipt must be proven against the receipts root
+// of the header. The chains whose headers don't commit to the receipts, e.g. cronos, have the receipt agreed by the
+// quorum of the providers instead. The chains without header verification are not checked.
+func (engine *SwapEngine) verifySwapStartHeader(chain string, receipt *types.Receipt) (string, error) {
+	headers, ok := engine.headerCaches[chain]
+	if !ok {
+		return "", nil
+	}
+	header, err := headers.Header(receipt.BlockNumber.Int64())
+	if errors.Is(err, errHeaderNotSynced) {
+		return "", fmt.Errorf("%w, %s", errSwapStartNotFinal, err.Error())
+	}
+	if err != nil {
+		return "", err
+	}
+	txHash := receipt.TxHash.String()
+	if header.Hash != receipt.BlockHash {
+		return fmt.Sprintf("block %s of swap start tx %s is not the block %s at height %d agreed by the quorum of the header providers",
+			receipt.BlockHash.String(), txHash, header.Hash.String(), header.Height()), nil
+	}
+
+	if header.ReceiptHash == types.EmptyRootHash {
+		verified, err := headers.quorumReceipt(receipt.TxHash)
+		if err != nil {
+			return "", err
+		}
+		expected, err := receiptVote(verified)
+		if err != nil {
+			return "", err
+		}
+		if vote, err := receiptVote(receipt); err != nil || vote != expected {
+			return fmt.Sprintf("receipt of swap start tx %s doesn't match the receipt agreed by the header providers", txHash), nil
+		}
+		return "", nil
+	}
+
+	client := engine.getClient(chain)
+	block, err := client.BlockByHash(context.Background(), receipt.BlockHash)
+	if err != nil {
+		return "", err
+	}
+	txs := block.Transactions()
+	if root := types.DeriveSha(txs); root != header.TxHash {
+		return fmt.Sprintf("transactions of block %s of swap start tx %s don't match the transactions root %s agreed by the quorum of the header providers",
+			receipt.BlockHash.String(), txHash, header.TxHash.String()), nil
+	}
+	if receipt.TransactionIndex >= uint(len(txs)) || txs[receipt.TransactionIndex].Hash() != receipt.TxHash {
+		return fmt.Sprintf("swap start tx %s is not at index %d of block %s", txHash, receipt.TransactionIndex,
+			receipt.BlockHash.String()), nil
+	}
+
+	txHashes := make([]ethcom.Hash, 0, len(txs))
+	for _, tx := range txs {
+		txHashes = append(txHashes, tx.Hash())
+	}
+	receipts, errs := getTransactionReceipts(client, txHashes)
+	for _, err := range errs {
+		if err != nil {
+			return "", err
+		}
+	}
+	if _, err := proveReceipt(types.Receipts(receipts), receipt.TransactionIndex, header.ReceiptHash); err != nil {
+		return fmt.Sprintf("receipts of block %s of swap start tx %s can't be proven against the receipts root %s agreed by the quorum of the header providers: %s",
+			receipt.BlockHash.String(), txHash, header.ReceiptHash.String(), err.Error()), nil
+	}
+	// the logs matched against the event log are the ones of the proven receipt
+	if !bytes.Equal(types.Receipts(receipts).GetRlp(int(receipt.TransactionIndex)), types.Receipts{receipt}.GetRlp(0)) {
+		return fmt.Sprintf("receipt of swap start tx %s is not the receipt proven at index %d of block %s", txHash,
+			receipt.TransactionIndex, receipt.BlockHash.String()), nil
+	}
+	return "", nil
+}
+
+// verifySwapStartQuorum checks the receipt of the swap start tx returned by the rpc is the receipt the quorum of the
+// independent rpc providers of the chain return, the swap isn't confirmed until the quorum is reached. The chains
+// without the rpc quorum are not checked.
+func (engine *SwapEngine) verifySwapStartQuorum(chain string, receipt *types.Receipt) (string, error) {
+	quorum, ok := engine.rpcQuorums[chain]
+	if !ok {
+		return "", nil
+	}
+	txHash := receipt.TxHash.String()
+	confirmed, err := quorum.Receipt(receipt.TxHash)
+	if err != nil {
+		return "", err
+	}
+	if confirmed == nil {
+		return fmt.Sprintf("swap start tx %s is not found by the quorum of the rpc providers of %s", txHash, chain), nil
+	}
+	expected, err := receiptVote(confirmed)
+	if err != nil {
+		return "", err
+	}
+	if vote, err := receiptVote(receipt); err != nil || vote != expected {
+		return fmt.Sprintf("receipt of swap start tx %s doesn't match the receipt in block %s confirmed by the quorum of the rpc providers of %s",
+			txHash, confirmed.BlockHash.String(), chain), nil
+	}
+	return "", nil
+}
+
+// verifySwapFilledEvent checks that the receipt of a successful fill tx contains a SwapFilled event emitted by
+// the swap agent of the destination chain whose recipient and amount match the swap record. The fill tx must be
+// the one recorded for the swap (or retry swap) record, and the start tx hash of the event must be the one of the
+// swap if the abi version of the swap agent emits it. It returns a non-empty mismatch reason if the verification
+// fails.
+func (engine *SwapEngine) verifySwapFilledEvent(receipt *types.Receipt, swap *model.Swap, recordedFillTxHash string) string {
+	fillTxHash := receipt.TxHash.String()
+	if fillTxHash != recordedFillTxHash {
+		return fmt.Sprintf("fill tx %s is not the fill tx %s recorded for swap %s", fillTxHash, recordedFillTxHash, swap.StartTxHash)
+	}
+
+	destChain := getDestChain(swap.Direction)
+	swapAgent := engine.getSwapAgent(destChain)
+	decoder := engine.eventDecoders[destChain]
+	if isNFTSwap(swap.AssetType) {
+		return engine.verifySwapNFTFilledEvent(receipt, swap, destChain)
+	}
+	for _, log := range receipt.Logs {
+		if log.Address != swapAgent {
+			continue
+		}
+		event, err := decoder.DecodeSwapFilled(log)
+		if err != nil {
+			continue
+		}
+		if !engine.isFillOfSwapChains(event.FromChainId, event.ToChainId, swap) {
+			return fmt.Sprintf("SwapFilled event mismatch in fill tx %s, from chain id %s, to chain id %s, expected from chain id %d, to chain id %s",
+				fillTxHash, formatChainId(event.FromChainId), formatChainId(event.ToChainId), engine.getChainID(getSourceChain(swap.Direction)), swap.ToChainId)
+		}
+		if event.StartTxHash != (ethcom.Hash{}) && event.StartTxHash != ethcom.HexToHash(swap.StartTxHash) {
+			return fmt.Sprintf("SwapFilled event mismatch in fill tx %s, start tx hash %s, expected start tx hash %s",
+				fillTxHash, event.StartTxHash.String(), swap.StartTxHash)
+		}
+		recipient := event.ToAddress
+		amount := event.Amount.String()
+		if recipient != ethcom.HexToAddress(swap.GetRecipient()) || amount != swap.Amount {
+			return fmt.Sprintf("SwapFilled event mismatch in fill tx %s, recipient %s, amount %s, expected recipient %s, amount %s",
+				fillTxHash, recipient.String(), amount, swap.GetRecipient(), swap.Amount)
+		}
+		return ""
+	}
+	return fmt.Sprintf("no SwapFilled event emitted by swap agent %s is found in fill tx %s", swapAgent.String(), fillTxHash)
+}
+
+// verifySwapNFTFilledEvent checks the SwapNFTFilled event of the fill tx of an erc721 swap, the token id and the
+// collection of the destination chain must match the swap record
+func (engine *SwapEngine) verifySwapNFTFilledEvent(receipt *types.Receipt, swap *model.Swap, destChain string) string {
+	fillTxHash := receipt.TxHash.String()
+	swapAgent := engine.getSwapAgent(destChain)
+	collection := getNFTCollection(destChain, swap.BEP20Addr, swap.ERC20Addr)
+	for _, log := range receipt.Logs {
+		if log.Address != swapAgent {
+			continue
+		}
+		event, err := engine.eventDecoders[destChain].DecodeSwapNFTFilled(log)
+		if err != nil {
+			continue
+		}
+		if !engine.isFillOfSwapChains(event.FromChainId, event.ToChainId, swap) {
+			return fmt.Sprintf("SwapNFTFilled event mismatch in fill tx %s, from chain id %s, to chain id %s, expected from chain id %d, to chain id %s",
+				fillTxHash, formatChainId(event.FromChainId), formatChainId(event.ToChainId), engine.getChainID(getSourceChain(swap.Direction)), swap.ToChainId)
+		}
+		if event.ToAddress != ethcom.HexToAddress(swap.GetRecipient()) || event.Collection != collection || event.TokenId.String() != swap.TokenId {
+			return fmt.Sprintf("SwapNFTFilled event mismatch in fill tx %s, recipient %s, collection %s, token id %s, expected recipient %s, collection %s, token id %s",
+				fillTxHash, event.ToAddress.String(), event.Collection.String(), event.TokenId.String(), swap.GetRecipient(), collection.String(), swap.TokenId)
+		}
+		return ""
+	}
+	return fmt.Sprintf("no SwapNFTFilled event emitted by swap agent %s is found in fill tx %s", swapAgent.String(), fillTxHash)
+}
+
+// isFillOfSwapChains returns whether the chain ids of a fill event are the ones of the swap, the fill txs of the
+// engine are sent with a 0 fromChainId
+func (engine *SwapEngine) isFillOfSwapChains(fromChainId, toChainId *big.Int, swap *model.Swap) bool {
+	if toChainId == nil || toChainId.String() != swap.ToChainId {
+		return false
+	}
+	return fromChainId == nil || fromChainId.Sign() == 0 || fromChainId.Int64() == engine.getChainID(getSourceChain(swap.Direction))
+}
+
+func formatChainId(chainId *big.Int) string {
+	if chainId == nil {
+		return "none"
+	}
+	return chainId.String()
+}
+
+// getStartConfirmNum returns the confirmations the swap start tx waits for on the source chain by the risk class of
+// the pair of its token
+func (engine *SwapEngine) getStartConfirmNum(txEventLog *model.SwapStartTxLog) int64 {
+	chainConfirmNum := engine.config.ChainConfig.GetConfirmNum(txEventLog.Chain)
+	if txEventLog.TokenAddr == "" {
+		return chainConfirmNum
+	}
+	erc20Addr := ethcom.HexToAddress(txEventLog.TokenAddr)
+	if txEventLog.Chain == common.ChainBSC {
+		engine.mutex.RLock()
+		erc20Addr = engine.bep20ToERC20[erc20Addr]
+		engine.mutex.RUnlock()
+	}
+	return engine.config.RiskClassConfig.GetConfirmNum(erc20Addr.String(), txEventLog.Chain, chainConfirmNum)
+}
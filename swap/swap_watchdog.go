@@ -0,0 +1,276 @@
+package swap
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"occ-swap-server/common"
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+const (
+	StuckSending         = "sending"          // swap is in SwapSending for longer than the timeout
+	StuckUnacked         = "unacked_request"  // swap start tx log is in ConfirmRequest but never acked
+	StuckUnbroadcastFill = "unbroadcast_fill" // fill tx is in FillTxCreated but never marked as sent
+)
+
+// stuckSwap is a swap lingering in an intermediate state which the watchdog can't heal
+type stuckSwap struct {
+	Kind        string
+	Chain       string
+	Direction   common.SwapDirection
+	StartTxHash string
+	FillTxHash  string
+	Status      string
+	Sponsor     string
+	Amount      string
+	Since       time.Time
+	Detail      string
+}
+
+func (s stuckSwap) key() string {
+	return fmt.Sprintf("%s#%s#%s", s.Kind, s.StartTxHash, s.FillTxHash)
+}
+
+func (s stuckSwap) String() string {
+	fields := []string{
+		fmt.Sprintf("kind=%s", s.Kind),
+		fmt.Sprintf("start_tx_hash=%s", s.StartTxHash),
+		fmt.Sprintf("status=%s", s.Status),
+		fmt.Sprintf("since=%s", s.Since.UTC().Format(time.RFC3339)),
+	}
+	for _, field := range []struct{ name, value string }{
+		{"chain", s.Chain},
+		{"direction", string(s.Direction)},
+		{"fill_tx_hash", s.FillTxHash},
+		{"sponsor", s.Sponsor},
+		{"amount", s.Amount},
+		{"detail", s.Detail},
+	} {
+		if field.value != "" {
+			fields = append(fields, fmt.Sprintf("%s=%s", field.name, field.value))
+		}
+	}
+	return strings.Join(fields, ", ")
+}
+
+// claimSwap marks the swap as being handled by the fill daemons or the watchdog, it returns false if the swap
+// is already claimed
+func (engine *SwapEngine) claimSwap(id uint) bool {
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+
+	if engine.claimedSwaps[id] {
+		return false
+	}
+	engine.claimedSwaps[id] = true
+	return true
+}
+
+func (engine *SwapEngine) releaseSwap(id uint) {
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+
+	delete(engine.claimedSwaps, id)
+}
+
+// skipPanickedSwap is deferred by the fill of the swap, if the fill panics the swap is skipped by the fill daemons
+// from then on, so a malformed row doesn't block the swaps behind it. The status of the swap is kept as its fill tx
+// may be sent already, the swap is healed by the admin and filled again after a restart. The panic is passed on to
+// the scheduler, which alerts it with the stack.
+func (engine *SwapEngine) skipPanickedSwap(swap model.Swap) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	engine.mutex.Lock()
+	engine.panickedSwaps[swap.ID] = true
+	engine.mutex.Unlock()
+	util.Logger.Errorf("fill of swap %d panicked, skip it until the restart, start tx hash %s", swap.ID, swap.StartTxHash)
+	util.SendTelegramMessage(fmt.Sprintf("Urgent alert: fill of swap %d panicked, it is skipped until the restart, start tx hash %s, status %s",
+		swap.ID, swap.StartTxHash, swap.Status))
+	panic(r)
+}
+
+func (engine *SwapEngine) getPanickedSwaps() []uint {
+	engine.mutex.RLock()
+	defer engine.mutex.RUnlock()
+
+	ids := make([]uint, 0, len(engine.panickedSwaps))
+	for id := range engine.panickedSwaps {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// watchdogDaemon detects the swaps lingering in intermediate states. The swaps which can be healed by the
+// transitions of the fill daemon are healed, the others are alerted once until they leave the state.
+func (engine *SwapEngine) watchdogDaemon() func() error {
+	alerted := make(map[string]bool)
+	return func() error {
+		deadline := time.Now().Add(-engine.config.ChainConfig.GetStuckSwapTimeout())
+		stuckSwaps := make([]stuckSwap, 0)
+		stuckSwaps = append(stuckSwaps, engine.checkSendingSwaps(deadline)...)
+		stuckSwaps = append(stuckSwaps, engine.checkUnackedRequests(deadline)...)
+		stuckSwaps = append(stuckSwaps, engine.checkUnbroadcastFillTxs(deadline)...)
+
+		counts := map[string]int{StuckSending: 0, StuckUnacked: 0, StuckUnbroadcastFill: 0}
+		stuck := make(map[string]bool, len(stuckSwaps))
+		for _, s := range stuckSwaps {
+			counts[s.Kind]++
+			stuck[s.key()] = true
+			if alerted[s.key()] {
+				continue
+			}
+			util.Logger.Errorf("stuck swap, %s", s.String())
+			util.SendTelegramMessage(fmt.Sprintf("Urgent alert: stuck swap, %s", s.String()))
+		}
+		alerted = stuck
+		for kind, count := range counts {
+			stuckSwapsGauge.WithLabelValues(kind).Set(float64(count))
+		}
+		return nil
+	}
+}
+
+// checkSendingSwaps heals the swaps in SwapSending which are not being filled, the same way as the fill daemon
+// does after a restart: swaps without fill tx are confirmed again, swaps with a created fill tx are marked as sent
+// and left to the tx tracking daemons. The swaps which are still being filled are reported.
+func (engine *SwapEngine) checkSendingSwaps(deadline time.Time) []stuckSwap {
+	swaps, err := engine.store.FindSwaps(SwapQuery{Statuses: []common.SwapStatus{SwapSending}, UpdatedBefore: deadline, Limit: BatchSize})
+	if err != nil {
+		util.Logger.Errorf("query sending swaps error: %s", err.Error())
+		return make([]stuckSwap, 0)
+	}
+
+	stuckSwaps := make([]stuckSwap, 0)
+	for _, swap := range swaps {
+		if !engine.claimSwap(swap.ID) {
+			stuckSwaps = append(stuckSwaps, stuckSwap{
+				Kind:        StuckSending,
+				Chain:       getDestChain(swap.Direction),
+				Direction:   swap.Direction,
+				StartTxHash: swap.StartTxHash,
+				Status:      string(swap.Status),
+				Sponsor:     swap.Sponsor,
+				Amount:      swap.Amount,
+				Since:       swap.UpdatedAt,
+				Detail:      "the fill tx is still being broadcast",
+			})
+			continue
+		}
+		if err := engine.healSendingSwap(swap.ID); err != nil {
+			util.Logger.Errorf("heal sending swap error, start tx hash %s, err: %s", swap.StartTxHash, err.Error())
+			util.SendTelegramMessage(fmt.Sprintf("heal sending swap error, start tx hash %s, err: %s", swap.StartTxHash, err.Error()))
+		}
+		engine.releaseSwap(swap.ID)
+	}
+	return stuckSwaps
+}
+
+func (engine *SwapEngine) healSendingSwap(id uint) error {
+	return engine.store.Transaction(func(tx SwapStore) error {
+		swap, err := tx.GetSwap(id)
+		if err != nil {
+			return err
+		}
+		if swap.Status != SwapSending {
+			return nil
+		}
+		if !engine.verifySwap(swap) {
+			return fmt.Errorf("verify hmac of swap failed: %s", swap.StartTxHash)
+		}
+
+		swapTx, err := tx.GetLastFillTxOfSwap(swap.ID, model.FillTxCreated)
+		if err != nil {
+			return err
+		}
+		if swapTx == nil || swapTx.FillSwapTxHash == "" {
+			util.Logger.Infof("watchdog: no fill tx of the sending swap, confirm it again, start tx hash %s", swap.StartTxHash)
+			swap.Status = SwapConfirmed
+		} else {
+			util.Logger.Infof("watchdog: fill tx of the sending swap is created, mark it as sent, start tx hash %s, fill tx hash %s",
+				swap.StartTxHash, swapTx.FillSwapTxHash)
+			if err := tx.SetFillTxStatus(swapTx.ID, model.FillTxSent); err != nil {
+				return err
+			}
+			swap.Status = SwapSent
+			swap.FillTxHash = swapTx.FillSwapTxHash
+		}
+		return engine.updateSwap(tx, swap)
+	})
+}
+
+// checkUnackedRequests reports the swap start tx logs which are not acked by the confirm daemon, they are either
+// not confirmed by the observer or can't be verified
+func (engine *SwapEngine) checkUnackedRequests(deadline time.Time) []stuckSwap {
+	txEventLogs, err := engine.store.FindStartTxLogsUpdatedBefore(model.ConfirmRequest, deadline, BatchSize)
+	if err != nil {
+		util.Logger.Errorf("query unacked swap start tx logs error: %s", err.Error())
+		return make([]stuckSwap, 0)
+	}
+
+	stuckSwaps := make([]stuckSwap, 0, len(txEventLogs))
+	for _, txEventLog := range txEventLogs {
+		status := "unconfirmed"
+		if txEventLog.Status == model.TxStatusConfirmed {
+			status = "confirmed"
+		}
+		stuckSwaps = append(stuckSwaps, stuckSwap{
+			Kind:        StuckUnacked,
+			Chain:       txEventLog.Chain,
+			StartTxHash: txEventLog.TxHash,
+			Status:      status,
+			Sponsor:     txEventLog.FromAddress,
+			Amount:      txEventLog.Amount,
+			Since:       time.Unix(txEventLog.UpdateTime, 0),
+			Detail:      fmt.Sprintf("height %d, confirmed num %d", txEventLog.Height, txEventLog.ConfirmedNum),
+		})
+	}
+	return stuckSwaps
+}
+
+// checkUnbroadcastFillTxs marks the created fill txs as sent if their swaps are sent by them, the others are
+// reported. The fill txs of the sending swaps are left to checkSendingSwaps.
+func (engine *SwapEngine) checkUnbroadcastFillTxs(deadline time.Time) []stuckSwap {
+	swapTxs, err := engine.store.FindFillTxsCreatedBefore(model.FillTxCreated, deadline, BatchSize)
+	if err != nil {
+		util.Logger.Errorf("query unbroadcast fill txs error: %s", err.Error())
+		return make([]stuckSwap, 0)
+	}
+
+	stuckSwaps := make([]stuckSwap, 0)
+	for _, swapTx := range swapTxs {
+		swap, err := engine.store.GetSwap(swapTx.SwapID)
+		if err != nil {
+			util.Logger.Errorf("query swap of fill tx %s error: %s", swapTx.FillSwapTxHash, err.Error())
+			continue
+		}
+		if swap.Status == SwapSending {
+			continue
+		}
+		if swap.Status == SwapSent && swap.FillTxHash == swapTx.FillSwapTxHash {
+			util.Logger.Infof("watchdog: swap is sent by the created fill tx, mark it as sent, start tx hash %s, fill tx hash %s",
+				swap.StartTxHash, swapTx.FillSwapTxHash)
+			if _, err := engine.store.MoveFillTxStatus(swapTx.ID, model.FillTxCreated, model.FillTxSent); err != nil {
+				util.Logger.Errorf("mark fill tx %s as sent error: %s", swapTx.FillSwapTxHash, err.Error())
+			}
+			continue
+		}
+		stuckSwaps = append(stuckSwaps, stuckSwap{
+			Kind:        StuckUnbroadcastFill,
+			Chain:       getDestChain(swap.Direction),
+			Direction:   swap.Direction,
+			StartTxHash: swap.StartTxHash,
+			FillTxHash:  swapTx.FillSwapTxHash,
+			Status:      string(swap.Status),
+			Sponsor:     swap.Sponsor,
+			Amount:      swap.Amount,
+			Since:       swapTx.CreatedAt,
+			Detail:      fmt.Sprintf("gas price %s", swapTx.GasPrice),
+		})
+	}
+	return stuckSwaps
+}
@@ -0,0 +1,189 @@
+package swap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+
+	"occ-swap-server/common"
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+const (
+	WebhookSignatureHeader = "X-Swap-Signature"
+
+	// MaxWebhookErrorLength is the max length of the response body kept in the delivery log
+	MaxWebhookErrorLength = 256
+)
+
+// webhookStatuses are the swap statuses the webhooks are called for
+var webhookStatuses = []common.SwapStatus{SwapSuccess, SwapSendFailed, SwapExpired}
+
+// WebhookPayload is the json body posted to the webhooks, it is signed with the hmac of the webhook secret
+type WebhookPayload struct {
+	DeliveryID uint                 `json:"delivery_id"`
+	Event      string               `json:"event"`
+	SwapID     uint                 `json:"swap_id"`
+	Status     common.SwapStatus    `json:"status"`
+	Direction  common.SwapDirection `json:"direction"`
+	Sponsor    string               `json:"sponsor"`
+	Recipient  string               `json:"recipient"`
+	Memo       string               `json:"memo"`
+	ToChainId  string               `json:"to_chain_id"`
+	Amount     string               `json:"amount"`
+	// the amount in the unit of the token
+	AmountDecimal string `json:"amount_decimal"`
+	Decimals      int    `json:"decimals"`
+	StartTxHash   string `json:"start_tx_hash"`
+	FillTxHash    string `json:"fill_tx_hash"`
+	// explorer links of the txs, empty if the explorer is unknown
+	StartTxUrl string `json:"start_tx_url"`
+	FillTxUrl  string `json:"fill_tx_url"`
+	Log        string `json:"log"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
+func isWebhookStatus(status common.SwapStatus) bool {
+	for _, s := range webhookStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// queueWebhookDeliveries queues the calls of the webhooks matching the swap in the tx which updates the swap
+func (engine *SwapEngine) queueWebhookDeliveries(tx SwapStore, swap *model.Swap) error {
+	webhooks, err := tx.FindWebhooks([]string{"", swap.Sponsor})
+	if err != nil {
+		return err
+	}
+	for _, webhook := range webhooks {
+		if webhook.ApiKey != "" {
+			// the webhooks of the scoped api keys are only called for the swaps of the owned pairs
+			if owner, _ := tx.IsPairOwner(webhook.ApiKey, ethcom.HexToAddress(swap.ERC20Addr).String()); !owner {
+				continue
+			}
+		}
+
+		delivery := model.WebhookDelivery{
+			WebhookID:   webhook.ID,
+			SwapID:      swap.ID,
+			StartTxHash: swap.StartTxHash,
+			Event:       fmt.Sprintf("swap.%s", swap.Status),
+			Status:      model.WebhookDeliveryPending,
+		}
+		if err := tx.CreateWebhookDelivery(&delivery); err != nil {
+			return err
+		}
+		startTxUrl, fillTxUrl := engine.SwapExplorerLinks(swap)
+		payload, err := json.Marshal(WebhookPayload{
+			DeliveryID:    delivery.ID,
+			Event:         delivery.Event,
+			SwapID:        swap.ID,
+			Status:        swap.Status,
+			Direction:     swap.Direction,
+			Sponsor:       swap.Sponsor,
+			Recipient:     swap.GetRecipient(),
+			Memo:          swap.Memo,
+			ToChainId:     swap.ToChainId,
+			Amount:        swap.Amount,
+			AmountDecimal: swap.AmountDecimal,
+			Decimals:      swap.Decimals,
+			StartTxHash:   swap.StartTxHash,
+			FillTxHash:    swap.FillTxHash,
+			StartTxUrl:    startTxUrl,
+			FillTxUrl:     fillTxUrl,
+			Log:           swap.Log,
+			Timestamp:     time.Now().Unix(),
+		})
+		if err != nil {
+			return err
+		}
+		if err := tx.SetWebhookDeliveryPayload(delivery.ID, string(payload)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (engine *SwapEngine) webhookDeliveryDaemon() func() error {
+	client := engine.config.WebhookConfig.NewHttpClient()
+	return func() error {
+		deliveries, err := engine.store.FindDueWebhookDeliveries(time.Now().Unix(), BatchSize)
+		if err != nil {
+			return err
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+
+		for _, delivery := range deliveries {
+			engine.deliverWebhook(client, &delivery)
+		}
+		return util.RunAgain
+	}
+}
+
+func (engine *SwapEngine) deliverWebhook(client *http.Client, delivery *model.WebhookDelivery) {
+	webhook, err := engine.store.GetWebhook(delivery.WebhookID)
+	if err != nil {
+		delivery.Status = model.WebhookDeliveryFailed
+		delivery.LastError = fmt.Sprintf("query webhook error: %s", err.Error())
+		engine.store.SaveWebhookDelivery(delivery)
+		return
+	}
+
+	responseCode, callErr := callWebhook(client, webhook, []byte(delivery.Payload))
+	attempts := delivery.Attempts
+	delivery.Attempts++
+	delivery.ResponseCode = responseCode
+	delivery.LastError = ""
+	if callErr == nil {
+		delivery.Status = model.WebhookDeliverySuccess
+	} else {
+		delivery.LastError = callErr.Error()
+		policy := engine.config.WebhookConfig.GetRetryPolicy()
+		if delivery.Attempts >= policy.MaxAttempts {
+			util.Logger.Errorf("call webhook %d failed after %d attempts, give up, start tx hash %s, err: %s",
+				webhook.ID, delivery.Attempts, delivery.StartTxHash, callErr.Error())
+			delivery.Status = model.WebhookDeliveryFailed
+		} else {
+			util.Logger.Infof("call webhook %d failed, retry it later, start tx hash %s, err: %s",
+				webhook.ID, delivery.StartTxHash, callErr.Error())
+			delivery.NextAttemptAt = time.Now().Add(policy.GetRetryBackoff(attempts)).Unix()
+		}
+	}
+	if err := engine.store.SaveWebhookDelivery(delivery); err != nil {
+		util.Logger.Errorf("update webhook delivery %d error: %s", delivery.ID, err.Error())
+	}
+}
+
+// callWebhook posts the payload to the webhook, the call succeeds if the response status is 2xx
+func callWebhook(client *http.Client, webhook *model.Webhook, payload []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, webhook.Url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookSignatureHeader, util.NewHmacSigner("", webhook.Secret).Sign(payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, MaxWebhookErrorLength))
+		return resp.StatusCode, fmt.Errorf("response status %d: %s", resp.StatusCode, string(body))
+	}
+	return resp.StatusCode, nil
+}
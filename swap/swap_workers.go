@@ -0,0 +1,90 @@
+package swap
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"occ-swap-server/model"
+	"occ-swap-server/util"
+)
+
+// swapWorkerPool fills the swaps to the destination chain with a pool of workers, it starts the workers and returns
+// the run of the daemon dispatching the swaps to them. The swaps of a sponsor are always filled by the same worker,
+// in order. The fill txs of all the workers are signed by the broadcaster of the chain, which assigns the nonces of
+// the relayer serially, so the workers never compete for a nonce.
+func (engine *SwapEngine) swapWorkerPool(destChain string, workers int) func() error {
+	util.Logger.Infof("start %d swap workers, destination chain %s", workers, destChain)
+
+	var mutex sync.Mutex
+	// the swaps queued or being filled, key is the swap id
+	dispatched := make(map[uint]bool)
+
+	queues := make([]chan model.Swap, workers)
+	for i := range queues {
+		queues[i] = make(chan model.Swap, BatchSize)
+		queue := queues[i]
+		engine.scheduler.Go(util.Daemon{
+			Name:     fmt.Sprintf("swap_%s_worker_%d", destChain, i),
+			Interval: engine.config.ChainConfig.GetWaitBetweenSwaps(destChain),
+			Run: func() error {
+				swap := <-queue
+				// the swap is dispatched again if its fill panics
+				defer func() {
+					mutex.Lock()
+					delete(dispatched, swap.ID)
+					mutex.Unlock()
+				}()
+				engine.fillQueuedSwap(destChain, swap)
+				return nil
+			},
+		})
+	}
+
+	return func() error {
+		queued := 0
+		for _, swap := range engine.getFillableSwaps(destChain) {
+			mutex.Lock()
+			isDispatched := dispatched[swap.ID]
+			dispatched[swap.ID] = true
+			mutex.Unlock()
+			if isDispatched {
+				continue
+			}
+			queues[getSwapWorker(swap.Sponsor, workers)] <- swap
+			queued++
+		}
+		if queued == 0 {
+			return nil
+		}
+		return util.RunAgain
+	}
+}
+
+// fillQueuedSwap fills the swap if it is still fillable. The swap may be queried by the dispatcher before the
+// previous fill of it is written to db, so it is read again here, it may also be claimed by another executor replica
+// if its claim expired in the queue.
+func (engine *SwapEngine) fillQueuedSwap(destChain string, queuedSwap model.Swap) {
+	swap, err := engine.store.GetSwap(queuedSwap.ID)
+	if err != nil {
+		util.Logger.Errorf("query swap %d error: %s", queuedSwap.ID, err.Error())
+		return
+	}
+	if (swap.Status != SwapConfirmed && swap.Status != SwapSending) || engine.IsDirectionPaused(swap.Direction) {
+		util.Logger.Debugf("swap is not fillable any more, start tx hash %s, status %s", swap.StartTxHash, swap.Status)
+		return
+	}
+	if swap.ClaimedBy != engine.instanceID && swap.ClaimedUntil >= time.Now().Unix() {
+		util.Logger.Debugf("swap is claimed by executor %s, start tx hash %s", swap.ClaimedBy, swap.StartTxHash)
+		return
+	}
+	engine.fillSwapInstance(destChain, *swap)
+}
+
+func getSwapWorker(sponsor string, workers int) int {
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(sponsor)))
+	return int(h.Sum32() % uint32(workers))
+}
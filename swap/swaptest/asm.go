@@ -0,0 +1,101 @@
+package swaptest
+
+import (
+	"encoding/binary"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// assembler builds the bytecode of the mock contracts, jump targets are referenced by label and patched when the
+// code is built
+type assembler struct {
+	code   []byte
+	labels map[string]int
+	// key is the offset of the PUSH2 operand to patch with the position of the label
+	refs map[int]string
+}
+
+func newAssembler() *assembler {
+	return &assembler{
+		labels: make(map[string]int),
+		refs:   make(map[int]string),
+	}
+}
+
+func (a *assembler) op(ops ...vm.OpCode) *assembler {
+	for _, op := range ops {
+		a.code = append(a.code, byte(op))
+	}
+	return a
+}
+
+// push pushes the value with the shortest PUSH opcode
+func (a *assembler) push(value []byte) *assembler {
+	if len(value) == 0 {
+		value = []byte{0}
+	}
+	a.code = append(a.code, byte(vm.PUSH1)+byte(len(value)-1))
+	a.code = append(a.code, value...)
+	return a
+}
+
+func (a *assembler) pushInt(value uint64) *assembler {
+	return a.push(big.NewInt(0).SetUint64(value).Bytes())
+}
+
+// jump jumps to the label with JUMP or JUMPI
+func (a *assembler) jump(op vm.OpCode, label string) *assembler {
+	a.code = append(a.code, byte(vm.PUSH2))
+	a.refs[len(a.code)] = label
+	a.code = append(a.code, 0, 0)
+	return a.op(op)
+}
+
+func (a *assembler) label(name string) *assembler {
+	a.labels[name] = len(a.code)
+	return a.op(vm.JUMPDEST)
+}
+
+// dispatch jumps to the label of the function selected by the calldata and reverts if none is matched, the labels
+// are named after the function signatures
+func (a *assembler) dispatch(signatures ...string) *assembler {
+	a.pushInt(0).op(vm.CALLDATALOAD).pushInt(0xe0).op(vm.SHR)
+	for _, signature := range signatures {
+		a.op(vm.DUP1).push(crypto.Keccak256([]byte(signature))[:4]).op(vm.EQ).jump(vm.JUMPI, signature)
+	}
+	return a.pushInt(0).op(vm.DUP1, vm.REVERT)
+}
+
+// returnWord returns the word on top of the stack
+func (a *assembler) returnWord() *assembler {
+	return a.pushInt(0).op(vm.MSTORE).pushInt(32).pushInt(0).op(vm.RETURN)
+}
+
+// arg pushes the n-th static argument of the calldata
+func (a *assembler) arg(n uint64) *assembler {
+	return a.pushInt(4 + 32*n).op(vm.CALLDATALOAD)
+}
+
+func (a *assembler) bytes() []byte {
+	code := make([]byte, len(a.code))
+	copy(code, a.code)
+	for offset, label := range a.refs {
+		position, ok := a.labels[label]
+		if !ok {
+			panic("unknown label " + label)
+		}
+		binary.BigEndian.PutUint16(code[offset:], uint16(position))
+	}
+	return code
+}
+
+// deployCode returns the creation code which deploys the runtime code
+func deployCode(runtime []byte) []byte {
+	a := newAssembler()
+	// the 13 bytes constructor copies the runtime code after it to the memory and returns it
+	a.code = append(a.code, byte(vm.PUSH2), byte(len(runtime)>>8), byte(len(runtime)))
+	a.op(vm.DUP1).push([]byte{0, 13}).pushInt(0).op(vm.CODECOPY).pushInt(0).op(vm.RETURN)
+	return append(a.bytes(), runtime...)
+}
@@ -0,0 +1,91 @@
+package swaptest
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	sabi "occ-swap-server/abi"
+)
+
+const (
+	swapSignature           = "swap(uint256,uint256,uint256)"
+	fillSwapSignature       = "fillSwap(uint256,uint256,address,uint256)"
+	tokenAddressesSignature = "tokenAddresses(uint256)"
+	setTokenSignature       = "setToken(uint256,address)"
+
+	balanceOfSignature  = "balanceOf(address)"
+	setBalanceSignature = "setBalance(address,uint256)"
+)
+
+// mockSwapAgentCode returns the runtime code of a swap agent which emits the same events as the real one:
+//   - swap emits SwapStarted(fromChainId, toChainId, msg.sender, amount) and returns true
+//   - fillSwap emits SwapFilled(fromChainId, toChainId, toAddress, amount) and returns true
+//   - tokenAddresses and setToken read and write the token registered under a chain id
+//
+// There is no access control and no token is transferred.
+func mockSwapAgentCode() []byte {
+	swapAgentABI, err := abi.JSON(strings.NewReader(sabi.SwapAgentABI))
+	if err != nil {
+		panic(err)
+	}
+	swapStarted := swapAgentABI.Events["SwapStarted"].ID()
+	swapFilled := swapAgentABI.Events["SwapFilled"].ID()
+
+	a := newAssembler().dispatch(swapSignature, fillSwapSignature, tokenAddressesSignature, setTokenSignature)
+
+	// the non-indexed fromChainId is the data of the events
+	a.label(swapSignature).
+		arg(2).op(vm.CALLER).arg(1).push(swapStarted.Bytes()).
+		arg(0).pushInt(0).op(vm.MSTORE).pushInt(32).pushInt(0).op(vm.LOG4).
+		pushInt(1).returnWord()
+
+	a.label(fillSwapSignature).
+		arg(3).arg(2).arg(1).push(swapFilled.Bytes()).
+		arg(0).pushInt(0).op(vm.MSTORE).pushInt(32).pushInt(0).op(vm.LOG4).
+		pushInt(1).returnWord()
+
+	a.label(tokenAddressesSignature).arg(0).op(vm.SLOAD).returnWord()
+
+	a.label(setTokenSignature).arg(1).arg(0).op(vm.SSTORE, vm.STOP)
+
+	return a.bytes()
+}
+
+// mockTokenCode returns the runtime code of a token which only keeps the balances, they are set by setBalance
+// without access control
+func mockTokenCode() []byte {
+	a := newAssembler().dispatch(balanceOfSignature, setBalanceSignature)
+
+	a.label(balanceOfSignature).arg(0).op(vm.SLOAD).returnWord()
+
+	a.label(setBalanceSignature).arg(1).arg(0).op(vm.SSTORE, vm.STOP)
+
+	return a.bytes()
+}
+
+// MockSwapAgentDeployCode returns the creation code of the mock swap agent, to deploy it on a real node
+func MockSwapAgentDeployCode() []byte {
+	return deployCode(mockSwapAgentCode())
+}
+
+// MockTokenDeployCode returns the creation code of the mock token, to deploy it on a real node
+func MockTokenDeployCode() []byte {
+	return deployCode(mockTokenCode())
+}
+
+// SetTokenData returns the calldata of the mock swap agent which registers the token under the chain id
+func SetTokenData(chainId *big.Int, token ethcom.Address) []byte {
+	data := append(crypto.Keccak256([]byte(setTokenSignature))[:4], ethcom.LeftPadBytes(chainId.Bytes(), 32)...)
+	return append(data, ethcom.LeftPadBytes(token.Bytes(), 32)...)
+}
+
+// SetBalanceData returns the calldata of the mock token which sets the balance of the account
+func SetBalanceData(account ethcom.Address, amount *big.Int) []byte {
+	data := append(crypto.Keccak256([]byte(setBalanceSignature))[:4], ethcom.LeftPadBytes(account.Bytes(), 32)...)
+	return append(data, ethcom.LeftPadBytes(amount.Bytes(), 32)...)
+}
@@ -0,0 +1,348 @@
+// Package swaptest runs the swap engine against in-memory chains. Every chain is a simulated backend with a mock
+// swap agent and a mock token in the genesis, so that the engine can be tested end to end without rpc endpoints.
+package swaptest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/jinzhu/gorm"
+
+	sabi "occ-swap-server/abi"
+	"occ-swap-server/common"
+	"occ-swap-server/events"
+	"occ-swap-server/model"
+	"occ-swap-server/swap"
+	"occ-swap-server/util"
+)
+
+const (
+	DefaultGasLimit = 8000000
+
+	// private keys of the accounts funded in the genesis of every chain
+	RelayerPrivateKey = "b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291"
+	UserPrivateKey    = "8a1f9a8f95be41cd7ccb6168179afb4504aefe388d1e14474d32c45c72ce7b7a"
+
+	TokenSymbol   = "MOCK"
+	TokenDecimals = 18
+)
+
+var (
+	SwapAgentAddr = ethcom.HexToAddress("0x00000000000000000000000000000000005a9e47")
+	TokenAddr     = ethcom.HexToAddress("0x0000000000000000000000000000000000070ce2")
+
+	// the liquidity of the swap agent on every chain in the genesis
+	DefaultLiquidity = new(big.Int).Exp(big.NewInt(10), big.NewInt(24), nil)
+
+	genesisBalance = new(big.Int).Exp(big.NewInt(10), big.NewInt(22), nil)
+)
+
+// Chain is an in-memory chain, it implements swap.ChainClient. The simulated backend only signs with the chain id
+// of params.AllEthashProtocolChanges, so all the chains have the same chain id.
+type Chain struct {
+	*backends.SimulatedBackend
+	Name string
+}
+
+var _ swap.ChainClient = (*Chain)(nil)
+
+func (chain *Chain) ChainID(ctx context.Context) (*big.Int, error) {
+	return params.AllEthashProtocolChanges.ChainID, nil
+}
+
+// TransactionReceipt returns ethereum.NotFound for the unknown txs like ethclient, the simulated backend returns a
+// nil receipt instead
+func (chain *Chain) TransactionReceipt(ctx context.Context, txHash ethcom.Hash) (*types.Receipt, error) {
+	receipt, err := chain.SimulatedBackend.TransactionReceipt(ctx, txHash)
+	if err == nil && receipt == nil {
+		return nil, ethereum.NotFound
+	}
+	return receipt, err
+}
+
+func newChain(name string, accounts ...ethcom.Address) *Chain {
+	alloc := core.GenesisAlloc{
+		SwapAgentAddr: {
+			Code:    mockSwapAgentCode(),
+			Balance: big.NewInt(0),
+			// the token filled on this chain is registered under the chain id
+			Storage: map[ethcom.Hash]ethcom.Hash{
+				ethcom.BigToHash(params.AllEthashProtocolChanges.ChainID): ethcom.BytesToHash(TokenAddr.Bytes()),
+			},
+		},
+		TokenAddr: {
+			Code:    mockTokenCode(),
+			Balance: big.NewInt(0),
+			Storage: map[ethcom.Hash]ethcom.Hash{
+				ethcom.BytesToHash(SwapAgentAddr.Bytes()): ethcom.BigToHash(DefaultLiquidity),
+			},
+		},
+	}
+	for _, account := range accounts {
+		alloc[account] = core.GenesisAccount{Balance: genesisBalance}
+	}
+	return &Chain{
+		SimulatedBackend: backends.NewSimulatedBackend(alloc, DefaultGasLimit),
+		Name:             name,
+	}
+}
+
+// Harness holds the in-memory chains and db of a swap engine
+type Harness struct {
+	DB     *gorm.DB
+	Config *util.Config
+
+	Relayer *ecdsa.PrivateKey
+	User    *ecdsa.PrivateKey
+	// key is the chain name
+	Chains map[string]*Chain
+
+	swapAgentABI *abi.ABI
+}
+
+func NewHarness() (*Harness, error) {
+	relayer, err := crypto.HexToECDSA(RelayerPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	user, err := crypto.HexToECDSA(UserPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	swapAgentABI, err := abi.JSON(strings.NewReader(sabi.SwapAgentABI))
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := model.OpenDB(common.DBDialectSqlite3, model.SqliteInMemoryPath)
+	if err != nil {
+		return nil, err
+	}
+	// the mock token has the same address on every chain
+	if err := db.Create(TokenPair(TokenAddr, TokenAddr)).Error; err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	accounts := []ethcom.Address{crypto.PubkeyToAddress(relayer.PublicKey), crypto.PubkeyToAddress(user.PublicKey)}
+	return &Harness{
+		DB:      db,
+		Config:  newConfig(),
+		Relayer: relayer,
+		User:    user,
+		Chains: map[string]*Chain{
+			common.ChainBSC:   newChain(common.ChainBSC, accounts...),
+			common.ChainETH:   newChain(common.ChainETH, accounts...),
+			common.ChainMATIC: newChain(common.ChainMATIC, accounts...),
+		},
+		swapAgentABI: &swapAgentABI,
+	}, nil
+}
+
+// TokenPair returns the swap pair of the mock tokens, the swaps of the tokens of a swap agent are rejected unless
+// they are a swap pair
+func TokenPair(bep20Addr, erc20Addr ethcom.Address) *model.SwapPair {
+	return &model.SwapPair{
+		Symbol:     TokenSymbol,
+		Name:       TokenSymbol,
+		Decimals:   TokenDecimals,
+		BEP20Addr:  bep20Addr.String(),
+		ERC20Addr:  erc20Addr.String(),
+		Available:  true,
+		LowBound:   "0",
+		UpperBound: DefaultLiquidity.String(),
+		AssetType:  common.AssetTypeFungible,
+	}
+}
+
+// newConfig returns a config with short intervals, the providers are not used
+func newConfig() *util.Config {
+	return &util.Config{
+		KeyManagerConfig: util.KeyManagerConfig{
+			KeyType:              common.LocalPrivateKey,
+			LocalHMACKey:         "swaptest",
+			LocalBSCTxHash:       RelayerPrivateKey,
+			LocalETHPrivateKey:   RelayerPrivateKey,
+			LocalMATICPrivateKey: RelayerPrivateKey,
+		},
+		DBConfig: util.DBConfig{Dialect: common.DBDialectSqlite3, DBPath: model.SqliteInMemoryPath},
+		ChainConfig: util.ChainConfig{
+			BalanceMonitorInterval:     1,
+			MonitorSwapRequestInterval: 1,
+			ConfirmSwapRequestInterval: 1,
+			RetryFailedSwapInterval:    1,
+			TrackRetrySwapTxInterval:   1,
+
+			BSCConfirmNum:         1,
+			BSCSwapAgentAddr:      SwapAgentAddr.String(),
+			BSCMaxTrackRetry:      10,
+			BSCSwapDaemonInterval: 1,
+			BSCTrackTxInterval:    1,
+
+			ETHConfirmNum:         1,
+			ETHSwapAgentAddr:      SwapAgentAddr.String(),
+			ETHMaxTrackRetry:      10,
+			ETHSwapDaemonInterval: 1,
+			ETHTrackTxInterval:    1,
+
+			MATICConfirmNum:         1,
+			MATICSwapAgentAddr:      SwapAgentAddr.String(),
+			MATICMaxTrackRetry:      10,
+			MATICSwapDaemonInterval: 1,
+			MATICTrackTxInterval:    1,
+		},
+		AlertConfig: util.AlertConfig{BlockUpdateTimeout: 10},
+		// the webhooks of the tests are local servers
+		WebhookConfig:     util.WebhookConfig{AllowPrivateUrls: true},
+		EnvironmentConfig: util.EnvironmentConfig{Profile: util.EnvironmentTestnet},
+	}
+}
+
+// NewSwapEngine returns a swap engine on the chains of the harness, it is not started
+func (h *Harness) NewSwapEngine() (*swap.SwapEngine, error) {
+	return swap.NewSwapEngine(h.DB, h.Config, h.Chains[common.ChainBSC], h.Chains[common.ChainETH], h.Chains[common.ChainMATIC])
+}
+
+func (h *Harness) Close() {
+	for _, chain := range h.Chains {
+		chain.Close()
+	}
+	h.DB.Close()
+}
+
+// Commit mines the pending txs of all the chains
+func (h *Harness) Commit() {
+	for _, chain := range h.Chains {
+		chain.Commit()
+	}
+}
+
+// AutoCommit mines the pending txs of all the chains periodically until stop is called
+func (h *Harness) AutoCommit(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				h.Commit()
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+func (h *Harness) sendTx(chain *Chain, key *ecdsa.PrivateKey, to ethcom.Address, data []byte) (*types.Receipt, error) {
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	nonce, err := chain.PendingNonceAt(context.Background(), from)
+	if err != nil {
+		return nil, err
+	}
+	gasPrice, err := chain.SuggestGasPrice(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	gasLimit, err := chain.EstimateGas(context.Background(), ethereum.CallMsg{From: from, To: &to, Data: data})
+	if err != nil {
+		return nil, err
+	}
+	rawTx := types.NewTransaction(nonce, to, big.NewInt(0), gasLimit, gasPrice, data)
+	signedTx, err := bind.NewKeyedTransactor(key).Signer(types.NewEIP155Signer(params.AllEthashProtocolChanges.ChainID), from, rawTx)
+	if err != nil {
+		return nil, err
+	}
+	if err := chain.SendTransaction(context.Background(), signedTx); err != nil {
+		return nil, err
+	}
+	chain.Commit()
+	return chain.TransactionReceipt(context.Background(), signedTx.Hash())
+}
+
+// StartSwap sends a swap tx of the user to the swap agent of the chain and mines it
+func (h *Harness) StartSwap(chainName string, toChainId int64, amount *big.Int) (*types.Receipt, error) {
+	chain, ok := h.Chains[chainName]
+	if !ok {
+		return nil, fmt.Errorf("unknown chain %s", chainName)
+	}
+	data, err := h.swapAgentABI.Pack("swap", params.AllEthashProtocolChanges.ChainID, big.NewInt(toChainId), amount)
+	if err != nil {
+		return nil, err
+	}
+	return h.sendTx(chain, h.User, SwapAgentAddr, data)
+}
+
+// SaveSwapStartTxLog saves the SwapStarted event of the receipt as a confirmed event log, the same as the observer
+// does once the tx is confirmed
+func (h *Harness) SaveSwapStartTxLog(chainName string, receipt *types.Receipt) (*model.SwapStartTxLog, error) {
+	for _, log := range receipt.Logs {
+		if log.Address != SwapAgentAddr {
+			continue
+		}
+		event, err := events.DefaultDecoder.DecodeSwapStarted(log)
+		if err != nil {
+			continue
+		}
+		txLog := &model.SwapStartTxLog{
+			Chain:       chainName,
+			TokenAddr:   TokenAddr.String(),
+			FromAddress: event.FromAddress.String(),
+			Amount:      event.Amount.String(),
+			FeeAmount:   "0",
+			ToChainId:   event.ToChainId.String(),
+			Status:      model.TxStatusConfirmed,
+			TxHash:      log.TxHash.String(),
+			BlockHash:   log.BlockHash.Hex(),
+			Height:      int64(log.BlockNumber),
+			Phase:       model.SeenRequest,
+			UpdateTime:  time.Now().Unix(),
+			CreateTime:  time.Now().Unix(),
+		}
+		return txLog, h.DB.Create(txLog).Error
+	}
+	return nil, fmt.Errorf("no SwapStarted event is found in tx %s", receipt.TxHash.String())
+}
+
+// SetLiquidity sets the token balance of the swap agent of the chain
+func (h *Harness) SetLiquidity(chainName string, amount *big.Int) error {
+	chain, ok := h.Chains[chainName]
+	if !ok {
+		return fmt.Errorf("unknown chain %s", chainName)
+	}
+	_, err := h.sendTx(chain, h.User, TokenAddr, SetBalanceData(SwapAgentAddr, amount))
+	return err
+}
+
+// WaitForSwap polls the swap of the start tx until it has the status
+func (h *Harness) WaitForSwap(startTxHash string, status common.SwapStatus, timeout time.Duration) (*model.Swap, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		var swap model.Swap
+		err := h.DB.Where("start_tx_hash = ?", startTxHash).First(&swap).Error
+		if err == nil && swap.Status == status {
+			return &swap, nil
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return nil, fmt.Errorf("swap of %s is not found: %s", startTxHash, err.Error())
+			}
+			return &swap, fmt.Errorf("swap of %s is %s, not %s after %s", startTxHash, swap.Status, status, timeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,87 @@
+package swaptest
+
+import (
+	"context"
+	"math/big"
+	"strconv"
+	"testing"
+	"time"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"occ-swap-server/common"
+	"occ-swap-server/events"
+	"occ-swap-server/model"
+	"occ-swap-server/swap"
+)
+
+// TestSwapSuccess starts a swap on bsc and waits for the engine to confirm it, fill it on eth and mark it success
+func TestSwapSuccess(t *testing.T) {
+	h, err := NewHarness()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	engine, err := h.NewSwapEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.Start()
+	stop := h.AutoCommit(100 * time.Millisecond)
+	defer stop()
+
+	// the in-memory chains share a chain id, the destination is the chain id of eth in the environment profile
+	ethChainId, err := strconv.ParseInt(h.Config.EnvironmentConfig.GetProfile().ChainIds[common.ChainETH][0], 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receipt, err := h.StartSwap(common.ChainBSC, ethChainId, big.NewInt(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		t.Fatalf("swap tx %s failed", receipt.TxHash.String())
+	}
+	if _, err := h.SaveSwapStartTxLog(common.ChainBSC, receipt); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := h.WaitForSwap(receipt.TxHash.String(), swap.SwapSuccess, 30*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Direction != swap.SwapBSC2Eth {
+		t.Errorf("direction is %s, want %s", s.Direction, swap.SwapBSC2Eth)
+	}
+	if s.FillTxHash == "" {
+		t.Fatal("no fill tx hash")
+	}
+
+	var fillTx model.SwapFillTx
+	if err := h.DB.Where("fill_swap_tx_hash = ?", s.FillTxHash).First(&fillTx).Error; err != nil {
+		t.Fatal(err)
+	}
+	if fillTx.Status != model.FillTxSuccess {
+		t.Errorf("fill tx is %d, want %d", fillTx.Status, model.FillTxSuccess)
+	}
+
+	fillReceipt, err := h.Chains[common.ChainETH].TransactionReceipt(context.Background(), ethcom.HexToHash(s.FillTxHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := crypto.PubkeyToAddress(h.User.PublicKey)
+	for _, log := range fillReceipt.Logs {
+		filled, err := events.DefaultDecoder.DecodeSwapFilled(log)
+		if err != nil {
+			continue
+		}
+		if filled.ToAddress != user {
+			t.Errorf("fill pays %s, want %s", filled.ToAddress.String(), user.String())
+		}
+		return
+	}
+	t.Errorf("no SwapFilled event in fill tx %s", s.FillTxHash)
+}
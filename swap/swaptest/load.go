@@ -0,0 +1,228 @@
+package swaptest
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"time"
+
+	"occ-swap-server/common"
+	"occ-swap-server/model"
+	"occ-swap-server/swap"
+)
+
+const (
+	LoadStageConfirm = "observed_created" // swap start tx log saved to the swap created
+	LoadStageFill    = "created_filled"   // swap created to the swap succeeding
+	LoadStageTotal   = "total"            // swap start tx log saved to the swap succeeding
+
+	loadPollInterval = 200 * time.Millisecond
+	// the swaps are polled by chunks of start tx hashes, within the variable limit of sqlite
+	loadPollChunk = 500
+)
+
+var loadStages = []string{LoadStageConfirm, LoadStageFill, LoadStageTotal}
+
+// the statuses a swap doesn't leave without the admin, the swaps of the load are done once they have one of them
+var loadFailedStatuses = []common.SwapStatus{swap.SwapQuoteRejected, swap.SwapRejected, swap.SwapSendFailed, swap.SwapMismatch,
+	swap.SwapAbandoned, swap.SwapUneconomic, swap.SwapExpired}
+
+// LoadOptions are the swaps RunLoad injects, Count swaps of Amount from Chain to ToChainId at Rate swaps a second
+type LoadOptions struct {
+	Chain     string
+	ToChainId int64
+	Amount    *big.Int
+	Rate      float64
+	Count     int
+	// the swaps which are not done Timeout after the last swap is injected are reported as pending
+	Timeout time.Duration
+}
+
+// LatencyPercentiles are in milliseconds
+type LatencyPercentiles struct {
+	Samples int   `json:"samples"`
+	P50     int64 `json:"p50_ms"`
+	P90     int64 `json:"p90_ms"`
+	P99     int64 `json:"p99_ms"`
+	Max     int64 `json:"max_ms"`
+}
+
+type LoadReport struct {
+	Injected  int `json:"injected"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	Pending   int `json:"pending"`
+	// rate the swaps are actually injected at, lower than the target rate if the source chain can't keep up
+	InjectionRate float64 `json:"injection_rate"`
+	// succeeded swaps a second from the first swap injected to the last swap succeeding
+	Throughput float64 `json:"throughput"`
+	Elapsed    string  `json:"elapsed"`
+	// key is the stage
+	Latency map[string]LatencyPercentiles `json:"latency"`
+	// key is the status of the failed swaps
+	FailedStatuses map[common.SwapStatus]int `json:"failed_statuses,omitempty"`
+}
+
+type injectedSwap struct {
+	startTxHash string
+	injectedAt  time.Time
+}
+
+// RunLoad starts the swaps of the options on the source chain and saves their swap start tx logs at the rate, the
+// same as the observer does, and measures how long the engine takes to fill them. The engine of the harness must
+// be started and the chains mined, e.g. by AutoCommit.
+func (h *Harness) RunLoad(opts LoadOptions) (*LoadReport, error) {
+	if opts.Rate <= 0 || opts.Count <= 0 {
+		return nil, fmt.Errorf("rate and count should be positive")
+	}
+	if _, ok := h.Chains[opts.Chain]; !ok {
+		return nil, fmt.Errorf("unknown chain %s", opts.Chain)
+	}
+
+	injected := make(chan injectedSwap, opts.Count)
+	injectErr := make(chan error, 1)
+	go func() {
+		defer close(injected)
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.Rate))
+		defer ticker.Stop()
+		for i := 0; i < opts.Count; i++ {
+			receipt, err := h.StartSwap(opts.Chain, opts.ToChainId, opts.Amount)
+			if err != nil {
+				injectErr <- fmt.Errorf("start swap %d error: %s", i, err.Error())
+				return
+			}
+			txLog, err := h.SaveSwapStartTxLog(opts.Chain, receipt)
+			if err != nil {
+				injectErr <- fmt.Errorf("save swap start tx log %d error: %s", i, err.Error())
+				return
+			}
+			injected <- injectedSwap{startTxHash: txLog.TxHash, injectedAt: time.Now()}
+			if i < opts.Count-1 {
+				<-ticker.C
+			}
+		}
+	}()
+
+	report := &LoadReport{Latency: make(map[string]LatencyPercentiles), FailedStatuses: make(map[common.SwapStatus]int)}
+	latencies := make(map[string][]time.Duration, len(loadStages))
+	pending := make(map[string]time.Time)
+	var firstInjected, lastInjected, lastSucceeded time.Time
+	injecting := true
+
+	ticker := time.NewTicker(loadPollInterval)
+	defer ticker.Stop()
+	for injecting || len(pending) > 0 {
+		select {
+		case err := <-injectErr:
+			return nil, err
+		case swapInjected, ok := <-injected:
+			if !ok {
+				injecting = false
+				injected = nil
+				continue
+			}
+			if report.Injected == 0 {
+				firstInjected = swapInjected.injectedAt
+			}
+			lastInjected = swapInjected.injectedAt
+			report.Injected++
+			pending[swapInjected.startTxHash] = swapInjected.injectedAt
+		case <-ticker.C:
+			if !injecting && time.Since(lastInjected) > opts.Timeout {
+				report.Pending = len(pending)
+				pending = nil
+				continue
+			}
+			done, err := h.pollLoadSwaps(pending)
+			if err != nil {
+				return nil, err
+			}
+			for _, doneSwap := range done {
+				injectedAt := pending[doneSwap.StartTxHash]
+				delete(pending, doneSwap.StartTxHash)
+				if doneSwap.Status != swap.SwapSuccess {
+					report.Failed++
+					report.FailedStatuses[doneSwap.Status]++
+					continue
+				}
+				report.Succeeded++
+				if doneSwap.UpdatedAt.After(lastSucceeded) {
+					lastSucceeded = doneSwap.UpdatedAt
+				}
+				latencies[LoadStageConfirm] = append(latencies[LoadStageConfirm], nonNegative(doneSwap.CreatedAt.Sub(injectedAt)))
+				latencies[LoadStageFill] = append(latencies[LoadStageFill], nonNegative(doneSwap.UpdatedAt.Sub(doneSwap.CreatedAt)))
+				latencies[LoadStageTotal] = append(latencies[LoadStageTotal], nonNegative(doneSwap.UpdatedAt.Sub(injectedAt)))
+			}
+		}
+	}
+
+	select {
+	case err := <-injectErr:
+		return nil, err
+	default:
+	}
+	if report.Injected > 1 {
+		report.InjectionRate = float64(report.Injected-1) / lastInjected.Sub(firstInjected).Seconds()
+	}
+	if report.Succeeded > 0 && lastSucceeded.After(firstInjected) {
+		report.Throughput = float64(report.Succeeded) / lastSucceeded.Sub(firstInjected).Seconds()
+	}
+	report.Elapsed = time.Since(firstInjected).Round(time.Millisecond).String()
+	for _, stage := range loadStages {
+		report.Latency[stage] = percentiles(latencies[stage])
+	}
+	return report, nil
+}
+
+// pollLoadSwaps returns the pending swaps which are done
+func (h *Harness) pollLoadSwaps(pending map[string]time.Time) ([]model.Swap, error) {
+	startTxHashes := make([]string, 0, len(pending))
+	for startTxHash := range pending {
+		startTxHashes = append(startTxHashes, startTxHash)
+	}
+	doneStatuses := append([]common.SwapStatus{swap.SwapSuccess}, loadFailedStatuses...)
+	done := make([]model.Swap, 0)
+	for start := 0; start < len(startTxHashes); start += loadPollChunk {
+		end := start + loadPollChunk
+		if end > len(startTxHashes) {
+			end = len(startTxHashes)
+		}
+		swaps := make([]model.Swap, 0)
+		err := h.DB.Where("start_tx_hash in (?) and status in (?)", startTxHashes[start:end], doneStatuses).Find(&swaps).Error
+		if err != nil {
+			return nil, err
+		}
+		done = append(done, swaps...)
+	}
+	return done, nil
+}
+
+func nonNegative(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// percentiles returns the nearest-rank percentiles of the latencies
+func percentiles(latencies []time.Duration) LatencyPercentiles {
+	if len(latencies) == 0 {
+		return LatencyPercentiles{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p float64) int64 {
+		idx := int(math.Ceil(p*float64(len(latencies)))) - 1
+		if idx < 0 {
+			idx = 0
+		}
+		return latencies[idx].Milliseconds()
+	}
+	return LatencyPercentiles{
+		Samples: len(latencies),
+		P50:     percentile(0.5),
+		P90:     percentile(0.9),
+		P99:     percentile(0.99),
+		Max:     latencies[len(latencies)-1].Milliseconds(),
+	}
+}
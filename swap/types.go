@@ -1,28 +1,48 @@
 package swap
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"math/big"
 	"sync"
 
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi"
-
 	ethcom "github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/jinzhu/gorm"
 
 	"occ-swap-server/common"
+	"occ-swap-server/events"
+	"occ-swap-server/price"
 	"occ-swap-server/util"
 )
 
 const (
-	SwapTokenReceived common.SwapStatus = "received"
-	SwapQuoteRejected common.SwapStatus = "rejected"
-	SwapConfirmed     common.SwapStatus = "confirmed"
-	SwapSending       common.SwapStatus = "sending"
-	SwapSent          common.SwapStatus = "sent"
-	SwapSendFailed    common.SwapStatus = "sent_fail"
-	SwapSuccess       common.SwapStatus = "sent_success"
+	SwapTokenReceived = common.SwapStatusReceived
+	SwapQuoteRejected = common.SwapStatusRejected
+	SwapConfirmed     = common.SwapStatusConfirmed
+	// SwapAwaitingLiquidity swaps are held until the swap agent of the destination chain can cover the amount
+	SwapAwaitingLiquidity = common.SwapStatusAwaitingLiquidity
+	SwapDelayed           = common.SwapStatusDelayed
+	SwapSending           = common.SwapStatusSending
+	SwapSent              = common.SwapStatusSent
+	SwapSendFailed        = common.SwapStatusSendFailed
+	SwapSuccess           = common.SwapStatusSuccess
+	SwapMismatch          = common.SwapStatusMismatch
+	// SwapAbandoned swaps failed after the max auto retries, they are left to the operators
+	SwapAbandoned = common.SwapStatusAbandoned
+	// SwapUneconomic swaps are held until the operators fill or reject them, their bridge fees don't cover the gas
+	SwapUneconomic = common.SwapStatusUneconomic
+	// SwapExpired swaps couldn't be filled within the max age of the expiry config, they are refunded by the operators
+	SwapExpired = common.SwapStatusExpired
+	// SwapAwaitingWindow swaps are held until the daily withdrawal limit of the destination chain can cover the amount
+	SwapAwaitingWindow = common.SwapStatusAwaitingWindow
+	// SwapAwaitingGas swaps are held until the gas price of the destination chain falls below the gas guard ceiling
+	SwapAwaitingGas = common.SwapStatusAwaitingGas
+	// SwapRejected swaps failed the verification of their start event or of their record hash, unlike the quote
+	// rejected ones they may have been tampered with, they are never retried nor expired and are left to the operators
+	SwapRejected = common.SwapStatusVerifyRejected
 
 	SwapPairReceived   common.SwapPairStatus = "received"
 	SwapPairConfirmed  common.SwapPairStatus = "confirmed"
@@ -38,17 +58,15 @@ const (
 	RetrySwapSendFailed common.RetrySwapStatus = "sent_fail"
 	RetrySwapSuccess    common.RetrySwapStatus = "sent_success"
 
-	SwapEth2BSC   common.SwapDirection = "eth_bsc"
-	SwapEth2MATIC common.SwapDirection = "eth_matic"
-	SwapBSC2Eth   common.SwapDirection = "bsc_eth"
-	SwapBSC2MATIC common.SwapDirection = "bsc_matic"
-	SwapMATIC2BSC common.SwapDirection = "matic_bsc"
-	SwapMATIC2Eth common.SwapDirection = "matic_eth"
+	SwapEth2BSC   = common.SwapDirectionETH2BSC
+	SwapEth2MATIC = common.SwapDirectionETH2MATIC
+	SwapBSC2Eth   = common.SwapDirectionBSC2ETH
+	SwapBSC2MATIC = common.SwapDirectionBSC2MATIC
+	SwapMATIC2BSC = common.SwapDirectionMATIC2BSC
+	SwapMATIC2Eth = common.SwapDirectionMATIC2ETH
 
 	BatchSize                = 50
 	TrackSentTxBatchSize     = 100
-	SleepTime                = 5
-	SwapSleepSecond          = 2
 	TrackSwapPairSMBatchSize = 5
 
 	TxFailedStatus = 0x00
@@ -60,16 +78,40 @@ var ethClientMutex sync.RWMutex
 var bscClientMutex sync.RWMutex
 var maticClientMutex sync.RWMutex
 
+// ChainClient is the part of the chain rpc used by the swap engine. It is implemented by *ethclient.Client, and by
+// the simulated chains of the swaptest package so that the engine can run without real rpc endpoints.
+type ChainClient interface {
+	ethereum.ContractCaller
+	ethereum.GasEstimator
+	ethereum.GasPricer
+	ethereum.LogFilterer
+	ethereum.TransactionReader
+	ethereum.TransactionSender
+
+	ChainID(ctx context.Context) (*big.Int, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	BlockByHash(ctx context.Context, hash ethcom.Hash) (*types.Block, error)
+	PendingNonceAt(ctx context.Context, account ethcom.Address) (uint64, error)
+	NonceAt(ctx context.Context, account ethcom.Address, blockNumber *big.Int) (uint64, error)
+	BalanceAt(ctx context.Context, account ethcom.Address, blockNumber *big.Int) (*big.Int, error)
+	CodeAt(ctx context.Context, account ethcom.Address, blockNumber *big.Int) ([]byte, error)
+}
+
 type SwapEngine struct {
-	mutex    sync.RWMutex
-	db       *gorm.DB
+	mutex sync.RWMutex
+	db    *gorm.DB
+	// the storage of the swap lifecycle daemons, the db unless it is replaced by SetStore
+	store    SwapStore
 	hmacCKey string
 	config   *util.Config
+	// chain ids and directions of the environment, selected by the config
+	profile util.EnvironmentProfile
+	routes  routingTable
 	// key is the bsc contract addr
 	swapPairsFromERC20Addr map[ethcom.Address]*SwapPairIns
-	ethClient              *ethclient.Client
-	bscClient              *ethclient.Client
-	maticClient            *ethclient.Client
+	ethClient              ChainClient
+	bscClient              ChainClient
+	maticClient            ChainClient
 	ethPrivateKey          *ecdsa.PrivateKey
 	bscPrivateKey          *ecdsa.PrivateKey
 	maticPrivateKey        *ecdsa.PrivateKey
@@ -80,10 +122,63 @@ type SwapEngine struct {
 	erc20ToBEP20           map[ethcom.Address]ethcom.Address
 
 	swapAgentABI *abi.ABI
+	// decoders of the swap agent events, key is the chain
+	eventDecoders map[string]*events.Decoder
+
+	// directions paused by operators, guarded by mutex
+	pausedDirections map[common.SwapDirection]bool
+	// pairs paused by the admin or the anomaly detector, saved in the paused_pairs table, guarded by mutex
+	pausedPairs map[ethcom.Address]bool
+	// the fill methods of the pairs by the erc20 address and the destination chain, saved in the pair_fill_methods
+	// table, guarded by mutex
+	fillMethods map[ethcom.Address]map[string]*fillMethod
 
 	ethSwapAgent   ethcom.Address
 	bscSwapAgent   ethcom.Address
 	maticSwapAgent ethcom.Address
+
+	// key is the destination chain
+	relayerPools map[string]*RelayerPool
+	// latest liquidity of the destination chains, guarded by mutex
+	liquidity map[string]*Liquidity
+	// latest block numbers of the chains, key is the chain name
+	heads map[string]*headTracker
+	// header caches of the quorum of the header providers the swap start events are checked against, key is the
+	// chain name, only the chains with header verification enabled have one
+	headerCaches map[string]*quorumHeaderCache
+	// quorums of the independent rpcs confirming the swap start txs, key is the chain name, only the chains with the
+	// rpc quorum enabled have one
+	rpcQuorums map[string]*rpcQuorum
+	// circuit breakers of the chain rpcs, the clients of the engine go through them, key is the chain name
+	breakers map[string]*circuitBreaker
+	// swaps being filled or healed by the watchdog, key is the swap id, guarded by mutex
+	claimedSwaps map[uint]bool
+	// swaps whose fill panicked, skipped by the fill daemons of the instance until it restarts, guarded by mutex
+	panickedSwaps map[uint]bool
+	// id of the executor replica, the swaps claimed in db by the other replicas are skipped
+	instanceID string
+
+	// queue of the swap start events from the observers, nil if it is disabled
+	queue util.Queue
+	// wakeups of the daemons waiting for the events, the fill daemons are keyed by the destination chain
+	wakeMonitor chan struct{}
+	wakeConfirm chan struct{}
+	wakeFill    map[string]chan struct{}
+	// runs the daemons of the engine, with the jitter and the error backoff of the config
+	scheduler *util.Scheduler
+
+	// results of reconciling the relayer nonces on startup, guarded by mutex
+	nonceReconciliations []NonceReconciliation
+	// usd prices of the tokens for the limits and the thresholds in usd
+	prices *price.Oracle
+	// report of the latest integrity sweep, guarded by mutex
+	integrityReport *IntegrityReport
+	// hourly spend of the relayer accounts, halting the fills to the chains being drained
+	drainBrake *drainBrake
+	// tokens filled to the chains within the current day of their withdrawal limits, guarded by mutex
+	withdrawals map[string]*withdrawalWindow
+	// gas prices of the chains with a gas guard ceiling, guarded by mutex
+	gasGuards map[string]*GasGuardState
 }
 
 type SwapPairEngine struct {
@@ -94,7 +189,7 @@ type SwapPairEngine struct {
 
 	swapEngine *SwapEngine
 
-	bscClient       *ethclient.Client
+	bscClient       ChainClient
 	bscPrivateKey   *ecdsa.PrivateKey
 	bscChainID      int64
 	bscTxSender     ethcom.Address
@@ -111,4 +206,9 @@ type SwapPairIns struct {
 
 	BEP20Addr ethcom.Address
 	ERC20Addr ethcom.Address
+
+	AssetType  common.AssetType
+	NFTEnabled bool
+	// the new swaps of a disabled pair are rejected, the pair is kept for its in-flight and historical swaps
+	Available bool
 }
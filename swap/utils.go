@@ -1,9 +1,9 @@
 package swap
 
 import (
+	"bytes"
 	"context"
 	"crypto/ecdsa"
-	"encoding/json"
 	"fmt"
 	"math/big"
 	"strings"
@@ -14,7 +14,6 @@ import (
 	ethcom "github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/ethclient"
 
 	"occ-swap-server/common"
 	"occ-swap-server/model"
@@ -45,6 +44,9 @@ func buildSwapPairInstance(pairs []model.SwapPair) (map[ethcom.Address]*SwapPair
 			UpperBound: upperBound,
 			BEP20Addr:  ethcom.HexToAddress(pair.BEP20Addr),
 			ERC20Addr:  ethcom.HexToAddress(pair.ERC20Addr),
+			AssetType:  pair.AssetType,
+			NFTEnabled: pair.NFTEnabled,
+			Available:  pair.Available,
 		}
 
 		util.Logger.Infof("Load swap pair, symbol %s, bep20 address %s, erc20 address %s", pair.Symbol, pair.BEP20Addr, pair.ERC20Addr)
@@ -53,29 +55,54 @@ func buildSwapPairInstance(pairs []model.SwapPair) (map[ethcom.Address]*SwapPair
 	return swapPairInstances, nil
 }
 
-func GetKeyConfig(cfg *util.Config) (*util.KeyConfig, error) {
-	if cfg.KeyManagerConfig.KeyType == common.AWSPrivateKey {
-		result, err := util.GetSecret(cfg.KeyManagerConfig.AWSSecretName, cfg.KeyManagerConfig.AWSRegion)
-		if err != nil {
-			return nil, err
-		}
+// getDestChain returns the chain the fill tx of the given direction is sent to
+func getDestChain(direction common.SwapDirection) string {
+	route, _ := getSwapRoute(direction)
+	return route.DestChain
+}
+
+// getSourceChain returns the chain the swap start tx of the given direction is sent to
+func getSourceChain(direction common.SwapDirection) string {
+	route, _ := getSwapRoute(direction)
+	return route.SourceChain
+}
 
-		keyConfig := util.KeyConfig{}
-		err = json.Unmarshal([]byte(result), &keyConfig)
-		if err != nil {
-			return nil, err
+// SwapExplorerLinks returns the explorer links of the start tx and the fill tx of the swap, empty if the swap has no
+// direction or no fill tx yet
+func (engine *SwapEngine) SwapExplorerLinks(swap *model.Swap) (string, string) {
+	return engine.config.ExplorerLink(getSourceChain(swap.Direction), util.ExplorerTx, swap.StartTxHash),
+		engine.config.ExplorerLink(getDestChain(swap.Direction), util.ExplorerTx, swap.FillTxHash)
+}
+
+// getDirectionsToChain returns all the swap directions whose fill txs are sent to the given chain
+func getDirectionsToChain(chain string) []common.SwapDirection {
+	directions := make([]common.SwapDirection, 0)
+	for _, route := range swapRoutes {
+		if route.DestChain == chain {
+			directions = append(directions, route.Direction)
 		}
-		return &keyConfig, nil
-	} else {
-		return &util.KeyConfig{
-			HMACKey:         cfg.KeyManagerConfig.LocalHMACKey,
-			AdminApiKey:     cfg.KeyManagerConfig.LocalAdminApiKey,
-			AdminSecretKey:  cfg.KeyManagerConfig.LocalAdminSecretKey,
-			BSCPrivateKey:   cfg.KeyManagerConfig.LocalBSCTxHash,
-			ETHPrivateKey:   cfg.KeyManagerConfig.LocalETHPrivateKey,
-			MATICPrivateKey: cfg.KeyManagerConfig.LocalMATICPrivateKey,
-		}, nil
 	}
+	return directions
+}
+
+// getSwapDirection returns the direction of a swap started on the chain to the chain id by the routing table
+func (engine *SwapEngine) getSwapDirection(chain string, toChainId string) (common.SwapDirection, error) {
+	if entry, ok := engine.routes[routeKey{sourceChain: chain, toChainId: toChainId}]; ok {
+		return entry.Direction, nil
+	}
+	if _, ok := engine.profile.GetChainByID(toChainId); ok {
+		return "", fmt.Errorf("swap from %s to chain id %s is not enabled in the %s environment", chain, toChainId, engine.profile.Name)
+	}
+	return "", fmt.Errorf("unrecognized to chain id %s in the %s environment", toChainId, engine.profile.Name)
+}
+
+func isValidDirection(direction common.SwapDirection) bool {
+	_, ok := getSwapRoute(direction)
+	return ok
+}
+
+func GetKeyConfig(cfg *util.Config) (*util.KeyConfig, error) {
+	return util.GetKeyConfig(cfg)
 }
 
 func abiEncodeFillETH2BSCSwap(ethTxHash ethcom.Hash, erc20Addr ethcom.Address, toAddress ethcom.Address, amount *big.Int, abi *abi.ABI) ([]byte, error) {
@@ -118,20 +145,20 @@ func abiEncodeCreateSwapPair(registerTxHash ethcom.Hash, erc20Addr ethcom.Addres
 	return data, nil
 }
 
-func buildSignedTransaction(contract ethcom.Address, ethClient *ethclient.Client, txInput []byte, privateKey *ecdsa.PrivateKey, chainId *big.Int) (*types.Transaction, error) {
+func buildSignedTransaction(contract ethcom.Address, client ChainClient, txInput []byte, privateKey *ecdsa.PrivateKey, chainId *big.Int) (*types.Transaction, error) {
 	txOpts := bind.NewKeyedTransactor(privateKey)
 
-	nonce, err := ethClient.PendingNonceAt(context.Background(), txOpts.From)
+	nonce, err := client.PendingNonceAt(context.Background(), txOpts.From)
 	if err != nil {
 		return nil, err
 	}
-	gasPrice, err := ethClient.SuggestGasPrice(context.Background())
+	gasPrice, err := client.SuggestGasPrice(context.Background())
 	if err != nil {
 		return nil, err
 	}
 	value := big.NewInt(0)
 	msg := ethereum.CallMsg{From: txOpts.From, To: &contract, GasPrice: gasPrice, Value: value, Data: txInput}
-	gasLimit, err := ethClient.EstimateGas(context.Background(), msg)
+	gasLimit, err := client.EstimateGas(context.Background(), msg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to estimate gas needed: %v", err)
 	}
@@ -147,20 +174,20 @@ func buildSignedTransaction(contract ethcom.Address, ethClient *ethclient.Client
 	return signedTx, nil
 }
 
-func buildNativeCoinTransferTx(contract ethcom.Address, ethClient *ethclient.Client, value *big.Int, privateKey *ecdsa.PrivateKey) (*types.Transaction, error) {
+func buildNativeCoinTransferTx(contract ethcom.Address, client ChainClient, value *big.Int, privateKey *ecdsa.PrivateKey) (*types.Transaction, error) {
 	txOpts := bind.NewKeyedTransactor(privateKey)
 
-	nonce, err := ethClient.PendingNonceAt(context.Background(), txOpts.From)
+	nonce, err := client.PendingNonceAt(context.Background(), txOpts.From)
 	if err != nil {
 		return nil, err
 	}
-	gasPrice, err := ethClient.SuggestGasPrice(context.Background())
+	gasPrice, err := client.SuggestGasPrice(context.Background())
 	fmt.Printf("gasPrice: %d", gasPrice)
 	if err != nil {
 		return nil, err
 	}
 	msg := ethereum.CallMsg{From: txOpts.From, To: &contract, GasPrice: gasPrice, Value: value}
-	gasLimit, err := ethClient.EstimateGas(context.Background(), msg)
+	gasLimit, err := client.EstimateGas(context.Background(), msg)
 	fmt.Printf("gasLimit: %d", gasLimit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to estimate gas needed: %v", err)
@@ -189,3 +216,64 @@ func BuildKeys(privateKeyStr string) (*ecdsa.PrivateKey, *ecdsa.PublicKey, error
 	}
 	return priKey, publicKey, nil
 }
+
+// revertReasonSelector is the selector of the solidity Error(string) revert payload
+var revertReasonSelector = crypto.Keccak256([]byte("Error(string)"))[:4]
+
+// bestEffortRevertPrefix labels the revert reasons of the replays in the logs and the records, the txs before the
+// failed tx in its block are not replayed, so the reason may not be the one the tx reverted with
+const bestEffortRevertPrefix = "best effort, replayed on the parent block: "
+
+// getRevertReason replays the given transaction via eth_call on the state of the parent block
+// and extracts the revert reason string, labeled best effort, the errors of the rpc are returned, the failed tx is
+// recorded without a reason then
+func getRevertReason(client ChainClient, txHash ethcom.Hash, blockNumber *big.Int) (string, error) {
+	tx, _, err := client.TransactionByHash(context.Background(), txHash)
+	if err != nil {
+		return "", err
+	}
+	from, err := types.Sender(types.NewEIP155Signer(tx.ChainId()), tx)
+	if err != nil {
+		return "", err
+	}
+	msg := ethereum.CallMsg{From: from, To: tx.To(), Gas: tx.Gas(), GasPrice: tx.GasPrice(), Value: tx.Value(), Data: tx.Data()}
+
+	callHeight := big.NewInt(0)
+	if blockNumber != nil && blockNumber.Sign() > 0 {
+		callHeight.Sub(blockNumber, big.NewInt(1))
+	}
+	result, err := client.CallContract(context.Background(), msg, callHeight)
+	if err != nil {
+		// newer nodes report the revert reason in the error message, e.g. "execution reverted: reason"
+		if strings.Contains(err.Error(), "execution reverted") {
+			return bestEffortRevertPrefix + err.Error(), nil
+		}
+		return "", err
+	}
+	reason, err := unpackRevertReason(result)
+	if err != nil {
+		// the replay doesn't revert with a reason, e.g. the tx ran out of gas
+		util.Logger.Debugf("unpack revert reason of tx %s failed: %s", txHash.String(), err.Error())
+		return "", nil
+	}
+	return bestEffortRevertPrefix + reason, nil
+}
+
+func unpackRevertReason(result []byte) (string, error) {
+	if len(result) < 4 || !bytes.Equal(result[:4], revertReasonSelector) {
+		return "", fmt.Errorf("no revert reason found in call result")
+	}
+	stringType, err := abi.NewType("string", "", nil)
+	if err != nil {
+		return "", err
+	}
+	values, err := abi.Arguments{{Type: stringType}}.UnpackValues(result[4:])
+	if err != nil {
+		return "", err
+	}
+	reason, ok := values[0].(string)
+	if !ok {
+		return "", fmt.Errorf("invalid revert reason type")
+	}
+	return reason, nil
+}
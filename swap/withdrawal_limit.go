@@ -0,0 +1,201 @@
+package swap
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"occ-swap-server/common"
+	"occ-swap-server/util"
+)
+
+// withdrawalWindowLength is the window of the daily withdrawal limits, the windows start at utc midnight
+const withdrawalWindowLength = 24 * time.Hour
+
+// withdrawalWindow caches the tokens filled to a chain within the window starting at start, the limit is checked
+// against the db, the cache only reports the allowance
+type withdrawalWindow struct {
+	start time.Time
+	used  *big.Int
+	// the limit is alerted once a window
+	reached bool
+}
+
+// WithdrawalAllowance is what is left of the daily withdrawal limit of a chain within the current window
+type WithdrawalAllowance struct {
+	Chain       string `json:"chain"`
+	Limit       string `json:"limit"`
+	Used        string `json:"used"`
+	Remaining   string `json:"remaining"`
+	WindowStart int64  `json:"window_start"`
+	WindowEnd   int64  `json:"window_end"`
+	// whether a swap is held for the next window
+	Reached bool `json:"reached"`
+}
+
+func getWithdrawalWindowStart(now time.Time) time.Time {
+	return now.UTC().Truncate(withdrawalWindowLength)
+}
+
+// queryWithdrawals sums the fungible swaps to the chain being filled, or whose fill tx is sent within the window
+// starting at start, the failed fills didn't move the tokens
+func (engine *SwapEngine) queryWithdrawals(chain string, start time.Time) (*big.Int, error) {
+	return engine.store.SumFilledAmount(getDirectionsToChain(chain), start)
+}
+
+// refreshWithdrawalWindow reloads the tokens filled to the chain within the current window from the db, and starts a
+// new window once the day is over
+func (engine *SwapEngine) refreshWithdrawalWindow(chain string) (*withdrawalWindow, error) {
+	start := getWithdrawalWindowStart(time.Now())
+	used, err := engine.queryWithdrawals(chain, start)
+	if err != nil {
+		return nil, err
+	}
+
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+	window, ok := engine.withdrawals[chain]
+	if !ok || !window.start.Equal(start) {
+		window = &withdrawalWindow{start: start}
+		engine.withdrawals[chain] = window
+	}
+	window.used = used
+	return window, nil
+}
+
+// getWithdrawalWindow returns the current window of the chain, it is loaded from the db at the start of the window
+func (engine *SwapEngine) getWithdrawalWindow(chain string) (*withdrawalWindow, error) {
+	start := getWithdrawalWindowStart(time.Now())
+	engine.mutex.RLock()
+	window, ok := engine.withdrawals[chain]
+	engine.mutex.RUnlock()
+	if ok && window.start.Equal(start) {
+		return window, nil
+	}
+	return engine.refreshWithdrawalWindow(chain)
+}
+
+// reserveWithdrawal checks the amount of the fill against the remaining allowance of the chain in the tx claiming the
+// swap, it is false if the amount is above it or the withdrawals can't be summed. The withdrawals to the chain are
+// locked until the tx ends and summed from the db, and the swap saved as sending in the tx reserves the amount, so
+// the replicas never exceed the limit together.
+func (engine *SwapEngine) reserveWithdrawal(tx SwapStore, chain string, amount *big.Int) bool {
+	limit := engine.config.WithdrawalLimitConfig.GetMaxPerDay(chain)
+	if limit == nil {
+		return true
+	}
+	if err := tx.LockWithdrawals(chain); err != nil {
+		util.Logger.Errorf("lock withdrawals of %s error: %s", chain, err.Error())
+		return false
+	}
+	start := getWithdrawalWindowStart(time.Now())
+	used, err := tx.SumFilledAmount(getDirectionsToChain(chain), start)
+	if err != nil {
+		util.Logger.Errorf("query withdrawals of %s error: %s", chain, err.Error())
+		return false
+	}
+	reserved := new(big.Int).Add(used, amount).Cmp(limit) <= 0
+	if reserved {
+		used.Add(used, amount)
+	}
+
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+	window, ok := engine.withdrawals[chain]
+	if !ok || !window.start.Equal(start) {
+		window = &withdrawalWindow{start: start}
+		engine.withdrawals[chain] = window
+	}
+	window.used = used
+	if !reserved && !window.reached {
+		window.reached = true
+		util.SendTelegramMessage(fmt.Sprintf("daily withdrawal limit %s of %s is reached, %s is filled today, the swaps are held until %s",
+			limit.String(), chain, used.String(), start.Add(withdrawalWindowLength).Format(time.RFC3339)))
+	}
+	return reserved
+}
+
+// GetWithdrawalAllowance returns the allowance left to the chain within the current window, nil if the chain has
+// no withdrawal limit
+func (engine *SwapEngine) GetWithdrawalAllowance(chain string) (*WithdrawalAllowance, error) {
+	limit := engine.config.WithdrawalLimitConfig.GetMaxPerDay(chain)
+	if limit == nil {
+		return nil, nil
+	}
+	window, err := engine.getWithdrawalWindow(chain)
+	if err != nil {
+		return nil, err
+	}
+
+	engine.mutex.RLock()
+	defer engine.mutex.RUnlock()
+	remaining := new(big.Int).Sub(limit, window.used)
+	if remaining.Sign() < 0 {
+		remaining.SetInt64(0)
+	}
+	return &WithdrawalAllowance{
+		Chain:       chain,
+		Limit:       limit.String(),
+		Used:        window.used.String(),
+		Remaining:   remaining.String(),
+		WindowStart: window.start.Unix(),
+		WindowEnd:   window.start.Add(withdrawalWindowLength).Unix(),
+		Reached:     window.reached,
+	}, nil
+}
+
+// withdrawalLimitDaemon refreshes the windows of the chains with a withdrawal limit, and releases the swaps waiting
+// for the window in order as long as the remaining allowance covers them
+func (engine *SwapEngine) withdrawalLimitDaemon() error {
+	for _, chain := range []string{common.ChainBSC, common.ChainETH, common.ChainMATIC} {
+		limit := engine.config.WithdrawalLimitConfig.GetMaxPerDay(chain)
+		if limit == nil {
+			continue
+		}
+		window, err := engine.refreshWithdrawalWindow(chain)
+		if err != nil {
+			util.Logger.Errorf("query withdrawals of %s error: %s", chain, err.Error())
+			continue
+		}
+		engine.mutex.RLock()
+		remaining := new(big.Int).Sub(limit, window.used)
+		engine.mutex.RUnlock()
+		remainingFloat, _ := new(big.Float).SetInt(remaining).Float64()
+		withdrawalRemainingGauge.WithLabelValues(chain).Set(remainingFloat)
+
+		engine.releaseAwaitingWindowSwaps(chain, remaining)
+	}
+	return nil
+}
+
+func (engine *SwapEngine) releaseAwaitingWindowSwaps(chain string, remaining *big.Int) {
+	swaps, err := engine.store.FindSwaps(SwapQuery{Statuses: []common.SwapStatus{SwapAwaitingWindow},
+		Directions: getDirectionsToChain(chain), ByPriority: true, Limit: BatchSize})
+	if err != nil {
+		util.Logger.Errorf("query swaps awaiting withdrawal window to %s error: %s", chain, err.Error())
+		return
+	}
+
+	for _, swap := range swaps {
+		amount, ok := big.NewInt(0).SetString(swap.Amount, 10)
+		if !ok {
+			continue
+		}
+		if remaining.Cmp(amount) < 0 {
+			break
+		}
+		remaining.Sub(remaining, amount)
+		util.Logger.Infof("daily withdrawal limit of %s covers swap, start tx hash %s, amount %s", chain, swap.StartTxHash, swap.Amount)
+		writeDBErr := engine.store.Transaction(func(tx SwapStore) error {
+			if !engine.verifySwap(&swap) {
+				return fmt.Errorf("verify hmac of swap failed: %s", swap.StartTxHash)
+			}
+			swap.Status = SwapConfirmed
+			return engine.updateSwap(tx, &swap)
+		})
+		if writeDBErr != nil {
+			util.Logger.Errorf("write db error: %s", writeDBErr.Error())
+			util.SendTelegramMessage(fmt.Sprintf("write db error: %s", writeDBErr.Error()))
+		}
+	}
+}
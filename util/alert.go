@@ -0,0 +1,169 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	UrgentAlertPrefix = "Urgent alert: "
+
+	// telegram rejects the messages longer than 4096 characters
+	maxTelegramMessageLength = 4000
+	// the oldest pending alerts are dropped beyond it
+	maxPendingAlerts = 1000
+)
+
+// alertAggregator sits between SendTelegramMessage and the telegram api, so an alert sent in a loop doesn't flood
+// the channel. The repeats of an alert within the dedup window are counted and sent as one summary once the window
+// is over, the alerts are sent in batches every batch interval, an urgent alert triggers the batch at once, and at
+// most maxPerMinute messages are posted a minute, the rest wait for the next batch.
+type alertAggregator struct {
+	post          func(text string)
+	dedupWindow   time.Duration
+	batchInterval time.Duration
+	maxPerMinute  int
+
+	mutex sync.Mutex
+	// alerts sent within the dedup window, key is the message
+	seen      map[string]*seenAlert
+	pending   []string
+	dropped   int
+	postTimes []time.Time
+	wake      chan struct{}
+}
+
+type seenAlert struct {
+	firstTime time.Time
+	repeats   int
+}
+
+func newAlertAggregator(cfg AlertConfig, post func(text string)) *alertAggregator {
+	return &alertAggregator{
+		post:          post,
+		dedupWindow:   cfg.GetDedupWindow(),
+		batchInterval: cfg.GetBatchInterval(),
+		maxPerMinute:  cfg.GetMaxPerMinute(),
+		seen:          make(map[string]*seenAlert),
+		wake:          make(chan struct{}, 1),
+	}
+}
+
+func (a *alertAggregator) Start() {
+	go a.flushDaemon()
+}
+
+// Add queues the alert unless it is a repeat within the dedup window
+func (a *alertAggregator) Add(msg string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if seen, ok := a.seen[msg]; ok && time.Since(seen.firstTime) < a.dedupWindow {
+		seen.repeats++
+		return
+	}
+	a.seen[msg] = &seenAlert{firstTime: time.Now()}
+	a.push(msg)
+	if strings.HasPrefix(msg, UrgentAlertPrefix) {
+		select {
+		case a.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// push must be called with the mutex held
+func (a *alertAggregator) push(msg string) {
+	if len(a.pending) >= maxPendingAlerts {
+		a.pending = a.pending[1:]
+		a.dropped++
+	}
+	a.pending = append(a.pending, msg)
+}
+
+func (a *alertAggregator) flushDaemon() {
+	ticker := time.NewTicker(a.batchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-a.wake:
+		}
+		for {
+			text := a.nextBatch()
+			if text == "" {
+				break
+			}
+			a.post(text)
+		}
+	}
+}
+
+// nextBatch returns the text of the next message to post, it is empty if there is nothing to post or the rate
+// limit is reached
+func (a *alertAggregator) nextBatch() string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	now := time.Now()
+	expired := make([]string, 0)
+	for msg, seen := range a.seen {
+		if now.Sub(seen.firstTime) >= a.dedupWindow {
+			expired = append(expired, msg)
+		}
+	}
+	sort.Strings(expired)
+	for _, msg := range expired {
+		if repeats := a.seen[msg].repeats; repeats > 0 {
+			a.push(fmt.Sprintf("repeated %d times in the last %s: %s", repeats, a.dedupWindow, msg))
+		}
+		delete(a.seen, msg)
+	}
+	if a.dropped > 0 {
+		a.pending = append(a.pending, fmt.Sprintf("%d alerts are dropped, too many alerts are pending", a.dropped))
+		a.dropped = 0
+	}
+	if len(a.pending) == 0 {
+		return ""
+	}
+
+	postTimes := make([]time.Time, 0, len(a.postTimes))
+	for _, postTime := range a.postTimes {
+		if now.Sub(postTime) < time.Minute {
+			postTimes = append(postTimes, postTime)
+		}
+	}
+	a.postTimes = postTimes
+	if len(a.postTimes) >= a.maxPerMinute {
+		return ""
+	}
+	a.postTimes = append(a.postTimes, now)
+
+	if len(a.pending) == 1 {
+		text := truncateAlert(a.pending[0])
+		a.pending = a.pending[:0]
+		return text
+	}
+	var builder strings.Builder
+	count := 0
+	for _, msg := range a.pending {
+		line := "\n- " + truncateAlert(msg)
+		if count > 0 && builder.Len()+len(line) > maxTelegramMessageLength {
+			break
+		}
+		builder.WriteString(line)
+		count++
+	}
+	a.pending = a.pending[count:]
+	return fmt.Sprintf("%d alerts:%s", count, builder.String())
+}
+
+func truncateAlert(msg string) string {
+	if len(msg) <= maxTelegramMessageLength {
+		return msg
+	}
+	return msg[:maxTelegramMessageLength] + "..."
+}
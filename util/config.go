@@ -4,39 +4,2125 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math"
+	"math/big"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	ethcom "github.com/ethereum/go-ethereum/common"
 
 	"occ-swap-server/common"
 )
 
+const (
+	// RoleAll runs the observers and the executor in one process
+	RoleAll = "all"
+	// RoleObserver only observes the swap events of the chains into the db, it doesn't load any key
+	RoleObserver = "observer"
+	// RoleExecutor only fills the swaps observed by the observers sharing the db and serves the admin api
+	RoleExecutor = "executor"
+)
+
 type Config struct {
-	KeyManagerConfig KeyManagerConfig `json:"key_manager_config"`
-	DBConfig         DBConfig         `json:"db_config"`
-	ChainConfig      ChainConfig      `json:"chain_config"`
-	LogConfig        LogConfig        `json:"log_config"`
-	AlertConfig      AlertConfig      `json:"alert_config"`
-	AdminConfig      AdminConfig      `json:"admin_config"`
+	// role of the process, all by default
+	Role string `json:"role"`
+
+	KeyManagerConfig  KeyManagerConfig  `json:"key_manager_config"`
+	DBConfig          DBConfig          `json:"db_config"`
+	ChainConfig       ChainConfig       `json:"chain_config"`
+	LogConfig         LogConfig         `json:"log_config"`
+	AlertConfig       AlertConfig       `json:"alert_config"`
+	AdminConfig       AdminConfig       `json:"admin_config"`
+	WebhookConfig     WebhookConfig     `json:"webhook_config"`
+	AnomalyConfig     AnomalyConfig     `json:"anomaly_config"`
+	EnvironmentConfig EnvironmentConfig `json:"environment_config"`
+	QueueConfig       QueueConfig       `json:"queue_config"`
+	ArchiveConfig     ArchiveConfig     `json:"archive_config"`
+	RelayConfig       RelayConfig       `json:"relay_config"`
+	// optional check of the fill gas of the small swaps against their bridge fees
+	ProfitabilityConfig ProfitabilityConfig `json:"profitability_config"`
+	// optional usd prices of the tokens for the limits and the thresholds in usd
+	PriceConfig PriceConfig `json:"price_config"`
+	// optional schedule of the integrity sweep of the swaps
+	IntegrityConfig IntegrityConfig `json:"integrity_config"`
+	// optional thresholds of the circuit breakers of the chain rpcs
+	CircuitBreakerConfig CircuitBreakerConfig `json:"circuit_breaker_config"`
+	// optional factories deploying the pegged tokens of the registered swap pairs
+	PeggedTokenConfig PeggedTokenConfig `json:"pegged_token_config"`
+	// optional jitter and error backoff of the daemons
+	SchedulerConfig SchedulerConfig `json:"scheduler_config"`
+	// optional header providers the source events are verified against, instead of trusting the chain rpc alone
+	HeaderVerificationConfig HeaderVerificationConfig `json:"header_verification_config"`
+	// optional rpc providers confirming the swap start txs before the swaps are filled
+	RPCQuorumConfig RPCQuorumConfig `json:"rpc_quorum_config"`
+	// optional risk classes of the pairs waiting for more confirmations of their swap start txs
+	RiskClassConfig RiskClassConfig `json:"risk_class_config"`
+	// optional expiry of the swaps which can't be filled for long
+	ExpiryConfig ExpiryConfig `json:"expiry_config"`
+	// optional ceilings of the hourly spend of the relayer accounts, halting the fills to a chain being drained
+	DrainBrakeConfig DrainBrakeConfig `json:"drain_brake_config"`
+	// optional daily caps of the tokens filled to the chains, the swaps above them wait for the next day
+	WithdrawalLimitConfig WithdrawalLimitConfig `json:"withdrawal_limit_config"`
+	// optional gas price ceilings of the destination chains, the fills wait while the gas price is above them
+	GasGuardConfig GasGuardConfig `json:"gas_guard_config"`
+	// optional search of the destination chains for an earlier fill of a swap before it is filled
+	DuplicateFillConfig DuplicateFillConfig `json:"duplicate_fill_config"`
+	// optional external gas oracles pricing the txs of the chains instead of the gas price of the rpc
+	GasOracleConfig GasOracleConfig `json:"gas_oracle_config"`
+	// age of the oldest swap of every processing stage, alerted above the max ages
+	QueueAgeConfig QueueAgeConfig `json:"queue_age_config"`
+	// optional single tx swap starts with the permit2 signatures of the owners
+	Permit2Config Permit2Config `json:"permit2_config"`
+	// optional allowances and balances of the tokens held by the relayer accounts
+	RelayerTokenConfig RelayerTokenConfig `json:"relayer_token_config"`
+	// optional targets of the token inventory of the chains, planning the transfers between the swap agents
+	RebalanceConfig RebalanceConfig `json:"rebalance_config"`
+	// hourly and daily aggregates of the swaps served to the dashboards
+	RollupConfig RollupConfig `json:"rollup_config"`
+	// optional sink the swap lifecycle events are published to
+	EventExportConfig EventExportConfig `json:"event_export_config"`
+	// optional blocklist of the recipients of the fills
+	ScreeningConfig ScreeningConfig `json:"screening_config"`
+	// optional tx, address and block pages of the explorers of the chains
+	ExplorerConfig ExplorerConfig `json:"explorer_config"`
+}
+
+// Check returns all the problems of the config
+func (cfg *Config) Check() []string {
+	errs := make([]string, 0)
+	switch cfg.GetRole() {
+	case RoleAll, RoleObserver, RoleExecutor:
+	default:
+		errs = append(errs, fmt.Sprintf("role should be %s, %s or %s", RoleAll, RoleObserver, RoleExecutor))
+	}
+	if cfg.HasRole(RoleExecutor) {
+		errs = append(errs, cfg.KeyManagerConfig.Check()...)
+	}
+	errs = append(errs, cfg.DBConfig.Check()...)
+	errs = append(errs, cfg.ChainConfig.Check()...)
+	errs = append(errs, cfg.LogConfig.Check()...)
+	errs = append(errs, cfg.AlertConfig.Check()...)
+	errs = append(errs, cfg.WebhookConfig.Check()...)
+	errs = append(errs, cfg.AnomalyConfig.Check()...)
+	errs = append(errs, cfg.EnvironmentConfig.Check()...)
+	errs = append(errs, cfg.ExplorerConfig.Check()...)
+	errs = append(errs, cfg.QueueConfig.Check()...)
+	errs = append(errs, cfg.ArchiveConfig.Check()...)
+	errs = append(errs, cfg.RelayConfig.Check()...)
+	errs = append(errs, cfg.ProfitabilityConfig.Check()...)
+	errs = append(errs, cfg.PriceConfig.Check()...)
+	errs = append(errs, cfg.IntegrityConfig.Check()...)
+	errs = append(errs, cfg.CircuitBreakerConfig.Check()...)
+	errs = append(errs, cfg.PeggedTokenConfig.Check()...)
+	errs = append(errs, cfg.SchedulerConfig.Check()...)
+	errs = append(errs, cfg.HeaderVerificationConfig.Check()...)
+	errs = append(errs, cfg.RPCQuorumConfig.Check()...)
+	errs = append(errs, cfg.RiskClassConfig.Check()...)
+	errs = append(errs, cfg.ExpiryConfig.Check()...)
+	errs = append(errs, cfg.DrainBrakeConfig.Check()...)
+	errs = append(errs, cfg.WithdrawalLimitConfig.Check()...)
+	errs = append(errs, cfg.GasGuardConfig.Check()...)
+	errs = append(errs, cfg.DuplicateFillConfig.Check()...)
+	errs = append(errs, cfg.GasOracleConfig.Check()...)
+	errs = append(errs, cfg.QueueAgeConfig.Check()...)
+	errs = append(errs, cfg.Permit2Config.Check()...)
+	errs = append(errs, cfg.RelayerTokenConfig.Check()...)
+	errs = append(errs, cfg.RebalanceConfig.Check()...)
+	errs = append(errs, cfg.RollupConfig.Check()...)
+	errs = append(errs, cfg.EventExportConfig.Check()...)
+	errs = append(errs, cfg.ScreeningConfig.Check()...)
+	errs = append(errs, cfg.AdminConfig.Check()...)
+	errs = append(errs, cfg.checkPricedTokens()...)
+	errs = append(errs, cfg.checkChainIds()...)
+	return errs
+}
+
+func (cfg *Config) GetRole() string {
+	if cfg.Role == "" {
+		return RoleAll
+	}
+	return cfg.Role
+}
+
+// HasRole returns whether the process plays the role, the processes of RoleAll play all the roles
+func (cfg *Config) HasRole(role string) bool {
+	return cfg.GetRole() == RoleAll || cfg.GetRole() == role
+}
+
+// checkChainIds checks the configured chain ids are recognized as their chains by the environment profile
+func (cfg *Config) checkChainIds() []string {
+	errs := make([]string, 0)
+	profile := cfg.EnvironmentConfig.GetProfile()
+	for _, chain := range cfg.ChainConfig.chainParams() {
+		if chain.chainID <= 0 {
+			continue
+		}
+		if recognized, _ := profile.GetChainByID(strconv.FormatInt(chain.chainID, 10)); recognized != chain.name {
+			errs = append(errs, fmt.Sprintf("%s_chain_id %d is not a %s chain id of the %s environment",
+				chain.prefix, chain.chainID, chain.name, profile.Name))
+		}
+	}
+	return errs
+}
+
+// checkPricedTokens checks the limits and the thresholds in usd have the prices of price_config
+func (cfg *Config) checkPricedTokens() []string {
+	errs := make([]string, 0)
+	priced := cfg.PriceConfig.Enabled()
+	for _, pair := range cfg.ProfitabilityConfig.Pairs {
+		if !priced {
+			errs = append(errs, "price_config should be enabled for profitability_config")
+			break
+		}
+		if _, ok := cfg.PriceConfig.GetToken(pair.ERC20Addr); !ok {
+			errs = append(errs, fmt.Sprintf("profitability_config pair %s is not in the tokens of price_config", pair.ERC20Addr))
+		}
+	}
+	for _, swapDelay := range cfg.ChainConfig.SwapDelays {
+		if swapDelay.ThresholdUsd > 0 && !priced {
+			errs = append(errs, "price_config should be enabled for threshold_usd of swap_delays")
+			break
+		}
+	}
+	if cfg.AnomalyConfig.MaxVolumeUsd > 0 && !priced {
+		errs = append(errs, "price_config should be enabled for max_volume_usd of anomaly_config")
+	}
+	return errs
+}
+
+// getExplorerUrl returns the explorer url of the chain in chain_config, the one of the environment profile by default
+func (cfg *Config) getExplorerUrl(chain string) string {
+	var explorerUrl string
+	switch chain {
+	case common.ChainBSC:
+		explorerUrl = cfg.ChainConfig.BSCExplorerUrl
+	case common.ChainETH:
+		explorerUrl = cfg.ChainConfig.ETHExplorerUrl
+	case common.ChainMATIC:
+		explorerUrl = cfg.ChainConfig.MATICExplorerUrl
+	}
+	if explorerUrl == "" {
+		explorerUrl = cfg.EnvironmentConfig.GetProfile().ExplorerUrls[chain]
+	}
+	return explorerUrl
+}
+
+// GetExplorerTemplates returns the explorer pages of the chain, the ones of explorer_config first, the others are
+// derived from the explorer url of the chain
+func (cfg *Config) GetExplorerTemplates(chain string) ExplorerTemplates {
+	templates := explorerTemplatesOfTxUrl(cfg.getExplorerUrl(chain))
+	override := cfg.ExplorerConfig.Templates[chain]
+	if override.Tx != "" {
+		templates.Tx = override.Tx
+	}
+	if override.Address != "" {
+		templates.Address = override.Address
+	}
+	if override.Block != "" {
+		templates.Block = override.Block
+	}
+	return templates
+}
+
+// ExplorerLink returns the link of the explorer page of the tx hash, the address or the block number of the chain,
+// empty if the explorer of the chain has no page of the kind
+func (cfg *Config) ExplorerLink(chain, kind, hash string) string {
+	return cfg.GetExplorerTemplates(chain).Link(kind, hash)
+}
+
+func (cfg *Config) Validate() {
+	panicOnErrors(cfg.Check())
+}
+
+func panicOnErrors(errs []string) {
+	if len(errs) > 0 {
+		panic(fmt.Sprintf("invalid config:\n  %s", strings.Join(errs, "\n  ")))
+	}
+}
+
+type AlertConfig struct {
+	TelegramBotId  string `json:"telegram_bot_id"`
+	TelegramChatId string `json:"telegram_chat_id"`
+
+	BlockUpdateTimeout int64 `json:"block_update_timeout"`
+
+	// an alert is sent once every DedupWindow seconds, its repeats are counted and sent as a summary. The alerts are
+	// batched every BatchInterval seconds, the urgent alerts at once, and at most MaxPerMinute telegram messages are
+	// sent a minute.
+	DedupWindow   int64 `json:"dedup_window"`
+	BatchInterval int64 `json:"batch_interval"`
+	MaxPerMinute  int64 `json:"max_per_minute"`
+}
+
+func (cfg AlertConfig) Check() []string {
+	errs := make([]string, 0)
+	if cfg.BlockUpdateTimeout <= 0 {
+		errs = append(errs, "block_update_timeout should be larger than 0")
+	}
+	for name, value := range map[string]int64{
+		"dedup_window":   cfg.DedupWindow,
+		"batch_interval": cfg.BatchInterval,
+		"max_per_minute": cfg.MaxPerMinute,
+	} {
+		if value < 0 {
+			errs = append(errs, fmt.Sprintf("%s of alert_config should not be less than 0", name))
+		}
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+const (
+	DefaultAlertDedupWindow   int64 = 300
+	DefaultAlertBatchInterval int64 = 10
+	DefaultAlertMaxPerMinute  int64 = 20
+)
+
+func (cfg AlertConfig) GetDedupWindow() time.Duration {
+	return intervalOrDefault(cfg.DedupWindow, DefaultAlertDedupWindow)
+}
+
+func (cfg AlertConfig) GetBatchInterval() time.Duration {
+	return intervalOrDefault(cfg.BatchInterval, DefaultAlertBatchInterval)
+}
+
+func (cfg AlertConfig) GetMaxPerMinute() int {
+	if cfg.MaxPerMinute <= 0 {
+		return int(DefaultAlertMaxPerMinute)
+	}
+	return int(cfg.MaxPerMinute)
+}
+
+func (cfg AlertConfig) Validate() {
+	panicOnErrors(cfg.Check())
+}
+
+const (
+	DefaultWebhookInterval          int64 = 5
+	DefaultWebhookTimeout           int64 = 10
+	DefaultWebhookMaxAttempts       int64 = 10
+	DefaultWebhookBackoffSeconds    int64 = 10
+	DefaultWebhookMaxBackoffSeconds int64 = 3600
+)
+
+// WebhookConfig configures the calls of the integrator webhooks, all the fields are optional. A failed call is
+// retried the same way as the failed swaps, see RetryPolicyConfig.
+type WebhookConfig struct {
+	Interval          int64 `json:"interval"`
+	Timeout           int64 `json:"timeout"`
+	MaxAttempts       int64 `json:"max_attempts"`
+	BackoffSeconds    int64 `json:"backoff_seconds"`
+	MaxBackoffSeconds int64 `json:"max_backoff_seconds"`
+	// the webhook urls may reach the loopback and the private ips, e.g. for the tests, they may only reach the
+	// public ips otherwise
+	AllowPrivateUrls bool `json:"allow_private_urls"`
+}
+
+func (cfg WebhookConfig) Check() []string {
+	errs := make([]string, 0)
+	for name, value := range map[string]int64{
+		"interval":            cfg.Interval,
+		"timeout":             cfg.Timeout,
+		"max_attempts":        cfg.MaxAttempts,
+		"backoff_seconds":     cfg.BackoffSeconds,
+		"max_backoff_seconds": cfg.MaxBackoffSeconds,
+	} {
+		if value < 0 {
+			errs = append(errs, fmt.Sprintf("%s of webhook_config should not be less than 0", name))
+		}
+	}
+	if cfg.Interval > MaxDaemonInterval {
+		errs = append(errs, fmt.Sprintf("interval of webhook_config should not be larger than %d", MaxDaemonInterval))
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+func (cfg WebhookConfig) GetInterval() time.Duration {
+	return intervalOrDefault(cfg.Interval, DefaultWebhookInterval)
+}
+
+func (cfg WebhookConfig) GetTimeout() time.Duration {
+	return intervalOrDefault(cfg.Timeout, DefaultWebhookTimeout)
+}
+
+// GetRetryPolicy returns the retry policy of the failed webhook calls
+func (cfg WebhookConfig) GetRetryPolicy() RetryPolicyConfig {
+	policy := RetryPolicyConfig{
+		MaxAttempts:       cfg.MaxAttempts,
+		BackoffSeconds:    cfg.BackoffSeconds,
+		MaxBackoffSeconds: cfg.MaxBackoffSeconds,
+	}
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = DefaultWebhookMaxAttempts
+	}
+	if policy.BackoffSeconds <= 0 {
+		policy.BackoffSeconds = DefaultWebhookBackoffSeconds
+	}
+	if policy.MaxBackoffSeconds < policy.BackoffSeconds {
+		policy.MaxBackoffSeconds = DefaultWebhookMaxBackoffSeconds
+	}
+	return policy
+}
+
+const (
+	DefaultAnomalyInterval       int64 = 60
+	DefaultAnomalyWindow         int64 = 300
+	DefaultAnomalyTrailingPeriod int64 = 86400
+	DefaultAnomalyMinSwaps       int64 = 10
+)
+
+// AnomalyConfig pauses a swap pair automatically if the number or the volume of its swaps in the last Window
+// seconds is larger than RateMultiple or VolumeMultiple times the average of a window over the TrailingPeriod
+// before it. Windows with less than MinSwaps swaps are not checked. A pair is also paused if the usd value of its
+// swaps in the last Window is larger than MaxVolumeUsd, however many swaps there are. The detector is disabled if
+// the multiples and MaxVolumeUsd are 0, the paused pairs are only resumed by the admin.
+type AnomalyConfig struct {
+	Interval       int64   `json:"interval"`
+	Window         int64   `json:"window"`
+	TrailingPeriod int64   `json:"trailing_period"`
+	RateMultiple   float64 `json:"rate_multiple"`
+	VolumeMultiple float64 `json:"volume_multiple"`
+	MinSwaps       int64   `json:"min_swaps"`
+	MaxVolumeUsd   float64 `json:"max_volume_usd"`
+}
+
+func (cfg AnomalyConfig) Check() []string {
+	errs := make([]string, 0)
+	for name, value := range map[string]int64{
+		"interval":        cfg.Interval,
+		"window":          cfg.Window,
+		"trailing_period": cfg.TrailingPeriod,
+		"min_swaps":       cfg.MinSwaps,
+	} {
+		if value < 0 {
+			errs = append(errs, fmt.Sprintf("%s of anomaly_config should not be less than 0", name))
+		}
+	}
+	if cfg.RateMultiple != 0 && cfg.RateMultiple <= 1 {
+		errs = append(errs, "rate_multiple of anomaly_config should be 0 or larger than 1")
+	}
+	if cfg.VolumeMultiple != 0 && cfg.VolumeMultiple <= 1 {
+		errs = append(errs, "volume_multiple of anomaly_config should be 0 or larger than 1")
+	}
+	if cfg.MaxVolumeUsd < 0 {
+		errs = append(errs, "max_volume_usd of anomaly_config should not be less than 0")
+	}
+	if cfg.Interval > MaxDaemonInterval {
+		errs = append(errs, fmt.Sprintf("interval of anomaly_config should not be larger than %d", MaxDaemonInterval))
+	}
+	if cfg.GetTrailingPeriod() < cfg.GetWindow() {
+		errs = append(errs, "trailing_period of anomaly_config should not be less than window")
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+func (cfg AnomalyConfig) Enabled() bool {
+	return cfg.RateMultiple > 0 || cfg.VolumeMultiple > 0 || cfg.MaxVolumeUsd > 0
+}
+
+func (cfg AnomalyConfig) GetInterval() time.Duration {
+	return intervalOrDefault(cfg.Interval, DefaultAnomalyInterval)
+}
+
+func (cfg AnomalyConfig) GetWindow() time.Duration {
+	return intervalOrDefault(cfg.Window, DefaultAnomalyWindow)
+}
+
+func (cfg AnomalyConfig) GetTrailingPeriod() time.Duration {
+	return intervalOrDefault(cfg.TrailingPeriod, DefaultAnomalyTrailingPeriod)
+}
+
+func (cfg AnomalyConfig) GetMinSwaps() int64 {
+	if cfg.MinSwaps <= 0 {
+		return DefaultAnomalyMinSwaps
+	}
+	return cfg.MinSwaps
+}
+
+const (
+	QueueTypeRedis = "redis"
+
+	DefaultQueueKey                = "swap_start_events"
+	DefaultQueuePollInterval int64 = 60
+)
+
+// QueueConfig enables the work queue between the observers and the swap engine. The observers push the confirmed
+// swap start events to the queue and the engine handles them at once, the db is still polled every PollInterval
+// seconds in case an event is lost. The queue is disabled if Type is empty.
+type QueueConfig struct {
+	Type          string `json:"type"`
+	RedisAddr     string `json:"redis_addr"`
+	RedisPassword string `json:"redis_password"`
+	RedisDB       int    `json:"redis_db"`
+	Key           string `json:"key"`
+	PollInterval  int64  `json:"poll_interval"`
+}
+
+func (cfg QueueConfig) Check() []string {
+	errs := make([]string, 0)
+	if !cfg.Enabled() {
+		return errs
+	}
+	if cfg.Type != QueueTypeRedis {
+		errs = append(errs, fmt.Sprintf("type of queue_config should be empty or %s", QueueTypeRedis))
+	}
+	if cfg.RedisAddr == "" {
+		errs = append(errs, "redis_addr of queue_config should not be empty")
+	}
+	if cfg.RedisDB < 0 {
+		errs = append(errs, "redis_db of queue_config should not be less than 0")
+	}
+	if cfg.PollInterval < 0 {
+		errs = append(errs, "poll_interval of queue_config should not be less than 0")
+	}
+	if cfg.PollInterval > MaxDaemonInterval {
+		errs = append(errs, fmt.Sprintf("poll_interval of queue_config should not be larger than %d", MaxDaemonInterval))
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+func (cfg QueueConfig) Enabled() bool {
+	return cfg.Type != ""
+}
+
+func (cfg QueueConfig) GetKey() string {
+	if cfg.Key == "" {
+		return DefaultQueueKey
+	}
+	return cfg.Key
+}
+
+func (cfg QueueConfig) GetPollInterval() time.Duration {
+	return intervalOrDefault(cfg.PollInterval, DefaultQueuePollInterval)
+}
+
+const (
+	DefaultArchiveInterval  int64 = 3600
+	DefaultArchiveBatchSize int64 = 500
+)
+
+// ArchiveConfig enables the retention policy of the swaps, the swaps which succeeded or were rejected more than
+// RetentionDays ago are moved to the archive tables with their start tx logs and fill txs, every Interval seconds
+// and at most BatchSize swaps a tx. The swaps are kept in the hot tables if RetentionDays is 0.
+type ArchiveConfig struct {
+	RetentionDays int64 `json:"retention_days"`
+	Interval      int64 `json:"interval"`
+	BatchSize     int64 `json:"batch_size"`
+}
+
+func (cfg ArchiveConfig) Check() []string {
+	errs := make([]string, 0)
+	for name, value := range map[string]int64{
+		"retention_days": cfg.RetentionDays,
+		"interval":       cfg.Interval,
+		"batch_size":     cfg.BatchSize,
+	} {
+		if value < 0 {
+			errs = append(errs, fmt.Sprintf("%s of archive_config should not be less than 0", name))
+		}
+	}
+	if cfg.Interval > MaxDaemonInterval {
+		errs = append(errs, fmt.Sprintf("interval of archive_config should not be larger than %d", MaxDaemonInterval))
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+func (cfg ArchiveConfig) Enabled() bool {
+	return cfg.RetentionDays > 0
+}
+
+func (cfg ArchiveConfig) GetRetention() time.Duration {
+	return time.Duration(cfg.RetentionDays) * 24 * time.Hour
+}
+
+// ScreeningConfig screens the accounts paid by the fills, the swaps to a BlockedAddresses recipient are rejected
+// when they are created, and they are not filled if the address is blocked later
+type ScreeningConfig struct {
+	BlockedAddresses []string `json:"blocked_addresses"`
+}
+
+func (cfg ScreeningConfig) Check() []string {
+	errs := make([]string, 0)
+	for _, addr := range cfg.BlockedAddresses {
+		if !ethcom.IsHexAddress(addr) {
+			errs = append(errs, fmt.Sprintf("invalid address %s in blocked_addresses of screening_config", addr))
+		}
+	}
+	return errs
+}
+
+// IsBlocked returns whether the address is in the blocked addresses, regardless of its case
+func (cfg ScreeningConfig) IsBlocked(addr ethcom.Address) bool {
+	for _, blocked := range cfg.BlockedAddresses {
+		if ethcom.HexToAddress(blocked) == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg ArchiveConfig) GetInterval() time.Duration {
+	return intervalOrDefault(cfg.Interval, DefaultArchiveInterval)
+}
+
+func (cfg ArchiveConfig) GetBatchSize() int64 {
+	if cfg.BatchSize <= 0 {
+		return DefaultArchiveBatchSize
+	}
+	return cfg.BatchSize
+}
+
+const (
+	DefaultRelayInterval         int64 = 5
+	DefaultRelayMaxSwapsPerOwner int64 = 3
+	DefaultRelayMaxSwapsPerIP    int64 = 10
+)
+
+// RelayConfig enables the swaps started by the relayers with the EIP-2612 permits signed by the owners of the
+// tokens, on the chains whose tokens support permit. The relayers of the chain pay the gas and the swap fee, and
+// keep the fee of the chain out of the permitted amount for them. The relayed swaps of an owner and of an ip are
+// limited to MaxSwapsPerOwner and MaxSwapsPerIP an hour.
+type RelayConfig struct {
+	Chains           []string   `json:"chains"`
+	Interval         int64      `json:"interval"`
+	Fees             []RelayFee `json:"fees"`
+	MaxSwapsPerOwner int64      `json:"max_swaps_per_owner"`
+	MaxSwapsPerIP    int64      `json:"max_swaps_per_ip"`
+}
+
+// RelayFee is the smallest amount the owners may permit for a relayed swap on the chain, and the part of it the
+// relayer keeps for the gas of the permit, pull, approve and swap txs and the swap fee, in the smallest unit of the
+// token. The swap is started with the rest.
+type RelayFee struct {
+	Chain     string `json:"chain"`
+	MinAmount string `json:"min_amount"`
+	Fee       string `json:"fee"`
+}
+
+func (cfg RelayConfig) Check() []string {
+	errs := make([]string, 0)
+	for _, chain := range cfg.Chains {
+		if chain != common.ChainBSC && chain != common.ChainETH && chain != common.ChainMATIC {
+			errs = append(errs, fmt.Sprintf("unknown chain %s in chains of relay_config", chain))
+		}
+		if _, ok := cfg.GetFee(chain); !ok {
+			errs = append(errs, fmt.Sprintf("no fee of %s in fees of relay_config", chain))
+		}
+	}
+	seen := make(map[string]bool)
+	for _, fee := range cfg.Fees {
+		if seen[fee.Chain] {
+			errs = append(errs, fmt.Sprintf("duplicated fee of %s in fees of relay_config", fee.Chain))
+		}
+		seen[fee.Chain] = true
+		minAmount, ok := big.NewInt(0).SetString(fee.MinAmount, 10)
+		if !ok || minAmount.Sign() <= 0 {
+			errs = append(errs, fmt.Sprintf("min_amount of %s in fees of relay_config should be a positive integer", fee.Chain))
+			continue
+		}
+		amount, ok := big.NewInt(0).SetString(fee.Fee, 10)
+		if !ok || amount.Sign() < 0 {
+			errs = append(errs, fmt.Sprintf("fee of %s in fees of relay_config should be a non-negative integer", fee.Chain))
+		} else if amount.Cmp(minAmount) >= 0 {
+			errs = append(errs, fmt.Sprintf("fee of %s in fees of relay_config should be less than min_amount", fee.Chain))
+		}
+	}
+	if cfg.Interval < 0 {
+		errs = append(errs, "interval of relay_config should not be less than 0")
+	}
+	if cfg.Interval > MaxDaemonInterval {
+		errs = append(errs, fmt.Sprintf("interval of relay_config should not be larger than %d", MaxDaemonInterval))
+	}
+	if cfg.MaxSwapsPerOwner < 0 {
+		errs = append(errs, "max_swaps_per_owner of relay_config should not be less than 0")
+	}
+	if cfg.MaxSwapsPerIP < 0 {
+		errs = append(errs, "max_swaps_per_ip of relay_config should not be less than 0")
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+// GetFee returns the fee of the relayed swaps started on the chain
+func (cfg RelayConfig) GetFee(chain string) (RelayFee, bool) {
+	for _, fee := range cfg.Fees {
+		if fee.Chain == chain {
+			return fee, true
+		}
+	}
+	return RelayFee{}, false
+}
+
+func (cfg RelayConfig) GetMaxSwapsPerOwner() int64 {
+	if cfg.MaxSwapsPerOwner <= 0 {
+		return DefaultRelayMaxSwapsPerOwner
+	}
+	return cfg.MaxSwapsPerOwner
+}
+
+func (cfg RelayConfig) GetMaxSwapsPerIP() int64 {
+	if cfg.MaxSwapsPerIP <= 0 {
+		return DefaultRelayMaxSwapsPerIP
+	}
+	return cfg.MaxSwapsPerIP
+}
+
+func (fee RelayFee) GetMinAmount() *big.Int {
+	amount, _ := big.NewInt(0).SetString(fee.MinAmount, 10)
+	return amount
+}
+
+func (fee RelayFee) GetFee() *big.Int {
+	amount, _ := big.NewInt(0).SetString(fee.Fee, 10)
+	return amount
+}
+
+func (cfg RelayConfig) Enabled() bool {
+	return len(cfg.Chains) != 0
+}
+
+// IsRelayChain returns whether the swaps started on the chain can be relayed
+func (cfg RelayConfig) IsRelayChain(chain string) bool {
+	for _, c := range cfg.Chains {
+		if c == chain {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg RelayConfig) GetInterval() time.Duration {
+	return intervalOrDefault(cfg.Interval, DefaultRelayInterval)
+}
+
+const (
+	// CanonicalPermit2Addr is the address permit2 is deployed at on the chains by its deterministic deployment
+	CanonicalPermit2Addr = "0x000000000022D473030F116dDEE9F6B43aC78BA3"
+	// DefaultPermit2Deadline is how long in seconds the permits built for the owners are valid
+	DefaultPermit2Deadline int64 = 1800
+)
+
+// Permit2Config enables the single tx swap starts on the Chains whose swap agent has swapWithPermit2, for the owners
+// who approved permit2 rather than the swap agent. The owner signs a permit2 transfer of the amount to the swap agent
+// and sends the swapWithPermit2 tx, the swap agent pulls the tokens through permit2 and emits SwapPermit2Started,
+// which is observed like SwapStarted. Permit2Addr is the permit2 contract of a chain, key is the chain name,
+// CanonicalPermit2Addr by default, and the permits are valid for DeadlineSeconds.
+type Permit2Config struct {
+	Chains          []string          `json:"chains"`
+	Permit2Addr     map[string]string `json:"permit2_addr"`
+	DeadlineSeconds int64             `json:"deadline_seconds"`
+}
+
+func (cfg Permit2Config) Check() []string {
+	errs := make([]string, 0)
+	for _, chain := range cfg.Chains {
+		if chain != common.ChainBSC && chain != common.ChainETH && chain != common.ChainMATIC {
+			errs = append(errs, fmt.Sprintf("unknown chain %s in chains of permit2_config", chain))
+		}
+	}
+	for chain, addr := range cfg.Permit2Addr {
+		if chain != common.ChainBSC && chain != common.ChainETH && chain != common.ChainMATIC {
+			errs = append(errs, fmt.Sprintf("unknown chain %s in permit2_addr of permit2_config", chain))
+		}
+		if !ethcom.IsHexAddress(addr) {
+			errs = append(errs, fmt.Sprintf("invalid permit2_addr of %s in permit2_config: %s", chain, addr))
+		}
+	}
+	if cfg.DeadlineSeconds < 0 {
+		errs = append(errs, "deadline_seconds of permit2_config should not be less than 0")
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+// IsPermit2Chain returns whether the swaps on the chain can be started with a permit2 signature
+func (cfg Permit2Config) IsPermit2Chain(chain string) bool {
+	for _, c := range cfg.Chains {
+		if c == chain {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg Permit2Config) GetPermit2Addr(chain string) ethcom.Address {
+	if addr, ok := cfg.Permit2Addr[chain]; ok {
+		return ethcom.HexToAddress(addr)
+	}
+	return ethcom.HexToAddress(CanonicalPermit2Addr)
+}
+
+func (cfg Permit2Config) GetDeadline() time.Duration {
+	return intervalOrDefault(cfg.DeadlineSeconds, DefaultPermit2Deadline)
+}
+
+// the risk classes of the pairs
+const (
+	RiskClassLow    = "low"
+	RiskClassMedium = "medium"
+	RiskClassHigh   = "high"
+)
+
+// RiskClassConfig declares the risk classes of the pairs, the swap start txs of a pair are confirmed once they have
+// the confirm number of the risk class of the pair on the source chain, e.g. 64 blocks of eth for the high value
+// tokens. Key of Pairs is the erc20 address of the pair, key of ConfirmNums is the risk class then the chain name.
+// The confirm number of the chain in chain_config is the least whatever the risk class, a pair without a risk
+// class or a risk class without a confirm number of the chain waits for it alone.
+type RiskClassConfig struct {
+	Pairs       map[string]string           `json:"pairs"`
+	ConfirmNums map[string]map[string]int64 `json:"confirm_nums"`
+}
+
+func isRiskClass(riskClass string) bool {
+	return riskClass == RiskClassLow || riskClass == RiskClassMedium || riskClass == RiskClassHigh
+}
+
+func (cfg RiskClassConfig) Check() []string {
+	errs := make([]string, 0)
+	seen := make(map[string]bool)
+	for erc20Addr, riskClass := range cfg.Pairs {
+		if !ethcom.IsHexAddress(erc20Addr) {
+			errs = append(errs, fmt.Sprintf("invalid erc20 address in pairs of risk_class_config: %s", erc20Addr))
+		} else if seen[strings.ToLower(erc20Addr)] {
+			errs = append(errs, fmt.Sprintf("duplicated erc20 address in pairs of risk_class_config: %s", erc20Addr))
+		}
+		seen[strings.ToLower(erc20Addr)] = true
+		if !isRiskClass(riskClass) {
+			errs = append(errs, fmt.Sprintf("risk class of pair %s in risk_class_config should be %s, %s or %s", erc20Addr,
+				RiskClassLow, RiskClassMedium, RiskClassHigh))
+		}
+	}
+	for riskClass, confirmNums := range cfg.ConfirmNums {
+		if !isRiskClass(riskClass) {
+			errs = append(errs, fmt.Sprintf("unknown risk class %s in confirm_nums of risk_class_config", riskClass))
+		}
+		for chain, confirmNum := range confirmNums {
+			if chain != common.ChainBSC && chain != common.ChainETH && chain != common.ChainMATIC {
+				errs = append(errs, fmt.Sprintf("unknown chain %s in confirm_nums of %s in risk_class_config", chain, riskClass))
+			}
+			if confirmNum < 0 {
+				errs = append(errs, fmt.Sprintf("confirm_nums of %s on %s in risk_class_config should not be less than 0", riskClass, chain))
+			}
+		}
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+// GetRiskClass returns the risk class of the pair of the erc20 address, empty if the pair doesn't have one
+func (cfg RiskClassConfig) GetRiskClass(erc20Addr string) string {
+	for addr, riskClass := range cfg.Pairs {
+		if strings.EqualFold(addr, erc20Addr) {
+			return riskClass
+		}
+	}
+	return ""
+}
+
+// GetConfirmNum returns the confirmations the swap start txs of the pair of the erc20 address wait for on the
+// chain, which confirms the swap start txs after chainConfirmNum blocks
+func (cfg RiskClassConfig) GetConfirmNum(erc20Addr, chain string, chainConfirmNum int64) int64 {
+	if confirmNum := cfg.ConfirmNums[cfg.GetRiskClass(erc20Addr)][chain]; confirmNum > chainConfirmNum {
+		return confirmNum
+	}
+	return chainConfirmNum
+}
+
+const DefaultPeggedTokenInterval int64 = 10
+
+// PeggedTokenConfig has the factory contracts deploying the pegged tokens, key is the chain name. A swap pair whose
+// token doesn't exist on the chain yet can be registered with the token deployed by the factory, the relayer of the
+// chain calls createPeggedToken and the swap agent of the chain is made the minter of the token.
+type PeggedTokenConfig struct {
+	Factories map[string]string `json:"factories"`
+	Interval  int64             `json:"interval"`
+}
+
+func (cfg PeggedTokenConfig) Check() []string {
+	errs := make([]string, 0)
+	for chain, factory := range cfg.Factories {
+		if chain != common.ChainBSC && chain != common.ChainETH && chain != common.ChainMATIC {
+			errs = append(errs, fmt.Sprintf("unknown chain %s in factories of pegged_token_config", chain))
+		}
+		if !ethcom.IsHexAddress(factory) {
+			errs = append(errs, fmt.Sprintf("invalid factory %s of %s in pegged_token_config", factory, chain))
+		}
+	}
+	if cfg.Interval < 0 {
+		errs = append(errs, "interval of pegged_token_config should not be less than 0")
+	}
+	if cfg.Interval > MaxDaemonInterval {
+		errs = append(errs, fmt.Sprintf("interval of pegged_token_config should not be larger than %d", MaxDaemonInterval))
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+func (cfg PeggedTokenConfig) Enabled() bool {
+	return len(cfg.Factories) != 0
+}
+
+// GetFactory returns the factory of the pegged tokens on the chain, false if the tokens aren't deployed on it
+func (cfg PeggedTokenConfig) GetFactory(chain string) (ethcom.Address, bool) {
+	factory, ok := cfg.Factories[chain]
+	if !ok {
+		return ethcom.Address{}, false
+	}
+	return ethcom.HexToAddress(factory), true
+}
+
+func (cfg PeggedTokenConfig) GetInterval() time.Duration {
+	return intervalOrDefault(cfg.Interval, DefaultPeggedTokenInterval)
+}
+
+const DefaultExpiryInterval int64 = 600
+
+// ExpiryConfig expires the swaps which couldn't be filled MaxAgeHours hours after they were confirmed, e.g. their
+// direction was paused for days, so they don't fill suddenly after a config change. The expired swaps are not
+// filled or retried any more and they are refunded by the operators. The swaps are checked every Interval seconds,
+// the swaps never expire if MaxAgeHours is 0.
+type ExpiryConfig struct {
+	MaxAgeHours int64 `json:"max_age_hours"`
+	Interval    int64 `json:"interval"`
+}
+
+func (cfg ExpiryConfig) Check() []string {
+	errs := make([]string, 0)
+	if cfg.MaxAgeHours < 0 {
+		errs = append(errs, "max_age_hours of expiry_config should not be less than 0")
+	}
+	if cfg.Interval < 0 {
+		errs = append(errs, "interval of expiry_config should not be less than 0")
+	}
+	if cfg.Interval > MaxDaemonInterval {
+		errs = append(errs, fmt.Sprintf("interval of expiry_config should not be larger than %d", MaxDaemonInterval))
+	}
+	return errs
+}
+
+func (cfg ExpiryConfig) Enabled() bool {
+	return cfg.MaxAgeHours > 0
+}
+
+func (cfg ExpiryConfig) GetMaxAge() time.Duration {
+	return time.Duration(cfg.MaxAgeHours) * time.Hour
+}
+
+func (cfg ExpiryConfig) GetInterval() time.Duration {
+	return intervalOrDefault(cfg.Interval, DefaultExpiryInterval)
+}
+
+// DrainBrakeConfig halts the fills to a chain once a relayer account of the chain sends more than a ceiling within
+// the last hour, whatever the limits of the pairs, as the last brake on a drain. MaxNativePerHour is the ceiling of
+// the native coin sent by an account with the txs and their gas, in wei, key is the chain name. MaxTokenPerHour is
+// the ceiling of the tokens of a pair filled by an account, in the smallest unit, key is the erc20 address of the
+// pair. The halted chains are released by the admin, nothing is halted without the ceilings.
+type DrainBrakeConfig struct {
+	MaxNativePerHour map[string]string `json:"max_native_per_hour"`
+	MaxTokenPerHour  map[string]string `json:"max_token_per_hour"`
+}
+
+func (cfg DrainBrakeConfig) Check() []string {
+	errs := make([]string, 0)
+	for chain, ceiling := range cfg.MaxNativePerHour {
+		if chain != common.ChainBSC && chain != common.ChainETH && chain != common.ChainMATIC {
+			errs = append(errs, fmt.Sprintf("unknown chain %s in max_native_per_hour of drain_brake_config", chain))
+		}
+		if value, ok := big.NewInt(0).SetString(ceiling, 10); !ok || value.Sign() <= 0 {
+			errs = append(errs, fmt.Sprintf("max_native_per_hour of %s in drain_brake_config should be a positive integer", chain))
+		}
+	}
+	for erc20Addr, ceiling := range cfg.MaxTokenPerHour {
+		if !ethcom.IsHexAddress(erc20Addr) {
+			errs = append(errs, fmt.Sprintf("invalid erc20 address %s in max_token_per_hour of drain_brake_config", erc20Addr))
+		}
+		if value, ok := big.NewInt(0).SetString(ceiling, 10); !ok || value.Sign() <= 0 {
+			errs = append(errs, fmt.Sprintf("max_token_per_hour of %s in drain_brake_config should be a positive integer", erc20Addr))
+		}
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+func (cfg DrainBrakeConfig) Enabled() bool {
+	return len(cfg.MaxNativePerHour) != 0 || len(cfg.MaxTokenPerHour) != 0
+}
+
+// GetMaxNativePerHour returns the ceiling of the native coin sent by a relayer account of the chain, nil if the
+// chain has none
+func (cfg DrainBrakeConfig) GetMaxNativePerHour(chain string) *big.Int {
+	ceiling, ok := big.NewInt(0).SetString(cfg.MaxNativePerHour[chain], 10)
+	if !ok {
+		return nil
+	}
+	return ceiling
+}
+
+// GetMaxTokenPerHour returns the ceiling of the tokens of the pair filled by a relayer account, nil if the pair has
+// none
+func (cfg DrainBrakeConfig) GetMaxTokenPerHour(erc20Addr ethcom.Address) *big.Int {
+	for addr, ceiling := range cfg.MaxTokenPerHour {
+		if ethcom.HexToAddress(addr) != erc20Addr {
+			continue
+		}
+		if value, ok := big.NewInt(0).SetString(ceiling, 10); ok {
+			return value
+		}
+	}
+	return nil
+}
+
+const DefaultWithdrawalLimitInterval int64 = 60
+
+// WithdrawalLimitConfig caps the tokens filled to a chain within a utc day, whatever the limits of the pairs, like the
+// daily limit of a safe. MaxPerDay is the cap in the smallest unit of the token of the swap agent of the chain, key
+// is the chain name. The swaps above the remaining allowance of the day wait in awaiting_window, every Interval
+// seconds they are released in order as long as the allowance covers them, so they are filled from the next day.
+// Nothing is capped without the caps.
+type WithdrawalLimitConfig struct {
+	Interval  int64             `json:"interval"`
+	MaxPerDay map[string]string `json:"max_per_day"`
+}
+
+func (cfg WithdrawalLimitConfig) Check() []string {
+	errs := make([]string, 0)
+	if cfg.Interval < 0 {
+		errs = append(errs, "interval of withdrawal_limit_config should not be less than 0")
+	}
+	if cfg.Interval > MaxDaemonInterval {
+		errs = append(errs, fmt.Sprintf("interval of withdrawal_limit_config should not be larger than %d", MaxDaemonInterval))
+	}
+	for chain, limit := range cfg.MaxPerDay {
+		if chain != common.ChainBSC && chain != common.ChainETH && chain != common.ChainMATIC {
+			errs = append(errs, fmt.Sprintf("unknown chain %s in max_per_day of withdrawal_limit_config", chain))
+		}
+		if value, ok := big.NewInt(0).SetString(limit, 10); !ok || value.Sign() <= 0 {
+			errs = append(errs, fmt.Sprintf("max_per_day of %s in withdrawal_limit_config should be a positive integer", chain))
+		}
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+func (cfg WithdrawalLimitConfig) Enabled() bool {
+	return len(cfg.MaxPerDay) != 0
+}
+
+func (cfg WithdrawalLimitConfig) GetInterval() time.Duration {
+	return intervalOrDefault(cfg.Interval, DefaultWithdrawalLimitInterval)
+}
+
+// GetMaxPerDay returns the daily cap of the tokens filled to the chain, nil if the chain has none
+func (cfg WithdrawalLimitConfig) GetMaxPerDay(chain string) *big.Int {
+	limit, ok := big.NewInt(0).SetString(cfg.MaxPerDay[chain], 10)
+	if !ok {
+		return nil
+	}
+	return limit
+}
+
+const DefaultGasGuardInterval int64 = 30
+
+const (
+	ExplorerTx      = "tx"
+	ExplorerAddress = "address"
+	ExplorerBlock   = "block"
+
+	// ExplorerHashPlaceholder is replaced by the tx hash, the address or the block number in the explorer templates
+	ExplorerHashPlaceholder = "{hash}"
+)
+
+// ExplorerTemplates are the urls of the tx, address and block pages of an explorer, with ExplorerHashPlaceholder
+type ExplorerTemplates struct {
+	Tx      string `json:"tx"`
+	Address string `json:"address"`
+	Block   string `json:"block"`
+}
+
+// explorerTemplatesOfTxUrl derives the pages from the explorer url of the txs, e.g. https://bscscan.com/tx, the
+// address and the block pages are only known if it ends with /tx
+func explorerTemplatesOfTxUrl(txUrl string) ExplorerTemplates {
+	txUrl = strings.TrimRight(txUrl, "/")
+	if txUrl == "" {
+		return ExplorerTemplates{}
+	}
+	templates := ExplorerTemplates{Tx: txUrl + "/" + ExplorerHashPlaceholder}
+	if base := strings.TrimSuffix(txUrl, "/tx"); base != txUrl {
+		templates.Address = base + "/address/" + ExplorerHashPlaceholder
+		templates.Block = base + "/block/" + ExplorerHashPlaceholder
+	}
+	return templates
+}
+
+// Link returns the link of the page of the kind, empty if there is no such page
+func (templates ExplorerTemplates) Link(kind, hash string) string {
+	var template string
+	switch kind {
+	case ExplorerTx:
+		template = templates.Tx
+	case ExplorerAddress:
+		template = templates.Address
+	case ExplorerBlock:
+		template = templates.Block
+	}
+	if template == "" || hash == "" {
+		return ""
+	}
+	return strings.Replace(template, ExplorerHashPlaceholder, hash, -1)
+}
+
+// ExplorerConfig sets the pages of the explorers of the chains, key is the chain name. The pages not set are derived
+// from the explorer urls of chain_config or of the environment profile.
+type ExplorerConfig struct {
+	Templates map[string]ExplorerTemplates `json:"templates"`
+}
+
+func (cfg ExplorerConfig) Check() []string {
+	errs := make([]string, 0)
+	for chain, templates := range cfg.Templates {
+		if chain != common.ChainBSC && chain != common.ChainETH && chain != common.ChainMATIC {
+			errs = append(errs, fmt.Sprintf("unknown chain %s in templates of explorer_config", chain))
+			continue
+		}
+		for kind, template := range map[string]string{ExplorerTx: templates.Tx, ExplorerAddress: templates.Address, ExplorerBlock: templates.Block} {
+			if template != "" && !strings.Contains(template, ExplorerHashPlaceholder) {
+				errs = append(errs, fmt.Sprintf("%s template of %s in explorer_config should contain %s", kind, chain, ExplorerHashPlaceholder))
+			}
+		}
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+// GasGuardConfig holds the fills to a chain while its gas price spikes instead of paying the spike. Every Interval
+// seconds the gas price of the chain is queried, once it is above MaxGasPrice the swaps to the chain wait in
+// awaiting_gas, and once it falls to ResumeGasPrice they are filled again. ResumeGasPrice is below MaxGasPrice so the
+// fills don't flap around the ceiling, it is MaxGasPrice without one. The prices are in wei, key is the chain name.
+// Nothing is held without the ceilings.
+type GasGuardConfig struct {
+	Interval       int64             `json:"interval"`
+	MaxGasPrice    map[string]string `json:"max_gas_price"`
+	ResumeGasPrice map[string]string `json:"resume_gas_price"`
+}
+
+func (cfg GasGuardConfig) Check() []string {
+	errs := make([]string, 0)
+	if cfg.Interval < 0 {
+		errs = append(errs, "interval of gas_guard_config should not be less than 0")
+	}
+	if cfg.Interval > MaxDaemonInterval {
+		errs = append(errs, fmt.Sprintf("interval of gas_guard_config should not be larger than %d", MaxDaemonInterval))
+	}
+	for chain, maxGasPrice := range cfg.MaxGasPrice {
+		if chain != common.ChainBSC && chain != common.ChainETH && chain != common.ChainMATIC {
+			errs = append(errs, fmt.Sprintf("unknown chain %s in max_gas_price of gas_guard_config", chain))
+		}
+		if value, ok := big.NewInt(0).SetString(maxGasPrice, 10); !ok || value.Sign() <= 0 {
+			errs = append(errs, fmt.Sprintf("max_gas_price of %s in gas_guard_config should be a positive integer", chain))
+		}
+	}
+	for chain, resumeGasPrice := range cfg.ResumeGasPrice {
+		maxGasPrice := cfg.GetMaxGasPrice(chain)
+		if maxGasPrice == nil {
+			errs = append(errs, fmt.Sprintf("resume_gas_price of %s in gas_guard_config has no max_gas_price", chain))
+			continue
+		}
+		value, ok := big.NewInt(0).SetString(resumeGasPrice, 10)
+		if !ok || value.Sign() <= 0 {
+			errs = append(errs, fmt.Sprintf("resume_gas_price of %s in gas_guard_config should be a positive integer", chain))
+		} else if value.Cmp(maxGasPrice) > 0 {
+			errs = append(errs, fmt.Sprintf("resume_gas_price of %s in gas_guard_config should not be larger than its max_gas_price", chain))
+		}
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+func (cfg GasGuardConfig) Enabled() bool {
+	return len(cfg.MaxGasPrice) != 0
+}
+
+func (cfg GasGuardConfig) GetInterval() time.Duration {
+	return intervalOrDefault(cfg.Interval, DefaultGasGuardInterval)
+}
+
+// GetMaxGasPrice returns the gas price of the chain above which the fills are held, nil if the chain has no ceiling
+func (cfg GasGuardConfig) GetMaxGasPrice(chain string) *big.Int {
+	maxGasPrice, ok := big.NewInt(0).SetString(cfg.MaxGasPrice[chain], 10)
+	if !ok || maxGasPrice.Sign() <= 0 {
+		return nil
+	}
+	return maxGasPrice
+}
+
+// GetResumeGasPrice returns the gas price of the chain at or below which the held fills are resumed, nil if the
+// chain has no ceiling
+func (cfg GasGuardConfig) GetResumeGasPrice(chain string) *big.Int {
+	maxGasPrice := cfg.GetMaxGasPrice(chain)
+	if maxGasPrice == nil {
+		return nil
+	}
+	resumeGasPrice, ok := big.NewInt(0).SetString(cfg.ResumeGasPrice[chain], 10)
+	if !ok || resumeGasPrice.Sign() <= 0 {
+		return maxGasPrice
+	}
+	return resumeGasPrice
+}
+
+// DuplicateFillConfig looks for a fill of the swap by a relayer account on the destination chain before its fill tx is
+// sent, in case a previous instance has filled it without the db knowing. The swap agent keeps no record of the
+// filled start txs, so the SwapFilled events of the last LookbackBlocks blocks of the chain are searched for one
+// paying the recipient the amount of the swap, key is the chain name.
+type DuplicateFillConfig struct {
+	LookbackBlocks map[string]int64 `json:"lookback_blocks"`
+}
+
+func (cfg DuplicateFillConfig) Check() []string {
+	errs := make([]string, 0)
+	for chain, blocks := range cfg.LookbackBlocks {
+		if chain != common.ChainBSC && chain != common.ChainETH && chain != common.ChainMATIC {
+			errs = append(errs, fmt.Sprintf("unknown chain %s in lookback_blocks of duplicate_fill_config", chain))
+		}
+		if blocks < 0 {
+			errs = append(errs, fmt.Sprintf("lookback_blocks of %s in duplicate_fill_config should not be less than 0", chain))
+		}
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+// GetLookbackBlocks returns the blocks of the chain searched for an earlier fill, 0 if the fills to the chain are
+// not checked
+func (cfg DuplicateFillConfig) GetLookbackBlocks(chain string) int64 {
+	return cfg.LookbackBlocks[chain]
+}
+
+// the types of the gas oracles
+const (
+	// the gasoracle action of the gastracker module of the etherscan family, e.g. bscscan and polygonscan
+	GasOracleEtherscan = "etherscan"
+	// the block prices of blocknative
+	GasOracleBlocknative = "blocknative"
+)
+
+const (
+	DefaultGasOracleCacheSeconds int64 = 15
+	DefaultGasOracleTimeout      int64 = 5
+	DefaultBlocknativeUrl              = "https://api.blocknative.com/gasprices/blockprices"
+	DefaultBlocknativeConfidence int64 = 90
+)
+
+// GasOracleConfig prices the txs of a chain by the external gas oracles of the chain rather than the gas price the
+// rpc suggests, which is the node default on the congested chains, key is the chain name. The oracles of the chain
+// are asked in order until one answers, and the gas price of the rpc is used if none does. An answer above
+// MaxGasPrice of the chain in wei is not trusted and the next oracle is asked. The answers are cached for
+// CacheSeconds. The txs are legacy txs, so the gas price of the oracle is used rather than a priority fee.
+type GasOracleConfig struct {
+	Oracles      map[string][]GasOracle `json:"oracles"`
+	MaxGasPrice  map[string]string      `json:"max_gas_price"`
+	CacheSeconds int64                  `json:"cache_seconds"`
+	Timeout      int64                  `json:"timeout"`
+}
+
+// GasOracle is an external gas oracle of Type. Url is the api of an etherscan oracle, e.g.
+// https://api.bscscan.com/api, the price of Speed is used, safe, propose or fast, propose by default. The blocknative
+// oracle is asked for the chain id of the chain at DefaultBlocknativeUrl by default, the price of Confidence percent
+// is used, 70, 80, 90, 95 or 99, 90 by default.
+type GasOracle struct {
+	Type       string `json:"type"`
+	Url        string `json:"url"`
+	ApiKey     string `json:"api_key"`
+	Speed      string `json:"speed"`
+	Confidence int64  `json:"confidence"`
+}
+
+func (cfg GasOracleConfig) Check() []string {
+	errs := make([]string, 0)
+	if cfg.CacheSeconds < 0 {
+		errs = append(errs, "cache_seconds of gas_oracle_config should not be less than 0")
+	}
+	if cfg.Timeout < 0 {
+		errs = append(errs, "timeout of gas_oracle_config should not be less than 0")
+	}
+	for chain, oracles := range cfg.Oracles {
+		if chain != common.ChainBSC && chain != common.ChainETH && chain != common.ChainMATIC {
+			errs = append(errs, fmt.Sprintf("unknown chain %s in oracles of gas_oracle_config", chain))
+		}
+		for i, oracle := range oracles {
+			switch oracle.Type {
+			case GasOracleEtherscan:
+				if oracle.Url == "" {
+					errs = append(errs, fmt.Sprintf("url of gas_oracle_config oracle %d of %s should not be empty", i, chain))
+				}
+				if oracle.Speed != "" && oracle.Speed != "safe" && oracle.Speed != "propose" && oracle.Speed != "fast" {
+					errs = append(errs, fmt.Sprintf("speed of gas_oracle_config oracle %d of %s should be safe, propose or fast", i, chain))
+				}
+			case GasOracleBlocknative:
+				if oracle.ApiKey == "" {
+					errs = append(errs, fmt.Sprintf("api_key of gas_oracle_config oracle %d of %s should not be empty", i, chain))
+				}
+				if c := oracle.Confidence; c != 0 && c != 70 && c != 80 && c != 90 && c != 95 && c != 99 {
+					errs = append(errs, fmt.Sprintf("confidence of gas_oracle_config oracle %d of %s should be 70, 80, 90, 95 or 99", i, chain))
+				}
+			default:
+				errs = append(errs, fmt.Sprintf("unknown type of gas_oracle_config oracle %d of %s: %s", i, chain, oracle.Type))
+			}
+		}
+	}
+	for chain, maxGasPrice := range cfg.MaxGasPrice {
+		if chain != common.ChainBSC && chain != common.ChainETH && chain != common.ChainMATIC {
+			errs = append(errs, fmt.Sprintf("unknown chain %s in max_gas_price of gas_oracle_config", chain))
+		}
+		if value, ok := big.NewInt(0).SetString(maxGasPrice, 10); !ok || value.Sign() <= 0 {
+			errs = append(errs, fmt.Sprintf("max_gas_price of %s in gas_oracle_config should be a positive integer", chain))
+		}
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+// Enabled returns whether the txs of the chain are priced by the gas oracles
+func (cfg GasOracleConfig) Enabled(chain string) bool {
+	return len(cfg.Oracles[chain]) != 0
+}
+
+func (cfg GasOracleConfig) GetOracles(chain string) []GasOracle {
+	return cfg.Oracles[chain]
+}
+
+// GetMaxGasPrice returns the highest gas price of the oracles of the chain trusted, nil if there is no ceiling
+func (cfg GasOracleConfig) GetMaxGasPrice(chain string) *big.Int {
+	maxGasPrice, ok := big.NewInt(0).SetString(cfg.MaxGasPrice[chain], 10)
+	if !ok {
+		return nil
+	}
+	return maxGasPrice
+}
+
+func (cfg GasOracleConfig) GetCache() time.Duration {
+	return intervalOrDefault(cfg.CacheSeconds, DefaultGasOracleCacheSeconds)
+}
+
+func (cfg GasOracleConfig) GetTimeout() time.Duration {
+	return intervalOrDefault(cfg.Timeout, DefaultGasOracleTimeout)
+}
+
+func (oracle GasOracle) GetSpeed() string {
+	if oracle.Speed == "" {
+		return "propose"
+	}
+	return oracle.Speed
+}
+
+func (oracle GasOracle) GetUrl() string {
+	if oracle.Url == "" && oracle.Type == GasOracleBlocknative {
+		return DefaultBlocknativeUrl
+	}
+	return oracle.Url
+}
+
+func (oracle GasOracle) GetConfidence() int64 {
+	if oracle.Confidence <= 0 {
+		return DefaultBlocknativeConfidence
+	}
+	return oracle.Confidence
+}
+
+// the processing stages whose oldest swap is aged
+const (
+	// the swap start txs waiting for their confirmations
+	QueueStageSeenRequest = "seen_request"
+	// the confirmed swap start txs waiting to be turned into swaps
+	QueueStageConfirmRequest = "confirm_request"
+	// the confirmed swaps waiting for their fill tx
+	QueueStageConfirmed = "confirmed"
+	// the sent fill txs waiting to be mined
+	QueueStageFillTxSent = "fill_tx_sent"
+)
+
+const DefaultQueueAgeInterval int64 = 60
+
+// DefaultQueueMaxAges are the seconds the oldest swap of a stage may wait before it is alerted
+var DefaultQueueMaxAges = map[string]int64{
+	QueueStageSeenRequest:    1800,
+	QueueStageConfirmRequest: 600,
+	QueueStageConfirmed:      600,
+	QueueStageFillTxSent:     1800,
+}
+
+// QueueAgeConfig ages the oldest swap of every processing stage and direction every Interval seconds, see the
+// QueueStage constants. A stage is alerted once its oldest swap waited longer than MaxAge seconds of the stage, the
+// DefaultQueueMaxAges by default, until it moves on, so a stuck direction is noticed before the users complain.
+type QueueAgeConfig struct {
+	Interval int64            `json:"interval"`
+	MaxAge   map[string]int64 `json:"max_age"`
+}
+
+func (cfg QueueAgeConfig) Check() []string {
+	errs := make([]string, 0)
+	if cfg.Interval < 0 {
+		errs = append(errs, "interval of queue_age_config should not be less than 0")
+	}
+	if cfg.Interval > MaxDaemonInterval {
+		errs = append(errs, fmt.Sprintf("interval of queue_age_config should not be larger than %d", MaxDaemonInterval))
+	}
+	for stage, maxAge := range cfg.MaxAge {
+		if _, ok := DefaultQueueMaxAges[stage]; !ok {
+			errs = append(errs, fmt.Sprintf("unknown stage %s in max_age of queue_age_config", stage))
+		}
+		if maxAge < 0 {
+			errs = append(errs, fmt.Sprintf("max_age of %s in queue_age_config should not be less than 0", stage))
+		}
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+func (cfg QueueAgeConfig) GetInterval() time.Duration {
+	return intervalOrDefault(cfg.Interval, DefaultQueueAgeInterval)
+}
+
+// GetMaxAge returns how long the oldest swap of the stage may wait before it is alerted
+func (cfg QueueAgeConfig) GetMaxAge(stage string) time.Duration {
+	return intervalOrDefault(cfg.MaxAge[stage], DefaultQueueMaxAges[stage])
+}
+
+const DefaultRelayerTokenInterval int64 = 60
+
+// RelayerTokenConfig watches the tokens of the pairs held by the relayer accounts, for the paths where the relayers
+// hold and transfer the tokens themselves, e.g. the relayed swaps. Every Interval seconds the allowance of every
+// relayer account of the chain to the swap agent is topped up and its balance is checked, see RelayerToken.
+type RelayerTokenConfig struct {
+	Interval int64          `json:"interval"`
+	Tokens   []RelayerToken `json:"tokens"`
+}
+
+// RelayerToken is a token of a pair on the chain held by the relayers. The relayer approves ApproveAmount to the swap
+// agent once its allowance is below MinAllowance, and it is alerted once its balance is below MinBalance, in the
+// smallest unit of the token. Either threshold may be empty.
+type RelayerToken struct {
+	Chain         string `json:"chain"`
+	ERC20Addr     string `json:"erc20_addr"`
+	MinAllowance  string `json:"min_allowance"`
+	ApproveAmount string `json:"approve_amount"`
+	MinBalance    string `json:"min_balance"`
+}
+
+func (cfg RelayerTokenConfig) Check() []string {
+	errs := make([]string, 0)
+	if cfg.Interval < 0 {
+		errs = append(errs, "interval of relayer_token_config should not be less than 0")
+	}
+	if cfg.Interval > MaxDaemonInterval {
+		errs = append(errs, fmt.Sprintf("interval of relayer_token_config should not be larger than %d", MaxDaemonInterval))
+	}
+	seen := make(map[string]bool)
+	for _, token := range cfg.Tokens {
+		if token.Chain != common.ChainBSC && token.Chain != common.ChainETH && token.Chain != common.ChainMATIC {
+			errs = append(errs, fmt.Sprintf("unknown chain %s in tokens of relayer_token_config", token.Chain))
+		}
+		if !ethcom.IsHexAddress(token.ERC20Addr) {
+			errs = append(errs, fmt.Sprintf("invalid erc20_addr %s in tokens of relayer_token_config", token.ERC20Addr))
+			continue
+		}
+		key := token.Chain + "/" + ethcom.HexToAddress(token.ERC20Addr).String()
+		if seen[key] {
+			errs = append(errs, fmt.Sprintf("duplicated token %s of %s in tokens of relayer_token_config", token.ERC20Addr, token.Chain))
+		}
+		seen[key] = true
+		for name, value := range map[string]string{
+			"min_allowance":  token.MinAllowance,
+			"approve_amount": token.ApproveAmount,
+			"min_balance":    token.MinBalance,
+		} {
+			if amount, ok := big.NewInt(0).SetString(value, 10); value != "" && (!ok || amount.Sign() <= 0) {
+				errs = append(errs, fmt.Sprintf("%s of token %s of %s in relayer_token_config should be a positive integer",
+					name, token.ERC20Addr, token.Chain))
+			}
+		}
+		if token.MinAllowance == "" && token.MinBalance == "" {
+			errs = append(errs, fmt.Sprintf("token %s of %s in relayer_token_config has no min_allowance or min_balance",
+				token.ERC20Addr, token.Chain))
+		}
+		minAllowance, approveAmount := token.GetMinAllowance(), token.GetApproveAmount()
+		if minAllowance != nil && (approveAmount == nil || approveAmount.Cmp(minAllowance) < 0) {
+			errs = append(errs, fmt.Sprintf("approve_amount of token %s of %s in relayer_token_config should not be less than min_allowance",
+				token.ERC20Addr, token.Chain))
+		}
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+func (cfg RelayerTokenConfig) Enabled() bool {
+	return len(cfg.Tokens) != 0
+}
+
+func (cfg RelayerTokenConfig) GetInterval() time.Duration {
+	return intervalOrDefault(cfg.Interval, DefaultRelayerTokenInterval)
+}
+
+// GetMinAllowance returns the allowance the relayers keep to the swap agent, nil if it is not topped up
+func (token RelayerToken) GetMinAllowance() *big.Int {
+	return positiveOrNil(token.MinAllowance)
+}
+
+func (token RelayerToken) GetApproveAmount() *big.Int {
+	return positiveOrNil(token.ApproveAmount)
+}
+
+// GetMinBalance returns the balance of the token below which the relayers are alerted, nil if it is not checked
+func (token RelayerToken) GetMinBalance() *big.Int {
+	return positiveOrNil(token.MinBalance)
+}
+
+func positiveOrNil(amount string) *big.Int {
+	value, ok := big.NewInt(0).SetString(amount, 10)
+	if !ok || value.Sign() <= 0 {
+		return nil
+	}
+	return value
+}
+
+const (
+	DefaultRollupInterval     int64 = 300
+	DefaultRollupSettleHours  int64 = 24
+	DefaultRollupBackfillDays int64 = 30
+)
+
+// RollupConfig aggregates the swaps by the hour and by the day of their creation every Interval seconds. The
+// buckets of the last SettleHours hours are recomputed every time, the swaps created then may still change their
+// status. On the first run the swaps of the last BackfillDays days are aggregated.
+type RollupConfig struct {
+	Interval     int64 `json:"interval"`
+	SettleHours  int64 `json:"settle_hours"`
+	BackfillDays int64 `json:"backfill_days"`
+}
+
+func (cfg RollupConfig) Check() []string {
+	errs := make([]string, 0)
+	if cfg.Interval < 0 {
+		errs = append(errs, "interval of rollup_config should not be less than 0")
+	}
+	if cfg.Interval > MaxDaemonInterval {
+		errs = append(errs, fmt.Sprintf("interval of rollup_config should not be larger than %d", MaxDaemonInterval))
+	}
+	if cfg.SettleHours < 0 {
+		errs = append(errs, "settle_hours of rollup_config should not be less than 0")
+	}
+	if cfg.BackfillDays < 0 {
+		errs = append(errs, "backfill_days of rollup_config should not be less than 0")
+	}
+	return errs
+}
+
+func (cfg RollupConfig) GetInterval() time.Duration {
+	return intervalOrDefault(cfg.Interval, DefaultRollupInterval)
+}
+
+func (cfg RollupConfig) GetSettleWindow() time.Duration {
+	if cfg.SettleHours == 0 {
+		return time.Duration(DefaultRollupSettleHours) * time.Hour
+	}
+	return time.Duration(cfg.SettleHours) * time.Hour
+}
+
+func (cfg RollupConfig) GetBackfillWindow() time.Duration {
+	if cfg.BackfillDays == 0 {
+		return time.Duration(DefaultRollupBackfillDays) * 24 * time.Hour
+	}
+	return time.Duration(cfg.BackfillDays) * 24 * time.Hour
+}
+
+const (
+	EventSinkWebhook   = "webhook"
+	EventSinkKafkaRest = "kafka_rest"
+	EventSinkNats      = "nats"
+
+	DefaultEventExportInterval      int64 = 5
+	DefaultEventExportTimeout       int64 = 10
+	DefaultEventExportBatchSize     int64 = 100
+	MaxEventExportBatchSize         int64 = 1000
+	DefaultEventExportRetentionDays int64 = 7
+)
+
+// EventExportConfig publishes the swap lifecycle events to an external sink every Interval seconds, at most
+// BatchSize events a call. The webhook sink posts the events to Url signed with the hmac of Secret, the kafka_rest
+// sink produces them to Topic through the kafka rest proxy at Url. The nats sink publishes every event to the
+// subject Topic.<type> of the nats server at Url, confirmed by the acks of the streams if JetStream is set. The
+// published events are kept RetentionDays days. Nothing is exported if Sink is empty.
+type EventExportConfig struct {
+	Sink          string `json:"sink"`
+	Url           string `json:"url"`
+	Topic         string `json:"topic"`
+	Secret        string `json:"secret"`
+	JetStream     bool   `json:"jet_stream"`
+	Interval      int64  `json:"interval"`
+	Timeout       int64  `json:"timeout"`
+	BatchSize     int64  `json:"batch_size"`
+	RetentionDays int64  `json:"retention_days"`
+}
+
+func (cfg EventExportConfig) Check() []string {
+	errs := make([]string, 0)
+	if !cfg.Enabled() {
+		return errs
+	}
+	switch cfg.Sink {
+	case EventSinkWebhook, EventSinkKafkaRest:
+		if !strings.HasPrefix(cfg.Url, "http://") && !strings.HasPrefix(cfg.Url, "https://") {
+			errs = append(errs, "url of event_export_config should be a http or https url")
+		}
+	case EventSinkNats:
+		if _, err := NewNatsPublisher(cfg.Url); err != nil {
+			errs = append(errs, fmt.Sprintf("url of event_export_config error: %s", err.Error()))
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("sink of event_export_config should be empty, %s, %s or %s", EventSinkWebhook,
+			EventSinkKafkaRest, EventSinkNats))
+	}
+	if (cfg.Sink == EventSinkKafkaRest || cfg.Sink == EventSinkNats) && cfg.Topic == "" {
+		errs = append(errs, fmt.Sprintf("topic of event_export_config should not be empty for the %s sink", cfg.Sink))
+	}
+	if cfg.Sink == EventSinkWebhook && cfg.Secret == "" {
+		errs = append(errs, "secret of event_export_config should not be empty for the webhook sink")
+	}
+	for name, value := range map[string]int64{
+		"interval":       cfg.Interval,
+		"timeout":        cfg.Timeout,
+		"batch_size":     cfg.BatchSize,
+		"retention_days": cfg.RetentionDays,
+	} {
+		if value < 0 {
+			errs = append(errs, fmt.Sprintf("%s of event_export_config should not be less than 0", name))
+		}
+	}
+	if cfg.Interval > MaxDaemonInterval {
+		errs = append(errs, fmt.Sprintf("interval of event_export_config should not be larger than %d", MaxDaemonInterval))
+	}
+	if cfg.BatchSize > MaxEventExportBatchSize {
+		errs = append(errs, fmt.Sprintf("batch_size of event_export_config should not be larger than %d", MaxEventExportBatchSize))
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+func (cfg EventExportConfig) Enabled() bool {
+	return cfg.Sink != ""
+}
+
+func (cfg EventExportConfig) GetInterval() time.Duration {
+	return intervalOrDefault(cfg.Interval, DefaultEventExportInterval)
+}
+
+func (cfg EventExportConfig) GetTimeout() time.Duration {
+	return intervalOrDefault(cfg.Timeout, DefaultEventExportTimeout)
+}
+
+func (cfg EventExportConfig) GetBatchSize() int {
+	if cfg.BatchSize == 0 {
+		return int(DefaultEventExportBatchSize)
+	}
+	return int(cfg.BatchSize)
+}
+
+func (cfg EventExportConfig) GetRetention() time.Duration {
+	if cfg.RetentionDays == 0 {
+		return time.Duration(DefaultEventExportRetentionDays) * 24 * time.Hour
+	}
+	return time.Duration(cfg.RetentionDays) * 24 * time.Hour
+}
+
+const DefaultRebalanceInterval int64 = 600
+
+// RebalanceConfig plans the transfers of the bridged token between the swap agents of the chains, so one direction
+// doesn't run dry while the other accumulates. TargetPercents is the share of the total inventory every chain should
+// hold, key is the chain name, the shares sum to 100. A plan is proposed once a chain is more than ThresholdPercent
+// points off its target, the transfers below MinTransfer are left out. An approved plan is executed by the relayers
+// if AutoExecute is set, else it is executed by hand. Nothing is planned without the targets.
+type RebalanceConfig struct {
+	TargetPercents   map[string]int64 `json:"target_percents"`
+	ThresholdPercent int64            `json:"threshold_percent"`
+	MinTransfer      string           `json:"min_transfer"`
+	AutoExecute      bool             `json:"auto_execute"`
+	Interval         int64            `json:"interval"`
+}
+
+func (cfg RebalanceConfig) Check() []string {
+	errs := make([]string, 0)
+	if !cfg.Enabled() {
+		return errs
+	}
+	total := int64(0)
+	for chain, percent := range cfg.TargetPercents {
+		if chain != common.ChainBSC && chain != common.ChainETH && chain != common.ChainMATIC {
+			errs = append(errs, fmt.Sprintf("unknown chain %s in target_percents of rebalance_config", chain))
+		}
+		if percent < 0 {
+			errs = append(errs, fmt.Sprintf("target_percents of %s in rebalance_config should not be less than 0", chain))
+		}
+		total += percent
+	}
+	if total != 100 {
+		errs = append(errs, fmt.Sprintf("target_percents of rebalance_config should sum to 100 instead of %d", total))
+	}
+	if len(cfg.TargetPercents) < 2 {
+		errs = append(errs, "target_percents of rebalance_config should have at least 2 chains")
+	}
+	if cfg.ThresholdPercent <= 0 || cfg.ThresholdPercent >= 100 {
+		errs = append(errs, "threshold_percent of rebalance_config should be between 0 and 100")
+	}
+	if minTransfer, ok := big.NewInt(0).SetString(cfg.MinTransfer, 10); cfg.MinTransfer != "" && (!ok || minTransfer.Sign() < 0) {
+		errs = append(errs, "min_transfer of rebalance_config should be a non-negative integer")
+	}
+	if cfg.Interval < 0 {
+		errs = append(errs, "interval of rebalance_config should not be less than 0")
+	}
+	if cfg.Interval > MaxDaemonInterval {
+		errs = append(errs, fmt.Sprintf("interval of rebalance_config should not be larger than %d", MaxDaemonInterval))
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+func (cfg RebalanceConfig) Enabled() bool {
+	return len(cfg.TargetPercents) != 0
+}
+
+func (cfg RebalanceConfig) GetInterval() time.Duration {
+	return intervalOrDefault(cfg.Interval, DefaultRebalanceInterval)
+}
+
+// GetMinTransfer returns the min amount of a planned transfer, 0 if it is not set
+func (cfg RebalanceConfig) GetMinTransfer() *big.Int {
+	minTransfer, ok := big.NewInt(0).SetString(cfg.MinTransfer, 10)
+	if !ok {
+		return big.NewInt(0)
+	}
+	return minTransfer
+}
+
+const DefaultIntegrityBatchSize int64 = 500
+
+// IntegrityConfig schedules the integrity sweep, every IntervalHours hours the record hashes of all the swaps are
+// verified, BatchSize swaps a query. The tampered swaps and the swaps without a record hash are reported, and moved
+// to the quarantined_swaps table for review if Quarantine is set. The sweep is not scheduled if IntervalHours is 0.
+type IntegrityConfig struct {
+	IntervalHours int64 `json:"interval_hours"`
+	BatchSize     int64 `json:"batch_size"`
+	Quarantine    bool  `json:"quarantine"`
+}
+
+func (cfg IntegrityConfig) Check() []string {
+	errs := make([]string, 0)
+	for name, value := range map[string]int64{
+		"interval_hours": cfg.IntervalHours,
+		"batch_size":     cfg.BatchSize,
+	} {
+		if value < 0 {
+			errs = append(errs, fmt.Sprintf("%s of integrity_config should not be less than 0", name))
+		}
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+func (cfg IntegrityConfig) Enabled() bool {
+	return cfg.IntervalHours > 0
+}
+
+func (cfg IntegrityConfig) GetInterval() time.Duration {
+	return time.Duration(cfg.IntervalHours) * time.Hour
+}
+
+func (cfg IntegrityConfig) GetBatchSize() int64 {
+	if cfg.BatchSize <= 0 {
+		return DefaultIntegrityBatchSize
+	}
+	return cfg.BatchSize
+}
+
+const (
+	DefaultCircuitBreakerFailureThreshold int64 = 5
+	DefaultCircuitBreakerProbeInterval    int64 = 15
+)
+
+// CircuitBreakerConfig opens the circuit breaker of a chain after FailureThreshold rpc errors in a row, the rpc calls
+// of the chain then fail at once and its daemons are paused. The rpc is probed every ProbeInterval seconds and the
+// breaker is closed by the first successful probe.
+type CircuitBreakerConfig struct {
+	FailureThreshold int64 `json:"failure_threshold"`
+	ProbeInterval    int64 `json:"probe_interval"`
+}
+
+func (cfg CircuitBreakerConfig) Check() []string {
+	errs := make([]string, 0)
+	for name, value := range map[string]int64{
+		"failure_threshold": cfg.FailureThreshold,
+		"probe_interval":    cfg.ProbeInterval,
+	} {
+		if value < 0 {
+			errs = append(errs, fmt.Sprintf("%s of circuit_breaker_config should not be less than 0", name))
+		}
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+func (cfg CircuitBreakerConfig) GetFailureThreshold() int64 {
+	if cfg.FailureThreshold <= 0 {
+		return DefaultCircuitBreakerFailureThreshold
+	}
+	return cfg.FailureThreshold
+}
+
+func (cfg CircuitBreakerConfig) GetProbeInterval() time.Duration {
+	return intervalOrDefault(cfg.ProbeInterval, DefaultCircuitBreakerProbeInterval)
+}
+
+const (
+	DefaultSchedulerJitterPercent int64 = 10
+	DefaultSchedulerMaxBackoff    int64 = 300
+)
+
+// SchedulerConfig spreads the runs of the daemons by up to JitterPercent of their intervals, and backs off a daemon
+// failing in a row, doubling its interval after each error up to MaxBackoff seconds
+type SchedulerConfig struct {
+	JitterPercent int64 `json:"jitter_percent"`
+	MaxBackoff    int64 `json:"max_backoff"`
+}
+
+func (cfg SchedulerConfig) Check() []string {
+	errs := make([]string, 0)
+	if cfg.JitterPercent < 0 || cfg.JitterPercent >= 100 {
+		errs = append(errs, "jitter_percent of scheduler_config should be between 0 and 99")
+	}
+	if cfg.MaxBackoff < 0 {
+		errs = append(errs, "max_backoff of scheduler_config should not be less than 0")
+	}
+	return errs
+}
+
+func (cfg SchedulerConfig) GetJitter() float64 {
+	if cfg.JitterPercent <= 0 {
+		return float64(DefaultSchedulerJitterPercent) / 100
+	}
+	return float64(cfg.JitterPercent) / 100
+}
+
+func (cfg SchedulerConfig) GetMaxBackoff() time.Duration {
+	return intervalOrDefault(cfg.MaxBackoff, DefaultSchedulerMaxBackoff)
+}
+
+const (
+	DefaultHeaderSyncInterval int64 = 5
+	DefaultHeaderWindow       int64 = 1000
+)
+
+// HeaderVerificationConfig checks the swap start events of a source chain against a header cache synced from the
+// Providers of the chain, key is the chain name. The providers are rpcs run by other parties than the rpc of
+// chain_config, a header is only synced once Quorum providers return the same hash, all the providers by default,
+// and the synced headers must link by their parent hashes. The headers are not checked against the consensus of
+// the chain, it is not a light client, the quorum of the providers is trusted. Window headers are kept a chain, the
+// older blocks are asked to the quorum when a swap is verified. The chains without providers are not verified.
+type HeaderVerificationConfig struct {
+	Providers map[string][]string `json:"providers"`
+	Quorum    int64               `json:"quorum"`
+	Interval  int64               `json:"interval"`
+	Window    int64               `json:"window"`
+}
+
+func (cfg HeaderVerificationConfig) Check() []string {
+	errs := checkProviders("header_verification_config", cfg.Providers, cfg.Quorum)
+	for name, value := range map[string]int64{
+		"quorum":   cfg.Quorum,
+		"interval": cfg.Interval,
+		"window":   cfg.Window,
+	} {
+		if value < 0 {
+			errs = append(errs, fmt.Sprintf("%s of header_verification_config should not be less than 0", name))
+		}
+	}
+	if cfg.Interval > MaxDaemonInterval {
+		errs = append(errs, fmt.Sprintf("interval of header_verification_config should not be larger than %d", MaxDaemonInterval))
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+// Enabled returns whether the source events of the chain are verified against the header providers
+func (cfg HeaderVerificationConfig) Enabled(chain string) bool {
+	return len(cfg.Providers[chain]) != 0
+}
+
+func (cfg HeaderVerificationConfig) GetProviders(chain string) []string {
+	return cfg.Providers[chain]
+}
+
+// GetQuorum returns the number of the providers of the chain which must agree on a header
+func (cfg HeaderVerificationConfig) GetQuorum(chain string) int {
+	if cfg.Quorum <= 0 {
+		return len(cfg.Providers[chain])
+	}
+	return int(cfg.Quorum)
+}
+
+func (cfg HeaderVerificationConfig) GetInterval() time.Duration {
+	return intervalOrDefault(cfg.Interval, DefaultHeaderSyncInterval)
+}
+
+func (cfg HeaderVerificationConfig) GetWindow() int64 {
+	if cfg.Window <= 0 {
+		return DefaultHeaderWindow
+	}
+	return cfg.Window
+}
+
+// checkProviders checks the rpc providers keyed by chain name of the config section, and that the quorum can be
+// reached by the providers of every chain
+func checkProviders(section string, providers map[string][]string, quorum int64) []string {
+	errs := make([]string, 0)
+	for chain, urls := range providers {
+		if chain != common.ChainBSC && chain != common.ChainETH && chain != common.ChainMATIC {
+			errs = append(errs, fmt.Sprintf("unknown chain %s in providers of %s", chain, section))
+		}
+		if len(urls) == 0 {
+			errs = append(errs, fmt.Sprintf("providers of %s in %s should not be empty", chain, section))
+		}
+		for _, url := range urls {
+			if strings.TrimSpace(url) == "" {
+				errs = append(errs, fmt.Sprintf("empty provider of %s in %s", chain, section))
+			}
+		}
+		if quorum > int64(len(urls)) {
+			errs = append(errs, fmt.Sprintf("quorum of %s should not be larger than the %d providers of %s", section, len(urls), chain))
+		}
+	}
+	return errs
 }
 
-func (cfg *Config) Validate() {
-	cfg.DBConfig.Validate()
-	cfg.ChainConfig.Validate()
-	cfg.LogConfig.Validate()
-	cfg.AlertConfig.Validate()
+// RPCQuorumConfig has the swap start txs of a source chain confirmed by Quorum of the Providers of the chain before
+// the swaps are filled, all the providers by default, key is the chain name. The providers are rpcs run by other
+// parties than the rpc of chain_config, every provider is asked for the receipt of the swap start tx and the receipt
+// of the rpc must be the one the quorum returns. The chains without providers are not checked.
+type RPCQuorumConfig struct {
+	Providers map[string][]string `json:"providers"`
+	Quorum    int64               `json:"quorum"`
 }
 
-type AlertConfig struct {
-	TelegramBotId  string `json:"telegram_bot_id"`
-	TelegramChatId string `json:"telegram_chat_id"`
+func (cfg RPCQuorumConfig) Check() []string {
+	errs := checkProviders("rpc_quorum_config", cfg.Providers, cfg.Quorum)
+	if cfg.Quorum < 0 {
+		errs = append(errs, "quorum of rpc_quorum_config should not be less than 0")
+	}
+	sort.Strings(errs)
+	return errs
+}
 
-	BlockUpdateTimeout int64 `json:"block_update_timeout"`
+// Enabled returns whether the swap start txs of the chain are confirmed by the quorum of the providers
+func (cfg RPCQuorumConfig) Enabled(chain string) bool {
+	return len(cfg.Providers[chain]) != 0
 }
 
-func (cfg AlertConfig) Validate() {
-	if cfg.BlockUpdateTimeout <= 0 {
-		panic(fmt.Sprintf("block_update_timeout should be larger than 0"))
+func (cfg RPCQuorumConfig) GetProviders(chain string) []string {
+	return cfg.Providers[chain]
+}
+
+// GetQuorum returns the number of the providers of the chain which must agree on a receipt
+func (cfg RPCQuorumConfig) GetQuorum(chain string) int {
+	if cfg.Quorum <= 0 {
+		return len(cfg.Providers[chain])
+	}
+	return int(cfg.Quorum)
+}
+
+const (
+	DefaultPriceCacheSeconds int64 = 60
+	DefaultPriceFeedTimeout  int64 = 5
+	DefaultPriceMaxAge       int64 = 3600
+)
+
+// DefaultNativeSymbols are the symbols of the native tokens of the chains in the price feeds
+var DefaultNativeSymbols = map[string]string{
+	common.ChainBSC:   "BNB",
+	common.ChainETH:   "ETH",
+	common.ChainMATIC: "CRO",
+}
+
+// PriceConfig values the tokens in usd, so the limits and the thresholds can be configured in usd. The prices are
+// read from the chainlink feeds of ChainlinkFeeds, and from the rest oracle for the other symbols, a GET of RestUrl
+// returns a json object of the symbols to their usd prices, e.g. {"BNB": 310.5, "ETH": 1820.1, "OCC": 0.2}.
+type PriceConfig struct {
+	RestUrl string `json:"rest_url"`
+	// seconds the prices are cached
+	CacheSeconds int64 `json:"cache_seconds"`
+	Timeout      int64 `json:"timeout"`
+	// seconds after which the latest round of a chainlink feed is stale
+	MaxAgeSeconds  int64                 `json:"max_age_seconds"`
+	ChainlinkFeeds []ChainlinkFeedConfig `json:"chainlink_feeds"`
+	// symbols of the native tokens of the chains in the feeds, the DefaultNativeSymbols are used if empty
+	NativeSymbols map[string]string `json:"native_symbols"`
+	// the valued tokens, the token of the swap agents is recorded with the zero erc20 address
+	Tokens []PriceTokenConfig `json:"tokens"`
+}
+
+// ChainlinkFeedConfig reads the usd price of the symbol from the aggregator of a chainlink feed on the chain
+type ChainlinkFeedConfig struct {
+	Symbol     string `json:"symbol"`
+	Chain      string `json:"chain"`
+	Aggregator string `json:"aggregator"`
+}
+
+type PriceTokenConfig struct {
+	ERC20Addr string `json:"erc20_addr"`
+	// symbol of the token in the feeds, and its decimals
+	Symbol   string `json:"symbol"`
+	Decimals int    `json:"decimals"`
+}
+
+func (cfg PriceConfig) Check() []string {
+	errs := make([]string, 0)
+	for name, value := range map[string]int64{
+		"cache_seconds":   cfg.CacheSeconds,
+		"timeout":         cfg.Timeout,
+		"max_age_seconds": cfg.MaxAgeSeconds,
+	} {
+		if value < 0 {
+			errs = append(errs, fmt.Sprintf("%s of price_config should not be less than 0", name))
+		}
+	}
+	for chain := range cfg.NativeSymbols {
+		if _, ok := DefaultNativeSymbols[chain]; !ok {
+			errs = append(errs, fmt.Sprintf("unknown chain %s in native_symbols of price_config", chain))
+		}
+	}
+	feeds := make(map[string]bool)
+	for _, feed := range cfg.ChainlinkFeeds {
+		if feed.Symbol == "" {
+			errs = append(errs, "symbol of price_config chainlink_feeds should not be empty")
+		} else if feeds[feed.Symbol] {
+			errs = append(errs, fmt.Sprintf("duplicated symbol of price_config chainlink_feeds: %s", feed.Symbol))
+		}
+		feeds[feed.Symbol] = true
+		if _, ok := DefaultNativeSymbols[feed.Chain]; !ok {
+			errs = append(errs, fmt.Sprintf("unknown chain of price_config chainlink feed %s: %s", feed.Symbol, feed.Chain))
+		}
+		if !ethcom.IsHexAddress(feed.Aggregator) {
+			errs = append(errs, fmt.Sprintf("invalid aggregator of price_config chainlink feed %s: %s", feed.Symbol, feed.Aggregator))
+		}
+	}
+	seen := make(map[string]bool)
+	for _, token := range cfg.Tokens {
+		if !ethcom.IsHexAddress(token.ERC20Addr) {
+			errs = append(errs, fmt.Sprintf("invalid erc20_addr of price_config tokens: %s", token.ERC20Addr))
+		} else if seen[strings.ToLower(token.ERC20Addr)] {
+			errs = append(errs, fmt.Sprintf("duplicated erc20_addr of price_config tokens: %s", token.ERC20Addr))
+		}
+		seen[strings.ToLower(token.ERC20Addr)] = true
+		if token.Symbol == "" {
+			errs = append(errs, fmt.Sprintf("symbol of price_config token %s should not be empty", token.ERC20Addr))
+		}
+		if token.Decimals < 0 || token.Decimals > 36 {
+			errs = append(errs, fmt.Sprintf("decimals of price_config token %s should be between 0 and 36", token.ERC20Addr))
+		}
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+func (cfg PriceConfig) Enabled() bool {
+	return cfg.RestUrl != "" || len(cfg.ChainlinkFeeds) != 0
+}
+
+// GetToken returns the valuation of the token of the erc20 address, ok is false if the token is not valued
+func (cfg PriceConfig) GetToken(erc20Addr string) (token PriceTokenConfig, ok bool) {
+	for _, token := range cfg.Tokens {
+		if strings.EqualFold(token.ERC20Addr, erc20Addr) {
+			return token, true
+		}
+	}
+	return PriceTokenConfig{}, false
+}
+
+func (cfg PriceConfig) GetNativeSymbol(chain string) string {
+	if symbol, ok := cfg.NativeSymbols[chain]; ok {
+		return symbol
+	}
+	return DefaultNativeSymbols[chain]
+}
+
+func (cfg PriceConfig) GetCache() time.Duration {
+	return intervalOrDefault(cfg.CacheSeconds, DefaultPriceCacheSeconds)
+}
+
+func (cfg PriceConfig) GetTimeout() time.Duration {
+	return intervalOrDefault(cfg.Timeout, DefaultPriceFeedTimeout)
+}
+
+func (cfg PriceConfig) GetMaxAge() time.Duration {
+	return intervalOrDefault(cfg.MaxAgeSeconds, DefaultPriceMaxAge)
+}
+
+// ProfitabilityConfig holds the small swaps of the pairs whose bridge fee doesn't cover the gas of their fill txs,
+// the gas and the fee are compared in the bridged token by the prices of price_config
+type ProfitabilityConfig struct {
+	Pairs []PairProfitabilityConfig `json:"pairs"`
+}
+
+// PairProfitabilityConfig checks the swaps of the pair whose amount is not larger than MaxAmount, or whose usd value
+// is not larger than MaxAmountUsd, the token of the pair should be in the tokens of price_config
+type PairProfitabilityConfig struct {
+	ERC20Addr    string  `json:"erc20_addr"`
+	MaxAmount    string  `json:"max_amount"`
+	MaxAmountUsd float64 `json:"max_amount_usd"`
+}
+
+func (cfg ProfitabilityConfig) Check() []string {
+	errs := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, pair := range cfg.Pairs {
+		if !ethcom.IsHexAddress(pair.ERC20Addr) {
+			errs = append(errs, fmt.Sprintf("invalid erc20_addr of profitability_config pairs: %s", pair.ERC20Addr))
+		} else if seen[strings.ToLower(pair.ERC20Addr)] {
+			errs = append(errs, fmt.Sprintf("duplicated erc20_addr of profitability_config pairs: %s", pair.ERC20Addr))
+		}
+		seen[strings.ToLower(pair.ERC20Addr)] = true
+		if pair.MaxAmount != "" {
+			if maxAmount, ok := big.NewInt(0).SetString(pair.MaxAmount, 10); !ok || maxAmount.Sign() < 0 {
+				errs = append(errs, fmt.Sprintf("invalid max_amount of profitability_config pair %s: %s", pair.ERC20Addr, pair.MaxAmount))
+			}
+		}
+		if pair.MaxAmountUsd < 0 {
+			errs = append(errs, fmt.Sprintf("max_amount_usd of profitability_config pair %s should not be less than 0", pair.ERC20Addr))
+		}
+		if pair.MaxAmount == "" && pair.MaxAmountUsd == 0 {
+			errs = append(errs, fmt.Sprintf("max_amount or max_amount_usd of profitability_config pair %s should be set", pair.ERC20Addr))
+		}
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+func (cfg ProfitabilityConfig) Enabled() bool {
+	return len(cfg.Pairs) != 0
+}
+
+// GetPair returns the check of the swaps of the erc20 address, ok is false if they are not checked
+func (cfg ProfitabilityConfig) GetPair(erc20Addr string) (pair PairProfitabilityConfig, ok bool) {
+	for _, pair := range cfg.Pairs {
+		if strings.EqualFold(pair.ERC20Addr, erc20Addr) {
+			return pair, true
+		}
 	}
+	return PairProfitabilityConfig{}, false
 }
 
 type KeyManagerConfig struct {
@@ -46,11 +2132,28 @@ type KeyManagerConfig struct {
 
 	// local keys
 	LocalHMACKey         string `json:"local_hmac_key"`
-	LocalBSCTxHash   string `json:"local_bsc_private_key"`
+	LocalBSCTxHash       string `json:"local_bsc_private_key"`
 	LocalETHPrivateKey   string `json:"local_eth_private_key"`
 	LocalMATICPrivateKey string `json:"local_matic_private_key"`
 	LocalAdminApiKey     string `json:"local_admin_api_key"`
 	LocalAdminSecretKey  string `json:"local_admin_secret_key"`
+	// additional relayer accounts, the fill txs are assigned to all the relayer accounts of a chain in turn
+	LocalBSCExtraPrivateKeys   []string `json:"local_bsc_extra_private_keys"`
+	LocalETHExtraPrivateKeys   []string `json:"local_eth_extra_private_keys"`
+	LocalMATICExtraPrivateKeys []string `json:"local_matic_extra_private_keys"`
+
+	// go-ethereum keystore files, used if key_type is keystore_private_key
+	KeystoreBSCFile   string `json:"keystore_bsc_file"`
+	KeystoreETHFile   string `json:"keystore_eth_file"`
+	KeystoreMATICFile string `json:"keystore_matic_file"`
+	// keystore files of the additional relayer accounts
+	KeystoreBSCExtraFiles   []string `json:"keystore_bsc_extra_files"`
+	KeystoreETHExtraFiles   []string `json:"keystore_eth_extra_files"`
+	KeystoreMATICExtraFiles []string `json:"keystore_matic_extra_files"`
+	// env, stdin or fd
+	KeystorePassphraseSource string `json:"keystore_passphrase_source"`
+	KeystorePassphraseEnv    string `json:"keystore_passphrase_env"`
+	KeystorePassphraseFd     int    `json:"keystore_passphrase_fd"`
 }
 
 type KeyConfig struct {
@@ -60,30 +2163,76 @@ type KeyConfig struct {
 	MATICPrivateKey string `json:"matic_private_key"`
 	AdminApiKey     string `json:"admin_api_key"`
 	AdminSecretKey  string `json:"admin_secret_key"`
+
+	BSCExtraPrivateKeys   []string `json:"bsc_extra_private_keys"`
+	ETHExtraPrivateKeys   []string `json:"eth_extra_private_keys"`
+	MATICExtraPrivateKeys []string `json:"matic_extra_private_keys"`
 }
 
-func (cfg KeyManagerConfig) Validate() {
+// registerSecrets makes the logs and the alerts redact the keys, see Scrub
+func (cfg KeyConfig) registerSecrets() {
+	for _, secret := range []string{cfg.HMACKey, cfg.AdminSecretKey} {
+		RegisterSecret(secret)
+	}
+	for _, chain := range []string{common.ChainBSC, common.ChainETH, common.ChainMATIC} {
+		for _, key := range cfg.GetRelayerPrivateKeys(chain) {
+			RegisterSecret(key)
+		}
+	}
+}
+
+// GetRelayerPrivateKeys returns the private keys of all the relayer accounts of the chain, the first one is the
+// main relayer key which is also used by the other components
+func (cfg KeyConfig) GetRelayerPrivateKeys(chain string) []string {
+	switch chain {
+	case common.ChainBSC:
+		return append([]string{cfg.BSCPrivateKey}, cfg.BSCExtraPrivateKeys...)
+	case common.ChainMATIC:
+		return append([]string{cfg.MATICPrivateKey}, cfg.MATICExtraPrivateKeys...)
+	default:
+		return append([]string{cfg.ETHPrivateKey}, cfg.ETHExtraPrivateKeys...)
+	}
+}
+
+func (cfg KeyManagerConfig) Check() []string {
+	errs := make([]string, 0)
 	if cfg.KeyType == common.LocalPrivateKey && len(cfg.LocalHMACKey) == 0 {
-		panic("missing local hmac key")
+		errs = append(errs, "missing local hmac key")
 	}
 	if cfg.KeyType == common.LocalPrivateKey && len(cfg.LocalBSCTxHash) == 0 {
-		panic("missing local bsc private key")
+		errs = append(errs, "missing local bsc private key")
 	}
 	if cfg.KeyType == common.LocalPrivateKey && len(cfg.LocalETHPrivateKey) == 0 {
-		panic("missing local eth private key")
+		errs = append(errs, "missing local eth private key")
 	}
 
 	if cfg.KeyType == common.LocalPrivateKey && len(cfg.LocalAdminApiKey) == 0 {
-		panic("missing local admin api key")
+		errs = append(errs, "missing local admin api key")
 	}
 
 	if cfg.KeyType == common.LocalPrivateKey && len(cfg.LocalAdminSecretKey) == 0 {
-		panic("missing local admin secret key")
+		errs = append(errs, "missing local admin secret key")
 	}
 
 	if cfg.KeyType == common.AWSPrivateKey && (cfg.AWSRegion == "" || cfg.AWSSecretName == "") {
-		panic("Missing aws key region or name")
+		errs = append(errs, "Missing aws key region or name")
+	}
+
+	if cfg.KeyType == common.KeystorePrivateKey {
+		if cfg.KeystoreBSCFile == "" || cfg.KeystoreETHFile == "" {
+			errs = append(errs, "missing bsc or eth keystore file")
+		}
+		switch cfg.KeystorePassphraseSource {
+		case "", PassphraseSourceEnv, PassphraseSourceStdin, PassphraseSourceFd:
+		default:
+			errs = append(errs, fmt.Sprintf("unknown keystore_passphrase_source: %s", cfg.KeystorePassphraseSource))
+		}
 	}
+	return errs
+}
+
+func (cfg KeyManagerConfig) Validate() {
+	panicOnErrors(cfg.Check())
 }
 
 type TokenSecretKey struct {
@@ -95,20 +2244,134 @@ type TokenSecretKey struct {
 type DBConfig struct {
 	Dialect string `json:"dialect"`
 	DBPath  string `json:"db_path"`
+	// add the check constraints of the swap statuses and directions, they are replaced on start once a value is
+	// added to the registries, sqlite can't add them
+	EnumConstraints bool `json:"enum_constraints"`
 }
 
-func (cfg DBConfig) Validate() {
-	if cfg.Dialect != common.DBDialectMysql && cfg.Dialect != common.DBDialectSqlite3 {
-		panic(fmt.Sprintf("only %s and %s supported", common.DBDialectMysql, common.DBDialectSqlite3))
+func (cfg DBConfig) Check() []string {
+	errs := make([]string, 0)
+	if cfg.Dialect != common.DBDialectMysql && cfg.Dialect != common.DBDialectSqlite3 && cfg.Dialect != common.DBDialectPostgres {
+		errs = append(errs, fmt.Sprintf("only %s, %s and %s supported", common.DBDialectMysql, common.DBDialectSqlite3, common.DBDialectPostgres))
 	}
 	if cfg.DBPath == "" {
-		panic("db path should not be empty")
+		errs = append(errs, "db path should not be empty")
+	}
+	return errs
+}
+
+func (cfg DBConfig) Validate() {
+	panicOnErrors(cfg.Check())
+}
+
+const (
+	// DefaultDaemonInterval is the polling interval in seconds used by the swap daemons if not configured
+	DefaultDaemonInterval int64 = 5
+	// DefaultSwapDaemonInterval is the polling interval in seconds of the fill daemons if not configured
+	DefaultSwapDaemonInterval int64 = 2
+	// MaxDaemonInterval is the max polling interval in seconds, larger intervals are most likely typos
+	MaxDaemonInterval int64 = 3600
+	// DefaultMaxInFlightTxs is the max number of sent but not mined txs of a chain if not configured
+	DefaultMaxInFlightTxs int64 = 16
+	// DefaultRebroadcastTimeout is the timeout in seconds after which a tx not seen by the node is rebroadcast
+	DefaultRebroadcastTimeout int64 = 60
+	// DefaultRPCTimeout is the timeout in seconds of a call to the chain rpc if not configured
+	DefaultRPCTimeout int64 = 30
+	// DefaultWatchdogInterval is the polling interval in seconds of the stuck swap watchdog if not configured
+	DefaultWatchdogInterval int64 = 60
+	// DefaultStuckSwapTimeout is the time in seconds after which a swap in an intermediate state is considered stuck
+	DefaultStuckSwapTimeout int64 = 1800
+	// DefaultLatencyStatsInterval is the interval in seconds the latency percentiles of the swaps are recomputed
+	DefaultLatencyStatsInterval int64 = 60
+	// MaxSwapWorkers is the max number of the workers filling the swaps of a chain concurrently
+	MaxSwapWorkers int64 = 64
+	// DefaultObserverLogRange is the max number of the blocks whose logs the observer queries at once if not configured
+	DefaultObserverLogRange int64 = 1000
+	// CurrentSwapAgentAbiVersion is the version name of the compiled-in swap agent abi
+	CurrentSwapAgentAbiVersion = "current"
+	// DefaultGasLimitMultiplier is the multiplier of the gas estimate of a tx if not configured
+	DefaultGasLimitMultiplier = 1.2
+	// MaxGasLimitMultiplier is the max multiplier of the gas estimates, larger multipliers are most likely typos
+	MaxGasLimitMultiplier = 3.0
+	// DefaultMaxGasLimit is the max gas limit of a tx if not configured
+	DefaultMaxGasLimit int64 = 1000000
+	// DefaultFallbackGasLimit is the gas limit of a tx whose gas can't be estimated if not configured
+	DefaultFallbackGasLimit int64 = 300000
+)
+
+// SwapDelayConfig holds the swaps of the direction whose amount is larger than the threshold, or whose usd value is
+// larger than ThresholdUsd, for DelayMinutes before they are eligible for fill
+type SwapDelayConfig struct {
+	Direction string `json:"direction"`
+	Threshold string `json:"threshold"`
+	// threshold of the usd value of the swaps, the swaps whose tokens can't be valued are delayed
+	ThresholdUsd float64 `json:"threshold_usd"`
+	DelayMinutes int64   `json:"delay_minutes"`
+}
+
+// RetryPolicyConfig retries the swaps failed with FailureClass automatically, the n-th retry is sent
+// BackoffSeconds * 2^(n-1) seconds after the failure, at most MaxBackoffSeconds. The swap is abandoned once it
+// fails after MaxAttempts retries.
+type RetryPolicyConfig struct {
+	FailureClass      string `json:"failure_class"`
+	MaxAttempts       int64  `json:"max_attempts"`
+	BackoffSeconds    int64  `json:"backoff_seconds"`
+	MaxBackoffSeconds int64  `json:"max_backoff_seconds"`
+}
+
+// SwapAgentAbiConfig is a historical abi of a swap agent behind an upgradeable proxy. The SwapStarted events
+// of every version are observed, so the events emitted before and after an upgrade are not dropped. The event
+// should keep the toChainId, fromAddress and amount arguments, and optionally feeAmount and the token.
+type SwapAgentAbiConfig struct {
+	Version string `json:"version"`
+	AbiFile string `json:"abi_file"`
+}
+
+// Load reads the abi file, it fails if the abi has no SwapStarted event
+func (cfg SwapAgentAbiConfig) Load() (abi.ABI, error) {
+	data, err := ioutil.ReadFile(cfg.AbiFile)
+	if err != nil {
+		return abi.ABI{}, err
+	}
+	agentAbi, err := abi.JSON(strings.NewReader(string(data)))
+	if err != nil {
+		return abi.ABI{}, err
 	}
+	if _, ok := agentAbi.Events["SwapStarted"]; !ok {
+		return abi.ABI{}, fmt.Errorf("no SwapStarted event in %s", cfg.AbiFile)
+	}
+	return agentAbi, nil
+}
+
+// DefaultRetryPolicies are used for the failure classes without a configured policy, the swaps of the other
+// failure classes are left to the operators. The relayer out of funds is retried slowly until it is topped up.
+var DefaultRetryPolicies = []RetryPolicyConfig{
+	{FailureClass: string(common.FailureUnderpriced), MaxAttempts: 10, BackoffSeconds: 5, MaxBackoffSeconds: 300},
+	{FailureClass: string(common.FailureConnection), MaxAttempts: 10, BackoffSeconds: 15, MaxBackoffSeconds: 900},
+	{FailureClass: string(common.FailureTimeout), MaxAttempts: 10, BackoffSeconds: 15, MaxBackoffSeconds: 900},
+	{FailureClass: string(common.FailureNonce), MaxAttempts: 10, BackoffSeconds: 5, MaxBackoffSeconds: 300},
+	{FailureClass: string(common.FailureInsufficientFunds), MaxAttempts: 20, BackoffSeconds: 60, MaxBackoffSeconds: 3600},
 }
 
 type ChainConfig struct {
 	BalanceMonitorInterval int64 `json:"balance_monitor_interval"`
 
+	// optional time-lock delays of the large swaps
+	SwapDelays []SwapDelayConfig `json:"swap_delays"`
+	// optional auto-retry policies of the failed swaps
+	RetryPolicies []RetryPolicyConfig `json:"retry_policies"`
+
+	// polling intervals in seconds of the chain independent daemons
+	MonitorSwapRequestInterval int64 `json:"monitor_swap_request_interval"`
+	ConfirmSwapRequestInterval int64 `json:"confirm_swap_request_interval"`
+	RetryFailedSwapInterval    int64 `json:"retry_failed_swap_interval"`
+	TrackRetrySwapTxInterval   int64 `json:"track_retry_swap_tx_interval"`
+	WatchdogInterval           int64 `json:"watchdog_interval"`
+	SwapProofInterval          int64 `json:"swap_proof_interval"`
+	LatencyStatsInterval       int64 `json:"latency_stats_interval"`
+	// seconds after which a swap lingering in an intermediate state is reported by the watchdog
+	StuckSwapTimeout int64 `json:"stuck_swap_timeout"`
+
 	BSCObserverFetchInterval    int64  `json:"bsc_observer_fetch_interval"`
 	BSCStartHeight              int64  `json:"bsc_start_height"`
 	BSCProvider                 string `json:"bsc_provider"`
@@ -118,6 +2381,22 @@ type ChainConfig struct {
 	BSCMaxTrackRetry            int64  `json:"bsc_max_track_retry"`
 	BSCAlertThreshold           string `json:"bsc_alert_threshold"`
 	BSCWaitMilliSecBetweenSwaps int64  `json:"bsc_wait_milli_sec_between_swaps"`
+	BSCSwapDaemonInterval       int64  `json:"bsc_swap_daemon_interval"`
+	BSCTrackTxInterval          int64  `json:"bsc_track_tx_interval"`
+	BSCMaxInFlightTxs           int64  `json:"bsc_max_in_flight_txs"`
+	BSCRebroadcastTimeout       int64  `json:"bsc_rebroadcast_timeout"`
+	BSCRPCTimeout               int64  `json:"bsc_rpc_timeout"`
+	BSCSwapWorkers              int64  `json:"bsc_swap_workers"`
+	BSCObserverLogRange         int64  `json:"bsc_observer_log_range"`
+	// optional gas limit policy of the txs sent to the chain, see GasLimitPolicy
+	BSCGasLimitMultiplier float64 `json:"bsc_gas_limit_multiplier"`
+	BSCMaxGasLimit        int64   `json:"bsc_max_gas_limit"`
+	BSCFallbackGasLimit   int64   `json:"bsc_fallback_gas_limit"`
+	// optional, checked against the node and the relayer key at startup if set
+	BSCChainID     int64  `json:"bsc_chain_id"`
+	BSCRelayerAddr string `json:"bsc_relayer_addr"`
+	// optional, the former abis of the swap agent if it is upgraded behind a proxy
+	BSCSwapAgentHistoricalAbis []SwapAgentAbiConfig `json:"bsc_swap_agent_historical_abis"`
 
 	ETHObserverFetchInterval    int64  `json:"eth_observer_fetch_interval"`
 	ETHStartHeight              int64  `json:"eth_start_height"`
@@ -128,6 +2407,22 @@ type ChainConfig struct {
 	ETHMaxTrackRetry            int64  `json:"eth_max_track_retry"`
 	ETHAlertThreshold           string `json:"eth_alert_threshold"`
 	ETHWaitMilliSecBetweenSwaps int64  `json:"eth_wait_milli_sec_between_swaps"`
+	ETHSwapDaemonInterval       int64  `json:"eth_swap_daemon_interval"`
+	ETHTrackTxInterval          int64  `json:"eth_track_tx_interval"`
+	ETHMaxInFlightTxs           int64  `json:"eth_max_in_flight_txs"`
+	ETHRebroadcastTimeout       int64  `json:"eth_rebroadcast_timeout"`
+	ETHRPCTimeout               int64  `json:"eth_rpc_timeout"`
+	ETHSwapWorkers              int64  `json:"eth_swap_workers"`
+	ETHObserverLogRange         int64  `json:"eth_observer_log_range"`
+	// optional gas limit policy of the txs sent to the chain, see GasLimitPolicy
+	ETHGasLimitMultiplier float64 `json:"eth_gas_limit_multiplier"`
+	ETHMaxGasLimit        int64   `json:"eth_max_gas_limit"`
+	ETHFallbackGasLimit   int64   `json:"eth_fallback_gas_limit"`
+	// optional, checked against the node and the relayer key at startup if set
+	ETHChainID     int64  `json:"eth_chain_id"`
+	ETHRelayerAddr string `json:"eth_relayer_addr"`
+	// optional, the former abis of the swap agent if it is upgraded behind a proxy
+	ETHSwapAgentHistoricalAbis []SwapAgentAbiConfig `json:"eth_swap_agent_historical_abis"`
 
 	MATICObserverFetchInterval    int64  `json:"matic_observer_fetch_interval"`
 	MATICStartHeight              int64  `json:"matic_start_height"`
@@ -138,40 +2433,507 @@ type ChainConfig struct {
 	MATICMaxTrackRetry            int64  `json:"matic_max_track_retry"`
 	MATICAlertThreshold           string `json:"matic_alert_threshold"`
 	MATICWaitMilliSecBetweenSwaps int64  `json:"matic_wait_milli_sec_between_swaps"`
+	MATICSwapDaemonInterval       int64  `json:"matic_swap_daemon_interval"`
+	MATICTrackTxInterval          int64  `json:"matic_track_tx_interval"`
+	MATICMaxInFlightTxs           int64  `json:"matic_max_in_flight_txs"`
+	MATICRebroadcastTimeout       int64  `json:"matic_rebroadcast_timeout"`
+	MATICRPCTimeout               int64  `json:"matic_rpc_timeout"`
+	MATICSwapWorkers              int64  `json:"matic_swap_workers"`
+	MATICObserverLogRange         int64  `json:"matic_observer_log_range"`
+	// optional gas limit policy of the txs sent to the chain, see GasLimitPolicy
+	MATICGasLimitMultiplier float64 `json:"matic_gas_limit_multiplier"`
+	MATICMaxGasLimit        int64   `json:"matic_max_gas_limit"`
+	MATICFallbackGasLimit   int64   `json:"matic_fallback_gas_limit"`
+	// optional, checked against the node and the relayer key at startup if set
+	MATICChainID     int64  `json:"matic_chain_id"`
+	MATICRelayerAddr string `json:"matic_relayer_addr"`
+	// optional, the former abis of the swap agent if it is upgraded behind a proxy
+	MATICSwapAgentHistoricalAbis []SwapAgentAbiConfig `json:"matic_swap_agent_historical_abis"`
+}
+
+// chainParams are the settings of a chain, prefix is the prefix of their json names
+type chainParams struct {
+	name           string
+	prefix         string
+	startHeight    int64
+	provider       string
+	confirmNum     int64
+	swapAgentAddr  string
+	maxTrackRetry  int64
+	alertThreshold string
+	fetchInterval  int64
+	chainID        int64
+	relayerAddr    string
+	historicalAbis []SwapAgentAbiConfig
+}
+
+func (cfg ChainConfig) chainParams() []chainParams {
+	return []chainParams{
+		{common.ChainBSC, "bsc", cfg.BSCStartHeight, cfg.BSCProvider, cfg.BSCConfirmNum, cfg.BSCSwapAgentAddr, cfg.BSCMaxTrackRetry,
+			cfg.BSCAlertThreshold, cfg.BSCObserverFetchInterval, cfg.BSCChainID, cfg.BSCRelayerAddr, cfg.BSCSwapAgentHistoricalAbis},
+		{common.ChainETH, "eth", cfg.ETHStartHeight, cfg.ETHProvider, cfg.ETHConfirmNum, cfg.ETHSwapAgentAddr, cfg.ETHMaxTrackRetry,
+			cfg.ETHAlertThreshold, cfg.ETHObserverFetchInterval, cfg.ETHChainID, cfg.ETHRelayerAddr, cfg.ETHSwapAgentHistoricalAbis},
+		{common.ChainMATIC, "matic", cfg.MATICStartHeight, cfg.MATICProvider, cfg.MATICConfirmNum, cfg.MATICSwapAgentAddr, cfg.MATICMaxTrackRetry,
+			cfg.MATICAlertThreshold, cfg.MATICObserverFetchInterval, cfg.MATICChainID, cfg.MATICRelayerAddr, cfg.MATICSwapAgentHistoricalAbis},
+	}
+}
+
+func (cfg ChainConfig) Check() []string {
+	errs := make([]string, 0)
+	for _, chain := range cfg.chainParams() {
+		if chain.startHeight < 0 {
+			errs = append(errs, fmt.Sprintf("%s_start_height should not be less than 0", chain.prefix))
+		}
+		if chain.provider == "" {
+			errs = append(errs, fmt.Sprintf("%s_provider should not be empty", chain.prefix))
+		}
+		if chain.confirmNum <= 0 {
+			errs = append(errs, fmt.Sprintf("%s_confirm_num should be larger than 0", chain.prefix))
+		}
+		if !ethcom.IsHexAddress(chain.swapAgentAddr) {
+			errs = append(errs, fmt.Sprintf("invalid %s_swap_agent_addr: %s", chain.prefix, chain.swapAgentAddr))
+		}
+		if chain.maxTrackRetry <= 0 {
+			errs = append(errs, fmt.Sprintf("%s_max_track_retry should be larger than 0", chain.prefix))
+		}
+		if chain.alertThreshold != "" {
+			if _, ok := big.NewInt(0).SetString(chain.alertThreshold, 10); !ok {
+				errs = append(errs, fmt.Sprintf("%s_alert_threshold should be a big integer: %s", chain.prefix, chain.alertThreshold))
+			}
+		}
+		if chain.fetchInterval <= 0 || chain.fetchInterval > MaxDaemonInterval {
+			errs = append(errs, fmt.Sprintf("%s_observer_fetch_interval should be between 1 and %d", chain.prefix, MaxDaemonInterval))
+		}
+		if chain.chainID < 0 {
+			errs = append(errs, fmt.Sprintf("%s_chain_id should not be less than 0", chain.prefix))
+		}
+		if chain.relayerAddr != "" && !ethcom.IsHexAddress(chain.relayerAddr) {
+			errs = append(errs, fmt.Sprintf("invalid %s_relayer_addr: %s", chain.prefix, chain.relayerAddr))
+		}
+		versions := make(map[string]bool)
+		for _, abiCfg := range chain.historicalAbis {
+			if abiCfg.Version == "" || abiCfg.Version == CurrentSwapAgentAbiVersion {
+				errs = append(errs, fmt.Sprintf("version of %s_swap_agent_historical_abis should not be empty or %s", chain.prefix, CurrentSwapAgentAbiVersion))
+			} else if versions[abiCfg.Version] {
+				errs = append(errs, fmt.Sprintf("duplicated version of %s_swap_agent_historical_abis: %s", chain.prefix, abiCfg.Version))
+			}
+			versions[abiCfg.Version] = true
+			if _, err := abiCfg.Load(); err != nil {
+				errs = append(errs, fmt.Sprintf("invalid abi_file of %s_swap_agent_historical_abis: %s", chain.prefix, err.Error()))
+			}
+		}
+	}
+
+	intervals := map[string]int64{
+		"monitor_swap_request_interval": cfg.MonitorSwapRequestInterval,
+		"confirm_swap_request_interval": cfg.ConfirmSwapRequestInterval,
+		"retry_failed_swap_interval":    cfg.RetryFailedSwapInterval,
+		"track_retry_swap_tx_interval":  cfg.TrackRetrySwapTxInterval,
+		"watchdog_interval":             cfg.WatchdogInterval,
+		"swap_proof_interval":           cfg.SwapProofInterval,
+		"latency_stats_interval":        cfg.LatencyStatsInterval,
+		"stuck_swap_timeout":            cfg.StuckSwapTimeout,
+		"bsc_swap_daemon_interval":      cfg.BSCSwapDaemonInterval,
+		"bsc_track_tx_interval":         cfg.BSCTrackTxInterval,
+		"eth_swap_daemon_interval":      cfg.ETHSwapDaemonInterval,
+		"eth_track_tx_interval":         cfg.ETHTrackTxInterval,
+		"matic_swap_daemon_interval":    cfg.MATICSwapDaemonInterval,
+		"matic_track_tx_interval":       cfg.MATICTrackTxInterval,
+		"bsc_max_in_flight_txs":         cfg.BSCMaxInFlightTxs,
+		"bsc_rebroadcast_timeout":       cfg.BSCRebroadcastTimeout,
+		"eth_max_in_flight_txs":         cfg.ETHMaxInFlightTxs,
+		"eth_rebroadcast_timeout":       cfg.ETHRebroadcastTimeout,
+		"matic_max_in_flight_txs":       cfg.MATICMaxInFlightTxs,
+		"matic_rebroadcast_timeout":     cfg.MATICRebroadcastTimeout,
+		"bsc_rpc_timeout":               cfg.BSCRPCTimeout,
+		"eth_rpc_timeout":               cfg.ETHRPCTimeout,
+		"matic_rpc_timeout":             cfg.MATICRPCTimeout,
+		"bsc_swap_workers":              cfg.BSCSwapWorkers,
+		"eth_swap_workers":              cfg.ETHSwapWorkers,
+		"matic_swap_workers":            cfg.MATICSwapWorkers,
+		"bsc_observer_log_range":        cfg.BSCObserverLogRange,
+		"eth_observer_log_range":        cfg.ETHObserverLogRange,
+		"matic_observer_log_range":      cfg.MATICObserverLogRange,
+		"bsc_max_gas_limit":             cfg.BSCMaxGasLimit,
+		"bsc_fallback_gas_limit":        cfg.BSCFallbackGasLimit,
+		"eth_max_gas_limit":             cfg.ETHMaxGasLimit,
+		"eth_fallback_gas_limit":        cfg.ETHFallbackGasLimit,
+		"matic_max_gas_limit":           cfg.MATICMaxGasLimit,
+		"matic_fallback_gas_limit":      cfg.MATICFallbackGasLimit,
+	}
+	names := make([]string, 0, len(intervals))
+	for name := range intervals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if intervals[name] < 0 {
+			errs = append(errs, fmt.Sprintf("%s should not be less than 0", name))
+		} else if strings.HasSuffix(name, "_interval") && intervals[name] > MaxDaemonInterval {
+			errs = append(errs, fmt.Sprintf("%s should not be larger than %d", name, MaxDaemonInterval))
+		} else if strings.HasSuffix(name, "_swap_workers") && intervals[name] > MaxSwapWorkers {
+			errs = append(errs, fmt.Sprintf("%s should not be larger than %d", name, MaxSwapWorkers))
+		}
+	}
+
+	for _, chain := range cfg.chainParams() {
+		multiplier := cfg.getGasLimitMultiplier(chain.name)
+		if multiplier != 0 && (multiplier < 1 || multiplier > MaxGasLimitMultiplier) {
+			errs = append(errs, fmt.Sprintf("%s_gas_limit_multiplier should be 0 or between 1 and %.0f", chain.prefix, MaxGasLimitMultiplier))
+		}
+		if policy := cfg.GetGasLimitPolicy(chain.name); policy.Fallback > policy.Max {
+			errs = append(errs, fmt.Sprintf("%s_fallback_gas_limit %d should not be larger than %s_max_gas_limit %d",
+				chain.prefix, policy.Fallback, chain.prefix, policy.Max))
+		}
+	}
+
+	for _, swapDelay := range cfg.SwapDelays {
+		if swapDelay.Direction == "" {
+			errs = append(errs, "direction of swap_delays should not be empty")
+		}
+		if swapDelay.Threshold != "" || swapDelay.ThresholdUsd == 0 {
+			if threshold, ok := big.NewInt(0).SetString(swapDelay.Threshold, 10); !ok || threshold.Sign() < 0 {
+				errs = append(errs, fmt.Sprintf("invalid threshold of swap_delays: %s", swapDelay.Threshold))
+			}
+		}
+		if swapDelay.ThresholdUsd < 0 {
+			errs = append(errs, "threshold_usd of swap_delays should not be less than 0")
+		}
+		if swapDelay.DelayMinutes <= 0 {
+			errs = append(errs, "delay_minutes of swap_delays should be larger than 0")
+		}
+	}
+
+	classes := make(map[string]bool)
+	for _, policy := range cfg.RetryPolicies {
+		switch class := common.FailureClass(policy.FailureClass); class {
+		case common.FailureRPC, common.FailureUnderpriced, common.FailureConnection, common.FailureTimeout,
+			common.FailureNonce, common.FailureInsufficientFunds:
+		case common.FailureRevert, common.FailureBlocked:
+			errs = append(errs, fmt.Sprintf("failure_class %s of retry_policies is permanent, it is never retried", class))
+		default:
+			errs = append(errs, fmt.Sprintf("unknown failure_class of retry_policies: %s", policy.FailureClass))
+		}
+		if classes[policy.FailureClass] {
+			errs = append(errs, fmt.Sprintf("duplicated failure_class of retry_policies: %s", policy.FailureClass))
+		}
+		classes[policy.FailureClass] = true
+		if policy.MaxAttempts <= 0 {
+			errs = append(errs, "max_attempts of retry_policies should be larger than 0")
+		}
+		if policy.BackoffSeconds <= 0 {
+			errs = append(errs, "backoff_seconds of retry_policies should be larger than 0")
+		}
+		if policy.MaxBackoffSeconds < policy.BackoffSeconds {
+			errs = append(errs, "max_backoff_seconds of retry_policies should not be less than backoff_seconds")
+		}
+	}
+	return errs
 }
 
 func (cfg ChainConfig) Validate() {
-	if cfg.BSCStartHeight < 0 {
-		panic("bsc_start_height should not be less than 0")
+	panicOnErrors(cfg.Check())
+}
+
+func intervalOrDefault(interval, defaultInterval int64) time.Duration {
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	return time.Duration(interval) * time.Second
+}
+
+func (cfg ChainConfig) GetMonitorSwapRequestInterval() time.Duration {
+	return intervalOrDefault(cfg.MonitorSwapRequestInterval, DefaultDaemonInterval)
+}
+
+func (cfg ChainConfig) GetConfirmSwapRequestInterval() time.Duration {
+	return intervalOrDefault(cfg.ConfirmSwapRequestInterval, DefaultDaemonInterval)
+}
+
+func (cfg ChainConfig) GetRetryFailedSwapInterval() time.Duration {
+	return intervalOrDefault(cfg.RetryFailedSwapInterval, DefaultDaemonInterval)
+}
+
+func (cfg ChainConfig) GetTrackRetrySwapTxInterval() time.Duration {
+	return intervalOrDefault(cfg.TrackRetrySwapTxInterval, DefaultDaemonInterval)
+}
+
+func (cfg ChainConfig) GetWatchdogInterval() time.Duration {
+	return intervalOrDefault(cfg.WatchdogInterval, DefaultWatchdogInterval)
+}
+
+func (cfg ChainConfig) GetSwapProofInterval() time.Duration {
+	return intervalOrDefault(cfg.SwapProofInterval, DefaultDaemonInterval)
+}
+
+func (cfg ChainConfig) GetLatencyStatsInterval() time.Duration {
+	return intervalOrDefault(cfg.LatencyStatsInterval, DefaultLatencyStatsInterval)
+}
+
+func (cfg ChainConfig) GetStuckSwapTimeout() time.Duration {
+	return intervalOrDefault(cfg.StuckSwapTimeout, DefaultStuckSwapTimeout)
+}
+
+// GetSwapDaemonInterval returns the polling interval of the fill daemon of the destination chain
+func (cfg ChainConfig) GetSwapDaemonInterval(chain string) time.Duration {
+	switch chain {
+	case common.ChainBSC:
+		return intervalOrDefault(cfg.BSCSwapDaemonInterval, DefaultSwapDaemonInterval)
+	case common.ChainMATIC:
+		return intervalOrDefault(cfg.MATICSwapDaemonInterval, DefaultSwapDaemonInterval)
+	default:
+		return intervalOrDefault(cfg.ETHSwapDaemonInterval, DefaultSwapDaemonInterval)
+	}
+}
+
+// GetTrackTxInterval returns the polling interval of the fill tx tracking daemon of the destination chain
+func (cfg ChainConfig) GetTrackTxInterval(chain string) time.Duration {
+	switch chain {
+	case common.ChainBSC:
+		return intervalOrDefault(cfg.BSCTrackTxInterval, DefaultDaemonInterval)
+	case common.ChainMATIC:
+		return intervalOrDefault(cfg.MATICTrackTxInterval, DefaultDaemonInterval)
+	default:
+		return intervalOrDefault(cfg.ETHTrackTxInterval, DefaultDaemonInterval)
+	}
+}
+
+// GetMaxInFlightTxs returns the max number of sent but not mined fill txs of the destination chain
+func (cfg ChainConfig) GetMaxInFlightTxs(chain string) int {
+	maxInFlightTxs := cfg.ETHMaxInFlightTxs
+	switch chain {
+	case common.ChainBSC:
+		maxInFlightTxs = cfg.BSCMaxInFlightTxs
+	case common.ChainMATIC:
+		maxInFlightTxs = cfg.MATICMaxInFlightTxs
+	}
+	if maxInFlightTxs <= 0 {
+		maxInFlightTxs = DefaultMaxInFlightTxs
+	}
+	return int(maxInFlightTxs)
+}
+
+// GetSwapWorkers returns the number of the workers filling the swaps of the destination chain concurrently, the
+// swaps are filled one by one if it is not larger than 1
+func (cfg ChainConfig) GetSwapWorkers(chain string) int {
+	workers := cfg.ETHSwapWorkers
+	switch chain {
+	case common.ChainBSC:
+		workers = cfg.BSCSwapWorkers
+	case common.ChainMATIC:
+		workers = cfg.MATICSwapWorkers
+	}
+	if workers > MaxSwapWorkers {
+		workers = MaxSwapWorkers
+	}
+	return int(workers)
+}
+
+// GetRebroadcastTimeout returns the timeout after which a fill tx not seen by the node of the destination chain is rebroadcast
+func (cfg ChainConfig) GetRebroadcastTimeout(chain string) time.Duration {
+	switch chain {
+	case common.ChainBSC:
+		return intervalOrDefault(cfg.BSCRebroadcastTimeout, DefaultRebroadcastTimeout)
+	case common.ChainMATIC:
+		return intervalOrDefault(cfg.MATICRebroadcastTimeout, DefaultRebroadcastTimeout)
+	default:
+		return intervalOrDefault(cfg.ETHRebroadcastTimeout, DefaultRebroadcastTimeout)
+	}
+}
+
+// GetRPCTimeout returns the timeout of a call of the swap engine to the rpc of the chain, a hung call fails instead
+// of stalling its daemon
+func (cfg ChainConfig) GetRPCTimeout(chain string) time.Duration {
+	switch chain {
+	case common.ChainBSC:
+		return intervalOrDefault(cfg.BSCRPCTimeout, DefaultRPCTimeout)
+	case common.ChainMATIC:
+		return intervalOrDefault(cfg.MATICRPCTimeout, DefaultRPCTimeout)
+	default:
+		return intervalOrDefault(cfg.ETHRPCTimeout, DefaultRPCTimeout)
+	}
+}
+
+// GetObserverLogRange returns the max number of the blocks of the chain whose swap events the observer queries with
+// one eth_getLogs while it catches up, the blocks are fetched one by one if it is 1
+func (cfg ChainConfig) GetObserverLogRange(chain string) int64 {
+	logRange := cfg.ETHObserverLogRange
+	switch chain {
+	case common.ChainBSC:
+		logRange = cfg.BSCObserverLogRange
+	case common.ChainMATIC:
+		logRange = cfg.MATICObserverLogRange
+	}
+	if logRange <= 0 {
+		return DefaultObserverLogRange
+	}
+	return logRange
+}
+
+// GasLimitPolicy sets the gas limit of a tx to its gas estimate times the multiplier, at most Max. The Fallback gas
+// limit is used if the gas can't be estimated.
+type GasLimitPolicy struct {
+	Multiplier float64
+	Max        uint64
+	Fallback   uint64
+}
+
+// GetGasLimit returns the gas limit of the tx of the gas estimate, it is error if the estimate is larger than Max,
+// the tx would run out of gas
+func (policy GasLimitPolicy) GetGasLimit(estimate uint64) (uint64, error) {
+	if estimate > policy.Max {
+		return 0, fmt.Errorf("gas estimate %d is larger than the max gas limit %d", estimate, policy.Max)
+	}
+	gasLimit := uint64(math.Ceil(float64(estimate) * policy.Multiplier))
+	if gasLimit > policy.Max {
+		gasLimit = policy.Max
+	}
+	return gasLimit, nil
+}
+
+func (cfg ChainConfig) getGasLimitMultiplier(chain string) float64 {
+	switch chain {
+	case common.ChainBSC:
+		return cfg.BSCGasLimitMultiplier
+	case common.ChainMATIC:
+		return cfg.MATICGasLimitMultiplier
+	default:
+		return cfg.ETHGasLimitMultiplier
+	}
+}
+
+// GetGasLimitPolicy returns how the gas limits of the txs sent to the chain are set
+func (cfg ChainConfig) GetGasLimitPolicy(chain string) GasLimitPolicy {
+	multiplier := cfg.getGasLimitMultiplier(chain)
+	maxGasLimit, fallbackGasLimit := cfg.ETHMaxGasLimit, cfg.ETHFallbackGasLimit
+	switch chain {
+	case common.ChainBSC:
+		maxGasLimit, fallbackGasLimit = cfg.BSCMaxGasLimit, cfg.BSCFallbackGasLimit
+	case common.ChainMATIC:
+		maxGasLimit, fallbackGasLimit = cfg.MATICMaxGasLimit, cfg.MATICFallbackGasLimit
+	}
+	if multiplier <= 0 {
+		multiplier = DefaultGasLimitMultiplier
+	}
+	if maxGasLimit <= 0 {
+		maxGasLimit = DefaultMaxGasLimit
+	}
+	if fallbackGasLimit <= 0 {
+		fallbackGasLimit = DefaultFallbackGasLimit
+	}
+	return GasLimitPolicy{Multiplier: multiplier, Max: uint64(maxGasLimit), Fallback: uint64(fallbackGasLimit)}
+}
+
+// GetAlertThreshold returns the native coin balance below which a relayer account of the chain is considered drained
+func (cfg ChainConfig) GetAlertThreshold(chain string) *big.Int {
+	alertThreshold := cfg.ETHAlertThreshold
+	switch chain {
+	case common.ChainBSC:
+		alertThreshold = cfg.BSCAlertThreshold
+	case common.ChainMATIC:
+		alertThreshold = cfg.MATICAlertThreshold
 	}
-	if cfg.BSCProvider == "" {
-		panic("bsc_provider should not be empty")
+	threshold, ok := big.NewInt(0).SetString(alertThreshold, 10)
+	if !ok {
+		return big.NewInt(0)
 	}
-	if cfg.BSCConfirmNum <= 0 {
-		panic("bsc_confirm_num should be larger than 0")
+	return threshold
+}
+
+func (cfg ChainConfig) GetSwapAgentHistoricalAbis(chain string) []SwapAgentAbiConfig {
+	switch chain {
+	case common.ChainBSC:
+		return cfg.BSCSwapAgentHistoricalAbis
+	case common.ChainMATIC:
+		return cfg.MATICSwapAgentHistoricalAbis
+	default:
+		return cfg.ETHSwapAgentHistoricalAbis
 	}
-	if !ethcom.IsHexAddress(cfg.BSCSwapAgentAddr) {
-		panic(fmt.Sprintf("invalid bsc_swap_contract_addr: %s", cfg.BSCSwapAgentAddr))
+}
+
+func (cfg ChainConfig) GetMaxTrackRetry(chain string) int64 {
+	switch chain {
+	case common.ChainBSC:
+		return cfg.BSCMaxTrackRetry
+	case common.ChainMATIC:
+		return cfg.MATICMaxTrackRetry
+	default:
+		return cfg.ETHMaxTrackRetry
 	}
-	if cfg.BSCMaxTrackRetry <= 0 {
-		panic("bsc_max_track_retry should be larger than 0")
+}
+
+func (cfg ChainConfig) GetConfirmNum(chain string) int64 {
+	switch chain {
+	case common.ChainBSC:
+		return cfg.BSCConfirmNum
+	case common.ChainMATIC:
+		return cfg.MATICConfirmNum
+	default:
+		return cfg.ETHConfirmNum
 	}
+}
 
-	if cfg.ETHStartHeight < 0 {
-		panic("bsc_start_height should not be less than 0")
+func (cfg ChainConfig) GetWaitBetweenSwaps(chain string) time.Duration {
+	switch chain {
+	case common.ChainBSC:
+		return time.Duration(cfg.BSCWaitMilliSecBetweenSwaps) * time.Millisecond
+	case common.ChainMATIC:
+		return time.Duration(cfg.MATICWaitMilliSecBetweenSwaps) * time.Millisecond
+	default:
+		return time.Duration(cfg.ETHWaitMilliSecBetweenSwaps) * time.Millisecond
 	}
-	if cfg.ETHProvider == "" {
-		panic("bsc_provider should not be empty")
+}
+
+// GetSwapDelay returns the time-lock delay of the swap of the given direction and amount, 0 means no delay
+// GetRetryPolicy returns the auto-retry policy of the failure class, ok is false if the failed swaps of the class
+// are not retried automatically
+func (cfg ChainConfig) GetRetryPolicy(class common.FailureClass) (policy RetryPolicyConfig, ok bool) {
+	for _, policies := range [][]RetryPolicyConfig{cfg.RetryPolicies, DefaultRetryPolicies} {
+		for _, policy := range policies {
+			if common.FailureClass(policy.FailureClass) == class {
+				return policy, true
+			}
+		}
 	}
-	if !ethcom.IsHexAddress(cfg.ETHSwapAgentAddr) {
-		panic(fmt.Sprintf("invalid eth_swap_contract_addr: %s", cfg.ETHSwapAgentAddr))
+	return RetryPolicyConfig{}, false
+}
+
+// GetRetryBackoff returns how long to wait before the retry after the given number of attempts
+func (policy RetryPolicyConfig) GetRetryBackoff(attempts int64) time.Duration {
+	backoff := policy.BackoffSeconds
+	for i := int64(0); i < attempts && backoff < policy.MaxBackoffSeconds; i++ {
+		backoff *= 2
 	}
-	if cfg.ETHConfirmNum <= 0 {
-		panic("bsc_confirm_num should be larger than 0")
+	if backoff > policy.MaxBackoffSeconds {
+		backoff = policy.MaxBackoffSeconds
 	}
-	if cfg.ETHMaxTrackRetry <= 0 {
-		panic("eth_max_track_retry should be larger than 0")
+	return time.Duration(backoff) * time.Second
+}
+
+// GetSwapDelay returns the longest delay of the swap of the amount, valueUsd is only called for the delays with
+// threshold_usd, the swap exceeds them if it fails
+func (cfg ChainConfig) GetSwapDelay(direction common.SwapDirection, amount *big.Int, valueUsd func() (float64, error)) time.Duration {
+	var delay time.Duration
+	for _, swapDelay := range cfg.SwapDelays {
+		if common.SwapDirection(swapDelay.Direction) != direction {
+			continue
+		}
+		exceeded := false
+		if threshold, ok := big.NewInt(0).SetString(swapDelay.Threshold, 10); ok && amount.Cmp(threshold) > 0 {
+			exceeded = true
+		}
+		if swapDelay.ThresholdUsd > 0 && !exceeded {
+			value, err := valueUsd()
+			exceeded = err != nil || value > swapDelay.ThresholdUsd
+		}
+		if !exceeded {
+			continue
+		}
+		if d := time.Duration(swapDelay.DelayMinutes) * time.Minute; d > delay {
+			delay = d
+		}
 	}
+	return delay
 }
 
 type LogConfig struct {
@@ -185,22 +2947,115 @@ type LogConfig struct {
 	Compress                     bool   `json:"compress"`
 }
 
-func (cfg LogConfig) Validate() {
+func (cfg LogConfig) Check() []string {
+	errs := make([]string, 0)
 	if cfg.UseFileLogger {
 		if cfg.Filename == "" {
-			panic("filename should not be empty if use file logger")
+			errs = append(errs, "filename should not be empty if use file logger")
 		}
 		if cfg.MaxFileSizeInMB <= 0 {
-			panic("max_file_size_in_mb should be larger than 0 if use file logger")
+			errs = append(errs, "max_file_size_in_mb should be larger than 0 if use file logger")
 		}
 		if cfg.MaxBackupsOfLogFiles <= 0 {
-			panic("max_backups_off_log_files should be larger than 0 if use file logger")
+			errs = append(errs, "max_backups_off_log_files should be larger than 0 if use file logger")
 		}
 	}
+	return errs
+}
+
+func (cfg LogConfig) Validate() {
+	panicOnErrors(cfg.Check())
 }
 
+const DefaultAdminTokenTTL int64 = 3600
+
+// DefaultAdminReplayWindow is how far in seconds the timestamp of a signed admin request may be from now
+const DefaultAdminReplayWindow int64 = 300
+
+// DefaultAdminStreamTimeout is how long in seconds the streamed responses of the admin api, e.g. the csv exports,
+// may take to be written
+const DefaultAdminStreamTimeout int64 = 600
+
 type AdminConfig struct {
 	ListenAddr string `json:"listen_addr"`
+	// lifetime of the tokens issued to the admin users
+	TokenTTLSeconds int64 `json:"token_ttl_seconds"`
+
+	// listener of the endpoints with a permission, the clients need a certificate signed by the client ca. The
+	// public endpoints stay on listen_addr, the endpoints with a permission are not served without it.
+	SecureListenAddr string `json:"secure_listen_addr"`
+	TLSCertFile      string `json:"tls_cert_file"`
+	TLSKeyFile       string `json:"tls_key_file"`
+	ClientCAFile     string `json:"client_ca_file"`
+	// ips or cidrs allowed to call the endpoints with a permission, required by secure_listen_addr, set 0.0.0.0/0
+	// to allow all
+	AllowedIPs []string `json:"allowed_ips"`
+	// seconds the timestamp of a signed request may be off, the nonces of the requests are kept as long
+	ReplayWindowSeconds int64 `json:"replay_window_seconds"`
+	// seconds the streamed responses, e.g. the csv exports, may take to be written, the others are cut after 3 seconds
+	StreamTimeoutSeconds int64 `json:"stream_timeout_seconds"`
+}
+
+func (cfg AdminConfig) Check() []string {
+	errs := make([]string, 0)
+	if cfg.SecureListenAddr != "" {
+		if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" || cfg.ClientCAFile == "" {
+			errs = append(errs, "tls_cert_file, tls_key_file and client_ca_file of admin_config are required by secure_listen_addr")
+		}
+		if cfg.SecureListenAddr == cfg.ListenAddr {
+			errs = append(errs, "secure_listen_addr of admin_config should not be listen_addr")
+		}
+		if len(cfg.AllowedIPs) == 0 {
+			errs = append(errs, "allowed_ips of admin_config are required by secure_listen_addr")
+		}
+	}
+	if _, err := cfg.GetAllowedNets(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if cfg.ReplayWindowSeconds < 0 {
+		errs = append(errs, "replay_window_seconds of admin_config should not be negative")
+	}
+	if cfg.StreamTimeoutSeconds < 0 {
+		errs = append(errs, "stream_timeout_seconds of admin_config should not be negative")
+	}
+	return errs
+}
+
+// GetAllowedNets returns the networks of the allowed ips, a single ip is a network of one address
+func (cfg AdminConfig) GetAllowedNets() ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cfg.AllowedIPs))
+	for _, allowed := range cfg.AllowedIPs {
+		if !strings.Contains(allowed, "/") {
+			ip := net.ParseIP(allowed)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip in allowed_ips of admin_config: %s", allowed)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(allowed)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr in allowed_ips of admin_config: %s", allowed)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func (cfg AdminConfig) GetTokenTTL() time.Duration {
+	return intervalOrDefault(cfg.TokenTTLSeconds, DefaultAdminTokenTTL)
+}
+
+func (cfg AdminConfig) GetReplayWindow() time.Duration {
+	return intervalOrDefault(cfg.ReplayWindowSeconds, DefaultAdminReplayWindow)
+}
+
+func (cfg AdminConfig) GetStreamTimeout() time.Duration {
+	return intervalOrDefault(cfg.StreamTimeoutSeconds, DefaultAdminStreamTimeout)
 }
 
 func ParseConfigFromFile(filePath string) *Config {
@@ -0,0 +1,93 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	ethcom "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// CheckChains checks the config against the chains at startup: the chain ids of the nodes are the expected ones
+// of the environment, the swap agents are contracts and the relayer keys are the expected ones. The keys are only
+// checked if the process is an executor. It returns all the problems found.
+func CheckChains(cfg *Config, clients map[string]*ethclient.Client) []string {
+	errs := make([]string, 0)
+
+	var keyConfig *KeyConfig
+	if cfg.HasRole(RoleExecutor) {
+		var err error
+		if keyConfig, err = GetKeyConfig(cfg); err != nil {
+			errs = append(errs, fmt.Sprintf("load keys error: %s", err.Error()))
+		}
+	}
+
+	profile := cfg.EnvironmentConfig.GetProfile()
+	for _, chain := range cfg.ChainConfig.chainParams() {
+		client, ok := clients[chain.name]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("no client of %s", chain.name))
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ChainConfig.GetRPCTimeout(chain.name))
+		chainID, err := client.ChainID(ctx)
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("query chain id from %s_provider %s error: %s", chain.prefix, chain.provider, err.Error()))
+			// the node is not reachable, the rest checks will fail as well
+			continue
+		}
+		if chain.chainID != 0 && chainID.Int64() != chain.chainID {
+			errs = append(errs, fmt.Sprintf("%s_provider %s is on chain %s, but %s_chain_id is %d",
+				chain.prefix, chain.provider, chainID.String(), chain.prefix, chain.chainID))
+		}
+		if recognized, _ := profile.GetChainByID(chainID.String()); recognized != chain.name {
+			errs = append(errs, fmt.Sprintf("%s_provider %s is on chain %s, which is not a %s chain of the %s environment",
+				chain.prefix, chain.provider, chainID.String(), chain.name, profile.Name))
+		}
+
+		ctx, cancel = context.WithTimeout(context.Background(), cfg.ChainConfig.GetRPCTimeout(chain.name))
+		code, err := client.CodeAt(ctx, ethcom.HexToAddress(chain.swapAgentAddr), nil)
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("query code of %s_swap_agent_addr %s error: %s", chain.prefix, chain.swapAgentAddr, err.Error()))
+		} else if len(code) == 0 {
+			errs = append(errs, fmt.Sprintf("%s_swap_agent_addr %s is not a contract on chain %s", chain.prefix, chain.swapAgentAddr, chainID.String()))
+		}
+
+		if keyConfig != nil {
+			errs = append(errs, checkRelayerKey(chain, keyConfig)...)
+		}
+	}
+	return errs
+}
+
+func checkRelayerKey(chain chainParams, keyConfig *KeyConfig) []string {
+	errs := make([]string, 0)
+	accounts := make(map[ethcom.Address]bool)
+	for idx, privateKey := range keyConfig.GetRelayerPrivateKeys(chain.name) {
+		key, err := crypto.HexToECDSA(strings.TrimPrefix(privateKey, "0x"))
+		if err != nil {
+			if idx == 0 {
+				errs = append(errs, fmt.Sprintf("invalid %s relayer private key: %s", chain.prefix, err.Error()))
+			} else {
+				errs = append(errs, fmt.Sprintf("invalid %s extra relayer private key %d: %s", chain.prefix, idx, err.Error()))
+			}
+			continue
+		}
+		relayerAddr := crypto.PubkeyToAddress(key.PublicKey)
+		if idx == 0 && chain.relayerAddr != "" && relayerAddr != ethcom.HexToAddress(chain.relayerAddr) {
+			errs = append(errs, fmt.Sprintf("%s relayer private key is of %s, but %s_relayer_addr is %s",
+				chain.prefix, relayerAddr.String(), chain.prefix, chain.relayerAddr))
+		}
+		// the nonces are tracked per account, an account can't be used by two broadcasters
+		if accounts[relayerAddr] {
+			errs = append(errs, fmt.Sprintf("%s relayer account %s is configured more than once", chain.prefix, relayerAddr.String()))
+		}
+		accounts[relayerAddr] = true
+	}
+	return errs
+}
@@ -0,0 +1,178 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"occ-swap-server/common"
+)
+
+const (
+	EnvironmentMainnet = "mainnet"
+	EnvironmentTestnet = "testnet"
+	EnvironmentCustom  = "custom"
+)
+
+// EnvironmentProfile defines the chain ids recognized as each chain, the explorers and the enabled swap directions
+// of a deployment. The directions are named <source prefix>_<destination prefix>, e.g. bsc_eth.
+type EnvironmentProfile struct {
+	Name         string
+	ChainIds     map[string][]string
+	ExplorerUrls map[string]string
+	Directions   []string
+}
+
+// allDirections returns all the directions between the chains
+func allDirections() []string {
+	directions := make([]string, 0)
+	for _, from := range chainPrefixes() {
+		for _, to := range chainPrefixes() {
+			if from != to {
+				directions = append(directions, fmt.Sprintf("%s_%s", from, to))
+			}
+		}
+	}
+	return directions
+}
+
+func chainPrefixes() []string {
+	return []string{"bsc", "eth", "matic"}
+}
+
+var environmentProfiles = map[string]EnvironmentProfile{
+	EnvironmentMainnet: {
+		Name: EnvironmentMainnet,
+		ChainIds: map[string][]string{
+			common.ChainBSC:   {"56"},
+			common.ChainETH:   {"1"},
+			common.ChainMATIC: {"25"},
+		},
+		ExplorerUrls: map[string]string{
+			common.ChainBSC:   "https://bscscan.com/tx",
+			common.ChainETH:   "https://etherscan.io/tx",
+			common.ChainMATIC: "https://cronos.org/explorer/tx",
+		},
+		Directions: allDirections(),
+	},
+	EnvironmentTestnet: {
+		Name: EnvironmentTestnet,
+		ChainIds: map[string][]string{
+			common.ChainBSC:   {"97"},
+			common.ChainETH:   {"4"},
+			common.ChainMATIC: {"338"},
+		},
+		ExplorerUrls: map[string]string{
+			common.ChainBSC:   "https://testnet.bscscan.com/tx",
+			common.ChainETH:   "https://rinkeby.etherscan.io/tx",
+			common.ChainMATIC: "https://cronos.org/explorer/testnet3/tx",
+		},
+		Directions: allDirections(),
+	},
+}
+
+// GetChainByID returns the chain the chain id is recognized as
+func (profile EnvironmentProfile) GetChainByID(chainId string) (string, bool) {
+	for chain, chainIds := range profile.ChainIds {
+		for _, id := range chainIds {
+			if id == chainId {
+				return chain, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (profile EnvironmentProfile) IsDirectionEnabled(direction string) bool {
+	for _, d := range profile.Directions {
+		if d == direction {
+			return true
+		}
+	}
+	return false
+}
+
+// EnvironmentConfig selects the environment profile, the chain ids, the explorer urls and the directions are only
+// used by the custom profile
+type EnvironmentConfig struct {
+	Profile string `json:"profile"`
+
+	BSCChainIds      []string `json:"bsc_chain_ids"`
+	BSCExplorerUrl   string   `json:"bsc_explorer_url"`
+	ETHChainIds      []string `json:"eth_chain_ids"`
+	ETHExplorerUrl   string   `json:"eth_explorer_url"`
+	MATICChainIds    []string `json:"matic_chain_ids"`
+	MATICExplorerUrl string   `json:"matic_explorer_url"`
+	Directions       []string `json:"directions"`
+}
+
+func (cfg EnvironmentConfig) Check() []string {
+	errs := make([]string, 0)
+	if cfg.Profile != EnvironmentCustom {
+		if _, ok := environmentProfiles[cfg.Profile]; !ok {
+			errs = append(errs, fmt.Sprintf("profile of environment_config should be %s, %s or %s",
+				EnvironmentMainnet, EnvironmentTestnet, EnvironmentCustom))
+		}
+		return errs
+	}
+
+	profile := cfg.GetProfile()
+	recognized := make(map[string]string)
+	for _, prefix := range chainPrefixes() {
+		chain := chainOfPrefix(prefix)
+		if len(profile.ChainIds[chain]) == 0 {
+			errs = append(errs, fmt.Sprintf("%s_chain_ids of environment_config should not be empty", prefix))
+		}
+		for _, chainId := range profile.ChainIds[chain] {
+			if id, err := strconv.ParseInt(chainId, 10, 64); err != nil || id <= 0 {
+				errs = append(errs, fmt.Sprintf("invalid chain id in %s_chain_ids of environment_config: %s", prefix, chainId))
+			} else if other, ok := recognized[chainId]; ok && other != prefix {
+				errs = append(errs, fmt.Sprintf("chain id %s is in both %s_chain_ids and %s_chain_ids of environment_config",
+					chainId, other, prefix))
+			}
+			recognized[chainId] = prefix
+		}
+	}
+	if len(cfg.Directions) == 0 {
+		errs = append(errs, "directions of environment_config should not be empty")
+	}
+	for _, direction := range cfg.Directions {
+		if !environmentProfiles[EnvironmentMainnet].IsDirectionEnabled(direction) {
+			errs = append(errs, fmt.Sprintf("unknown direction in environment_config: %s", direction))
+		}
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+// GetProfile returns the selected profile, the profile is valid only if the config is checked
+func (cfg EnvironmentConfig) GetProfile() EnvironmentProfile {
+	if cfg.Profile != EnvironmentCustom {
+		return environmentProfiles[cfg.Profile]
+	}
+	return EnvironmentProfile{
+		Name: EnvironmentCustom,
+		ChainIds: map[string][]string{
+			common.ChainBSC:   cfg.BSCChainIds,
+			common.ChainETH:   cfg.ETHChainIds,
+			common.ChainMATIC: cfg.MATICChainIds,
+		},
+		ExplorerUrls: map[string]string{
+			common.ChainBSC:   cfg.BSCExplorerUrl,
+			common.ChainETH:   cfg.ETHExplorerUrl,
+			common.ChainMATIC: cfg.MATICExplorerUrl,
+		},
+		Directions: cfg.Directions,
+	}
+}
+
+func chainOfPrefix(prefix string) string {
+	switch prefix {
+	case "bsc":
+		return common.ChainBSC
+	case "matic":
+		return common.ChainMATIC
+	default:
+		return common.ChainETH
+	}
+}
@@ -2,11 +2,13 @@ package util
 
 import (
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
-
-	"occ-swap-server/common"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
 )
 
 // Signer signs provided payloads.
@@ -24,23 +26,11 @@ type HmacSigner struct {
 }
 
 func NewHmacSignerFromConfig(config *Config) (*HmacSigner, error) {
-	apiKey, secretKey := "", ""
-	if config.KeyManagerConfig.KeyType == common.AWSPrivateKey {
-		result, err := GetSecret(config.KeyManagerConfig.AWSSecretName, config.KeyManagerConfig.AWSRegion)
-		if err != nil {
-			return nil, err
-		}
-
-		keyConfig := KeyConfig{}
-		err = json.Unmarshal([]byte(result), &keyConfig)
-		if err != nil {
-			return nil, err
-		}
-		apiKey, secretKey = keyConfig.AdminApiKey, keyConfig.AdminSecretKey
-	} else {
-		apiKey, secretKey = config.KeyManagerConfig.LocalAdminApiKey, config.KeyManagerConfig.LocalAdminSecretKey
+	keyConfig, err := GetKeyConfig(config)
+	if err != nil {
+		return nil, err
 	}
-	return NewHmacSigner(apiKey, secretKey), nil
+	return NewHmacSigner(keyConfig.AdminApiKey, keyConfig.AdminSecretKey), nil
 }
 
 func NewHmacSigner(apiKey string, secretKey string) *HmacSigner {
@@ -61,5 +51,59 @@ func (hs *HmacSigner) Verify(payload []byte, hash string) bool {
 	mac := hmac.New(sha256.New, hs.SecretKey)
 	mac.Write(payload)
 	res := hex.EncodeToString(mac.Sum(nil))
-	return hash == res
+	return hmac.Equal([]byte(hash), []byte(res))
+}
+
+const (
+	// TimestampHeader is the unix time the admin request is signed at
+	TimestampHeader = "Timestamp"
+	// NonceHeader is the random nonce of the admin request, a nonce is accepted once
+	NonceHeader = "Nonce"
+	// TokenSignatureHeader is the hmac of the admin request sent with a token, signed with the secret of the token
+	TokenSignatureHeader = "TokenSignature"
+
+	RequestNonceLength = 16
+)
+
+// SignedRequestPayload is the payload of the admin request signed with the api secret, the method, the uri, the
+// timestamp and the nonce are signed along with the body so that a captured request can't be replayed
+func SignedRequestPayload(method, uri string, timestamp int64, nonce string, body []byte) []byte {
+	payload := []byte(fmt.Sprintf("%s\n%s\n%d\n%s\n", method, uri, timestamp, nonce))
+	return append(payload, body...)
+}
+
+// SetRequestNonce sets a new timestamp and nonce of the request to the admin api
+func SetRequestNonce(req *http.Request) (int64, string, error) {
+	bz := make([]byte, RequestNonceLength)
+	if _, err := rand.Read(bz); err != nil {
+		return 0, "", err
+	}
+	nonce := hex.EncodeToString(bz)
+	timestamp := time.Now().Unix()
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(NonceHeader, nonce)
+	return timestamp, nonce, nil
+}
+
+// SignRequest sets the api key, the timestamp, the nonce and the hmac of the request to the admin api
+func (hs *HmacSigner) SignRequest(req *http.Request, body []byte) error {
+	timestamp, nonce, err := SetRequestNonce(req)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("ApiKey", hs.ApiKey)
+	req.Header.Set("Authorization", hs.Sign(SignedRequestPayload(req.Method, req.URL.RequestURI(), timestamp, nonce, body)))
+	return nil
+}
+
+// SignTokenRequest sets the token, the timestamp, the nonce and the hmac of the request to the admin api, the hmac is
+// signed with the secret of the token so that the timestamp and the nonce of a captured request can't be replaced
+func SignTokenRequest(req *http.Request, token, tokenSecret string, body []byte) error {
+	timestamp, nonce, err := SetRequestNonce(req)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(TokenSignatureHeader, NewHmacSigner("", tokenSecret).Sign(SignedRequestPayload(req.Method, req.URL.RequestURI(), timestamp, nonce, body)))
+	return nil
 }
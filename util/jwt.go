@@ -0,0 +1,82 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// JWTClaims are the claims of the tokens issued to the admin users
+type JWTClaims struct {
+	Subject   string `json:"sub"`
+	Role      string `json:"role"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// JWTSigner issues and verifies HS256 json web tokens
+type JWTSigner struct {
+	secret []byte
+}
+
+func NewJWTSigner(secret []byte) *JWTSigner {
+	return &JWTSigner{secret: secret}
+}
+
+func (signer *JWTSigner) sign(signingInput string) string {
+	mac := hmac.New(sha256.New, signer.secret)
+	mac.Write([]byte(signingInput))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Sign returns the token of the claims
+func (signer *JWTSigner) Sign(claims JWTClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + signer.sign(signingInput), nil
+}
+
+// TokenSecret returns the secret of the token, the requests sent with the token are signed with it so that the
+// timestamp and the nonce of a captured request can't be replaced. It is derived from the token and is only returned
+// to the caller the token is issued to.
+func (signer *JWTSigner) TokenSecret(token string) string {
+	mac := hmac.New(sha256.New, signer.secret)
+	mac.Write([]byte("token_secret\n" + token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify returns the claims of the token if it is signed by the signer and not expired
+func (signer *JWTSigner) Verify(token string) (*JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+	if parts[0] != jwtHeader {
+		return nil, fmt.Errorf("unsupported token header")
+	}
+	if !hmac.Equal([]byte(signer.sign(parts[0]+"."+parts[1])), []byte(parts[2])) {
+		return nil, fmt.Errorf("invalid token signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token payload: %s", err.Error())
+	}
+	var claims JWTClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed token payload: %s", err.Error())
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, fmt.Errorf("token is expired")
+	}
+	return &claims, nil
+}
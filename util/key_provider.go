@@ -0,0 +1,207 @@
+package util
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/crypto"
+	"golang.org/x/crypto/ssh/terminal"
+
+	"occ-swap-server/common"
+)
+
+const (
+	PassphraseSourceEnv   = "env"
+	PassphraseSourceStdin = "stdin"
+	PassphraseSourceFd    = "fd"
+
+	DefaultKeystorePassphraseEnv = "SWAP_KEYSTORE_PASSPHRASE"
+)
+
+// KeyProvider loads the private keys of the relayers and the hmac and admin keys
+type KeyProvider interface {
+	GetKeyConfig() (*KeyConfig, error)
+}
+
+type localKeyProvider struct {
+	cfg KeyManagerConfig
+}
+
+func (p localKeyProvider) GetKeyConfig() (*KeyConfig, error) {
+	return &KeyConfig{
+		HMACKey:         p.cfg.LocalHMACKey,
+		AdminApiKey:     p.cfg.LocalAdminApiKey,
+		AdminSecretKey:  p.cfg.LocalAdminSecretKey,
+		BSCPrivateKey:   p.cfg.LocalBSCTxHash,
+		ETHPrivateKey:   p.cfg.LocalETHPrivateKey,
+		MATICPrivateKey: p.cfg.LocalMATICPrivateKey,
+
+		BSCExtraPrivateKeys:   p.cfg.LocalBSCExtraPrivateKeys,
+		ETHExtraPrivateKeys:   p.cfg.LocalETHExtraPrivateKeys,
+		MATICExtraPrivateKeys: p.cfg.LocalMATICExtraPrivateKeys,
+	}, nil
+}
+
+type awsKeyProvider struct {
+	cfg KeyManagerConfig
+}
+
+func (p awsKeyProvider) GetKeyConfig() (*KeyConfig, error) {
+	result, err := GetSecret(p.cfg.AWSSecretName, p.cfg.AWSRegion)
+	if err != nil {
+		return nil, err
+	}
+
+	keyConfig := KeyConfig{}
+	err = json.Unmarshal([]byte(result), &keyConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &keyConfig, nil
+}
+
+// keystoreKeyProvider decrypts the go-ethereum keystore files of the relayers, the hmac and admin keys are
+// still read from the local config
+type keystoreKeyProvider struct {
+	cfg KeyManagerConfig
+}
+
+func (p keystoreKeyProvider) readPassphrase() (string, error) {
+	switch p.cfg.KeystorePassphraseSource {
+	case PassphraseSourceStdin:
+		fmt.Fprint(os.Stderr, "keystore passphrase: ")
+		if terminal.IsTerminal(int(os.Stdin.Fd())) {
+			passphrase, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+			fmt.Fprintln(os.Stderr)
+			return string(passphrase), err
+		}
+		passphrase, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && passphrase == "" {
+			return "", err
+		}
+		return strings.TrimRight(passphrase, "\r\n"), nil
+	case PassphraseSourceFd:
+		f := os.NewFile(uintptr(p.cfg.KeystorePassphraseFd), "passphrase")
+		if f == nil {
+			return "", fmt.Errorf("invalid keystore passphrase fd %d", p.cfg.KeystorePassphraseFd)
+		}
+		defer f.Close()
+		passphrase, err := ioutil.ReadAll(f)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(passphrase), "\r\n"), nil
+	default:
+		env := p.cfg.KeystorePassphraseEnv
+		if env == "" {
+			env = DefaultKeystorePassphraseEnv
+		}
+		passphrase, ok := os.LookupEnv(env)
+		if !ok {
+			return "", fmt.Errorf("keystore passphrase env %s is not set", env)
+		}
+		return passphrase, nil
+	}
+}
+
+func decryptKeystore(path, passphrase string) (string, error) {
+	keyJson, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	key, err := keystore.DecryptKey(keyJson, passphrase)
+	if err != nil {
+		return "", fmt.Errorf("decrypt keystore %s error: %s", path, err.Error())
+	}
+	return hex.EncodeToString(crypto.FromECDSA(key.PrivateKey)), nil
+}
+
+func (p keystoreKeyProvider) GetKeyConfig() (*KeyConfig, error) {
+	passphrase, err := p.readPassphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	keyConfig, _ := localKeyProvider{cfg: p.cfg}.GetKeyConfig()
+	keystores := []struct {
+		path string
+		key  *string
+	}{
+		{p.cfg.KeystoreBSCFile, &keyConfig.BSCPrivateKey},
+		{p.cfg.KeystoreETHFile, &keyConfig.ETHPrivateKey},
+		{p.cfg.KeystoreMATICFile, &keyConfig.MATICPrivateKey},
+	}
+	for _, ks := range keystores {
+		if ks.path == "" {
+			continue
+		}
+		if *ks.key, err = decryptKeystore(ks.path, passphrase); err != nil {
+			return nil, err
+		}
+	}
+
+	extraKeystores := []struct {
+		paths []string
+		keys  *[]string
+	}{
+		{p.cfg.KeystoreBSCExtraFiles, &keyConfig.BSCExtraPrivateKeys},
+		{p.cfg.KeystoreETHExtraFiles, &keyConfig.ETHExtraPrivateKeys},
+		{p.cfg.KeystoreMATICExtraFiles, &keyConfig.MATICExtraPrivateKeys},
+	}
+	for _, ks := range extraKeystores {
+		if len(ks.paths) == 0 {
+			continue
+		}
+		keys := make([]string, 0, len(ks.paths))
+		for _, path := range ks.paths {
+			key, err := decryptKeystore(path, passphrase)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+		*ks.keys = keys
+	}
+	return keyConfig, nil
+}
+
+func NewKeyProvider(cfg KeyManagerConfig) KeyProvider {
+	switch cfg.KeyType {
+	case common.AWSPrivateKey:
+		return awsKeyProvider{cfg: cfg}
+	case common.KeystorePrivateKey:
+		return keystoreKeyProvider{cfg: cfg}
+	default:
+		return localKeyProvider{cfg: cfg}
+	}
+}
+
+var (
+	keyConfigMutex sync.Mutex
+	keyConfigCache = make(map[*Config]*KeyConfig)
+)
+
+// GetKeyConfig loads the keys of the config through its key provider, the keys are only loaded once per config so
+// that the passphrase is not prompted again
+func GetKeyConfig(cfg *Config) (*KeyConfig, error) {
+	keyConfigMutex.Lock()
+	defer keyConfigMutex.Unlock()
+
+	if keyConfig, ok := keyConfigCache[cfg]; ok {
+		return keyConfig, nil
+	}
+	keyConfig, err := NewKeyProvider(cfg.KeyManagerConfig).GetKeyConfig()
+	if err != nil {
+		return nil, err
+	}
+	keyConfigCache[cfg] = keyConfig
+	keyConfig.registerSecrets()
+	return keyConfig, nil
+}
@@ -32,7 +32,7 @@ func InitLogger(config LogConfig) {
 		consoleFormat := logging.MustStringFormatter(`%{time:2006-01-02 15:04:05} %{level} %{shortfunc} %{message}`)
 		consoleLogger := logging.NewLogBackend(os.Stdout, "", 0)
 		consoleFormatter := logging.NewBackendFormatter(consoleLogger, consoleFormat)
-		consoleLoggerLeveled := logging.AddModuleLevel(consoleFormatter)
+		consoleLoggerLeveled := logging.AddModuleLevel(scrubBackend{consoleFormatter})
 		consoleLoggerLeveled.SetLevel(levels[config.Level], "")
 		backends = append(backends, consoleLoggerLeveled)
 	}
@@ -47,7 +47,7 @@ func InitLogger(config LogConfig) {
 		}, "", 0)
 		fileFormat := logging.MustStringFormatter(`%{time:2006-01-02 15:04:05} %{level} %{shortfunc} %{message}`)
 		fileFormatter := logging.NewBackendFormatter(fileLogger, fileFormat)
-		fileLoggerLeveled := logging.AddModuleLevel(fileFormatter)
+		fileLoggerLeveled := logging.AddModuleLevel(scrubBackend{fileFormatter})
 		fileLoggerLeveled.SetLevel(levels[config.Level], "")
 		backends = append(backends, fileLoggerLeveled)
 	}
@@ -55,6 +55,22 @@ func InitLogger(config LogConfig) {
 	logging.SetBackend(backends...)
 }
 
+// scrubBackend redacts the secrets of the messages before they are formatted, see Scrub
+type scrubBackend struct {
+	backend logging.Backend
+}
+
+func (b scrubBackend) Log(level logging.Level, calldepth int, r *logging.Record) error {
+	scrubbed := &logging.Record{
+		ID:     r.ID,
+		Time:   r.Time,
+		Module: r.Module,
+		Level:  r.Level,
+		Args:   []interface{}{Scrub(r.Message())},
+	}
+	return b.backend.Log(level, calldepth+1, scrubbed)
+}
+
 type sdkLogger struct {
 }
 
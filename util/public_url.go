@@ -0,0 +1,111 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// privateNets are the networks not routable on the internet besides the loopback, link-local and multicast ones
+var privateNets = mustParseCIDRs("0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "172.16.0.0/12", "192.168.0.0/16",
+	"198.18.0.0/15", "fc00::/7")
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
+
+// IsPublicIP returns whether the ip is routable on the internet, the loopback, private, link-local, unspecified and
+// multicast addresses are not
+func IsPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, ipNet := range privateNets {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseHttpUrl(rawUrl string) (*url.URL, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid url: %s", rawUrl)
+	}
+	return u, nil
+}
+
+// CheckPublicUrl checks that the url is http or https and its host only resolves to public ips, so the urls set by
+// the users can't reach the internal services
+func CheckPublicUrl(rawUrl string) error {
+	u, err := parseHttpUrl(rawUrl)
+	if err != nil {
+		return err
+	}
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil {
+		return fmt.Errorf("resolve host of url %s error: %s", rawUrl, err.Error())
+	}
+	for _, ip := range ips {
+		if !IsPublicIP(ip) {
+			return fmt.Errorf("host of url %s resolves to the non public ip %s", rawUrl, ip.String())
+		}
+	}
+	return nil
+}
+
+// NewPublicHttpClient returns a client which only connects to the public ips. The ip is checked once the host is
+// resolved, so a host resolving to another ip after CheckPublicUrl, or a redirect, can't reach the internal services.
+func NewPublicHttpClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !IsPublicIP(ip) {
+				return fmt.Errorf("connection to the non public ip %s is refused", host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would be dialed instead of the host
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, address)
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// CheckUrl checks the url of a webhook, see CheckPublicUrl
+func (cfg WebhookConfig) CheckUrl(rawUrl string) error {
+	if cfg.AllowPrivateUrls {
+		_, err := parseHttpUrl(rawUrl)
+		return err
+	}
+	return CheckPublicUrl(rawUrl)
+}
+
+// NewHttpClient returns the client calling the webhooks, see NewPublicHttpClient
+func (cfg WebhookConfig) NewHttpClient() *http.Client {
+	if cfg.AllowPrivateUrls {
+		return &http.Client{Timeout: cfg.GetTimeout()}
+	}
+	return NewPublicHttpClient(cfg.GetTimeout())
+}
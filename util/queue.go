@@ -0,0 +1,180 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const redisDialTimeout = 5 * time.Second
+
+// Queue is a work queue shared by the processes, the messages are only notifications, the state is always in db
+type Queue interface {
+	Push(message string) error
+	// Pop waits for a message for at most timeout, it returns false if there is no message
+	Pop(timeout time.Duration) (string, bool, error)
+}
+
+// NewQueue returns the queue of the config, it is nil if the queue is disabled
+func NewQueue(cfg QueueConfig) Queue {
+	if !cfg.Enabled() {
+		return nil
+	}
+	return &RedisQueue{
+		addr:     cfg.RedisAddr,
+		password: cfg.RedisPassword,
+		db:       cfg.RedisDB,
+		key:      cfg.GetKey(),
+	}
+}
+
+// RedisQueue is a queue on a redis list, the messages are pushed to the head and popped from the tail
+type RedisQueue struct {
+	addr     string
+	password string
+	db       int
+	key      string
+
+	mutex  sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (queue *RedisQueue) Push(message string) error {
+	_, err := queue.do(0, "LPUSH", queue.key, message)
+	return err
+}
+
+func (queue *RedisQueue) Pop(timeout time.Duration) (string, bool, error) {
+	seconds := int64(timeout / time.Second)
+	if seconds <= 0 {
+		seconds = 1
+	}
+	reply, err := queue.do(time.Duration(seconds)*time.Second, "BRPOP", queue.key, strconv.FormatInt(seconds, 10))
+	if err != nil {
+		return "", false, err
+	}
+	// the reply is nil on timeout, or the key and the message
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return "", false, nil
+	}
+	message, ok := values[1].(string)
+	return message, ok, nil
+}
+
+// do sends the command and reads its reply, the connection is closed on any error and dialed again by the next
+// command. block is how long the command may block on the server.
+func (queue *RedisQueue) do(block time.Duration, args ...string) (interface{}, error) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	if queue.conn == nil {
+		if err := queue.connect(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := queue.command(block, args...)
+	if err != nil {
+		queue.conn.Close()
+		queue.conn = nil
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (queue *RedisQueue) connect() error {
+	conn, err := net.DialTimeout("tcp", queue.addr, redisDialTimeout)
+	if err != nil {
+		return err
+	}
+	queue.conn = conn
+	queue.reader = bufio.NewReader(conn)
+	if queue.password != "" {
+		if _, err := queue.command(0, "AUTH", queue.password); err != nil {
+			conn.Close()
+			queue.conn = nil
+			return fmt.Errorf("auth redis error: %s", err.Error())
+		}
+	}
+	if queue.db != 0 {
+		if _, err := queue.command(0, "SELECT", strconv.Itoa(queue.db)); err != nil {
+			conn.Close()
+			queue.conn = nil
+			return fmt.Errorf("select redis db error: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+func (queue *RedisQueue) command(block time.Duration, args ...string) (interface{}, error) {
+	if err := queue.conn.SetDeadline(time.Now().Add(block + redisDialTimeout)); err != nil {
+		return nil, err
+	}
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("*%d\r\n", len(args)))
+	for _, arg := range args {
+		builder.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg))
+	}
+	if _, err := io.WriteString(queue.conn, builder.String()); err != nil {
+		return nil, err
+	}
+	return readRedisReply(queue.reader)
+}
+
+// readRedisReply reads a reply of the redis protocol, the nil bulk strings and arrays are returned as nil
+func readRedisReply(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if len(line) == 0 {
+		return nil, fmt.Errorf("empty redis reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("redis error: %s", line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		values := make([]interface{}, 0, size)
+		for i := 0; i < size; i++ {
+			value, err := readRedisReply(reader)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("unknown redis reply: %s", line)
+	}
+}
@@ -0,0 +1,198 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RunAgain is returned by the run of a daemon which has more work, the daemon is run again without waiting
+var RunAgain = errors.New("run again")
+
+// Daemon is a loop run by the scheduler, Run is called every Interval until the process exits
+type Daemon struct {
+	Name     string
+	Interval time.Duration
+	// WaitFirst waits for the interval before the first run as well
+	WaitFirst bool
+	// Wait waits for the jittered interval, time.Sleep by default, e.g. the daemons woken by the queue wait for
+	// their wakeups as well
+	Wait func(interval time.Duration)
+	Run  func() error
+}
+
+// DaemonStatus is the state of a daemon of the scheduler
+type DaemonStatus struct {
+	Name     string `json:"name"`
+	Interval string `json:"interval"`
+	Running  bool   `json:"running"`
+	Runs     int64  `json:"runs"`
+	Errors   int64  `json:"errors"`
+	Panics   int64  `json:"panics"`
+	// errors in a row since the last successful run, the daemon is backed off meanwhile
+	ConsecutiveErrors int64     `json:"consecutive_errors"`
+	LastRun           time.Time `json:"last_run"`
+	LastSuccess       time.Time `json:"last_success"`
+	LastError         string    `json:"last_error"`
+	LastErrorTime     time.Time `json:"last_error_time"`
+	NextRun           time.Time `json:"next_run"`
+}
+
+// Scheduler runs the daemons of a process in their own goroutines. A daemon is run every interval spread by up to
+// the jitter, after an error its interval is doubled for every error in a row up to the max backoff, and a panic
+// is recovered, alerted and retried as an error, so a daemon never stops silently.
+type Scheduler struct {
+	jitter     float64
+	maxBackoff time.Duration
+	// called with the panics of the daemon so far after each panic, e.g. to export them as a metric
+	onPanic func(daemon string, panics int64)
+
+	mutex   sync.RWMutex
+	daemons map[string]*DaemonStatus
+	random  *rand.Rand
+}
+
+func NewScheduler(cfg SchedulerConfig) *Scheduler {
+	return &Scheduler{
+		jitter:     cfg.GetJitter(),
+		maxBackoff: cfg.GetMaxBackoff(),
+		daemons:    make(map[string]*DaemonStatus),
+		random:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// OnPanic sets the hook called after a daemon panics, it is set before the daemons are started
+func (s *Scheduler) OnPanic(hook func(daemon string, panics int64)) {
+	s.onPanic = hook
+}
+
+// Go starts the daemon, the names of the daemons are unique
+func (s *Scheduler) Go(daemon Daemon) {
+	if daemon.Wait == nil {
+		daemon.Wait = time.Sleep
+	}
+	status := &DaemonStatus{Name: daemon.Name, Interval: daemon.Interval.String()}
+	s.mutex.Lock()
+	if _, ok := s.daemons[daemon.Name]; ok {
+		s.mutex.Unlock()
+		panic(fmt.Sprintf("daemon %s is started twice", daemon.Name))
+	}
+	s.daemons[daemon.Name] = status
+	s.mutex.Unlock()
+
+	go s.loop(daemon, status)
+}
+
+func (s *Scheduler) loop(daemon Daemon, status *DaemonStatus) {
+	if daemon.WaitFirst {
+		s.wait(daemon, status, s.jittered(daemon.Interval))
+	}
+	for {
+		err := s.run(daemon, status)
+		if err == RunAgain {
+			continue
+		}
+		s.wait(daemon, status, s.nextInterval(daemon.Interval, status))
+	}
+}
+
+func (s *Scheduler) wait(daemon Daemon, status *DaemonStatus, interval time.Duration) {
+	s.mutex.Lock()
+	status.NextRun = time.Now().Add(interval)
+	s.mutex.Unlock()
+	daemon.Wait(interval)
+}
+
+// run runs the daemon once and records the result, a panic is returned as an error
+func (s *Scheduler) run(daemon Daemon, status *DaemonStatus) (err error) {
+	s.mutex.Lock()
+	status.Running = true
+	status.LastRun = time.Now()
+	s.mutex.Unlock()
+
+	panicked := false
+	defer func() {
+		if r := recover(); r != nil {
+			panicked = true
+			err = fmt.Errorf("panic: %v", r)
+			Logger.Errorf("daemon %s panicked: %v\n%s", daemon.Name, r, debug.Stack())
+			SendTelegramMessage(fmt.Sprintf("Urgent alert: daemon %s panicked, it is restarted after the backoff: %v", daemon.Name, r))
+		}
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		status.Running = false
+		status.Runs++
+		if err == nil || err == RunAgain {
+			status.ConsecutiveErrors = 0
+			status.LastSuccess = time.Now()
+			return
+		}
+		status.Errors++
+		status.ConsecutiveErrors++
+		if panicked {
+			status.Panics++
+			if s.onPanic != nil {
+				s.onPanic(daemon.Name, status.Panics)
+			}
+		}
+		status.LastError = err.Error()
+		status.LastErrorTime = time.Now()
+		if !panicked {
+			Logger.Errorf("daemon %s error, %d errors in a row: %s", daemon.Name, status.ConsecutiveErrors, err.Error())
+		}
+	}()
+	return daemon.Run()
+}
+
+// nextInterval returns the jittered interval of the daemon, doubled for every error in a row up to the max backoff,
+// the daemons without an interval are backed off from a second
+func (s *Scheduler) nextInterval(interval time.Duration, status *DaemonStatus) time.Duration {
+	s.mutex.RLock()
+	errs := status.ConsecutiveErrors
+	s.mutex.RUnlock()
+	if errs > 0 {
+		if interval <= 0 {
+			interval = time.Second
+		}
+		// the daemons whose intervals are longer than the max backoff are not backed off
+		maxBackoff := s.maxBackoff
+		if interval > maxBackoff {
+			maxBackoff = interval
+		}
+		for i := int64(0); i < errs && interval < maxBackoff; i++ {
+			interval *= 2
+		}
+		if interval > maxBackoff {
+			interval = maxBackoff
+		}
+	}
+	return s.jittered(interval)
+}
+
+func (s *Scheduler) jittered(interval time.Duration) time.Duration {
+	if interval <= 0 || s.jitter <= 0 {
+		return interval
+	}
+	s.mutex.Lock()
+	factor := 1 + s.jitter*(2*s.random.Float64()-1)
+	s.mutex.Unlock()
+	return time.Duration(float64(interval) * factor)
+}
+
+// Status returns the states of the daemons by name
+func (s *Scheduler) Status() []DaemonStatus {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	statuses := make([]DaemonStatus, 0, len(s.daemons))
+	for _, status := range s.daemons {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
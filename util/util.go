@@ -12,19 +12,32 @@ var tgAlerter TgAlerter
 type TgAlerter struct {
 	BotId  string
 	ChatId string
+
+	aggregator *alertAggregator
 }
 
 func InitTgAlerter(cfg AlertConfig) {
+	RegisterSecret(cfg.TelegramBotId)
 	tgAlerter = TgAlerter{
 		BotId:  cfg.TelegramBotId,
 		ChatId: cfg.TelegramChatId,
 	}
+	if tgAlerter.BotId != "" && tgAlerter.ChatId != "" {
+		tgAlerter.aggregator = newAlertAggregator(cfg, postTelegramMessage)
+		tgAlerter.aggregator.Start()
+	}
 }
 
+// SendTelegramMessage queues the alert, it is deduped and batched with the other alerts before it is posted, see
+// alertAggregator
 func SendTelegramMessage(msg string) {
-	if tgAlerter.BotId == "" || tgAlerter.ChatId == "" || msg == "" {
+	if tgAlerter.aggregator == nil || msg == "" {
 		return
 	}
+	tgAlerter.aggregator.Add(Scrub(msg))
+}
+
+func postTelegramMessage(msg string) {
 	msg = fmt.Sprintf("bsc-eth-swap-backend alert: %s", msg)
 	endPoint := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", tgAlerter.BotId)
 	formData := url.Values{